@@ -3,16 +3,24 @@ module github.com/manishmeganathan/peerchat
 go 1.16
 
 require (
+	github.com/btcsuite/btcd/btcec/v2 v2.2.1
+	github.com/eclipse/paho.mqtt.golang v1.3.5
 	github.com/gdamore/tcell/v2 v2.3.3
+	github.com/gorilla/websocket v1.4.2
 	github.com/ipfs/go-cid v0.0.7
+	github.com/ipfs/go-datastore v0.4.5
 	github.com/libp2p/go-libp2p v0.14.2
+	github.com/libp2p/go-libp2p-circuit v0.4.0
 	github.com/libp2p/go-libp2p-connmgr v0.2.4
 	github.com/libp2p/go-libp2p-core v0.8.5
 	github.com/libp2p/go-libp2p-discovery v0.5.0
 	github.com/libp2p/go-libp2p-host v0.1.0
 	github.com/libp2p/go-libp2p-kad-dht v0.12.1
+	github.com/libp2p/go-libp2p-kbucket v0.4.7
+	github.com/libp2p/go-libp2p-peerstore v0.2.7
 	github.com/libp2p/go-libp2p-pubsub v0.4.1
 	github.com/libp2p/go-libp2p-tls v0.1.3
+	github.com/libp2p/go-libp2p-transport-upgrader v0.4.2
 	github.com/libp2p/go-libp2p-yamux v0.5.4
 	github.com/libp2p/go-tcp-transport v0.2.1
 	github.com/mr-tron/base58 v1.2.0
@@ -20,4 +28,9 @@ require (
 	github.com/multiformats/go-multihash v0.0.15
 	github.com/rivo/tview v0.0.0-20210608105643-d4fb0348227b
 	github.com/sirupsen/logrus v1.2.0
+	github.com/yuin/gopher-lua v0.0.0-20210529063254-f4c35e4016d9
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
+	golang.org/x/term v0.0.0-20210220032956-6a3ed077a48d
+	google.golang.org/grpc v1.33.2
+	gopkg.in/yaml.v2 v2.3.0
 )
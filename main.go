@@ -1,9 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 
 	"github.com/manishmeganathan/peerchat/src"
@@ -23,29 +28,188 @@ W E L C O M E  T O
 dP     
 `
 
+// A structure that represents a subcommand of the application
+type subcommand struct {
+	// Represents a short description of the subcommand
+	help string
+	// Represents the function that runs the subcommand with its arguments
+	run func(args []string)
+}
+
+// Represents the subcommands of the application
+var subcommands = map[string]subcommand{
+	"chat":     {help: "join a chat room with the terminal UI (default)", run: chatcommand},
+	"daemon":   {help: "run the node without a UI, serving the control API, REST API and web UI", run: daemoncommand},
+	"relay":    {help: "run a relay node for peers that cannot be reached directly", run: relaycommand},
+	"keygen":   {help: "generate the identity key of a profile", run: keygencommand},
+	"key":      {help: "export or import the identity key of a profile to a password-encrypted file", run: keycommand},
+	"bots":     {help: "run the plugins, scripts, webhooks and bridges without a UI", run: botscommand},
+	"version":  {help: "print the version", run: versioncommand},
+	"export":   {help: "export the history of a room from a running daemon", run: exportcommand},
+	"import":   {help: "import transcripts or exported history into the history of the rooms", run: importcommand},
+	"bench":    {help: "flood a room on an in-memory network and report latency and drops", run: benchcommand},
+	"simulate": {help: "run many nodes in the process on an in-memory network to test them", run: simulatecommand},
+}
+
 func init() {
 	// Log as Text with color
-	logrus.SetFormatter(&logrus.TextFormatter{
-		ForceColors:     true,
-		FullTimestamp:   true,
-		TimestampFormat: time.RFC822,
-	})
+	setformatter(true)
 
 	// Log to stdout
 	logrus.SetOutput(os.Stdout)
 }
 
 func main() {
-	// Define input flags
-	username := flag.String("user", "", "username to use in the chatroom.")
-	chatroom := flag.String("room", "", "chatroom to join.")
-	loglevel := flag.String("log", "", "level of logs to print.")
-	discovery := flag.String("discover", "", "method to use for discovery.")
-	// Parse input flags
-	flag.Parse()
+	// Determine the subcommand, defaulting to chat when only flags are given
+	name, args := "chat", os.Args[1:]
+	if len(args) > 0 && args[0] != "" && args[0][0] != '-' {
+		name, args = args[0], args[1:]
+	}
+
+	if name == "help" {
+		usage()
+		return
+	}
+
+	// Print the version for the -version flag of any command
+	for _, arg := range args {
+		if arg == "-version" || arg == "--version" {
+			versioncommand(nil)
+			return
+		}
+	}
+
+	command, ok := subcommands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command '%s'\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	command.run(args)
+}
+
+// A function that prints the usage of the application with its subcommands
+func usage() {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "Usage: peerchat [command] [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Commands:")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", name, subcommands[name].help)
+	}
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "Run 'peerchat <command> -h' for the flags of a command.")
+}
+
+// A structure that represents the flags shared by all the subcommands
+type commonflags struct {
+	// Represents the profile to use
+	profile *string
+	// Represents the level of logs to print
+	loglevel *string
+	// Represents the format of the logs (text or json)
+	logformat *string
+	// Represents the file to write logs to, its maximum
+	// size in megabytes and the number of rotated files
+	logfile    *string
+	logmaxsize *int
+	logbackups *int
+}
+
+// Represents the log file that logs are written to (nil if logs are printed)
+var logfile *src.LogFile
+
+// Represents the format of the logs (text or json)
+var logformat = "text"
+
+// A function that creates the flag set of a subcommand with the shared flags
+func newflagset(name string) (*flag.FlagSet, *commonflags) {
+	flags := flag.NewFlagSet("peerchat "+name, flag.ExitOnError)
+
+	return flags, &commonflags{
+		profile:    flags.String("profile", "", "profile to use, with its own identity, configuration and data (~/.peerchat/profiles/<name>)."),
+		loglevel:   flags.String("log", "", "level of logs to print."),
+		logformat:  flags.String("logformat", "", "format of the logs, text or json (for log aggregators)."),
+		logfile:    flags.String("logfile", "", "file to write logs to instead of printing them."),
+		logmaxsize: flags.Int("logmaxsize", src.DefaultLogMaxSize, "size in megabytes after which the log file is rotated."),
+		logbackups: flags.Int("logbackups", src.DefaultLogBackups, "number of rotated log files to keep."),
+	}
+}
+
+// A type that represents a repeatable flag of chat room names
+type roomsflag []string
+
+// A method of roomsflag that returns the room names separated by commas
+func (rooms *roomsflag) String() string {
+	return strings.Join(*rooms, ",")
+}
+
+// A method of roomsflag that adds the room names of a flag value,
+// which can be a single name or a comma separated list of names
+func (rooms *roomsflag) Set(value string) error {
+	for _, room := range strings.Split(value, ",") {
+		if room = strings.TrimSpace(room); room != "" {
+			*rooms = append(*rooms, room)
+		}
+	}
+
+	return nil
+}
+
+// A function that returns the rooms to join at launch, which are the rooms
+// given with flags, else the rooms of the configuration, else (if restore
+// is set) the rooms of the last session. The first room is opened.
+func launchrooms(flagged roomsflag, config *src.Config, restore bool) []string {
+	switch {
+	case len(flagged) > 0:
+		return flagged
+	case len(config.Rooms) > 0:
+		return config.Rooms
+	case restore:
+		return src.LoadSession()
+	default:
+		return nil
+	}
+}
+
+// A function that parses the flags of a subcommand, selects the profile,
+// loads its configuration and fills the flags that were not set with the
+// values of the configuration. Returns the configuration.
+func setup(flags *flag.FlagSet, common *commonflags, args []string) *src.Config {
+	flags.Parse(args)
+
+	// Select the profile before its configuration is loaded
+	if err := src.SetProfile(*common.profile); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Select the Profile!")
+	}
+
+	// Load the configuration file
+	config, err := src.LoadConfig()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Load the Configuration!")
+	}
+
+	// Fill the flags that were not set with the values of the configuration
+	setflags := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { setflags[f.Name] = true })
+	for name, value := range config.Flags() {
+		if !setflags[name] && value != "" && flags.Lookup(name) != nil {
+			flags.Set(name, value)
+		}
+	}
 
 	// Set the log level
-	switch *loglevel {
+	switch *common.loglevel {
 	case "panic", "PANIC":
 		logrus.SetLevel(logrus.PanicLevel)
 	case "fatal", "FATAL":
@@ -64,36 +228,255 @@ func main() {
 		logrus.SetLevel(logrus.InfoLevel)
 	}
 
-	// Display the welcome figlet
-	fmt.Println(figlet)
-	fmt.Println("The PeerChat Application is starting.")
-	fmt.Println("This may take upto 30 seconds.")
-	fmt.Println()
+	// Set the log format
+	switch *common.logformat {
+	case "", "text", "TEXT":
+		logformat = "text"
+	case "json", "JSON":
+		logformat = "json"
+	default:
+		logrus.WithFields(logrus.Fields{
+			"format": *common.logformat,
+		}).Fatalln("Invalid Log Format!")
+	}
+	setformatter(true)
+
+	// Write the logs to the log file if one is given
+	if *common.logfile != "" {
+		logtofile(*common.logfile, *common.logmaxsize, *common.logbackups)
+	}
+
+	return config
+}
+
+// A function that writes the logs to a log file that is rotated
+// when it grows past maxsize megabytes, instead of printing them
+func logtofile(path string, maxsize, backups int) {
+	file, err := src.OpenLogFile(path, maxsize, backups)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  path,
+		}).Fatalln("Failed to Open the Log File!")
+	}
+
+	// Log without color
+	setformatter(false)
+	logrus.SetOutput(file)
+	logfile = file
+}
+
+// A function that sets the formatter of the logs for the log format. Text
+// logs are colored if color is set, while JSON logs carry their fields
+// (such as peer IDs, room names and message IDs) as JSON keys.
+func setformatter(color bool) {
+	if logformat == "json" {
+		logrus.SetFormatter(&logrus.JSONFormatter{
+			TimestampFormat: time.RFC3339,
+		})
+		return
+	}
+
+	logrus.SetFormatter(&logrus.TextFormatter{
+		ForceColors:     color,
+		DisableColors:   !color,
+		FullTimestamp:   true,
+		TimestampFormat: time.RFC822,
+	})
+}
+
+// A structure that represents the flags of the subcommands that run a node
+type nodeflags struct {
+	// Represents the method to use for discovery
+	discovery *string
+	// Represents the SOCKS5 proxy to dial peers through
+	proxy *string
+	// Represents whether peers are reached over Tor
+	tor *bool
+	// Represents the network to join
+	network *string
+	// Represents whether the topics of the rooms are hashed
+	hashtopics *bool
+	// Represents the PubSub router of the rooms
+	router *string
+	// Represents the parameters of the GossipSub router (advanced options)
+	gossipheartbeat *time.Duration
+	gossipd         *int
+	gossipdlo       *int
+	gossipdhi       *int
+	gossiphistory   *int
+	gossipfanoutttl *time.Duration
+	// Represents the trace of the PubSub events
+	pubsubtrace *string
+	// Represents the limits on the resources that peers can make the host use
+	maxconns       *int
+	maxstreams     *int
+	maxpeerstreams *int
+	maxmemory      *int
+	// Represents the file the statistics of the session are appended to on exit
+	stats *string
+	// Represents the faults to inject into the connections (developer options)
+	chaoslatency    *time.Duration
+	chaosjitter     *time.Duration
+	chaosdrop       *float64
+	chaosdisconnect *time.Duration
+}
+
+// A function that defines the flags of a subcommand that runs a node
+func newnodeflags(flags *flag.FlagSet) *nodeflags {
+	limits := src.DefaultResourceLimits()
+
+	return &nodeflags{
+		discovery:  flags.String("discover", "", "method to use for discovery (advertise, announce or tracker)."),
+		proxy:      flags.String("proxy", "", "SOCKS5 proxy to dial peers through (socks5://[user:pass@]host:port)."),
+		tor:        flags.Bool("tor", false, "listen on an onion service and dial peers through Tor."),
+		network:    flags.String("network", "", "network to join, isolating discovery and rooms from other networks (global if empty)."),
+		hashtopics: flags.Bool("hashtopics", false, "derive the topics of the rooms from an HMAC of their names, hiding the names from the network."),
+		router:     flags.String("router", "", "PubSub router of the rooms (gossipsub, floodsub or randomsub, default gossipsub)."),
+
+		gossipheartbeat: flags.Duration("gossipheartbeat", 0, "advanced option: interval between the gossipsub heartbeats (default 1s)."),
+		gossipd:         flags.Int("gossipd", 0, "advanced option: number of peers in the gossipsub mesh of a room (default 6)."),
+		gossipdlo:       flags.Int("gossipdlo", 0, "advanced option: number of peers below which the gossipsub mesh is grown (default 5)."),
+		gossipdhi:       flags.Int("gossipdhi", 0, "advanced option: number of peers above which the gossipsub mesh is pruned (default 12)."),
+		gossiphistory:   flags.Int("gossiphistory", 0, "advanced option: number of heartbeats that gossipsub keeps sent messages for (default 5)."),
+		gossipfanoutttl: flags.Duration("gossipfanoutttl", 0, "advanced option: time that gossipsub keeps the fanout of a room it hasn't joined (default 1m)."),
+		pubsubtrace:     flags.String("pubsubtrace", "", "trace the pubsub events to json:<file>, pb:<file> or remote:<multiaddr>."),
+
+		maxconns:       flags.Int("maxconns", limits.Connections, "maximum number of open connections, beyond which inbound connections are refused (0 for no limit)."),
+		maxstreams:     flags.Int("maxstreams", limits.Streams, "maximum number of open streams, beyond which inbound streams are reset (0 for no limit)."),
+		maxpeerstreams: flags.Int("maxpeerstreams", limits.PeerStreams, "maximum number of open streams with a single peer (0 for no limit)."),
+		maxmemory:      flags.Int("maxmemory", limits.Memory, "heap size in megabytes beyond which connections are trimmed (0 for no limit)."),
+
+		stats: flags.String("stats", "", "file to append the statistics of the session to as a JSON line on exit."),
+
+		chaoslatency:    flags.Duration("chaoslatency", 0, "developer option: delay every write on the connections."),
+		chaosjitter:     flags.Duration("chaosjitter", 0, "developer option: add a random delay up to this on top of -chaoslatency."),
+		chaosdrop:       flags.Float64("chaosdrop", 0, "developer option: probability of dropping a room message from a peer."),
+		chaosdisconnect: flags.Duration("chaosdisconnect", 0, "developer option: disconnect a random peer about this often."),
+	}
+}
+
+// A function that creates the P2P host (as a relay if hop is set), dialing
+// through the proxy or Tor if requested, and connects to peers with the
+// chosen discovery method
+func startnode(config *src.Config, node *nodeflags, hop bool) *src.P2P {
+	// Dial peers through the proxy or Tor of the flags (or the configuration)
+	err := config.SetProxy(*node.proxy)
+	if err == nil {
+		err = config.SetTor(*node.tor)
+	}
+	if err == nil {
+		err = config.SetNetwork(*node.network)
+	}
+	config.SetHashTopics(*node.hashtopics)
+	if err == nil {
+		err = config.SetRouter(*node.router)
+	}
+	if err == nil {
+		err = src.SetGossipParams(src.GossipParams{
+			Heartbeat: *node.gossipheartbeat,
+			D:         *node.gossipd,
+			Dlo:       *node.gossipdlo,
+			Dhi:       *node.gossipdhi,
+			History:   *node.gossiphistory,
+			FanoutTTL: *node.gossipfanoutttl,
+		})
+	}
+	if err == nil {
+		err = src.SetPubSubTrace(*node.pubsubtrace)
+	}
+	if err == nil {
+		err = src.SetResourceLimits(src.ResourceLimits{
+			Connections: *node.maxconns,
+			Streams:     *node.maxstreams,
+			PeerStreams: *node.maxpeerstreams,
+			Memory:      *node.maxmemory,
+		})
+	}
+	if err == nil {
+		err = src.SetChaos(src.Chaos{
+			Latency:    *node.chaoslatency,
+			Jitter:     *node.chaosjitter,
+			Drop:       *node.chaosdrop,
+			Disconnect: *node.chaosdisconnect,
+		})
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Set Up the Node!")
+	}
+	src.SetStatsFile(*node.stats)
 
 	// Create a new P2PHost
-	p2phost := src.NewP2P()
+	var p2phost *src.P2P
+	if hop {
+		p2phost = src.NewRelayP2P(context.Background())
+	} else {
+		p2phost = src.NewP2P(context.Background())
+	}
 	logrus.Infoln("Completed P2P Setup")
 
 	// Connect to peers with the chosen discovery method
-	switch *discovery {
+	switch *node.discovery {
 	case "announce":
 		p2phost.AnnounceConnect()
 	case "advertise":
 		p2phost.AdvertiseConnect()
+	case "tracker":
+		p2phost.TrackerConnect()
 	default:
 		p2phost.AdvertiseConnect()
 	}
 	logrus.Infoln("Connected to Service Peers")
 
-	// Join the chat room
-	chatapp, _ := src.JoinChatRoom(p2phost, *username, *chatroom)
-	logrus.Infof("Joined the '%s' chatroom as '%s'", chatapp.RoomName, chatapp.UserName)
+	return p2phost
+}
+
+// A function that starts the incoming webhook endpoint on an address if one is given
+func startinhook(p2phost *src.P2P, addr, token string) {
+	if addr == "" {
+		return
+	}
+
+	if err := src.ServeIncomingWebhooks(p2phost, addr, token); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Start the Incoming Webhook Endpoint!")
+	}
+	logrus.Infof("Serving Incoming Webhooks on %s", addr)
+}
 
-	// Wait for network setup to complete
-	time.Sleep(time.Second * 5)
+// A function that blocks until the process is interrupted or terminated
+func waitforsignal() {
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	<-sigchan
+}
+
+// A function that stops the plugins and closes
+// the P2P host before the application exits
+func shutdown(p2phost *src.P2P) {
+	// Stop the plugin subprocesses and the bridges
+	src.StopPlugins()
+	src.StopBridges()
+
+	// Close the P2P host
+	if err := p2phost.Close(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Failed to Close the P2P Host!")
+	}
+
+	// Summarize the session, on stderr to keep the JSON lines of the headless interface clean
+	summary := src.SessionSummary()
+	fmt.Fprint(os.Stderr, summary)
+	if err := src.SaveSessionStats(summary); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Failed to Save the Session Statistics!")
+	}
 
-	// Create the Chat UI
-	ui := src.NewUI(chatapp)
-	// Start the UI system
-	ui.Run()
+	logrus.Infoln("PeerChat has exited.")
 }
@@ -0,0 +1,233 @@
+/*
+Package peerchattest builds chat rooms over an in-memory libp2p network
+(mocknet), so that features which involve several peers can be tested
+deterministically in the same process without internet access.
+
+	network := peerchattest.New(t, 3)
+	rooms := network.Join("lobby")
+
+	peerchattest.Send(t, rooms[0], "hello")
+	peerchattest.Expect(t, rooms[1], "hello")
+
+The data of the nodes (address book, room settings, history) is written
+to a temporary home directory that is shared by the whole test binary,
+since the stores of the application are global to the process.
+*/
+package peerchattest
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/manishmeganathan/peerchat/src"
+)
+
+// Represents the time allowed for the rooms to form their mesh and for messages to arrive
+var Timeout = 10 * time.Second
+
+// Represents the guard that moves the home directory once per test binary
+var isolate sync.Once
+
+// A structure that represents an in-memory network of peerchat nodes
+type Network struct {
+	// Represents the test that uses the network
+	t testing.TB
+	// Represents the context of the network
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	// Represents the in-memory libp2p network of the nodes
+	Mocknet mocknet.Mocknet
+	// Represents the nodes of the network, in the order they were created
+	Nodes []*src.P2P
+}
+
+// A constructor function that creates a network of n nodes that are all
+// linked and connected to each other. The network is shut down when the test
+// ends, along with the rooms that were joined on it.
+func New(t testing.TB, n int) *Network {
+	t.Helper()
+	isolate.Do(isolatehome)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	network := &Network{t: t, ctx: ctx, cancel: cancel, Mocknet: mocknet.New(ctx)}
+	t.Cleanup(network.close)
+
+	for index := 0; index < n; index++ {
+		nodehost, err := network.Mocknet.GenPeer()
+		if err != nil {
+			t.Fatalf("peerchattest: failed to create node %d: %s", index, err)
+		}
+
+		node, err := src.NewHostP2P(ctx, nodehost)
+		if err != nil {
+			t.Fatalf("peerchattest: failed to create node %d: %s", index, err)
+		}
+		network.Nodes = append(network.Nodes, node)
+	}
+
+	if err := network.Mocknet.LinkAll(); err != nil {
+		t.Fatalf("peerchattest: failed to link the nodes: %s", err)
+	}
+	if err := network.Mocknet.ConnectAllButSelf(); err != nil {
+		t.Fatalf("peerchattest: failed to connect the nodes: %s", err)
+	}
+
+	return network
+}
+
+// A method of Network that joins every node to a room, with the user
+// names user0, user1 and so on, and waits until every node sees the
+// others in the room. Returns the chat rooms in the order of the nodes.
+func (network *Network) Join(room string) []*src.ChatRoom {
+	network.t.Helper()
+
+	chatrooms := make([]*src.ChatRoom, len(network.Nodes))
+	for index, node := range network.Nodes {
+		chatroom, err := src.JoinChatRoom(network.ctx, node, fmt.Sprintf("user%d", index), room)
+		if err != nil {
+			network.t.Fatalf("peerchattest: node %d failed to join '%s': %s", index, room, err)
+		}
+
+		chatrooms[index] = chatroom
+		network.t.Cleanup(chatroom.Exit)
+	}
+
+	network.WaitMesh(chatrooms...)
+	return chatrooms
+}
+
+// A method of Network that waits until each chat room sees all the
+// other chat rooms as peers of the room, failing the test on timeout
+func (network *Network) WaitMesh(chatrooms ...*src.ChatRoom) {
+	network.t.Helper()
+
+	deadline := time.Now().Add(Timeout)
+	for _, chatroom := range chatrooms {
+		for len(chatroom.PeerList()) < len(chatrooms)-1 {
+			if time.Now().After(deadline) {
+				network.t.Fatalf("peerchattest: '%s' of %s sees %d of %d peers", chatroom.RoomName, chatroom.UserName, len(chatroom.PeerList()), len(chatrooms)-1)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+}
+
+// A method of Network that cuts the links between two nodes, so that they
+// can only reach each other through the other nodes (if at all)
+func (network *Network) Disconnect(a, b int) {
+	network.t.Helper()
+
+	first, second := network.Nodes[a].Host.ID(), network.Nodes[b].Host.ID()
+	if err := network.Mocknet.UnlinkPeers(first, second); err != nil {
+		network.t.Fatalf("peerchattest: failed to unlink nodes %d and %d: %s", a, b, err)
+	}
+	if err := network.Mocknet.DisconnectPeers(first, second); err != nil {
+		network.t.Fatalf("peerchattest: failed to disconnect nodes %d and %d: %s", a, b, err)
+	}
+}
+
+// A method of Network that links and connects two nodes again
+func (network *Network) Connect(a, b int) {
+	network.t.Helper()
+
+	first, second := network.Nodes[a].Host.ID(), network.Nodes[b].Host.ID()
+	if _, err := network.Mocknet.LinkPeers(first, second); err != nil {
+		network.t.Fatalf("peerchattest: failed to link nodes %d and %d: %s", a, b, err)
+	}
+	if _, err := network.Mocknet.ConnectPeers(first, second); err != nil {
+		network.t.Fatalf("peerchattest: failed to connect nodes %d and %d: %s", a, b, err)
+	}
+}
+
+// A method of Network that shuts down the nodes and the in-memory network
+func (network *Network) close() {
+	for _, node := range network.Nodes {
+		node.Close()
+	}
+
+	network.cancel()
+	network.Mocknet.Close()
+}
+
+// A function that sends a message to a chat room as its user
+func Send(t testing.TB, chatroom *src.ChatRoom, message string) {
+	t.Helper()
+
+	select {
+	case chatroom.Outbound <- message:
+	case <-time.After(Timeout):
+		t.Fatalf("peerchattest: timed out sending to '%s' as %s", chatroom.RoomName, chatroom.UserName)
+	}
+}
+
+// A function that reads the messages of a chat room until one with the given
+// text arrives and returns it, failing the test if it does not arrive in time.
+// The other messages and the logs of the chat room are discarded.
+func Expect(t testing.TB, chatroom *src.ChatRoom, message string) src.ChatMessage {
+	t.Helper()
+
+	received, ok := Next(chatroom, func(msg src.ChatMessage) bool { return msg.Message == message })
+	if !ok {
+		t.Fatalf("peerchattest: %s did not receive '%s' in '%s'", chatroom.UserName, message, chatroom.RoomName)
+	}
+
+	return received
+}
+
+// A function that asserts that no message with the given text arrives
+// in a chat room before the timeout, such as a message that moderation
+// is expected to drop. Other messages and the logs are discarded.
+func Refute(t testing.TB, chatroom *src.ChatRoom, message string, timeout time.Duration) {
+	t.Helper()
+
+	if _, ok := next(chatroom, timeout, func(msg src.ChatMessage) bool { return msg.Message == message }); ok {
+		t.Fatalf("peerchattest: %s unexpectedly received '%s' in '%s'", chatroom.UserName, message, chatroom.RoomName)
+	}
+}
+
+// A function that reads the messages of a chat room until one matches
+// and returns it, or returns false if none matches within the timeout
+func Next(chatroom *src.ChatRoom, match func(src.ChatMessage) bool) (src.ChatMessage, bool) {
+	return next(chatroom, Timeout, match)
+}
+
+// A function that reads the messages of a chat room until
+// one matches, or returns false after the given timeout
+func next(chatroom *src.ChatRoom, timeout time.Duration, match func(src.ChatMessage) bool) (src.ChatMessage, bool) {
+	deadline := time.After(timeout)
+
+	for {
+		select {
+		case msg, ok := <-chatroom.Inbound:
+			if !ok {
+				return src.ChatMessage{}, false
+			}
+			if match(msg) {
+				return msg, true
+			}
+
+		case <-chatroom.Logs:
+			// Discard the logs, which would otherwise block the room
+
+		case <-deadline:
+			return src.ChatMessage{}, false
+		}
+	}
+}
+
+// A function that points the home directory of the process to a temporary
+// directory, so that the nodes do not write into the profile of the user
+func isolatehome() {
+	home, err := os.MkdirTemp("", "peerchattest")
+	if err != nil {
+		panic(fmt.Sprintf("peerchattest: failed to create a home directory: %s", err))
+	}
+
+	os.Setenv("HOME", home)
+}
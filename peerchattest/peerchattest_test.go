@@ -0,0 +1,74 @@
+package peerchattest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/manishmeganathan/peerchat/peerchattest"
+	"github.com/manishmeganathan/peerchat/src"
+)
+
+// Represents the time a message is waited for before it is considered dropped
+const dropped = 2 * time.Second
+
+func TestBroadcast(t *testing.T) {
+	network := peerchattest.New(t, 3)
+	rooms := network.Join("broadcast")
+
+	peerchattest.Send(t, rooms[0], "hello")
+
+	for _, room := range rooms[1:] {
+		msg := peerchattest.Expect(t, room, "hello")
+		if msg.SenderName != "user0" {
+			t.Errorf("%s received 'hello' from %s, want user0", room.UserName, msg.SenderName)
+		}
+		if msg.SenderID != network.Nodes[0].Host.ID().Pretty() {
+			t.Errorf("%s received 'hello' from %s, want the peer ID of node 0", room.UserName, msg.SenderID)
+		}
+	}
+}
+
+func TestDisconnect(t *testing.T) {
+	network := peerchattest.New(t, 3)
+	rooms := network.Join("partition")
+
+	// Cut node 0 off from the others
+	network.Disconnect(0, 1)
+	network.Disconnect(0, 2)
+
+	peerchattest.Send(t, rooms[1], "while away")
+	peerchattest.Expect(t, rooms[2], "while away")
+	peerchattest.Refute(t, rooms[0], "while away", dropped)
+
+	// Messages reach node 0 again once it is reconnected
+	network.Connect(0, 1)
+	network.Connect(0, 2)
+	network.WaitMesh(rooms...)
+
+	peerchattest.Send(t, rooms[1], "welcome back")
+	peerchattest.Expect(t, rooms[0], "welcome back")
+}
+
+func TestReadOnlyRoom(t *testing.T) {
+	network := peerchattest.New(t, 3)
+	rooms := network.Join("announcements")
+
+	// Node 0 becomes the operator of the room and makes it read-only
+	_, err := network.Nodes[0].UpdateRoomSettings("announcements", func(settings *src.RoomSettings) error {
+		settings.ReadOnly = true
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to update the room settings: %s", err)
+	}
+
+	// The messages of other members are rejected by the topic validators
+	peerchattest.Send(t, rooms[1], "spam")
+	peerchattest.Refute(t, rooms[0], "spam", dropped)
+	peerchattest.Refute(t, rooms[2], "spam", dropped)
+
+	// The operator can still post
+	peerchattest.Send(t, rooms[0], "announcement")
+	peerchattest.Expect(t, rooms[1], "announcement")
+	peerchattest.Expect(t, rooms[2], "announcement")
+}
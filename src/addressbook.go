@@ -0,0 +1,257 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time after which a peer that was not seen in a room is forgotten
+const addressbookexpiry = 30 * 24 * time.Hour
+
+// Represents the maximum number of peers redialed when joining a room
+const maxredial = 64
+
+// Represents the maximum number of addresses kept for a peer
+const maxpeeraddrs = 8
+
+// Represents the delays of the attempts to redial the known peers of a room
+var redialdelays = []time.Duration{0, 10 * time.Second, 30 * time.Second}
+
+// A structure that represents a peer seen in a room, with its addresses
+type seenpeer struct {
+	// Represents the peer ID of the peer
+	PeerID string `json:"peerid"`
+	// Represents the addresses of the peer when it was last seen
+	Addrs []string `json:"addrs"`
+	// Represents the time the peer was last seen in the room
+	Seen time.Time `json:"seen"`
+}
+
+// A structure that represents the peers seen in the rooms, which are
+// redialed when a room is joined so that it is not left to discovery
+type addressbookstore struct {
+	// Represents the peers seen in the rooms, indexed by room and peer
+	rooms map[string]map[string]seenpeer
+	// Represents whether the address book has been loaded
+	loaded bool
+	// Represents the lock on the address book
+	mutex sync.Mutex
+}
+
+// Represents the address book of the application
+var addressbook = &addressbookstore{}
+
+// A function that returns the path of the address book
+func addressbookpath() string {
+	return peerchatpath("addressbook.json")
+}
+
+// A method of addressbookstore that loads the address book if it has
+// not been loaded yet. Must be called with the address book lock held.
+func (book *addressbookstore) load() {
+	if book.loaded {
+		return
+	}
+
+	book.loaded = true
+	book.rooms = make(map[string]map[string]seenpeer)
+
+	data, err := ioutil.ReadFile(addressbookpath())
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &book.rooms); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  addressbookpath(),
+		}).Warnln("Failed to Parse the Address Book!")
+		book.rooms = make(map[string]map[string]seenpeer)
+	}
+}
+
+// A method of addressbookstore that saves the address book, forgetting
+// the peers that have not been seen for a long time.
+// Must be called with the address book lock held.
+func (book *addressbookstore) save() error {
+	for room, peers := range book.rooms {
+		for id, seen := range peers {
+			if time.Since(seen.Seen) > addressbookexpiry {
+				delete(peers, id)
+			}
+		}
+		if len(peers) == 0 {
+			delete(book.rooms, room)
+		}
+	}
+
+	data, err := json.MarshalIndent(book.rooms, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(addressbookpath()), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(addressbookpath(), data, 0600)
+}
+
+// A method of addressbookstore that records a peer seen in a room with
+// its addresses. Loopback addresses are not kept, since they only reach
+// the peer from the same machine.
+func (book *addressbookstore) record(room string, peerid peer.ID, addrs []multiaddr.Multiaddr) {
+	seen := seenpeer{PeerID: peerid.Pretty(), Seen: time.Now()}
+	for _, addr := range addrs {
+		if len(seen.Addrs) < maxpeeraddrs && !manet.IsIPLoopback(addr) {
+			seen.Addrs = append(seen.Addrs, addr.String())
+		}
+	}
+
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.load()
+
+	if book.rooms[room] == nil {
+		book.rooms[room] = make(map[string]seenpeer)
+	}
+	book.rooms[room][seen.PeerID] = seen
+
+	if err := book.save(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  addressbookpath(),
+		}).Warnln("Failed to Save the Address Book!")
+	}
+}
+
+// A method of addressbookstore that returns the peers seen in a room, most recently seen first
+func (book *addressbookstore) peers(room string) []seenpeer {
+	book.mutex.Lock()
+	defer book.mutex.Unlock()
+	book.load()
+
+	peers := make([]seenpeer, 0, len(book.rooms[room]))
+	for _, seen := range book.rooms[room] {
+		if time.Since(seen.Seen) <= addressbookexpiry {
+			peers = append(peers, seen)
+		}
+	}
+	sort.Slice(peers, func(i, j int) bool { return peers[i].Seen.After(peers[j].Seen) })
+
+	if len(peers) > maxredial {
+		peers = peers[:maxredial]
+	}
+
+	return peers
+}
+
+// A method of P2P that redials the peers that were seen in a room with
+// their known addresses, a few times in the background, so that the room
+// is joined without waiting for the peers to be discovered in the DHT
+func (p2p *P2P) redialroom(room string) {
+	peers := addressbook.peers(room)
+	if len(peers) == 0 {
+		return
+	}
+
+	for _, seen := range peers {
+		peerid, err := peer.Decode(seen.PeerID)
+		if err != nil || peerid == p2p.Host.ID() {
+			continue
+		}
+
+		var addrs []multiaddr.Multiaddr
+		for _, addr := range seen.Addrs {
+			if decoded, err := multiaddr.NewMultiaddr(addr); err == nil {
+				addrs = append(addrs, decoded)
+			}
+		}
+
+		go p2p.redial(peer.AddrInfo{ID: peerid, Addrs: addrs})
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"room":  room,
+		"peers": len(peers),
+	}).Debugln("Redialing the Known Peers of the Room.")
+}
+
+// A method of P2P that dials a known peer until it is connected or the attempts run out
+func (p2p *P2P) redial(addrinfo peer.AddrInfo) {
+	p2p.Host.Peerstore().AddAddrs(addrinfo.ID, addrinfo.Addrs, pstore.RecentlyConnectedAddrTTL)
+
+	for _, delay := range redialdelays {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		if p2p.Host.Network().Connectedness(addrinfo.ID) == network.Connected {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(p2p.Ctx, dmtimeout)
+		started := time.Now()
+		err := p2p.Host.Connect(ctx, addrinfo)
+		p2p.dials.record(addrinfo.ID, err, time.Since(started))
+		cancel()
+
+		if err == nil {
+			return
+		}
+	}
+}
+
+// A method of ChatRoom that records the peers that join the room in the
+// address book until the room is exited. The connections with the peers
+// of the room are protected from pruning while they are in the room, so
+// that the connection manager only trims the incidental DHT peers.
+func (cr *ChatRoom) recordpeers() {
+	events, err := cr.pstopic.EventHandler()
+	if err != nil {
+		return
+	}
+	defer events.Cancel()
+
+	connmanager := cr.Host.Host.ConnManager()
+	tag := "peerchat-room:" + cr.pstopic.String()
+	protected := make(map[peer.ID]struct{})
+	defer func() {
+		for peerid := range protected {
+			connmanager.Unprotect(peerid, tag)
+		}
+	}()
+
+	for {
+		event, err := events.NextPeerEvent(cr.psctx)
+		if err != nil {
+			return
+		}
+
+		if event.Type != pubsub.PeerJoin {
+			connmanager.Unprotect(event.Peer, tag)
+			delete(protected, event.Peer)
+			continue
+		}
+
+		connmanager.Protect(event.Peer, tag)
+		protected[event.Peer] = struct{}{}
+		addressbook.record(cr.RoomName, event.Peer, cr.Host.Host.Peerstore().Addrs(event.Peer))
+	}
+}
@@ -0,0 +1,164 @@
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Represents the name of the application in the agent versions of its peers
+const agentname = "peerchat"
+
+// Represents the features of the application that depend on a capability,
+// which are missing when chatting with peers that do not advertise it
+var features = map[string]string{
+	"chunks":        "long messages",
+	versionedtopics: "versioned room topics",
+}
+
+// A structure that represents the agent version advertised by a peer with Identify
+type peeragent struct {
+	// Represents the raw agent version
+	raw string
+	// Represents whether the peer runs peerchat
+	peerchat bool
+	// Represents the version of peerchat the peer runs
+	version string
+	// Represents the chat protocol version of the peer (0 if not advertised)
+	protocol int
+	// Represents the capabilities of the peer
+	capabilities []string
+}
+
+// A function that returns the agent version advertised with Identify, which
+// carries the protocol version and capabilities of the application so that
+// peers can tell what it supports before a capability handshake, e.g.
+//
+//	peerchat/v1.1.0 (protocol/2; hello,chunks,topics/1)
+func identifyagent() string {
+	return fmt.Sprintf("%s (protocol/%d; %s)", AgentVersion(), protocolversion, strings.Join(capabilities, ","))
+}
+
+// A function that parses an agent version advertised with Identify.
+// Older peerchat clients only advertise their version and other
+// applications are kept as they are, without any capabilities.
+func parseagent(raw string) peeragent {
+	agent := peeragent{raw: raw}
+
+	name := raw
+	details := ""
+	if index := strings.Index(raw, " ("); index >= 0 && strings.HasSuffix(raw, ")") {
+		name, details = raw[:index], raw[index+2:len(raw)-1]
+	}
+
+	if !strings.HasPrefix(name, agentname+"/") {
+		return agent
+	}
+	agent.peerchat = true
+	agent.version = strings.TrimPrefix(name, agentname+"/")
+
+	for _, field := range strings.Split(details, ";") {
+		field = strings.TrimSpace(field)
+
+		switch {
+		case field == "":
+		case strings.HasPrefix(field, "protocol/"):
+			agent.protocol, _ = strconv.Atoi(strings.TrimPrefix(field, "protocol/"))
+		default:
+			agent.capabilities = strings.Split(field, ",")
+		}
+	}
+
+	return agent
+}
+
+// A method of peeragent that returns a description of the client of the peer
+func (agent peeragent) describe() string {
+	if !agent.peerchat {
+		return agent.raw
+	}
+
+	return fmt.Sprintf("%s %s", agentname, agent.version)
+}
+
+// A method of P2P that returns the agent version a peer advertised with Identify, if known
+func (p2p *P2P) peeragent(peerid peer.ID) (peeragent, bool) {
+	value, err := p2p.Host.Peerstore().Get(peerid, "AgentVersion")
+	if err != nil {
+		return peeragent{}, false
+	}
+
+	raw, ok := value.(string)
+	if !ok || raw == "" {
+		return peeragent{}, false
+	}
+
+	return parseagent(raw), true
+}
+
+// A method of P2P that returns the capabilities of a peer from its
+// handshake or, before the handshake, from its agent version
+func (p2p *P2P) peercapabilities(peerid peer.ID) ([]string, bool) {
+	if remote, ok := p2p.peerhello(peerid); ok && remote.Capabilities != nil {
+		return remote.Capabilities, true
+	}
+
+	if agent, ok := p2p.peeragent(peerid); ok && agent.peerchat {
+		return agent.capabilities, true
+	}
+
+	return nil, false
+}
+
+// A method of P2P that returns the features of the application that a peer
+// running peerchat does not support, sorted by name. Peers whose
+// capabilities are not known yet are not reported.
+func (p2p *P2P) missingfeatures(peerid peer.ID) []string {
+	supported, ok := p2p.peercapabilities(peerid)
+	if !ok {
+		return nil
+	}
+
+	var missing []string
+	for capability, feature := range features {
+		if !contains(supported, capability) {
+			missing = append(missing, feature)
+		}
+	}
+
+	sort.Strings(missing)
+	return missing
+}
+
+// A method of P2P that returns the features a peer does not support the
+// first time they are known, so that the UI warns about each peer once
+func (p2p *P2P) incompatible(peerid peer.ID) []string {
+	missing := p2p.missingfeatures(peerid)
+	if len(missing) == 0 {
+		return nil
+	}
+
+	p2p.protocols.mutex.Lock()
+	defer p2p.protocols.mutex.Unlock()
+
+	if p2p.protocols.incompatible[peerid] {
+		return nil
+	}
+	p2p.protocols.incompatible[peerid] = true
+
+	return missing
+}
+
+// A function that returns whether a slice of strings contains a string
+func contains(values []string, value string) bool {
+	for _, candidate := range values {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
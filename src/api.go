@@ -0,0 +1,239 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the maximum size of a REST API request body
+const maxapibody = 64 * 1024
+
+/*
+A function that starts the REST API of a Gateway on the given address, so
+that alternative frontends can be built against a running peerchat node.
+Requests must carry the token as a bearer token. The token may only be
+left empty if the API listens on a loopback address, in which case the
+requests must be made to a loopback host name. POST requests must be JSON.
+
+	GET    /self                      the peer ID, addresses and user name of the node
+	GET    /rooms                     the joined rooms
+	POST   /rooms                     join a room ({"room": "name"})
+	DELETE /rooms/{room}              leave a room
+	GET    /rooms/{room}/messages     the recent messages of a room (?limit=n)
+	POST   /rooms/{room}/messages     send a message to a room ({"message": "text"})
+	GET    /rooms/{room}/peers        the peers of a room
+*/
+func ServeAPI(gw *Gateway, addr string, token string) error {
+	// Listen on the address
+	listener, err := listenapi(addr, token)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	addapiroutes(mux, gw)
+
+	// Serve the requests in the background
+	go func() {
+		if err := http.Serve(listener, guardapi(authorize(mux, token), token)); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("REST API Failed!")
+		}
+	}()
+
+	return nil
+}
+
+// A function that listens on the address of the REST API. Listening on an
+// address other than a loopback address is refused without a token, since
+// anyone who can reach the API could then post in the rooms of the node.
+func listenapi(addr string, token string) (net.Listener, error) {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if tcpaddr, ok := listener.Addr().(*net.TCPAddr); token == "" && (!ok || !tcpaddr.IP.IsLoopback()) {
+		listener.Close()
+		return nil, fmt.Errorf("a token is required to serve on %s, which is not a loopback address", addr)
+	}
+
+	return listener, nil
+}
+
+// A function that adds the routes of the REST API of a Gateway to a mux
+func addapiroutes(mux *http.ServeMux, gw *Gateway) {
+	mux.HandleFunc("/self", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		addrs := []string{}
+		for _, addr := range gw.Host.Host.Addrs() {
+			addrs = append(addrs, addr.String())
+		}
+
+		writejson(w, http.StatusOK, map[string]interface{}{
+			"id":    gw.Host.Host.ID().Pretty(),
+			"user":  gw.UserName,
+			"addrs": addrs,
+		})
+	})
+
+	mux.HandleFunc("/rooms", func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			writejson(w, http.StatusOK, gw.Rooms())
+
+		case http.MethodPost:
+			var req struct {
+				Room string `json:"room"`
+			}
+
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxapibody)).Decode(&req); err != nil || req.Room == "" {
+				http.Error(w, "room is required", http.StatusBadRequest)
+				return
+			}
+
+			if err := gw.Join(req.Room); err != nil {
+				http.Error(w, err.Error(), http.StatusBadGateway)
+				return
+			}
+
+			w.WriteHeader(http.StatusCreated)
+
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	})
+
+	mux.HandleFunc("/rooms/", func(w http.ResponseWriter, r *http.Request) {
+		// Split the path into the room and the resource
+		parts := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/rooms/"), "/", 2)
+		room := parts[0]
+		resource := ""
+		if len(parts) == 2 {
+			resource = parts[1]
+		}
+
+		switch {
+		case resource == "" && r.Method == http.MethodDelete:
+			if err := gw.Leave(room); err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+
+		case resource == "messages" && r.Method == http.MethodGet:
+			limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+			history, err := gw.History(room, limit)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			writejson(w, http.StatusOK, history)
+
+		case resource == "messages" && r.Method == http.MethodPost:
+			var req struct {
+				Message string `json:"message"`
+			}
+
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxapibody)).Decode(&req); err != nil {
+				http.Error(w, "could not decode JSON", http.StatusBadRequest)
+				return
+			}
+
+			if err := gw.Send(room, req.Message); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+
+			w.WriteHeader(http.StatusAccepted)
+
+		case resource == "peers" && r.Method == http.MethodGet:
+			peers, err := gw.Peers(room)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusNotFound)
+				return
+			}
+
+			writejson(w, http.StatusOK, peers)
+
+		default:
+			http.Error(w, "not found", http.StatusNotFound)
+		}
+	})
+}
+
+// A function that wraps a handler to require a bearer token in the Authorization
+// header. The handler is returned as is if the token is empty.
+func authorize(handler http.Handler, token string) http.Handler {
+	if token == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !checkbearer(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// A function that wraps a handler against requests made by web pages in a browser.
+// POST requests must carry a JSON body, which pages of other origins cannot send
+// without a preflight, so they cannot post as the user. Without a token, the Host
+// must also be a loopback address, so that a page cannot read the API through a
+// name that it rebinds to the loopback address.
+func guardapi(handler http.Handler, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" && !loopbackhost(r.Host) {
+			http.Error(w, "forbidden host", http.StatusForbidden)
+			return
+		}
+
+		if r.Method == http.MethodPost {
+			if mediatype, _, err := mime.ParseMediaType(r.Header.Get("Content-Type")); err != nil || mediatype != "application/json" {
+				http.Error(w, "content type must be application/json", http.StatusUnsupportedMediaType)
+				return
+			}
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// A function that checks if the Host of a request is 'localhost' or a loopback address
+func loopbackhost(host string) bool {
+	if name, _, err := net.SplitHostPort(host); err == nil {
+		host = name
+	}
+
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+
+	ip := net.ParseIP(strings.Trim(host, "[]"))
+	return ip != nil && ip.IsLoopback()
+}
+
+// A function that writes a value as a JSON response
+func writejson(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(value)
+}
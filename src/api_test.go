@@ -0,0 +1,89 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestListenAPI(t *testing.T) {
+	tests := []struct {
+		addr  string
+		token string
+		ok    bool
+	}{
+		{"127.0.0.1:0", "", true},
+		{"localhost:0", "", true},
+		{"127.0.0.1:0", "secret", true},
+		{"0.0.0.0:0", "secret", true},
+		{"0.0.0.0:0", "", false},
+		{":0", "", false},
+	}
+
+	for _, test := range tests {
+		listener, err := listenapi(test.addr, test.token)
+		if err == nil {
+			listener.Close()
+		}
+
+		if ok := err == nil; ok != test.ok {
+			t.Errorf("listenapi(%q, %q) returned %v, want success %v", test.addr, test.token, err, test.ok)
+		}
+	}
+}
+
+func TestGatewayRoomName(t *testing.T) {
+	tests := []struct {
+		roomname string
+		want     string
+	}{
+		{"lobby", "lobby"},
+		{"  lobby\t", "lobby"},
+		{"", defaultroom},
+		{"   ", defaultroom},
+	}
+
+	for _, test := range tests {
+		if got := gatewayroomname(test.roomname); got != test.want {
+			t.Errorf("gatewayroomname(%q) = %q, want %q", test.roomname, got, test.want)
+		}
+	}
+}
+
+func TestGuardAPI(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		name        string
+		token       string
+		method      string
+		host        string
+		contenttype string
+		want        int
+	}{
+		{"loopback get", "", http.MethodGet, "127.0.0.1:8788", "", http.StatusOK},
+		{"localhost get", "", http.MethodGet, "localhost:8788", "", http.StatusOK},
+		{"ipv6 loopback get", "", http.MethodGet, "[::1]:8788", "", http.StatusOK},
+		{"rebound name", "", http.MethodGet, "attacker.example:8788", "", http.StatusForbidden},
+		{"lan address", "", http.MethodGet, "192.168.1.2:8788", "", http.StatusForbidden},
+		{"any host with token", "secret", http.MethodGet, "192.168.1.2:8788", "", http.StatusOK},
+		{"json post", "", http.MethodPost, "127.0.0.1:8788", "application/json; charset=utf-8", http.StatusOK},
+		{"form post", "", http.MethodPost, "127.0.0.1:8788", "text/plain", http.StatusUnsupportedMediaType},
+		{"post without type", "secret", http.MethodPost, "127.0.0.1:8788", "", http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(test.method, "/rooms/lobby/messages", strings.NewReader(`{"message":"hi"}`))
+		request.Host = test.host
+		if test.contenttype != "" {
+			request.Header.Set("Content-Type", test.contenttype)
+		}
+
+		recorder := httptest.NewRecorder()
+		guardapi(ok, test.token).ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package src
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/rivo/tview"
+)
+
+// Represents the protocol ID for fetching the avatar of a user from its peer
+const avatarprotocol = protocol.ID("/peerchat/avatar/1.0.0")
+
+// Represents the maximum number of lines and columns of an avatar
+const (
+	maxavatarlines = 8
+	maxavatarwidth = 24
+)
+
+// Represents the time allowed to retrieve an avatar
+const avatartimeout = 5 * time.Second
+
+// A function that returns the path of the avatar of the selected profile
+func avatarpath() string {
+	return filepath.Join(defaultdir(), "avatar.txt")
+}
+
+// A function that returns the path of a cached avatar of another user
+func avatarcachepath(hash string) string {
+	return peerchatpath("avatars", hash+".txt")
+}
+
+// A function that returns the hash of an avatar, which
+// is published with the user profile to identify it
+func avatarhash(art string) string {
+	sum := sha256.Sum256([]byte(art))
+	return hex.EncodeToString(sum[:])
+}
+
+// A function that checks that an avatar is small printable ASCII art
+// and returns it with trailing whitespace and empty lines trimmed
+func validateavatar(art string) (string, error) {
+	lines := strings.Split(strings.TrimRight(strings.ReplaceAll(art, "\r\n", "\n"), "\n "), "\n")
+	if len(lines) > maxavatarlines {
+		return "", fmt.Errorf("avatar has more than %d lines", maxavatarlines)
+	}
+
+	for index, line := range lines {
+		line = strings.TrimRight(line, " ")
+		if len(line) > maxavatarwidth {
+			return "", fmt.Errorf("avatar is wider than %d columns", maxavatarwidth)
+		}
+
+		for _, char := range line {
+			if char < ' ' || char > '~' {
+				return "", fmt.Errorf("avatar contains a character that is not printable ASCII")
+			}
+		}
+
+		lines[index] = line
+	}
+
+	return strings.Join(lines, "\n"), nil
+}
+
+// A function that loads the avatar of the selected profile, if any
+func loadavatar() string {
+	data, err := ioutil.ReadFile(avatarpath())
+	if err != nil {
+		return ""
+	}
+
+	art, err := validateavatar(string(data))
+	if err != nil {
+		return ""
+	}
+
+	return art
+}
+
+// A function that returns the lines of an avatar escaped for tview and padded
+// to the same width, so that the avatar keeps its shape when centered
+func avatarlines(art string) []string {
+	lines := strings.Split(art, "\n")
+
+	width := 0
+	for _, line := range lines {
+		if len(line) > width {
+			width = len(line)
+		}
+	}
+
+	for index, line := range lines {
+		lines[index] = tview.Escape(line + strings.Repeat(" ", width-len(line)))
+	}
+
+	return lines
+}
+
+// A method of P2P that sets the avatar of the user profile of the host from a
+// text file, or removes the avatar if the path is empty. Only the hash of the
+// avatar is published with the profile, the avatar is sent on request.
+func (p2p *P2P) SetAvatar(path string) error {
+	if path == "" {
+		os.Remove(avatarpath())
+		return p2p.UpdateProfile(func(profile *UserProfile) { profile.Avatar = "" })
+	}
+
+	data, err := ioutil.ReadFile(expandhome(path))
+	if err != nil {
+		return err
+	}
+
+	art, err := validateavatar(string(data))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(avatarpath()), 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(avatarpath(), []byte(art), 0600); err != nil {
+		return err
+	}
+
+	return p2p.UpdateProfile(func(profile *UserProfile) { profile.Avatar = avatarhash(art) })
+}
+
+// A method of P2P that returns the avatar of a peer if it has
+// already been retrieved, without contacting the network
+func (p2p *P2P) cachedavatar(peerid peer.ID) string {
+	if peerid == p2p.Host.ID() {
+		return loadavatar()
+	}
+
+	p2p.profile.mutex.Lock()
+	profile, ok := p2p.profile.known[peerid]
+	p2p.profile.mutex.Unlock()
+
+	if !ok || profile.Avatar == "" {
+		return ""
+	}
+
+	data, err := ioutil.ReadFile(avatarcachepath(profile.Avatar))
+	if err != nil {
+		return ""
+	}
+
+	return string(data)
+}
+
+// A method of P2P that handles a request for the avatar of the host
+func (p2p *P2P) handleavatar(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(avatartimeout))
+
+	stream.Write([]byte(loadavatar()))
+}
+
+// A method of P2P that returns the avatar of a peer, which is identified by
+// the hash in its user profile. Avatars are requested from the peer and
+// cached by their hash. Returns an empty avatar if the peer has none.
+func (p2p *P2P) PeerAvatar(peerid peer.ID) (string, error) {
+	if peerid == p2p.Host.ID() {
+		return loadavatar(), nil
+	}
+
+	profile, err := p2p.knownprofile(peerid)
+	if err != nil || profile.Avatar == "" {
+		return "", err
+	}
+
+	// Use the cached avatar if it is known
+	if data, err := ioutil.ReadFile(avatarcachepath(profile.Avatar)); err == nil {
+		return string(data), nil
+	}
+
+	// Request the avatar from the peer
+	ctx, cancel := context.WithTimeout(p2p.Ctx, avatartimeout)
+	defer cancel()
+
+	stream, err := p2p.Host.NewStream(ctx, peerid, avatarprotocol)
+	if err != nil {
+		return "", err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(avatartimeout))
+
+	data, err := ioutil.ReadAll(io.LimitReader(stream, maxavatarlines*(maxavatarwidth+1)))
+	if err != nil {
+		return "", err
+	}
+
+	// The avatar must match the hash of the signed profile
+	art := string(data)
+	if avatarhash(art) != profile.Avatar {
+		return "", fmt.Errorf("avatar does not match the profile of the peer")
+	}
+	if art, err = validateavatar(art); err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(avatarcachepath(profile.Avatar)), 0700); err == nil {
+		ioutil.WriteFile(avatarcachepath(profile.Avatar), []byte(art), 0600)
+	}
+
+	return art, nil
+}
@@ -0,0 +1,72 @@
+package src
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// A method of simstats that returns the lines of a report of the delivered
+// messages, their ordering and their latency. Must be called with the lock held.
+func (stats *simstats) report() []string {
+	latencies := append([]time.Duration(nil), stats.latencies...)
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+
+	return []string{
+		fmt.Sprintf("messages:   %d sent, %d of %d delivered (%.2f%% dropped)", stats.sent, stats.delivered, stats.expected, stats.droprate()),
+		fmt.Sprintf("ordering:   %d reordered, %d duplicates", stats.reordered, stats.duplicates),
+		fmt.Sprintf("latency:    %s p50, %s p90, %s p99, %s max",
+			percentile(latencies, 50), percentile(latencies, 90), percentile(latencies, 99), percentile(latencies, 100)),
+	}
+}
+
+// A method of simstats that returns the percentage of the expected
+// deliveries that did not happen. Must be called with the lock held.
+func (stats *simstats) droprate() float64 {
+	if stats.expected == 0 {
+		return 0
+	}
+
+	delivered := stats.delivered - stats.duplicates
+	if delivered > stats.expected {
+		delivered = stats.expected
+	}
+
+	return 100 * float64(stats.expected-delivered) / float64(stats.expected)
+}
+
+// A function that returns a percentile of sorted latencies, rounded for display
+func percentile(sorted []time.Duration, p int) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	index := (len(sorted)*p+99)/100 - 1
+	if index < 0 {
+		index = 0
+	}
+
+	return sorted[index].Round(100 * time.Microsecond)
+}
+
+// A method of Simulation that returns the report of a benchmark that generated
+// traffic for the elapsed time, with the rates of the sent and delivered messages
+func (sim *Simulation) Benchmark(elapsed time.Duration) []string {
+	stats := sim.stats
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	senders := len(sim.sending) / len(sim.config.Rooms)
+	seconds := elapsed.Seconds()
+	if seconds == 0 {
+		seconds = 1
+	}
+
+	lines := []string{
+		fmt.Sprintf("senders:    %d of %d nodes, sending every %s", senders, len(sim.nodes), sim.config.Interval),
+		fmt.Sprintf("size:       %d bytes", sim.config.Size),
+		fmt.Sprintf("throughput: %.1f msg/s sent, %.1f msg/s delivered", float64(stats.sent)/seconds, float64(stats.delivered)/seconds),
+	}
+
+	return append(lines, stats.report()...)
+}
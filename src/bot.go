@@ -0,0 +1,158 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Represents the prefix of the bot commands in chat messages.
+// Slash commands are handled locally by the UI of each peer,
+// so bots respond to commands such as '!roll' instead.
+const botcommandprefix = "!"
+
+// A type that represents a handler for chat messages recieved by a Bot
+type MessageHandler func(bot *Bot, msg ChatMessage)
+
+// A type that represents a handler for peers joining the chat room of a Bot
+type JoinHandler func(bot *Bot, peerid peer.ID)
+
+// A type that represents a handler for bot commands. The handler recieves
+// the chat message with the command and the argument string of the command.
+type BotCommandHandler func(bot *Bot, msg ChatMessage, arg string)
+
+// A structure that represents an autonomous chat room bot.
+// Go programs can import peerchat, join a ChatRoom, attach
+// callbacks to a Bot and run it without any UI.
+type Bot struct {
+	// Represents the ChatRoom (embedded)
+	*ChatRoom
+
+	// Represents the handlers for chat messages
+	messagehandlers []MessageHandler
+	// Represents the handlers for peers joining
+	joinhandlers []JoinHandler
+	// Represents the handlers for bot commands
+	commandhandlers map[string]BotCommandHandler
+
+	// Represents the lock on the handlers
+	mutex sync.RWMutex
+}
+
+// A constructor function that generates and
+// returns a new Bot for a given ChatRoom
+func NewBot(cr *ChatRoom) *Bot {
+	return &Bot{
+		ChatRoom:        cr,
+		commandhandlers: make(map[string]BotCommandHandler),
+	}
+}
+
+// A method of Bot that adds a handler called for every chat message
+func (bot *Bot) OnMessage(handler MessageHandler) {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	bot.messagehandlers = append(bot.messagehandlers, handler)
+}
+
+// A method of Bot that adds a handler called when a peer joins the chat room
+func (bot *Bot) OnJoin(handler JoinHandler) {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	bot.joinhandlers = append(bot.joinhandlers, handler)
+}
+
+// A method of Bot that sets the handler for a bot command. The command
+// is invoked by chat messages starting with '!' and the command name.
+func (bot *Bot) OnCommand(name string, handler BotCommandHandler) {
+	bot.mutex.Lock()
+	defer bot.mutex.Unlock()
+
+	bot.commandhandlers[strings.TrimPrefix(name, botcommandprefix)] = handler
+}
+
+// A method of Bot that publishes a message to the chat room
+func (bot *Bot) Send(text string) {
+	bot.Outbound <- text
+}
+
+// A method of Bot that publishes a message to the
+// chat room that mentions the sender of a message
+func (bot *Bot) Reply(msg ChatMessage, text string) {
+	bot.Send(fmt.Sprintf("@%s %s", msg.SenderName, text))
+}
+
+// A method of Bot that runs the bot, dispatching chat messages and peer
+// joins to the handlers until the context is cancelled or the room exits
+func (bot *Bot) Run(ctx context.Context) error {
+	defer bot.Exit()
+
+	return bot.Emit(ctx, bot)
+}
+
+// A method of Bot that dispatches a message received in the chat room
+func (bot *Bot) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	bot.dispatchmessage(msg)
+}
+
+// A method of Bot that dispatches a peer joining the chat room to the join handlers
+func (bot *Bot) PeerJoined(cr *ChatRoom, peerid peer.ID) {
+	bot.mutex.RLock()
+	handlers := append([]JoinHandler{}, bot.joinhandlers...)
+	bot.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(bot, peerid)
+	}
+}
+
+// A method of Bot that ignores a peer leaving the chat room
+func (bot *Bot) PeerLeft(cr *ChatRoom, peerid peer.ID) {}
+
+// A method of Bot that follows the chat room to the room it is left for
+func (bot *Bot) RoomChanged(from *ChatRoom, to *ChatRoom) {
+	bot.ChatRoom = to
+}
+
+// A method of Bot that logs a log of the chat room
+func (bot *Bot) Error(cr *ChatRoom, err error) {
+	logroomerror(cr, err)
+}
+
+// A method of Bot that dispatches a chat message to the message
+// handlers and to the command handler if it contains a bot command.
+// The handlers are called without the lock held, so that they can
+// add handlers and a slow handler does not block adding them.
+func (bot *Bot) dispatchmessage(msg ChatMessage) {
+	bot.mutex.RLock()
+	handlers := append([]MessageHandler{}, bot.messagehandlers...)
+	bot.mutex.RUnlock()
+
+	for _, handler := range handlers {
+		handler(bot, msg)
+	}
+
+	// Check for a bot command
+	if !strings.HasPrefix(msg.Message, botcommandprefix) {
+		return
+	}
+
+	// Split the command from its argument
+	cmdparts := strings.SplitN(strings.TrimPrefix(msg.Message, botcommandprefix), " ", 2)
+	if len(cmdparts) == 1 {
+		cmdparts = append(cmdparts, "")
+	}
+
+	bot.mutex.RLock()
+	handler, ok := bot.commandhandlers[cmdparts[0]]
+	bot.mutex.RUnlock()
+
+	if ok {
+		handler(bot, msg, strings.TrimSpace(cmdparts[1]))
+	}
+}
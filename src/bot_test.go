@@ -0,0 +1,47 @@
+package src
+
+import (
+	"testing"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A function that fails the test if a call does not return in time
+func within(t *testing.T, call func()) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		call()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the call deadlocked")
+	}
+}
+
+func TestBotHandlersRegisterHandlers(t *testing.T) {
+	bot := NewBot(nil)
+
+	var rolled string
+	bot.OnMessage(func(bot *Bot, msg ChatMessage) {
+		// Handlers can add other handlers while they run
+		bot.OnCommand("roll", func(bot *Bot, msg ChatMessage, arg string) {
+			rolled = arg
+		})
+	})
+	bot.OnJoin(func(bot *Bot, peerid peer.ID) {
+		bot.OnJoin(func(bot *Bot, peerid peer.ID) {})
+	})
+
+	within(t, func() { bot.dispatchmessage(ChatMessage{Message: "!roll d20"}) })
+	within(t, func() { bot.PeerJoined(nil, peer.ID("peer")) })
+
+	if rolled != "d20" {
+		t.Errorf("the command handler got '%s', want 'd20'", rolled)
+	}
+}
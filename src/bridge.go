@@ -0,0 +1,553 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time to wait before reconnecting to a bridged server
+const bridgereconnect = time.Second * 15
+
+// Represents the maximum length of the text of a single IRC message
+const ircmaxtext = 400
+
+// Represents the suffix added to the names of IRC users in a chat room
+const ircnamesuffix = "@irc"
+
+/*
+An interface that represents a bridge that mirrors a chat room to a room,
+channel or feed of another chat network and back.
+
+Messages sent by the interfaces of the node running a bridge are not
+mirrored, so bridges are best run on a dedicated node (such as a daemon).
+*/
+type bridge interface {
+	// Represents the method that validates the configuration, joins the chat room and starts relaying
+	start(p2phost *P2P) error
+	// Represents the method that stops relaying and exits the chat room
+	stop()
+}
+
+// A structure that represents a bridge that mirrors a chat room to an IRC
+// channel and back. Room messages are relayed to the channel as '<name> text'
+// and channel messages are published into the room with the IRC nickname
+// suffixed by '@irc'. Joins and parts are announced on both sides.
+type ircbridge struct {
+	// Represents the chat room that is bridged
+	Room string `json:"room"`
+	// Represents the address of the IRC server (host:port)
+	Server string `json:"server"`
+	// Represents whether to connect to the IRC server with TLS
+	TLS bool `json:"tls"`
+	// Represents the nickname of the bridge on IRC and its name in the chat room
+	Nick string `json:"nick"`
+	// Represents the password of the IRC server (optional)
+	Password string `json:"password"`
+	// Represents the IRC channel that is bridged
+	Channel string `json:"channel"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the current connection to the IRC server
+	conn net.Conn
+	// Represents the lock on the connection
+	mutex sync.Mutex
+}
+
+// Represents the running bridges
+var bridges []bridge
+
+// A function that loads the bridges from the bridges file
+// (~/.peerchat/bridges.json) and starts them on a P2P host.
+// The file contains a JSON list of bridges, each with a 'type'
+// ('irc' if empty) and the configuration of that type of bridge.
+// Returns the number of bridges started.
+func LoadBridges(p2phost *P2P) int {
+	path := peerchatpath("bridges.json")
+
+	// Read the bridges file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logrus.Debugf("No Bridges Loaded from %s.", path)
+		return 0
+	}
+
+	// Unmarshal the list of bridges
+	var entries []json.RawMessage
+	if err := json.Unmarshal(data, &entries); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  path,
+		}).Warnln("Failed to Parse Bridges!")
+		return 0
+	}
+
+	for _, entry := range entries {
+		// Create the bridge of the given type and unmarshal its configuration
+		b, err := newbridge(entry)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"file":  path,
+			}).Warnln("Failed to Parse Bridge!")
+			continue
+		}
+
+		if err := b.start(p2phost); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"bridge": string(entry),
+			}).Warnln("Failed to Start Bridge!")
+			continue
+		}
+
+		bridges = append(bridges, b)
+	}
+
+	return len(bridges)
+}
+
+// A function that stops all the running bridges
+func StopBridges() {
+	for _, b := range bridges {
+		b.stop()
+	}
+
+	bridges = nil
+}
+
+// A function that creates a bridge for the type of a
+// configuration entry and unmarshals the entry into it
+func newbridge(entry json.RawMessage) (bridge, error) {
+	var header struct {
+		Type string `json:"type"`
+	}
+
+	if err := json.Unmarshal(entry, &header); err != nil {
+		return nil, err
+	}
+
+	var b bridge
+	switch header.Type {
+	case "", "irc":
+		b = &ircbridge{}
+	case "matrix":
+		b = &matrixbridge{}
+	case "xmpp":
+		b = &xmppbridge{}
+	case "nostr":
+		b = &nostrbridge{}
+	case "mqtt":
+		b = &mqttbridge{}
+	case "feed":
+		b = &feedbridge{}
+	default:
+		return nil, fmt.Errorf("unsupported bridge type - %s", header.Type)
+	}
+
+	if err := json.Unmarshal(entry, b); err != nil {
+		return nil, err
+	}
+
+	return b, nil
+}
+
+// A method of ircbridge that joins the chat room and starts relaying
+func (bridge *ircbridge) start(p2phost *P2P) error {
+	if bridge.Server == "" || bridge.Channel == "" || bridge.Nick == "" {
+		return errors.New("irc bridge requires a server, channel and nick")
+	}
+
+	// Join the chat room with the nick of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Nick, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+
+	// Announce the peers joining and leaving the room
+	err = room.presence(func(name string, joined bool) {
+		verb := "left"
+		if joined {
+			verb = "joined"
+		}
+
+		bridge.privmsg("", fmt.Sprintf("* %s %s %s", stripcontrols(name), verb, room.chatroom.RoomName))
+	})
+	if err != nil {
+		room.leave()
+		return err
+	}
+
+	// Relay the room messages to the channel
+	go room.relay(func(msg ChatMessage) {
+		bridge.privmsg(fmt.Sprintf("<%s> ", stripcontrols(msg.SenderName)), msg.Message)
+	})
+
+	go bridge.ircloop()
+	return nil
+}
+
+// A method of ircbridge that stops relaying, quits IRC and exits the chat room
+func (bridge *ircbridge) stop() {
+	bridge.room.cancel()
+
+	bridge.mutex.Lock()
+	if bridge.conn != nil {
+		fmt.Fprintf(bridge.conn, "QUIT :peerchat bridge stopped\r\n")
+		bridge.conn.Close()
+	}
+	bridge.mutex.Unlock()
+
+	bridge.room.leave()
+}
+
+// A method of ircbridge that keeps a connection to the IRC
+// server and reconnects to it until the bridge is stopped
+func (bridge *ircbridge) ircloop() {
+	for {
+		if err := bridge.session(); err != nil && bridge.room.ctx.Err() == nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"server": bridge.Server,
+			}).Warnln("IRC Bridge Disconnected!")
+		}
+
+		select {
+		case <-bridge.room.ctx.Done():
+			return
+		case <-time.After(bridgereconnect):
+		}
+	}
+}
+
+// A method of ircbridge that connects to the IRC server and
+// handles its messages until the connection is closed
+func (bridge *ircbridge) session() error {
+	// Connect to the IRC server
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: time.Second * 30}
+	if bridge.TLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", bridge.Server, &tls.Config{})
+	} else {
+		conn, err = dialer.Dial("tcp", bridge.Server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bridge.mutex.Lock()
+	bridge.conn = conn
+	bridge.mutex.Unlock()
+
+	defer func() {
+		bridge.mutex.Lock()
+		bridge.conn = nil
+		bridge.mutex.Unlock()
+	}()
+
+	// Register with the IRC server
+	nick := bridge.Nick
+	if bridge.Password != "" {
+		bridge.send("PASS " + bridge.Password)
+	}
+	bridge.send("NICK " + nick)
+	bridge.send(fmt.Sprintf("USER %s 0 * :peerchat bridge", nick))
+
+	scanner := bufio.NewScanner(conn)
+	for scanner.Scan() {
+		prefix, command, params := parseircline(scanner.Text())
+		sender := strings.SplitN(prefix, "!", 2)[0]
+
+		switch command {
+		case "PING":
+			bridge.send("PONG :" + strings.Join(params, " "))
+
+		case "001":
+			// Join the channel once registered
+			bridge.send("JOIN " + bridge.Channel)
+			logrus.WithFields(logrus.Fields{
+				"server":  bridge.Server,
+				"channel": bridge.Channel,
+				"room":    bridge.room.chatroom.RoomName,
+			}).Infoln("IRC Bridge Connected")
+
+		case "433":
+			// Retry with another nick if the nick is in use
+			nick += "_"
+			bridge.send("NICK " + nick)
+
+		case "PRIVMSG":
+			if len(params) < 2 || !strings.EqualFold(params[0], bridge.Channel) {
+				continue
+			}
+
+			text := params[1]
+			// Convert CTCP actions into emotes
+			if strings.HasPrefix(text, "\x01ACTION ") {
+				text = fmt.Sprintf("* %s %s", sender, strings.Trim(strings.TrimPrefix(text, "\x01ACTION "), "\x01"))
+			} else if strings.HasPrefix(text, "\x01") {
+				continue
+			}
+
+			bridge.room.publish(sender+ircnamesuffix, text)
+
+		case "JOIN", "PART":
+			if sender == nick || len(params) < 1 || !strings.EqualFold(params[0], bridge.Channel) {
+				continue
+			}
+
+			verb := "joined"
+			if command == "PART" {
+				verb = "left"
+			}
+
+			bridge.room.publish(bridge.Nick, fmt.Sprintf("* %s %s %s", sender+ircnamesuffix, verb, bridge.Channel))
+
+		case "QUIT":
+			if sender == nick {
+				continue
+			}
+
+			bridge.room.publish(bridge.Nick, fmt.Sprintf("* %s quit IRC", sender+ircnamesuffix))
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	return errors.New("connection closed")
+}
+
+// A method of ircbridge that sends a text to the IRC channel, split
+// into lines and chunks that fit into IRC messages, each with a prefix
+func (bridge *ircbridge) privmsg(prefix string, text string) {
+	for _, line := range irclines(bridge.Channel, prefix, text) {
+		bridge.send(line)
+	}
+}
+
+// A function that builds the raw PRIVMSG lines that send a text to an IRC
+// channel, split into lines and chunks that fit into IRC messages, each with
+// a prefix. The prefix and the text come from peers, so control characters
+// (such as the CR and LF that end a raw line) are stripped from both, to keep
+// peers from injecting IRC commands.
+func irclines(channel string, prefix string, text string) []string {
+	prefix = stripcontrols(prefix)
+
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		line = stripcontrols(line)
+
+		for len(line) > 0 {
+			// Split the line at a rune boundary
+			chunk := line
+			if len(chunk) > ircmaxtext {
+				cut := ircmaxtext
+				for cut > 1 && !utf8.RuneStart(chunk[cut]) {
+					cut--
+				}
+				chunk = chunk[:cut]
+			}
+			line = line[len(chunk):]
+
+			lines = append(lines, fmt.Sprintf("PRIVMSG %s :%s%s", channel, prefix, chunk))
+		}
+	}
+
+	return lines
+}
+
+// A method of ircbridge that writes a raw line to the IRC
+// server. The line is dropped if the bridge is disconnected.
+func (bridge *ircbridge) send(line string) {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	if bridge.conn == nil {
+		return
+	}
+
+	fmt.Fprintf(bridge.conn, "%s\r\n", line)
+}
+
+// A function that parses a raw IRC line into its prefix, command and parameters
+func parseircline(line string) (string, string, []string) {
+	prefix := ""
+	if strings.HasPrefix(line, ":") {
+		parts := strings.SplitN(line[1:], " ", 2)
+		prefix = parts[0]
+		line = ""
+		if len(parts) == 2 {
+			line = parts[1]
+		}
+	}
+
+	// Split off the trailing parameter
+	trailing := ""
+	hastrailing := false
+	if index := strings.Index(line, " :"); index >= 0 {
+		trailing = line[index+2:]
+		line = line[:index]
+		hastrailing = true
+	} else if strings.HasPrefix(line, ":") {
+		trailing = line[1:]
+		line = ""
+		hastrailing = true
+	}
+
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return prefix, "", nil
+	}
+
+	params := fields[1:]
+	if hastrailing {
+		params = append(params, trailing)
+	}
+
+	return prefix, strings.ToUpper(fields[0]), params
+}
+
+// A structure that represents the chat room side of a bridge
+type bridgeroom struct {
+	// Represents the chat room of the bridge
+	chatroom *ChatRoom
+	// Represents the last known user names of the peers in the room
+	nicks map[peer.ID]string
+	// Represents the lock on the nicks
+	nicklock sync.Mutex
+	// Represents the bridge lifecycle context
+	ctx context.Context
+	// Represents the bridge lifecycle cancellation function
+	cancel context.CancelFunc
+	// Represents the handler of the peers joining and leaving the room (nil if not set)
+	onpresence func(name string, joined bool)
+	// Represents the handler of the messages of the room
+	onmessage func(msg ChatMessage)
+}
+
+// A constructor function that joins a chat room on a P2P host
+// for a bridge with the given name and returns a new bridgeroom
+func joinbridgeroom(p2phost *P2P, name string, roomname string) (*bridgeroom, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	// Join the chat room with the name of the bridge, for as long as the bridge runs
+	chatroom, err := JoinChatRoom(ctx, p2phost, name, roomname)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &bridgeroom{
+		chatroom: chatroom,
+		nicks:    make(map[peer.ID]string),
+		ctx:      ctx,
+		cancel:   cancel,
+	}, nil
+}
+
+// A method of bridgeroom that passes the events of the chat room to the
+// handlers until the bridge is stopped or the room exits. The messages are
+// passed to the given handler, and the peers joining and leaving to the
+// handler set with presence before the relay is started.
+func (br *bridgeroom) relay(handler func(msg ChatMessage)) {
+	br.onmessage = handler
+	br.chatroom.Emit(br.ctx, br)
+}
+
+// A method of bridgeroom that sets the handler of the peers joining and leaving
+// the chat room, which are passed with their last known user name once relayed
+func (br *bridgeroom) presence(handler func(name string, joined bool)) error {
+	br.onpresence = handler
+	return nil
+}
+
+// A method of bridgeroom that passes a message of the chat room to the message handler
+func (br *bridgeroom) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	// Remember the user name of the sender
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		br.nicklock.Lock()
+		br.nicks[senderid] = msg.SenderName
+		br.nicklock.Unlock()
+	}
+
+	br.onmessage(msg)
+}
+
+// A method of bridgeroom that passes a peer joining the chat room to the presence handler
+func (br *bridgeroom) PeerJoined(cr *ChatRoom, peerid peer.ID) {
+	br.announce(peerid, true)
+}
+
+// A method of bridgeroom that passes a peer leaving the chat room to the presence handler
+func (br *bridgeroom) PeerLeft(cr *ChatRoom, peerid peer.ID) {
+	br.announce(peerid, false)
+}
+
+// A method of bridgeroom that passes a peer joining or leaving
+// to the presence handler with its last known user name
+func (br *bridgeroom) announce(peerid peer.ID, joined bool) {
+	if br.onpresence == nil {
+		return
+	}
+
+	br.nicklock.Lock()
+	name, ok := br.nicks[peerid]
+	br.nicklock.Unlock()
+	if !ok {
+		name = peerid.ShortString()
+	}
+
+	br.onpresence(name, joined)
+}
+
+// A method of bridgeroom that is told when the chat room is left
+// for another room, which bridges never do
+func (br *bridgeroom) RoomChanged(from *ChatRoom, to *ChatRoom) {}
+
+// A method of bridgeroom that logs a log of the chat room
+func (br *bridgeroom) Error(cr *ChatRoom, err error) {
+	logroomerror(cr, err)
+}
+
+// A method of bridgeroom that publishes a message from
+// another network into the chat room with a sender name
+func (br *bridgeroom) publish(sender string, text string) {
+	cr := br.chatroom
+
+	err := cr.publish(ChatMessage{
+		Message:    text,
+		SenderID:   cr.selfid.Pretty(),
+		SenderName: sender,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"room":   cr.RoomName,
+			"sender": sender,
+		}).Debugln("Failed to Publish Bridged Message!")
+	}
+}
+
+// A method of bridgeroom that stops the bridge and exits the chat room
+func (br *bridgeroom) leave() {
+	br.cancel()
+	br.chatroom.Exit()
+}
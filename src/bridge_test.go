@@ -0,0 +1,98 @@
+package src
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestIRCLinesInjection(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		text   string
+		want   []string
+	}{
+		{
+			name:   "plain",
+			prefix: "<alice> ",
+			text:   "hello",
+			want:   []string{"PRIVMSG #peerchat :<alice> hello"},
+		},
+		{
+			name:   "multiline",
+			prefix: "<alice> ",
+			text:   "one\r\ntwo",
+			want:   []string{"PRIVMSG #peerchat :<alice> one", "PRIVMSG #peerchat :<alice> two"},
+		},
+		{
+			name:   "cr in text",
+			prefix: "<mallory> ",
+			text:   "hi\rQUIT :bye",
+			want:   []string{"PRIVMSG #peerchat :<mallory> hiQUIT :bye"},
+		},
+		{
+			name:   "cr in name",
+			prefix: "<mallory\rPRIVMSG NickServ :DROP> ",
+			text:   "hi",
+			want:   []string{"PRIVMSG #peerchat :<malloryPRIVMSG NickServ :DROP> hi"},
+		},
+		{
+			name:   "crlf in name",
+			prefix: "<mallory\r\nQUIT :bye> ",
+			text:   "hi",
+			want:   []string{"PRIVMSG #peerchat :<malloryQUIT :bye> hi"},
+		},
+		{
+			name:   "control bytes",
+			prefix: "<\x00mallory\x01> ",
+			text:   "\x01ACTION waves\x01\x7f",
+			want:   []string{"PRIVMSG #peerchat :<mallory> ACTION waves"},
+		},
+		{
+			name:   "empty lines",
+			prefix: "<alice> ",
+			text:   "\n\r\n",
+			want:   nil,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := irclines("#peerchat", test.prefix, test.text)
+			if strings.Join(got, "\n") != strings.Join(test.want, "\n") {
+				t.Fatalf("irclines() = %q, want %q", got, test.want)
+			}
+
+			// Every line must be a single PRIVMSG without any raw line breaks
+			for _, line := range got {
+				if strings.ContainsAny(line, "\r\n\x00") {
+					t.Errorf("line %q contains a line break or NUL", line)
+				}
+				if _, command, _ := parseircline(line); command != "PRIVMSG" {
+					t.Errorf("line %q parsed as %q, want PRIVMSG", line, command)
+				}
+			}
+		})
+	}
+}
+
+func TestIRCLinesChunks(t *testing.T) {
+	text := strings.Repeat("é", ircmaxtext)
+	lines := irclines("#peerchat", "", text)
+	if len(lines) != 2 {
+		t.Fatalf("irclines() returned %d lines, want 2", len(lines))
+	}
+
+	var joined string
+	for _, line := range lines {
+		_, _, params := parseircline(line)
+		if len(params) != 2 || len(params[1]) > ircmaxtext {
+			t.Fatalf("line %q has an invalid chunk", line)
+		}
+		joined += params[1]
+	}
+
+	if joined != text {
+		t.Errorf("chunks do not add up to the text")
+	}
+}
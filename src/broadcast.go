@@ -0,0 +1,41 @@
+package src
+
+import (
+	"strings"
+)
+
+// Represents the placeholder of the input field in a read-only room
+const readonlyplaceholder = "Read-only room: only its operators and publishers can post. Commands still work."
+
+// A method of ChatRoom that checks if the host cannot post in the room,
+// because it is a read-only room and the host is not one of its publishers
+func (cr *ChatRoom) readonly() bool {
+	// Room settings are not available when backed by a daemon
+	if cr.Host == nil {
+		return false
+	}
+
+	settings := cr.Host.RoomSettings(cr.RoomName)
+	return settings != nil && !settings.canpublish(cr.selfid)
+}
+
+// A method of UI that disables the input field for messages in a read-only
+// room, leaving only commands, and explains why in its placeholder.
+// Called from the tview event loop.
+func (ui *UI) syncreadonly(readonly bool) {
+	if readonly == ui.inputdisabled {
+		return
+	}
+	ui.inputdisabled = readonly
+
+	if !readonly {
+		ui.inputBox.SetPlaceholder("")
+		ui.inputBox.SetAcceptanceFunc(nil)
+		return
+	}
+
+	ui.inputBox.SetPlaceholder(readonlyplaceholder)
+	ui.inputBox.SetAcceptanceFunc(func(text string, ch rune) bool {
+		return strings.HasPrefix(text, "/")
+	})
+}
@@ -0,0 +1,126 @@
+package src
+
+import (
+	"errors"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/mux"
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the faults injected into the connections of the
+// host for resilience testing. These are developer options that degrade the
+// node on purpose, so they are only set with flags and never in the configuration.
+type Chaos struct {
+	// Represents the delay added to every write on a connection
+	Latency time.Duration
+	// Represents the maximum random delay added on top of the latency
+	Jitter time.Duration
+	// Represents the probability that a room message received from a peer is dropped
+	Drop float64
+	// Represents the average time between the disconnects of a random peer (none if 0)
+	Disconnect time.Duration
+}
+
+// Represents the faults injected into the connections of the host
+var chaos Chaos
+
+// A function that sets the faults injected into the connections of the
+// host, which must be done before the host is created
+func SetChaos(faults Chaos) error {
+	if faults.Drop < 0 || faults.Drop > 1 {
+		return errors.New("the drop probability must be between 0 and 1")
+	}
+	if faults.Latency < 0 || faults.Jitter < 0 || faults.Disconnect < 0 {
+		return errors.New("the chaos durations cannot be negative")
+	}
+
+	chaos = faults
+	if chaos != (Chaos{}) {
+		logrus.WithFields(logrus.Fields{
+			"latency":    chaos.Latency,
+			"jitter":     chaos.Jitter,
+			"drop":       chaos.Drop,
+			"disconnect": chaos.Disconnect,
+		}).Warnln("Injecting Faults into the Connections!")
+	}
+
+	return nil
+}
+
+// A function that returns whether a received message should be dropped
+func chaosdrop() bool {
+	return chaos.Drop > 0 && rand.Float64() < chaos.Drop
+}
+
+// A structure that represents a stream multiplexer that
+// delays the writes on the connections it multiplexes
+type chaosmultiplexer struct {
+	mux.Multiplexer
+}
+
+// A function that wraps a stream multiplexer to delay the writes on its
+// connections if a latency is injected, and returns it unchanged otherwise
+func chaosmuxer(multiplexer mux.Multiplexer) mux.Multiplexer {
+	if chaos.Latency == 0 && chaos.Jitter == 0 {
+		return multiplexer
+	}
+
+	return chaosmultiplexer{Multiplexer: multiplexer}
+}
+
+// A method of chaosmultiplexer that multiplexes a connection whose writes are delayed
+func (multiplexer chaosmultiplexer) NewConn(conn net.Conn, isServer bool) (mux.MuxedConn, error) {
+	return multiplexer.Multiplexer.NewConn(&chaosconn{Conn: conn}, isServer)
+}
+
+// A structure that represents a connection whose writes are delayed
+type chaosconn struct {
+	net.Conn
+	// Represents the lock that keeps the delayed writes in order
+	mutex sync.Mutex
+}
+
+// A method of chaosconn that writes to the connection after the injected latency
+func (conn *chaosconn) Write(data []byte) (int, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+
+	delay := chaos.Latency
+	if chaos.Jitter > 0 {
+		delay += time.Duration(rand.Int63n(int64(chaos.Jitter)))
+	}
+	time.Sleep(delay)
+
+	return conn.Conn.Write(data)
+}
+
+// A method of P2P that disconnects a random peer every so often, around
+// the average time between disconnects, until the host is closed
+func (p2p *P2P) chaosloop() {
+	for {
+		// Vary the time between the disconnects between half and one and a half times the average
+		wait := chaos.Disconnect/2 + time.Duration(rand.Int63n(int64(chaos.Disconnect)+1))
+
+		select {
+		case <-time.After(wait):
+		case <-p2p.Ctx.Done():
+			return
+		}
+
+		peers := p2p.Host.Network().Peers()
+		if len(peers) == 0 {
+			continue
+		}
+
+		victim := peers[rand.Intn(len(peers))]
+		p2p.Host.Network().ClosePeer(victim)
+
+		logrus.WithFields(logrus.Fields{
+			"peer": victim.Pretty(),
+		}).Infoln("Chaos Disconnected a Peer.")
+	}
+}
@@ -2,11 +2,17 @@ package src
 
 import (
 	"context"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/libp2p/go-libp2p-core/peer"
 	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
 )
 
 // Represents the default fallback room and user names
@@ -20,9 +26,12 @@ type ChatRoom struct {
 	Host *P2P
 
 	// Represents the channel of incoming messages
-	Inbound chan chatmessage
+	Inbound chan ChatMessage
 	// Represents the channel of outgoing messages
 	Outbound chan string
+	// Represents the channel of outgoing messages that the outbound
+	// transformers were already applied to, which the UI echoes
+	transformed chan string
 	// Represents the channel of chat log messages
 	Logs chan chatlog
 
@@ -30,6 +39,9 @@ type ChatRoom struct {
 	RoomName string
 	// Represent the name of the user in the chat room
 	UserName string
+	// Represents the user name of the profile, which UserName
+	// overrides if the user has another name in this room
+	basename string
 	// Represents the host ID of the peer
 	selfid peer.ID
 
@@ -37,31 +49,91 @@ type ChatRoom struct {
 	psctx context.Context
 	// Represents the chat room lifecycle cancellation function
 	pscancel context.CancelFunc
+	// Represents the guard that exits the chat room only once
+	exitonce sync.Once
 	// Represents the PubSub Topic of the ChatRoom
-	pstopic *pubsub.Topic
+	pstopic Topic
 	// Represents the PubSub Subscription for the topic
-	psub *pubsub.Subscription
+	psub Subscription
+	// Represents the legacy topic of the room and its subscription,
+	// which bridge older clients during the migration (nil if off)
+	legacy *legacyroom
+	// Represents the shared notes of the room (nil if they are not available)
+	notes *roomnotes
+	// Represents the whiteboard of the room (nil until it is opened), the
+	// guard that joins it once and the lock on it
+	board     *roomboard
+	boardonce sync.Once
+	boardlock sync.Mutex
+	// Represents the daemon client if the chat room is backed by a daemon
+	daemon *DaemonClient
+	// Represents whether the chat room is not joined yet, because the host is still starting
+	offline bool
+	// Represents the chunked messages that are being reassembled
+	chunks *chunkbuffer
+	// Represents the time the host last sent a message, for the slow mode
+	lastsent time.Time
+	// Represents the lock on the time of the last message
+	slowlock sync.Mutex
+	// Represents the room that the room is left for, read by the consumer of its events
+	moved chan *ChatRoom
+	// Represents the messages waiting to be sent until the room has peers
+	pending []string
+	// Represents the lock on the pending messages
+	pendinglock sync.Mutex
 }
 
 // A structure that represents a chat message
-type chatmessage struct {
+type ChatMessage struct {
 	Message    string `json:"message"`
 	SenderID   string `json:"senderid"`
 	SenderName string `json:"sendername"`
+	// Represents the protocol version of the sender (absent for legacy clients)
+	Protocol int `json:"protocol,omitempty"`
+
+	// Represents the ID of a received message, derived from the
+	// sequence number of its PubSub message (not sent to peers)
+	ID string `json:"-"`
+	// Represents the tags attached by the content filters (not sent to peers)
+	Tags []string `json:"-"`
+	// Represents the position of the message in a chunked message (absent if not chunked)
+	Chunk *messagechunk `json:"chunk,omitempty"`
+	// Represents the encrypted payload of a whisper (absent if not a whisper)
+	Whisper *whisper `json:"whisper,omitempty"`
+	// Represents whether a whisper was decrypted for the host (not sent to peers)
+	Whispered bool `json:"-"`
 }
 
 // A structure that represents a chat log
 type chatlog struct {
+	loglevel  logrus.Level
 	logprefix string
 	logmsg    string
 }
 
 // A constructor function that generates and returns a new
-// ChatRoom for a given P2PHost, username and roomname
-func JoinChatRoom(p2phost *P2P, username string, roomname string) (*ChatRoom, error) {
+// ChatRoom for a given P2PHost, username and roomname.
+// The chat room has its own context that is derived from the given context,
+// and the room is exited when that context is cancelled or the host is closed.
+func JoinChatRoom(ctx context.Context, p2phost *P2P, username string, roomname string) (*ChatRoom, error) {
+	// Check the provided roomname
+	if roomname == "" {
+		// Use the default room name
+		roomname = defaultroom
+	}
+
+	// Redial the peers seen in the room before, rather than waiting for discovery
+	p2phost.redialroom(roomname)
+	// Count the room in the statistics of the session
+	stats.joined(roomname)
+
+	// Ask the operators of the room to admit the host if the room has a join policy
+	if err := p2phost.admit(ctx, roomname, username); err != nil {
+		return nil, err
+	}
 
 	// Create a PubSub topic with the room name
-	topic, err := p2phost.PubSub.Join(fmt.Sprintf("room-peerchat-%s", roomname))
+	topic, err := p2phost.JoinTopic(roomtopic(roomname))
 	// Check the error
 	if err != nil {
 		return nil, err
@@ -71,6 +143,7 @@ func JoinChatRoom(p2phost *P2P, username string, roomname string) (*ChatRoom, er
 	sub, err := topic.Subscribe()
 	// Check the error
 	if err != nil {
+		p2phost.LeaveTopic(topic.String())
 		return nil, err
 	}
 
@@ -80,75 +153,194 @@ func JoinChatRoom(p2phost *P2P, username string, roomname string) (*ChatRoom, er
 		username = defaultuser
 	}
 
-	// Check the provided roomname
-	if roomname == "" {
-		// Use the default room name
-		roomname = defaultroom
-	}
-
-	// Create cancellable context
-	pubsubctx, cancel := context.WithCancel(context.Background())
+	// Create the context of the chat room
+	pubsubctx, cancel := context.WithCancel(ctx)
 
 	// Create a ChatRoom object
 	chatroom := &ChatRoom{
 		Host: p2phost,
 
-		Inbound:  make(chan chatmessage),
+		Inbound:  make(chan ChatMessage),
 		Outbound: make(chan string),
 		Logs:     make(chan chatlog),
+		moved:    make(chan *ChatRoom, 1),
+
+		transformed: make(chan string),
 
 		psctx:    pubsubctx,
 		pscancel: cancel,
 		pstopic:  topic,
 		psub:     sub,
+		chunks:   newchunkbuffer(),
 
 		RoomName: roomname,
-		UserName: username,
+		UserName: roomnicks.name(roomname, username),
+		basename: username,
 		selfid:   p2phost.Host.ID(),
 	}
 
+	// Enforce the settings of the room on its messages
+	p2phost.watchroom(roomname, topic)
+	// Bridge the older clients on the legacy topic of the room
+	chatroom.joinlegacy()
+	// Synchronize the shared notes of the room with its peers
+	chatroom.joinnotes()
+
+	// Exit the chat room when its context ends or the host is closed
+	go chatroom.watchcontext(p2phost.Ctx)
 	// Start the subscribe loop
 	go chatroom.SubLoop()
 	// Start the publish loop
 	go chatroom.PubLoop()
+	// Remember the peers of the room for the next time it is joined
+	go chatroom.recordpeers()
+	// Tell the consumer when the host reconnects to the network
+	go chatroom.reconnectloop()
+	// Log when the number of peers crosses the configured thresholds
+	go chatroom.watchpeercount()
 
 	// Return the chatroom
 	return chatroom, nil
 }
 
-// A method of ChatRoom that publishes a chatmessage
-// to the PubSub topic until the pubsub context closes
+// A method of ChatRoom that publishes a ChatMessage
+// to the PubSub topic until the pubsub context closes.
+// Messages sent while the room has no peers or the network
+// is down are held back and sent once the room has peers.
 func (cr *ChatRoom) PubLoop() {
+	pendingticker := time.NewTicker(pendinginterval)
+	defer pendingticker.Stop()
+
 	for {
 		select {
 		case <-cr.psctx.Done():
 			return
 
-		case message := <-cr.Outbound:
-			// Create a ChatMessage
-			m := chatmessage{
-				Message:    message,
-				SenderID:   cr.selfid.Pretty(),
-				SenderName: cr.UserName,
-			}
+		case <-pendingticker.C:
+			// Send the pending messages if the room has peers again
+			cr.flushpending()
 
-			// Marshal the ChatMessage into a JSON
-			messagebytes, err := json.Marshal(m)
-			if err != nil {
-				cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not marshal JSON"}
+		case message := <-cr.Outbound:
+			// Apply the outbound transformers
+			message, ok := pipeline.transform(cr.RoomName, message)
+			if !ok {
 				continue
 			}
 
-			// Publish the message to the topic
-			err = cr.pstopic.Publish(cr.psctx, messagebytes)
-			if err != nil {
-				cr.Logs <- chatlog{logprefix: "puberr", logmsg: "could not publish to topic"}
-				continue
-			}
+			cr.publishtext(message)
+
+		case message := <-cr.transformed:
+			cr.publishtext(message)
 		}
 	}
 }
 
+// A method of ChatRoom that publishes a message that the outbound transformers
+// were applied to, or holds it back if it cannot be published right now
+func (cr *ChatRoom) publishtext(message string) {
+	// Hold the message back behind the pending messages, or if no peer would receive it
+	if cr.Pending() > 0 {
+		cr.holdback(message, "earlier messages are pending")
+		return
+	}
+	if len(cr.PeerList()) == 0 {
+		cr.holdback(message, "the room has no peers")
+		return
+	}
+
+	// Publish the ChatMessage to the topic
+	if err := cr.publish(cr.outgoing(message)); err != nil {
+		cr.holdback(message, err.Error())
+		return
+	}
+	stats.count(1, 0)
+	transcripts.write(cr.RoomName, cr.UserName, message)
+
+	// Remember the links of the message to serve their previews
+	cr.Host.rememberlinks(message)
+}
+
+// A method of ChatRoom that returns the ChatMessage of a message sent by the host
+func (cr *ChatRoom) outgoing(message string) ChatMessage {
+	return ChatMessage{
+		Message:    message,
+		SenderID:   cr.selfid.Pretty(),
+		SenderName: cr.UserName,
+	}
+}
+
+// A function that returns the PubSub topic name for a chat room name in the
+// network, which is derived from an HMAC of the room name if topics are hashed
+func roomtopic(roomname string) string {
+	if hashingtopics() {
+		return hashtopic(roomname)
+	}
+
+	return topicprefix() + roomname
+}
+
+// A function that returns the chat room name of a PubSub topic name, or
+// false if the topic is not the topic of a chat room in the network
+func topicroom(topic string) (string, bool) {
+	if hashingtopics() {
+		return hashedroom(topic)
+	}
+
+	prefix := topicprefix()
+	if !strings.HasPrefix(topic, prefix) {
+		return "", false
+	}
+
+	return strings.TrimPrefix(topic, prefix), true
+}
+
+// A function that marshals a ChatMessage into a JSON and publishes it to a topic.
+// Messages longer than chunklength are published as chunks that receivers
+// reassemble, and messages longer than maxmessagelength are rejected.
+func publish(ctx context.Context, topic Topic, msg ChatMessage) error {
+	if len(msg.Message) > maxmessagelength {
+		return fmt.Errorf("message is too long (%d bytes, at most %d)", len(msg.Message), maxmessagelength)
+	}
+
+	// Mark the message with the protocol version
+	msg.Protocol = protocolversion
+
+	parts := splitmessage(msg.Message)
+	if len(parts) == 1 {
+		return publishmessage(ctx, topic, msg)
+	}
+
+	// Publish the chunks of the message in order
+	id := chunkid()
+	for index, part := range parts {
+		chunk := msg
+		chunk.Message = part
+		chunk.Chunk = &messagechunk{ID: id, Index: index, Total: len(parts)}
+
+		if err := publishmessage(ctx, topic, chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A function that marshals a single ChatMessage into a JSON and publishes it to a topic
+func publishmessage(ctx context.Context, topic Topic, msg ChatMessage) error {
+	// Marshal the ChatMessage into a JSON
+	messagebytes, err := json.Marshal(msg)
+	if err != nil {
+		return errors.New("could not marshal JSON")
+	}
+
+	// Publish the message to the topic
+	if err := topic.Publish(ctx, messagebytes); err != nil {
+		return errors.New("could not publish to topic")
+	}
+
+	return nil
+}
+
 // A method of ChatRoom that continously reads from the subscription
 // until either the subscription or pubsub context closes.
 // The recieved message is parsed sent into the inbound channel
@@ -164,51 +356,196 @@ func (cr *ChatRoom) SubLoop() {
 			message, err := cr.psub.Next(cr.psctx)
 			// Check error
 			if err != nil {
+				// Stop reading the legacy topic before the messages queue is closed
+				cr.leavelegacy()
+				cr.waitlegacy()
+				// Report the subscription closing unless the room was exited
+				cr.log(logrus.ErrorLevel, "suberr", "subscription has closed")
 				// Close the messages queue (subscription has closed)
 				close(cr.Inbound)
-				cr.Logs <- chatlog{logprefix: "suberr", logmsg: "subscription has closed"}
 				return
 			}
 
-			// Check if message is from self
-			if message.ReceivedFrom == cr.selfid {
-				continue
-			}
+			cr.deliver(message)
+		}
+	}
+}
 
-			// Declare a ChatMessage
-			cm := &chatmessage{}
-			// Unmarshal the message data into a ChatMessage
-			err = json.Unmarshal(message.Data, cm)
-			if err != nil {
-				cr.Logs <- chatlog{logprefix: "suberr", logmsg: "could not unmarshal JSON"}
-				continue
-			}
+// A method of ChatRoom that parses a message received
+// from the subscription and sends it into the inbound channel
+func (cr *ChatRoom) deliver(message *pubsub.Message) {
+	// Check if message is from self
+	if message.ReceivedFrom == cr.selfid {
+		return
+	}
 
-			// Send the ChatMessage into the message queue
-			cr.Inbound <- *cm
+	// Declare a ChatMessage
+	cm := &ChatMessage{}
+	// Unmarshal the message data into a ChatMessage
+	err := json.Unmarshal(message.Data, cm)
+	if err != nil {
+		cr.log(logrus.ErrorLevel, "suberr", "could not unmarshal JSON")
+		return
+	}
+	cm.ID = hex.EncodeToString(message.GetSeqno())
+
+	// Identify the sender by the key that signed the message
+	sender, err := peer.IDFromBytes(message.GetFrom())
+	if err != nil {
+		return
+	}
+	cm.SenderID = sender.Pretty()
+
+	// Reassemble chunked messages once all their chunks have been received
+	if cm.Chunk != nil {
+		complete, ok, err := cr.chunks.add(sender, *cm)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  cr.RoomName,
+				"peer":  cm.SenderID,
+			}).Debugln("Dropped a Message Chunk.")
+			return
+		}
+		if !ok {
+			return
 		}
+
+		*cm = complete
+	}
+
+	// Decrypt the whispers addressed to the host
+	if cm.Whisper != nil {
+		cr.openwhisper(cm)
+	}
+
+	// Handshake with new senders and warn once about newer protocols
+	if cr.Host.observe(sender, cm.protocol()) {
+		cr.log(logrus.WarnLevel, "protocol", fmt.Sprintf("%s speaks a newer protocol (v%d) than this client (v%d), consider upgrading", cm.SenderName, cm.protocol(), protocolversion))
+	}
+
+	// Warn once about senders whose clients lack features of this client
+	if missing := cr.Host.incompatible(sender); len(missing) > 0 {
+		cr.log(logrus.WarnLevel, "protocol", fmt.Sprintf("%s runs a client without support for %s", cm.SenderName, strings.Join(missing, ", ")))
+	}
+
+	// Apply the inbound filters
+	filtered, ok := pipeline.filter(cr.RoomName, *cm)
+	if !ok {
+		return
+	}
+
+	// Send the ChatMessage into the message queue, unless the room is exited
+	select {
+	case cr.Inbound <- filtered:
+		stats.count(0, 1)
+		transcripts.write(cr.RoomName, filtered.SenderName, filtered.Message)
+	case <-cr.psctx.Done():
 	}
 }
 
+// A method of ChatRoom that sends a log to the consumer
+// of the events of the room, unless the room is exited first
+func (cr *ChatRoom) log(level logrus.Level, prefix string, msg string) {
+	select {
+	case cr.Logs <- chatlog{loglevel: level, logprefix: prefix, logmsg: msg}:
+	case <-cr.psctx.Done():
+	}
+}
+
+// A method of ChatRoom that exits the chat room once its context
+// is cancelled or the host context ends, releasing its topics
+func (cr *ChatRoom) watchcontext(hostctx context.Context) {
+	select {
+	case <-cr.psctx.Done():
+	case <-hostctx.Done():
+	}
+
+	cr.Exit()
+}
+
 // A method of ChatRoom that returns a list
 // of all peer IDs connected to it
 func (cr *ChatRoom) PeerList() []peer.ID {
-	// Return the slice of peer IDs connected to chat room topic
-	return cr.pstopic.ListPeers()
+	// Retrieve the peers from the daemon if the chat room is backed by one
+	if cr.daemon != nil {
+		return cr.daemonpeers()
+	}
+	// There are no peers until the chat room is joined
+	if cr.offline {
+		return nil
+	}
+
+	// Return the slice of peer IDs connected to chat room topic,
+	// including the older clients on the legacy topic of the room
+	return append(cr.pstopic.ListPeers(), cr.legacypeers()...)
 }
 
-// A method of ChatRoom that updates the chat
-// room by subscribing to the new topic
+// A method of ChatRoom that exits the chat room, cancelling its
+// context and releasing its topics. Exiting a room more than once
+// has no effect.
 func (cr *ChatRoom) Exit() {
+	cr.exitonce.Do(cr.exit)
+}
+
+// A method of ChatRoom that stops the loops of
+// the chat room and releases its topic handlers
+func (cr *ChatRoom) exit() {
 	defer cr.pscancel()
 
+	// Only stop the stream if the chat room is backed by a daemon,
+	// and only stop the loop if the chat room was never joined
+	if cr.daemon != nil || cr.offline {
+		return
+	}
+
 	// Cancel the existing subscription
 	cr.psub.Cancel()
-	// Close the topic handler
-	cr.pstopic.Close()
+	// Release the topic handler
+	cr.Host.LeaveTopic(cr.pstopic.String())
+	// Leave the legacy topic of the room
+	cr.leavelegacy()
+	// Leave the notes topic of the room
+	cr.leavenotes()
+	// Leave the whiteboard topic of the room, if it was opened
+	cr.leaveboard()
+	// Close the transcript of the room
+	transcripts.close(cr.RoomName)
+}
+
+// A method of ChatRoom that joins a new chat room on the same host
+// with the same user name and then exits the current chat room.
+// The current chat room is left intact if the new room cannot be joined.
+// The context of the new chat room is derived from the given context.
+func (cr *ChatRoom) Jump(ctx context.Context, roomname string) (*ChatRoom, error) {
+	// Create a new chatroom and join it
+	newchatroom, err := cr.join(ctx, roomname)
+	if err != nil {
+		return nil, err
+	}
+
+	// Move the consumer of the events to the new chatroom and exit the current one
+	cr.moveto(newchatroom)
+	cr.Exit()
+	return newchatroom, nil
+}
+
+// A method of ChatRoom that joins a new chat room in the same way as the current
+// one, either on the same host or on the same daemon, with the same user name
+func (cr *ChatRoom) join(ctx context.Context, roomname string) (*ChatRoom, error) {
+	if cr.offline {
+		return nil, errors.New("the network is still starting")
+	}
+	if cr.daemon != nil {
+		return JoinDaemonChatRoom(ctx, cr.daemon, roomname)
+	}
+
+	return JoinChatRoom(ctx, cr.Host, cr.basename, roomname)
 }
 
-// A method of ChatRoom that updates the chat user name
+// A method of ChatRoom that updates the chat user name of the
+// profile, which applies unless the user has another name in the room
 func (cr *ChatRoom) UpdateUser(username string) {
-	cr.UserName = username
+	cr.basename = username
+	cr.UserName = roomnicks.name(cr.RoomName, username)
 }
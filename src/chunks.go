@@ -0,0 +1,185 @@
+package src
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Represents the maximum length of a chat message in bytes. Longer
+// messages are rejected instead of being dropped by the PubSub limits.
+const maxmessagelength = 64 * 1024
+
+// Represents the maximum length of the text of a single published message.
+// Longer messages are split into chunks that receivers reassemble.
+const chunklength = 4 * 1024
+
+// Represents the maximum number of chunks of a message
+const maxchunks = (maxmessagelength + chunklength - 1) / chunklength
+
+// Represents the time after which an incomplete chunked message is discarded
+const chunktimeout = time.Minute
+
+// Represents the maximum number of incomplete chunked messages kept per sender
+const maxpendingchunks = 4
+
+// Represents the maximum number of incomplete chunked messages kept per room
+const maxpendingroomchunks = 256
+
+// A structure that represents the position of a chunk in a chunked message.
+// Clients that predate chunking display each chunk as a separate message.
+type messagechunk struct {
+	// Represents the ID shared by all the chunks of the message
+	ID string `json:"id"`
+	// Represents the index of the chunk
+	Index int `json:"index"`
+	// Represents the number of chunks of the message
+	Total int `json:"total"`
+}
+
+// A structure that represents an incomplete chunked message
+type pendingmessage struct {
+	// Represents the text of the chunks received so far
+	parts []string
+	// Represents whether each chunk was received
+	got []bool
+	// Represents the number of chunks received
+	received int
+	// Represents the length of the text received so far
+	length int
+	// Represents the time the first chunk was received
+	started time.Time
+}
+
+// A structure that represents the chunked messages of a
+// chat room that are being reassembled, by sender and chunk ID
+type chunkbuffer struct {
+	// Represents the incomplete messages of each sender
+	pending map[peer.ID]map[string]*pendingmessage
+	// Represents the number of incomplete messages
+	count int
+	// Represents the lock on the incomplete messages
+	mutex sync.Mutex
+}
+
+// A constructor function that generates and returns an empty chunkbuffer
+func newchunkbuffer() *chunkbuffer {
+	return &chunkbuffer{pending: make(map[peer.ID]map[string]*pendingmessage)}
+}
+
+// A function that splits the text of a message into chunks of at most
+// chunklength bytes, without splitting multi-byte characters
+func splitmessage(text string) []string {
+	var chunks []string
+
+	for len(text) > chunklength {
+		cut := chunklength
+		for cut > 0 && !utf8.RuneStart(text[cut]) {
+			cut--
+		}
+
+		chunks = append(chunks, text[:cut])
+		text = text[cut:]
+	}
+
+	return append(chunks, text)
+}
+
+// A function that generates a random ID for the chunks of a message
+func chunkid() string {
+	id := make([]byte, 8)
+	rand.Read(id)
+
+	return hex.EncodeToString(id)
+}
+
+// A method of chunkbuffer that adds a chunk of a message from a sender.
+// Returns the reassembled message once all its chunks have been received.
+// Each sender can only have a few incomplete messages at a time, so one
+// sender cannot keep the chunked messages of the others from the room.
+func (buffer *chunkbuffer) add(sender peer.ID, msg ChatMessage) (ChatMessage, bool, error) {
+	chunk := msg.Chunk
+	if chunk.Total < 1 || chunk.Total > maxchunks || chunk.Index < 0 || chunk.Index >= chunk.Total {
+		return ChatMessage{}, false, fmt.Errorf("invalid chunk %d of %d", chunk.Index, chunk.Total)
+	}
+	if len(msg.Message) > chunklength {
+		return ChatMessage{}, false, fmt.Errorf("chunk is too long (%d bytes, at most %d)", len(msg.Message), chunklength)
+	}
+
+	buffer.mutex.Lock()
+	defer buffer.mutex.Unlock()
+
+	// Discard the messages that were never completed
+	now := time.Now()
+	for from, messages := range buffer.pending {
+		for id, pending := range messages {
+			if now.Sub(pending.started) > chunktimeout {
+				buffer.discard(from, id)
+			}
+		}
+	}
+
+	pending, ok := buffer.pending[sender][chunk.ID]
+	if !ok {
+		if len(buffer.pending[sender]) >= maxpendingchunks {
+			return ChatMessage{}, false, fmt.Errorf("too many incomplete messages from the sender")
+		}
+		if buffer.count >= maxpendingroomchunks {
+			return ChatMessage{}, false, fmt.Errorf("too many incomplete messages")
+		}
+
+		if buffer.pending[sender] == nil {
+			buffer.pending[sender] = make(map[string]*pendingmessage)
+		}
+
+		pending = &pendingmessage{parts: make([]string, chunk.Total), got: make([]bool, chunk.Total), started: now}
+		buffer.pending[sender][chunk.ID] = pending
+		buffer.count++
+	}
+
+	if len(pending.parts) != chunk.Total {
+		buffer.discard(sender, chunk.ID)
+		return ChatMessage{}, false, fmt.Errorf("inconsistent chunks")
+	}
+
+	if !pending.got[chunk.Index] {
+		if pending.length+len(msg.Message) > maxmessagelength {
+			buffer.discard(sender, chunk.ID)
+			return ChatMessage{}, false, fmt.Errorf("message is too long (more than %d bytes)", maxmessagelength)
+		}
+
+		pending.parts[chunk.Index] = msg.Message
+		pending.got[chunk.Index] = true
+		pending.length += len(msg.Message)
+		pending.received++
+	}
+
+	if pending.received < chunk.Total {
+		return ChatMessage{}, false, nil
+	}
+
+	buffer.discard(sender, chunk.ID)
+
+	msg.Message = strings.Join(pending.parts, "")
+	msg.Chunk = nil
+	return msg, true, nil
+}
+
+// A method of chunkbuffer that removes an incomplete message of a sender
+func (buffer *chunkbuffer) discard(sender peer.ID, id string) {
+	if _, ok := buffer.pending[sender][id]; !ok {
+		return
+	}
+
+	delete(buffer.pending[sender], id)
+	if len(buffer.pending[sender]) == 0 {
+		delete(buffer.pending, sender)
+	}
+	buffer.count--
+}
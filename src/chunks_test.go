@@ -0,0 +1,46 @@
+package src
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestChunkBuffer(t *testing.T) {
+	buffer := newchunkbuffer()
+	chunk := func(id string, index, total int, text string) ChatMessage {
+		return ChatMessage{Message: text, Chunk: &messagechunk{ID: id, Index: index, Total: total}}
+	}
+
+	// A message is reassembled once all its chunks arrived, in any order
+	if _, ok, err := buffer.add(peer.ID("alice"), chunk("a", 1, 2, "world")); ok || err != nil {
+		t.Fatalf("first chunk returned %v, %v", ok, err)
+	}
+	msg, ok, err := buffer.add(peer.ID("alice"), chunk("a", 0, 2, "hello "))
+	if !ok || err != nil || msg.Message != "hello world" || msg.Chunk != nil {
+		t.Fatalf("got %q, %v, %v, want the reassembled message", msg.Message, ok, err)
+	}
+
+	// Empty chunks are counted once
+	buffer.add(peer.ID("alice"), chunk("e", 0, 2, ""))
+	if _, ok, _ := buffer.add(peer.ID("alice"), chunk("e", 0, 2, "")); ok {
+		t.Error("a repeated empty chunk completed the message")
+	}
+
+	// Chunks longer than chunklength are rejected
+	if _, _, err := buffer.add(peer.ID("alice"), chunk("b", 0, 2, strings.Repeat("x", chunklength+1))); err == nil {
+		t.Error("an oversized chunk was accepted")
+	}
+
+	// A sender can only keep a few incomplete messages
+	for i := 0; i < maxpendingchunks; i++ {
+		buffer.add(peer.ID("mallory"), chunk(string(rune('a'+i)), 0, 2, "x"))
+	}
+	if _, _, err := buffer.add(peer.ID("mallory"), chunk("z", 0, 2, "x")); err == nil {
+		t.Error("a sender kept more than maxpendingchunks incomplete messages")
+	}
+	if _, _, err := buffer.add(peer.ID("bob"), chunk("z", 0, 2, "x")); err != nil {
+		t.Errorf("another sender was refused: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package src
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+)
+
+// Represents the largest clipboard contents that can be sent with /clip
+const maxclip = 16 * 1024
+
+// Represents the number of received room messages kept for /copy
+const recentmessages = 200
+
+// Represents the number of messages listed by /copy without a message ID
+const listedcopies = 10
+
+// A function that returns the commands that print the contents of
+// the clipboard on the platform, in the order they are tried
+func pastecommands() [][]string {
+	switch runtime.GOOS {
+	case "darwin":
+		return [][]string{{"pbpaste"}}
+	case "windows":
+		return [][]string{{"powershell", "-NoProfile", "-Command", "Get-Clipboard -Raw"}}
+	default:
+		commands := [][]string{{"xclip", "-selection", "clipboard", "-o"}, {"xsel", "--clipboard", "--output"}}
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			commands = append([][]string{{"wl-paste", "--no-newline"}}, commands...)
+		}
+		return commands
+	}
+}
+
+// A function that reads the text in the clipboard with the first paste
+// command of the platform that is installed. Terminals do not let programs
+// read the clipboard with escape sequences, unlike writing it (OSC 52).
+func readclipboard() (string, error) {
+	for _, command := range pastecommands() {
+		if _, err := exec.LookPath(command[0]); err != nil {
+			continue
+		}
+
+		output, err := exec.Command(command[0], command[1:]...).Output()
+		if err != nil {
+			return "", fmt.Errorf("%s failed - %w", command[0], err)
+		}
+		return strings.TrimRight(string(output), "\r\n"), nil
+	}
+
+	return "", errors.New("no clipboard tool found (install xclip, xsel or wl-clipboard)")
+}
+
+// A function that generates an OSC 52 escape sequence that places text in the
+// clipboard of the terminal, which also works over SSH. The sequence is wrapped
+// in a DCS passthrough when running inside tmux or screen.
+func clipboardsequence(text string) string {
+	sequence := fmt.Sprintf("\033]52;c;%s\a", base64.StdEncoding.EncodeToString([]byte(text)))
+
+	switch {
+	case os.Getenv("TMUX") != "":
+		return "\033Ptmux;" + strings.ReplaceAll(sequence, "\033", "\033\033") + "\033\\"
+	case os.Getenv("STY") != "":
+		return "\033P" + sequence + "\033\\"
+	default:
+		return sequence
+	}
+}
+
+// A method of UI that keeps a received room message for /copy
+func (ui *UI) recordrecent(msg ChatMessage) {
+	if msg.ID == "" || msg.Whisper != nil && !msg.Whispered {
+		return
+	}
+
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	ui.recent = append(ui.recent, msg)
+	if len(ui.recent) > recentmessages {
+		ui.recent = ui.recent[len(ui.recent)-recentmessages:]
+	}
+}
+
+// A method of UI that returns the received messages that can be copied, from
+// the shown direct message pane or else from the room, with their IDs.
+// The messages are returned oldest first.
+func (ui *UI) copyable() ([]string, []ChatMessage) {
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	var ids []string
+	var messages []ChatMessage
+
+	if pane, ok := ui.dms[ui.activedm]; ok && ui.activedm != "" {
+		for _, entry := range pane.entries {
+			if entry.notice == "" && entry.msg.SenderID == pane.peer.Pretty() {
+				ids = append(ids, entry.id)
+				messages = append(messages, entry.msg)
+			}
+		}
+		return ids, messages
+	}
+
+	for _, msg := range ui.recent {
+		ids = append(ids, msg.ID)
+		messages = append(messages, msg)
+	}
+	return ids, messages
+}
+
+// A method of UI that places the text of a received message in the clipboard
+// of the terminal. The message is found by a unique prefix of its ID.
+func (ui *UI) copymessage(prefix string) (ChatMessage, error) {
+	ids, messages := ui.copyable()
+
+	var found []ChatMessage
+	for index, id := range ids {
+		if strings.HasPrefix(id, prefix) {
+			found = append(found, messages[index])
+		}
+	}
+
+	switch len(found) {
+	case 0:
+		return ChatMessage{}, fmt.Errorf("no recent message has the ID %s", prefix)
+	case 1:
+	default:
+		return ChatMessage{}, fmt.Errorf("more than one message has an ID starting with %s", prefix)
+	}
+
+	// Code blocks are copied without their fences
+	text := found[0].Message
+	if lines, ok := codeblocklines(text); ok {
+		text = strings.Join(lines, "\n")
+	}
+
+	os.Stdout.WriteString(clipboardsequence(text))
+	return found[0], nil
+}
+
+// A method of UI that asks whether the contents of the clipboard are sent to
+// a peer or, if the peer is empty, to the room. Multi-line contents are sent
+// as a code block. Must be called from the tview event loop.
+func (ui *UI) confirmclip(peerid peer.ID, text string) {
+	target := fmt.Sprintf("the room '%s'", ui.RoomName)
+	if peerid != "" {
+		ui.stateLock.Lock()
+		name, ok := ui.nicks[peerid]
+		ui.stateLock.Unlock()
+
+		if !ok {
+			name = peerid.ShortString()
+		}
+		target = name
+	}
+
+	// Preview the start of the contents
+	lines := strings.Split(text, "\n")
+	preview := strings.Join(lines[:minint(len(lines), 3)], "\n")
+	if len(preview) > 200 || len(lines) > 3 {
+		preview = preview[:minint(len(preview), 200)] + "\n..."
+	}
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("Send the clipboard (%d bytes, %d lines) to %s?\n\n%s", len(text), len(lines), sanitize(target), sanitize(preview))).
+		AddButtons([]string{"Send", "Cancel"}).
+		SetDoneFunc(func(index int, label string) {
+			ui.pages.RemovePage("clip")
+			ui.TerminalApp.SetFocus(ui.inputBox)
+
+			if label != "Send" {
+				return
+			}
+
+			message := text
+			if len(lines) > 1 {
+				message = formatcodeblock(lines)
+			}
+
+			// Send the message outside the event loop, since it is displayed through it
+			go func() {
+				if peerid != "" {
+					ui.senddm(peerid, message)
+					return
+				}
+				ui.MsgInputs <- message
+			}()
+		})
+
+	ui.pages.AddPage("clip", modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
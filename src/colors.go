@@ -0,0 +1,40 @@
+package src
+
+import (
+	"hash/fnv"
+	"strings"
+)
+
+// Represents the default palette of colors used for the nicks of peers.
+// Blue and yellow are left out as they represent self messages and logs.
+var defaultpalette = []string{
+	"red", "green", "fuchsia", "orange", "aqua", "lime",
+	"violet", "coral", "teal", "gold", "orchid", "springgreen",
+}
+
+// Represents the colorblind-friendly palette of colors used for the nicks
+// of peers. Derived from the Okabe-Ito palette, excluding the blues and yellow.
+var colorblindpalette = []string{
+	"#E69F00", "#009E73", "#D55E00", "#CC79A7", "#999999",
+}
+
+// A function that returns the nick color palette for a given palette name, in
+// any case. Falls back to the default palette if the name is not recognized.
+func nickpalette(name string) []string {
+	if strings.EqualFold(name, "colorblind") {
+		return colorblindpalette
+	}
+
+	return defaultpalette
+}
+
+// A function that returns a stable color from a given palette for a peer ID.
+// The peer ID is hashed with FNV-1a so that the same peer always gets the same color.
+func nickcolor(palette []string, peerid string) string {
+	// Hash the peer ID
+	hasher := fnv.New32a()
+	hasher.Write([]byte(peerid))
+
+	// Pick the color from the palette
+	return palette[hasher.Sum32()%uint32(len(palette))]
+}
@@ -0,0 +1,1771 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// A type that represents the handler function of a UI command.
+// The handler recieves the UI and the argument string of the command.
+type CommandHandler func(ui *UI, arg string)
+
+// A structure that represents a UI command
+type Command struct {
+	// Represents the name of the command (including the slash)
+	Name string
+	// Represents the alternative names of the command (including the slash)
+	Aliases []string
+	// Represents the usage of the command arguments
+	Args string
+	// Represents a short description of the command
+	Help string
+	// Represents a detailed description of the command
+	Details string
+	// Represents the handler function of the command
+	Handler CommandHandler
+}
+
+// A structure that represents a registry of UI commands
+// that maps command names and aliases to the commands
+type commandregistry struct {
+	// Represents the commands in the order of registration
+	commands []*Command
+	// Represents the mapping of names and aliases to commands
+	index map[string]*Command
+
+	// Represents the lock on the registry
+	mutex sync.RWMutex
+}
+
+// Represents the registry of all supported UI commands
+var registry = &commandregistry{index: make(map[string]*Command)}
+
+// A function that registers a new UI command so that it can be invoked
+// from the input box and is listed by /help. Returns an error if the
+// command has no handler or if its name or an alias is already taken.
+func RegisterCommand(cmd Command) error {
+	return registry.register(cmd)
+}
+
+// A method of commandregistry that registers a command
+func (reg *commandregistry) register(cmd Command) error {
+	reg.mutex.Lock()
+	defer reg.mutex.Unlock()
+
+	// Check the command handler
+	if cmd.Handler == nil {
+		return fmt.Errorf("command '%s' has no handler", cmd.Name)
+	}
+
+	// Check the command name and aliases
+	names := append([]string{cmd.Name}, cmd.Aliases...)
+	for _, name := range names {
+		if !strings.HasPrefix(name, "/") {
+			return fmt.Errorf("command name '%s' must start with a slash", name)
+		}
+
+		if _, ok := reg.index[name]; ok {
+			return fmt.Errorf("command name '%s' is already registered", name)
+		}
+	}
+
+	// Add the command to the registry
+	command := &cmd
+	reg.commands = append(reg.commands, command)
+	for _, name := range names {
+		reg.index[name] = command
+	}
+
+	return nil
+}
+
+// A method of commandregistry that returns the command for a name or alias.
+// The leading slash of the name is optional. Returns false if not found.
+func (reg *commandregistry) lookup(name string) (*Command, bool) {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	// Add the leading slash if missing
+	if !strings.HasPrefix(name, "/") {
+		name = "/" + name
+	}
+
+	command, ok := reg.index[name]
+	return command, ok
+}
+
+// A method of commandregistry that returns all commands in registration order
+func (reg *commandregistry) list() []*Command {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	return append([]*Command{}, reg.commands...)
+}
+
+// A method of commandregistry that returns the names and aliases that
+// closely resemble a given unknown command name, closest first
+func (reg *commandregistry) suggest(name string) []string {
+	reg.mutex.RLock()
+	defer reg.mutex.RUnlock()
+
+	// Collect all names within an edit distance of 2 or with a matching prefix
+	distances := make(map[string]int)
+	for candidate := range reg.index {
+		distance := editdistance(name, candidate)
+		if distance <= 2 || (len(name) > 2 && strings.HasPrefix(candidate, name)) {
+			distances[candidate] = distance
+		}
+	}
+
+	// Sort the suggestions by distance and then by name
+	suggestions := make([]string, 0, len(distances))
+	for candidate := range distances {
+		suggestions = append(suggestions, candidate)
+	}
+
+	sort.Slice(suggestions, func(i, j int) bool {
+		if distances[suggestions[i]] != distances[suggestions[j]] {
+			return distances[suggestions[i]] < distances[suggestions[j]]
+		}
+		return suggestions[i] < suggestions[j]
+	})
+
+	return suggestions
+}
+
+// A method of Command that returns its usage string
+func (cmd *Command) usage() string {
+	if cmd.Args == "" {
+		return cmd.Name
+	}
+
+	return cmd.Name + " " + cmd.Args
+}
+
+// A function that returns the Levenshtein edit distance between two strings
+func editdistance(a, b string) int {
+	// Represents the previous and current rows of the distance matrix
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+
+			curr[j] = smallest(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev, curr = curr, prev
+	}
+
+	return prev[len(b)]
+}
+
+// A function that returns the smallest of the given integers
+func smallest(first int, rest ...int) int {
+	for _, value := range rest {
+		if value < first {
+			first = value
+		}
+	}
+
+	return first
+}
+
+// Register the built-in UI commands
+func init() {
+	builtins := []Command{
+		{
+			Name:    "/help",
+			Aliases: []string{"/h", "/?"},
+			Args:    "[command]",
+			Help:    "list all commands or show the details of a command",
+			Details: "Lists all the supported commands with their arguments. When a command is given, its detailed usage is shown instead.",
+			Handler: helpcommand,
+		},
+		{
+			Name:    "/quit",
+			Aliases: []string{"/exit", "/q"},
+			Help:    "quit the chat",
+			Details: "Asks for confirmation, then exits the chat room and closes the application. Pressing Ctrl-C does the same.",
+			Handler: quitcommand,
+		},
+		{
+			Name:    "/clear",
+			Aliases: []string{"/cls"},
+			Help:    "clear the chat",
+			Details: "Clears all the messages from the message box.",
+			Handler: clearcommand,
+		},
+		{
+			Name:    "/room",
+			Aliases: []string{"/join"},
+			Args:    "<roomname>",
+			Help:    "change chat room",
+			Details: "Leaves the current chat room and joins the given chat room.",
+			Handler: roomcommand,
+		},
+		{
+			Name:    "/rooms",
+			Help:    "show the room tree",
+			Details: "Shows the rooms in the tabs as a tree of namespaces and their sub-rooms, along with the channels listed in the room settings. Sub-rooms such as project/dev have their own topic but share the settings and members of their namespace (project). Selecting a room switches to it.",
+			Handler: roomscommand,
+		},
+		{
+			Name:    "/user",
+			Aliases: []string{"/nick"},
+			Args:    "[here] <username>",
+			Help:    "change user name",
+			Details: "Changes the user name displayed to other peers for all subsequent messages. With 'here', the user name only applies to the current room and is remembered for it, so you can have another name in each room. '/nick here' without a name goes back to the user name of the profile in the current room.",
+			Handler: usercommand,
+		},
+		{
+			Name:    "/whois",
+			Args:    "<username|peerid>",
+			Help:    "show the details of a peer",
+			Details: "Shows the user name, peer ID, connection status and addresses of a peer in the chat room. The peer can be given by user name or by the peer ID suffix shown in the peer box (at least 6 characters). Clicking a peer in the peer box does the same.",
+			Handler: whoiscommand,
+		},
+		{
+			Name:    "/members",
+			Aliases: []string{"/peers"},
+			Help:    "list the members of the room",
+			Details: "Shows the members of the current room in a table with their user name, short peer ID, connection status, latency and connection type (transport and direction). Press 1 to 5 to sort the table by a column.",
+			Handler: memberscommand,
+		},
+		{
+			Name:    "/dm",
+			Aliases: []string{"/msg"},
+			Args:    "<peer> [text]",
+			Help:    "open a direct message pane",
+			Details: "Opens the direct message conversation with a peer (user name, peer ID suffix or full peer ID) in its own tab and sends the text if one is given. While the pane is shown, everything typed is sent to the peer, who sees that you are typing. Direct messages are sent over an encrypted stream between the two peers and never touch the room. Click the room tab to go back to the room.",
+			Handler: dmcommand,
+		},
+		{
+			Name:    "/play",
+			Args:    "<peer> <game> | accept | decline | quit | <move>",
+			Help:    "play a game with a peer",
+			Details: "Invites a peer to a game of 'tictactoe' or 'trivia', played in the direct message pane of the peer. 'accept' and 'decline' answer an invitation, and 'quit' leaves the game. Any other argument is a move in the game with the peer of the shown pane: the number of a cell in tic-tac-toe, or a, b, c or d in trivia.",
+			Handler: playcommand,
+		},
+		{
+			Name:    "/voice",
+			Args:    "[peer,peer,... | talk | leave]",
+			Help:    "talk to peers in the voice channel (experimental)",
+			Details: "An experimental voice channel with a voice stream to each of its peers. '/voice <peer,peer,...>' adds peers to the channel, and they hear you once they add you as well. The push-to-talk key (F4 by default) or '/voice talk' starts and stops talking, for at most a minute at a time. '/voice leave' leaves the channel, and '/voice' lists its peers. The microphone is captured and the voice is played by the commands under 'voice' in the configuration (ffmpeg and ffplay by default).",
+			Handler: voicecommand,
+		},
+		{
+			Name:    "/schedule",
+			Args:    "<when> <text>",
+			Help:    "send a message to the room later",
+			Details: "Holds a message and sends it to the current room when it is due. The time is a delay such as 'in 2h' or 'in 1d12h', a time of the day such as 'at 15:30' or 'tomorrow 9:00', or a quoted date such as \"2021-07-01 15:30\", e.g. '/schedule \"in 2h\" standup is starting'. The message is held by the client until it is due and sent once the room is shown, or by the daemon when attached to one. '/scheduled' lists the scheduled messages.",
+			Handler: schedulecommand,
+		},
+		{
+			Name:    "/remind",
+			Args:    "<when> <text>",
+			Help:    "remind yourself of something later",
+			Details: "Shows a reminder in the log pane and notifies you when it is due. The time is given as for '/schedule', e.g. '/remind \"in 20m\" check the build'. Reminders are kept by the client, and the reminders that fell due while it was closed are shown when it starts.",
+			Handler: remindcommand,
+		},
+		{
+			Name:    "/scheduled",
+			Args:    "[cancel <id>]",
+			Help:    "list or cancel the scheduled messages",
+			Details: "Lists the scheduled messages and reminders with their IDs, and the messages held by the daemon when attached to one. 'cancel' cancels the message with the given ID, of which a unique prefix is enough.",
+			Handler: scheduledcommand,
+		},
+		{
+			Name:    "/clip",
+			Args:    "[peer]",
+			Help:    "send the contents of the clipboard",
+			Details: "Reads the clipboard with the clipboard tool of the platform (pbpaste, wl-paste, xclip or xsel) and asks before sending it to a peer as a direct message, or to the shown direct message pane or the room without a peer. Multi-line contents are sent as a code block. At most 16 KiB can be sent.",
+			Handler: clipcommand,
+		},
+		{
+			Name:    "/copy",
+			Args:    "[msg-id]",
+			Help:    "copy a received message to the clipboard",
+			Details: "Places the text of a message received in the room, or in the shown direct message pane, in the clipboard with an OSC 52 escape sequence, which also works over SSH if the terminal supports it. Without a message ID, lists the IDs of the latest messages. A unique prefix of the ID is enough.",
+			Handler: copycommand,
+		},
+		{
+			Name:    "/share-term",
+			Args:    "<peer,peer,...>",
+			Help:    "share a terminal with peers, read-only",
+			Details: "Suspends PeerChat and starts your shell on a new terminal recorded with script(1). Everything the shell writes is streamed to the peers, who watch it with '/view-term', until you exit the shell. The peers cannot type into the terminal.",
+			Handler: sharetermcommand,
+		},
+		{
+			Name:    "/view-term",
+			Args:    "[peer]",
+			Help:    "watch the terminal shared by a peer",
+			Details: "Shows the terminal shared by a peer in a viewer pane, which Esc closes. The peer defaults to the peer of the shown direct message pane. Colors are kept, but full-screen programs such as editors are not rendered correctly.",
+			Handler: viewtermcommand,
+		},
+		{
+			Name:    "/close",
+			Help:    "close the direct message pane",
+			Details: "Closes the tab of the shown direct message conversation and shows the room again.",
+			Handler: closecommand,
+		},
+		{
+			Name:    "/whisper",
+			Aliases: []string{"/w"},
+			Args:    "<peer,peer,...> <text>",
+			Help:    "whisper to some members of the room",
+			Details: "Sends a message that only the given peers (user names or peer ID suffixes, comma separated) can read. The whisper is published in the room like any other message, but its text is encrypted to the keys of the recipients, so the other members only see that a whisper was sent.",
+			Handler: whispercommand,
+		},
+		{
+			Name:    "/find",
+			Args:    "<peer>",
+			Help:    "look up a peer in the DHT and dial it",
+			Details: "Looks up the addresses of a peer (user name, peer ID suffix, friend alias or full peer ID) in the DHT, lists them with their kind (public, private, loopback or relay) and dials the peer, showing whether the dial succeeded and over which address. Use it to find out why a peer cannot be reached.",
+			Handler: findcommand,
+		},
+		{
+			Name:    "/dht",
+			Help:    "inspect the DHT routing table",
+			Details: "Shows the DHT routing table of the node: its mode, the number of peers by common prefix length (the buckets), the peers closest to the node with their latency and when they last answered a query usefully, and the recent DHT queries of the node with how long they took. Press r to refresh. Useful when peer discovery fails.",
+			Handler: dhtcommand,
+		},
+		{
+			Name:    "/trace",
+			Args:    "[msg-id]",
+			Help:    "show how a message was delivered",
+			Details: "A developer command that shows what the node knows about the delivery of a message of the room, to debug why someone didn't see it: the peer it was received from, the mesh peers it was forwarded to, the peers it was announced to with gossip and which of them asked for it, and the peers that sent it back because they had it already. Without a message ID, lists the IDs of the latest messages of the room. A unique prefix of the ID is enough.",
+			Handler: tracecommand,
+		},
+		{
+			Name:    "/notes",
+			Args:    "[add <text>|insert <n> <text>|set <n> <text>|del <n>]",
+			Help:    "show or edit the shared notes of the room",
+			Details: "Without arguments, shows the shared notes of the room, a document of lines kept in sync with the peers of the room for an agenda or a scratchpad. 'add' appends a line, 'insert' inserts a line before line n, 'set' replaces the text of line n and 'del' deletes line n. Edits made by peers at the same time are merged, so every peer ends up with the same notes.",
+			Handler: notescommand,
+		},
+		{
+			Name:    "/draw",
+			Args:    "[clear]",
+			Help:    "open the shared whiteboard of the room (experimental)",
+			Details: "An experimental whiteboard of 80x24 characters that the members of the room sketch on together. Move the cursor with the arrow keys and type to draw, Backspace and Delete clear a character and Esc closes the whiteboard. The whiteboard is synchronized with the members that have opened it. 'clear' clears the whiteboard for everyone.",
+			Handler: drawcommand,
+		},
+		{
+			Name:    "/purge",
+			Help:    "apply the retention policy to the history now",
+			Details: "Removes the messages beyond the retention policy under 'history' in the configuration (the maximum age and the maximum number of messages of each room) from the history on disk right away, instead of waiting for the hourly cleanup.",
+			Handler: purgecommand,
+		},
+		{
+			Name:    "/wipe",
+			Args:    "[room|all]",
+			Help:    "wipe the local data of a room or of every room",
+			Details: "Asks for confirmation, then shreds the history and the transcripts of a room (the current room if none is given) from this machine, with the pinned keys and the cached profiles of its members if it is the current room. With 'all', shreds the whole history, the transcripts, the pinned keys and the cached profiles. Meant for shared or compromised machines.",
+			Handler: wipecommand,
+		},
+		{
+			Name:    "/friend",
+			Args:    "<add|remove> <peer> [alias]",
+			Help:    "add or remove a friend",
+			Details: "Adds a peer (user name, peer ID suffix or full peer ID) to your contacts with a local alias, which is shown in the peer box and before their messages instead of their user name. The alias defaults to their current user name. Adding a friend again changes the alias. Friends are removed by their alias or peer ID suffix.",
+			Handler: friendcommand,
+		},
+		{
+			Name:    "/friends",
+			Help:    "list your friends",
+			Details: "Lists your friends with their aliases and whether they are online (connected to your node).",
+			Handler: friendscommand,
+		},
+		{
+			Name:    "/trust",
+			Args:    "<username>",
+			Help:    "accept the new key of a user",
+			Details: "The key of each user name is pinned on first contact, and a warning is shown when the user name later appears with a different key. After verifying with the user, this accepts the new key as the pinned key of the user name. The aliases of friends stay pinned to the friend, so use /friend add to change them.",
+			Handler: trustcommand,
+		},
+		{
+			Name:    "/show",
+			Args:    "[peer]",
+			Help:    "show messages hidden as spam",
+			Details: "Messages of a peer are hidden as spam while they flood the room, with a placeholder of how many were hidden. Every message raises the spam score of its sender, which decays over a few seconds, and duplicates of their recent messages and a poor GossipSub peer score raise it further. Friends are never hidden. With a peer (user name or peer ID suffix), the hidden messages of the peer are shown and their later messages are no longer hidden this session. Without a peer, the peers with hidden messages are listed.",
+			Handler: showcommand,
+		},
+		{
+			Name:    "/previews",
+			Args:    "[off|direct|sender]",
+			Help:    "show or set link previews",
+			Details: "Shows or sets whether the titles and descriptions of links in messages are shown below them. 'off' fetches nothing (the default). 'direct' fetches the linked pages yourself, which reveals your address to the linked sites. 'sender' asks the peer who sent the link to fetch its preview, since they already know the link. When previews are on, you also serve the previews of the links you sent recently.",
+			Handler: previewscommand,
+		},
+		{
+			Name:    "/roomset",
+			Args:    "[<field> <value>]",
+			Help:    "show or change the room settings",
+			Details: "Shows the settings of the room, which are signed by an operator of the room and enforced by every client on the messages of the room. Operators can change the slowmode (the time between the messages of a user, like 30s), maxlength (the maximum message length in bytes), ttl (how long the daemon keeps messages, like 24h) and types (the allowed message types: text, code and link, comma separated) of the room, with 0 or 'off' to remove a limit. 'readonly on' turns the room into an announcement channel where only the operators and publishers can post. 'maxmembers' limits the number of members, and 'approval on' makes an operator approve every new member. 'op' and 'deop' add and remove operators, 'pub' and 'unpub' add and remove publishers, and 'admit' and 'expel' add and remove members. 'channel' and 'unchannel' list and unlist the sub-rooms of the room for the room tree. Sub-rooms share the settings of their namespace. The first user to change the settings of a room becomes its operator.",
+			Handler: roomsetcommand,
+		},
+		{
+			Name:    "/fingerprint",
+			Args:    "<peer>",
+			Help:    "show words to verify a peer",
+			Details: "Shows verification words for the keys of a peer and yourself, from the PGP word list. The peer sees the same words with /fingerprint on their side, so you can read them to each other over a phone call to make sure nobody is impersonating either of you. The fingerprints of both keys are shown as well.",
+			Handler: fingerprintcommand,
+		},
+		{
+			Name:    "/reload",
+			Help:    "reload the Lua scripts",
+			Details: "Reloads all the Lua scripts in the scripts directory (~/.peerchat/scripts).",
+			Handler: reloadcommand,
+		},
+		{
+			Name:    "/config",
+			Args:    "[reload]",
+			Help:    "show or reload the configuration",
+			Details: "Shows the path of the configuration file (~/.peerchat/config.yaml). With 'reload', the file is read again and its theme, key bindings, default rooms and log level are applied. The network and storage settings apply on restart.",
+			Handler: configcommand,
+		},
+		{
+			Name:    "/profile",
+			Args:    "[<peer> | set <field> <value> | use <name>]",
+			Help:    "show user profiles or switch the profile",
+			Details: "Shows your user profile, the current profile and the existing profiles. With a peer (user name or peer ID suffix), the signed user profile of the peer is retrieved from the DHT. 'set' updates the bio, avatar (a text file of up to 8 lines of 24 columns, empty to remove it) or contacts (comma separated) of your user profile, which is published under your peer ID with your user name. 'use' restarts the application with the identity, user name and configuration of another profile (~/.peerchat/profiles/<name>, or ~/.peerchat for 'default'). New profiles are created on first use.",
+			Handler: profilecommand,
+		},
+		{
+			Name:    "/togglepeers",
+			Help:    "show/hide the peer box",
+			Details: "Toggles the visibility of the peer box. The peer box is hidden automatically on narrow terminals.",
+			Handler: togglecommand(func(ui *UI) { ui.showPeers = !ui.showPeers }),
+		},
+		{
+			Name:    "/toggleusage",
+			Help:    "show/hide the usage bar",
+			Details: "Toggles the visibility of the usage bar. The usage bar is hidden automatically on short terminals.",
+			Handler: togglecommand(func(ui *UI) { ui.showUsage = !ui.showUsage }),
+		},
+		{
+			Name:    "/toggletitle",
+			Help:    "show/hide the title",
+			Details: "Toggles the visibility of the title. The title is hidden automatically on short terminals.",
+			Handler: togglecommand(func(ui *UI) { ui.showTitle = !ui.showTitle }),
+		},
+		{
+			Name:    "/loglevel",
+			Args:    "<level>",
+			Help:    "filter the log pane",
+			Details: "Sets the most verbose log level shown in the log pane. Valid levels are trace, debug, info, warn, error, fatal and panic. The log pane can be toggled with F2.",
+			Handler: loglevelcommand,
+		},
+		{
+			Name:    "/notify",
+			Args:    "<on|off>",
+			Help:    "turn notifications on/off for the room",
+			Details: "Turns mention notifications on or off for the current chat room.",
+			Handler: notifycommand,
+		},
+		{
+			Name:    "/version",
+			Help:    "show the version and build information",
+			Details: "Shows the version of the application, the git commit and date of the build and the versions of libp2p and Go it was built with.",
+			Handler: versioncommand,
+		},
+		{
+			Name:    "/dnd",
+			Help:    "toggle do-not-disturb",
+			Details: "Toggles do-not-disturb, which silences notifications for all chat rooms.",
+			Handler: dndcommand,
+		},
+	}
+
+	for _, cmd := range builtins {
+		if err := registry.register(cmd); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// A function that handles the help command
+func helpcommand(ui *UI, arg string) {
+	ui.display_help(arg)
+}
+
+// A function that handles the quit command
+func quitcommand(ui *UI, arg string) {
+	// Ask the user to confirm before stopping the chat UI
+	ui.TerminalApp.QueueUpdateDraw(ui.confirmquit)
+}
+
+// A function that handles the clear command
+func clearcommand(ui *UI, arg string) {
+	// Clear the UI message box
+	ui.messageBox.Clear()
+}
+
+// A function that handles the room change command
+func roomcommand(ui *UI, arg string) {
+	if arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing room name for command"}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "roomchange", logmsg: fmt.Sprintf("joining new room '%s'", arg)}
+
+	// Create a reference to the current chatroom
+	oldchatroom := ui.ChatRoom
+
+	// Create a new chatroom and join it
+	newchatroom, err := ui.join(context.Background(), arg)
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "jumperr", logmsg: fmt.Sprintf("could not change chat room - %s", err)}
+		return
+	}
+
+	// Assign the new chat room to UI and move its events to the new room
+	ui.ChatRoom = newchatroom
+	oldchatroom.moveto(newchatroom)
+
+	// Add the room to the tabs if it is new
+	ui.AddRooms(newchatroom.RoomName)
+	// Sleep for a second to give time for the queues to adapt
+	time.Sleep(time.Second * 1)
+
+	// Exit the old chatroom and pause for two seconds
+	oldchatroom.Exit()
+
+	// Clear the UI message box
+	ui.messageBox.Clear()
+	// Update the chat room UI elements
+	ui.messageBox.SetTitle(roomtitle(ui.ChatRoom, len(ui.PeerList())))
+	ui.TerminalApp.QueueUpdateDraw(ui.showroomview)
+	ui.synclabel()
+}
+
+// A function that handles the members command
+func memberscommand(ui *UI, arg string) {
+	members := ui.members()
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.showmembers(members)
+	})
+}
+
+// A function that handles the direct message command
+func dmcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "dm", logmsg: "direct messages are not supported when attached to a daemon"}
+		return
+	}
+
+	parts := strings.SplitN(arg, " ", 2)
+	if parts[0] == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /dm <peer> [text]"}
+		return
+	}
+
+	// Peers that are not in the room can be given by their full peer ID
+	peerid, ok := ui.findpeer(parts[0])
+	if !ok {
+		decoded, err := peer.Decode(parts[0])
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", parts[0])}
+			return
+		}
+		peerid = decoded
+	}
+
+	if peerid == ui.selfid {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "dm", logmsg: "cannot send direct messages to yourself"}
+		return
+	}
+
+	ui.openpane(peerid, "")
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.showpane(peerid)
+	})
+
+	if len(parts) == 2 && strings.TrimSpace(parts[1]) != "" {
+		ui.senddm(peerid, strings.TrimSpace(parts[1]))
+	}
+}
+
+// A function that handles the play command
+func playcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "play", logmsg: "games are not supported when attached to a daemon"}
+		return
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) == 0 {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /play <peer> <game> | accept | decline | quit | <move>"}
+		return
+	}
+
+	var err error
+	if len(fields) == 2 {
+		// Invite a peer to a game
+		peerid, ok := ui.findpeer(fields[0])
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", fields[0])}
+			return
+		}
+		if peerid == ui.selfid {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "play", logmsg: "cannot play with yourself"}
+			return
+		}
+
+		if err = ui.invitegame(peerid, fields[1]); err == nil {
+			ui.TerminalApp.QueueUpdateDraw(func() {
+				ui.showpane(peerid)
+			})
+		}
+	} else {
+		// Play with the peer of the shown pane, or answer the only invitation
+		peerid := ui.shownpane()
+		if peerid == "" {
+			if invited, ok := games.invitation(); ok && (fields[0] == "accept" || fields[0] == "decline") {
+				peerid = invited
+			} else {
+				ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "play", logmsg: "open the direct message pane of the peer to play"}
+				return
+			}
+		}
+
+		switch fields[0] {
+		case "accept", "decline":
+			if err = ui.answergame(peerid, fields[0] == "accept"); err == nil {
+				ui.TerminalApp.QueueUpdateDraw(func() {
+					ui.showpane(peerid)
+				})
+			}
+		case "quit":
+			err = ui.quitgame(peerid)
+		default:
+			err = ui.movegame(peerid, fields[0])
+		}
+	}
+
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "play", logmsg: err.Error()}
+	}
+}
+
+// A function that handles the voice command
+func voicecommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "voice", logmsg: "voice is not supported when attached to a daemon"}
+		return
+	}
+
+	switch arg = strings.TrimSpace(arg); arg {
+	case "":
+		peers := ui.Host.VoicePeers()
+		if len(peers) == 0 {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: "nobody is in the voice channel"}
+			return
+		}
+
+		var listing []string
+		for peerid, connected := range peers {
+			state := "waiting"
+			if connected {
+				state = "connected"
+			}
+			listing = append(listing, fmt.Sprintf("%s (%s)", peerid.ShortString(), state))
+		}
+		sort.Strings(listing)
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: "in the voice channel: " + strings.Join(listing, ", ")}
+
+	case "talk":
+		ui.talk()
+
+	case "leave":
+		ui.Host.LeaveVoice()
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: "left the voice channel"}
+
+	default:
+		var peerids []peer.ID
+		for _, query := range strings.Split(arg, ",") {
+			peerid, ok := ui.findpeer(strings.TrimSpace(query))
+			if !ok {
+				ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", query)}
+				return
+			}
+			peerids = append(peerids, peerid)
+		}
+
+		if err := ui.Host.JoinVoice(peerids...); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "voice", logmsg: err.Error()}
+			return
+		}
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: fmt.Sprintf("added %d peers to the voice channel, press the push-to-talk key to talk", len(peerids))}
+	}
+}
+
+// A function that handles the schedule and remind commands
+func schedulelater(ui *UI, arg string, reminder bool) {
+	at, text, err := parseschedule(arg, time.Now())
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: err.Error()}
+		return
+	}
+
+	msg, err := ui.schedulemessage(text, at, reminder)
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "schedule", logmsg: err.Error()}
+		return
+	}
+
+	what := fmt.Sprintf("the message to %s", msg.Room)
+	if reminder {
+		what = "the reminder"
+	}
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "schedule", logmsg: fmt.Sprintf("scheduled %s for %s (%s, /scheduled cancel %s)", what, at.Format("Mon 02 Jan 15:04"), msg.ID, msg.ID)}
+}
+
+// A function that handles the schedule command
+func schedulecommand(ui *UI, arg string) {
+	schedulelater(ui, arg, false)
+}
+
+// A function that handles the remind command
+func remindcommand(ui *UI, arg string) {
+	schedulelater(ui, arg, true)
+}
+
+// A function that handles the scheduled command
+func scheduledcommand(ui *UI, arg string) {
+	fields := strings.Fields(arg)
+
+	switch {
+	case len(fields) == 0:
+		messages, err := ui.scheduled()
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "schedule", logmsg: fmt.Sprintf("cannot list the messages held by the daemon - %s", err)}
+		}
+		if len(messages) == 0 {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "schedule", logmsg: "no messages are scheduled"}
+		}
+		for _, msg := range messages {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "schedule", logmsg: msg.describe()}
+		}
+
+	case fields[0] == "cancel" && len(fields) == 2:
+		msg, err := ui.unschedule(fields[1])
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "schedule", logmsg: err.Error()}
+			return
+		}
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "schedule", logmsg: "cancelled " + msg.describe()}
+
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /scheduled [cancel <id>]"}
+	}
+}
+
+// A function that handles the clip command
+func clipcommand(ui *UI, arg string) {
+	peerid := ui.shownpane()
+	if arg = strings.TrimSpace(arg); arg != "" {
+		found, ok := ui.findpeer(arg)
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", arg)}
+			return
+		}
+		peerid = found
+	}
+	if peerid != "" && ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "clip", logmsg: "direct messages are not supported when attached to a daemon"}
+		return
+	}
+
+	text, err := readclipboard()
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "clip", logmsg: err.Error()}
+		return
+	}
+
+	switch {
+	case strings.TrimSpace(text) == "":
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "clip", logmsg: "the clipboard is empty"}
+	case len(text) > maxclip:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "clip", logmsg: fmt.Sprintf("the clipboard is too large to send (%d bytes, at most %d)", len(text), maxclip)}
+	default:
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.confirmclip(peerid, text)
+		})
+	}
+}
+
+// A function that handles the copy command
+func copycommand(ui *UI, arg string) {
+	// List the latest messages without a message ID
+	if arg = strings.TrimSpace(arg); arg == "" {
+		ids, messages := ui.copyable()
+		if len(ids) == 0 {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "copy", logmsg: "no messages have been received yet"}
+			return
+		}
+
+		for index := maxint(0, len(ids)-listedcopies); index < len(ids); index++ {
+			text := strings.SplitN(messages[index].Message, "\n", 2)[0]
+			if len(text) > 40 {
+				text = text[:40] + "..."
+			}
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "copy", logmsg: fmt.Sprintf("%s %s: %s", ids[index], messages[index].SenderName, text)}
+		}
+		return
+	}
+
+	msg, err := ui.copymessage(arg)
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "copy", logmsg: err.Error()}
+		return
+	}
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "copy", logmsg: fmt.Sprintf("copied the message of %s to the clipboard", msg.SenderName)}
+}
+
+// A function that handles the share-term command
+func sharetermcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "share", logmsg: "sharing a terminal is not supported when attached to a daemon"}
+		return
+	}
+	if arg = strings.TrimSpace(arg); arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing the peers to share the terminal with"}
+		return
+	}
+
+	var peerids []peer.ID
+	for _, query := range strings.Split(arg, ",") {
+		peerid, ok := ui.findpeer(strings.TrimSpace(query))
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", query)}
+			return
+		}
+		peerids = append(peerids, peerid)
+	}
+
+	if err := ui.shareterm(peerids); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "share", logmsg: err.Error()}
+		return
+	}
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "share", logmsg: "stopped sharing the terminal"}
+}
+
+// A function that handles the view-term command
+func viewtermcommand(ui *UI, arg string) {
+	peerid := ui.shownpane()
+	if arg = strings.TrimSpace(arg); arg != "" {
+		found, ok := ui.findterm(arg)
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "share", logmsg: fmt.Sprintf("no terminal is shared by '%s'", arg)}
+			return
+		}
+		peerid = found
+	}
+	if peerid == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing the peer whose terminal to watch"}
+		return
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		if !ui.showterm(peerid) {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "share", logmsg: fmt.Sprintf("%s has not shared a terminal", peerid.ShortString())}
+		}
+	})
+}
+
+// A function that handles the close command
+func closecommand(ui *UI, arg string) {
+	peerid := ui.shownpane()
+	if peerid == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "no direct message pane is shown"}
+		return
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.closepane(peerid)
+	})
+}
+
+// A function that handles the whisper command
+func whispercommand(ui *UI, arg string) {
+	parts := strings.SplitN(arg, " ", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[1]) == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /whisper <peer,peer,...> <text>"}
+		return
+	}
+
+	var recipients []peer.ID
+	names := strings.Split(parts[0], ",")
+	for _, name := range names {
+		peerid, ok := ui.findpeer(name)
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", name)}
+			return
+		}
+
+		recipients = append(recipients, peerid)
+	}
+
+	text := strings.TrimSpace(parts[1])
+	if err := ui.Whisper(recipients, text); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "whisper", logmsg: err.Error()}
+		return
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.display_selfwhisper(names, text)
+	})
+}
+
+// A function that handles the room tree command
+func roomscommand(ui *UI, arg string) {
+	ui.TerminalApp.QueueUpdateDraw(ui.showroomtree)
+}
+
+// A function that handles the user change command
+func usercommand(ui *UI, arg string) {
+	if arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing user name for command"}
+		return
+	}
+
+	// Set or clear the user name of the current room
+	if arg == "here" || strings.HasPrefix(arg, "here ") {
+		nick := strings.TrimSpace(strings.TrimPrefix(arg, "here"))
+		if err := ui.SetRoomNick(nick); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "nick", logmsg: err.Error()}
+			return
+		}
+
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "nick", logmsg: fmt.Sprintf("you are now '%s' in room '%s'", ui.UserName, ui.RoomName)}
+		ui.inputBox.SetLabel(ui.inputlabel())
+		return
+	}
+
+	// Update the chat user name
+	ui.UpdateUser(arg)
+	if ui.UserName != arg {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "nick", logmsg: fmt.Sprintf("you are still '%s' in room '%s' (/nick here to use your user name)", ui.UserName, ui.RoomName)}
+	}
+	// Publish the user name with the user profile
+	if ui.Host != nil {
+		ui.Host.PublishProfile(arg)
+	}
+	// Update the chat room UI element
+	ui.inputBox.SetLabel(ui.inputlabel())
+}
+
+// A function that handles the whois command
+func whoiscommand(ui *UI, arg string) {
+	if arg = strings.TrimSpace(arg); arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /whois <username|peerid>"}
+		return
+	}
+
+	matches := ui.matchpeers(arg)
+	switch {
+	case len(matches) == 0:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s' (peer ID suffixes need at least %d characters)", arg, minpeersuffix)}
+		return
+	case len(matches) > 1:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("'%s' matches %d peers, give more of the peer ID", arg, len(matches))}
+		return
+	}
+	peerid := matches[0]
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.showwhois(peerid)
+	})
+}
+
+// A function that handles the find command
+func findcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "find", logmsg: "peer lookups are not supported when attached to a daemon"}
+		return
+	}
+
+	if arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /find <peer>"}
+		return
+	}
+
+	// Peers that are not in the room can be given by their friend alias or full peer ID
+	peerid, ok := ui.findpeer(arg)
+	if !ok {
+		for _, f := range friends.list() {
+			if f.Alias == arg {
+				if decoded, err := peer.Decode(f.PeerID); err == nil {
+					peerid, ok = decoded, true
+				}
+				break
+			}
+		}
+	}
+	if !ok {
+		decoded, err := peer.Decode(arg)
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", arg)}
+			return
+		}
+		peerid = decoded
+	}
+
+	if peerid == ui.selfid {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "find", logmsg: "that is your own peer ID"}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "find", logmsg: fmt.Sprintf("looking up %s...", peerid.Pretty())}
+
+	lookup := ui.Host.LookupPeer(peerid)
+	for _, line := range lookup.describe() {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "find", logmsg: line}
+	}
+}
+
+// A function that handles the dht command
+func dhtcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "dht", logmsg: "the DHT cannot be inspected when attached to a daemon"}
+		return
+	}
+
+	snapshot := ui.Host.DHTSnapshot()
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.showdht(snapshot)
+	})
+}
+
+// A function that handles the trace command
+func tracecommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "trace", logmsg: "messages cannot be traced when attached to a daemon"}
+		return
+	}
+
+	// List the latest messages of the room without a message ID
+	if arg == "" {
+		records := ui.Host.Deliveries(ui.RoomName, "", recentdeliveries)
+		if len(records) == 0 {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "trace", logmsg: "no messages of the room have been seen yet"}
+		}
+		for _, record := range records {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "trace", logmsg: record.summary()}
+		}
+		return
+	}
+
+	records := ui.Host.Deliveries(ui.RoomName, arg, 2)
+	switch len(records) {
+	case 0:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "trace", logmsg: fmt.Sprintf("no recent message of the room has the ID %s", arg)}
+	case 1:
+		for _, line := range records[0].describe() {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "trace", logmsg: line}
+		}
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "trace", logmsg: fmt.Sprintf("more than one message has an ID starting with %s", arg)}
+	}
+}
+
+// A function that handles the notes command
+func notescommand(ui *UI, arg string) {
+	fields := strings.SplitN(strings.TrimSpace(arg), " ", 3)
+	action := fields[0]
+
+	if action == "" {
+		lines, err := ui.Notes()
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "notes", logmsg: err.Error()}
+			return
+		}
+
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.shownotes(lines)
+		})
+		return
+	}
+
+	var number int
+	var text string
+	switch action {
+	case "add":
+		text = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(arg), "add"))
+		if text == "" {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing text of the line"}
+			return
+		}
+
+	case "insert", "set", "del":
+		var err error
+		if len(fields) < 2 {
+			err = errors.New("missing line number")
+		} else if number, err = strconv.Atoi(fields[1]); err != nil {
+			err = fmt.Errorf("invalid line number '%s'", fields[1])
+		}
+		if err == nil && action != "del" {
+			if len(fields) < 3 || strings.TrimSpace(fields[2]) == "" {
+				err = errors.New("missing text of the line")
+			} else {
+				text = strings.TrimSpace(fields[2])
+			}
+		}
+
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: err.Error()}
+			return
+		}
+
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("unknown notes action '%s' (add, insert, set, del)", action)}
+		return
+	}
+
+	if err := ui.EditNotes(action, number, text); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "notes", logmsg: err.Error()}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "notes", logmsg: "the notes were updated"}
+}
+
+// A function that handles the draw command
+func drawcommand(ui *UI, arg string) {
+	room := ui.ChatRoom
+	if err := room.joinboard(); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "draw", logmsg: err.Error()}
+		return
+	}
+
+	switch strings.TrimSpace(arg) {
+	case "":
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.showboard(room)
+		})
+
+	case "clear":
+		if err := room.ClearBoard(); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "draw", logmsg: err.Error()}
+			return
+		}
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "draw", logmsg: "the whiteboard was cleared"}
+
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("unknown draw action '%s'", arg)}
+	}
+}
+
+// A function that handles the purge command
+func purgecommand(ui *UI, arg string) {
+	if !currentconfig().History.Persist {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "purge", logmsg: "the history is not kept on disk"}
+		return
+	}
+	if !currentconfig().History.Retention.enabled() {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "purge", logmsg: "the retention policy has no limits, set them under 'history' in the configuration"}
+		return
+	}
+
+	store, err := openhistory()
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "purge", logmsg: fmt.Sprintf("failed to open the history - %s", err)}
+		return
+	}
+
+	removed, histories := store.purge()
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "purge", logmsg: fmt.Sprintf("removed %d messages from %d histories", removed, histories)}
+}
+
+// A function that handles the wipe command
+func wipecommand(ui *UI, arg string) {
+	target := strings.TrimSpace(arg)
+	if target == "" {
+		target = ui.RoomName
+	}
+
+	// Ask the user to confirm before wiping anything
+	ui.TerminalApp.QueueUpdateDraw(func() { ui.confirmwipe(target) })
+}
+
+// A function that handles the friend command
+func friendcommand(ui *UI, arg string) {
+	fields := strings.Fields(arg)
+	if len(fields) < 2 {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "friend command expects 'add <peer> [alias]' or 'remove <peer>'"}
+		return
+	}
+
+	switch fields[0] {
+	case "add":
+		// Find the peer in the room, or decode a full peer ID
+		peerid, ok := ui.findpeer(fields[1])
+		if !ok {
+			decoded, err := peer.Decode(fields[1])
+			if err != nil {
+				ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", fields[1])}
+				return
+			}
+			peerid = decoded
+		}
+
+		// Default to the user name of the peer
+		alias := strings.Join(fields[2:], " ")
+		if alias == "" {
+			ui.stateLock.Lock()
+			alias = ui.nicks[peerid]
+			ui.stateLock.Unlock()
+		}
+		if alias == "" {
+			pretty := peerid.Pretty()
+			alias = pretty[len(pretty)-8:]
+		}
+
+		if err := friends.add(peerid, alias); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "friends", logmsg: fmt.Sprintf("could not save the contact list - %s", err)}
+			return
+		}
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "friends", logmsg: fmt.Sprintf("added %s as '%s'", peerid.Pretty(), alias)}
+
+	case "remove":
+		removed, ok, err := friends.remove(strings.Join(fields[1:], " "))
+		switch {
+		case !ok:
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no friend found for '%s'", fields[1])}
+			return
+		case err != nil:
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "friends", logmsg: fmt.Sprintf("could not save the contact list - %s", err)}
+			return
+		}
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "friends", logmsg: fmt.Sprintf("removed '%s'", removed.Alias)}
+
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "friend command expects 'add' or 'remove'"}
+		return
+	}
+
+	// Show the aliases in the peer box
+	ui.syncpeerbox()
+}
+
+// A function that handles the friends command
+func friendscommand(ui *UI, arg string) {
+	all := friends.list()
+	if len(all) == 0 {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "friends", logmsg: "no friends yet, add one with /friend add <peer> [alias]"}
+		return
+	}
+
+	for _, f := range all {
+		status := "unknown"
+		if peerid, err := peer.Decode(f.PeerID); err == nil && ui.Host != nil {
+			status = "offline"
+			if ui.Host.Host.Network().Connectedness(peerid) == network.Connected {
+				status = "online"
+			}
+		}
+
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "friends", logmsg: fmt.Sprintf("%s (%s) - %s", f.Alias, f.PeerID, status)}
+	}
+}
+
+// A function that handles the trust command
+func trustcommand(ui *UI, arg string) {
+	if arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "missing user name for command"}
+		return
+	}
+
+	peerid, ok := pins.trust(arg)
+	if !ok {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("'%s' has not appeared with a different key", arg)}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "keychange", logmsg: fmt.Sprintf("pinned '%s' to the key %s", arg, peerid.Pretty())}
+}
+
+// A function that handles the show command
+func showcommand(ui *UI, arg string) {
+	if arg == "" {
+		counts := ui.spam.hiddencounts()
+		if len(counts) == 0 {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "spam", logmsg: "no messages are hidden"}
+			return
+		}
+
+		for senderid, count := range counts {
+			ui.stateLock.Lock()
+			name := ui.nicks[senderid]
+			ui.stateLock.Unlock()
+
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "spam", logmsg: fmt.Sprintf("%d messages hidden from %s (%s)", count, name, senderid.Pretty())}
+		}
+		return
+	}
+
+	peerid, ok := ui.findpeer(arg)
+	if !ok {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", arg)}
+		return
+	}
+
+	hidden := ui.spam.show(peerid)
+	if len(hidden) > 0 {
+		fmt.Fprintf(ui.messageBox, "[gray]%d hidden messages:[-]\n", len(hidden))
+	}
+	for _, msg := range hidden {
+		fmt.Fprintf(ui.messageBox, "%s %s\n", ui.chatprompt(msg), rendermessage(msg.Message))
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "spam", logmsg: fmt.Sprintf("showing the messages of %s", peerid.Pretty())}
+}
+
+// A function that handles the previews command
+func previewscommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "previews", logmsg: "link previews are not supported when attached to a daemon"}
+		return
+	}
+
+	if arg == "" {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "previews", logmsg: fmt.Sprintf("link previews are '%s'", ui.Host.previewmode())}
+		return
+	}
+
+	if err := ui.Host.SetPreviews(arg); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: err.Error()}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "previews", logmsg: fmt.Sprintf("link previews are now '%s'", arg)}
+}
+
+// A function that handles the room settings command
+func roomsetcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "roomset", logmsg: "room settings are not supported when attached to a daemon"}
+		return
+	}
+
+	room := ui.RoomName
+	if arg == "" {
+		settings := ui.Host.RoomSettings(room)
+		if settings == nil {
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "roomset", logmsg: fmt.Sprintf("room '%s' has no settings", room)}
+			return
+		}
+
+		details := append([]string{fmt.Sprintf("Settings of %s", room), ""}, describesettings(settings)...)
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.showmodal("roomset", details)
+		})
+		return
+	}
+
+	fields := strings.Fields(arg)
+	if len(fields) != 2 {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "usage: /roomset <field> <value>"}
+		return
+	}
+
+	field, value := fields[0], fields[1]
+	update := func(settings *RoomSettings) error {
+		switch field {
+		case "slowmode", "ttl":
+			duration := time.Duration(0)
+			if value != "0" && value != "off" {
+				parsed, err := time.ParseDuration(value)
+				if err != nil {
+					return fmt.Errorf("invalid duration '%s'", value)
+				}
+				duration = parsed
+			}
+
+			if field == "slowmode" {
+				settings.SlowMode = int(duration / time.Second)
+			} else {
+				settings.TTL = int(duration / time.Second)
+			}
+
+		case "types":
+			settings.Types = nil
+			if value != "off" && value != "all" {
+				settings.Types = strings.Split(value, ",")
+			}
+
+		case "readonly", "approval":
+			if value != "on" && value != "off" {
+				return fmt.Errorf("invalid value '%s' (on or off)", value)
+			}
+			if field == "readonly" {
+				settings.ReadOnly = value == "on"
+			} else {
+				settings.Approval = value == "on"
+			}
+
+		case "maxlength", "maxmembers":
+			limit := 0
+			if value != "off" {
+				if _, err := fmt.Sscanf(value, "%d", &limit); err != nil {
+					return fmt.Errorf("invalid number '%s'", value)
+				}
+			}
+			if field == "maxlength" {
+				settings.MaxLength = limit
+			} else {
+				settings.MaxMembers = limit
+			}
+
+		case "channel", "unchannel":
+			channels := []string{}
+			for _, channel := range settings.Channels {
+				if channel != value {
+					channels = append(channels, channel)
+				}
+			}
+			if field == "channel" {
+				channels = append(channels, value)
+			}
+			settings.Channels = channels
+
+		case "op", "deop", "pub", "unpub", "admit", "expel":
+			peerid, ok := ui.findpeer(value)
+			if !ok {
+				return fmt.Errorf("no peer found for '%s'", value)
+			}
+
+			list := &settings.Operators
+			switch field {
+			case "pub", "unpub":
+				list = &settings.Publishers
+			case "admit", "expel":
+				list = &settings.Members
+			}
+
+			peers := []string{}
+			for _, existing := range *list {
+				if existing != peerid.Pretty() {
+					peers = append(peers, existing)
+				}
+			}
+			if field == "op" || field == "pub" || field == "admit" {
+				peers = append(peers, peerid.Pretty())
+			}
+			*list = peers
+
+		default:
+			return fmt.Errorf("unknown room setting '%s' (slowmode, maxlength, ttl, types, readonly, maxmembers, approval, channel, unchannel, op, deop, pub, unpub, admit or expel)", field)
+		}
+
+		return nil
+	}
+
+	if _, err := ui.Host.UpdateRoomSettings(room, update); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "roomset", logmsg: err.Error()}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "roomset", logmsg: fmt.Sprintf("set %s of room '%s' to %s", field, room, value)}
+}
+
+// A function that returns a readable description of room settings
+func describesettings(settings *RoomSettings) []string {
+	limit := func(value int, unit string) string {
+		if value == 0 {
+			return "off"
+		}
+		return fmt.Sprintf("%d %s", value, unit)
+	}
+
+	types := "all"
+	if len(settings.Types) > 0 {
+		types = strings.Join(settings.Types, ", ")
+	}
+
+	// Shorten the peer IDs
+	shorten := func(peerids []string) string {
+		short := make([]string, len(peerids))
+		for index, peerid := range peerids {
+			if len(peerid) > 8 {
+				peerid = peerid[len(peerid)-8:]
+			}
+			short[index] = peerid
+		}
+		return strings.Join(short, ", ")
+	}
+
+	approval := "off"
+	if settings.Approval {
+		approval = fmt.Sprintf("on (%d members)", len(settings.Members))
+	}
+
+	readonly := "off"
+	if settings.ReadOnly {
+		readonly = "on"
+		if len(settings.Publishers) > 0 {
+			readonly = fmt.Sprintf("on (publishers: %s)", shorten(settings.Publishers))
+		}
+	}
+
+	return []string{
+		fmt.Sprintf("Slow mode: %s", limit(settings.SlowMode, "seconds")),
+		fmt.Sprintf("Max length: %s", limit(settings.MaxLength, "bytes")),
+		fmt.Sprintf("Message TTL: %s", limit(settings.TTL, "seconds")),
+		fmt.Sprintf("Message types: %s", types),
+		fmt.Sprintf("Read-only: %s", readonly),
+		fmt.Sprintf("Max members: %s", limit(settings.MaxMembers, "members")),
+		fmt.Sprintf("Approval: %s", approval),
+		fmt.Sprintf("Channels: %s", strings.Join(settings.Channels, ", ")),
+		fmt.Sprintf("Operators: %s", shorten(settings.Operators)),
+		fmt.Sprintf("Version: %s", time.Unix(0, settings.Version).Format(time.RFC1123)),
+	}
+}
+
+// A function that handles the fingerprint command
+func fingerprintcommand(ui *UI, arg string) {
+	peerid, ok := ui.findpeer(arg)
+	if !ok {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", arg)}
+		return
+	}
+
+	details := []string{
+		"Verification words (the same on both sides):",
+		strings.Join(verificationwords(ui.selfid, peerid), " "),
+		"",
+		fmt.Sprintf("Your key: %s", strings.Join(fingerprint(ui.selfid), " ")),
+		fmt.Sprintf("Their key: %s", strings.Join(fingerprint(peerid), " ")),
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.showmodal("fingerprint", details)
+	})
+}
+
+// A function that handles the script reload command
+func reloadcommand(ui *UI, arg string) {
+	count := scripts.reload()
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "scripts", logmsg: fmt.Sprintf("reloaded %d scripts", count)}
+}
+
+// A function that handles the configuration command
+func configcommand(ui *UI, arg string) {
+	switch arg {
+	case "":
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "config", logmsg: fmt.Sprintf("configuration file is %s", configpath())}
+
+	case "reload":
+		cfg, err := LoadConfig()
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "config", logmsg: fmt.Sprintf("could not reload the configuration - %s", err)}
+			return
+		}
+
+		// Apply the log level and nick palette if they are configured
+		if level, err := logrus.ParseLevel(cfg.Log); err == nil {
+			logrus.SetLevel(level)
+		}
+		if cfg.Theme.Palette != "" {
+			ui.SetPalette(cfg.Theme.Palette)
+		}
+
+		// Apply the theme, key bindings and default rooms
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.applyconfig(cfg)
+			ui.synctabs()
+		})
+
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "config", logmsg: "reloaded the configuration"}
+
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "config command expects no argument or 'reload'"}
+	}
+}
+
+// A function that handles the profile command
+func profilecommand(ui *UI, arg string) {
+	action, rest := arg, ""
+	if index := strings.IndexByte(arg, ' '); index >= 0 {
+		action, rest = arg[:index], strings.TrimSpace(arg[index+1:])
+	}
+
+	switch {
+	case arg == "":
+		current := ProfileName()
+		if current == "" {
+			current = "default"
+		}
+
+		profiles := append([]string{"default"}, listprofiles()...)
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "profile", logmsg: fmt.Sprintf("current profile is '%s' (profiles: %s)", current, strings.Join(profiles, ", "))}
+
+		if ui.Host != nil {
+			profile := ui.Host.Profile()
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "profile", logmsg: fmt.Sprintf("user profile - name: %s, bio: %s, avatar: %s, contacts: %s", profile.Name, profile.Bio, profile.Avatar, strings.Join(profile.Contacts, ", "))}
+		}
+
+	case action == "use":
+		if err := requestprofile(rest); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: err.Error()}
+			return
+		}
+
+		// Stop the UI so that the application restarts with the profile
+		ui.TerminalApp.Stop()
+
+	case action == "set":
+		profilesetcommand(ui, rest)
+
+	default:
+		if ui.Host == nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "user profiles are not available when attached to a daemon"}
+			return
+		}
+
+		peerid, ok := ui.findpeer(arg)
+		if !ok {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no peer found for '%s'", arg)}
+			return
+		}
+
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "profile", logmsg: fmt.Sprintf("retrieving the profile of %s", peerid.Pretty())}
+		profile, err := ui.Host.LookupProfile(peerid)
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "profile", logmsg: fmt.Sprintf("could not retrieve the profile - %s", err)}
+			return
+		}
+
+		// Retrieve the avatar of the profile
+		avatar, err := ui.Host.PeerAvatar(peerid)
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "profile", logmsg: fmt.Sprintf("could not retrieve the avatar - %s", err)}
+		}
+
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.showprofile(peerid, profile, avatar)
+		})
+	}
+}
+
+// A function that handles the profile set command, which updates
+// a field of the user profile of the host and publishes it
+func profilesetcommand(ui *UI, arg string) {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "user profiles are not available when attached to a daemon"}
+		return
+	}
+
+	field, value := arg, ""
+	if index := strings.IndexByte(arg, ' '); index >= 0 {
+		field, value = arg[:index], strings.TrimSpace(arg[index+1:])
+	}
+
+	var update func(profile *UserProfile)
+	switch field {
+	case "bio":
+		update = func(profile *UserProfile) { profile.Bio = value }
+	case "avatar":
+		// Avatars are read from a text file and sent on request
+		if err := ui.Host.SetAvatar(value); err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "profile", logmsg: fmt.Sprintf("could not set the avatar - %s", err)}
+			return
+		}
+
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "profile", logmsg: "updated the avatar of your user profile"}
+		return
+	case "contacts":
+		var contacts []string
+		for _, contact := range strings.Split(value, ",") {
+			if contact = strings.TrimSpace(contact); contact != "" {
+				contacts = append(contacts, contact)
+			}
+		}
+		update = func(profile *UserProfile) { profile.Contacts = contacts }
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "profile set expects 'bio', 'avatar' or 'contacts'"}
+		return
+	}
+
+	if err := ui.Host.UpdateProfile(update); err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "profile", logmsg: fmt.Sprintf("could not save the user profile - %s", err)}
+		return
+	}
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "profile", logmsg: fmt.Sprintf("updated the %s of your user profile", field)}
+}
+
+// A function that generates the handler of a pane toggle command.
+// The toggle is applied on the tview event loop and the layout redrawn.
+func togglecommand(toggle func(ui *UI)) CommandHandler {
+	return func(ui *UI, arg string) {
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			toggle(ui)
+			ui.relayout()
+		})
+	}
+}
+
+// A function that handles the log level command
+func loglevelcommand(ui *UI, arg string) {
+	level, err := logrus.ParseLevel(arg)
+	if err != nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("invalid log level - %s", arg)}
+		return
+	}
+
+	// Update the log pane filter level
+	atomic.StoreUint32(&ui.logLevel, uint32(level))
+}
+
+// A function that handles the version command
+func versioncommand(ui *UI, arg string) {
+	for _, line := range BuildInfo() {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "version", logmsg: line}
+	}
+}
+
+// A function that handles the do-not-disturb command
+func dndcommand(ui *UI, arg string) {
+	if ui.notifier.ToggleDND() {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "notify", logmsg: "do-not-disturb enabled"}
+	} else {
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "notify", logmsg: "do-not-disturb disabled"}
+	}
+}
+
+// A function that handles the room notifications command
+func notifycommand(ui *UI, arg string) {
+	switch arg {
+	case "on", "off":
+		ui.notifier.SetRoom(ui.RoomName, arg == "on")
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "notify", logmsg: fmt.Sprintf("notifications turned %s for '%s'", arg, ui.RoomName)}
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: "notify command expects 'on' or 'off'"}
+	}
+}
@@ -0,0 +1,478 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	"github.com/multiformats/go-multiaddr"
+	"gopkg.in/yaml.v2"
+)
+
+// A structure that represents the configuration file of
+// the application (~/.peerchat/config.yaml). Command line
+// flags take precedence over the values of the configuration.
+type Config struct {
+	// Represents the user name to use in the chat rooms
+	User string `yaml:"user"`
+	// Represents the chat rooms to join, the first of which is opened
+	Rooms []string `yaml:"rooms"`
+	// Represents the multiaddrs of the bootstrap peers (libp2p defaults if empty)
+	Bootstrap []string `yaml:"bootstrap"`
+	// Represents the network to join, which scopes the discovery and the
+	// rooms to an isolated community (the global network if empty)
+	Network string `yaml:"network"`
+	// Represents whether the topics of the rooms are derived from an
+	// HMAC of their names, so that observers cannot enumerate the rooms
+	HashTopics bool `yaml:"hashtopics"`
+	// Represents whether the legacy topics of the rooms are bridged for older
+	// clients ('bridge', the default) or ignored ('off')
+	LegacyTopics string `yaml:"legacytopics"`
+	// Represents the router of the message transport of the rooms
+	// ('gossipsub', the default, 'floodsub', 'randomsub' or a registered router)
+	Router string `yaml:"router"`
+	// Represents the multiaddrs of the trackers for the tracker discovery
+	Trackers []string `yaml:"trackers"`
+	// Represents the multiaddrs to listen on (all interfaces on a random port if empty)
+	Listen []string `yaml:"listen"`
+	// Represents the SOCKS5 proxy to dial peers through (socks5://[user:pass@]host:port)
+	Proxy string `yaml:"proxy"`
+	// Represents the settings for reaching peers over Tor
+	Tor TorConfig `yaml:"tor"`
+	// Represents the colors of the interface
+	Theme ThemeConfig `yaml:"theme"`
+	// Represents the level of logs to print
+	Log string `yaml:"log"`
+	// Represents the format of the logs (text or json)
+	LogFormat string `yaml:"logformat"`
+	// Represents the file to write logs to, its maximum size in
+	// megabytes and the number of rotated files to keep (optional)
+	LogFile    string `yaml:"logfile"`
+	LogMaxSize int    `yaml:"logmaxsize"`
+	LogBackups int    `yaml:"logbackups"`
+	// Represents the locations of the local data
+	Storage StorageConfig `yaml:"storage"`
+	// Represents the settings of the local message history
+	History HistoryConfig `yaml:"history"`
+	// Represents the settings of the plain-text transcripts of the rooms
+	Transcripts TranscriptConfig `yaml:"transcripts"`
+	// Represents the content filters applied in order to inbound messages
+	Filters []FilterConfig `yaml:"filters"`
+	// Represents the mode of link previews ('off', 'direct' or 'sender')
+	Previews string `yaml:"previews"`
+	// Represents the mapping of UI actions to key names (e.g. 'togglelogs: F2')
+	Keybindings map[string]string `yaml:"keybindings"`
+	// Represents the numbers of peers in a room that are logged when they are crossed
+	PeerAlerts []int `yaml:"peeralerts"`
+	// Represents the settings of the voice channels
+	Voice VoiceConfig `yaml:"voice"`
+
+	// Represents the compiled content filters
+	filters []*contentfilter
+}
+
+// A structure that represents the colors of the interface
+type ThemeConfig struct {
+	// Represents the color palette for peer nicks ('default' or 'colorblind')
+	Palette string `yaml:"palette"`
+	// Represents the color of the borders and labels (a color name or #rrggbb)
+	Accent string `yaml:"accent"`
+}
+
+// A structure that represents the settings for reaching peers over Tor.
+// The node listens on an onion service and dials all peers through Tor.
+type TorConfig struct {
+	// Represents whether Tor is used
+	Enabled bool `yaml:"enabled"`
+	// Represents the address of the SOCKS port of Tor (127.0.0.1:9050 if empty)
+	Socks string `yaml:"socks"`
+	// Represents the address of the control port of Tor (127.0.0.1:9051 if empty)
+	Control string `yaml:"control"`
+	// Represents the password of the control port (cookie authentication if empty)
+	Password string `yaml:"password"`
+	// Represents the port of the onion service (4001 if zero)
+	Port int `yaml:"port"`
+}
+
+// A structure that represents the locations of the local data.
+// Relative paths are resolved against the data directory.
+type StorageConfig struct {
+	// Represents the directory of the local data (~/.peerchat if empty)
+	Data string `yaml:"data"`
+	// Represents the directory of the plugins (<data>/plugins if empty)
+	Plugins string `yaml:"plugins"`
+	// Represents the directory of the Lua scripts (<data>/scripts if empty)
+	Scripts string `yaml:"scripts"`
+	// Represents whether the peerstore and the DHT are kept in memory only,
+	// instead of in <data>/datastore.json across restarts
+	Ephemeral bool `yaml:"ephemeral"`
+}
+
+// A structure that represents the settings of the local message history
+type HistoryConfig struct {
+	// Represents whether the history of the rooms is kept on disk (encrypted)
+	Persist bool `yaml:"persist"`
+	// Represents the retention policy of the history
+	Retention RetentionConfig `yaml:"retention"`
+}
+
+// Represents the default color of the borders and labels
+const defaultaccent = "green"
+
+// Represents the default mapping of UI actions to key names
+var defaultkeybindings = map[string]string{
+	"togglelogs": "F2",
+	"talk":       "F4",
+	"quit":       "Ctrl-C",
+}
+
+// Represents the configuration of the application and the lock on it
+var (
+	config     = &Config{}
+	configlock sync.RWMutex
+)
+
+// A function that returns the path of the configuration file
+func configpath() string {
+	return filepath.Join(defaultdir(), "config.yaml")
+}
+
+// A function that loads the configuration file and makes it the configuration of
+// the application. A missing file is not an error and yields an empty configuration.
+func LoadConfig() (*Config, error) {
+	loaded := &Config{}
+
+	// Read the configuration file
+	data, err := ioutil.ReadFile(configpath())
+	switch {
+	case os.IsNotExist(err):
+	case err != nil:
+		return nil, err
+	default:
+		if err := yaml.UnmarshalStrict(data, loaded); err != nil {
+			return nil, err
+		}
+	}
+
+	// Validate the configuration before applying it
+	if err := loaded.validate(); err != nil {
+		return nil, err
+	}
+
+	configlock.Lock()
+	config = loaded
+	configlock.Unlock()
+
+	return loaded, nil
+}
+
+// A function that returns the configuration of the application
+func currentconfig() *Config {
+	configlock.RLock()
+	defer configlock.RUnlock()
+
+	return config
+}
+
+// A method of Config that checks the addresses, colors and key
+// bindings, and compiles the content filters
+func (cfg *Config) validate() error {
+	for _, addr := range cfg.Listen {
+		if _, err := multiaddr.NewMultiaddr(addr); err != nil {
+			return fmt.Errorf("invalid listen multiaddr '%s' - %s", addr, err)
+		}
+	}
+
+	// Bootstrap peers must include their peer ID
+	for _, addr := range cfg.Bootstrap {
+		muladdr, err := multiaddr.NewMultiaddr(addr)
+		if err == nil {
+			_, err = peer.AddrInfoFromP2pAddr(muladdr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid bootstrap multiaddr '%s' - %s", addr, err)
+		}
+	}
+
+	// Trackers must include their peer ID
+	for _, addr := range cfg.Trackers {
+		muladdr, err := multiaddr.NewMultiaddr(addr)
+		if err == nil {
+			_, err = peer.AddrInfoFromP2pAddr(muladdr)
+		}
+
+		if err != nil {
+			return fmt.Errorf("invalid tracker multiaddr '%s' - %s", addr, err)
+		}
+	}
+
+	if err := validnetwork(cfg.Network); err != nil {
+		return err
+	}
+
+	if _, err := parseproxy(cfg.Proxy); err != nil {
+		return err
+	}
+
+	if cfg.Tor.Enabled && cfg.Proxy != "" {
+		return errors.New("a proxy cannot be combined with tor, which uses its own SOCKS port")
+	}
+
+	if !validlegacymode(cfg.LegacyTopics) {
+		return fmt.Errorf("invalid legacy topics mode '%s' (%s)", cfg.LegacyTopics, strings.Join(legacymodes, ", "))
+	}
+
+	if _, ok := transportconstructor(cfg.Router); !ok {
+		return fmt.Errorf("invalid router '%s' (%s)", cfg.Router, strings.Join(transportnames(), ", "))
+	}
+
+	if !validpreviewmode(cfg.Previews) {
+		return fmt.Errorf("invalid link preview mode '%s' (%s)", cfg.Previews, strings.Join(previewmodes, ", "))
+	}
+
+	if err := cfg.History.Retention.validate(); err != nil {
+		return err
+	}
+
+	if err := validpeeralerts(cfg.PeerAlerts); err != nil {
+		return err
+	}
+
+	if cfg.Theme.Accent != "" && tcell.GetColor(cfg.Theme.Accent) == tcell.ColorDefault {
+		return fmt.Errorf("invalid accent color '%s'", cfg.Theme.Accent)
+	}
+
+	if _, err := cfg.keymap(); err != nil {
+		return err
+	}
+
+	filters, err := compilefilters(cfg.Filters)
+	if err != nil {
+		return err
+	}
+	cfg.filters = filters
+
+	return nil
+}
+
+// A method of Config that returns the values of the configuration for the
+// command line flags, so that they can be applied to flags that were not set
+func (cfg *Config) Flags() map[string]string {
+	flags := map[string]string{
+		"user":      cfg.User,
+		"log":       cfg.Log,
+		"palette":   cfg.Theme.Palette,
+		"logfile":   cfg.LogFile,
+		"logformat": cfg.LogFormat,
+		"proxy":     cfg.Proxy,
+		"network":   cfg.Network,
+		"previews":  cfg.Previews,
+		"router":    cfg.Router,
+	}
+
+	if cfg.LogMaxSize > 0 {
+		flags["logmaxsize"] = strconv.Itoa(cfg.LogMaxSize)
+	}
+	if cfg.LogBackups > 0 {
+		flags["logbackups"] = strconv.Itoa(cfg.LogBackups)
+	}
+	if cfg.Tor.Enabled {
+		flags["tor"] = "true"
+	}
+	if cfg.HashTopics {
+		flags["hashtopics"] = "true"
+	}
+
+	return flags
+}
+
+// A method of Config that returns the listen multiaddrs,
+// defaulting to all interfaces on a random TCP port
+func (cfg *Config) listenaddrs() []multiaddr.Multiaddr {
+	listen := cfg.Listen
+	if len(listen) == 0 {
+		listen = []string{"/ip4/0.0.0.0/tcp/0"}
+	}
+
+	addrs := make([]multiaddr.Multiaddr, 0, len(listen))
+	for _, addr := range listen {
+		addrs = append(addrs, multiaddr.StringCast(addr))
+	}
+
+	return addrs
+}
+
+// A method of Config that returns the multiaddrs of the
+// bootstrap peers, defaulting to the bootstrap peers of libp2p
+func (cfg *Config) bootstrappeers() []multiaddr.Multiaddr {
+	if len(cfg.Bootstrap) == 0 {
+		return dht.DefaultBootstrapPeers
+	}
+
+	addrs := make([]multiaddr.Multiaddr, 0, len(cfg.Bootstrap))
+	for _, addr := range cfg.Bootstrap {
+		addrs = append(addrs, multiaddr.StringCast(addr))
+	}
+
+	return addrs
+}
+
+// A method of Config that returns the trackers of the configuration
+func (cfg *Config) trackers() []peer.AddrInfo {
+	points := make([]peer.AddrInfo, 0, len(cfg.Trackers))
+	for _, addr := range cfg.Trackers {
+		if point, err := peer.AddrInfoFromP2pAddr(multiaddr.StringCast(addr)); err == nil {
+			points = append(points, *point)
+		}
+	}
+
+	return points
+}
+
+// A method of Config that sets the SOCKS5 proxy to dial peers through,
+// overriding the proxy of the configuration file. An empty URL dials directly.
+func (cfg *Config) SetProxy(raw string) error {
+	if _, err := parseproxy(raw); err != nil {
+		return err
+	}
+
+	configlock.Lock()
+	cfg.Proxy = raw
+	configlock.Unlock()
+
+	return nil
+}
+
+// A method of Config that sets whether peers are reached over Tor,
+// overriding the configuration file. Tor cannot be combined with a proxy.
+func (cfg *Config) SetTor(enabled bool) error {
+	configlock.Lock()
+	defer configlock.Unlock()
+
+	if enabled && cfg.Proxy != "" {
+		return errors.New("a proxy cannot be combined with tor, which uses its own SOCKS port")
+	}
+
+	cfg.Tor.Enabled = enabled
+	return nil
+}
+
+// A method of Config that returns the SOCKS5 proxy to dial peers through, if any
+func (cfg *Config) proxy() *socksproxy {
+	// The proxy has been validated when it was loaded
+	proxy, _ := parseproxy(cfg.Proxy)
+	return proxy
+}
+
+// A method of Config that returns the color of the borders and labels
+func (cfg *Config) accent() tcell.Color {
+	if cfg.Theme.Accent == "" {
+		return tcell.GetColor(defaultaccent)
+	}
+
+	return tcell.GetColor(cfg.Theme.Accent)
+}
+
+// A method of Config that returns the mapping of keys to UI actions,
+// with the default bindings for the actions that are not configured
+func (cfg *Config) keymap() (map[tcell.Key]string, error) {
+	// Index the key names
+	keys := make(map[string]tcell.Key, len(tcell.KeyNames))
+	for key, name := range tcell.KeyNames {
+		keys[strings.ToLower(name)] = key
+	}
+
+	bindings := make(map[string]string, len(defaultkeybindings))
+	for action, name := range defaultkeybindings {
+		bindings[action] = name
+	}
+	for action, name := range cfg.Keybindings {
+		bindings[action] = name
+	}
+
+	keymap := make(map[tcell.Key]string, len(bindings))
+	for action, name := range bindings {
+		if _, ok := keyactions[action]; !ok {
+			return nil, fmt.Errorf("unsupported key binding action '%s'", action)
+		}
+
+		key, ok := keys[strings.ToLower(name)]
+		if !ok {
+			return nil, fmt.Errorf("unsupported key '%s' for action '%s'", name, action)
+		}
+
+		if other, ok := keymap[key]; ok {
+			return nil, fmt.Errorf("key '%s' is bound to both '%s' and '%s'", name, other, action)
+		}
+
+		keymap[key] = action
+	}
+
+	return keymap, nil
+}
+
+// A method of Config that returns the router of the message transport of the rooms
+func (cfg *Config) router() string {
+	if cfg.Router == "" {
+		return defaultrouter
+	}
+
+	return cfg.Router
+}
+
+// A method of Config that returns the data directory with a leading '~' expanded
+func (cfg *Config) datadir() string {
+	if cfg.Storage.Data == "" {
+		return defaultdir()
+	}
+
+	return expandhome(cfg.Storage.Data)
+}
+
+// A method of Config that returns the directory of the plugins
+func (cfg *Config) pluginsdir() string {
+	return cfg.storagepath(cfg.Storage.Plugins, "plugins")
+}
+
+// A method of Config that returns the directory of the Lua scripts
+func (cfg *Config) scriptsdir() string {
+	return cfg.storagepath(cfg.Storage.Scripts, "scripts")
+}
+
+// A method of Config that returns a storage path of the configuration,
+// resolved against the data directory, or a default path within it
+func (cfg *Config) storagepath(path string, fallback string) string {
+	if path == "" {
+		return filepath.Join(cfg.datadir(), fallback)
+	}
+
+	path = expandhome(path)
+	if filepath.IsAbs(path) {
+		return path
+	}
+
+	return filepath.Join(cfg.datadir(), path)
+}
+
+// A function that expands a leading '~' in a path to the home directory of the user
+func expandhome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
+}
@@ -0,0 +1,367 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// Represents the name of the gRPC service of the daemon
+const daemonservicename = "peerchat.Daemon"
+
+// Represents the content subtype of the codec used by the daemon
+const daemoncodecname = "json"
+
+/*
+A structure that represents a JSON codec for gRPC.
+
+The daemon service is described by hand rather than generated from a
+protobuf definition, so its messages are plain structures encoded as
+JSON. Clients must use the 'json' content subtype (application/grpc+json).
+*/
+type daemoncodec struct{}
+
+// A method of daemoncodec that marshals a message into JSON
+func (daemoncodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+// A method of daemoncodec that unmarshals a message from JSON
+func (daemoncodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+// A method of daemoncodec that returns the name of the codec
+func (daemoncodec) Name() string {
+	return daemoncodecname
+}
+
+func init() {
+	encoding.RegisterCodec(daemoncodec{})
+}
+
+// A structure that represents a daemon request for a room
+type daemonroom struct {
+	Room string `json:"room"`
+}
+
+// A structure that represents a daemon request to send a message
+type daemonsend struct {
+	Room    string `json:"room"`
+	Message string `json:"message"`
+}
+
+// A structure that represents a daemon request for the history of a room
+type daemonhistory struct {
+	Room  string `json:"room"`
+	Limit int    `json:"limit"`
+}
+
+// A structure that represents the daemon reply with the history of a room
+type daemonmessages struct {
+	Messages []GatewayMessage `json:"messages"`
+}
+
+// A structure that represents a daemon request to schedule a message
+type daemonschedule struct {
+	Room    string    `json:"room"`
+	Message string    `json:"message"`
+	At      time.Time `json:"at"`
+}
+
+// A structure that represents a daemon request to cancel a scheduled message
+type daemonunschedule struct {
+	ID string `json:"id"`
+}
+
+// A structure that represents the daemon reply with scheduled messages
+type daemonscheduled struct {
+	Messages []scheduledmessage `json:"messages"`
+}
+
+// A structure that represents an empty daemon request or reply
+type daemonempty struct{}
+
+// A structure that represents the daemon reply with the node details
+type daemonself struct {
+	ID   string `json:"id"`
+	User string `json:"user"`
+}
+
+// A structure that represents the daemon reply with the peers of a room
+type daemonpeers struct {
+	Peers []GatewayPeer `json:"peers"`
+}
+
+// An interface that represents the methods of the daemon service
+type daemonservice interface {
+	Self(context.Context, *daemonempty) (*daemonself, error)
+	Join(context.Context, *daemonroom) (*daemonempty, error)
+	Leave(context.Context, *daemonroom) (*daemonempty, error)
+	Send(context.Context, *daemonsend) (*daemonempty, error)
+	Peers(context.Context, *daemonroom) (*daemonpeers, error)
+	History(context.Context, *daemonhistory) (*daemonmessages, error)
+	Schedule(context.Context, *daemonschedule) (*daemonscheduled, error)
+	Scheduled(context.Context, *daemonempty) (*daemonscheduled, error)
+	Unschedule(context.Context, *daemonunschedule) (*daemonscheduled, error)
+	StreamMessages(*daemonroom, grpc.ServerStream) error
+}
+
+// A function that returns a unary handler for a method of the daemon service
+func daemonhandler(method string, request func() interface{}, call func(daemonservice, context.Context, interface{}) (interface{}, error)) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: method,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			// Decode the request
+			req := request()
+			if err := dec(req); err != nil {
+				return nil, err
+			}
+
+			if interceptor == nil {
+				return call(srv.(daemonservice), ctx, req)
+			}
+
+			info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + daemonservicename + "/" + method}
+			return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				return call(srv.(daemonservice), ctx, req)
+			})
+		},
+	}
+}
+
+// Represents the description of the daemon service
+var daemondesc = grpc.ServiceDesc{
+	ServiceName: daemonservicename,
+	HandlerType: (*daemonservice)(nil),
+	Methods: []grpc.MethodDesc{
+		daemonhandler("Self", func() interface{} { return &daemonempty{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Self(ctx, req.(*daemonempty))
+		}),
+		daemonhandler("Join", func() interface{} { return &daemonroom{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Join(ctx, req.(*daemonroom))
+		}),
+		daemonhandler("Leave", func() interface{} { return &daemonroom{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Leave(ctx, req.(*daemonroom))
+		}),
+		daemonhandler("Send", func() interface{} { return &daemonsend{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Send(ctx, req.(*daemonsend))
+		}),
+		daemonhandler("Peers", func() interface{} { return &daemonroom{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Peers(ctx, req.(*daemonroom))
+		}),
+		daemonhandler("History", func() interface{} { return &daemonhistory{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.History(ctx, req.(*daemonhistory))
+		}),
+		daemonhandler("Schedule", func() interface{} { return &daemonschedule{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Schedule(ctx, req.(*daemonschedule))
+		}),
+		daemonhandler("Scheduled", func() interface{} { return &daemonempty{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Scheduled(ctx, req.(*daemonempty))
+		}),
+		daemonhandler("Unschedule", func() interface{} { return &daemonunschedule{} }, func(s daemonservice, ctx context.Context, req interface{}) (interface{}, error) {
+			return s.Unschedule(ctx, req.(*daemonunschedule))
+		}),
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMessages",
+			ServerStreams: true,
+			Handler: func(srv interface{}, stream grpc.ServerStream) error {
+				req := &daemonroom{}
+				if err := stream.RecvMsg(req); err != nil {
+					return err
+				}
+
+				return srv.(daemonservice).StreamMessages(req, stream)
+			},
+		},
+	},
+	Metadata: "peerchat/daemon",
+}
+
+// A structure that represents the daemon service backed by a Gateway
+type daemonserver struct {
+	gw *Gateway
+}
+
+/*
+A function that starts the gRPC control API of a Gateway on a unix socket,
+so that the node can stay online in its rooms while frontends (such as the
+terminal UI started with -attach) connect, disconnect and restart.
+
+	Self            the peer ID and user name of the node
+	Join            join a room
+	Leave           leave a room
+	Send            send a message to a joined room
+	Peers           the peers of a joined room
+	History         the recent messages of a joined room
+	Schedule        schedule a message for a room, sent when it is due
+	Scheduled       the scheduled messages
+	Unschedule      cancel a scheduled message
+	StreamMessages  a stream of the messages of a room (all rooms if empty)
+
+The socket defaults to ~/.peerchat/daemon.sock and is only accessible
+by the current user. Returns the gRPC server.
+*/
+func ServeDaemon(gw *Gateway, socket string) (*grpc.Server, error) {
+	if socket == "" {
+		socket = defaultdaemonsocket()
+	}
+
+	// Create the directory of the socket
+	if err := os.MkdirAll(filepath.Dir(socket), 0700); err != nil {
+		return nil, err
+	}
+
+	// Remove a stale socket from a previous run
+	if err := os.Remove(socket); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	// Listen on the socket
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		return nil, err
+	}
+
+	// Restrict the socket to the current user
+	if err := os.Chmod(socket, 0600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	server := grpc.NewServer()
+	server.RegisterService(&daemondesc, &daemonserver{gw: gw})
+
+	// Serve the requests in the background
+	go func() {
+		if err := server.Serve(listener); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("Daemon Control API Failed!")
+		}
+	}()
+
+	return server, nil
+}
+
+// A function that returns the default path of the daemon socket
+func defaultdaemonsocket() string {
+	return peerchatpath("daemon.sock")
+}
+
+// A method of daemonserver that returns the node details
+func (ds *daemonserver) Self(ctx context.Context, req *daemonempty) (*daemonself, error) {
+	return &daemonself{ID: ds.gw.Host.Host.ID().Pretty(), User: ds.gw.UserName}, nil
+}
+
+// A method of daemonserver that joins a room
+func (ds *daemonserver) Join(ctx context.Context, req *daemonroom) (*daemonempty, error) {
+	if err := ds.gw.Join(req.Room); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+
+	return &daemonempty{}, nil
+}
+
+// A method of daemonserver that leaves a room
+func (ds *daemonserver) Leave(ctx context.Context, req *daemonroom) (*daemonempty, error) {
+	if err := ds.gw.Leave(req.Room); err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &daemonempty{}, nil
+}
+
+// A method of daemonserver that sends a message to a room
+func (ds *daemonserver) Send(ctx context.Context, req *daemonsend) (*daemonempty, error) {
+	if err := ds.gw.Send(req.Room, req.Message); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &daemonempty{}, nil
+}
+
+// A method of daemonserver that returns the peers of a room
+func (ds *daemonserver) Peers(ctx context.Context, req *daemonroom) (*daemonpeers, error) {
+	peers, err := ds.gw.Peers(req.Room)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &daemonpeers{Peers: peers}, nil
+}
+
+// A method of daemonserver that returns the recent messages of a room
+func (ds *daemonserver) History(ctx context.Context, req *daemonhistory) (*daemonmessages, error) {
+	messages, err := ds.gw.History(req.Room, req.Limit)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &daemonmessages{Messages: messages}, nil
+}
+
+// A method of daemonserver that schedules a message for a room
+func (ds *daemonserver) Schedule(ctx context.Context, req *daemonschedule) (*daemonscheduled, error) {
+	msg, err := ds.gw.Schedule(req.Room, req.Message, req.At)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	return &daemonscheduled{Messages: []scheduledmessage{msg}}, nil
+}
+
+// A method of daemonserver that returns the scheduled messages
+func (ds *daemonserver) Scheduled(ctx context.Context, req *daemonempty) (*daemonscheduled, error) {
+	return &daemonscheduled{Messages: ds.gw.Scheduled()}, nil
+}
+
+// A method of daemonserver that cancels a scheduled message
+func (ds *daemonserver) Unschedule(ctx context.Context, req *daemonunschedule) (*daemonscheduled, error) {
+	msg, err := ds.gw.Unschedule(req.ID)
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+
+	return &daemonscheduled{Messages: []scheduledmessage{msg}}, nil
+}
+
+// A method of daemonserver that streams the messages
+// of a room until the client cancels the stream
+func (ds *daemonserver) StreamMessages(req *daemonroom, stream grpc.ServerStream) error {
+	// Subscribe to the gateway messages
+	messages, cancel := ds.gw.Subscribe()
+	defer cancel()
+
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+
+		case msg, ok := <-messages:
+			if !ok {
+				return nil
+			}
+
+			// Skip the messages of other rooms
+			if req.Room != "" && msg.Room != req.Room {
+				continue
+			}
+
+			if err := stream.SendMsg(&msg); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,308 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+)
+
+// Represents the timeout for connecting to the daemon and for its unary calls
+const daemontimeout = time.Second * 5
+
+// A structure that represents a client of the gRPC control API of a daemon
+type DaemonClient struct {
+	// Represents the gRPC connection to the daemon
+	conn *grpc.ClientConn
+}
+
+// A constructor function that connects to the daemon listening on a unix
+// socket (~/.peerchat/daemon.sock if empty) and returns a new DaemonClient
+func DialDaemon(socket string) (*DaemonClient, error) {
+	if socket == "" {
+		socket = defaultdaemonsocket()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), daemontimeout)
+	defer cancel()
+
+	// Dial the unix socket of the daemon
+	conn, err := grpc.DialContext(ctx, socket,
+		grpc.WithInsecure(),
+		grpc.WithBlock(),
+		grpc.WithAuthority("localhost"),
+		grpc.WithContextDialer(func(ctx context.Context, addr string) (net.Conn, error) {
+			var dialer net.Dialer
+			return dialer.DialContext(ctx, "unix", addr)
+		}),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(daemoncodecname)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("could not connect to daemon at %s - %w", socket, err)
+	}
+
+	return &DaemonClient{conn: conn}, nil
+}
+
+// A method of DaemonClient that calls a unary method of the daemon service
+func (dc *DaemonClient) call(method string, req interface{}, reply interface{}) error {
+	ctx, cancel := context.WithTimeout(context.Background(), daemontimeout)
+	defer cancel()
+
+	return dc.conn.Invoke(ctx, "/"+daemonservicename+"/"+method, req, reply)
+}
+
+// A method of DaemonClient that returns the peer ID and user name of the daemon
+func (dc *DaemonClient) Self() (string, string, error) {
+	reply := &daemonself{}
+	if err := dc.call("Self", &daemonempty{}, reply); err != nil {
+		return "", "", err
+	}
+
+	return reply.ID, reply.User, nil
+}
+
+// A method of DaemonClient that joins a room on the daemon
+func (dc *DaemonClient) Join(room string) error {
+	return dc.call("Join", &daemonroom{Room: room}, &daemonempty{})
+}
+
+// A method of DaemonClient that leaves a room on the daemon
+func (dc *DaemonClient) Leave(room string) error {
+	return dc.call("Leave", &daemonroom{Room: room}, &daemonempty{})
+}
+
+// A method of DaemonClient that sends a message to a room joined by the daemon
+func (dc *DaemonClient) Send(room string, message string) error {
+	return dc.call("Send", &daemonsend{Room: room, Message: message}, &daemonempty{})
+}
+
+// A method of DaemonClient that returns the peers of a room joined by the daemon
+func (dc *DaemonClient) Peers(room string) ([]GatewayPeer, error) {
+	reply := &daemonpeers{}
+	if err := dc.call("Peers", &daemonroom{Room: room}, reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Peers, nil
+}
+
+// A method of DaemonClient that returns the recent messages of a room
+// joined by the daemon, oldest first (all of them if limit is not positive)
+func (dc *DaemonClient) History(room string, limit int) ([]GatewayMessage, error) {
+	reply := &daemonmessages{}
+	if err := dc.call("History", &daemonhistory{Room: room, Limit: limit}, reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Messages, nil
+}
+
+// A method of DaemonClient that schedules a message for a room, which the
+// daemon joins if needed and sends the message to once it is due
+func (dc *DaemonClient) Schedule(room string, message string, at time.Time) (scheduledmessage, error) {
+	reply := &daemonscheduled{}
+	if err := dc.call("Schedule", &daemonschedule{Room: room, Message: message, At: at}, reply); err != nil {
+		return scheduledmessage{}, err
+	}
+	if len(reply.Messages) == 0 {
+		return scheduledmessage{}, errors.New("daemon did not return the scheduled message")
+	}
+
+	return reply.Messages[0], nil
+}
+
+// A method of DaemonClient that returns the messages scheduled on the daemon, the earliest first
+func (dc *DaemonClient) Scheduled() ([]scheduledmessage, error) {
+	reply := &daemonscheduled{}
+	if err := dc.call("Scheduled", &daemonempty{}, reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Messages, nil
+}
+
+// A method of DaemonClient that cancels a message scheduled on the daemon by a prefix of its ID
+func (dc *DaemonClient) Unschedule(prefix string) (scheduledmessage, error) {
+	reply := &daemonscheduled{}
+	if err := dc.call("Unschedule", &daemonunschedule{ID: prefix}, reply); err != nil {
+		return scheduledmessage{}, err
+	}
+	if len(reply.Messages) == 0 {
+		return scheduledmessage{}, errors.New("daemon did not return the cancelled message")
+	}
+
+	return reply.Messages[0], nil
+}
+
+// A method of DaemonClient that streams the messages of a room (all rooms
+// if empty) into the returned channel until the context is cancelled.
+// The channel is closed when the stream ends.
+func (dc *DaemonClient) StreamMessages(ctx context.Context, room string) (<-chan GatewayMessage, error) {
+	// Open the server stream
+	stream, err := dc.conn.NewStream(ctx, &daemondesc.Streams[0], "/"+daemonservicename+"/StreamMessages")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := stream.SendMsg(&daemonroom{Room: room}); err != nil {
+		return nil, err
+	}
+
+	if err := stream.CloseSend(); err != nil {
+		return nil, err
+	}
+
+	messages := make(chan GatewayMessage)
+	go func() {
+		defer close(messages)
+
+		for {
+			var msg GatewayMessage
+			if err := stream.RecvMsg(&msg); err != nil {
+				return
+			}
+
+			select {
+			case messages <- msg:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return messages, nil
+}
+
+// A method of DaemonClient that closes the connection to the daemon
+func (dc *DaemonClient) Close() error {
+	return dc.conn.Close()
+}
+
+// A constructor function that generates and returns a new ChatRoom that is
+// backed by a room of a running daemon instead of a local P2P host, so that
+// the interfaces can act as thin clients. Exiting the chat room only stops
+// the stream, the daemon stays in the room. The stream has its own context
+// that is derived from the given context.
+func JoinDaemonChatRoom(ctx context.Context, client *DaemonClient, roomname string) (*ChatRoom, error) {
+	// Check the provided roomname
+	if roomname == "" {
+		// Use the default room name
+		roomname = defaultroom
+	}
+
+	// Retrieve the node details of the daemon
+	selfid, username, err := client.Self()
+	if err != nil {
+		return nil, err
+	}
+
+	peerid, err := peer.Decode(selfid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Join the room on the daemon
+	if err := client.Join(roomname); err != nil {
+		return nil, err
+	}
+
+	// Create the context of the stream
+	streamctx, cancel := context.WithCancel(ctx)
+
+	// Stream the messages of the room
+	messages, err := client.StreamMessages(streamctx, roomname)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	// Create a ChatRoom object
+	chatroom := &ChatRoom{
+		Inbound:  make(chan ChatMessage),
+		Outbound: make(chan string),
+		Logs:     make(chan chatlog),
+		moved:    make(chan *ChatRoom, 1),
+
+		transformed: make(chan string),
+
+		psctx:    streamctx,
+		pscancel: cancel,
+		daemon:   client,
+
+		RoomName: roomname,
+		UserName: username,
+		basename: username,
+		selfid:   peerid,
+	}
+
+	// Start the daemon loop
+	go chatroom.daemonloop(messages)
+
+	// Return the chatroom
+	return chatroom, nil
+}
+
+// A method of ChatRoom that relays the messages between the chat
+// room and the daemon until the stream or the context closes
+func (cr *ChatRoom) daemonloop(messages <-chan GatewayMessage) {
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+
+		case msg, ok := <-messages:
+			// Close the messages queue if the stream has ended
+			if !ok {
+				cr.log(logrus.ErrorLevel, "suberr", "daemon stream has closed")
+				close(cr.Inbound)
+				return
+			}
+
+			// Skip the messages sent by the daemon itself
+			if msg.SenderID == cr.selfid.Pretty() {
+				continue
+			}
+
+			select {
+			case cr.Inbound <- msg.ChatMessage:
+			case <-cr.psctx.Done():
+				return
+			}
+
+		case message := <-cr.Outbound:
+			// Send the message through the daemon
+			if err := cr.daemon.Send(cr.RoomName, message); err != nil {
+				cr.log(logrus.ErrorLevel, "puberr", err.Error())
+			}
+
+		case message := <-cr.transformed:
+			// Send the message through the daemon
+			if err := cr.daemon.Send(cr.RoomName, message); err != nil {
+				cr.log(logrus.ErrorLevel, "puberr", err.Error())
+			}
+		}
+	}
+}
+
+// A method of ChatRoom that returns the peers of a daemon backed chat room
+func (cr *ChatRoom) daemonpeers() []peer.ID {
+	peers, err := cr.daemon.Peers(cr.RoomName)
+	if err != nil {
+		return nil
+	}
+
+	peerids := make([]peer.ID, 0, len(peers))
+	for _, p := range peers {
+		if peerid, err := peer.Decode(p.ID); err == nil {
+			peerids = append(peerids, peerid)
+		}
+	}
+
+	return peerids
+}
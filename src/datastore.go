@@ -0,0 +1,218 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	ds "github.com/ipfs/go-datastore"
+	"github.com/ipfs/go-datastore/namespace"
+	"github.com/ipfs/go-datastore/query"
+	dssync "github.com/ipfs/go-datastore/sync"
+	"github.com/libp2p/go-libp2p-core/peer"
+	pstore "github.com/libp2p/go-libp2p-core/peerstore"
+	host "github.com/libp2p/go-libp2p-host"
+	peerstore "github.com/libp2p/go-libp2p-peerstore"
+	"github.com/libp2p/go-libp2p-peerstore/pstoreds"
+	"github.com/libp2p/go-libp2p-peerstore/pstoremem"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time between the writes of the datastore to disk
+const datastoreflush = time.Minute
+
+// Represents the key under which the peers of the DHT routing table are kept
+var routingkey = ds.NewKey("/routing")
+
+// A structure that represents the datastore of the peerstore and the DHT. The
+// entries are kept in memory and written to a file periodically and on close,
+// so that a restarted node rejoins the network with the addresses of the peers
+// it knew and the peers of its routing table, instead of bootstrapping cold.
+type diskstore struct {
+	*dssync.MutexDatastore
+	// Represents the path of the file of the datastore
+	path string
+}
+
+// A function that returns the path of the file of the datastore
+func datastorepath() string {
+	return peerchatpath("datastore.json")
+}
+
+// A function that opens the datastore of the peerstore and the DHT and
+// starts writing it to disk periodically. Returns nil if the network
+// state is ephemeral or the datastore cannot be read, in which case
+// the peerstore and the DHT are kept in memory only.
+func opendatastore(ctx context.Context) *diskstore {
+	if currentconfig().Storage.Ephemeral {
+		return nil
+	}
+
+	store := &diskstore{MutexDatastore: dssync.MutexWrap(ds.NewMapDatastore()), path: datastorepath()}
+
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  store.path,
+		}).Warnln("Failed to Read the Datastore!")
+		return nil
+	}
+
+	if len(data) > 0 {
+		var entries map[string][]byte
+		if err := json.Unmarshal(data, &entries); err != nil {
+			// Start over rather than failing on a corrupt datastore
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"file":  store.path,
+			}).Warnln("Failed to Decode the Datastore!")
+		}
+
+		for key, value := range entries {
+			store.Put(ds.NewKey(key), value)
+		}
+	}
+
+	go func() {
+		ticker := time.NewTicker(datastoreflush)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				store.save()
+			}
+		}
+	}()
+
+	return store
+}
+
+// A method of diskstore that writes the entries of the datastore to its file
+func (store *diskstore) save() {
+	results, err := store.Query(query.Query{})
+	if err != nil {
+		return
+	}
+
+	entries, err := results.Rest()
+	if err != nil {
+		return
+	}
+
+	data := make(map[string][]byte, len(entries))
+	for _, entry := range entries {
+		data[entry.Key] = entry.Value
+	}
+
+	encoded, err := json.Marshal(data)
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(store.path), 0700)
+	}
+	// Write to a temporary file first, so the datastore is not lost on failure
+	if err == nil {
+		err = ioutil.WriteFile(store.path+".tmp", encoded, 0600)
+	}
+	if err == nil {
+		err = os.Rename(store.path+".tmp", store.path)
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  store.path,
+		}).Warnln("Failed to Save the Datastore!")
+	}
+}
+
+// A method of diskstore that writes the datastore to disk when it is closed
+func (store *diskstore) Close() error {
+	store.save()
+	return nil
+}
+
+// A method of diskstore that returns a peerstore which keeps the addresses,
+// protocols and metadata of peers in the datastore. Keys are kept in memory
+// only, so that the private key of the host is never written to the datastore.
+func (store *diskstore) openpeerstore(ctx context.Context) (pstore.Peerstore, error) {
+	peers := namespace.Wrap(store, ds.NewKey("/peers"))
+	options := pstoreds.DefaultOpts()
+
+	addrbook, err := pstoreds.NewAddrBook(ctx, peers, options)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata, err := pstoreds.NewPeerMetadata(ctx, peers, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return peerstore.NewPeerstore(pstoremem.NewKeyBook(), addrbook, pstoreds.NewProtoBook(metadata), metadata), nil
+}
+
+// A method of diskstore that returns the namespace of the datastore for the records of the DHT
+func (store *diskstore) dhtstore() ds.Batching {
+	return namespace.Wrap(store, ds.NewKey("/dht"))
+}
+
+// A method of diskstore that keeps the peers of the routing table of the DHT
+func (store *diskstore) saverouting(peers []peer.ID) {
+	ids := make([]string, 0, len(peers))
+	for _, peerid := range peers {
+		ids = append(ids, peerid.Pretty())
+	}
+
+	if data, err := json.Marshal(ids); err == nil {
+		store.Put(routingkey, data)
+	}
+}
+
+// A method of diskstore that returns the peers of the routing table of the DHT before the restart
+func (store *diskstore) routing() []peer.ID {
+	data, err := store.Get(routingkey)
+	if err != nil {
+		return nil
+	}
+
+	var ids []string
+	json.Unmarshal(data, &ids)
+
+	peers := make([]peer.ID, 0, len(ids))
+	for _, id := range ids {
+		if peerid, err := peer.Decode(id); err == nil {
+			peers = append(peers, peerid)
+		}
+	}
+
+	return peers
+}
+
+// A function that reconnects to the peers of the routing table of the DHT
+// before the restart with their known addresses, which refills the routing
+// table as the peers are identified as DHT servers
+func warmrouting(ctx context.Context, nodehost host.Host, store *diskstore) {
+	peers := store.routing()
+	if len(peers) == 0 {
+		return
+	}
+
+	for _, peerid := range peers {
+		go func(peerid peer.ID) {
+			ctx, cancel := context.WithTimeout(ctx, dmtimeout)
+			defer cancel()
+
+			nodehost.Connect(ctx, peer.AddrInfo{ID: peerid})
+		}(peerid)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"peers": len(peers),
+	}).Debugln("Reconnecting to the Known DHT Peers.")
+}
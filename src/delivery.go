@@ -0,0 +1,244 @@
+package src
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	pb "github.com/libp2p/go-libp2p-pubsub/pb"
+)
+
+// Represents the number of messages whose delivery is remembered
+const maxdeliveries = 512
+
+// Represents the number of recent messages listed by /trace without a message ID
+const recentdeliveries = 10
+
+// A structure that represents what the host knows about the delivery of a message
+// of a room: where it came from, which peers it was forwarded or announced to,
+// and which peers are known to have it because they sent it back or asked for it
+type deliveryrecord struct {
+	// Represents the ID of the message (the hex sequence number, as in ChatMessage.ID)
+	id string
+	// Represents the topic of the message
+	topic string
+	// Represents when the host first saw the message
+	seen time.Time
+	// Represents whether the message was published by the host
+	published bool
+	// Represents the peer the message was first received from
+	from peer.ID
+	// Represents the peers of the mesh the message was forwarded to
+	sentto []peer.ID
+	// Represents the peers the message was announced to with gossip (IHAVE)
+	announced []peer.ID
+	// Represents the peers that asked for the message after its announcement (IWANT)
+	wanted []peer.ID
+	// Represents the peers that sent the message as well, which had it already
+	duplicates []peer.ID
+	// Represents the reason the message was rejected (empty if it wasn't)
+	rejected string
+}
+
+// A structure that represents the delivery records of the recent messages, which
+// is fed by the PubSub events of the host and passes them on to the PubSub trace
+type deliverylog struct {
+	// Represents the delivery records by message ID, and the order they were created in
+	records map[string]*deliveryrecord
+	order   []string
+	// Represents the tracer the events are passed on to (nil if not traced)
+	next pubsub.EventTracer
+	// Represents the lock on the records
+	lock sync.Mutex
+}
+
+// A constructor function that returns an empty deliverylog
+// which passes the events on to a tracer, if not nil
+func newdeliverylog(next pubsub.EventTracer) *deliverylog {
+	return &deliverylog{records: make(map[string]*deliveryrecord), next: next}
+}
+
+// A function that returns the ID of a message from its PubSub message ID, which is
+// the sender followed by the sequence number of the message with the default IDs
+func deliveryid(msgid []byte) string {
+	if len(msgid) > 8 {
+		msgid = msgid[len(msgid)-8:]
+	}
+
+	return hex.EncodeToString(msgid)
+}
+
+// A method of deliverylog that returns the record of a message, creating it if
+// it is not known, in which case the oldest record is forgotten if there are
+// too many. Must be called with the lock held.
+func (log *deliverylog) record(msgid []byte, topic string) *deliveryrecord {
+	id := deliveryid(msgid)
+	if record, ok := log.records[id]; ok {
+		if record.topic == "" {
+			record.topic = topic
+		}
+		return record
+	}
+
+	record := &deliveryrecord{id: id, topic: topic, seen: time.Now()}
+	log.records[id] = record
+	log.order = append(log.order, id)
+
+	if len(log.order) > maxdeliveries {
+		delete(log.records, log.order[0])
+		log.order = log.order[1:]
+	}
+
+	return record
+}
+
+// A function that adds a peer to a list of peers, unless it is in the list already
+func addpeer(peers []peer.ID, peerid peer.ID) []peer.ID {
+	for _, known := range peers {
+		if known == peerid {
+			return peers
+		}
+	}
+
+	return append(peers, peerid)
+}
+
+// A method of deliverylog that records a PubSub event of the
+// host and passes it on. Satisfies the pubsub.EventTracer interface.
+func (log *deliverylog) Trace(evt *pb.TraceEvent) {
+	log.lock.Lock()
+	switch evt.GetType() {
+	case pb.TraceEvent_PUBLISH_MESSAGE:
+		publish := evt.GetPublishMessage()
+		log.record(publish.GetMessageID(), publish.GetTopic()).published = true
+
+	case pb.TraceEvent_REJECT_MESSAGE:
+		reject := evt.GetRejectMessage()
+		log.record(reject.GetMessageID(), reject.GetTopic()).rejected = reject.GetReason()
+
+	case pb.TraceEvent_DUPLICATE_MESSAGE:
+		duplicate := evt.GetDuplicateMessage()
+		record := log.record(duplicate.GetMessageID(), duplicate.GetTopic())
+		record.duplicates = addpeer(record.duplicates, peer.ID(duplicate.GetReceivedFrom()))
+
+	case pb.TraceEvent_RECV_RPC:
+		from := peer.ID(evt.GetRecvRPC().GetReceivedFrom())
+		meta := evt.GetRecvRPC().GetMeta()
+		for _, message := range meta.GetMessages() {
+			if record := log.record(message.GetMessageID(), message.GetTopic()); record.from == "" && !record.published {
+				record.from = from
+			}
+		}
+		for _, iwant := range meta.GetControl().GetIwant() {
+			for _, msgid := range iwant.GetMessageIDs() {
+				if record, ok := log.records[deliveryid(msgid)]; ok {
+					record.wanted = addpeer(record.wanted, from)
+				}
+			}
+		}
+
+	case pb.TraceEvent_SEND_RPC:
+		to := peer.ID(evt.GetSendRPC().GetSendTo())
+		meta := evt.GetSendRPC().GetMeta()
+		for _, message := range meta.GetMessages() {
+			record := log.record(message.GetMessageID(), message.GetTopic())
+			record.sentto = addpeer(record.sentto, to)
+		}
+		for _, ihave := range meta.GetControl().GetIhave() {
+			for _, msgid := range ihave.GetMessageIDs() {
+				if record, ok := log.records[deliveryid(msgid)]; ok {
+					record.announced = addpeer(record.announced, to)
+				}
+			}
+		}
+	}
+	log.lock.Unlock()
+
+	if log.next != nil {
+		log.next.Trace(evt)
+	}
+}
+
+// A method of deliverylog that returns copies of the records of the latest messages
+// of a topic, newest first, whose IDs start with a prefix (all if the prefix is empty)
+func (log *deliverylog) find(topic string, prefix string, limit int) []deliveryrecord {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	var found []deliveryrecord
+	for index := len(log.order) - 1; index >= 0 && len(found) < limit; index-- {
+		record := log.records[log.order[index]]
+		if record.topic == topic && strings.HasPrefix(record.id, prefix) {
+			found = append(found, *record)
+		}
+	}
+
+	return found
+}
+
+// A method of deliveryrecord that returns a line summarizing the delivery of the message
+func (record deliveryrecord) summary() string {
+	origin := "published by you"
+	if !record.published {
+		origin = "from " + shortpeer(record.from)
+	}
+
+	return fmt.Sprintf("%s %s %s, forwarded to %d, announced to %d, %d had it", record.id, record.seen.Format("15:04:05"), origin, len(record.sentto), len(record.announced), len(record.duplicates))
+}
+
+// A method of deliveryrecord that returns the lines describing the delivery of the message
+func (record deliveryrecord) describe() []string {
+	lines := []string{record.summary()}
+	if record.rejected != "" {
+		lines = append(lines, "rejected: "+record.rejected)
+	}
+
+	lines = append(lines,
+		"forwarded to the mesh peers: "+peerlist(record.sentto),
+		"announced with gossip to: "+peerlist(record.announced),
+		"asked for after the announcement by: "+peerlist(record.wanted),
+		"sent back by (they had it): "+peerlist(record.duplicates),
+	)
+
+	if !record.published && len(record.sentto) == 0 && len(record.announced) == 0 {
+		lines = append(lines, "the message was not passed on, no other mesh peer needed it from the host")
+	}
+	if record.published && len(record.sentto) == 0 {
+		lines = append(lines, "the message reached no mesh peer, peers that were not connected to the host may not have it")
+	}
+
+	return lines
+}
+
+// A function that returns the short form of a peer ID, or 'unknown' if it is empty
+func shortpeer(peerid peer.ID) string {
+	if peerid == "" {
+		return "unknown"
+	}
+
+	return peerid.ShortString()
+}
+
+// A function that returns a list of peers in their short form, or 'none'
+func peerlist(peers []peer.ID) string {
+	if len(peers) == 0 {
+		return "none"
+	}
+
+	names := make([]string, len(peers))
+	for index, peerid := range peers {
+		names[index] = peerid.ShortString()
+	}
+
+	return strings.Join(names, ", ")
+}
+
+// A method of P2P that returns the delivery records of the latest messages of a
+// room, newest first, whose IDs start with a prefix (all if the prefix is empty)
+func (p2p *P2P) Deliveries(room string, prefix string, limit int) []deliveryrecord {
+	return p2p.deliveries.find(roomtopic(room), strings.ToLower(prefix), limit)
+}
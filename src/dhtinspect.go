@@ -0,0 +1,247 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/ipfs/go-cid"
+	"github.com/libp2p/go-libp2p-core/peer"
+	dht "github.com/libp2p/go-libp2p-kad-dht"
+	kbucket "github.com/libp2p/go-libp2p-kbucket"
+	"github.com/rivo/tview"
+)
+
+// Represents the number of recent DHT queries that are remembered
+const maxqueries = 32
+
+// Represents the number of closest peers shown by the DHT inspector
+const closestpeers = 10
+
+// A structure that represents a DHT query made by the host
+type dhtquery struct {
+	// Represents the kind of the query (findpeer, getvalue, putvalue or provide)
+	kind string
+	// Represents the key, peer or CID the query was for
+	target string
+	// Represents when the query started
+	started time.Time
+	// Represents the time the query took
+	took time.Duration
+	// Represents the reason the query failed, empty if it succeeded
+	err string
+}
+
+// A structure that represents the recent DHT queries of a host
+type querylog struct {
+	// Represents the recent queries, oldest first
+	queries []dhtquery
+	// Represents the lock on the queries
+	mutex sync.Mutex
+}
+
+// A method of querylog that records a query that has finished
+func (log *querylog) record(kind string, target string, started time.Time, err error) {
+	query := dhtquery{kind: kind, target: target, started: started, took: time.Since(started)}
+	if err != nil {
+		query.err = err.Error()
+	}
+
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	log.queries = append(log.queries, query)
+	if len(log.queries) > maxqueries {
+		log.queries = log.queries[len(log.queries)-maxqueries:]
+	}
+}
+
+// A method of querylog that returns the recent queries, newest first
+func (log *querylog) recent() []dhtquery {
+	log.mutex.Lock()
+	defer log.mutex.Unlock()
+
+	queries := make([]dhtquery, len(log.queries))
+	for index, query := range log.queries {
+		queries[len(queries)-1-index] = query
+	}
+
+	return queries
+}
+
+// A method of P2P that looks up the addresses of a peer in the DHT and records the query
+func (p2p *P2P) findpeer(ctx context.Context, peerid peer.ID) (peer.AddrInfo, error) {
+	started := time.Now()
+	addrinfo, err := p2p.KadDHT.FindPeer(ctx, peerid)
+	p2p.queries.record("findpeer", peerid.ShortString(), started, err)
+
+	return addrinfo, err
+}
+
+// A method of P2P that gets a value from the DHT and records the query
+func (p2p *P2P) getvalue(ctx context.Context, key string) ([]byte, error) {
+	started := time.Now()
+	value, err := p2p.KadDHT.GetValue(ctx, key)
+	p2p.queries.record("getvalue", key, started, err)
+
+	return value, err
+}
+
+// A method of P2P that puts a value into the DHT and records the query
+func (p2p *P2P) putvalue(ctx context.Context, key string, value []byte) error {
+	started := time.Now()
+	err := p2p.KadDHT.PutValue(ctx, key, value)
+	p2p.queries.record("putvalue", key, started, err)
+
+	return err
+}
+
+// A method of P2P that announces that the host provides a CID and records the query
+func (p2p *P2P) provide(ctx context.Context, key cid.Cid) error {
+	started := time.Now()
+	err := p2p.KadDHT.Provide(ctx, key, true)
+	p2p.queries.record("provide", key.String(), started, err)
+
+	return err
+}
+
+// A structure that represents a peer in the DHT routing table
+type routingpeer struct {
+	// Represents the peer ID of the peer
+	id peer.ID
+	// Represents the length of the common prefix of the DHT IDs of the host and the peer
+	cpl int
+	// Represents the latency to the peer (0 if unknown)
+	latency time.Duration
+	// Represents when the peer last answered a query usefully
+	useful time.Time
+}
+
+// A structure that represents a snapshot of the DHT of a host
+type dhtsnapshot struct {
+	// Represents the mode of the DHT (client or server)
+	mode string
+	// Represents the number of peers in the routing table
+	size int
+	// Represents the number of peers in the routing table by common prefix length
+	buckets []int
+	// Represents the peers of the routing table closest to the host
+	closest []routingpeer
+	// Represents the recent queries of the host, newest first
+	queries []dhtquery
+}
+
+// A method of P2P that takes a snapshot of the routing table and recent queries of the DHT
+func (p2p *P2P) DHTSnapshot() dhtsnapshot {
+	table := p2p.KadDHT.RoutingTable()
+	self := kbucket.ConvertPeerID(p2p.Host.ID())
+
+	snapshot := dhtsnapshot{mode: "client", size: table.Size(), queries: p2p.queries.recent()}
+	if p2p.KadDHT.Mode() == dht.ModeServer {
+		snapshot.mode = "server"
+	}
+
+	useful := make(map[peer.ID]time.Time)
+	for _, info := range table.GetPeerInfos() {
+		cpl := kbucket.CommonPrefixLen(self, kbucket.ConvertPeerID(info.Id))
+		for len(snapshot.buckets) <= cpl {
+			snapshot.buckets = append(snapshot.buckets, 0)
+		}
+		snapshot.buckets[cpl]++
+		useful[info.Id] = info.LastUsefulAt
+	}
+
+	for _, peerid := range table.NearestPeers(self, closestpeers) {
+		snapshot.closest = append(snapshot.closest, routingpeer{
+			id:      peerid,
+			cpl:     kbucket.CommonPrefixLen(self, kbucket.ConvertPeerID(peerid)),
+			latency: p2p.Host.Peerstore().LatencyEWMA(peerid),
+			useful:  useful[peerid],
+		})
+	}
+
+	return snapshot
+}
+
+// A function that describes how long ago a time was, or 'never'
+func ago(t time.Time) string {
+	if t.IsZero() {
+		return "never"
+	}
+
+	return time.Since(t).Round(time.Second).String() + " ago"
+}
+
+// A method of dhtsnapshot that renders the snapshot for the DHT inspector
+func (snapshot dhtsnapshot) render() string {
+	accent := fmt.Sprintf("#%06x", currentconfig().accent().Hex())
+	var builder strings.Builder
+
+	fmt.Fprintf(&builder, "[%s]Routing table[-]: %d peers, %s mode\n\n", accent, snapshot.size, snapshot.mode)
+
+	fmt.Fprintf(&builder, "[%s]Buckets[-] (peers by common prefix length)\n", accent)
+	if len(snapshot.buckets) == 0 {
+		builder.WriteString("  the routing table is empty, the node is not connected to the DHT\n")
+	}
+	for cpl, count := range snapshot.buckets {
+		fmt.Fprintf(&builder, "  %3d %3d %s\n", cpl, count, strings.Repeat("▇", count))
+	}
+
+	fmt.Fprintf(&builder, "\n[%s]Closest peers[-]\n", accent)
+	for _, p := range snapshot.closest {
+		latency := "-"
+		if p.latency > 0 {
+			latency = p.latency.Round(time.Millisecond).String()
+		}
+		fmt.Fprintf(&builder, "  %s  cpl %-3d latency %-8s useful %s\n", p.id.ShortString(), p.cpl, latency, ago(p.useful))
+	}
+
+	fmt.Fprintf(&builder, "\n[%s]Recent queries[-]\n", accent)
+	if len(snapshot.queries) == 0 {
+		builder.WriteString("  no queries yet\n")
+	}
+	for _, query := range snapshot.queries {
+		result := "[green]ok[-]"
+		if query.err != "" {
+			result = "[red]" + tview.Escape(query.err) + "[-]"
+		}
+		fmt.Fprintf(&builder, "  %-8s %8s  %s  %s  (%s)\n", query.kind, query.took.Round(time.Millisecond), tview.Escape(stripcontrols(query.target)), result, ago(query.started))
+	}
+
+	return builder.String()
+}
+
+// A method of UI that shows the DHT inspector with a snapshot of the DHT.
+// Must be called from the tview event loop.
+func (ui *UI) showdht(snapshot dhtsnapshot) {
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	view.SetBorder(true).SetTitle("DHT (r to refresh, Esc to close)")
+	view.SetText(snapshot.render())
+
+	view.SetDoneFunc(func(key tcell.Key) {
+		ui.pages.RemovePage("dht")
+		ui.TerminalApp.SetFocus(ui.inputBox)
+	})
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 'r' {
+			view.SetText(ui.Host.DHTSnapshot().render())
+			return nil
+		}
+		return event
+	})
+
+	// Center the inspector on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, 30, 1, true).
+			AddItem(nil, 0, 1, false), 100, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("dht", popup, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
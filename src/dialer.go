@@ -0,0 +1,214 @@
+package src
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time that the discovered peers are collected for before they are dialed,
+// the number of peers dialed at once and the timeout of dialing a discovered peer
+const (
+	dialwindow   = time.Second
+	dialbatch    = 32
+	dialparallel = 8
+	dialtimeout  = 15 * time.Second
+)
+
+// Represents the backoff after the first failed dial of a peer,
+// which doubles with each failure up to the maximum backoff
+const (
+	dialbackoff    = 30 * time.Second
+	maxdialbackoff = time.Hour
+)
+
+// A structure that represents the dial history of a discovered peer
+type peerquality struct {
+	// Represents the number of dials that failed in a row
+	failures int
+	// Represents the time before which the peer is not dialed again
+	backoff time.Time
+	// Represents the moving average of the time it took to connect to the peer
+	latency time.Duration
+}
+
+// A structure that represents the dial histories of the discovered peers
+type dialbook struct {
+	peers map[peer.ID]*peerquality
+	lock  sync.Mutex
+}
+
+// A constructor function that returns an empty dialbook
+func newdialbook() *dialbook {
+	return &dialbook{peers: make(map[peer.ID]*peerquality)}
+}
+
+// A method of dialbook that returns whether a peer can be dialed, which is
+// false while the peer is backing off after failed dials
+func (book *dialbook) dialable(peerid peer.ID) bool {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	quality, ok := book.peers[peerid]
+	return !ok || time.Now().After(quality.backoff)
+}
+
+// A method of dialbook that records the outcome of a dial of a peer. A failed dial
+// backs the peer off exponentially, and a successful one resets its failures and
+// updates the average time it takes to connect to it.
+func (book *dialbook) record(peerid peer.ID, err error, took time.Duration) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	quality, ok := book.peers[peerid]
+	if !ok {
+		quality = &peerquality{}
+		book.peers[peerid] = quality
+	}
+
+	if err != nil {
+		quality.failures++
+		backoff := dialbackoff << uint(minint(quality.failures-1, 16))
+		if backoff > maxdialbackoff {
+			backoff = maxdialbackoff
+		}
+		quality.backoff = time.Now().Add(backoff)
+		return
+	}
+
+	quality.failures, quality.backoff = 0, time.Time{}
+	if quality.latency == 0 {
+		quality.latency = took
+	} else {
+		quality.latency = (quality.latency*3 + took) / 4
+	}
+}
+
+// A method of dialbook that returns the rank of a peer for dialing, which
+// is lower for better peers: peers that failed less, then faster peers.
+// Peers that were never dialed rank behind the known responsive peers.
+func (book *dialbook) rank(peerid peer.ID) (int, time.Duration) {
+	book.lock.Lock()
+	defer book.lock.Unlock()
+
+	quality, ok := book.peers[peerid]
+	if !ok {
+		return 0, dialtimeout
+	}
+
+	return quality.failures, quality.latency
+}
+
+// A method of P2P that connects to the peers received from a channel of discovered
+// peers until it closes. The peers are collected for a short time and dialed in
+// order of quality, a few at a time, skipping the connected peers and the peers
+// that are backing off after failed dials.
+func (p2p *P2P) handlediscovery(peerchan <-chan peer.AddrInfo) {
+	for {
+		batch, open := collectpeers(peerchan)
+		p2p.dialpeers(batch)
+
+		if !open {
+			return
+		}
+	}
+}
+
+// A function that collects the peers received from a channel of discovered peers
+// until the dial window passes, the batch is full or the channel closes, which is
+// reported by returning false. Waits for the first peer of the batch.
+func collectpeers(peerchan <-chan peer.AddrInfo) ([]peer.AddrInfo, bool) {
+	first, ok := <-peerchan
+	if !ok {
+		return nil, false
+	}
+
+	batch := []peer.AddrInfo{first}
+	window := time.NewTimer(dialwindow)
+	defer window.Stop()
+
+	for len(batch) < dialbatch {
+		select {
+		case addrinfo, ok := <-peerchan:
+			if !ok {
+				return batch, false
+			}
+			batch = append(batch, addrinfo)
+
+		case <-window.C:
+			return batch, true
+		}
+	}
+
+	return batch, true
+}
+
+// A method of P2P that dials a batch of discovered peers in order of quality
+func (p2p *P2P) dialpeers(batch []peer.AddrInfo) {
+	candidates := make([]peer.AddrInfo, 0, len(batch))
+	for _, addrinfo := range batch {
+		if addrinfo.ID == p2p.Host.ID() || p2p.Host.Network().Connectedness(addrinfo.ID) == network.Connected {
+			continue
+		}
+		if !p2p.dials.dialable(addrinfo.ID) {
+			continue
+		}
+
+		candidates = append(candidates, addrinfo)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		ifailures, ilatency := p2p.dials.rank(candidates[i].ID)
+		jfailures, jlatency := p2p.dials.rank(candidates[j].ID)
+		if ifailures != jfailures {
+			return ifailures < jfailures
+		}
+
+		return ilatency < jlatency
+	})
+
+	var wait sync.WaitGroup
+	slots := make(chan struct{}, dialparallel)
+	for _, addrinfo := range candidates {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case slots <- struct{}{}:
+		}
+
+		wait.Add(1)
+		go func(addrinfo peer.AddrInfo) {
+			defer wait.Done()
+			defer func() { <-slots }()
+
+			p2p.dial(addrinfo)
+		}(addrinfo)
+	}
+
+	wait.Wait()
+}
+
+// A method of P2P that dials a discovered peer and records the outcome
+func (p2p *P2P) dial(addrinfo peer.AddrInfo) {
+	ctx, cancel := context.WithTimeout(p2p.Ctx, dialtimeout)
+	defer cancel()
+
+	started := time.Now()
+	err := p2p.Host.Connect(ctx, addrinfo)
+	p2p.dials.record(addrinfo.ID, err, time.Since(started))
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  addrinfo.ID.Pretty(),
+		}).Traceln("Failed to Connect to a Discovered Peer!")
+		return
+	}
+
+	reportstartup("discovery", "connected to peer %s", addrinfo.ID.ShortString())
+}
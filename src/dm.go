@@ -0,0 +1,249 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the protocol ID for direct messages between peers
+const dmprotocol = protocol.ID("/peerchat/dm/1.0.0")
+
+// Represents the time allowed to open a direct message stream
+const dmtimeout = 10 * time.Second
+
+// Represents the maximum size of a direct message frame
+const maxdmframe = 16 * 1024
+
+// Represents the tag that protects the connections with the peers of
+// open direct message streams from being pruned by the connection manager
+const dmprotecttag = "peerchat-dm"
+
+// A structure that represents a frame of a direct message stream. Each
+// conversation uses a single long-lived stream, which is encrypted and
+// authenticated by the libp2p connection, with one JSON frame per line.
+type dmframe struct {
+	// Represents the type of the frame: 'message', 'typing', 'receipt'
+	// (a message was received), 'read' (messages were read up to one)
+	// or 'game' (a frame of a game)
+	Type string `json:"type"`
+	// Represents the ID of a message, or of the message a receipt is for
+	ID string `json:"id,omitempty"`
+	// Represents the user name of the sender
+	Name string `json:"name,omitempty"`
+	// Represents the text of a message
+	Text string `json:"text,omitempty"`
+	// Represents the frame of a game
+	Game *gameframe `json:"game,omitempty"`
+}
+
+// A structure that represents a frame received from a peer
+type dmevent struct {
+	// Represents the peer that sent the frame
+	peer peer.ID
+	// Represents the frame
+	frame dmframe
+	// Represents whether the event was raised by the host itself
+	// about a queued message ('flushed'), rather than sent by the peer
+	local bool
+}
+
+// A structure that represents the direct message stream with a peer
+type dmstream struct {
+	// Represents the stream
+	stream network.Stream
+	// Represents the encoder of the frames written to the stream
+	encoder *json.Encoder
+	// Represents the lock on writes to the stream
+	mutex sync.Mutex
+}
+
+// A structure that represents the direct message streams of a host
+type dmmanager struct {
+	// Represents the open streams indexed by peer
+	streams map[peer.ID]*dmstream
+	// Represents the received frames, read by the UI
+	events chan dmevent
+	// Represents the messages queued for the peers that cannot be reached
+	queues map[peer.ID]*dmqueue
+	// Represents the lock on the streams
+	mutex sync.Mutex
+}
+
+// A constructor function that generates and returns an empty dmmanager
+func newdmmanager() *dmmanager {
+	return &dmmanager{
+		streams: make(map[peer.ID]*dmstream),
+		queues:  make(map[peer.ID]*dmqueue),
+		events:  make(chan dmevent, 64),
+	}
+}
+
+// A method of P2P that returns the channel of the direct message frames
+// received from peers. Frames are dropped when the channel is not read.
+func (p2p *P2P) DMEvents() <-chan dmevent {
+	return p2p.dms.events
+}
+
+// A method of P2P that returns the direct message stream with a peer,
+// opening it if there is none
+func (p2p *P2P) dmstream(peerid peer.ID) (*dmstream, error) {
+	p2p.dms.mutex.Lock()
+	existing, ok := p2p.dms.streams[peerid]
+	p2p.dms.mutex.Unlock()
+
+	if ok {
+		return existing, nil
+	}
+
+	ctx, cancel := context.WithTimeout(p2p.Ctx, dmtimeout)
+	defer cancel()
+
+	stream, err := p2p.Host.NewStream(ctx, peerid, dmprotocol)
+	if err != nil {
+		return nil, err
+	}
+
+	return p2p.adddmstream(peerid, stream), nil
+}
+
+// A method of P2P that adds the direct message stream with a peer,
+// replacing any previous stream, and starts reading its frames
+func (p2p *P2P) adddmstream(peerid peer.ID, stream network.Stream) *dmstream {
+	ds := &dmstream{stream: stream, encoder: json.NewEncoder(stream)}
+
+	p2p.dms.mutex.Lock()
+	if previous, ok := p2p.dms.streams[peerid]; ok {
+		previous.stream.Close()
+	}
+	p2p.dms.streams[peerid] = ds
+	p2p.dms.mutex.Unlock()
+
+	// Keep the connection with the peer while the conversation is open
+	p2p.Host.ConnManager().Protect(peerid, dmprotecttag)
+
+	go p2p.readdm(peerid, ds)
+	return ds
+}
+
+// A method of P2P that removes the direct message stream with a peer
+func (p2p *P2P) removedmstream(peerid peer.ID, ds *dmstream) {
+	p2p.dms.mutex.Lock()
+	if current, ok := p2p.dms.streams[peerid]; ok && current == ds {
+		delete(p2p.dms.streams, peerid)
+		p2p.Host.ConnManager().Unprotect(peerid, dmprotecttag)
+	}
+	p2p.dms.mutex.Unlock()
+
+	ds.stream.Reset()
+}
+
+// A method of P2P that handles the direct message streams opened by peers
+func (p2p *P2P) handledm(stream network.Stream) {
+	p2p.adddmstream(stream.Conn().RemotePeer(), stream)
+}
+
+// A method of P2P that reads the frames of a direct message stream until it closes
+func (p2p *P2P) readdm(peerid peer.ID, ds *dmstream) {
+	defer p2p.removedmstream(peerid, ds)
+
+	scanner := bufio.NewScanner(ds.stream)
+	scanner.Buffer(make([]byte, 4096), maxdmframe)
+
+	for scanner.Scan() {
+		var frame dmframe
+		if err := json.Unmarshal(scanner.Bytes(), &frame); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"peer":  peerid.Pretty(),
+			}).Debugln("Dropped a Direct Message Frame.")
+			continue
+		}
+
+		select {
+		case p2p.dms.events <- dmevent{peer: peerid, frame: frame}:
+		default:
+		}
+	}
+}
+
+// A method of P2P that writes a frame to the direct message stream with a peer
+func (p2p *P2P) senddm(peerid peer.ID, frame dmframe) error {
+	if peerid == p2p.Host.ID() {
+		return errors.New("cannot send direct messages to yourself")
+	}
+
+	ds, err := p2p.dmstream(peerid)
+	if err != nil {
+		return err
+	}
+
+	ds.mutex.Lock()
+	ds.stream.SetWriteDeadline(time.Now().Add(dmtimeout))
+	err = ds.encoder.Encode(frame)
+	ds.mutex.Unlock()
+
+	if err != nil {
+		p2p.removedmstream(peerid, ds)
+		return err
+	}
+
+	return nil
+}
+
+// A method of P2P that sends a direct message with an ID to a peer. The
+// message has been written to the stream when it returns, and the peer
+// answers with a receipt once it is received and when it is read. If the
+// peer cannot be reached, the message is queued and errdmqueued is returned,
+// and a local 'flushed' event follows once the message has been sent.
+func (p2p *P2P) SendDM(peerid peer.ID, id string, name string, text string) error {
+	if len(text) > chunklength {
+		return fmt.Errorf("direct messages are limited to %d bytes", chunklength)
+	}
+	if peerid == p2p.Host.ID() {
+		return errors.New("cannot send direct messages to yourself")
+	}
+
+	frame := dmframe{Type: "message", ID: id, Name: name, Text: text}
+
+	// Keep the order of the messages queued for the peer
+	if p2p.dmqueued(peerid) {
+		return p2p.queuedm(peerid, frame)
+	}
+
+	if err := p2p.senddm(peerid, frame); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  peerid.Pretty(),
+		}).Debugln("Queued a Direct Message.")
+
+		return p2p.queuedm(peerid, frame)
+	}
+
+	return nil
+}
+
+// A method of P2P that tells a peer that a direct message was received ('receipt')
+// or that the direct messages up to one were read ('read'). Best effort.
+func (p2p *P2P) SendReceipt(peerid peer.ID, kind string, id string) {
+	if err := p2p.senddm(peerid, dmframe{Type: kind, ID: id}); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  peerid.Pretty(),
+		}).Debugln("Failed to Send a Direct Message Receipt!")
+	}
+}
+
+// A method of P2P that tells a peer that the user is typing a direct message to them
+func (p2p *P2P) SendTyping(peerid peer.ID, name string) error {
+	return p2p.senddm(peerid, dmframe{Type: "typing", Name: name})
+}
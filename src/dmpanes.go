@@ -0,0 +1,350 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time a typing indicator is shown after the last typing frame
+const typingtimeout = 5 * time.Second
+
+// Represents the minimum time between the typing frames sent to a peer
+const typinginterval = 3 * time.Second
+
+// Represents the number of direct messages restored into a new pane
+const dmhistorylimit = 200
+
+// A structure that represents the pane of a direct message conversation
+type dmpane struct {
+	// Represents the peer of the conversation
+	peer peer.ID
+	// Represents the user name of the peer
+	name string
+	// Represents the view of the messages of the conversation
+	view *tview.TextView
+	// Represents the number of messages received while the pane was not shown
+	unread int
+	// Represents when the peer last said they were typing
+	typing time.Time
+	// Represents the messages of the conversation with their delivery status
+	entries []dmentry
+	// Represents the ID of the last message received from the peer, and
+	// the ID of the last message the peer was told has been read
+	lastreceived string
+	lastread     string
+	// Represents the lock that serializes the rendering of the pane
+	render sync.Mutex
+}
+
+// A function that returns the name of the page of a direct message pane
+func dmpage(peerid peer.ID) string {
+	return "dm-" + peerid.Pretty()
+}
+
+// A function that returns the name under which the history of the
+// direct messages with a peer is kept, which cannot clash with rooms
+func dmhistoryroom(peerid peer.ID) string {
+	return "@" + peerid.Pretty()
+}
+
+// A method of UI that returns the pane of the conversation with a peer,
+// opening it with its history if there is none. The pane is not shown.
+// Must not be called from the tview event loop.
+func (ui *UI) openpane(peerid peer.ID, name string) *dmpane {
+	ui.stateLock.Lock()
+	if pane, ok := ui.dms[peerid]; ok {
+		if name != "" {
+			pane.name = name
+		}
+		ui.stateLock.Unlock()
+		return pane
+	}
+
+	if name == "" {
+		name = ui.nicks[peerid]
+	}
+	if name == "" {
+		name = peerid.ShortString()
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetChangedFunc(func() {
+			ui.TerminalApp.Draw()
+		})
+
+	view.
+		SetBorder(true).
+		SetBorderColor(currentconfig().accent()).
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(tcell.ColorWhite)
+
+	pane := &dmpane{peer: peerid, name: name, view: view}
+	ui.dms[peerid] = pane
+	ui.dmorder = append(ui.dmorder, peerid)
+	ui.stateLock.Unlock()
+
+	// Restore the history of the conversation
+	if ui.dmhistory != nil {
+		messages, _ := ui.dmhistory.load(dmhistoryroom(peerid), dmhistorylimit)
+		ui.stateLock.Lock()
+		for _, msg := range messages {
+			pane.entries = append(pane.entries, dmentry{msg: msg.ChatMessage})
+		}
+		ui.stateLock.Unlock()
+
+		ui.renderpane(pane)
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		ui.convPages.AddPage(dmpage(peerid), view, true, false)
+		ui.syncpanetitle(pane)
+		ui.synctabs()
+	})
+
+	return pane
+}
+
+// A method of UI that keeps a direct message in the history, if it is kept
+func (ui *UI) recorddm(peerid peer.ID, msg ChatMessage) {
+	if ui.dmhistory == nil {
+		return
+	}
+
+	room := dmhistoryroom(peerid)
+	ui.dmhistory.append(room, GatewayMessage{ChatMessage: msg, Room: room, Timestamp: time.Now()})
+}
+
+// A method of UI that handles a frame received from a peer.
+// Called from the UI event handler.
+func (ui *UI) display_dmevent(event dmevent) {
+	if event.local {
+		ui.display_dmflush(event)
+		return
+	}
+
+	switch event.frame.Type {
+	case "message":
+		pane := ui.openpane(event.peer, event.frame.Name)
+		msg := ChatMessage{Message: event.frame.Text, SenderID: event.peer.Pretty(), SenderName: pane.name}
+
+		ui.adddm(pane, dmentry{msg: msg, id: event.frame.ID})
+		ui.recorddm(event.peer, msg)
+
+		// Acknowledge the message, and tell the peer it was read if the pane is shown
+		go ui.Host.SendReceipt(event.peer, "receipt", event.frame.ID)
+
+		ui.stateLock.Lock()
+		pane.typing = time.Time{}
+		pane.lastreceived = event.frame.ID
+		shown := ui.activedm == event.peer
+		if !shown {
+			pane.unread++
+		}
+		ui.stateLock.Unlock()
+
+		if shown {
+			ui.markread(pane)
+		} else {
+			ui.notifier.Notify(ui.RoomName, fmt.Sprintf("%s sent you a direct message", pane.name), msg.Message)
+		}
+
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			ui.syncpanetitle(pane)
+			ui.synctabs()
+		})
+
+	case "typing":
+		ui.stateLock.Lock()
+		pane, ok := ui.dms[event.peer]
+		if ok {
+			pane.typing = time.Now()
+		}
+		ui.stateLock.Unlock()
+
+		if ok {
+			ui.TerminalApp.QueueUpdateDraw(func() {
+				ui.syncpanetitle(pane)
+			})
+		}
+
+	case "game":
+		ui.display_gameevent(event)
+
+	case "receipt", "read":
+		ui.stateLock.Lock()
+		pane, ok := ui.dms[event.peer]
+		ui.stateLock.Unlock()
+
+		if ok {
+			ui.updatestatus(pane, event.frame.ID, event.frame.Type == "read")
+		}
+	}
+}
+
+// A method of UI that handles a queued direct message that was sent once its
+// peer could be reached again. The user is told when the queue is flushed.
+// Called from the UI event handler.
+func (ui *UI) display_dmflush(event dmevent) {
+	ui.stateLock.Lock()
+	pane, ok := ui.dms[event.peer]
+	ui.stateLock.Unlock()
+
+	if !ok || ui.flushstatus(pane, event.frame.ID) {
+		return
+	}
+
+	ui.display_logmessage(chatlog{
+		loglevel:  logrus.InfoLevel,
+		logprefix: "dm",
+		logmsg:    fmt.Sprintf("%s is reachable again, the queued direct messages were sent", pane.name),
+	})
+	ui.notifier.Notify(ui.RoomName, "Direct messages sent", fmt.Sprintf("The queued direct messages to %s were sent", pane.name))
+}
+
+// A method of UI that clears the typing indicators that have expired.
+// Called from the UI event handler.
+func (ui *UI) synctyping() {
+	var expired []*dmpane
+
+	ui.stateLock.Lock()
+	for _, pane := range ui.dms {
+		if !pane.typing.IsZero() && time.Since(pane.typing) > typingtimeout {
+			pane.typing = time.Time{}
+			expired = append(expired, pane)
+		}
+	}
+	ui.stateLock.Unlock()
+
+	if len(expired) == 0 {
+		return
+	}
+
+	ui.TerminalApp.QueueUpdateDraw(func() {
+		for _, pane := range expired {
+			ui.syncpanetitle(pane)
+		}
+	})
+}
+
+// A method of UI that updates the title of a pane with the typing
+// indicator of its peer. Must be called from the tview event loop.
+func (ui *UI) syncpanetitle(pane *dmpane) {
+	ui.stateLock.Lock()
+	title := fmt.Sprintf("DM-%s", pane.name)
+	if !pane.typing.IsZero() {
+		title += " (typing...)"
+	}
+	ui.stateLock.Unlock()
+
+	pane.view.SetTitle(tview.Escape(stripcontrols(title)))
+}
+
+// A method of UI that shows the pane of the conversation with a
+// peer and marks it as read. Must be called from the tview event loop.
+func (ui *UI) showpane(peerid peer.ID) {
+	ui.stateLock.Lock()
+	pane, ok := ui.dms[peerid]
+	if ok {
+		ui.activedm = peerid
+		pane.unread = 0
+	}
+	ui.stateLock.Unlock()
+
+	if !ok {
+		return
+	}
+
+	ui.convPages.SwitchToPage(dmpage(peerid))
+	ui.inputBox.SetTitle(tview.Escape(fmt.Sprintf("Input (DM with %s)", stripcontrols(pane.name))))
+	ui.synctabs()
+
+	go ui.markread(pane)
+}
+
+// A method of UI that shows the messages of the current
+// room again. Must be called from the tview event loop.
+func (ui *UI) showroomview() {
+	ui.stateLock.Lock()
+	ui.activedm = ""
+	ui.stateLock.Unlock()
+
+	ui.convPages.SwitchToPage("room")
+	ui.inputBox.SetTitle("Input")
+	ui.synctabs()
+}
+
+// A method of UI that closes the pane of the conversation with
+// a peer. Must be called from the tview event loop.
+func (ui *UI) closepane(peerid peer.ID) {
+	ui.stateLock.Lock()
+	delete(ui.dms, peerid)
+	for index, open := range ui.dmorder {
+		if open == peerid {
+			ui.dmorder = append(ui.dmorder[:index], ui.dmorder[index+1:]...)
+			break
+		}
+	}
+	active := ui.activedm == peerid
+	ui.stateLock.Unlock()
+
+	if active {
+		ui.showroomview()
+	}
+
+	ui.convPages.RemovePage(dmpage(peerid))
+	ui.synctabs()
+}
+
+// A method of UI that returns the peer of the shown pane (empty if the room is shown)
+func (ui *UI) shownpane() peer.ID {
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	return ui.activedm
+}
+
+// A method of UI that sends a direct message to a peer and writes it into
+// their pane, where its delivery status is shown as it changes
+func (ui *UI) senddm(peerid peer.ID, text string) {
+	pane := ui.openpane(peerid, "")
+
+	id := chunkid()
+	msg := ChatMessage{Message: text, SenderID: ui.selfid.Pretty(), SenderName: ui.UserName}
+	ui.adddm(pane, dmentry{msg: msg, id: id, status: dmsending})
+
+	switch err := ui.Host.SendDM(peerid, id, ui.UserName, text); {
+	case errors.Is(err, errdmqueued):
+		// The message is sent once the peer can be reached again
+		ui.setstatus(pane, id, dmqueued, "")
+		ui.recorddm(peerid, msg)
+		return
+	case err != nil:
+		ui.setstatus(pane, id, dmfailed, err.Error())
+		return
+	}
+
+	ui.setstatus(pane, id, dmsent, "")
+	ui.recorddm(peerid, msg)
+}
+
+// A method of UI that tells the peer of the shown pane that the user is
+// typing, at most once per typing interval. Called from the tview event
+// loop when the text of the input field changes.
+func (ui *UI) inputchanged(text string) {
+	peerid := ui.shownpane()
+	if peerid == "" || text == "" || strings.HasPrefix(text, "/") || time.Since(ui.lasttyping) < typinginterval {
+		return
+	}
+
+	ui.lasttyping = time.Now()
+	go ui.Host.SendTyping(peerid, ui.UserName)
+}
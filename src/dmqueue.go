@@ -0,0 +1,166 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the first and the longest wait between the attempts to
+// reach a peer that direct messages are queued for
+const (
+	dmretrymin = 5 * time.Second
+	dmretrymax = 2 * time.Minute
+)
+
+// Represents the maximum number of direct messages queued for a peer
+const maxdmqueue = 256
+
+// Represents the error returned when a direct message could not be
+// sent right away and was queued until the peer can be reached again
+var errdmqueued = errors.New("peer is unreachable, the message is queued")
+
+// A structure that represents the direct messages queued for a peer
+type dmqueue struct {
+	// Represents the queued message frames, in the order they were sent
+	frames []dmframe
+	// Represents the signal that wakes the retries when the peer connects
+	wake chan struct{}
+}
+
+// A method of P2P that queues a direct message frame for a peer, and starts
+// retrying to reach the peer if it is not being retried already
+func (p2p *P2P) queuedm(peerid peer.ID, frame dmframe) error {
+	p2p.dms.mutex.Lock()
+	defer p2p.dms.mutex.Unlock()
+
+	queue, ok := p2p.dms.queues[peerid]
+	if !ok {
+		queue = &dmqueue{wake: make(chan struct{}, 1)}
+		p2p.dms.queues[peerid] = queue
+		go p2p.retrydm(peerid, queue)
+	}
+
+	if len(queue.frames) >= maxdmqueue {
+		return errors.New("too many messages are queued for the peer")
+	}
+
+	queue.frames = append(queue.frames, frame)
+	return errdmqueued
+}
+
+// A method of P2P that returns whether direct messages are queued for a
+// peer, in which case new messages are queued behind them to keep the order
+func (p2p *P2P) dmqueued(peerid peer.ID) bool {
+	p2p.dms.mutex.Lock()
+	defer p2p.dms.mutex.Unlock()
+
+	_, ok := p2p.dms.queues[peerid]
+	return ok
+}
+
+// A method of P2P that retries to reach a peer that direct messages are queued
+// for, backing off between the attempts, until the queue is flushed. Each
+// attempt looks the peer up in the DHT if the host is not connected to it.
+func (p2p *P2P) retrydm(peerid peer.ID, queue *dmqueue) {
+	wait := dmretrymin
+
+	for {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case <-queue.wake:
+		case <-time.After(wait):
+		}
+
+		if err := p2p.reachpeer(peerid); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"peer":  peerid.Pretty(),
+			}).Debugln("Failed to Reach a Direct Message Peer!")
+
+			if wait *= 2; wait > dmretrymax {
+				wait = dmretrymax
+			}
+			continue
+		}
+
+		if p2p.flushdm(peerid, queue) {
+			return
+		}
+
+		wait = dmretrymin
+	}
+}
+
+// A method of P2P that connects to a peer, looking up its
+// addresses in the DHT if the host is not connected to it
+func (p2p *P2P) reachpeer(peerid peer.ID) error {
+	if p2p.Host.Network().Connectedness(peerid) == network.Connected {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(p2p.Ctx, dmtimeout)
+	defer cancel()
+
+	addrinfo, err := p2p.findpeer(ctx, peerid)
+	if err != nil {
+		return err
+	}
+
+	return p2p.Host.Connect(ctx, addrinfo)
+}
+
+// A method of P2P that sends the direct messages queued for a peer in
+// order, and tells the UI of each message sent. Returns whether the queue
+// was flushed, in which case it is removed.
+func (p2p *P2P) flushdm(peerid peer.ID, queue *dmqueue) bool {
+	for {
+		p2p.dms.mutex.Lock()
+		if len(queue.frames) == 0 {
+			delete(p2p.dms.queues, peerid)
+			p2p.dms.mutex.Unlock()
+			return true
+		}
+		frame := queue.frames[0]
+		p2p.dms.mutex.Unlock()
+
+		if err := p2p.senddm(peerid, frame); err != nil {
+			return false
+		}
+
+		p2p.dms.mutex.Lock()
+		queue.frames = queue.frames[1:]
+		p2p.dms.mutex.Unlock()
+
+		select {
+		case p2p.dms.events <- dmevent{peer: peerid, frame: dmframe{Type: "flushed", ID: frame.ID}, local: true}:
+		case <-p2p.Ctx.Done():
+			return false
+		}
+	}
+}
+
+// A method of P2P that wakes the retries of the direct messages queued
+// for a peer when the host connects to it, so they are sent right away
+func (p2p *P2P) wakedm(_ network.Network, conn network.Conn) {
+	p2p.dms.mutex.Lock()
+	defer p2p.dms.mutex.Unlock()
+
+	if queue, ok := p2p.dms.queues[conn.RemotePeer()]; ok {
+		select {
+		case queue.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// A method of P2P that returns a network notifiee that wakes the
+// retries of the queued direct messages when their peers connect
+func (p2p *P2P) dmnotifiee() network.Notifiee {
+	return &network.NotifyBundle{ConnectedF: p2p.wakedm}
+}
@@ -0,0 +1,203 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/rivo/tview"
+)
+
+// A type that represents the delivery status of a direct message
+type dmstatus int
+
+// Represents the delivery statuses of direct messages, in the order they
+// are reached. Received and restored messages have no delivery status.
+const (
+	dmnone dmstatus = iota
+	// The message is being written to the stream
+	dmsending
+	// The message could not be written to the stream
+	dmfailed
+	// The peer could not be reached and the message is queued
+	dmqueued
+	// The message was written to the stream
+	dmsent
+	// The peer acknowledged the message
+	dmdelivered
+	// The peer showed the message
+	dmread
+)
+
+// Represents the number of messages kept in a direct message pane
+const dmpanelimit = 500
+
+// Represents the frames of the spinner of messages that are being sent
+var spinnerframes = []string{"⠋", "⠙", "⠹", "⠸", "⠼", "⠴", "⠦", "⠧", "⠇", "⠏"}
+
+// A structure that represents a message in a direct message pane
+type dmentry struct {
+	// Represents the message
+	msg ChatMessage
+	// Represents the ID of the message
+	id string
+	// Represents the delivery status of a message sent by the user
+	status dmstatus
+	// Represents the reason a message could not be sent
+	failure string
+	// Represents a notice of the host that is shown instead of a message, such as a game
+	notice string
+}
+
+// A method of UI that adds a message to a direct message pane
+func (ui *UI) adddm(pane *dmpane, entry dmentry) {
+	ui.stateLock.Lock()
+	pane.entries = append(pane.entries, entry)
+	if len(pane.entries) > dmpanelimit {
+		pane.entries = pane.entries[len(pane.entries)-dmpanelimit:]
+	}
+	ui.stateLock.Unlock()
+
+	ui.renderpane(pane)
+}
+
+// A method of UI that sets the delivery status of a message sent by the user
+func (ui *UI) setstatus(pane *dmpane, id string, status dmstatus, failure string) {
+	ui.stateLock.Lock()
+	for index := range pane.entries {
+		if pane.entries[index].id == id {
+			pane.entries[index].status = status
+			pane.entries[index].failure = failure
+		}
+	}
+	ui.stateLock.Unlock()
+
+	ui.renderpane(pane)
+}
+
+// A method of UI that marks a queued message as sent. Returns whether
+// any messages remain queued in the pane.
+func (ui *UI) flushstatus(pane *dmpane, id string) bool {
+	queued := false
+
+	ui.stateLock.Lock()
+	for index := range pane.entries {
+		entry := &pane.entries[index]
+		if entry.id == id && entry.status == dmqueued {
+			entry.status = dmsent
+		}
+		if entry.status == dmqueued {
+			queued = true
+		}
+	}
+	ui.stateLock.Unlock()
+
+	ui.renderpane(pane)
+	return queued
+}
+
+// A method of UI that applies a receipt of a peer: a message was delivered,
+// or all the messages up to it were read. Statuses never go backwards.
+func (ui *UI) updatestatus(pane *dmpane, id string, read bool) {
+	ui.stateLock.Lock()
+	for index := range pane.entries {
+		entry := &pane.entries[index]
+		if read && entry.status >= dmsent && entry.status < dmread {
+			entry.status = dmread
+		}
+		if !read && entry.id == id && entry.status < dmdelivered {
+			entry.status = dmdelivered
+		}
+
+		if entry.id == id {
+			break
+		}
+	}
+	ui.stateLock.Unlock()
+
+	ui.renderpane(pane)
+}
+
+// A method of UI that tells the peer of a shown pane that its
+// messages have been read, if they have not been told already
+func (ui *UI) markread(pane *dmpane) {
+	ui.stateLock.Lock()
+	id := pane.lastreceived
+	unsent := id != "" && id != pane.lastread
+	pane.lastread = id
+	ui.stateLock.Unlock()
+
+	if unsent {
+		ui.Host.SendReceipt(pane.peer, "read", id)
+	}
+}
+
+// A method of UI that advances the spinners of the messages that are being
+// sent in the direct message panes. Called from the UI event handler.
+func (ui *UI) syncspinners() {
+	var sending []*dmpane
+
+	ui.stateLock.Lock()
+	for _, pane := range ui.dms {
+		for _, entry := range pane.entries {
+			if entry.status == dmsending {
+				sending = append(sending, pane)
+				break
+			}
+		}
+	}
+	ui.stateLock.Unlock()
+
+	for _, pane := range sending {
+		ui.renderpane(pane)
+	}
+}
+
+// A method of UI that renders the messages of a direct message pane, with
+// the delivery status of the messages sent by the user after them
+func (ui *UI) renderpane(pane *dmpane) {
+	pane.render.Lock()
+	defer pane.render.Unlock()
+
+	ui.stateLock.Lock()
+	entries := append([]dmentry{}, pane.entries...)
+	ui.stateLock.Unlock()
+
+	self := ui.selfid.Pretty()
+	spinner := spinnerframes[time.Now().Second()%len(spinnerframes)]
+
+	var builder strings.Builder
+	for _, entry := range entries {
+		if entry.notice != "" {
+			builder.WriteString(rendernotice(entry.notice) + "\n")
+			continue
+		}
+
+		prompt := ui.chatprompt(entry.msg)
+		if entry.msg.SenderID == self {
+			prompt = fmt.Sprintf("[blue]<%s>:[-]", sanitize(entry.msg.SenderName))
+		}
+
+		fmt.Fprintf(&builder, "%s %s", prompt, rendermessage(entry.msg.Message))
+
+		switch entry.status {
+		case dmsending:
+			fmt.Fprintf(&builder, " [gray]%s[-]", spinner)
+		case dmfailed:
+			fmt.Fprintf(&builder, " [red]✗ not delivered - %s[-]", tview.Escape(entry.failure))
+		case dmqueued:
+			builder.WriteString(" [yellow]⧗ queued[-]")
+		case dmsent:
+			builder.WriteString(" [gray]✓[-]")
+		case dmdelivered:
+			builder.WriteString(" [gray]✓✓[-]")
+		case dmread:
+			builder.WriteString(" [blue]✓✓[-]")
+		}
+
+		builder.WriteString("\n")
+	}
+
+	pane.view.SetText(builder.String())
+	pane.view.ScrollToEnd()
+}
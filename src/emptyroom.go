@@ -0,0 +1,32 @@
+package src
+
+// Represents the warning shown above the messages while the room has no peers
+const emptyroombanner = "[black:yellow] No peers in this room, messages are held until someone joins [-:-]"
+
+// A function that returns the marker of a message sent by the user in a room,
+// which marks the message as pending if no peer would have received it
+func pendingmarker(cr *ChatRoom) string {
+	if cr.Pending() > 0 || len(cr.PeerList()) == 0 {
+		return " [yellow]⧗ pending[-]"
+	}
+
+	return ""
+}
+
+// A method of UI that shows or hides the warning banner
+// that the room has no peers, if its state has changed
+func (ui *UI) syncbanner(empty bool) {
+	ui.TerminalApp.QueueUpdate(func() {
+		if ui.showBanner == empty {
+			return
+		}
+
+		ui.showBanner = empty
+		if empty {
+			ui.rootFlex.ResizeItem(ui.bannerBox, 1, 1)
+		} else {
+			ui.rootFlex.ResizeItem(ui.bannerBox, 0, 0)
+		}
+		go ui.TerminalApp.Draw()
+	})
+}
@@ -0,0 +1,243 @@
+package src
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// An interface that represents a consumer of the events of a chat room, such
+// as an interface, the gateway, a bot or a bridge. The events of a room are
+// delivered one at a time, in the order they happened, from the same goroutine.
+type ChatEvents interface {
+	// Called with each message received in the room
+	MessageReceived(cr *ChatRoom, msg ChatMessage)
+	// Called when a peer joins the topic of the room
+	PeerJoined(cr *ChatRoom, peerid peer.ID)
+	// Called when a peer leaves the topic of the room
+	PeerLeft(cr *ChatRoom, peerid peer.ID)
+	// Called when the room is left for another room, whose events follow
+	RoomChanged(from *ChatRoom, to *ChatRoom)
+	// Called with the errors and logs of the room, which are ChatErrors
+	Error(cr *ChatRoom, err error)
+}
+
+// A structure that represents a consumer of the events of a chat room that
+// ignores all of them, for embedding in consumers that only need a few
+type NopEvents struct{}
+
+// A method of NopEvents that ignores a received message
+func (NopEvents) MessageReceived(cr *ChatRoom, msg ChatMessage) {}
+
+// A method of NopEvents that ignores a peer joining
+func (NopEvents) PeerJoined(cr *ChatRoom, peerid peer.ID) {}
+
+// A method of NopEvents that ignores a peer leaving
+func (NopEvents) PeerLeft(cr *ChatRoom, peerid peer.ID) {}
+
+// A method of NopEvents that ignores a room change
+func (NopEvents) RoomChanged(from *ChatRoom, to *ChatRoom) {}
+
+// A method of NopEvents that ignores an error
+func (NopEvents) Error(cr *ChatRoom, err error) {}
+
+// A structure that represents an error or log of a chat room
+type ChatError struct {
+	// Represents the severity of the log
+	Level logrus.Level
+	// Represents the short prefix of the log, such as 'suberr'
+	Prefix string
+	// Represents the log message
+	Message string
+}
+
+// A method of ChatError that returns the log as a string
+func (err *ChatError) Error() string {
+	return fmt.Sprintf("%s: %s", err.Prefix, err.Message)
+}
+
+// A function that returns the chat log of an error of a chat room
+func errorlog(err error) chatlog {
+	if chaterr, ok := err.(*ChatError); ok {
+		return chatlog{loglevel: chaterr.Level, logprefix: chaterr.Prefix, logmsg: chaterr.Message}
+	}
+
+	return chatlog{loglevel: logrus.ErrorLevel, logprefix: "error", logmsg: err.Error()}
+}
+
+// A function that logs an error or log of a chat room, for
+// consumers of its events that have nowhere else to show it
+func logroomerror(cr *ChatRoom, err error) {
+	log := errorlog(err)
+	logrus.WithFields(logrus.Fields{
+		"room":   cr.RoomName,
+		"prefix": log.logprefix,
+	}).Log(log.loglevel, log.logmsg)
+}
+
+// A type that represents an event of a chat room, which is applied to a consumer of the events
+type ChatEvent func(handler ChatEvents)
+
+// A method of ChatRoom that delivers the events of the room to a consumer until
+// the room closes or the context is cancelled. The events of the rooms that the
+// room is left for with Jump follow, after a RoomChanged event. Returns the error
+// of the context if it was cancelled, and nil if the room closed.
+func (cr *ChatRoom) Emit(ctx context.Context, handler ChatEvents) error {
+	room := cr
+	for room != nil {
+		next, err := room.emitevents(ctx, handler)
+		if err != nil {
+			return err
+		}
+
+		if next != nil {
+			handler.RoomChanged(room, next)
+		}
+		room = next
+	}
+
+	return nil
+}
+
+// A method of ChatRoom that delivers the events of the room to a consumer until
+// the room closes or is left for another room, which is returned (nil if closed)
+func (cr *ChatRoom) emitevents(ctx context.Context, handler ChatEvents) (*ChatRoom, error) {
+	peerctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	peers := cr.peerevents(peerctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+
+		case next := <-cr.moved:
+			return next, nil
+
+		case msg, ok := <-cr.Inbound:
+			if !ok {
+				// The room is closed after it is left for another room
+				select {
+				case next := <-cr.moved:
+					return next, nil
+				default:
+					return nil, nil
+				}
+			}
+
+			handler.MessageReceived(cr, msg)
+
+		case log := <-cr.Logs:
+			handler.Error(cr, &ChatError{Level: log.loglevel, Prefix: log.logprefix, Message: log.logmsg})
+
+		case event := <-peers:
+			if event.Type == pubsub.PeerJoin {
+				handler.PeerJoined(cr, event.Peer)
+			} else {
+				handler.PeerLeft(cr, event.Peer)
+			}
+		}
+	}
+}
+
+// A method of ChatRoom that returns the peers joining and leaving the topic of the
+// room until the context is cancelled. Rooms backed by a daemon have no peer events.
+func (cr *ChatRoom) peerevents(ctx context.Context) <-chan pubsub.PeerEvent {
+	peers := make(chan pubsub.PeerEvent)
+	if cr.pstopic == nil {
+		return peers
+	}
+
+	events, err := cr.pstopic.EventHandler()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Debugln("Failed to Watch the Peers of a Room!")
+		return peers
+	}
+
+	go func() {
+		defer events.Cancel()
+
+		for {
+			event, err := events.NextPeerEvent(ctx)
+			if err != nil {
+				return
+			}
+
+			select {
+			case peers <- event:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return peers
+}
+
+// A method of ChatRoom that returns the events of the room as a channel, for
+// consumers that wait on other channels as well. Each event is applied to the
+// consumer with event(handler). The channel is closed when the events end.
+func (cr *ChatRoom) Events(ctx context.Context) <-chan ChatEvent {
+	events := make(chan ChatEvent)
+
+	go func() {
+		defer close(events)
+		cr.Emit(ctx, eventqueue{events: events, ctx: ctx})
+	}()
+
+	return events
+}
+
+// A structure that represents a consumer that queues the events of a chat room on a channel
+type eventqueue struct {
+	events chan ChatEvent
+	ctx    context.Context
+}
+
+// A method of eventqueue that queues an event unless the context is cancelled
+func (queue eventqueue) queue(event ChatEvent) {
+	select {
+	case queue.events <- event:
+	case <-queue.ctx.Done():
+	}
+}
+
+// A method of eventqueue that queues a received message
+func (queue eventqueue) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	queue.queue(func(handler ChatEvents) { handler.MessageReceived(cr, msg) })
+}
+
+// A method of eventqueue that queues a peer joining
+func (queue eventqueue) PeerJoined(cr *ChatRoom, peerid peer.ID) {
+	queue.queue(func(handler ChatEvents) { handler.PeerJoined(cr, peerid) })
+}
+
+// A method of eventqueue that queues a peer leaving
+func (queue eventqueue) PeerLeft(cr *ChatRoom, peerid peer.ID) {
+	queue.queue(func(handler ChatEvents) { handler.PeerLeft(cr, peerid) })
+}
+
+// A method of eventqueue that queues a room change
+func (queue eventqueue) RoomChanged(from *ChatRoom, to *ChatRoom) {
+	queue.queue(func(handler ChatEvents) { handler.RoomChanged(from, to) })
+}
+
+// A method of eventqueue that queues an error
+func (queue eventqueue) Error(cr *ChatRoom, err error) {
+	queue.queue(func(handler ChatEvents) { handler.Error(cr, err) })
+}
+
+// A method of ChatRoom that tells the consumer of the events of the
+// room that the room is left for another room, before it is exited
+func (cr *ChatRoom) moveto(next *ChatRoom) {
+	select {
+	case cr.moved <- next:
+	default:
+	}
+}
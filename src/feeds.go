@@ -0,0 +1,401 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the default name of a feed bridge in a chat room
+const defaultfeedname = "feeds"
+
+// Represents the default and the shortest interval between the polls of the feeds
+const (
+	defaultfeedinterval = 15 * time.Minute
+	minfeedinterval     = time.Minute
+)
+
+// Represents the default number of new entries of a feed announced per poll
+const defaultfeedmax = 5
+
+// Represents the timeout for fetching a feed
+const feedtimeout = 30 * time.Second
+
+// Represents the largest feed document that is read
+const maxfeedbody = 4 * 1024 * 1024
+
+// Represents the number of entry IDs remembered per feed
+const feedseenlimit = 500
+
+// Represents the pattern of the html tags in the summaries of entries
+var feedtagpattern = regexp.MustCompile(`<[^>]*>`)
+
+// A structure that represents a bridge that watches RSS and Atom feeds and
+// announces their new entries in a chat room. The entries that are in a feed
+// when it is first polled are not announced, and the entries seen are
+// remembered in the feeds directory (~/.peerchat/feeds) across restarts.
+type feedbridge struct {
+	// Represents the chat room the entries are announced in
+	Room string `json:"room"`
+	// Represents the URLs of the feeds
+	Feeds []string `json:"feeds"`
+	// Represents the interval between the polls of the feeds (15m if empty)
+	Interval string `json:"interval"`
+	// Represents the number of new entries of a feed announced per poll (5 if zero)
+	Max int `json:"max"`
+	// Represents whether the summaries of the entries are announced
+	Summary bool `json:"summary"`
+	// Represents the name of the bridge in the chat room (optional)
+	Name string `json:"name"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the parsed interval
+	interval time.Duration
+	// Represents the HTTP client that fetches the feeds
+	client *http.Client
+	// Represents the IDs of the entries seen in each feed, oldest first
+	seen map[string][]string
+}
+
+// A structure that represents an RSS 2.0, RSS 1.0 (RDF) or Atom document.
+// Elements are matched by their local names, whatever their namespaces.
+type feeddocument struct {
+	// Represents the title of an Atom feed
+	Title string `xml:"title"`
+	// Represents the channel of an RSS feed
+	Channel struct {
+		Title string     `xml:"title"`
+		Items []feeditem `xml:"item"`
+	} `xml:"channel"`
+	// Represents the items of an RSS 1.0 feed, which are outside the channel
+	Items []feeditem `xml:"item"`
+	// Represents the entries of an Atom feed
+	Entries []feeditem `xml:"entry"`
+}
+
+// A structure that represents an item of an RSS feed or an entry of an Atom feed
+type feeditem struct {
+	Title string `xml:"title"`
+	// Represents the links, as text in RSS and as attributes in Atom
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Rel  string `xml:"rel,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"link"`
+	// Represents the ID of the entry (guid in RSS, id in Atom)
+	GUID string `xml:"guid"`
+	ID   string `xml:"id"`
+	// Represents the summary of the entry (description in RSS)
+	Description string `xml:"description"`
+	Summary     string `xml:"summary"`
+}
+
+// A method of feeditem that returns the link of the entry, preferring the
+// alternate link of an Atom entry over its other links
+func (item feeditem) link() string {
+	var fallback string
+	for _, link := range item.Links {
+		href := strings.TrimSpace(link.Href)
+		if href == "" {
+			href = strings.TrimSpace(link.Text)
+		}
+
+		if href != "" && (link.Rel == "" || link.Rel == "alternate") {
+			return href
+		}
+		if fallback == "" {
+			fallback = href
+		}
+	}
+
+	return fallback
+}
+
+// A method of feeditem that returns the ID of the entry,
+// falling back to its link and its title if it has none
+func (item feeditem) key() string {
+	for _, key := range []string{item.GUID, item.ID, item.link(), item.Title} {
+		if key = strings.TrimSpace(key); key != "" {
+			return key
+		}
+	}
+
+	return ""
+}
+
+// A method of feedbridge that joins the chat room and starts polling the feeds
+func (bridge *feedbridge) start(p2phost *P2P) error {
+	if len(bridge.Feeds) == 0 {
+		return errors.New("feed bridge requires at least one feed")
+	}
+
+	if bridge.Name == "" {
+		bridge.Name = defaultfeedname
+	}
+	if bridge.Max <= 0 {
+		bridge.Max = defaultfeedmax
+	}
+
+	bridge.interval = defaultfeedinterval
+	if bridge.Interval != "" {
+		interval, err := time.ParseDuration(bridge.Interval)
+		if err != nil {
+			return fmt.Errorf("invalid feed interval - %w", err)
+		}
+		if interval < minfeedinterval {
+			return fmt.Errorf("feed interval must be at least %s", minfeedinterval)
+		}
+		bridge.interval = interval
+	}
+
+	bridge.client = &http.Client{Transport: proxiedtransport(feedtimeout), Timeout: feedtimeout}
+
+	// Join the chat room with the name of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Name, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+	bridge.seen = loadfeedseen(room.chatroom.RoomName, bridge.Name)
+
+	// The messages of the room are not bridged, but the room is still relayed to consume its events
+	go room.relay(func(msg ChatMessage) {})
+	go bridge.pollloop()
+
+	logrus.WithFields(logrus.Fields{
+		"feeds":    len(bridge.Feeds),
+		"interval": bridge.interval.String(),
+		"room":     room.chatroom.RoomName,
+	}).Infoln("Feed Bridge Started")
+
+	return nil
+}
+
+// A method of feedbridge that stops polling the feeds and exits the chat room
+func (bridge *feedbridge) stop() {
+	bridge.room.leave()
+}
+
+// A method of feedbridge that polls the feeds at the interval of the bridge until it is stopped
+func (bridge *feedbridge) pollloop() {
+	ticker := time.NewTicker(bridge.interval)
+	defer ticker.Stop()
+
+	for {
+		bridge.poll()
+
+		select {
+		case <-ticker.C:
+		case <-bridge.room.ctx.Done():
+			return
+		}
+	}
+}
+
+// A method of feedbridge that fetches every feed and announces its new entries,
+// and then saves the entries that have been seen
+func (bridge *feedbridge) poll() {
+	for _, url := range bridge.Feeds {
+		document, err := bridge.fetch(url)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"feed":  url,
+			}).Warnln("Failed to Fetch Feed!")
+			continue
+		}
+
+		bridge.announce(url, document)
+	}
+
+	if err := savefeedseen(bridge.room.chatroom.RoomName, bridge.Name, bridge.seen); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  bridge.room.chatroom.RoomName,
+		}).Debugln("Failed to Save the Seen Feed Entries!")
+	}
+}
+
+// A method of feedbridge that fetches and parses a feed
+func (bridge *feedbridge) fetch(url string) (*feeddocument, error) {
+	ctx, cancel := context.WithTimeout(bridge.room.ctx, feedtimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", AgentVersion())
+	request.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml, text/xml")
+
+	response, err := bridge.client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("feed returned %s", response.Status)
+	}
+
+	var document feeddocument
+	decoder := xml.NewDecoder(io.LimitReader(response.Body, maxfeedbody))
+	// Besides UTF-8, feeds are often declared as ASCII or Latin-1
+	decoder.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		switch strings.ToLower(charset) {
+		case "us-ascii":
+			return input, nil
+		case "iso-8859-1", "latin1", "windows-1252":
+			data, err := ioutil.ReadAll(input)
+			if err != nil {
+				return nil, err
+			}
+
+			// Every byte of Latin-1 is the code point of its character
+			runes := make([]rune, len(data))
+			for index, char := range data {
+				runes[index] = rune(char)
+			}
+			return strings.NewReader(string(runes)), nil
+		}
+		return nil, fmt.Errorf("unsupported charset - %s", charset)
+	}
+	// Feeds often use the html entities that XML does not define
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	if err := decoder.Decode(&document); err != nil {
+		return nil, err
+	}
+
+	return &document, nil
+}
+
+// A method of feedbridge that announces the new entries of a feed in the chat
+// room, oldest first. The entries of a feed that has not been seen before are
+// only remembered, so that joining a feed does not flood the room.
+func (bridge *feedbridge) announce(url string, document *feeddocument) {
+	title := document.Channel.Title
+	if title == "" {
+		title = document.Title
+	}
+	title = previewtext(title)
+	items := append(append(document.Channel.Items, document.Items...), document.Entries...)
+
+	seen, known := bridge.seen[url]
+	seenkeys := make(map[string]bool, len(seen))
+	for _, key := range seen {
+		seenkeys[key] = true
+	}
+
+	// Feeds list their newest entries first
+	var fresh []feeditem
+	for _, item := range items {
+		key := item.key()
+		if key == "" || seenkeys[key] {
+			continue
+		}
+
+		seenkeys[key] = true
+		seen = append(seen, key)
+		if known {
+			fresh = append(fresh, item)
+		}
+	}
+
+	if len(seen) > feedseenlimit {
+		seen = seen[len(seen)-feedseenlimit:]
+	}
+	bridge.seen[url] = seen
+
+	if len(fresh) > bridge.Max {
+		fresh = fresh[:bridge.Max]
+	}
+	for index := len(fresh) - 1; index >= 0; index-- {
+		bridge.room.publish(bridge.Name, bridge.format(title, fresh[index]))
+	}
+}
+
+// A method of feedbridge that formats the announcement of an entry of a feed:
+// the title of the feed and the entry, the summary if enabled and the link
+func (bridge *feedbridge) format(feedtitle string, item feeditem) string {
+	title := previewtext(feedtagpattern.ReplaceAllString(item.Title, ""))
+	if title == "" {
+		title = "(untitled)"
+	}
+
+	lines := []string{title}
+	if feedtitle != "" {
+		lines[0] = fmt.Sprintf("%s | %s", feedtitle, title)
+	}
+
+	if bridge.Summary {
+		summary := item.Summary
+		if summary == "" {
+			summary = item.Description
+		}
+		if summary = previewtext(feedtagpattern.ReplaceAllString(summary, " ")); summary != "" {
+			lines = append(lines, summary)
+		}
+	}
+
+	if link := item.link(); link != "" {
+		lines = append(lines, link)
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+// A function that returns the path of the file of the entries seen in
+// the feeds of a room by the feed bridge with the given name
+func feedseenpath(roomname string, name string) string {
+	return peerchatpath("feeds", transcriptname(roomname+"-"+name)+".json")
+}
+
+// A function that loads the entries seen in the feeds of a room by a feed bridge
+func loadfeedseen(roomname string, name string) map[string][]string {
+	seen := make(map[string][]string)
+
+	data, err := ioutil.ReadFile(feedseenpath(roomname, name))
+	if err != nil {
+		return seen
+	}
+
+	if err := json.Unmarshal(data, &seen); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Warnln("Failed to Parse the Seen Feed Entries!")
+		return make(map[string][]string)
+	}
+
+	return seen
+}
+
+// A function that saves the entries seen in the feeds of a room by a feed bridge
+func savefeedseen(roomname string, name string, seen map[string][]string) error {
+	path := feedseenpath(roomname, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(seen)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(path, data, 0600)
+}
@@ -0,0 +1,184 @@
+package src
+
+import (
+	"fmt"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents a content filter of the configuration, which
+// matches inbound messages with a regular expression or a list of words
+type FilterConfig struct {
+	// Represents the name of the filter, used as the default tag and in logs
+	Name string `yaml:"name"`
+	// Represents the regular expression to match (optional)
+	Match string `yaml:"match"`
+	// Represents the words to match as whole words, ignoring case (optional)
+	Words []string `yaml:"words"`
+	// Represents a file with more words to match, one per line (optional)
+	WordFile string `yaml:"wordfile"`
+	// Represents the action for matching messages: drop, redact, strip or tag
+	Action string `yaml:"action"`
+	// Represents the tag to attach with the tag action (the name if empty)
+	Tag string `yaml:"tag"`
+	// Represents the rooms the filter applies to (all rooms if empty)
+	Rooms []string `yaml:"rooms"`
+}
+
+// A structure that represents a compiled content filter
+type contentfilter struct {
+	// Represents the configuration of the filter
+	config FilterConfig
+	// Represents the patterns of the filter
+	patterns []*regexp.Regexp
+}
+
+// Apply the content filters of the configuration to the inbound messages
+func init() {
+	AddInboundFilter(applyfilters)
+}
+
+// A function that compiles the content filters of the configuration
+func compilefilters(configs []FilterConfig) ([]*contentfilter, error) {
+	filters := make([]*contentfilter, 0, len(configs))
+
+	for index, cfg := range configs {
+		if cfg.Name == "" {
+			cfg.Name = fmt.Sprintf("filter%d", index+1)
+		}
+
+		switch cfg.Action {
+		case "drop", "redact", "strip", "tag":
+		case "":
+			return nil, fmt.Errorf("filter '%s' has no action", cfg.Name)
+		default:
+			return nil, fmt.Errorf("filter '%s' has an invalid action '%s' (drop, redact, strip or tag)", cfg.Name, cfg.Action)
+		}
+
+		filter := &contentfilter{config: cfg}
+
+		if cfg.Match != "" {
+			pattern, err := regexp.Compile(cfg.Match)
+			if err != nil {
+				return nil, fmt.Errorf("filter '%s' has an invalid pattern - %s", cfg.Name, err)
+			}
+
+			filter.patterns = append(filter.patterns, pattern)
+		}
+
+		// Match the words as whole words, ignoring case
+		words := append([]string{}, cfg.Words...)
+		if cfg.WordFile != "" {
+			data, err := ioutil.ReadFile(expandhome(cfg.WordFile))
+			if err != nil {
+				return nil, fmt.Errorf("filter '%s' could not read its word file - %s", cfg.Name, err)
+			}
+
+			for _, line := range strings.Split(string(data), "\n") {
+				if word := strings.TrimSpace(line); word != "" && !strings.HasPrefix(word, "#") {
+					words = append(words, word)
+				}
+			}
+		}
+
+		if len(words) > 0 {
+			quoted := make([]string, len(words))
+			for i, word := range words {
+				quoted[i] = regexp.QuoteMeta(word)
+			}
+
+			filter.patterns = append(filter.patterns, regexp.MustCompile(`(?i)\b(?:`+strings.Join(quoted, "|")+`)\b`))
+		}
+
+		if len(filter.patterns) == 0 {
+			return nil, fmt.Errorf("filter '%s' has no pattern or words", cfg.Name)
+		}
+
+		filters = append(filters, filter)
+	}
+
+	return filters, nil
+}
+
+// A method of contentfilter that checks if the filter applies to a room
+func (filter *contentfilter) appliesto(room string) bool {
+	if len(filter.config.Rooms) == 0 {
+		return true
+	}
+
+	for _, name := range filter.config.Rooms {
+		if name == room {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of contentfilter that checks if a message matches the filter
+func (filter *contentfilter) matches(message string) bool {
+	for _, pattern := range filter.patterns {
+		if pattern.MatchString(message) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A function that applies the content filters of the configuration in order
+// to an inbound chat message, which can drop the message, redact or strip the
+// matches from it, or tag it. Implements the InboundFilter type.
+func applyfilters(room string, msg ChatMessage) (ChatMessage, bool) {
+	for _, filter := range currentconfig().filters {
+		if !filter.appliesto(room) || !filter.matches(msg.Message) {
+			continue
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"room":   room,
+			"peer":   msg.SenderID,
+			"filter": filter.config.Name,
+			"action": filter.config.Action,
+		}).Debugln("Filtered a Chat Message.")
+
+		switch filter.config.Action {
+		case "drop":
+			return ChatMessage{}, false
+
+		case "redact":
+			for _, pattern := range filter.patterns {
+				msg.Message = pattern.ReplaceAllStringFunc(msg.Message, func(match string) string {
+					return strings.Repeat("*", len([]rune(match)))
+				})
+			}
+
+		case "strip":
+			for _, pattern := range filter.patterns {
+				msg.Message = pattern.ReplaceAllString(msg.Message, "")
+			}
+
+		case "tag":
+			tag := filter.config.Tag
+			if tag == "" {
+				tag = filter.config.Name
+			}
+
+			msg.Tags = append(msg.Tags, tag)
+		}
+	}
+
+	return msg, true
+}
+
+// A function that returns the tags of a message as a prefix for its text
+func tagprefix(tags []string) string {
+	if len(tags) == 0 {
+		return ""
+	}
+
+	return "(" + strings.Join(tags, ", ") + ") "
+}
@@ -0,0 +1,118 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Represents the time allowed for each step of a peer lookup
+const lookuptimeout = 30 * time.Second
+
+// A structure that represents the result of looking up a peer in the DHT
+// and dialing it, to tell why a peer cannot be reached
+type peerlookup struct {
+	// Represents whether the host was connected to the peer before the lookup.
+	// The DHT answers with the known addresses of connected peers.
+	connected bool
+	// Represents the addresses of the peer that were found
+	addrs []multiaddr.Multiaddr
+	// Represents the time the DHT lookup took
+	took time.Duration
+	// Represents the reason the DHT lookup failed
+	finderr error
+	// Represents the reason the dial failed, nil if it succeeded
+	dialerr error
+	// Represents the time the dial took
+	dialtook time.Duration
+	// Represents the address over which the host is connected after the dial
+	via multiaddr.Multiaddr
+}
+
+// A method of P2P that looks up the addresses of a peer in the DHT and
+// dials them, for debugging peers that cannot be reached
+func (p2p *P2P) LookupPeer(peerid peer.ID) peerlookup {
+	lookup := peerlookup{connected: p2p.Host.Network().Connectedness(peerid) == network.Connected}
+
+	ctx, cancel := context.WithTimeout(p2p.Ctx, lookuptimeout)
+	defer cancel()
+
+	started := time.Now()
+	addrinfo, err := p2p.findpeer(ctx, peerid)
+	lookup.took = time.Since(started)
+
+	if err != nil {
+		lookup.finderr = err
+		// Still try the addresses that are known from before
+		addrinfo = peer.AddrInfo{ID: peerid, Addrs: p2p.Host.Peerstore().Addrs(peerid)}
+	}
+	lookup.addrs = addrinfo.Addrs
+
+	dialctx, dialcancel := context.WithTimeout(p2p.Ctx, lookuptimeout)
+	defer dialcancel()
+
+	started = time.Now()
+	lookup.dialerr = p2p.Host.Connect(dialctx, addrinfo)
+	lookup.dialtook = time.Since(started)
+
+	if conns := p2p.Host.Network().ConnsToPeer(peerid); len(conns) > 0 {
+		lookup.via = conns[0].RemoteMultiaddr()
+	}
+
+	return lookup
+}
+
+// A function that describes the kind of an address of a peer
+func addrkind(addr multiaddr.Multiaddr) string {
+	switch {
+	case strings.Contains(addr.String(), "/p2p-circuit"):
+		return "relay"
+	case manet.IsIPLoopback(addr):
+		return "loopback"
+	case manet.IsPrivateAddr(addr):
+		return "private"
+	case manet.IsPublicAddr(addr):
+		return "public"
+	default:
+		return "other"
+	}
+}
+
+// A method of peerlookup that returns the lines describing the lookup
+func (lookup peerlookup) describe() []string {
+	var lines []string
+
+	switch {
+	case lookup.finderr != nil:
+		lines = append(lines, fmt.Sprintf("DHT lookup failed after %s: %s", lookup.took.Round(time.Millisecond), lookup.finderr))
+	case lookup.connected:
+		lines = append(lines, "already connected, the DHT answered with the known addresses")
+	default:
+		lines = append(lines, fmt.Sprintf("DHT lookup found %d addresses in %s", len(lookup.addrs), lookup.took.Round(time.Millisecond)))
+	}
+
+	if len(lookup.addrs) == 0 {
+		lines = append(lines, "no addresses are known for the peer")
+	}
+	for _, addr := range lookup.addrs {
+		lines = append(lines, fmt.Sprintf("  %s (%s)", addr, addrkind(addr)))
+	}
+
+	if lookup.dialerr != nil {
+		lines = append(lines, fmt.Sprintf("dial failed after %s: %s", lookup.dialtook.Round(time.Millisecond), lookup.dialerr))
+		return lines
+	}
+
+	dialed := fmt.Sprintf("dial succeeded in %s", lookup.dialtook.Round(time.Millisecond))
+	if lookup.via != nil {
+		dialed += fmt.Sprintf(" via %s (%s)", lookup.via, addrkind(lookup.via))
+	}
+
+	return append(lines, dialed)
+}
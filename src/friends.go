@@ -0,0 +1,155 @@
+package src
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents a trusted peer in the contact list
+type friend struct {
+	// Represents the peer ID of the friend
+	PeerID string `json:"peerid"`
+	// Represents the local alias of the friend
+	Alias string `json:"alias"`
+	// Represents the time the friend was added
+	Added time.Time `json:"added"`
+}
+
+// A structure that represents the contact list of trusted peers
+// with their local aliases, which is loaded on first use
+type friendlist struct {
+	// Represents the friends indexed by their peer ID
+	friends map[peer.ID]friend
+	// Represents whether the contact list has been loaded
+	loaded bool
+	// Represents the lock on the contact list
+	mutex sync.Mutex
+}
+
+// Represents the contact list of the application
+var friends = &friendlist{}
+
+// A function that returns the path of the contact list
+func friendspath() string {
+	return peerchatpath("friends.json")
+}
+
+// A method of friendlist that loads the contact list if it has not
+// been loaded yet. Must be called with the contact list lock held.
+func (list *friendlist) load() {
+	if list.loaded {
+		return
+	}
+
+	list.loaded = true
+	list.friends = make(map[peer.ID]friend)
+
+	data, err := ioutil.ReadFile(friendspath())
+	if err != nil {
+		return
+	}
+
+	var saved []friend
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  friendspath(),
+		}).Warnln("Failed to Parse the Contact List!")
+		return
+	}
+
+	for _, f := range saved {
+		if peerid, err := peer.Decode(f.PeerID); err == nil {
+			list.friends[peerid] = f
+		}
+	}
+}
+
+// A method of friendlist that saves the contact list.
+// Must be called with the contact list lock held.
+func (list *friendlist) save() error {
+	saved := make([]friend, 0, len(list.friends))
+	for _, f := range list.friends {
+		saved = append(saved, f)
+	}
+	sort.Slice(saved, func(i, j int) bool { return saved[i].Alias < saved[j].Alias })
+
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(friendspath()), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(friendspath(), data, 0600)
+}
+
+// A method of friendlist that adds a peer as a friend with an alias,
+// or updates the alias of a peer that is already a friend
+func (list *friendlist) add(peerid peer.ID, alias string) error {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.load()
+
+	f, ok := list.friends[peerid]
+	if !ok {
+		f = friend{PeerID: peerid.Pretty(), Added: time.Now()}
+	}
+	f.Alias = alias
+	list.friends[peerid] = f
+
+	return list.save()
+}
+
+// A method of friendlist that removes a friend by its alias or
+// peer ID suffix. Returns false if no friend matches the query.
+func (list *friendlist) remove(query string) (friend, bool, error) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.load()
+
+	for peerid, f := range list.friends {
+		if f.Alias == query || strings.HasSuffix(f.PeerID, query) {
+			delete(list.friends, peerid)
+			return f, true, list.save()
+		}
+	}
+
+	return friend{}, false, nil
+}
+
+// A method of friendlist that returns the alias of a peer if it is a friend
+func (list *friendlist) alias(peerid peer.ID) (string, bool) {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.load()
+
+	f, ok := list.friends[peerid]
+	return f.Alias, ok
+}
+
+// A method of friendlist that returns the friends ordered by their alias
+func (list *friendlist) list() []friend {
+	list.mutex.Lock()
+	defer list.mutex.Unlock()
+	list.load()
+
+	all := make([]friend, 0, len(list.friends))
+	for _, f := range list.friends {
+		all = append(all, f)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Alias < all[j].Alias })
+
+	return all
+}
@@ -0,0 +1,568 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// An interface that represents a turn-based game for two players. Both peers
+// run the same game from the same seed and apply the same moves, so the game
+// is kept in sync by sending the moves over the direct message stream.
+// Player 0 is the peer that sent the invitation and plays first.
+type game interface {
+	// Represents the player whose turn it is
+	turn() int
+	// Represents a move of a player, which is checked before it is played
+	play(player int, move string) error
+	// Represents whether the game is over and its winner (-1 for a draw)
+	over() (bool, int)
+	// Represents the state of the game as seen by a player
+	render(player int) string
+}
+
+// Represents the games that can be played, by name
+var gamekinds = map[string]func(seed int64) game{
+	"tictactoe": newtictactoe,
+	"trivia":    newtrivia,
+}
+
+// A structure that represents a frame of a game, sent within a direct message frame
+type gameframe struct {
+	// Represents the name of the game
+	Kind string `json:"kind"`
+	// Represents the ID of the game session
+	Session string `json:"session"`
+	// Represents the action: 'invite', 'accept', 'decline', 'move' or 'quit'
+	Action string `json:"action"`
+	// Represents the move of a 'move' frame
+	Move string `json:"move,omitempty"`
+	// Represents the seed of the game of an 'invite' frame
+	Seed int64 `json:"seed,omitempty"`
+}
+
+// A structure that represents a game with a peer
+type gamesession struct {
+	// Represents the ID of the session
+	id string
+	// Represents the name of the game
+	kind string
+	// Represents the game
+	game game
+	// Represents the player of the host (0 if it sent the invitation)
+	player int
+	// Represents whether the invitation was accepted
+	started bool
+	// Represents the lock on the game, which is played from the
+	// commands of the user and the frames of the peer
+	lock sync.Mutex
+}
+
+// A structure that represents the games of the host, one for each peer at most
+type gametable struct {
+	// Represents the games by peer
+	sessions map[peer.ID]*gamesession
+	// Represents the lock on the games
+	lock sync.Mutex
+}
+
+// Represents the games of the host
+var games = &gametable{sessions: make(map[peer.ID]*gamesession)}
+
+// A function that returns the names of the games that can be played
+func gamenames() []string {
+	return []string{"tictactoe", "trivia"}
+}
+
+// A method of gametable that returns the game with a peer
+func (table *gametable) get(peerid peer.ID) (*gamesession, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	session, ok := table.sessions[peerid]
+	return session, ok
+}
+
+// A method of gametable that sets the game with a peer
+func (table *gametable) set(peerid peer.ID, session *gamesession) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	table.sessions[peerid] = session
+}
+
+// A method of gametable that ends the game with a peer
+func (table *gametable) end(peerid peer.ID) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	delete(table.sessions, peerid)
+}
+
+// A method of gametable that returns the peer of the only game with a pending invitation
+func (table *gametable) invitation() (peer.ID, bool) {
+	table.lock.Lock()
+	defer table.lock.Unlock()
+
+	var found peer.ID
+	count := 0
+	for peerid, session := range table.sessions {
+		if !session.started && session.player == 1 {
+			found = peerid
+			count++
+		}
+	}
+
+	return found, count == 1
+}
+
+// A method of P2P that sends a frame of a game to a peer over the direct message stream
+func (p2p *P2P) SendGame(peerid peer.ID, name string, frame gameframe) error {
+	return p2p.senddm(peerid, dmframe{Type: "game", Name: name, Game: &frame})
+}
+
+// A method of UI that writes a notice about the game with a peer into their pane
+func (ui *UI) gamenotice(peerid peer.ID, notice string) {
+	pane := ui.openpane(peerid, "")
+	ui.adddm(pane, dmentry{notice: notice})
+}
+
+// A method of UI that invites a peer to a game
+func (ui *UI) invitegame(peerid peer.ID, kind string) error {
+	if _, ok := gamekinds[kind]; !ok {
+		return fmt.Errorf("unknown game '%s' (%s)", kind, strings.Join(gamenames(), ", "))
+	}
+	if _, ok := games.get(peerid); ok {
+		return errors.New("a game with the peer is already going on, /play quit ends it")
+	}
+
+	session := &gamesession{id: chunkid(), kind: kind, player: 0}
+	seed := time.Now().UnixNano()
+	session.game = gamekinds[kind](seed)
+
+	if err := ui.Host.SendGame(peerid, ui.UserName, gameframe{Kind: kind, Session: session.id, Action: "invite", Seed: seed}); err != nil {
+		return err
+	}
+
+	games.set(peerid, session)
+	ui.gamenotice(peerid, fmt.Sprintf("You invited them to play %s, waiting for them to accept.", kind))
+	return nil
+}
+
+// A method of UI that answers the invitation of a peer to a game
+func (ui *UI) answergame(peerid peer.ID, accept bool) error {
+	session, ok := games.get(peerid)
+	if !ok {
+		return errors.New("there is no invitation from the peer")
+	}
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if session.started || session.player != 1 {
+		return errors.New("there is no invitation from the peer")
+	}
+
+	action := "decline"
+	if accept {
+		action = "accept"
+	}
+	if err := ui.Host.SendGame(peerid, ui.UserName, gameframe{Kind: session.kind, Session: session.id, Action: action}); err != nil {
+		return err
+	}
+
+	if !accept {
+		games.end(peerid)
+		ui.gamenotice(peerid, "You declined the game.")
+		return nil
+	}
+
+	session.started = true
+	ui.gamenotice(peerid, session.game.render(session.player))
+	return nil
+}
+
+// A method of UI that plays a move in the game with a peer
+func (ui *UI) movegame(peerid peer.ID, move string) error {
+	session, ok := games.get(peerid)
+	if !ok {
+		return errors.New("there is no game going on with the peer")
+	}
+
+	session.lock.Lock()
+	defer session.lock.Unlock()
+
+	if !session.started {
+		return errors.New("the peer has not accepted the game yet")
+	}
+	if err := session.game.play(session.player, move); err != nil {
+		return err
+	}
+	if err := ui.Host.SendGame(peerid, ui.UserName, gameframe{Kind: session.kind, Session: session.id, Action: "move", Move: move}); err != nil {
+		// The peer cannot follow the game anymore
+		games.end(peerid)
+		return fmt.Errorf("the game was abandoned - %s", err)
+	}
+
+	ui.showgame(peerid, session)
+	return nil
+}
+
+// A method of UI that quits the game with a peer
+func (ui *UI) quitgame(peerid peer.ID) error {
+	session, ok := games.get(peerid)
+	if !ok {
+		return errors.New("there is no game with the peer")
+	}
+
+	games.end(peerid)
+	ui.gamenotice(peerid, "You left the game.")
+
+	return ui.Host.SendGame(peerid, ui.UserName, gameframe{Kind: session.kind, Session: session.id, Action: "quit"})
+}
+
+// A method of UI that shows the game with a peer in their pane, with its outcome
+// if it is over, in which case the game is ended. Must be called with the lock
+// of the game held.
+func (ui *UI) showgame(peerid peer.ID, session *gamesession) {
+	state := session.game.render(session.player)
+
+	over, winner := session.game.over()
+	if !over {
+		ui.gamenotice(peerid, state)
+		return
+	}
+
+	games.end(peerid)
+	switch winner {
+	case -1:
+		state += "\nThe game is a draw."
+	case session.player:
+		state += "\nYou won!"
+	default:
+		state += "\nYou lost."
+	}
+	ui.gamenotice(peerid, state)
+}
+
+// A method of UI that handles a frame of a game received from a peer.
+// Called from the UI event handler.
+func (ui *UI) display_gameevent(event dmevent) {
+	frame := event.frame.Game
+	if frame == nil {
+		return
+	}
+	session, ok := games.get(event.peer)
+
+	switch frame.Action {
+	case "invite":
+		newgame, known := gamekinds[frame.Kind]
+		if !known {
+			go ui.Host.SendGame(event.peer, ui.UserName, gameframe{Kind: frame.Kind, Session: frame.Session, Action: "decline"})
+			return
+		}
+		if ok {
+			// Only one game is played with a peer at a time
+			go ui.Host.SendGame(event.peer, ui.UserName, gameframe{Kind: frame.Kind, Session: frame.Session, Action: "decline"})
+			return
+		}
+
+		games.set(event.peer, &gamesession{id: frame.Session, kind: frame.Kind, game: newgame(frame.Seed), player: 1})
+
+		pane := ui.openpane(event.peer, event.frame.Name)
+		ui.gamenotice(event.peer, fmt.Sprintf("%s invites you to play %s. /play accept or /play decline", pane.name, frame.Kind))
+		ui.notifier.Notify(ui.RoomName, fmt.Sprintf("%s invites you to play %s", pane.name, frame.Kind), "/play accept or /play decline")
+
+	case "accept":
+		if !ok || session.id != frame.Session || session.player != 0 {
+			return
+		}
+
+		session.lock.Lock()
+		session.started = true
+		ui.showgame(event.peer, session)
+		session.lock.Unlock()
+
+	case "decline", "quit":
+		if !ok || session.id != frame.Session {
+			return
+		}
+
+		games.end(event.peer)
+		if frame.Action == "decline" {
+			ui.gamenotice(event.peer, "They declined the game.")
+		} else {
+			ui.gamenotice(event.peer, "They left the game.")
+		}
+
+	case "move":
+		if !ok || session.id != frame.Session {
+			return
+		}
+
+		session.lock.Lock()
+		defer session.lock.Unlock()
+
+		if !session.started {
+			return
+		}
+		if err := session.game.play(1-session.player, frame.Move); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"peer":  event.peer.Pretty(),
+			}).Debugln("Dropped an Invalid Game Move.")
+			return
+		}
+
+		ui.showgame(event.peer, session)
+	}
+}
+
+// A structure that represents a game of tic-tac-toe. The cells are numbered
+// 1 to 9 from the top left, and the player who sent the invitation is X.
+type tictactoe struct {
+	// Represents the cells of the board (0 if empty, else the player + 1)
+	cells [9]int
+	// Represents the number of moves played
+	moves int
+}
+
+// Represents the lines of three cells that win a game of tic-tac-toe
+var tictactoelines = [8][3]int{
+	{0, 1, 2}, {3, 4, 5}, {6, 7, 8},
+	{0, 3, 6}, {1, 4, 7}, {2, 5, 8},
+	{0, 4, 8}, {2, 4, 6},
+}
+
+// A constructor function that generates and returns a new game of tic-tac-toe
+func newtictactoe(seed int64) game {
+	return &tictactoe{}
+}
+
+// A method of tictactoe that returns the player whose turn it is
+func (board *tictactoe) turn() int {
+	return board.moves % 2
+}
+
+// A method of tictactoe that plays a move, which is the number of a free cell
+func (board *tictactoe) play(player int, move string) error {
+	if over, _ := board.over(); over {
+		return errors.New("the game is over")
+	}
+	if player != board.turn() {
+		return errors.New("it is not your turn")
+	}
+
+	var cell int
+	if _, err := fmt.Sscanf(move, "%d", &cell); err != nil || cell < 1 || cell > 9 {
+		return errors.New("the move must be the number of a cell (1-9)")
+	}
+	if board.cells[cell-1] != 0 {
+		return fmt.Errorf("the cell %d is taken", cell)
+	}
+
+	board.cells[cell-1] = player + 1
+	board.moves++
+	return nil
+}
+
+// A method of tictactoe that returns whether the game is over and its winner
+func (board *tictactoe) over() (bool, int) {
+	for _, line := range tictactoelines {
+		if mark := board.cells[line[0]]; mark != 0 && mark == board.cells[line[1]] && mark == board.cells[line[2]] {
+			return true, mark - 1
+		}
+	}
+
+	return board.moves == 9, -1
+}
+
+// A method of tictactoe that returns the board with the free cells numbered
+func (board *tictactoe) render(player int) string {
+	marks := []string{"X", "O"}
+
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Tic-tac-toe, you are %s\n", marks[player])
+	for row := 0; row < 3; row++ {
+		var cells []string
+		for column := 0; column < 3; column++ {
+			index := row*3 + column
+			switch board.cells[index] {
+			case 0:
+				cells = append(cells, fmt.Sprintf("%d", index+1))
+			default:
+				cells = append(cells, marks[board.cells[index]-1])
+			}
+		}
+		fmt.Fprintf(&builder, " %s\n", strings.Join(cells, " | "))
+	}
+
+	if over, _ := board.over(); !over {
+		if board.turn() == player {
+			builder.WriteString("Your turn: /play <cell>")
+		} else {
+			builder.WriteString("Their turn")
+		}
+	}
+
+	return strings.TrimRight(builder.String(), "\n")
+}
+
+// A structure that represents a trivia question
+type triviaquestion struct {
+	// Represents the question
+	question string
+	// Represents the choices, the first of which is the answer
+	choices [4]string
+}
+
+// Represents the questions of the trivia game
+var triviaquestions = []triviaquestion{
+	{"Which protocol suite does PeerChat use for its networking?", [4]string{"libp2p", "XMPP", "IRC", "Matrix"}},
+	{"What does the D in DHT stand for?", [4]string{"Distributed", "Dynamic", "Direct", "Decentralized"}},
+	{"Which routing algorithm does the IPFS DHT implement?", [4]string{"Kademlia", "Chord", "Pastry", "Tapestry"}},
+	{"What is the name of the PubSub router used by PeerChat?", [4]string{"GossipSub", "FloodSub", "RandomSub", "MeshSub"}},
+	{"Which language is PeerChat written in?", [4]string{"Go", "Rust", "C", "Python"}},
+	{"What is the default room of PeerChat?", [4]string{"lobby", "general", "main", "home"}},
+	{"How many bits long is an IPv4 address?", [4]string{"32", "64", "128", "16"}},
+	{"Which port does SSH listen on by default?", [4]string{"22", "21", "23", "25"}},
+	{"Which planet is the largest in the solar system?", [4]string{"Jupiter", "Saturn", "Neptune", "Earth"}},
+	{"What is the chemical symbol of gold?", [4]string{"Au", "Ag", "Go", "Gd"}},
+	{"How many sides does a hexagon have?", [4]string{"6", "5", "7", "8"}},
+	{"Who proposed the theory of general relativity?", [4]string{"Albert Einstein", "Isaac Newton", "Niels Bohr", "Max Planck"}},
+	{"What is the largest ocean on Earth?", [4]string{"Pacific", "Atlantic", "Indian", "Arctic"}},
+	{"Which year did the first version of the World Wide Web go live?", [4]string{"1991", "1985", "1995", "1989"}},
+	{"What does TCP stand for?", [4]string{"Transmission Control Protocol", "Transfer Connection Protocol", "Terminal Control Program", "Traffic Control Protocol"}},
+	{"Which data structure works first in, first out?", [4]string{"Queue", "Stack", "Tree", "Heap"}},
+}
+
+// Represents the number of questions of a trivia game
+const triviarounds = 6
+
+// A structure that represents a game of trivia. The players take turns to
+// answer the questions, which are picked and shuffled from the seed of the
+// game, and score a point for each right answer.
+type trivia struct {
+	// Represents the questions of the game, with their choices shuffled
+	questions []triviaquestion
+	// Represents the position of the answer among the choices of each question
+	answers []int
+	// Represents the number of questions answered
+	round int
+	// Represents the scores of the players
+	scores [2]int
+	// Represents the outcome of the last answer
+	last string
+}
+
+// A constructor function that generates and returns a new game of trivia
+func newtrivia(seed int64) game {
+	random := rand.New(rand.NewSource(seed))
+	quiz := &trivia{}
+
+	for _, index := range random.Perm(len(triviaquestions))[:triviarounds] {
+		question := triviaquestions[index]
+		order := random.Perm(4)
+
+		shuffled := triviaquestion{question: question.question}
+		for position, choice := range order {
+			shuffled.choices[position] = question.choices[choice]
+			if choice == 0 {
+				quiz.answers = append(quiz.answers, position)
+			}
+		}
+		quiz.questions = append(quiz.questions, shuffled)
+	}
+
+	return quiz
+}
+
+// A method of trivia that returns the player whose turn it is
+func (quiz *trivia) turn() int {
+	return quiz.round % 2
+}
+
+// A method of trivia that plays an answer (a, b, c or d) to the current question
+func (quiz *trivia) play(player int, move string) error {
+	if over, _ := quiz.over(); over {
+		return errors.New("the game is over")
+	}
+	if player != quiz.turn() {
+		return errors.New("it is not your turn")
+	}
+
+	move = strings.ToLower(strings.TrimSpace(move))
+	if len(move) != 1 || move[0] < 'a' || move[0] > 'd' {
+		return errors.New("the answer must be a, b, c or d")
+	}
+
+	answer := quiz.answers[quiz.round]
+	if int(move[0]-'a') == answer {
+		quiz.scores[player]++
+		quiz.last = fmt.Sprintf("%s was right", strings.ToUpper(move))
+	} else {
+		quiz.last = fmt.Sprintf("%s was wrong, the answer was %c) %s", strings.ToUpper(move), 'A'+answer, quiz.questions[quiz.round].choices[answer])
+	}
+
+	quiz.round++
+	return nil
+}
+
+// A method of trivia that returns whether the game is over and its winner
+func (quiz *trivia) over() (bool, int) {
+	if quiz.round < len(quiz.questions) {
+		return false, -1
+	}
+
+	switch {
+	case quiz.scores[0] > quiz.scores[1]:
+		return true, 0
+	case quiz.scores[1] > quiz.scores[0]:
+		return true, 1
+	default:
+		return true, -1
+	}
+}
+
+// A method of trivia that returns the scores and the current question
+func (quiz *trivia) render(player int) string {
+	var builder strings.Builder
+	fmt.Fprintf(&builder, "Trivia, you %d - %d them", quiz.scores[player], quiz.scores[1-player])
+	if quiz.last != "" {
+		fmt.Fprintf(&builder, " (%s)", quiz.last)
+	}
+
+	if over, _ := quiz.over(); over {
+		return builder.String()
+	}
+
+	question := quiz.questions[quiz.round]
+	fmt.Fprintf(&builder, "\nQuestion %d of %d: %s\n", quiz.round+1, len(quiz.questions), question.question)
+	for index, choice := range question.choices {
+		fmt.Fprintf(&builder, " %c) %s\n", 'A'+index, choice)
+	}
+
+	if quiz.turn() == player {
+		builder.WriteString("Your turn: /play <a-d>")
+	} else {
+		builder.WriteString("Their turn")
+	}
+
+	return builder.String()
+}
+
+// A function that renders a notice of a direct message pane, line by line
+func rendernotice(notice string) string {
+	lines := strings.Split(notice, "\n")
+	for index, line := range lines {
+		lines[index] = "[yellow]" + tview.Escape(stripcontrols(line)) + "[-]"
+	}
+
+	return strings.Join(lines, "\n")
+}
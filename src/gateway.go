@@ -0,0 +1,389 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the number of messages kept in the history of each gateway room
+const gatewayhistory = 500
+
+// A structure that represents a chat message recieved or sent by a Gateway
+type GatewayMessage struct {
+	// Represents the chat message (embedded)
+	ChatMessage
+	// Represents the name of the chat room of the message
+	Room string `json:"room"`
+	// Represents the time at which the message was recieved or sent
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// A structure that represents a peer of a gateway room
+type GatewayPeer struct {
+	// Represents the peer ID of the peer
+	ID string `json:"id"`
+	// Represents the last known user name of the peer
+	Name string `json:"name,omitempty"`
+}
+
+// A structure that represents a gateway that manages multiple chat rooms
+// on a host for frontends other than the terminal UI (such as the REST API).
+// The gateway consumes the messages of each room and keeps a recent history.
+type Gateway struct {
+	// Represents the P2P Host of the gateway
+	Host *P2P
+	// Represents the user name used in all the rooms
+	UserName string
+
+	// Represents the joined rooms
+	rooms map[string]*gatewayroom
+	// Represents the last known user names of peers
+	nicks map[peer.ID]string
+	// Represents the channels of the message subscribers
+	subscribers map[chan GatewayMessage]struct{}
+	// Represents the encrypted history on disk (nil if the history is not kept)
+	store *historystore
+	// Represents the messages scheduled for the rooms
+	schedule *schedulestore
+
+	// Represents the lock on the gateway state
+	mutex sync.RWMutex
+}
+
+// A structure that represents a chat room joined by a Gateway
+type gatewayroom struct {
+	// Represents the chat room
+	chatroom *ChatRoom
+	// Represents the recent messages of the room
+	history []GatewayMessage
+	// Represents the number of messages in the history file of the room
+	persisted int
+}
+
+// A constructor function that generates and returns
+// a new Gateway for a given P2PHost and username
+func NewGateway(p2phost *P2P, username string) *Gateway {
+	if username == "" {
+		username = defaultuser
+	}
+
+	gw := &Gateway{
+		Host:     p2phost,
+		UserName: username,
+		rooms:    make(map[string]*gatewayroom),
+		nicks:    make(map[peer.ID]string),
+
+		subscribers: make(map[chan GatewayMessage]struct{}),
+		schedule:    openschedule("gateway"),
+	}
+
+	// Send the scheduled messages when they are due
+	go gw.sendscheduled()
+
+	// Keep the history of the rooms on disk if it is enabled
+	if currentconfig().History.Persist {
+		store, err := openhistory()
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Warnln("Failed to Open the History!")
+		}
+
+		gw.store = store
+		// Apply the retention policy to the history in the background
+		if store != nil {
+			startjanitor(store)
+		}
+	}
+
+	return gw
+}
+
+// A method of Gateway that subscribes to the messages of all joined rooms.
+// Returns the channel of messages and a function that cancels the
+// subscription. Messages are dropped for subscribers that fall behind.
+func (gw *Gateway) Subscribe() (<-chan GatewayMessage, func()) {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+
+	messages := make(chan GatewayMessage, 64)
+	gw.subscribers[messages] = struct{}{}
+
+	cancel := func() {
+		gw.mutex.Lock()
+		defer gw.mutex.Unlock()
+
+		if _, ok := gw.subscribers[messages]; ok {
+			delete(gw.subscribers, messages)
+			close(messages)
+		}
+	}
+
+	return messages, cancel
+}
+
+// A function that normalizes the name of a room given to a Gateway, in the same
+// way as JoinChatRoom, so that the rooms are looked up by the name they are joined with
+func gatewayroomname(roomname string) string {
+	roomname = strings.TrimSpace(roomname)
+	if roomname == "" {
+		return defaultroom
+	}
+
+	return roomname
+}
+
+// A method of Gateway that joins a chat room. Joining a room
+// that has already been joined is a no-op.
+func (gw *Gateway) Join(roomname string) error {
+	roomname = gatewayroomname(roomname)
+
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+
+	if _, ok := gw.rooms[roomname]; ok {
+		return nil
+	}
+
+	// Join the chat room for as long as the host runs
+	chatroom, err := JoinChatRoom(gw.Host.Ctx, gw.Host, gw.UserName, roomname)
+	if err != nil {
+		return err
+	}
+
+	room := &gatewayroom{chatroom: chatroom}
+	gw.rooms[chatroom.RoomName] = room
+
+	// Restore the history of the room
+	if gw.store != nil {
+		room.history, room.persisted = gw.store.load(chatroom.RoomName, gatewayhistory)
+	}
+
+	// Consume the messages of the room
+	go gw.consume(room)
+	return nil
+}
+
+// A method of Gateway that exits a chat room
+func (gw *Gateway) Leave(roomname string) error {
+	roomname = gatewayroomname(roomname)
+
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+
+	room, ok := gw.rooms[roomname]
+	if !ok {
+		return fmt.Errorf("room '%s' has not been joined", roomname)
+	}
+
+	delete(gw.rooms, roomname)
+	room.chatroom.Exit()
+	return nil
+}
+
+// A method of Gateway that returns the names of the joined rooms
+func (gw *Gateway) Rooms() []string {
+	gw.mutex.RLock()
+	defer gw.mutex.RUnlock()
+
+	names := make([]string, 0, len(gw.rooms))
+	for name := range gw.rooms {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// A method of Gateway that publishes a message to a joined room
+func (gw *Gateway) Send(roomname string, message string) error {
+	if message == "" {
+		return errors.New("message is empty")
+	}
+	roomname = gatewayroomname(roomname)
+
+	gw.mutex.Lock()
+	room, ok := gw.rooms[roomname]
+	if ok {
+		// Add the message to the history since the room skips self messages
+		gw.record(room, ChatMessage{
+			Message:    message,
+			SenderID:   gw.Host.Host.ID().Pretty(),
+			SenderName: gw.UserName,
+		})
+	}
+	gw.mutex.Unlock()
+
+	if !ok {
+		return fmt.Errorf("room '%s' has not been joined", roomname)
+	}
+
+	room.chatroom.Outbound <- message
+	return nil
+}
+
+// A method of Gateway that returns up to limit of the most recent
+// messages of a joined room, oldest first. All are returned if limit <= 0.
+func (gw *Gateway) History(roomname string, limit int) ([]GatewayMessage, error) {
+	roomname = gatewayroomname(roomname)
+
+	gw.mutex.RLock()
+	defer gw.mutex.RUnlock()
+
+	room, ok := gw.rooms[roomname]
+	if !ok {
+		return nil, fmt.Errorf("room '%s' has not been joined", roomname)
+	}
+
+	history := unexpired(roomname, room.history)
+	if limit > 0 && len(history) > limit {
+		history = history[len(history)-limit:]
+	}
+
+	return append([]GatewayMessage{}, history...), nil
+}
+
+// A function that returns the messages of a room history that have not outlived
+// the TTL in the settings of the room and are kept by the retention policy
+func unexpired(roomname string, history []GatewayMessage) []GatewayMessage {
+	history = retained(roomname, history)
+
+	settings := roomsettings.get(roomname)
+	if settings == nil || settings.TTL == 0 {
+		return history
+	}
+
+	cutoff := time.Now().Add(-time.Duration(settings.TTL) * time.Second)
+	for index, msg := range history {
+		if msg.Timestamp.After(cutoff) {
+			return history[index:]
+		}
+	}
+
+	return nil
+}
+
+// A method of Gateway that returns the peers of a joined room
+func (gw *Gateway) Peers(roomname string) ([]GatewayPeer, error) {
+	roomname = gatewayroomname(roomname)
+
+	gw.mutex.RLock()
+	defer gw.mutex.RUnlock()
+
+	room, ok := gw.rooms[roomname]
+	if !ok {
+		return nil, fmt.Errorf("room '%s' has not been joined", roomname)
+	}
+
+	peers := []GatewayPeer{}
+	for _, p := range room.chatroom.PeerList() {
+		peers = append(peers, GatewayPeer{ID: p.Pretty(), Name: gw.nicks[p]})
+	}
+
+	return peers, nil
+}
+
+// A method of Gateway that exits all the joined rooms
+func (gw *Gateway) Close() {
+	gw.mutex.Lock()
+	defer gw.mutex.Unlock()
+
+	for name, room := range gw.rooms {
+		room.chatroom.Exit()
+		delete(gw.rooms, name)
+	}
+}
+
+// A method of Gateway that consumes the events of a room until the room is exited
+func (gw *Gateway) consume(room *gatewayroom) {
+	cr := room.chatroom
+	cr.Emit(cr.psctx, gatewayconsumer{gw: gw, room: room})
+}
+
+// A structure that represents the consumer of the events of a room of the gateway
+type gatewayconsumer struct {
+	NopEvents
+
+	gw   *Gateway
+	room *gatewayroom
+}
+
+// A method of gatewayconsumer that records a message received in the room
+func (consumer gatewayconsumer) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	consumer.gw.mutex.Lock()
+	defer consumer.gw.mutex.Unlock()
+
+	consumer.gw.record(consumer.room, msg)
+}
+
+// A method of gatewayconsumer that logs a log of the room
+func (consumer gatewayconsumer) Error(cr *ChatRoom, err error) {
+	logroomerror(cr, err)
+}
+
+// A method of Gateway that adds a message to the history of a room
+// and delivers it to the subscribers. Must be called with the gateway lock held.
+func (gw *Gateway) record(room *gatewayroom, msg ChatMessage) {
+	// Remember the user name of the sender
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		gw.nicks[senderid] = msg.SenderName
+	}
+
+	gatewaymsg := GatewayMessage{
+		ChatMessage: msg,
+		Room:        room.chatroom.RoomName,
+		Timestamp:   time.Now(),
+	}
+
+	room.history = append(room.history, gatewaymsg)
+
+	logrus.WithFields(logrus.Fields{
+		"room":    gatewaymsg.Room,
+		"peer":    msg.SenderID,
+		"user":    msg.SenderName,
+		"message": msg.ID,
+	}).Debugln("Received a Chat Message.")
+
+	// Deliver the message to the subscribers without blocking
+	for subscriber := range gw.subscribers {
+		select {
+		case subscriber <- gatewaymsg:
+		default:
+		}
+	}
+
+	// Trim the history to its maximum length
+	if len(room.history) > gatewayhistory {
+		room.history = room.history[len(room.history)-gatewayhistory:]
+	}
+
+	// Drop the messages that have outlived the TTL of the room,
+	// removing them from the history file as well
+	if kept := unexpired(gatewaymsg.Room, room.history); len(kept) < len(room.history) {
+		room.history = kept
+		if gw.store != nil {
+			gw.store.rewrite(gatewaymsg.Room, room.history)
+			room.persisted = len(room.history)
+			return
+		}
+	}
+
+	// Save the message to the history file, compacting
+	// the file once it holds twice the maximum length
+	if gw.store != nil {
+		gw.store.append(gatewaymsg.Room, gatewaymsg)
+		room.persisted++
+
+		if room.persisted > 2*gatewayhistory {
+			gw.store.rewrite(gatewaymsg.Room, room.history)
+			room.persisted = len(room.history)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package src
+
+import (
+	"errors"
+	"time"
+
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the parameters of the GossipSub router, for
+// operators of large rooms that trade propagation latency for bandwidth.
+// The parameters that are zero keep the defaults of GossipSub.
+type GossipParams struct {
+	// Represents the interval between the heartbeats that maintain the mesh
+	Heartbeat time.Duration
+	// Represents the number of peers in the mesh of a topic (D)
+	D int
+	// Represents the number of peers below which the mesh is grown (Dlo)
+	Dlo int
+	// Represents the number of peers above which the mesh is pruned (Dhi)
+	Dhi int
+	// Represents the number of heartbeats that the sent messages are kept for
+	History int
+	// Represents the time that the fanout of a topic that isn't joined is kept for
+	FanoutTTL time.Duration
+}
+
+// A function that sets the parameters of the GossipSub router, which must be
+// done before the host is created. The parameters apply to every host of the process.
+func SetGossipParams(params GossipParams) error {
+	if params.Heartbeat < 0 || params.FanoutTTL < 0 {
+		return errors.New("the gossipsub durations cannot be negative")
+	}
+	if params.D < 0 || params.Dlo < 0 || params.Dhi < 0 || params.History < 0 {
+		return errors.New("the gossipsub degrees and history cannot be negative")
+	}
+	if params == (GossipParams{}) {
+		return nil
+	}
+
+	// Check the degrees that are set against the defaults of those that are not
+	d, dlo, dhi := orint(params.D, pubsub.GossipSubD), orint(params.Dlo, pubsub.GossipSubDlo), orint(params.Dhi, pubsub.GossipSubDhi)
+	if dlo > d || d > dhi {
+		return errors.New("the gossipsub degrees must satisfy Dlo <= D <= Dhi")
+	}
+
+	history := orint(params.History, pubsub.GossipSubHistoryLength)
+	if history < pubsub.GossipSubHistoryGossip {
+		return errors.New("the gossipsub history must cover the gossip window (3 heartbeats)")
+	}
+
+	pubsub.GossipSubD, pubsub.GossipSubDlo, pubsub.GossipSubDhi = d, dlo, dhi
+	pubsub.GossipSubHistoryLength = history
+	if params.Heartbeat > 0 {
+		pubsub.GossipSubHeartbeatInterval = params.Heartbeat
+	}
+	if params.FanoutTTL > 0 {
+		pubsub.GossipSubFanoutTTL = params.FanoutTTL
+	}
+
+	// Keep the outbound and score quotas of the mesh within the new degrees
+	pubsub.GossipSubDout = minint(pubsub.GossipSubDout, minint(dlo-1, d/2))
+	pubsub.GossipSubDscore = minint(pubsub.GossipSubDscore, d)
+
+	logrus.WithFields(logrus.Fields{
+		"heartbeat": pubsub.GossipSubHeartbeatInterval,
+		"d":         d,
+		"dlo":       dlo,
+		"dhi":       dhi,
+		"history":   history,
+		"fanoutttl": pubsub.GossipSubFanoutTTL,
+	}).Infoln("Tuned the GossipSub Parameters.")
+
+	return nil
+}
+
+// A function that returns a value, or the fallback if the value is zero
+func orint(value int, fallback int) int {
+	if value == 0 {
+		return fallback
+	}
+
+	return value
+}
@@ -0,0 +1,220 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A structure that represents the headless ChatRoom interface.
+// The headless interface runs without tview, emitting events as
+// JSON lines on stdout and accepting messages and commands as
+// JSON lines on stdin, so that bots can be written in any language.
+type Headless struct {
+	// Represents the ChatRoom (embedded)
+	*ChatRoom
+
+	// Represents the source of JSON input lines
+	input io.Reader
+	// Represents the encoder for JSON output lines
+	encoder *json.Encoder
+}
+
+// A structure that represents a JSON input line of the headless interface.
+// Type is either 'message' (with Message) or 'command' (with Command and Arg).
+// Supported commands are 'room', 'user', 'peers' and 'quit'.
+type headlessinput struct {
+	Type    string `json:"type"`
+	Message string `json:"message,omitempty"`
+	Command string `json:"command,omitempty"`
+	Arg     string `json:"arg,omitempty"`
+}
+
+// A structure that represents a JSON output line of the headless interface.
+// Type is one of 'joined', 'message', 'join', 'leave', 'log', 'peers' or 'error'.
+type headlessoutput struct {
+	Type       string   `json:"type"`
+	Room       string   `json:"room,omitempty"`
+	User       string   `json:"user,omitempty"`
+	SenderID   string   `json:"senderid,omitempty"`
+	SenderName string   `json:"sendername,omitempty"`
+	Message    string   `json:"message,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Level      string   `json:"level,omitempty"`
+	Prefix     string   `json:"prefix,omitempty"`
+	Peers      []string `json:"peers,omitempty"`
+	Peer       string   `json:"peer,omitempty"`
+}
+
+// A constructor function that generates and
+// returns a new Headless interface for a given ChatRoom
+func NewHeadless(cr *ChatRoom) *Headless {
+	hl := &Headless{
+		ChatRoom: cr,
+		input:    os.Stdin,
+		encoder:  json.NewEncoder(os.Stdout),
+	}
+
+	// Publish the messages sent by scripts to the current room
+	SetScriptSender(func(text string) {
+		hl.Outbound <- text
+	})
+
+	return hl
+}
+
+// A method of Headless that starts the headless interface
+// and runs until a quit command is recieved or stdin closes
+func (hl *Headless) Run() error {
+	// Read the input lines in the background
+	lines := make(chan []byte)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(hl.input)
+		for scanner.Scan() {
+			lines <- append([]byte{}, scanner.Bytes()...)
+		}
+	}()
+
+	defer hl.Exit()
+	hl.emit(headlessoutput{Type: "joined", Room: hl.RoomName, User: hl.UserName})
+
+	// Follow the events of the chat room, and of the rooms it is left for
+	events := hl.Events(context.Background())
+
+	for {
+		select {
+
+		case line, ok := <-lines:
+			// Exit if the input has closed
+			if !ok {
+				return nil
+			}
+
+			// Unmarshal the input line
+			var in headlessinput
+			if err := json.Unmarshal(line, &in); err != nil {
+				hl.emit(headlessoutput{Type: "error", Message: fmt.Sprintf("could not unmarshal JSON - %s", err)})
+				continue
+			}
+
+			switch in.Type {
+			case "message":
+				// Send the message to outbound queue
+				if in.Message != "" {
+					hl.Outbound <- in.Message
+				}
+
+			case "command":
+				if quit := hl.handlecommand(in); quit {
+					return nil
+				}
+
+			default:
+				hl.emit(headlessoutput{Type: "error", Message: fmt.Sprintf("unsupported input type - %s", in.Type)})
+			}
+
+		case event, ok := <-events:
+			// Stop waiting for events once the chat room has closed
+			if !ok {
+				events = nil
+				continue
+			}
+
+			event(hl)
+
+		case <-hl.psctx.Done():
+			return nil
+		}
+	}
+}
+
+// A method of Headless that writes a message received in the chat room
+func (hl *Headless) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	hl.emit(headlessoutput{
+		Type:       "message",
+		Room:       cr.RoomName,
+		SenderID:   msg.SenderID,
+		SenderName: msg.SenderName,
+		Message:    msg.Message,
+		Tags:       msg.Tags,
+	})
+}
+
+// A method of Headless that writes a peer joining the chat room
+func (hl *Headless) PeerJoined(cr *ChatRoom, peerid peer.ID) {
+	hl.emit(headlessoutput{Type: "join", Room: cr.RoomName, Peer: peerid.Pretty()})
+}
+
+// A method of Headless that writes a peer leaving the chat room
+func (hl *Headless) PeerLeft(cr *ChatRoom, peerid peer.ID) {
+	hl.emit(headlessoutput{Type: "leave", Room: cr.RoomName, Peer: peerid.Pretty()})
+}
+
+// A method of Headless that is told when the chat room is left for
+// another room. The room command writes the 'joined' line itself.
+func (hl *Headless) RoomChanged(from *ChatRoom, to *ChatRoom) {}
+
+// A method of Headless that writes a log of the chat room
+func (hl *Headless) Error(cr *ChatRoom, err error) {
+	log := errorlog(err)
+	hl.emit(headlessoutput{Type: "log", Room: cr.RoomName, Level: log.loglevel.String(), Prefix: log.logprefix, Message: log.logmsg})
+}
+
+// A method of Headless that handles a command input.
+// Returns true if the command asks to quit.
+func (hl *Headless) handlecommand(in headlessinput) bool {
+	switch in.Command {
+	case "quit":
+		return true
+
+	case "room":
+		if in.Arg == "" {
+			hl.emit(headlessoutput{Type: "error", Message: "missing room name for command"})
+			break
+		}
+
+		// Jump to the new chatroom
+		newchatroom, err := hl.Jump(context.Background(), in.Arg)
+		if err != nil {
+			hl.emit(headlessoutput{Type: "error", Message: fmt.Sprintf("could not change chat room - %s", err)})
+			break
+		}
+
+		hl.ChatRoom = newchatroom
+		hl.emit(headlessoutput{Type: "joined", Room: hl.RoomName, User: hl.UserName})
+
+	case "user":
+		if in.Arg == "" {
+			hl.emit(headlessoutput{Type: "error", Message: "missing user name for command"})
+			break
+		}
+
+		hl.UpdateUser(in.Arg)
+
+	case "peers":
+		// Collect the peer IDs of the room
+		peers := []string{}
+		for _, p := range hl.PeerList() {
+			peers = append(peers, p.Pretty())
+		}
+
+		hl.emit(headlessoutput{Type: "peers", Room: hl.RoomName, Peers: peers})
+
+	default:
+		hl.emit(headlessoutput{Type: "error", Message: fmt.Sprintf("unsupported command - %s", in.Command)})
+	}
+
+	return false
+}
+
+// A method of Headless that writes an output line
+func (hl *Headless) emit(out headlessoutput) {
+	hl.encoder.Encode(out)
+}
@@ -0,0 +1,363 @@
+package src
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the known plaintext that checks the key of the history
+const historycheck = "peerchat-history"
+
+// Represents the age after which the lock on the history of a room is stale
+const historylockstale = 10 * time.Second
+
+// Represents the interval at which a lock on the history of a room is retried
+const historylockpoll = 10 * time.Millisecond
+
+// A structure that represents the history of the rooms kept on disk. Each
+// room has an append-only file of messages in ~/.peerchat/history, each of
+// which is encrypted and authenticated with AES-256-GCM (with the room name
+// as additional data), so the contents of rooms do not leak from the disk.
+// The key is derived from the PEERCHAT_HISTORY_PASSPHRASE environment
+// variable if it is set, else from the identity key of the profile.
+type historystore struct {
+	// Represents the directory of the history files
+	dir string
+	// Represents the cipher of the history
+	aead cipher.AEAD
+	// Represents the rooms known to be in the index of the history
+	indexed map[string]bool
+	// Represents the lock on the index
+	indexlock sync.Mutex
+}
+
+// A function that returns the path of the history directory
+func historydir() string {
+	return peerchatpath("history")
+}
+
+// A function that opens the history store of the selected profile, deriving
+// its key and checking it against the key that the history was written with
+func openhistory() (*historystore, error) {
+	dir := historydir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+
+	key, err := historykey(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := keycipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	store := &historystore{dir: dir, aead: aead, indexed: make(map[string]bool)}
+
+	// Check the key with the known plaintext, which is written on first use
+	checkpath := filepath.Join(dir, "check")
+	data, err := ioutil.ReadFile(checkpath)
+	switch {
+	case os.IsNotExist(err):
+		return store, ioutil.WriteFile(checkpath, store.seal([]byte(historycheck), ""), 0600)
+	case err != nil:
+		return nil, err
+	}
+
+	if plaintext, err := store.open(data, ""); err != nil || string(plaintext) != historycheck {
+		return nil, errors.New("the history was encrypted with another key")
+	}
+
+	return store, nil
+}
+
+// A function that derives the key of the history from the passphrase
+// (with scrypt and the salt of the history) or the identity key
+func historykey(dir string) ([]byte, error) {
+	if passphrase := os.Getenv("PEERCHAT_HISTORY_PASSPHRASE"); passphrase != "" {
+		saltpath := filepath.Join(dir, "salt")
+		salt, err := ioutil.ReadFile(saltpath)
+		if os.IsNotExist(err) {
+			salt = make([]byte, 16)
+			if _, err = rand.Read(salt); err == nil {
+				err = ioutil.WriteFile(saltpath, salt, 0600)
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		return passwordkey(passphrase, salt)
+	}
+
+	prvkey, err := loadidentity()
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := crypto.MarshalPrivateKey(prvkey)
+	if err != nil {
+		return nil, err
+	}
+
+	hash := sha256.Sum256(append([]byte(historycheck), raw...))
+	return hash[:], nil
+}
+
+// A method of historystore that returns the path of the history file of a
+// room, which is named after the hash of the room so the names do not leak
+func (store *historystore) path(roomname string) string {
+	hash := sha256.Sum256([]byte(roomname))
+	return filepath.Join(store.dir, hex.EncodeToString(hash[:8])+".log")
+}
+
+// A method of historystore that encrypts data for a room
+func (store *historystore) seal(data []byte, roomname string) []byte {
+	nonce := make([]byte, store.aead.NonceSize())
+	rand.Read(nonce)
+
+	sealed := store.aead.Seal(nonce, nonce, data, []byte(roomname))
+	return []byte(base64.StdEncoding.EncodeToString(sealed))
+}
+
+// A method of historystore that decrypts data of a room
+func (store *historystore) open(data []byte, roomname string) ([]byte, error) {
+	sealed, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(data)))
+	if err != nil {
+		return nil, err
+	}
+
+	size := store.aead.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("invalid history entry")
+	}
+
+	return store.aead.Open(nil, sealed[:size], sealed[size:], []byte(roomname))
+}
+
+// A method of historystore that returns up to limit of the most recent
+// messages of a room, oldest first, with the number of entries in its file.
+// Entries that cannot be decrypted are skipped.
+func (store *historystore) load(roomname string, limit int) ([]GatewayMessage, int) {
+	file, err := os.Open(store.path(roomname))
+	if err != nil {
+		return nil, 0
+	}
+
+	var messages []GatewayMessage
+	var lines int
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines++
+
+		plaintext, err := store.open(scanner.Bytes(), roomname)
+		if err != nil {
+			continue
+		}
+
+		var msg GatewayMessage
+		if err := json.Unmarshal(plaintext, &msg); err == nil {
+			messages = append(messages, msg)
+		}
+	}
+	file.Close()
+
+	if len(messages) > limit {
+		messages = messages[len(messages)-limit:]
+	}
+
+	return messages, lines
+}
+
+// A method of historystore that takes the lock on the history of a room and returns
+// the function that releases it. The lock is a file next to the history that is
+// created exclusively, so it holds across the processes of the profile (the gateway
+// appending and the /purge of another UI). A lock older than historylockstale was
+// left by a process that died and is taken over.
+func (store *historystore) lock(roomname string) func() {
+	path := store.path(roomname) + ".lock"
+	for {
+		file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			file.Close()
+			return func() { os.Remove(path) }
+		}
+
+		if !os.IsExist(err) {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  roomname,
+			}).Debugln("Failed to Lock the History of the Room!")
+			return func() {}
+		}
+
+		if info, err := os.Stat(path); err == nil && time.Since(info.ModTime()) > historylockstale {
+			os.Remove(path)
+			continue
+		}
+
+		time.Sleep(historylockpoll)
+	}
+}
+
+// A method of historystore that appends a message to the history of a room
+func (store *historystore) append(roomname string, msg GatewayMessage) {
+	store.index(roomname)
+	defer store.lock(roomname)()
+
+	data, err := json.Marshal(msg)
+	if err == nil {
+		var file *os.File
+		file, err = os.OpenFile(store.path(roomname), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err == nil {
+			_, err = file.Write(append(store.seal(data, roomname), '\n'))
+			file.Close()
+		}
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Warnln("Failed to Save the Message to the History!")
+	}
+}
+
+// A method of historystore that replaces the history of a room with
+// messages, which compacts its file to the messages that are kept
+func (store *historystore) rewrite(roomname string, messages []GatewayMessage) {
+	store.index(roomname)
+	defer store.lock(roomname)()
+
+	store.write(roomname, messages)
+}
+
+// A method of historystore that replaces the history of a room with messages
+// while the lock on the history of the room is held by the caller
+func (store *historystore) write(roomname string, messages []GatewayMessage) {
+	var buffer bytes.Buffer
+	for _, msg := range messages {
+		if data, err := json.Marshal(msg); err == nil {
+			buffer.Write(store.seal(data, roomname))
+			buffer.WriteByte('\n')
+		}
+	}
+
+	// Write to a temporary file first, so the history is not lost on failure
+	path := store.path(roomname)
+	err := ioutil.WriteFile(path+".tmp", buffer.Bytes(), 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Warnln("Failed to Compact the History!")
+	}
+}
+
+// A method of historystore that adds a room to the index of the history, which
+// lists the rooms of the encrypted history files (named after their hashes) for
+// the retention policy. The index is encrypted like the history.
+func (store *historystore) index(roomname string) {
+	store.indexlock.Lock()
+	defer store.indexlock.Unlock()
+
+	if len(store.indexed) == 0 {
+		for _, known := range store.readindex() {
+			store.indexed[known] = true
+		}
+	}
+	if store.indexed[roomname] {
+		return
+	}
+
+	file, err := os.OpenFile(filepath.Join(store.dir, "index"), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err == nil {
+		_, err = file.Write(append(store.seal([]byte(roomname), "index"), '\n'))
+		file.Close()
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Debugln("Failed to Index the History of the Room!")
+		return
+	}
+
+	store.indexed[roomname] = true
+}
+
+// A method of historystore that returns the rooms in the index of the history
+func (store *historystore) readindex() []string {
+	data, err := ioutil.ReadFile(filepath.Join(store.dir, "index"))
+	if err != nil {
+		return nil
+	}
+
+	seen := make(map[string]bool)
+	var rooms []string
+	for _, line := range bytes.Split(data, []byte("\n")) {
+		roomname, err := store.open(line, "index")
+		if err != nil || seen[string(roomname)] {
+			continue
+		}
+
+		seen[string(roomname)] = true
+		rooms = append(rooms, string(roomname))
+	}
+
+	return rooms
+}
+
+// A method of historystore that shreds the history of a room and removes the room
+// from the index of the history. Returns the number of files shredded.
+func (store *historystore) forget(roomname string) (int, error) {
+	store.indexlock.Lock()
+	defer store.indexlock.Unlock()
+
+	var buffer bytes.Buffer
+	for _, known := range store.readindex() {
+		if known != roomname {
+			buffer.Write(store.seal([]byte(known), "index"))
+			buffer.WriteByte('\n')
+		}
+	}
+
+	// Shred the index before writing it again without the room
+	path := filepath.Join(store.dir, "index")
+	count, err := shred(path)
+	if err == nil && buffer.Len() > 0 {
+		err = ioutil.WriteFile(path, buffer.Bytes(), 0600)
+	}
+	if err != nil {
+		return count, err
+	}
+	delete(store.indexed, roomname)
+
+	shredded, err := shred(store.path(roomname))
+	return count + shredded, err
+}
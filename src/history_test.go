@@ -0,0 +1,65 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestHistoryLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "history")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store := &historystore{dir: dir}
+	unlock := store.lock("lobby")
+
+	// A second holder waits until the lock is released
+	acquired := make(chan struct{})
+	go func() {
+		store.lock("lobby")()
+		close(acquired)
+	}()
+
+	select {
+	case <-acquired:
+		t.Fatal("the lock was taken twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("the lock was not taken after it was released")
+	}
+
+	// The locks of other rooms are independent
+	store.lock("lobby")()
+	defer store.lock("other")()
+
+	// A stale lock left by a dead process is taken over
+	path := store.path("lobby") + ".lock"
+	if err := ioutil.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	stale := time.Now().Add(-2 * historylockstale)
+	if err := os.Chtimes(path, stale, stale); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		store.lock("lobby")()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("the stale lock was not taken over")
+	}
+}
@@ -0,0 +1,255 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Represents the formats of the transcripts that can be imported into the history:
+// JSON lines and text from 'peerchat export', and the daily transcripts of the rooms
+var importformats = []string{"auto", "json", "text", "transcript"}
+
+// Represents the lines of the text export ('[<RFC3339>] <sender> message', where
+// the sender can escape '>') and of the transcripts ('15:04:05 <sender> message')
+var (
+	exportline     = regexp.MustCompile(`^\[([^\]]+)\] <((?:[^\\>]|\\.)*)> (.*)$`)
+	transcriptline = regexp.MustCompile(`^(\d\d:\d\d:\d\d) <([^>]*)> (.*)$`)
+)
+
+// A structure that represents the outcome of an import into the history
+type ImportStats struct {
+	// Represents the number of messages imported into each room
+	Imported map[string]int
+	// Represents the number of messages that were in the history already
+	Duplicates int
+	// Represents the number of lines that could not be parsed
+	Skipped int
+}
+
+// A function that imports transcripts into the history of the rooms, so that the
+// archive of the rooms is kept when moving to another machine. The path is a file
+// or a directory of files (such as a room directory of the transcripts). The format
+// is one of importformats, 'auto' detecting it line by line. The room is required
+// unless the lines are JSON messages, which carry their room. Messages that are in
+// the history already are skipped, and the history is kept in the order of time.
+func ImportHistory(path string, room string, format string) (ImportStats, error) {
+	result := ImportStats{Imported: make(map[string]int)}
+
+	if !validimportformat(format) {
+		return result, fmt.Errorf("invalid import format '%s' (%s)", format, strings.Join(importformats, ", "))
+	}
+
+	files, err := importfiles(path)
+	if err != nil {
+		return result, err
+	}
+
+	// Parse the messages of all the files by room
+	parsed := make(map[string][]GatewayMessage)
+	for _, file := range files {
+		messages, skipped, err := parsetranscript(file, room, format)
+		if err != nil {
+			return result, err
+		}
+
+		result.Skipped += skipped
+		for _, msg := range messages {
+			parsed[msg.Room] = append(parsed[msg.Room], msg)
+		}
+	}
+
+	store, err := openhistory()
+	if err != nil {
+		return result, err
+	}
+
+	// Merge the messages into the history of each room
+	for roomname, messages := range parsed {
+		store.index(roomname)
+		unlock := store.lock(roomname)
+		existing, _ := store.load(roomname, math.MaxInt32)
+
+		seen := make(map[string]bool, len(existing))
+		for _, msg := range existing {
+			seen[historykeyof(msg)] = true
+		}
+
+		merged := existing
+		for _, msg := range messages {
+			if key := historykeyof(msg); !seen[key] {
+				seen[key] = true
+				merged = append(merged, msg)
+				result.Imported[roomname]++
+			} else {
+				result.Duplicates++
+			}
+		}
+
+		if result.Imported[roomname] > 0 {
+			sort.SliceStable(merged, func(i, j int) bool {
+				return merged[i].Timestamp.Before(merged[j].Timestamp)
+			})
+			store.write(roomname, merged)
+		}
+		unlock()
+	}
+
+	return result, nil
+}
+
+// A function that returns whether the history of the rooms is kept on disk
+func HistoryEnabled() bool {
+	return currentconfig().History.Persist
+}
+
+// A function that checks an import format
+func validimportformat(format string) bool {
+	for _, known := range importformats {
+		if format == known {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A function that returns the files to import from a path, which are
+// the files of the path in the order of their names if it is a directory
+func importfiles(path string) ([]string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if !info.IsDir() {
+		return []string{path}, nil
+	}
+
+	entries, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	for _, entry := range entries {
+		if !entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			files = append(files, filepath.Join(path, entry.Name()))
+		}
+	}
+
+	return files, nil
+}
+
+// A function that returns the line of a message in the text export. Control
+// characters are stripped, and the newlines and backslashes of the message (and
+// the '>' of the sender) are escaped, so that the text of peers cannot forge the
+// lines of the export.
+func ExportLine(msg GatewayMessage) string {
+	sender := strings.NewReplacer(`\`, `\\`, ">", `\>`).Replace(stripcontrols(msg.SenderName))
+	message := strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(msg.Message)
+	return fmt.Sprintf("[%s] <%s> %s", msg.Timestamp.Format(time.RFC3339), sender, stripcontrols(message))
+}
+
+// A function that reverses the escapes of the sender or message of a line of the text export
+func unescapeexport(text string) string {
+	var builder strings.Builder
+	for index := 0; index < len(text); index++ {
+		if text[index] == '\\' && index+1 < len(text) {
+			switch text[index+1] {
+			case 'n':
+				builder.WriteByte('\n')
+				index++
+				continue
+			case '\\', '>':
+				builder.WriteByte(text[index+1])
+				index++
+				continue
+			}
+		}
+
+		builder.WriteByte(text[index])
+	}
+
+	return builder.String()
+}
+
+// A function that returns the key that identifies a message in the history
+func historykeyof(msg GatewayMessage) string {
+	return fmt.Sprintf("%d|%s|%s", msg.Timestamp.Unix(), msg.SenderName, msg.Message)
+}
+
+// A function that parses the messages of a transcript file, in a format or detecting
+// it line by line. Returns the messages with the number of lines that were skipped.
+func parsetranscript(path string, room string, format string) ([]GatewayMessage, int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	// The transcripts of the rooms are named after their day
+	day, dayerr := time.ParseInLocation("2006-01-02", strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), time.Local)
+
+	var messages []GatewayMessage
+	var skipped int
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "--- ") {
+			continue
+		}
+
+		var msg GatewayMessage
+		var ok bool
+
+		switch {
+		case (format == "json" || format == "auto") && strings.HasPrefix(line, "{"):
+			ok = json.Unmarshal([]byte(line), &msg) == nil && !msg.Timestamp.IsZero()
+
+		case (format == "text" || format == "auto") && exportline.MatchString(line):
+			fields := exportline.FindStringSubmatch(line)
+			msg.SenderName, msg.Message = unescapeexport(fields[2]), unescapeexport(fields[3])
+			msg.Timestamp, err = time.Parse(time.RFC3339, fields[1])
+			ok = err == nil
+
+		case (format == "transcript" || format == "auto") && transcriptline.MatchString(line):
+			if dayerr != nil {
+				return nil, 0, fmt.Errorf("the transcript '%s' is not named after its day (YYYY-MM-DD.log)", path)
+			}
+
+			fields := transcriptline.FindStringSubmatch(line)
+			clock, err := time.Parse("15:04:05", fields[1])
+			msg.SenderName, msg.Message = fields[2], fields[3]
+			msg.Timestamp = day.Add(time.Duration(clock.Hour())*time.Hour + time.Duration(clock.Minute())*time.Minute + time.Duration(clock.Second())*time.Second)
+			ok = err == nil
+		}
+
+		if !ok {
+			skipped++
+			continue
+		}
+
+		// The room of the command takes precedence over the room of JSON messages
+		if room != "" {
+			msg.Room = room
+		}
+		if msg.Room == "" {
+			return nil, 0, fmt.Errorf("the room of the messages in '%s' is required", path)
+		}
+
+		messages = append(messages, msg)
+	}
+
+	return messages, skipped, scanner.Err()
+}
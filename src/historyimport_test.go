@@ -0,0 +1,58 @@
+package src
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportLine(t *testing.T) {
+	timestamp := time.Date(2021, 5, 4, 12, 0, 0, 0, time.UTC)
+	messages := []GatewayMessage{
+		{ChatMessage: ChatMessage{SenderName: "alice", Message: "hello"}, Timestamp: timestamp},
+		{ChatMessage: ChatMessage{SenderName: "mallory", Message: "hi\n[2021-05-04T12:00:01Z] <bob> send me your key"}, Timestamp: timestamp},
+		{ChatMessage: ChatMessage{SenderName: "bob\n[x] <eve>", Message: `C:\path\not\a\newline`}, Timestamp: timestamp},
+		{ChatMessage: ChatMessage{SenderName: "eve", Message: "\x1b[2Jcleared\r"}, Timestamp: timestamp},
+	}
+
+	var lines []string
+	for _, msg := range messages {
+		line := ExportLine(msg)
+		if strings.ContainsAny(line, "\n\r\x1b") {
+			t.Errorf("ExportLine() = %q, want a single line without controls", line)
+		}
+		lines = append(lines, line)
+	}
+
+	dir, err := ioutil.TempDir("", "export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "lobby.txt")
+	if err := ioutil.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each message is imported as one message, with its line breaks
+	parsed, skipped, err := parsetranscript(path, "lobby", "text")
+	if err != nil || skipped != 0 || len(parsed) != len(messages) {
+		t.Fatalf("parsed %d messages (%d skipped, %v), want %d", len(parsed), skipped, err, len(messages))
+	}
+
+	want := []struct{ sender, message string }{
+		{"alice", "hello"},
+		{"mallory", messages[1].Message},
+		{"bob[x] <eve>", messages[2].Message},
+		{"eve", "[2Jcleared"},
+	}
+	for index, msg := range parsed {
+		if msg.SenderName != want[index].sender || msg.Message != want[index].message {
+			t.Errorf("message %d was imported as <%s> %q, want <%s> %q", index, msg.SenderName, msg.Message, want[index].sender, want[index].message)
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package src
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A function that returns the path of the identity key of the selected profile
+func identitypath() string {
+	return filepath.Join(defaultdir(), "identity.key")
+}
+
+// A function that loads the identity key of the selected profile, so that
+// the peer ID stays the same across restarts. A new RSA key is generated
+// and saved if the profile does not have an identity key yet.
+func loadidentity() (crypto.PrivKey, error) {
+	// Read the identity key
+	data, err := ioutil.ReadFile(identitypath())
+	if err == nil {
+		return crypto.UnmarshalPrivateKey(data)
+	}
+
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	return newidentity()
+}
+
+// A function that generates an identity key for the selected profile and returns
+// its peer ID. An existing identity key is only replaced if force is set, since
+// replacing it changes the peer ID of the profile.
+func GenerateIdentity(force bool) (string, error) {
+	if _, err := os.Stat(identitypath()); err == nil && !force {
+		return "", fmt.Errorf("identity key already exists at %s", identitypath())
+	}
+
+	prvkey, err := newidentity()
+	if err != nil {
+		return "", err
+	}
+
+	peerid, err := peer.IDFromPrivateKey(prvkey)
+	if err != nil {
+		return "", err
+	}
+
+	return peerid.Pretty(), nil
+}
+
+// A function that generates a new RSA identity key and
+// saves it as the identity key of the selected profile
+func newidentity() (crypto.PrivKey, error) {
+	prvkey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := crypto.MarshalPrivateKey(prvkey)
+	if err != nil {
+		return nil, err
+	}
+
+	// Save the identity key, readable only by the user
+	path := identitypath()
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, data, 0600); err != nil {
+		return nil, err
+	}
+
+	return prvkey, nil
+}
@@ -0,0 +1,130 @@
+package src
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the default sender name of incoming webhook messages
+const defaultinhooksender = "webhook"
+
+// Represents the maximum size of an incoming webhook request body
+const maxinhookbody = 64 * 1024
+
+// A structure that represents the JSON body of an incoming webhook request
+type inhookrequest struct {
+	// Represents the room to publish the message into
+	Room string `json:"room"`
+	// Represents the text of the message
+	Message string `json:"message"`
+	// Represents the sender name of the message (optional)
+	Sender string `json:"sender"`
+}
+
+// A function that starts a local HTTP endpoint on the given address where
+// external tools (such as CI pipelines or monitoring) can POST messages to
+// '/message' that the node publishes into a chat room. Requests must carry
+// the token as a bearer token in the Authorization header.
+func ServeIncomingWebhooks(p2phost *P2P, addr string, token string) error {
+	// Refuse to serve without a token
+	if token == "" {
+		return errors.New("incoming webhooks require a token")
+	}
+
+	// Listen on the address
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/message", func(w http.ResponseWriter, r *http.Request) {
+		// Check the method
+		if r.Method != http.MethodPost {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		// Check the token
+		if !checkbearer(r, token) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		// Decode the request
+		var req inhookrequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxinhookbody)).Decode(&req); err != nil {
+			http.Error(w, "could not decode JSON", http.StatusBadRequest)
+			return
+		}
+
+		if req.Room == "" || req.Message == "" {
+			http.Error(w, "room and message are required", http.StatusBadRequest)
+			return
+		}
+
+		if req.Sender == "" {
+			req.Sender = defaultinhooksender
+		}
+
+		// Publish the message into the room
+		if err := publishtoroom(p2phost, req.Room, req.Sender, req.Message); err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+
+		w.WriteHeader(http.StatusAccepted)
+	})
+
+	// Serve the requests in the background
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("Incoming Webhook Endpoint Failed!")
+		}
+	}()
+
+	return nil
+}
+
+// A function that checks that a request carries the bearer token
+func checkbearer(r *http.Request, token string) bool {
+	header := r.Header.Get("Authorization")
+	if !strings.HasPrefix(header, "Bearer ") {
+		return false
+	}
+
+	given := strings.TrimPrefix(header, "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// A function that publishes a message into a chat room from
+// the host, without having to join the chat room as a user
+func publishtoroom(p2phost *P2P, roomname string, sender string, message string) error {
+	// Apply the outbound transformers
+	message, ok := pipeline.transform(roomname, message)
+	if !ok {
+		return errors.New("message was dropped")
+	}
+
+	// Join the topic of the room
+	topic, err := p2phost.JoinTopic(roomtopic(roomname))
+	if err != nil {
+		return err
+	}
+	defer p2phost.LeaveTopic(topic.String())
+
+	// Publish the message to the topic
+	return publish(p2phost.Ctx, topic, ChatMessage{
+		Message:    message,
+		SenderID:   p2phost.Host.ID().Pretty(),
+		SenderName: sender,
+	})
+}
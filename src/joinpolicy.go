@@ -0,0 +1,283 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the protocol ID for asking an operator of a room to join it
+const joinprotocol = protocol.ID("/peerchat/join/1.0.0")
+
+// Represents the time allowed for an operator to approve a join request
+const jointimeout = 2 * time.Minute
+
+// A structure that represents a request to join a room
+type joinrequest struct {
+	// Represents the name of the room
+	Room string `json:"room"`
+	// Represents the user name of the peer asking to join
+	Name string `json:"name"`
+
+	// Represents the peer asking to join (not sent to peers)
+	peer peer.ID
+	// Represents the channel on which the decision is sent (not sent to peers)
+	reply chan bool
+}
+
+// A structure that represents the answer to a join request
+type joinreply struct {
+	// Represents whether the peer was admitted to the room
+	Admitted bool `json:"admitted"`
+	// Represents why the peer was not admitted
+	Reason string `json:"reason,omitempty"`
+	// Represents the settings record of the room, which lists the new member
+	Record []byte `json:"record,omitempty"`
+}
+
+// A method of RoomSettings that checks if a room has a join policy
+func (settings *RoomSettings) restricted() bool {
+	return settings.MaxMembers > 0 || settings.Approval
+}
+
+// A method of RoomSettings that checks if a peer is a member of the room.
+// Everyone is a member of rooms that do not require approval to join.
+func (settings *RoomSettings) member(peerid peer.ID) bool {
+	if !settings.Approval || settings.operator(peerid) {
+		return true
+	}
+
+	for _, list := range [][]string{settings.Members, settings.Publishers} {
+		for _, member := range list {
+			if member == peerid.Pretty() {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// A method of P2P that returns the channel of the join requests that
+// the host has to approve as an operator. Requests are denied unless
+// the channel is read, which only the UI does.
+func (p2p *P2P) JoinRequests() <-chan *joinrequest {
+	p2p.joinlock.Lock()
+	defer p2p.joinlock.Unlock()
+
+	if p2p.joinrequests == nil {
+		p2p.joinrequests = make(chan *joinrequest)
+	}
+
+	return p2p.joinrequests
+}
+
+// A method of P2P that goes through the join policy of a room before it is
+// joined. Peers that are not yet members of a room with a member limit or
+// approval required ask its operators to admit them, one after another.
+// Returns an error if the peer is not admitted or the context is cancelled.
+func (p2p *P2P) admit(ctx context.Context, room string, username string) error {
+	lookupctx, cancel := context.WithTimeout(ctx, roomtimeout)
+	defer cancel()
+
+	// Retrieve the settings if they are not known yet
+	if record, err := p2p.getvalue(lookupctx, roomkey(room)); err == nil {
+		p2p.acceptsettings(room, record)
+	}
+
+	settings := roomsettings.get(room)
+	if settings == nil || !settings.restricted() {
+		return nil
+	}
+
+	// Members of the namespace can join its sub-rooms
+	self := p2p.Host.ID()
+	if (settings.Approval && settings.member(self)) || p2p.joinednamespace(room) {
+		return nil
+	}
+
+	reason := "no operator of the room could be reached"
+	for _, operator := range settings.Operators {
+		operatorid, err := peer.Decode(operator)
+		if err != nil || operatorid == self {
+			continue
+		}
+
+		reply, err := p2p.requestjoin(ctx, operatorid, joinrequest{Room: room, Name: username})
+		if err != nil {
+			// Stop asking the operators if the join was cancelled
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+
+			logrus.WithFields(logrus.Fields{
+				"error":    err.Error(),
+				"room":     room,
+				"operator": operator,
+			}).Debugln("Failed to Reach a Room Operator!")
+			continue
+		}
+
+		if reply.Record != nil {
+			p2p.acceptsettings(room, reply.Record)
+		}
+		if reply.Admitted {
+			return nil
+		}
+
+		reason = reply.Reason
+		// A full room is full for every operator
+		if !settings.Approval {
+			break
+		}
+	}
+
+	// Operators can always join their own rooms
+	if settings.operator(self) {
+		return nil
+	}
+
+	return fmt.Errorf("could not join room '%s' - %s", room, reason)
+}
+
+// A method of P2P that asks an operator of a room to admit the host to it
+func (p2p *P2P) requestjoin(ctx context.Context, operator peer.ID, request joinrequest) (*joinreply, error) {
+	ctx, cancel := context.WithTimeout(ctx, jointimeout)
+	defer cancel()
+
+	stream, err := p2p.Host.NewStream(ctx, operator, joinprotocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(jointimeout))
+
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	var reply joinreply
+	if err := json.NewDecoder(io.LimitReader(stream, 2*maxroomsettingssize)).Decode(&reply); err != nil {
+		return nil, err
+	}
+
+	return &reply, nil
+}
+
+// A method of P2P that handles the join requests of a room the host is an
+// operator of. Peers are turned away when the room is full, and otherwise
+// admitted, or asked about in the UI if the room requires approval. Admitted
+// peers are added to the members in the settings of the room.
+func (p2p *P2P) handlejoin(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(jointimeout))
+
+	var request joinrequest
+	if err := json.NewDecoder(io.LimitReader(stream, 4096)).Decode(&request); err != nil {
+		stream.Reset()
+		return
+	}
+
+	request.peer = stream.Conn().RemotePeer()
+	reply := p2p.decidejoin(&request)
+	json.NewEncoder(stream).Encode(reply)
+}
+
+// A method of P2P that decides on a join request
+func (p2p *P2P) decidejoin(request *joinrequest) joinreply {
+	settings := roomsettings.get(request.Room)
+	if settings == nil || !settings.operator(p2p.Host.ID()) {
+		return joinreply{Reason: "not an operator of the room"}
+	}
+
+	if settings.Approval && settings.member(request.peer) {
+		record, _ := roomsettings.record(request.Room)
+		return joinreply{Admitted: true, Record: record}
+	}
+
+	// Count the members as the peers of the room and its sub-rooms and the host
+	if settings.MaxMembers > 0 {
+		members := 1 + len(p2p.roompeers(request.Room))
+		if members >= settings.MaxMembers {
+			return joinreply{Reason: fmt.Sprintf("the room is full (%d members)", settings.MaxMembers)}
+		}
+	}
+
+	if !settings.Approval {
+		return joinreply{Admitted: true}
+	}
+
+	// Ask the operator, if the UI is listening
+	p2p.joinlock.Lock()
+	requests := p2p.joinrequests
+	p2p.joinlock.Unlock()
+
+	request.reply = make(chan bool, 1)
+	select {
+	case requests <- request:
+	case <-time.After(5 * time.Second):
+		return joinreply{Reason: "the operator is not available to approve joins"}
+	}
+
+	var approved bool
+	select {
+	case approved = <-request.reply:
+	case <-time.After(jointimeout - 10*time.Second):
+		return joinreply{Reason: "the operator did not answer"}
+	}
+
+	if !approved {
+		return joinreply{Reason: "the operator denied the request"}
+	}
+
+	// Add the peer to the members of the room
+	_, err := p2p.UpdateRoomSettings(request.Room, func(settings *RoomSettings) error {
+		if !settings.member(request.peer) {
+			settings.Members = append(settings.Members, request.peer.Pretty())
+		}
+		return nil
+	})
+	if err != nil {
+		return joinreply{Reason: err.Error()}
+	}
+
+	record, _ := roomsettings.record(request.Room)
+	return joinreply{Admitted: true, Record: record}
+}
+
+// A method of joinrequest that answers the request
+func (request *joinrequest) answer(approved bool) {
+	select {
+	case request.reply <- approved:
+	default:
+	}
+}
+
+// A method of UI that asks the user to approve a request to join a room
+// they are an operator of. Must be called from the tview event loop.
+func (ui *UI) showjoinrequest(request *joinrequest) {
+	page := fmt.Sprintf("join-%s", request.peer.Pretty())
+	text := fmt.Sprintf("%s (%s) asks to join the room %s.", sanitize(request.Name), request.peer.ShortString(), sanitize(request.Room))
+
+	modal := tview.NewModal().
+		SetText(text).
+		AddButtons([]string{"Approve", "Deny"}).
+		SetDoneFunc(func(index int, label string) {
+			ui.pages.RemovePage(page)
+			ui.TerminalApp.SetFocus(ui.inputBox)
+
+			request.answer(label == "Approve")
+		})
+
+	ui.pages.AddPage(page, modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
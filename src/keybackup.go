@@ -0,0 +1,125 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// A structure that represents a backup of the identity key of a profile
+// with its pinned keys and friends, which is encrypted with a password
+type keybackup struct {
+	// Represents the identity key
+	Identity []byte `json:"identity"`
+	// Represents the keys pinned for user names
+	Pins map[string]pin `json:"pins,omitempty"`
+	// Represents the friends
+	Friends []friend `json:"friends,omitempty"`
+}
+
+// A function that exports the identity key of the selected profile with its
+// pinned keys and friends into a backup that is encrypted with a password
+func ExportIdentity(password string) ([]byte, error) {
+	identity, err := ioutil.ReadFile(identitypath())
+	if err != nil {
+		return nil, err
+	}
+
+	backup := keybackup{
+		Identity: identity,
+		Pins:     make(map[string]pin),
+	}
+
+	pins.mutex.Lock()
+	pins.load()
+	for username, p := range pins.pins {
+		backup.Pins[username] = p
+	}
+	pins.mutex.Unlock()
+
+	backup.Friends = friends.list()
+
+	plaintext, err := json.Marshal(backup)
+	if err != nil {
+		return nil, err
+	}
+
+	return seal(plaintext, password)
+}
+
+// A function that imports a backup that is encrypted with a password into
+// the selected profile and returns the peer ID of the imported identity. An
+// existing different identity key is only replaced if force is set. The pinned
+// keys and friends are merged, keeping the existing ones on conflicts.
+func ImportIdentity(data []byte, password string, force bool) (string, error) {
+	plaintext, err := unseal(data, password)
+	if err != nil {
+		return "", err
+	}
+
+	var backup keybackup
+	if err := json.Unmarshal(plaintext, &backup); err != nil {
+		return "", fmt.Errorf("invalid backup - %s", err)
+	}
+
+	// Check the identity key of the backup
+	prvkey, err := crypto.UnmarshalPrivateKey(backup.Identity)
+	if err != nil {
+		return "", fmt.Errorf("invalid identity key - %s", err)
+	}
+	peerid, err := peer.IDFromPrivateKey(prvkey)
+	if err != nil {
+		return "", err
+	}
+
+	// Replacing a different identity key changes the peer ID of the profile
+	existing, err := ioutil.ReadFile(identitypath())
+	if err == nil && !bytes.Equal(existing, backup.Identity) && !force {
+		return "", fmt.Errorf("a different identity key already exists at %s", identitypath())
+	}
+
+	if err := os.MkdirAll(filepath.Dir(identitypath()), 0700); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(identitypath(), backup.Identity, 0600); err != nil {
+		return "", err
+	}
+
+	// Merge the pinned keys
+	pins.mutex.Lock()
+	pins.load()
+	for username, p := range backup.Pins {
+		if _, ok := pins.pins[username]; !ok {
+			pins.pins[username] = p
+		}
+	}
+	pins.save()
+	pins.mutex.Unlock()
+
+	// Merge the friends
+	friends.mutex.Lock()
+	friends.load()
+	for _, f := range backup.Friends {
+		friendid, err := peer.Decode(f.PeerID)
+		if err != nil {
+			continue
+		}
+		if _, ok := friends.friends[friendid]; !ok {
+			friends.friends[friendid] = f
+		}
+	}
+	err = friends.save()
+	friends.mutex.Unlock()
+
+	if err != nil {
+		return "", err
+	}
+
+	return peerid.Pretty(), nil
+}
@@ -0,0 +1,181 @@
+package src
+
+import (
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the version of the names of the room topics
+const topicversion = 1
+
+// Represents the capability of the clients that publish on the versioned room topics
+const versionedtopics = "topics/1"
+
+// Represents the modes of the legacy room topics. Clients that predate the
+// versioned topics only publish on the legacy topics, which are bridged when
+// 'bridge' (the default): the legacy topic is read for the messages of older
+// clients, and messages are also published on it while older clients are
+// subscribed. The legacy topics are ignored when 'off'.
+var legacymodes = []string{"bridge", "off"}
+
+// A function that checks a mode of the legacy room topics
+func validlegacymode(mode string) bool {
+	return mode == "" || mode == "bridge" || mode == "off"
+}
+
+// A function that returns the legacy PubSub topic name for a chat room name
+func legacytopic(roomname string) string {
+	if hashingtopics() {
+		return legacyprefix() + "h-" + hashroom(roomname)
+	}
+
+	return legacyprefix() + roomname
+}
+
+// A structure that represents the legacy topic of a chat room
+type legacyroom struct {
+	// Represents the legacy topic
+	topic Topic
+	// Represents the subscription to the legacy topic
+	sub Subscription
+	// Represents the signal that the legacy topic is no longer read
+	done chan struct{}
+	// Represents the guard that leaves the legacy topic once
+	once sync.Once
+}
+
+// A method of P2P that returns whether a peer has a capability,
+// as told in its handshake or in its agent version
+func (p2p *P2P) capable(peerid peer.ID, capability string) bool {
+	supported, _ := p2p.peercapabilities(peerid)
+	return contains(supported, capability)
+}
+
+// A method of ChatRoom that joins the legacy topic of the room to bridge older clients,
+// unless the legacy topics are off. The room works without it if it cannot be joined.
+func (cr *ChatRoom) joinlegacy() {
+	if currentconfig().LegacyTopics == "off" {
+		return
+	}
+
+	name := legacytopic(cr.RoomName)
+	topic, err := cr.Host.JoinTopic(name)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Warnln("Failed to Join the Legacy Topic!")
+		return
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		cr.Host.LeaveTopic(name)
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Warnln("Failed to Join the Legacy Topic!")
+		return
+	}
+
+	// Enforce the settings of the room on the legacy topic as well.
+	// A validator may already be registered by another user of the topic.
+	cr.Host.PubSub.RegisterTopicValidator(name, cr.Host.roommessagevalidator(cr.RoomName))
+
+	cr.legacy = &legacyroom{topic: topic, sub: sub, done: make(chan struct{})}
+	go cr.legacyloop()
+}
+
+// A method of ChatRoom that reads the messages of older clients from
+// the legacy topic until the subscription or the room is closed. Newer
+// clients also publish on the versioned topic, so their copies are dropped.
+func (cr *ChatRoom) legacyloop() {
+	defer close(cr.legacy.done)
+
+	for {
+		message, err := cr.legacy.sub.Next(cr.psctx)
+		if err != nil {
+			return
+		}
+
+		sender, err := peer.IDFromBytes(message.GetFrom())
+		if err != nil || cr.versioned(sender) {
+			continue
+		}
+
+		cr.deliver(message)
+	}
+}
+
+// A method of ChatRoom that returns whether a peer publishes on the versioned
+// topic of the room, which it does if it told so in its handshake or if it
+// is subscribed to the versioned topic
+func (cr *ChatRoom) versioned(peerid peer.ID) bool {
+	if cr.Host.capable(peerid, versionedtopics) {
+		return true
+	}
+
+	for _, subscribed := range cr.pstopic.ListPeers() {
+		if subscribed == peerid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of ChatRoom that returns the older clients on the legacy topic of the room
+func (cr *ChatRoom) legacypeers() []peer.ID {
+	if cr.legacy == nil {
+		return nil
+	}
+
+	var peers []peer.ID
+	for _, peerid := range cr.legacy.topic.ListPeers() {
+		if !cr.versioned(peerid) {
+			peers = append(peers, peerid)
+		}
+	}
+
+	return peers
+}
+
+// A method of ChatRoom that publishes a ChatMessage to the topic of the room,
+// and to its legacy topic while older clients are subscribed to it
+func (cr *ChatRoom) publish(msg ChatMessage) error {
+	if err := publish(cr.psctx, cr.pstopic, msg); err != nil {
+		return err
+	}
+
+	if len(cr.legacypeers()) > 0 {
+		if err := publish(cr.psctx, cr.legacy.topic, msg); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  cr.RoomName,
+			}).Debugln("Failed to Publish to the Legacy Topic!")
+		}
+	}
+
+	return nil
+}
+
+// A method of ChatRoom that leaves the legacy topic of the room, if it was joined
+func (cr *ChatRoom) leavelegacy() {
+	if cr.legacy == nil {
+		return
+	}
+
+	cr.legacy.once.Do(func() {
+		cr.legacy.sub.Cancel()
+		cr.Host.LeaveTopic(cr.legacy.topic.String())
+	})
+}
+
+// A method of ChatRoom that waits until the legacy topic of the room is no longer read
+func (cr *ChatRoom) waitlegacy() {
+	if cr.legacy != nil {
+		<-cr.legacy.done
+	}
+}
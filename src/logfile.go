@@ -0,0 +1,143 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// Represents the default maximum size of a log file in megabytes
+const DefaultLogMaxSize = 10
+
+// Represents the default number of rotated log files that are kept
+const DefaultLogBackups = 3
+
+// A structure that represents a log file that is rotated when it grows
+// past a maximum size. The rotated files are named <path>.1 (the newest)
+// to <path>.<backups> (the oldest) and older files are removed.
+type LogFile struct {
+	// Represents the path of the log file
+	path string
+	// Represents the maximum size of the log file in bytes
+	maxsize int64
+	// Represents the number of rotated files that are kept
+	backups int
+
+	// Represents the open log file and its current size
+	file *os.File
+	size int64
+	// Represents the lock on the log file
+	mutex sync.Mutex
+}
+
+// A function that returns the path of the default log file (~/.peerchat/peerchat.log)
+func DefaultLogPath() string {
+	return peerchatpath("peerchat.log")
+}
+
+// A constructor function that opens (or creates) a log file at a path that is
+// rotated when it grows past maxsize megabytes, keeping a number of backups.
+func OpenLogFile(path string, maxsize int, backups int) (*LogFile, error) {
+	if maxsize <= 0 {
+		maxsize = DefaultLogMaxSize
+	}
+	if backups < 0 {
+		backups = 0
+	}
+
+	logfile := &LogFile{
+		path:    expandhome(path),
+		maxsize: int64(maxsize) * 1024 * 1024,
+		backups: backups,
+	}
+
+	if err := logfile.open(); err != nil {
+		return nil, err
+	}
+
+	return logfile, nil
+}
+
+// A method of LogFile that opens the log file for appending
+func (logfile *LogFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(logfile.path), 0700); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(logfile.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	logfile.file = file
+	logfile.size = info.Size()
+	return nil
+}
+
+// A method of LogFile that writes to the log file, rotating it first
+// if the write would grow it past its maximum size
+func (logfile *LogFile) Write(p []byte) (int, error) {
+	logfile.mutex.Lock()
+	defer logfile.mutex.Unlock()
+
+	if logfile.file == nil {
+		return 0, os.ErrClosed
+	}
+
+	if logfile.size > 0 && logfile.size+int64(len(p)) > logfile.maxsize {
+		if err := logfile.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := logfile.file.Write(p)
+	logfile.size += int64(n)
+	return n, err
+}
+
+// A method of LogFile that closes the log file
+func (logfile *LogFile) Close() error {
+	logfile.mutex.Lock()
+	defer logfile.mutex.Unlock()
+
+	if logfile.file == nil {
+		return nil
+	}
+
+	err := logfile.file.Close()
+	logfile.file = nil
+	return err
+}
+
+// A method of LogFile that shifts the rotated files, moves
+// the log file to <path>.1 and opens a new log file
+func (logfile *LogFile) rotate() error {
+	err := logfile.file.Close()
+	logfile.file = nil
+	if err != nil {
+		return err
+	}
+
+	// Remove the oldest file and shift the others
+	backup := func(n int) string { return fmt.Sprintf("%s.%d", logfile.path, n) }
+
+	if logfile.backups > 0 {
+		os.Remove(backup(logfile.backups))
+		for n := logfile.backups - 1; n >= 1; n-- {
+			os.Rename(backup(n), backup(n+1))
+		}
+
+		os.Rename(logfile.path, backup(1))
+	} else {
+		os.Remove(logfile.path)
+	}
+
+	return logfile.open()
+}
@@ -0,0 +1,440 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the timeout of the long polling sync requests to a Matrix homeserver
+const matrixsynctimeout = time.Second * 30
+
+// Represents the default name of a Matrix bridge in a chat room
+const defaultmatrixname = "matrix-bridge"
+
+// Represents the suffix added to the names of Matrix users in a chat room
+const matrixnamesuffix = "@matrix"
+
+// A structure that represents a bridge that relays messages between a chat room
+// and a Matrix room through a bot account. Room messages are sent to the Matrix
+// room attributed to their sender and Matrix messages are published into the
+// room with the display name of the sender suffixed by '@matrix'. Bold, italic,
+// code and links are translated between plain text and Matrix HTML.
+type matrixbridge struct {
+	// Represents the chat room that is bridged
+	Room string `json:"room"`
+	// Represents the base URL of the Matrix homeserver
+	Homeserver string `json:"homeserver"`
+	// Represents the access token of the bot account
+	Token string `json:"token"`
+	// Represents the ID or alias of the Matrix room that is bridged
+	MatrixRoom string `json:"matrixroom"`
+	// Represents the name of the bridge in the chat room (optional)
+	Name string `json:"name"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the HTTP client for the homeserver
+	client *http.Client
+	// Represents the user ID of the bot account
+	userid string
+	// Represents the resolved ID of the Matrix room
+	roomid string
+	// Represents the display names of the Matrix users
+	displaynames map[string]string
+	// Represents the lock on the room ID and the display names
+	mutex sync.Mutex
+	// Represents the counter of the transaction IDs
+	txn uint64
+}
+
+// A structure that represents an event of the Matrix client-server API
+type matrixevent struct {
+	Type     string          `json:"type"`
+	Sender   string          `json:"sender"`
+	StateKey *string         `json:"state_key"`
+	Content  json.RawMessage `json:"content"`
+	Unsigned struct {
+		PrevContent *matrixcontent `json:"prev_content"`
+	} `json:"unsigned"`
+}
+
+// A structure that represents the content of a Matrix message or member event
+type matrixcontent struct {
+	MsgType       string `json:"msgtype,omitempty"`
+	Body          string `json:"body,omitempty"`
+	Format        string `json:"format,omitempty"`
+	FormattedBody string `json:"formatted_body,omitempty"`
+	Membership    string `json:"membership,omitempty"`
+	DisplayName   string `json:"displayname,omitempty"`
+}
+
+// A structure that represents the response of a Matrix sync request
+type matrixsync struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			State struct {
+				Events []matrixevent `json:"events"`
+			} `json:"state"`
+			Timeline struct {
+				Events []matrixevent `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+// A method of matrixbridge that joins the chat room and starts relaying
+func (bridge *matrixbridge) start(p2phost *P2P) error {
+	if bridge.Homeserver == "" || bridge.Token == "" || bridge.MatrixRoom == "" {
+		return errors.New("matrix bridge requires a homeserver, token and matrixroom")
+	}
+
+	if bridge.Name == "" {
+		bridge.Name = defaultmatrixname
+	}
+
+	bridge.Homeserver = strings.TrimRight(bridge.Homeserver, "/")
+	bridge.client = &http.Client{Timeout: matrixsynctimeout * 2}
+	bridge.displaynames = make(map[string]string)
+
+	// Join the chat room with the name of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Name, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+
+	// Announce the peers joining and leaving the room
+	err = room.presence(func(name string, joined bool) {
+		verb := "left"
+		if joined {
+			verb = "joined"
+		}
+
+		bridge.send("m.notice", "", fmt.Sprintf("%s %s %s", name, verb, room.chatroom.RoomName))
+	})
+	if err != nil {
+		room.leave()
+		return err
+	}
+
+	// Relay the room messages to the Matrix room
+	go room.relay(func(msg ChatMessage) {
+		bridge.send("m.text", msg.SenderName, msg.Message)
+	})
+
+	go bridge.syncloop()
+	return nil
+}
+
+// A method of matrixbridge that stops relaying and exits the chat room
+func (bridge *matrixbridge) stop() {
+	bridge.room.leave()
+}
+
+// A method of matrixbridge that joins the Matrix room and relays its events
+// into the chat room, reconnecting on failures until the bridge is stopped
+func (bridge *matrixbridge) syncloop() {
+	since := ""
+
+	for {
+		var err error
+		since, err = bridge.sync(since)
+		if err == nil {
+			continue
+		}
+
+		// Exit if the bridge has been stopped
+		if bridge.room.ctx.Err() != nil {
+			return
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"error":      err.Error(),
+			"homeserver": bridge.Homeserver,
+		}).Warnln("Matrix Bridge Disconnected!")
+
+		select {
+		case <-bridge.room.ctx.Done():
+			return
+		case <-time.After(bridgereconnect):
+		}
+	}
+}
+
+// A method of matrixbridge that runs a single sync request since a batch token,
+// setting up the bot account first if needed. Returns the next batch token.
+func (bridge *matrixbridge) sync(since string) (string, error) {
+	// Resolve the bot account and join the Matrix room
+	bridge.mutex.Lock()
+	roomid := bridge.roomid
+	bridge.mutex.Unlock()
+
+	if roomid == "" {
+		var whoami struct {
+			UserID string `json:"user_id"`
+		}
+		if err := bridge.request(http.MethodGet, "/account/whoami", nil, &whoami); err != nil {
+			return since, err
+		}
+
+		var joined struct {
+			RoomID string `json:"room_id"`
+		}
+		if err := bridge.request(http.MethodPost, "/join/"+url.PathEscape(bridge.MatrixRoom), struct{}{}, &joined); err != nil {
+			return since, err
+		}
+
+		bridge.mutex.Lock()
+		bridge.userid, bridge.roomid, roomid = whoami.UserID, joined.RoomID, joined.RoomID
+		bridge.mutex.Unlock()
+
+		logrus.WithFields(logrus.Fields{
+			"homeserver": bridge.Homeserver,
+			"matrixroom": bridge.MatrixRoom,
+			"room":       bridge.room.chatroom.RoomName,
+		}).Infoln("Matrix Bridge Connected")
+	}
+
+	// Long poll for the next events
+	query := url.Values{}
+	query.Set("timeout", fmt.Sprint(matrixsynctimeout.Milliseconds()))
+	if since != "" {
+		query.Set("since", since)
+	}
+
+	var response matrixsync
+	if err := bridge.request(http.MethodGet, "/sync?"+query.Encode(), nil, &response); err != nil {
+		return since, err
+	}
+
+	if joined, ok := response.Rooms.Join[roomid]; ok {
+		for _, event := range joined.State.Events {
+			bridge.handle(event, false)
+		}
+
+		// Skip the timeline of the initial sync, which holds past messages
+		for _, event := range joined.Timeline.Events {
+			bridge.handle(event, since != "")
+		}
+	}
+
+	return response.NextBatch, nil
+}
+
+// A method of matrixbridge that handles an event of the Matrix room.
+// Messages and membership changes are relayed if relay is true.
+func (bridge *matrixbridge) handle(event matrixevent, relay bool) {
+	var content matrixcontent
+	if err := json.Unmarshal(event.Content, &content); err != nil {
+		return
+	}
+
+	bridge.mutex.Lock()
+	self := event.Sender == bridge.userid
+	bridge.mutex.Unlock()
+
+	switch event.Type {
+	case "m.room.member":
+		if event.StateKey == nil {
+			return
+		}
+
+		// Remember the display name of the member
+		bridge.mutex.Lock()
+		if content.DisplayName != "" {
+			bridge.displaynames[*event.StateKey] = content.DisplayName
+		}
+		bridge.mutex.Unlock()
+
+		if !relay || self {
+			return
+		}
+
+		// Check the previous membership to skip profile changes
+		previous := ""
+		if event.Unsigned.PrevContent != nil {
+			previous = event.Unsigned.PrevContent.Membership
+		}
+
+		name := bridge.displayname(*event.StateKey)
+		switch {
+		case content.Membership == "join" && previous != "join":
+			bridge.room.publish(bridge.Name, fmt.Sprintf("* %s joined the Matrix room", name))
+		case content.Membership == "leave" || content.Membership == "ban":
+			bridge.room.publish(bridge.Name, fmt.Sprintf("* %s left the Matrix room", name))
+		}
+
+	case "m.room.message":
+		if !relay || self {
+			return
+		}
+
+		// Prefer the formatted body if it is HTML
+		text := content.Body
+		if content.Format == "org.matrix.custom.html" && content.FormattedBody != "" {
+			text = matrixtotext(content.FormattedBody)
+		}
+
+		name := bridge.displayname(event.Sender)
+		switch content.MsgType {
+		case "m.text", "m.notice":
+			bridge.room.publish(name+matrixnamesuffix, text)
+		case "m.emote":
+			bridge.room.publish(name+matrixnamesuffix, fmt.Sprintf("* %s %s", name, text))
+		default:
+			// Relay attachments and other messages by their body
+			bridge.room.publish(name+matrixnamesuffix, content.Body)
+		}
+	}
+}
+
+// A method of matrixbridge that returns the display name of a Matrix user,
+// falling back to the localpart of the user ID (@name:server)
+func (bridge *matrixbridge) displayname(userid string) string {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	if name, ok := bridge.displaynames[userid]; ok {
+		return name
+	}
+
+	return strings.SplitN(strings.TrimPrefix(userid, "@"), ":", 2)[0]
+}
+
+// A method of matrixbridge that sends a message to the Matrix room,
+// attributed to a sender if given. The message is dropped if the
+// Matrix room has not been joined yet.
+func (bridge *matrixbridge) send(msgtype string, sender string, text string) {
+	bridge.mutex.Lock()
+	roomid := bridge.roomid
+	bridge.mutex.Unlock()
+
+	if roomid == "" {
+		return
+	}
+
+	content := matrixcontent{
+		MsgType:       msgtype,
+		Body:          text,
+		Format:        "org.matrix.custom.html",
+		FormattedBody: texttomatrix(text),
+	}
+
+	if sender != "" {
+		content.Body = fmt.Sprintf("<%s> %s", sender, text)
+		content.FormattedBody = fmt.Sprintf("<strong>%s</strong>: %s", html.EscapeString(sender), content.FormattedBody)
+	}
+
+	txn := fmt.Sprintf("peerchat-%d-%d", time.Now().UnixNano(), atomic.AddUint64(&bridge.txn, 1))
+	path := fmt.Sprintf("/rooms/%s/send/m.room.message/%s", url.PathEscape(roomid), txn)
+
+	if err := bridge.request(http.MethodPut, path, content, nil); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":      err.Error(),
+			"matrixroom": bridge.MatrixRoom,
+		}).Debugln("Failed to Send Matrix Message!")
+	}
+}
+
+// A method of matrixbridge that makes a request to the client-server API
+// of the homeserver and decodes the JSON response into out (if not nil)
+func (bridge *matrixbridge) request(method string, path string, body interface{}, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	request, err := http.NewRequestWithContext(bridge.room.ctx, method, bridge.Homeserver+"/_matrix/client/r0"+path, reader)
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Authorization", "Bearer "+bridge.Token)
+	if body != nil {
+		request.Header.Set("Content-Type", "application/json")
+	}
+
+	response, err := bridge.client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("homeserver responded with %s", response.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+
+	return json.NewDecoder(response.Body).Decode(out)
+}
+
+// Represents the patterns used to translate Matrix HTML into plain text
+var (
+	matrixreply  = regexp.MustCompile(`(?is)<mx-reply>.*?</mx-reply>`)
+	matrixbreak  = regexp.MustCompile(`(?i)<br\s*/?>|</p>`)
+	matrixbold   = regexp.MustCompile(`(?is)<(?:b|strong)>(.*?)</(?:b|strong)>`)
+	matrixitalic = regexp.MustCompile(`(?is)<(?:i|em)>(.*?)</(?:i|em)>`)
+	matrixcode   = regexp.MustCompile(`(?is)<code[^>]*>(.*?)</code>`)
+	matrixlink   = regexp.MustCompile(`(?is)<a [^>]*href="([^"]*)"[^>]*>(.*?)</a>`)
+	matrixtag    = regexp.MustCompile(`(?s)<[^>]+>`)
+)
+
+// Represents the patterns used to translate plain text into Matrix HTML
+var (
+	textbold   = regexp.MustCompile(`\*\*(.+?)\*\*`)
+	textitalic = regexp.MustCompile(`(^|[\s(])_(.+?)_($|[\s).,!?])`)
+	textcode   = regexp.MustCompile("`([^`]+)`")
+)
+
+// A function that translates the HTML body of a Matrix message into plain
+// text, keeping bold (**text**), italics (_text_), code (`text`) and links
+func matrixtotext(body string) string {
+	body = matrixreply.ReplaceAllString(body, "")
+	body = matrixbreak.ReplaceAllString(body, "\n")
+	body = matrixbold.ReplaceAllString(body, "**$1**")
+	body = matrixitalic.ReplaceAllString(body, "_${1}_")
+	body = matrixcode.ReplaceAllString(body, "`$1`")
+	body = matrixlink.ReplaceAllStringFunc(body, func(link string) string {
+		parts := matrixlink.FindStringSubmatch(link)
+		if matrixtag.ReplaceAllString(parts[2], "") == parts[1] {
+			return parts[1]
+		}
+
+		return fmt.Sprintf("%s (%s)", parts[2], parts[1])
+	})
+	body = matrixtag.ReplaceAllString(body, "")
+
+	return strings.TrimSpace(html.UnescapeString(body))
+}
+
+// A function that translates the plain text of a message into Matrix HTML,
+// converting bold (**text**), italics (_text_), code (`text`) and newlines
+func texttomatrix(text string) string {
+	text = html.EscapeString(text)
+	text = textcode.ReplaceAllString(text, "<code>$1</code>")
+	text = textbold.ReplaceAllString(text, "<strong>$1</strong>")
+	text = textitalic.ReplaceAllString(text, "$1<em>$2</em>$3")
+
+	return strings.ReplaceAll(text, "\n", "<br>")
+}
@@ -0,0 +1,174 @@
+package src
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+)
+
+// Represents the columns of the members table
+var membercolumns = []string{"Name", "Peer", "Status", "Latency", "Connection"}
+
+// A structure that represents a member of a room in the members table
+type member struct {
+	// Represents the user name of the member
+	name string
+	// Represents the short peer ID of the member
+	peer string
+	// Represents the connection status of the member
+	status string
+	// Represents the latency to the member (0 if unknown)
+	latency time.Duration
+	// Represents the transport and direction of the connection to the member
+	connection string
+}
+
+// A method of UI that collects the members of the current room, with
+// the user first. Members are peers subscribed to the room topic.
+func (ui *UI) members() []member {
+	members := []member{{name: ui.UserName, peer: ui.selfid.ShortString(), status: "you"}}
+
+	for _, peerid := range ui.PeerList() {
+		ui.stateLock.Lock()
+		name, ok := ui.nicks[peerid]
+		ui.stateLock.Unlock()
+		if alias, friend := friends.alias(peerid); friend {
+			name = alias
+		} else if !ok {
+			name = "?"
+		}
+
+		m := member{name: name, peer: peerid.ShortString(), status: "via daemon", connection: "-"}
+		if ui.Host != nil {
+			m.status = connectedness(ui.Host.Host.Network().Connectedness(peerid))
+			m.latency = ui.Host.Host.Peerstore().LatencyEWMA(peerid)
+			m.connection = connectiontype(ui.Host, peerid)
+		}
+
+		members = append(members, m)
+	}
+
+	return members
+}
+
+// A function that describes the connections to a peer by their transport
+// and direction, such as 'tcp out' or 'relay in'
+func connectiontype(p2p *P2P, peerid peer.ID) string {
+	var types []string
+	for _, conn := range p2p.Host.Network().ConnsToPeer(peerid) {
+		addr := conn.RemoteMultiaddr().String()
+
+		transport := "other"
+		switch {
+		case strings.Contains(addr, "/p2p-circuit"):
+			transport = "relay"
+		case strings.Contains(addr, "/quic"):
+			transport = "quic"
+		case strings.Contains(addr, "/ws"):
+			transport = "websocket"
+		case strings.Contains(addr, "/tcp"):
+			transport = "tcp"
+		}
+
+		direction := "out"
+		if conn.Stat().Direction == network.DirInbound {
+			direction = "in"
+		}
+
+		types = append(types, transport+" "+direction)
+	}
+
+	if len(types) == 0 {
+		return "-"
+	}
+
+	return strings.Join(types, ", ")
+}
+
+// A function that sorts members by a column of the members table
+func sortmembers(members []member, column int) {
+	sort.SliceStable(members, func(i, j int) bool {
+		a, b := members[i], members[j]
+		switch column {
+		case 1:
+			return a.peer < b.peer
+		case 2:
+			return a.status < b.status
+		case 3:
+			// Unknown latencies go last
+			if a.latency == 0 || b.latency == 0 {
+				return b.latency == 0 && a.latency != 0
+			}
+			return a.latency < b.latency
+		case 4:
+			return a.connection < b.connection
+		default:
+			return strings.ToLower(a.name) < strings.ToLower(b.name)
+		}
+	})
+}
+
+// A method of UI that shows the members of the current room in a popup table,
+// which is sorted by a column when its number is pressed.
+// Must be called from the tview event loop.
+func (ui *UI) showmembers(members []member) {
+	table := tview.NewTable().SetFixed(1, 0).SetSelectable(true, false)
+	table.SetBorder(true).SetTitle(fmt.Sprintf("Members of %s (1-5 to sort, Esc to close)", tview.Escape(ui.RoomName)))
+
+	render := func(column int) {
+		sortmembers(members, column)
+		table.Clear()
+
+		for index, name := range membercolumns {
+			if index == column {
+				name += " ▾"
+			}
+			table.SetCell(0, index, tview.NewTableCell(name).
+				SetTextColor(currentconfig().accent()).
+				SetSelectable(false).
+				SetExpansion(1))
+		}
+
+		for row, m := range members {
+			latency := "-"
+			if m.latency > 0 {
+				latency = m.latency.Round(time.Millisecond).String()
+			}
+
+			for index, text := range []string{m.name, m.peer, m.status, latency, m.connection} {
+				table.SetCell(row+1, index, tview.NewTableCell(tview.Escape(stripcontrols(text))))
+			}
+		}
+	}
+	render(0)
+
+	table.SetDoneFunc(func(key tcell.Key) {
+		ui.pages.RemovePage("members")
+		ui.TerminalApp.SetFocus(ui.inputBox)
+	})
+	table.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() >= '1' && int(event.Rune()-'1') < len(membercolumns) {
+			render(int(event.Rune() - '1'))
+			return nil
+		}
+		return event
+	})
+
+	// Center the table on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(table, 20, 1, true).
+			AddItem(nil, 0, 1, false), 90, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("members", popup, true, true)
+	ui.TerminalApp.SetFocus(table)
+}
@@ -0,0 +1,194 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	mqtt "github.com/eclipse/paho.mqtt.golang"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the default name of an MQTT bridge in a chat room
+const defaultmqttname = "mqtt-bridge"
+
+// Represents the default prefix of the chat commands that publish to MQTT
+const defaultmqttprefix = "!mqtt"
+
+// Represents the maximum length of a payload injected into a chat room
+const mqttmaxpayload = 512
+
+// A structure that represents a bridge that posts the payloads of MQTT topics
+// into a chat room and publishes chat commands of the form '!mqtt <topic> <payload>'
+// back to MQTT, so that home automation events can be followed and controlled from chat.
+type mqttbridge struct {
+	// Represents the chat room that is bridged
+	Room string `json:"room"`
+	// Represents the URL of the broker (tcp://, ssl:// or ws://)
+	Broker string `json:"broker"`
+	// Represents the client ID of the bridge (optional)
+	ClientID string `json:"clientid"`
+	// Represents the credentials of the bridge (optional)
+	Username string `json:"username"`
+	Password string `json:"password"`
+	// Represents the topic filters that are posted into the chat room
+	Topics []string `json:"topics"`
+	// Represents the prefix of the chat commands (optional)
+	Prefix string `json:"prefix"`
+	// Represents the QoS of the subscriptions and publications (optional)
+	QoS byte `json:"qos"`
+	// Represents the name of the bridge in the chat room (optional)
+	Name string `json:"name"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the MQTT client of the bridge
+	client mqtt.Client
+}
+
+// A method of mqttbridge that joins the chat room, connects to the broker and starts relaying
+func (bridge *mqttbridge) start(p2phost *P2P) error {
+	if bridge.Broker == "" {
+		return errors.New("mqtt bridge requires a broker")
+	}
+
+	if bridge.QoS > 2 {
+		return errors.New("mqtt qos must be 0, 1 or 2")
+	}
+
+	if bridge.Name == "" {
+		bridge.Name = defaultmqttname
+	}
+
+	if bridge.Prefix == "" {
+		bridge.Prefix = defaultmqttprefix
+	}
+
+	// Join the chat room with the name of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Name, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+
+	if bridge.ClientID == "" {
+		bridge.ClientID = "peerchat-" + room.chatroom.selfid.ShortString()
+	}
+
+	// Connect to the broker, subscribing to the topics on every (re)connection
+	options := mqtt.NewClientOptions().
+		AddBroker(bridge.Broker).
+		SetClientID(bridge.ClientID).
+		SetUsername(bridge.Username).
+		SetPassword(bridge.Password).
+		SetAutoReconnect(true).
+		SetConnectRetry(true).
+		SetConnectRetryInterval(bridgereconnect).
+		SetOnConnectHandler(bridge.subscribe).
+		SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"broker": bridge.Broker,
+			}).Warnln("MQTT Broker Disconnected!")
+		})
+
+	bridge.client = mqtt.NewClient(options)
+	bridge.client.Connect()
+
+	// Publish the chat commands to MQTT
+	go room.relay(bridge.command)
+
+	logrus.WithFields(logrus.Fields{
+		"broker": bridge.Broker,
+		"topics": strings.Join(bridge.Topics, ","),
+		"room":   room.chatroom.RoomName,
+	}).Infoln("MQTT Bridge Started")
+
+	return nil
+}
+
+// A method of mqttbridge that disconnects from the broker and exits the chat room
+func (bridge *mqttbridge) stop() {
+	bridge.room.cancel()
+	bridge.client.Disconnect(250)
+	bridge.room.leave()
+}
+
+// A method of mqttbridge that subscribes to the topics of the bridge
+func (bridge *mqttbridge) subscribe(client mqtt.Client) {
+	for _, topic := range bridge.Topics {
+		token := client.Subscribe(topic, bridge.QoS, bridge.inject)
+		if token.Wait() && token.Error() != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": token.Error().Error(),
+				"topic": topic,
+			}).Warnln("MQTT Subscription Failed!")
+		}
+	}
+}
+
+// A method of mqttbridge that posts the payload of an MQTT message into the chat room.
+// Retained messages are skipped since they describe past state rather than events.
+func (bridge *mqttbridge) inject(_ mqtt.Client, message mqtt.Message) {
+	if message.Retained() {
+		return
+	}
+
+	payload := message.Payload()
+	if !utf8.Valid(payload) {
+		bridge.room.publish(bridge.Name, fmt.Sprintf("%s: <%d bytes>", message.Topic(), len(payload)))
+		return
+	}
+
+	text := strings.TrimSpace(string(payload))
+	if len(text) > mqttmaxpayload {
+		text = text[:mqttmaxpayload] + "..."
+	}
+
+	bridge.room.publish(bridge.Name, fmt.Sprintf("%s: %s", message.Topic(), text))
+}
+
+// A method of mqttbridge that publishes a chat message
+// of the form '<prefix> <topic> <payload>' to MQTT
+func (bridge *mqttbridge) command(msg ChatMessage) {
+	fields := strings.SplitN(strings.TrimSpace(msg.Message), " ", 3)
+	if fields[0] != bridge.Prefix {
+		return
+	}
+
+	if len(fields) < 2 || fields[1] == "" {
+		bridge.room.publish(bridge.Name, fmt.Sprintf("usage: %s <topic> <payload>", bridge.Prefix))
+		return
+	}
+
+	topic, payload := fields[1], ""
+	if len(fields) == 3 {
+		payload = fields[2]
+	}
+
+	// Reject wildcards, which are only valid in topic filters
+	if strings.ContainsAny(topic, "+#") {
+		bridge.room.publish(bridge.Name, "cannot publish to a wildcard topic")
+		return
+	}
+
+	// Publish the payload, waiting a bounded time while the broker is unreachable
+	token := bridge.client.Publish(topic, bridge.QoS, false, payload)
+	var err error
+	if token.WaitTimeout(bridgereconnect) {
+		err = token.Error()
+	} else {
+		err = errors.New("publish timed out")
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"topic":  topic,
+			"sender": msg.SenderName,
+		}).Warnln("MQTT Publish Failed!")
+
+		bridge.room.publish(bridge.Name, fmt.Sprintf("failed to publish to %s", topic))
+	}
+}
@@ -0,0 +1,80 @@
+package src
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Represents the pattern of the names of networks
+var networkpattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// Represents the network the node has joined. It is kept apart from the
+// configuration, which can be reloaded, since it cannot change while the
+// rooms of the network are joined.
+var joinednetwork string
+
+// A function that checks the name of a network (empty for the global network)
+func validnetwork(name string) error {
+	if name != "" && !networkpattern.MatchString(name) {
+		return fmt.Errorf("invalid network name '%s' (letters, digits, '.', '_' and '-', at most 64)", name)
+	}
+
+	return nil
+}
+
+// A method of Config that sets the network of the node, overriding the
+// configuration file, and joins it. Nodes only discover and share rooms with
+// the nodes of the same network, and an empty name joins the global network.
+func (cfg *Config) SetNetwork(name string) error {
+	if err := validnetwork(name); err != nil {
+		return err
+	}
+
+	configlock.Lock()
+	cfg.Network = name
+	joinednetwork = name
+	configlock.Unlock()
+
+	return nil
+}
+
+// A function that returns the network the node has joined (empty for the global network)
+func networkname() string {
+	configlock.RLock()
+	defer configlock.RUnlock()
+
+	return joinednetwork
+}
+
+// A function that returns the namespace under which the nodes of
+// the network advertise themselves in the DHT and at trackers
+func servicename() string {
+	if network := networkname(); network != "" {
+		return service + "/" + network
+	}
+
+	return service
+}
+
+// A function that returns the prefix of the PubSub topics of the rooms of the
+// network, which is namespaced to the application and versioned so that the
+// topics never collide with those of other libp2p applications. The prefixes
+// of the global network and of named networks differ, and network names
+// cannot contain '/', so the topics of networks never collide either.
+func topicprefix() string {
+	if network := networkname(); network != "" {
+		return fmt.Sprintf("/peerchat/%d/network/%s/room/", topicversion, network)
+	}
+
+	return fmt.Sprintf("/peerchat/%d/room/", topicversion)
+}
+
+// A function that returns the prefix of the legacy PubSub topics of the
+// rooms of the network, which older clients still publish on
+func legacyprefix() string {
+	if network := networkname(); network != "" {
+		return fmt.Sprintf("peerchat-network-%s/room-", network)
+	}
+
+	return "room-peerchat-"
+}
@@ -0,0 +1,408 @@
+package src
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the kind of nostr text notes
+const nostrtextnote = 1
+
+// Represents the number of event IDs remembered to skip duplicates across relays
+const nostrseenlimit = 1024
+
+// Represents the default name of a nostr bridge in a chat room
+const defaultnostrname = "nostr-bridge"
+
+// Represents the suffix added to the names of nostr authors in a chat room
+const nostrnamesuffix = "@nostr"
+
+// A structure that represents a bridge that publishes the messages of a chat
+// room as nostr text notes to relays and injects notes back into the room.
+// Notes are tagged with a hashtag ('peerchat-<room>' by default) and the notes
+// with that hashtag or that reply to the bridge are published into the room.
+type nostrbridge struct {
+	// Represents the chat room that is bridged
+	Room string `json:"room"`
+	// Represents the websocket URLs of the relays
+	Relays []string `json:"relays"`
+	// Represents the hex secret key of the bridge (generated and saved if empty)
+	Key string `json:"key"`
+	// Represents the hashtag of the notes of the room (optional)
+	Tag string `json:"tag"`
+	// Represents the name of the bridge in the chat room (optional)
+	Name string `json:"name"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the secret key of the bridge
+	secret []byte
+	// Represents the hex x-only public key of the bridge
+	pubkey string
+	// Represents the connections to the relays
+	conns map[string]*websocket.Conn
+	// Represents the IDs of the recently seen events and their order
+	seen      map[string]bool
+	seenorder []string
+	// Represents the lock on the connections and the seen events
+	mutex sync.Mutex
+}
+
+// A structure that represents a nostr event (NIP-01)
+type nostrevent struct {
+	ID        string     `json:"id"`
+	PubKey    string     `json:"pubkey"`
+	CreatedAt int64      `json:"created_at"`
+	Kind      int        `json:"kind"`
+	Tags      [][]string `json:"tags"`
+	Content   string     `json:"content"`
+	Sig       string     `json:"sig"`
+}
+
+// A method of nostrbridge that joins the chat room and starts relaying
+func (bridge *nostrbridge) start(p2phost *P2P) error {
+	if len(bridge.Relays) == 0 {
+		return errors.New("nostr bridge requires at least one relay")
+	}
+
+	if bridge.Name == "" {
+		bridge.Name = defaultnostrname
+	}
+
+	// Load the secret key of the bridge
+	secret, err := nostrkey(bridge.Key)
+	if err != nil {
+		return err
+	}
+
+	pubkey, err := schnorrpubkey(secret)
+	if err != nil {
+		return err
+	}
+
+	bridge.secret = secret
+	bridge.pubkey = hex.EncodeToString(pubkey)
+	bridge.conns = make(map[string]*websocket.Conn)
+	bridge.seen = make(map[string]bool)
+
+	// Join the chat room with the name of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Name, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+
+	if bridge.Tag == "" {
+		bridge.Tag = "peerchat-" + room.chatroom.RoomName
+	}
+
+	// Publish the room messages as notes
+	go room.relay(func(msg ChatMessage) {
+		bridge.publish(fmt.Sprintf("<%s> %s", msg.SenderName, msg.Message))
+	})
+
+	for _, relay := range bridge.Relays {
+		go bridge.relayloop(relay)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"pubkey": bridge.pubkey,
+		"tag":    bridge.Tag,
+		"room":   room.chatroom.RoomName,
+	}).Infoln("Nostr Bridge Started")
+
+	return nil
+}
+
+// A method of nostrbridge that stops relaying, closes the relay connections and exits the chat room
+func (bridge *nostrbridge) stop() {
+	bridge.room.cancel()
+
+	bridge.mutex.Lock()
+	for _, conn := range bridge.conns {
+		conn.Close()
+	}
+	bridge.mutex.Unlock()
+
+	bridge.room.leave()
+}
+
+// A method of nostrbridge that keeps a connection to a relay
+// and reconnects to it until the bridge is stopped
+func (bridge *nostrbridge) relayloop(relay string) {
+	for {
+		if err := bridge.session(relay); err != nil && bridge.room.ctx.Err() == nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"relay": relay,
+			}).Warnln("Nostr Relay Disconnected!")
+		}
+
+		select {
+		case <-bridge.room.ctx.Done():
+			return
+		case <-time.After(bridgereconnect):
+		}
+	}
+}
+
+// A method of nostrbridge that connects to a relay, subscribes to the notes
+// of the room and injects them into the room until the connection is closed
+func (bridge *nostrbridge) session(relay string) error {
+	// Connect to the relay
+	conn, _, err := websocket.DefaultDialer.DialContext(bridge.room.ctx, relay, nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	bridge.mutex.Lock()
+	bridge.conns[relay] = conn
+	bridge.mutex.Unlock()
+
+	defer func() {
+		bridge.mutex.Lock()
+		delete(bridge.conns, relay)
+		bridge.mutex.Unlock()
+	}()
+
+	// Subscribe to the notes with the tag of the room and the replies to the bridge
+	since := time.Now().Unix()
+	err = bridge.write(conn, []interface{}{"REQ", "peerchat",
+		map[string]interface{}{"kinds": []int{nostrtextnote}, "#t": []string{bridge.Tag}, "since": since},
+		map[string]interface{}{"kinds": []int{nostrtextnote}, "#p": []string{bridge.pubkey}, "since": since},
+	})
+	if err != nil {
+		return err
+	}
+
+	for {
+		// Read the next relay message
+		var message []json.RawMessage
+		if err := conn.ReadJSON(&message); err != nil {
+			return err
+		}
+
+		if len(message) < 3 {
+			continue
+		}
+
+		var label string
+		json.Unmarshal(message[0], &label)
+		if label != "EVENT" {
+			continue
+		}
+
+		var event nostrevent
+		if err := json.Unmarshal(message[2], &event); err != nil {
+			continue
+		}
+
+		// Skip the notes of the bridge, forgeries and duplicates from other relays
+		if event.PubKey == bridge.pubkey || event.Kind != nostrtextnote || !event.verify() || !bridge.first(event.ID) {
+			continue
+		}
+
+		bridge.room.publish(event.PubKey[:8]+nostrnamesuffix, event.Content)
+	}
+}
+
+// A method of nostrbridge that signs a note and publishes it to the connected relays
+func (bridge *nostrbridge) publish(content string) {
+	event := nostrevent{
+		PubKey:    bridge.pubkey,
+		CreatedAt: time.Now().Unix(),
+		Kind:      nostrtextnote,
+		Tags:      [][]string{{"t", bridge.Tag}},
+		Content:   content,
+	}
+
+	if err := event.sign(bridge.secret); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Debugln("Failed to Sign Nostr Event!")
+		return
+	}
+
+	bridge.mutex.Lock()
+	conns := make(map[string]*websocket.Conn, len(bridge.conns))
+	for relay, conn := range bridge.conns {
+		conns[relay] = conn
+	}
+	bridge.mutex.Unlock()
+
+	for relay, conn := range conns {
+		if err := bridge.write(conn, []interface{}{"EVENT", event}); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"relay": relay,
+			}).Debugln("Failed to Publish Nostr Event!")
+		}
+	}
+}
+
+// A method of nostrbridge that writes a message to a relay connection.
+// Writes are serialized since websocket connections allow a single writer.
+func (bridge *nostrbridge) write(conn *websocket.Conn, message interface{}) error {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	return conn.WriteJSON(message)
+}
+
+// A method of nostrbridge that checks if an event is seen
+// for the first time and remembers the recently seen events
+func (bridge *nostrbridge) first(id string) bool {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	if bridge.seen[id] {
+		return false
+	}
+
+	bridge.seen[id] = true
+	bridge.seenorder = append(bridge.seenorder, id)
+
+	// Forget the oldest events
+	if len(bridge.seenorder) > nostrseenlimit {
+		delete(bridge.seen, bridge.seenorder[0])
+		bridge.seenorder = bridge.seenorder[1:]
+	}
+
+	return true
+}
+
+// A method of nostrevent that computes its ID, the hash of its serialization
+func (event *nostrevent) hash() []byte {
+	tags := make([]string, 0, len(event.Tags))
+	for _, tag := range event.Tags {
+		values := make([]string, 0, len(tag))
+		for _, value := range tag {
+			values = append(values, nostrstring(value))
+		}
+		tags = append(tags, "["+strings.Join(values, ",")+"]")
+	}
+
+	serialized := fmt.Sprintf("[0,%s,%d,%d,[%s],%s]",
+		nostrstring(event.PubKey), event.CreatedAt, event.Kind, strings.Join(tags, ","), nostrstring(event.Content))
+
+	hash := sha256.Sum256([]byte(serialized))
+	return hash[:]
+}
+
+// A method of nostrevent that sets its ID and signature
+func (event *nostrevent) sign(secret []byte) error {
+	id := event.hash()
+
+	signature, err := schnorrsign(secret, id)
+	if err != nil {
+		return err
+	}
+
+	event.ID = hex.EncodeToString(id)
+	event.Sig = hex.EncodeToString(signature)
+	return nil
+}
+
+// A method of nostrevent that checks its ID and signature
+func (event *nostrevent) verify() bool {
+	id := event.hash()
+	if hex.EncodeToString(id) != event.ID {
+		return false
+	}
+
+	pubkey, err := hex.DecodeString(event.PubKey)
+	if err != nil {
+		return false
+	}
+
+	signature, err := hex.DecodeString(event.Sig)
+	if err != nil {
+		return false
+	}
+
+	return schnorrverify(pubkey, id, signature)
+}
+
+// A function that encodes a string as JSON with the escaping of NIP-01
+func nostrstring(value string) string {
+	var builder strings.Builder
+	builder.WriteByte('"')
+
+	for _, r := range value {
+		switch r {
+		case '\n':
+			builder.WriteString(`\n`)
+		case '"':
+			builder.WriteString(`\"`)
+		case '\\':
+			builder.WriteString(`\\`)
+		case '\r':
+			builder.WriteString(`\r`)
+		case '\t':
+			builder.WriteString(`\t`)
+		case '\b':
+			builder.WriteString(`\b`)
+		case '\f':
+			builder.WriteString(`\f`)
+		default:
+			builder.WriteRune(r)
+		}
+	}
+
+	builder.WriteByte('"')
+	return builder.String()
+}
+
+// A function that returns the secret key of a nostr bridge from its hex
+// encoding or, if empty, from the key file (~/.peerchat/nostr.key), which
+// is created with a new random key if it does not exist
+func nostrkey(encoded string) ([]byte, error) {
+	if encoded == "" {
+		path := peerchatpath("nostr.key")
+
+		data, err := ioutil.ReadFile(path)
+		switch {
+		case err == nil:
+			encoded = strings.TrimSpace(string(data))
+
+		case os.IsNotExist(err):
+			// Generate and save a new key
+			secret := make([]byte, 32)
+			if _, err := rand.Read(secret); err != nil {
+				return nil, err
+			}
+
+			encoded = hex.EncodeToString(secret)
+			if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+				return nil, err
+			}
+			if err := ioutil.WriteFile(path, []byte(encoded+"\n"), 0600); err != nil {
+				return nil, err
+			}
+
+		default:
+			return nil, err
+		}
+	}
+
+	secret, err := hex.DecodeString(encoded)
+	if err != nil || len(secret) != 32 {
+		return nil, errors.New("nostr key must be 32 hex encoded bytes")
+	}
+
+	return secret, nil
+}
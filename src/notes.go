@@ -0,0 +1,577 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the limits of the notes of a room: the number of lines kept
+// (including the deleted lines), the number of lines kept of each peer
+// and the length of a line
+const (
+	maxnotelines     = 1000
+	maxpeernotelines = 250
+	maxnotelength    = 256
+)
+
+// Represents how far ahead of the clock of the notes the clock of a line may be.
+// Lines that jump further are dropped, so that the clock cannot be wrapped around.
+const maxnoteclockjump = 1 << 32
+
+// Represents the time for which the notes are not sent again to peers that ask for them
+const notessyncinterval = 30 * time.Second
+
+// Represents the number of lines of the notes sent in a single message
+const notesbatch = 100
+
+// Represents the time for which a joined room waits for peers to ask for the notes
+const notessynctimeout = time.Minute
+
+// A structure that represents the identifier of a line of the notes, and the
+// version of its text. The clock is a Lamport clock of the notes of the room
+// and the peer breaks ties, so that identifiers are unique and ordered.
+type noteid struct {
+	Clock uint64 `json:"clock"`
+	Peer  string `json:"peer"`
+}
+
+// A structure that represents a line of the notes of a room. The lines form a
+// replicated growable array: each line is inserted after another line (or at
+// the start) and never moves. Its text is a last-writer-wins register and its
+// deletion is a tombstone, so merging the lines of peers in any order converges.
+type noteline struct {
+	// Represents the identifier of the line
+	ID noteid `json:"id"`
+	// Represents the line that the line was inserted after (zero for the start)
+	After noteid `json:"after"`
+	// Represents the text of the line and the version it was written at
+	Text    string `json:"text"`
+	Version noteid `json:"version"`
+	// Represents whether the line was deleted
+	Deleted bool `json:"deleted,omitempty"`
+}
+
+// A structure that represents a message on the notes topic of a room, which
+// carries lines of the notes, or asks the peers for all the lines they have
+type notesmessage struct {
+	Lines []noteline `json:"lines,omitempty"`
+	Sync  bool       `json:"sync,omitempty"`
+}
+
+// A structure that represents the shared notes of a room, which are
+// synchronized with the peers of the room over a dedicated topic
+type roomnotes struct {
+	// Represents the notes topic of the room and the subscription to it
+	topic Topic
+	sub   Subscription
+	// Represents the lines of the notes by identifier
+	lines map[noteid]*noteline
+	// Represents the Lamport clock of the notes
+	clock uint64
+	// Represents the time the notes were last sent to the peers that asked for them
+	lastsync time.Time
+	// Represents the lock on the lines
+	lock sync.Mutex
+	// Represents the guard that leaves the notes topic once
+	once sync.Once
+}
+
+// A method of noteid that returns whether the identifier is ordered after another
+func (id noteid) newer(other noteid) bool {
+	if id.Clock != other.Clock {
+		return id.Clock > other.Clock
+	}
+
+	return id.Peer > other.Peer
+}
+
+// A function that returns the PubSub topic name of the notes of a chat room,
+// which sits next to the topic of the room and is hashed in the same way
+func notestopic(roomname string) string {
+	prefix := strings.Replace(topicprefix(), "/room/", "/notes/", 1)
+	if hashingtopics() {
+		return prefix + "h-" + hashroom(roomname)
+	}
+
+	return prefix + roomname
+}
+
+// A function that returns the path of the local copy of the notes of a room
+func notespath(roomname string) string {
+	return peerchatpath("notes", transcriptname(roomname)+".json")
+}
+
+// A method of roomnotes that checks if a clock is within reach of the clock of the notes.
+// Must be called with the lock held.
+func (notes *roomnotes) reachable(clock uint64) bool {
+	return clock <= notes.clock || clock-notes.clock <= maxnoteclockjump
+}
+
+// A method of roomnotes that makes room for a new line if it is kept under the
+// limits. Each peer keeps its oldest maxpeernotelines lines, so the lines that are
+// kept do not depend on the order in which they arrive, and a single peer cannot
+// fill the notes. Returns false if the line is not kept. Must be called with the lock held.
+func (notes *roomnotes) admit(id noteid) bool {
+	var count int
+	var newest *noteline
+	for _, line := range notes.lines {
+		if line.ID.Peer != id.Peer {
+			continue
+		}
+
+		count++
+		if newest == nil || line.ID.newer(newest.ID) {
+			newest = line
+		}
+	}
+
+	if count >= maxpeernotelines {
+		if !newest.ID.newer(id) {
+			return false
+		}
+
+		delete(notes.lines, newest.ID)
+		return true
+	}
+
+	return len(notes.lines) < maxnotelines
+}
+
+// A method of roomnotes that merges a line into the notes. Returns whether the
+// notes changed. Must be called with the lock held.
+func (notes *roomnotes) merge(line noteline) bool {
+	if !notes.reachable(line.ID.Clock) || !notes.reachable(line.Version.Clock) {
+		return false
+	}
+
+	if line.Version.Clock > notes.clock {
+		notes.clock = line.Version.Clock
+	}
+	if line.ID.Clock > notes.clock {
+		notes.clock = line.ID.Clock
+	}
+
+	current, ok := notes.lines[line.ID]
+	if !ok {
+		if !notes.admit(line.ID) {
+			return false
+		}
+
+		copied := line
+		notes.lines[line.ID] = &copied
+		return true
+	}
+
+	changed := false
+	if line.Version.newer(current.Version) {
+		current.Text, current.Version = line.Text, line.Version
+		changed = true
+	}
+	if line.Deleted && !current.Deleted {
+		current.Deleted = true
+		changed = true
+	}
+
+	return changed
+}
+
+// A method of roomnotes that returns the lines of the notes in the order of the
+// document, including the deleted lines. The lines after each line are ordered
+// newest first, so a line inserted after another shows right below it.
+// Must be called with the lock held.
+func (notes *roomnotes) ordered() []*noteline {
+	children := make(map[noteid][]*noteline)
+	for _, line := range notes.lines {
+		children[line.After] = append(children[line.After], line)
+	}
+	for _, siblings := range children {
+		sort.Slice(siblings, func(i, j int) bool {
+			return siblings[i].ID.newer(siblings[j].ID)
+		})
+	}
+
+	var document []*noteline
+	var walk func(parent noteid)
+	walk = func(parent noteid) {
+		for _, line := range children[parent] {
+			document = append(document, line)
+			walk(line.ID)
+		}
+	}
+	walk(noteid{})
+
+	return document
+}
+
+// A method of roomnotes that returns the lines of the notes that are not deleted.
+// Must be called with the lock held.
+func (notes *roomnotes) visible() []*noteline {
+	var lines []*noteline
+	for _, line := range notes.ordered() {
+		if !line.Deleted {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines
+}
+
+// A method of roomnotes that returns all the lines of the notes.
+// Must be called with the lock held.
+func (notes *roomnotes) all() []noteline {
+	lines := make([]noteline, 0, len(notes.lines))
+	for _, line := range notes.lines {
+		lines = append(lines, *line)
+	}
+
+	return lines
+}
+
+// A method of roomnotes that loads the local copy of the notes of a room
+func (notes *roomnotes) load(roomname string) {
+	data, err := ioutil.ReadFile(notespath(roomname))
+	if err != nil {
+		return
+	}
+
+	var lines []noteline
+	if err := json.Unmarshal(data, &lines); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Debugln("Failed to Parse the Notes of the Room!")
+		return
+	}
+
+	notes.lock.Lock()
+	defer notes.lock.Unlock()
+
+	for _, line := range lines {
+		notes.merge(line)
+	}
+}
+
+// A method of roomnotes that saves the local copy of the notes of a room.
+// Must be called with the lock held.
+func (notes *roomnotes) save(roomname string) {
+	data, err := json.Marshal(notes.all())
+	if err == nil {
+		path := notespath(roomname)
+		if err = os.MkdirAll(filepath.Dir(path), 0700); err == nil {
+			err = ioutil.WriteFile(path, data, 0600)
+		}
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Debugln("Failed to Save the Notes of the Room!")
+	}
+}
+
+// A method of ChatRoom that joins the notes topic of the room, loading the local
+// copy of the notes and asking the peers for theirs. The room works without
+// the notes if the topic cannot be joined.
+func (cr *ChatRoom) joinnotes() {
+	name := notestopic(cr.RoomName)
+	topic, err := cr.Host.JoinTopic(name)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Warnln("Failed to Join the Notes Topic!")
+		return
+	}
+
+	sub, err := topic.Subscribe()
+	if err != nil {
+		cr.Host.LeaveTopic(name)
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  cr.RoomName,
+		}).Warnln("Failed to Join the Notes Topic!")
+		return
+	}
+
+	cr.notes = &roomnotes{topic: topic, sub: sub, lines: make(map[noteid]*noteline)}
+	cr.notes.load(cr.RoomName)
+
+	go cr.notesloop()
+	go cr.syncnotes()
+}
+
+// A method of ChatRoom that asks the peers of the room for their notes once
+// the notes topic has peers, giving up if it has none for a while
+func (cr *ChatRoom) syncnotes() {
+	ticker := time.NewTicker(pendinginterval)
+	defer ticker.Stop()
+	timeout := time.After(notessynctimeout)
+
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+			if len(cr.notes.topic.ListPeers()) == 0 {
+				continue
+			}
+
+			cr.publishnotes(notesmessage{Sync: true})
+			return
+		}
+	}
+}
+
+// A method of ChatRoom that publishes a message on the notes topic of the room
+func (cr *ChatRoom) publishnotes(msg notesmessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return cr.notes.topic.Publish(cr.psctx, data)
+}
+
+// A method of roomnotes that checks if the notes should be sent to a peer that asks
+// for them. They are sent at most once every notessyncinterval, since every member
+// answers and the notes of one of them are enough.
+func (notes *roomnotes) answersync() bool {
+	notes.lock.Lock()
+	defer notes.lock.Unlock()
+
+	if time.Since(notes.lastsync) < notessyncinterval {
+		return false
+	}
+
+	notes.lastsync = time.Now()
+	return true
+}
+
+// A method of ChatRoom that publishes all the lines of the notes of the room in batches
+func (cr *ChatRoom) sendnotes() {
+	cr.notes.lock.Lock()
+	lines := cr.notes.all()
+	cr.notes.lock.Unlock()
+
+	for start := 0; start < len(lines); start += notesbatch {
+		end := start + notesbatch
+		if end > len(lines) {
+			end = len(lines)
+		}
+
+		if err := cr.publishnotes(notesmessage{Lines: lines[start:end]}); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  cr.RoomName,
+			}).Debugln("Failed to Send the Notes of the Room!")
+			return
+		}
+	}
+}
+
+// A method of ChatRoom that reads the notes topic of the room until the
+// subscription or the room is closed, merging the lines of the peers
+// into the notes and sending the notes to the peers that ask for them
+func (cr *ChatRoom) notesloop() {
+	for {
+		message, err := cr.notes.sub.Next(cr.psctx)
+		if err != nil {
+			return
+		}
+		if message.ReceivedFrom == cr.selfid {
+			continue
+		}
+
+		var msg notesmessage
+		if err := json.Unmarshal(message.Data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Sync && cr.notes.answersync() {
+			go cr.sendnotes()
+		}
+
+		changed := false
+		cr.notes.lock.Lock()
+		for _, line := range msg.Lines {
+			if len(line.Text) > maxnotelength {
+				continue
+			}
+			if _, err := peer.Decode(line.ID.Peer); err != nil {
+				continue
+			}
+			changed = cr.notes.merge(line) || changed
+		}
+		if changed {
+			cr.notes.save(cr.RoomName)
+		}
+		cr.notes.lock.Unlock()
+
+		if changed {
+			sender, _ := peer.IDFromBytes(message.GetFrom())
+			cr.log(logrus.InfoLevel, "notes", fmt.Sprintf("the notes were updated by %s, see /notes", sender.ShortString()))
+		}
+	}
+}
+
+// A method of ChatRoom that returns the lines of the shared notes of the room
+func (cr *ChatRoom) Notes() ([]string, error) {
+	if cr.notes == nil {
+		return nil, errors.New("the notes are not available in this room")
+	}
+
+	cr.notes.lock.Lock()
+	defer cr.notes.lock.Unlock()
+
+	var lines []string
+	for _, line := range cr.notes.visible() {
+		lines = append(lines, line.Text)
+	}
+
+	return lines, nil
+}
+
+// A method of ChatRoom that edits the shared notes of the room and publishes the
+// edited line to the peers. The action is one of 'add' (append a line), 'insert'
+// (insert a line before a line), 'set' (replace the text of a line) or 'del'
+// (delete a line). Lines are numbered from 1.
+func (cr *ChatRoom) EditNotes(action string, number int, text string) error {
+	if cr.notes == nil {
+		return errors.New("the notes are not available in this room")
+	}
+	if len(text) > maxnotelength {
+		return fmt.Errorf("the line is too long (%d bytes, at most %d)", len(text), maxnotelength)
+	}
+
+	cr.notes.lock.Lock()
+	visible := cr.notes.visible()
+
+	// A line can be inserted before any line or after the last one
+	last := len(visible)
+	if action == "insert" {
+		last++
+	}
+	if action != "add" && (number < 1 || number > last) {
+		cr.notes.lock.Unlock()
+		return fmt.Errorf("there is no line %d in the notes", number)
+	}
+
+	if cr.notes.clock == math.MaxUint64 {
+		cr.notes.lock.Unlock()
+		return errors.New("the clock of the notes has run out")
+	}
+
+	cr.notes.clock++
+	stamp := noteid{Clock: cr.notes.clock, Peer: cr.selfid.Pretty()}
+
+	var line noteline
+	switch action {
+	case "add", "insert":
+		if action == "add" {
+			number = len(visible) + 1
+		}
+		line = noteline{ID: stamp, Text: text, Version: stamp}
+		if number > 1 {
+			line.After = visible[number-2].ID
+		}
+
+	case "set":
+		line = *visible[number-1]
+		line.Text, line.Version = text, stamp
+
+	case "del":
+		line = *visible[number-1]
+		line.Deleted = true
+
+	default:
+		cr.notes.lock.Unlock()
+		return fmt.Errorf("unknown notes action '%s'", action)
+	}
+
+	if !cr.notes.merge(line) {
+		cr.notes.lock.Unlock()
+		return fmt.Errorf("the notes are full (%d lines, %d of each peer)", maxnotelines, maxpeernotelines)
+	}
+	cr.notes.save(cr.RoomName)
+	cr.notes.lock.Unlock()
+
+	return cr.publishnotes(notesmessage{Lines: []noteline{line}})
+}
+
+// A method of ChatRoom that leaves the notes topic of the room, if it was joined
+func (cr *ChatRoom) leavenotes() {
+	if cr.notes == nil {
+		return
+	}
+
+	cr.notes.once.Do(func() {
+		cr.notes.sub.Cancel()
+		cr.Host.LeaveTopic(cr.notes.topic.String())
+	})
+}
+
+// A function that returns the text of the notes of a room with numbered lines
+func rendernotes(lines []string) string {
+	if len(lines) == 0 {
+		return "The notes are empty. Add a line with /notes add <text>."
+	}
+
+	accent := fmt.Sprintf("#%06x", currentconfig().accent().Hex())
+
+	var builder strings.Builder
+	for index, line := range lines {
+		fmt.Fprintf(&builder, "[%s]%3d[-]  %s\n", accent, index+1, tview.Escape(stripcontrols(line)))
+	}
+
+	return builder.String()
+}
+
+// A method of UI that shows the shared notes of the current room.
+// Must be called from the tview event loop.
+func (ui *UI) shownotes(lines []string) {
+	view := tview.NewTextView().SetDynamicColors(true).SetScrollable(true)
+	view.SetBorder(true).SetTitle(fmt.Sprintf("Notes of %s (r to refresh, Esc to close)", tview.Escape(ui.RoomName)))
+	view.SetText(rendernotes(lines))
+
+	view.SetDoneFunc(func(key tcell.Key) {
+		ui.pages.RemovePage("notes")
+		ui.TerminalApp.SetFocus(ui.inputBox)
+	})
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if event.Rune() == 'r' {
+			lines, _ := ui.Notes()
+			view.SetText(rendernotes(lines))
+			return nil
+		}
+		return event
+	})
+
+	// Center the notes on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, 30, 1, true).
+			AddItem(nil, 0, 1, false), 100, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("notes", popup, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
@@ -0,0 +1,113 @@
+package src
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+)
+
+// A function that returns new empty notes for a test
+func newtestnotes() *roomnotes {
+	return &roomnotes{lines: make(map[noteid]*noteline)}
+}
+
+// A function that returns the lines of notes as a set of identifiers and texts
+func notesstate(notes *roomnotes) map[noteid]noteline {
+	state := make(map[noteid]noteline)
+	for id, line := range notes.lines {
+		state[id] = *line
+	}
+
+	return state
+}
+
+func TestNotesClockJump(t *testing.T) {
+	notes := newtestnotes()
+	notes.merge(noteline{ID: noteid{Clock: 1, Peer: "alice"}, Version: noteid{Clock: 1, Peer: "alice"}})
+
+	// A line far ahead of the clock is dropped and leaves the clock alone
+	far := noteid{Clock: math.MaxUint64, Peer: "mallory"}
+	if notes.merge(noteline{ID: far, Version: far}) {
+		t.Fatal("merged a line with a clock that jumps too far")
+	}
+	if notes.clock != 1 {
+		t.Fatalf("the clock is %d after a dropped line, want 1", notes.clock)
+	}
+
+	// So is a version far ahead of the clock on a known line
+	if notes.merge(noteline{ID: noteid{Clock: 1, Peer: "alice"}, Text: "defaced", Version: far}) {
+		t.Fatal("merged a version with a clock that jumps too far")
+	}
+
+	near := noteid{Clock: 1 + maxnoteclockjump, Peer: "bob"}
+	if !notes.merge(noteline{ID: near, Version: near}) {
+		t.Fatal("dropped a line within reach of the clock")
+	}
+}
+
+func TestNotesPeerLimit(t *testing.T) {
+	var lines []noteline
+	for clock := uint64(1); clock <= 2*maxpeernotelines; clock++ {
+		id := noteid{Clock: clock, Peer: "mallory"}
+		lines = append(lines, noteline{ID: id, Version: id, Deleted: true})
+	}
+	alice := noteid{Clock: 7, Peer: "alice"}
+	lines = append(lines, noteline{ID: alice, Text: "kept", Version: alice})
+
+	// The same lines are kept whatever order they arrive in
+	var first map[noteid]noteline
+	for round := 0; round < 5; round++ {
+		rand.Shuffle(len(lines), func(i, j int) { lines[i], lines[j] = lines[j], lines[i] })
+
+		notes := newtestnotes()
+		for _, line := range lines {
+			notes.merge(line)
+		}
+
+		state := notesstate(notes)
+		if len(state) != maxpeernotelines+1 {
+			t.Fatalf("kept %d lines, want %d", len(state), maxpeernotelines+1)
+		}
+		if _, ok := state[alice]; !ok {
+			t.Fatal("the line of another peer was not kept")
+		}
+		for id := range state {
+			if id.Peer == "mallory" && id.Clock > maxpeernotelines {
+				t.Fatalf("kept the line %v, want only the oldest lines of the peer", id)
+			}
+		}
+
+		if first == nil {
+			first = state
+		} else if !reflect.DeepEqual(first, state) {
+			t.Fatal("the kept lines depend on the order they arrived in")
+		}
+	}
+}
+
+func TestNotesLimit(t *testing.T) {
+	notes := newtestnotes()
+	for index := 0; index < maxnotelines; index++ {
+		id := noteid{Clock: uint64(index + 1), Peer: fmt.Sprintf("peer-%d", index%(maxnotelines/maxpeernotelines))}
+		if !notes.merge(noteline{ID: id, Version: id}) {
+			t.Fatalf("line %d was not merged", index)
+		}
+	}
+
+	id := noteid{Clock: maxnotelines + 1, Peer: "late"}
+	if notes.merge(noteline{ID: id, Version: id}) {
+		t.Fatal("merged a line beyond the limit of the notes")
+	}
+}
+
+func TestNotesAnswerSync(t *testing.T) {
+	notes := newtestnotes()
+	if !notes.answersync() {
+		t.Fatal("the first sync was not answered")
+	}
+	if notes.answersync() {
+		t.Fatal("a second sync right away was answered")
+	}
+}
@@ -0,0 +1,147 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Represents the maximum number of characters of the title and the body of a notification
+const (
+	maxnotificationtitle = 80
+	maxnotificationbody  = 200
+)
+
+// A structure that represents a notification dispatcher for
+// mentions and direct messages. Notifications can be delivered
+// as a terminal bell, a terminal escape sequence (passed through
+// tmux/screen) or a desktop notification.
+type notifier struct {
+	// Represents the enabled notification methods
+	methods map[string]bool
+	// Represents the rooms with notifications turned off
+	muted map[string]bool
+	// Represents whether do-not-disturb is enabled
+	dnd bool
+
+	// Represents the lock on the notifier state
+	mutex sync.Mutex
+}
+
+// A constructor function that generates and returns a new notifier
+// for a comma separated list of methods ('bell', 'term', 'desktop').
+func newNotifier(methods string) *notifier {
+	n := &notifier{
+		methods: make(map[string]bool),
+		muted:   make(map[string]bool),
+	}
+
+	// Parse the list of methods
+	for _, method := range strings.Split(methods, ",") {
+		method = strings.ToLower(strings.TrimSpace(method))
+		if method != "" {
+			n.methods[method] = true
+		}
+	}
+
+	return n
+}
+
+// A method of notifier that toggles do-not-disturb and returns the new state
+func (n *notifier) ToggleDND() bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.dnd = !n.dnd
+	return n.dnd
+}
+
+// A method of notifier that turns notifications on or off for a room
+func (n *notifier) SetRoom(room string, enabled bool) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	n.muted[room] = !enabled
+}
+
+// A method of notifier that dispatches a notification with a title and body
+// for a given room using all the enabled methods. Nothing is dispatched if
+// do-not-disturb is enabled or if the room has notifications turned off.
+func (n *notifier) Notify(room, title, body string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+
+	// Check if the notification should be suppressed
+	if n.dnd || n.muted[room] {
+		return
+	}
+
+	// The title and body carry text from peers, which must not end
+	// the escape sequence early or flood the notification
+	title = notificationtext(title, maxnotificationtitle)
+	body = notificationtext(body, maxnotificationbody)
+
+	// Ring the terminal bell
+	if n.methods["bell"] {
+		os.Stdout.WriteString("\a")
+	}
+
+	// Send a terminal notification escape sequence
+	if n.methods["term"] {
+		os.Stdout.WriteString(termnotification(title, body))
+	}
+
+	// Send a desktop notification
+	if n.methods["desktop"] {
+		go desktopnotification(title, body)
+	}
+}
+
+// A function that strips the control characters from the text of a
+// notification and cuts it to a number of characters, marking the cut
+func notificationtext(text string, limit int) string {
+	runes := []rune(stripcontrols(text))
+	if len(runes) <= limit {
+		return string(runes)
+	}
+
+	return string(runes[:limit-1]) + "…"
+}
+
+// A function that generates an OSC 9 terminal notification escape sequence.
+// The sequence is wrapped in a DCS passthrough when running inside tmux or screen.
+func termnotification(title, body string) string {
+	sequence := fmt.Sprintf("\033]9;%s: %s\a", title, body)
+
+	switch {
+	case os.Getenv("TMUX") != "":
+		// tmux requires the escape characters within the passthrough to be doubled
+		return "\033Ptmux;" + strings.ReplaceAll(sequence, "\033", "\033\033") + "\033\\"
+	case os.Getenv("STY") != "":
+		return "\033P" + sequence + "\033\\"
+	default:
+		return sequence
+	}
+}
+
+// A function that sends a desktop notification using the platform
+// notification tool (notify-send on Linux and osascript on MacOSX).
+// Failures are ignored since desktop notifications are best-effort.
+func desktopnotification(title, body string) {
+	var cmd *exec.Cmd
+
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %q with title %q", body, title)
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux", "freebsd", "openbsd":
+		cmd = exec.Command("notify-send", title, body)
+	default:
+		return
+	}
+
+	cmd.Run()
+}
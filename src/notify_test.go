@@ -0,0 +1,37 @@
+package src
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNotificationText(t *testing.T) {
+	// Check the plain sequence, outside of tmux and screen
+	for _, name := range []string{"TMUX", "STY"} {
+		if value, ok := os.LookupEnv(name); ok {
+			os.Unsetenv(name)
+			defer os.Setenv(name, value)
+		}
+	}
+
+	payloads := []string{
+		"hi\a\033]0;pwned\a",
+		"hi\033\\\033[2J",
+		"line\r\nfake",
+		"\u009d0;title\u009c",
+	}
+
+	for _, payload := range payloads {
+		sequence := termnotification(notificationtext(payload, maxnotificationtitle), notificationtext(payload, maxnotificationbody))
+		if strings.Count(sequence, "\033") != 1 || strings.Count(sequence, "\a") != 1 || !strings.HasSuffix(sequence, "\a") {
+			t.Errorf("the notification of %q is %q, which breaks out of the escape sequence", payload, sequence)
+		}
+	}
+
+	long := notificationtext(strings.Repeat("é", 500), maxnotificationbody)
+	if utf8.RuneCountInString(long) != maxnotificationbody || !strings.HasSuffix(long, "…") {
+		t.Errorf("notificationtext() of a long text returned %d characters, want %d ending in '…'", utf8.RuneCountInString(long), maxnotificationbody)
+	}
+}
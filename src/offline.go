@@ -0,0 +1,90 @@
+package src
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A constructor function that returns a chat room that is not joined yet,
+// so that an interface can open before the network is up. The progress of
+// the startup of the node is shown in the logs of the room, and messages
+// are held back until the room goes online with GoOnline.
+func NewOfflineChatRoom(username string, roomname string) *ChatRoom {
+	if roomname == "" {
+		roomname = defaultroom
+	}
+	if username == "" {
+		username = defaultuser
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	chatroom := &ChatRoom{
+		Inbound:  make(chan ChatMessage),
+		Outbound: make(chan string),
+		Logs:     make(chan chatlog),
+		moved:    make(chan *ChatRoom, 1),
+
+		transformed: make(chan string),
+
+		psctx:    ctx,
+		pscancel: cancel,
+		offline:  true,
+
+		RoomName: roomname,
+		UserName: roomnicks.name(roomname, username),
+		basename: username,
+	}
+
+	go chatroom.offlineloop(WatchStartup())
+	return chatroom
+}
+
+// A method of ChatRoom that shows the progress of the startup of the node in the
+// logs of an offline room and holds back its messages until the room goes online
+func (cr *ChatRoom) offlineloop(startup <-chan StartupEvent) {
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+
+		case event := <-startup:
+			cr.log(logrus.InfoLevel, "startup", fmt.Sprintf("%s - %s", event.Stage, event.Message))
+
+		case message := <-cr.Outbound:
+			if message, ok := pipeline.transform(cr.RoomName, message); ok {
+				cr.holdback(message, "the network is still starting")
+			}
+
+		case message := <-cr.transformed:
+			cr.holdback(message, "the network is still starting")
+		}
+	}
+}
+
+// A method of ChatRoom that joins an offline room on a host once the host is
+// up and leaves the offline room for it, which the consumer of the events of
+// the room follows. Returns the joined room.
+func (cr *ChatRoom) GoOnline(ctx context.Context, p2phost *P2P) (*ChatRoom, error) {
+	if !cr.offline {
+		return nil, fmt.Errorf("the room '%s' is already online", cr.RoomName)
+	}
+
+	chatroom, err := JoinChatRoom(ctx, p2phost, cr.basename, cr.RoomName)
+	if err != nil {
+		return nil, err
+	}
+
+	StopStartup()
+	cr.log(logrus.InfoLevel, "startup", fmt.Sprintf("online, joined the '%s' chatroom as '%s'", chatroom.RoomName, chatroom.UserName))
+
+	cr.moveto(chatroom)
+	cr.Exit()
+
+	// Hand the held back messages over to the joined room
+	for _, message := range cr.takepending() {
+		chatroom.queuepending(message)
+	}
+	return chatroom, nil
+}
@@ -0,0 +1,85 @@
+package src
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the maximum number of messages pending in a room
+const maxpendingmessages = 256
+
+// Represents the interval between the checks for peers to send the pending messages to
+const pendinginterval = 2 * time.Second
+
+// A method of ChatRoom that adds a message to the pending messages of the room,
+// which are sent once the room has peers again. Returns the number pending.
+func (cr *ChatRoom) queuepending(message string) (int, error) {
+	cr.pendinglock.Lock()
+	defer cr.pendinglock.Unlock()
+
+	if len(cr.pending) >= maxpendingmessages {
+		return len(cr.pending), errors.New("too many messages are pending, the message was dropped")
+	}
+
+	cr.pending = append(cr.pending, message)
+	return len(cr.pending), nil
+}
+
+// A method of ChatRoom that returns the number of messages waiting
+// to be sent until the room has peers or the network is up again
+func (cr *ChatRoom) Pending() int {
+	cr.pendinglock.Lock()
+	defer cr.pendinglock.Unlock()
+
+	return len(cr.pending)
+}
+
+// A method of ChatRoom that removes and returns the pending messages of the room
+func (cr *ChatRoom) takepending() []string {
+	cr.pendinglock.Lock()
+	defer cr.pendinglock.Unlock()
+
+	pending := cr.pending
+	cr.pending = nil
+	return pending
+}
+
+// A method of ChatRoom that holds back a message that cannot be published
+// yet and logs that it is pending, or that it was dropped if too many are
+func (cr *ChatRoom) holdback(message string, reason string) {
+	count, err := cr.queuepending(message)
+	if err != nil {
+		cr.log(logrus.ErrorLevel, "pending", err.Error())
+		return
+	}
+
+	cr.log(logrus.WarnLevel, "pending", fmt.Sprintf("%s, the message will be sent later (%d pending)", reason, count))
+}
+
+// A method of ChatRoom that sends the pending messages of the room in order, once
+// the room has peers. Messages that fail to publish are put back in front of the
+// messages queued meanwhile, and are retried at the next check.
+func (cr *ChatRoom) flushpending() {
+	if cr.Pending() == 0 || len(cr.PeerList()) == 0 {
+		return
+	}
+
+	pending := cr.takepending()
+	for index, message := range pending {
+		if err := cr.publish(cr.outgoing(message)); err != nil {
+			cr.pendinglock.Lock()
+			cr.pending = append(pending[index:], cr.pending...)
+			cr.pendinglock.Unlock()
+			return
+		}
+
+		stats.count(1, 0)
+		transcripts.write(cr.RoomName, cr.UserName, message)
+		cr.Host.rememberlinks(message)
+	}
+
+	cr.log(logrus.InfoLevel, "pending", fmt.Sprintf("sent %d pending messages", len(pending)))
+}
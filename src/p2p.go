@@ -2,15 +2,15 @@ package src
 
 import (
 	"context"
-	"crypto/rand"
 	"crypto/sha256"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/ipfs/go-cid"
 	"github.com/libp2p/go-libp2p"
+	circuit "github.com/libp2p/go-libp2p-circuit"
 	connmgr "github.com/libp2p/go-libp2p-connmgr"
-	"github.com/libp2p/go-libp2p-core/crypto"
 	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/libp2p/go-libp2p-core/routing"
 	discovery "github.com/libp2p/go-libp2p-discovery"
@@ -30,8 +30,10 @@ const service = "manishmeganathan/peerchat"
 
 // A structure that represents a P2P Host
 type P2P struct {
-	// Represents the host context layer
+	// Represents the host context layer, which is cancelled when the host is closed
 	Ctx context.Context
+	// Represents the cancellation function of the host context
+	cancel context.CancelFunc
 
 	// Represents the libp2p host
 	Host host.Host
@@ -42,8 +44,59 @@ type P2P struct {
 	// Represents the peer discovery service
 	Discovery *discovery.RoutingDiscovery
 
-	// Represents the PubSub Handler
-	PubSub *pubsub.PubSub
+	// Represents the PubSub Handler, which transports the messages of the rooms
+	PubSub MessageTransport
+	// Represents the tracer of the PubSub events (nil if not traced)
+	tracer pubsubtracer
+	// Represents the delivery records of the recent messages, for /trace
+	deliveries *deliverylog
+
+	// Represents the last known GossipSub scores of the peers
+	scores *peerscores
+
+	// Represents the joined PubSub topics and their reference counts
+	topics map[string]*sharedtopic
+	// Represents the lock on the joined topics
+	topiclock sync.Mutex
+
+	// Represents the protocol versions and capabilities of the peers
+	protocols *peerprotocols
+
+	// Represents the onion service of the host (nil without Tor)
+	onion *onionservice
+
+	// Represents the publisher of the user profile of the host
+	profile *profilepublisher
+
+	// Represents the link previews of the host
+	previews *previewer
+	// Represents the join requests to approve as an operator, read by the UI
+	joinrequests chan *joinrequest
+	// Represents the lock on the join requests channel
+	joinlock sync.Mutex
+	// Represents the direct message streams with peers
+	dms *dmmanager
+	// Represents the voice channel with peers
+	voice *voicechannel
+	// Represents the terminals shared by peers, read by the UI
+	termshares chan termshare
+	// Represents the recent DHT queries of the host
+	queries *querylog
+	// Represents the dial histories of the discovered peers
+	dials *dialbook
+	// Represents the watcher that reconnects the host when it loses all its connections
+	netwatch *netwatcher
+	// Represents the datastore of the peerstore and the DHT (nil if ephemeral)
+	datastore *diskstore
+	// Represents the registrations of the host as a tracker (nil if it is not one)
+	tracker *trackerserver
+}
+
+// A structure that represents a PubSub topic shared by
+// all the users of the topic on the host (chat rooms, webhooks)
+type sharedtopic struct {
+	topic Topic
+	refs  int
 }
 
 /*
@@ -55,13 +108,28 @@ transport connection using a Yamux Stream Multiplexer and uses UPnP for the NAT
 A Kademlia DHT is then bootstrapped on this host using the default peers offered by libp2p
 and a Peer Discovery service is created from this Kademlia DHT. The PubSub handler is then
 created on the host using the peer discovery service created prior.
+
+The host context is derived from the given context, so cancelling it
+stops the host and exits the chat rooms joined on it.
 */
-func NewP2P() *P2P {
-	// Setup a background context
-	ctx := context.Background()
+func NewP2P(ctx context.Context) *P2P {
+	return newp2p(ctx, false)
+}
+
+// A constructor function that generates and returns a P2P object for a relay
+// node, which relays connections for peers that cannot be reached directly
+// (such as those behind NATs) and advertises itself as a relay to them.
+func NewRelayP2P(ctx context.Context) *P2P {
+	return newp2p(ctx, true)
+}
+
+// A function that generates and returns a P2P object, acting as a relay if hop is set
+func newp2p(ctx context.Context, hop bool) *P2P {
+	// Open the datastore of the peerstore and the DHT (nil if ephemeral)
+	store := opendatastore(ctx)
 
 	// Setup a P2P Host Node
-	nodehost, kaddht := setupHost(ctx)
+	nodehost, kaddht, onion := setupHost(ctx, hop, store)
 	// Debug log
 	logrus.Debugln("Created the P2P Host and the Kademlia DHT.")
 
@@ -70,24 +138,153 @@ func NewP2P() *P2P {
 	// Debug log
 	logrus.Debugln("Bootstrapped the Kademlia DHT and Connected to Bootstrap Peers")
 
+	// Reconnect to the peers of the routing table before the restart
+	if store != nil {
+		warmrouting(ctx, nodehost, store)
+	}
+
+	p2p := newnode(ctx, nodehost, kaddht, onion, store)
+	// Reconnect to the network whenever all the connections are lost
+	go p2p.watchnetwork()
+
+	return p2p
+}
+
+// A constructor function that generates and returns a P2P object for an existing
+// libp2p host, such as a host of an in-memory mocknet, with a Kademlia DHT kept in
+// memory. The host is not bootstrapped or connected to any peers, which is left
+// to the caller, and nothing of the host is kept in the datastore.
+func NewHostP2P(ctx context.Context, nodehost host.Host) (*P2P, error) {
+	kaddht, err := dht.New(ctx, nodehost, append([]dht.Option{dht.Mode(dht.ModeServer)}, recordvalidators()...)...)
+	if err != nil {
+		return nil, err
+	}
+
+	return newnode(ctx, nodehost, kaddht, nil, nil), nil
+}
+
+// A function that creates the discovery service and the PubSub handler of a
+// host with its Kademlia DHT, and returns the P2P object of the host with the
+// handlers of the application protocols set. The onion service and the
+// datastore are nil without Tor and for an ephemeral host.
+func newnode(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT, onion *onionservice, store *diskstore) *P2P {
+	// Derive the host context, which is cancelled when the host is closed
+	ctx, cancel := context.WithCancel(ctx)
+
 	// Create a peer discovery service using the Kad DHT
 	routingdiscovery := discovery.NewRoutingDiscovery(kaddht)
 	// Debug log
 	logrus.Debugln("Created the Peer Discovery Service.")
 
 	// Create a PubSub handler with the routing discovery
-	pubsubhandler := setupPubSub(ctx, nodehost, routingdiscovery)
+	scores := newpeerscores()
+	tracer := opentracer(ctx, nodehost)
+	deliveries := newdeliverylog(tracer)
+	pubsubhandler := setupPubSub(ctx, nodehost, routingdiscovery, scores, deliveries)
 	// Debug log
 	logrus.Debugln("Created the PubSub Handler.")
 
+	p2p := &P2P{
+		Ctx:        ctx,
+		cancel:     cancel,
+		Host:       nodehost,
+		KadDHT:     kaddht,
+		Discovery:  routingdiscovery,
+		PubSub:     pubsubhandler,
+		tracer:     tracer,
+		deliveries: deliveries,
+		scores:     scores,
+		topics:     make(map[string]*sharedtopic),
+		protocols:  newpeerprotocols(),
+		onion:      onion,
+		profile:    &profilepublisher{profile: loaduserprofile(), known: make(map[peer.ID]*UserProfile)},
+		previews:   newpreviewer(currentconfig().Previews),
+		dms:        newdmmanager(),
+		voice:      newvoicechannel(),
+		termshares: make(chan termshare, 8),
+		queries:    &querylog{},
+		dials:      newdialbook(),
+		netwatch:   newnetwatcher(),
+		datastore:  store,
+	}
+
+	// Answer the capability handshakes of other peers
+	nodehost.SetStreamHandler(helloprotocol, p2p.handlehello)
+	// Answer the requests for the user profile
+	nodehost.SetStreamHandler(profileprotocol, p2p.handleprofile)
+	nodehost.SetStreamHandler(avatarprotocol, p2p.handleavatar)
+	// Answer the requests for the previews of sent links
+	nodehost.SetStreamHandler(previewprotocol, p2p.handlepreview)
+	// Answer the requests for room settings and accept the pushed settings
+	nodehost.SetStreamHandler(roomprotocol, p2p.handleroomsettings)
+	// Answer the requests to join the rooms the host is an operator of
+	nodehost.SetStreamHandler(joinprotocol, p2p.handlejoin)
+	// Receive the direct messages of peers
+	nodehost.SetStreamHandler(dmprotocol, p2p.handledm)
+	// Receive the voice of the peers in the voice channel
+	nodehost.SetStreamHandler(voiceprotocol, p2p.handlevoice)
+	// Receive the terminals shared by peers
+	nodehost.SetStreamHandler(termprotocol, p2p.handleterm)
+	// Send the queued direct messages as soon as their peers connect
+	nodehost.Network().Notify(p2p.dmnotifiee())
+	// Reset the inbound streams beyond the stream limits
+	nodehost.Network().Notify(p2p.streamlimiter())
+	// Record the peak number of peers for the statistics of the session
+	nodehost.Network().Notify(stats.notifiee())
+	// Trim the connections if the memory goes over the limit
+	go p2p.watchmemory()
+	// Disconnect random peers if the chaos options ask for it
+	if chaos.Disconnect > 0 {
+		go p2p.chaosloop()
+	}
+
 	// Return the P2P object
-	return &P2P{
-		Ctx:       ctx,
-		Host:      nodehost,
-		KadDHT:    kaddht,
-		Discovery: routingdiscovery,
-		PubSub:    pubsubhandler,
+	return p2p
+}
+
+// A method of P2P that joins a PubSub topic and returns its handle.
+// PubSub only allows a single handle per topic, so the handle is
+// shared and reference counted. Each call must be paired with LeaveTopic.
+func (p2p *P2P) JoinTopic(name string) (Topic, error) {
+	p2p.topiclock.Lock()
+	defer p2p.topiclock.Unlock()
+
+	// Reuse the handle if the topic has already been joined
+	if shared, ok := p2p.topics[name]; ok {
+		shared.refs++
+		return shared.topic, nil
+	}
+
+	// Join the topic
+	topic, err := p2p.PubSub.Join(name)
+	if err != nil {
+		return nil, err
+	}
+
+	p2p.topics[name] = &sharedtopic{topic: topic, refs: 1}
+	return topic, nil
+}
+
+// A method of P2P that releases a handle of a PubSub topic
+// acquired with JoinTopic. The topic is closed when it has no users.
+func (p2p *P2P) LeaveTopic(name string) error {
+	p2p.topiclock.Lock()
+	defer p2p.topiclock.Unlock()
+
+	shared, ok := p2p.topics[name]
+	if !ok {
+		return nil
+	}
+
+	// Close the topic if this was the last user
+	if shared.refs--; shared.refs > 0 {
+		return nil
 	}
+
+	delete(p2p.topics, name)
+	// Remove the validator of the topic, if it has one
+	p2p.PubSub.UnregisterTopicValidator(name)
+	return shared.topic.Close()
 }
 
 // A method of P2P to connect to service peers.
@@ -96,17 +293,19 @@ func NewP2P() *P2P {
 // The peer discovery is handled by a go-routine that will read from a channel
 // of peer address information until the peer channel closes
 func (p2p *P2P) AdvertiseConnect() {
+	p2p.setdiscovery("advertise")
 	// Advertise the availabilty of the service on this node
-	ttl, err := p2p.Discovery.Advertise(p2p.Ctx, service)
+	ttl, err := p2p.Discovery.Advertise(p2p.Ctx, servicename())
 	// Debug log
 	logrus.Debugln("Advertised the PeerChat Service.")
+	reportstartup("discovery", "advertised the service, looking for peers")
 	// Sleep to give time for the advertisment to propogate
 	time.Sleep(time.Second * 5)
 	// Debug log
 	logrus.Debugf("Service Time-to-Live is %s", ttl)
 
 	// Find all peers advertising the same service
-	peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, service)
+	peerchan, err := p2p.Discovery.FindPeers(p2p.Ctx, servicename())
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -117,7 +316,7 @@ func (p2p *P2P) AdvertiseConnect() {
 	logrus.Traceln("Discovered PeerChat Service Peers.")
 
 	// Connect to peers as they are discovered
-	go handlePeerDiscovery(p2p.Host, peerchan)
+	go p2p.handlediscovery(peerchan)
 	// Trace log
 	logrus.Traceln("Started Peer Connection Handler.")
 }
@@ -128,13 +327,14 @@ func (p2p *P2P) AdvertiseConnect() {
 // The peer discovery is handled by a go-routine that will read from a channel
 // of peer address information until the peer channel closes
 func (p2p *P2P) AnnounceConnect() {
+	p2p.setdiscovery("announce")
 	// Generate the Service CID
-	cidvalue := generateCID(service)
+	cidvalue := generateCID(servicename())
 	// Trace log
 	logrus.Traceln("Generated the Service CID.")
 
 	// Announce that this host can provide the service CID
-	err := p2p.KadDHT.Provide(p2p.Ctx, cidvalue, true)
+	err := p2p.provide(p2p.Ctx, cidvalue)
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err.Error(),
@@ -142,6 +342,7 @@ func (p2p *P2P) AnnounceConnect() {
 	}
 	// Debug log
 	logrus.Debugln("Announced the PeerChat Service.")
+	reportstartup("discovery", "announced the service, looking for peers")
 	// Sleep to give time for the advertisment to propogate
 	time.Sleep(time.Second * 5)
 
@@ -151,31 +352,83 @@ func (p2p *P2P) AnnounceConnect() {
 	logrus.Traceln("Discovered PeerChat Service Peers.")
 
 	// Connect to peers as they are discovered
-	go handlePeerDiscovery(p2p.Host, peerchan)
+	go p2p.handlediscovery(peerchan)
 	// Debug log
 	logrus.Debugln("Started Peer Connection Handler.")
 }
 
+// A method of P2P that shuts down the Kademlia DHT and the
+// libp2p host, closing all connections to peers
+func (p2p *P2P) Close() error {
+	// Cancel the host context, which exits the chat rooms on the host
+	p2p.cancel()
+
+	// Flush the PubSub trace once the host is closed
+	if p2p.tracer != nil {
+		defer p2p.tracer.Close()
+	}
+
+	// Keep the peers of the routing table for the next start
+	if p2p.datastore != nil {
+		p2p.datastore.saverouting(p2p.KadDHT.RoutingTable().ListPeers())
+		defer p2p.datastore.Close()
+	}
+
+	// Close the Kademlia DHT
+	if err := p2p.KadDHT.Close(); err != nil {
+		return err
+	}
+
+	// Remove the onion service
+	if p2p.onion != nil {
+		p2p.onion.close()
+	}
+
+	// Close the libp2p host
+	return p2p.Host.Close()
+}
+
 // A function that generates the p2p configuration options and creates a
-// libp2p host object for the given context. The created host is returned
-func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT) {
-	// Set up the host identity options
-	prvkey, _, err := crypto.GenerateKeyPairWithReader(crypto.RSA, 2048, rand.Reader)
-	identity := libp2p.Identity(prvkey)
+// libp2p host object for the given context. The host relays connections
+// for other peers if hop is set, and keeps its peerstore and DHT in the
+// datastore unless it is nil. The created host is returned with its
+// onion service if Tor is enabled.
+func setupHost(ctx context.Context, hop bool, store *diskstore) (host.Host, *dht.IpfsDHT, *onionservice) {
+	// Set up the host identity options with the identity key of the profile
+	prvkey, err := loadidentity()
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err.Error(),
-		}).Fatalln("Failed to Generate P2P Identity Configuration!")
+			"file":  identitypath(),
+		}).Fatalln("Failed to Load P2P Identity Configuration!")
+	}
+	identity := libp2p.Identity(prvkey)
+	if peerid, err := peer.IDFromPrivateKey(prvkey); err == nil {
+		reportstartup("identity", "loaded the identity key of %s", peerid.Pretty())
 	}
 
 	// Trace log
 	logrus.Traceln("Generated P2P Identity Configuration.")
 
+	// Set up the onion service if Tor is enabled
+	var onion *onionservice
+	if tor := currentconfig().Tor; tor.Enabled {
+		onion = newonionservice(tor)
+	}
+
 	// Set up TLS secured TCP transport and options
 	tlstransport, err := tls.New(prvkey)
 	security := libp2p.Security(tls.ID, tlstransport)
 	transport := libp2p.Transport(tcp.NewTCPTransport)
+	// Dial peers through the SOCKS5 proxy if one is configured
+	if proxy := currentconfig().proxy(); proxy != nil {
+		transport = libp2p.Transport(proxytcp(proxy, false))
+	}
+	// Dial all peers (including onion addresses) through Tor if enabled
+	if onion != nil {
+		transport = libp2p.Transport(proxytcp(onion.proxy(), true))
+	}
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -186,22 +439,22 @@ func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT) {
 	// Trace log
 	logrus.Traceln("Generated P2P Security and Transport Configurations.")
 
-	// Set up host listener address options
-	muladdr, err := multiaddr.NewMultiaddr("/ip4/0.0.0.0/tcp/0")
-	listen := libp2p.ListenAddrs(muladdr)
-	// Handle any potential error
-	if err != nil {
-		logrus.WithFields(logrus.Fields{
-			"error": err.Error(),
-		}).Fatalln("Failed to Generate P2P Address Listener Configuration!")
+	// Set up host listener address options from the configuration
+	listen := libp2p.ListenAddrs(currentconfig().listenaddrs()...)
+	// Only listen locally for the onion service and advertise the onion address with Tor
+	if onion != nil {
+		listen = libp2p.ChainOptions(libp2p.ListenAddrStrings("/ip4/127.0.0.1/tcp/0"), libp2p.AddrsFactory(onion.addrs))
 	}
 
 	// Trace log
 	logrus.Traceln("Generated P2P Address Listener Configuration.")
 
 	// Set up the stream multiplexer and connection manager options
-	muxer := libp2p.Muxer("/yamux/1.0.0", yamux.DefaultTransport)
+	muxer := libp2p.Muxer("/yamux/1.0.0", chaosmuxer(yamux.DefaultTransport))
 	conn := libp2p.ConnectionManager(connmgr.NewConnManager(100, 400, time.Minute))
+	// Refuse inbound connections beyond the connection limit
+	limiter := &connlimiter{}
+	gater := libp2p.ConnectionGater(limiter)
 
 	// Trace log
 	logrus.Traceln("Generated P2P Stream Multiplexer, Connection Manager Configurations.")
@@ -209,6 +462,14 @@ func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT) {
 	// Setup NAT traversal and relay options
 	nat := libp2p.NATPortMap()
 	relay := libp2p.EnableAutoRelay()
+	// Port mappings and relay addresses would reveal the host with Tor
+	if onion != nil {
+		nat, relay = libp2p.ChainOptions(), libp2p.ChainOptions()
+	}
+	// Act as a relay for other peers if requested
+	if hop {
+		relay = libp2p.ChainOptions(relay, libp2p.EnableRelay(circuit.OptHop))
+	}
 
 	// Trace log
 	logrus.Traceln("Generated P2P NAT Traversal and Relay Configurations.")
@@ -217,14 +478,31 @@ func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT) {
 	var kaddht *dht.IpfsDHT
 	// Setup a routing configuration with the KadDHT
 	routing := libp2p.Routing(func(h host.Host) (routing.PeerRouting, error) {
-		kaddht = setupKadDHT(ctx, h)
+		kaddht = setupKadDHT(ctx, h, store)
 		return kaddht, err
 	})
 
 	// Trace log
 	logrus.Traceln("Generated P2P Routing Configurations.")
 
-	opts := libp2p.ChainOptions(identity, listen, security, transport, muxer, conn, nat, routing, relay)
+	// Advertise the agent version and capabilities of the application with Identify
+	agent := libp2p.UserAgent(identifyagent())
+	// Count the bytes transferred for the statistics of the session
+	bandwidth := libp2p.BandwidthReporter(stats.bandwidth)
+
+	// Keep the addresses of known peers in the datastore across restarts
+	peers := libp2p.ChainOptions()
+	if store != nil {
+		peerstore, err := store.openpeerstore(ctx)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Create the P2P Peerstore!")
+		}
+		peers = libp2p.Peerstore(peerstore)
+	}
+
+	opts := libp2p.ChainOptions(identity, listen, security, transport, muxer, conn, gater, nat, routing, relay, agent, bandwidth, peers)
 
 	// Construct a new libP2P host with the created options
 	libhost, err := libp2p.New(ctx, opts)
@@ -235,24 +513,63 @@ func setupHost(ctx context.Context) (host.Host, *dht.IpfsDHT) {
 		}).Fatalln("Failed to Create the P2P Host!")
 	}
 
-	// Return the created host and the kademlia DHT
-	return libhost, kaddht
+	// Count the connections of the host against the connection limit
+	limiter.attach(libhost.Network())
+	for _, addr := range libhost.Addrs() {
+		reportstartup("listen", "listening on %s", addr)
+	}
+
+	// Publish the onion service for the local listener
+	if onion != nil {
+		if err := onion.publish(localport(libhost)); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Publish the Onion Service!")
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"address": onion.addrs(nil)[0].String(),
+		}).Infoln("Reachable over Tor.")
+	}
+
+	// Return the created host, the kademlia DHT and the onion service
+	return libhost, kaddht, onion
 }
 
-// A function that generates a Kademlia DHT object and returns it
-func setupKadDHT(ctx context.Context, nodehost host.Host) *dht.IpfsDHT {
+// A function that returns the TCP port the host listens on
+func localport(nodehost host.Host) int {
+	for _, addr := range nodehost.Network().ListenAddresses() {
+		if value, err := addr.ValueForProtocol(multiaddr.P_TCP); err == nil {
+			if port, err := strconv.Atoi(value); err == nil {
+				return port
+			}
+		}
+	}
+
+	return 0
+}
+
+// A function that generates a Kademlia DHT object and returns it. The
+// records of the DHT are kept in the datastore unless it is nil.
+func setupKadDHT(ctx context.Context, nodehost host.Host, store *diskstore) *dht.IpfsDHT {
 	// Create DHT server mode option
 	dhtmode := dht.Mode(dht.ModeServer)
-	// Rertieve the list of boostrap peer addresses
-	bootstrappeers := dht.GetDefaultBootstrapPeerAddrInfos()
+	// Rertieve the list of boostrap peer addresses from the configuration
+	bootstrappeers, _ := peer.AddrInfosFromP2pAddrs(currentconfig().bootstrappeers()...)
 	// Create the DHT bootstrap peers option
 	dhtpeers := dht.BootstrapPeers(bootstrappeers...)
 
 	// Trace log
 	logrus.Traceln("Generated DHT Configuration.")
 
+	options := append([]dht.Option{dhtmode, dhtpeers}, recordvalidators()...)
+	// Keep the records of the DHT in the datastore across restarts
+	if store != nil {
+		options = append(options, dht.Datastore(store.dhtstore()))
+	}
+
 	// Start a Kademlia DHT on the host in server mode
-	kaddht, err := dht.New(ctx, nodehost, dhtmode, dhtpeers)
+	kaddht, err := dht.New(ctx, nodehost, options...)
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
@@ -264,16 +581,44 @@ func setupKadDHT(ctx context.Context, nodehost host.Host) *dht.IpfsDHT {
 	return kaddht
 }
 
+// A function that returns the DHT options with the validators of the records of the application
+func recordvalidators() []dht.Option {
+	return []dht.Option{
+		// Validate the user profile records
+		dht.NamespacedValidator(profilenamespace, profilevalidator{}),
+		// Validate the room settings records
+		dht.NamespacedValidator(roomnamespace, roomvalidator{}),
+	}
+}
+
 // A function that generates a PubSub Handler object and returns it
-// Requires a node host and a routing discovery service.
-func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *discovery.RoutingDiscovery) *pubsub.PubSub {
-	// Create a new PubSub service which uses a GossipSub router
-	pubsubhandler, err := pubsub.NewGossipSub(ctx, nodehost, pubsub.WithDiscovery(routingdiscovery))
+// Requires a node host, a routing discovery service, the peer scores
+// which are updated with the scores of the GossipSub router and the
+// tracer of the PubSub events, which records the delivery of the messages.
+func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *discovery.RoutingDiscovery, scores *peerscores, tracer pubsub.EventTracer) MessageTransport {
+	options := []pubsub.Option{pubsub.WithDiscovery(routingdiscovery), pubsub.WithEventTracer(tracer)}
+
+	// Retrieve the message transport of the configured router
+	router := currentconfig().router()
+	constructor, ok := transportconstructor(router)
+	if !ok {
+		logrus.WithFields(logrus.Fields{
+			"router": router,
+		}).Fatalln("Unknown PubSub Router!")
+	}
+
+	// Peer scoring is only supported by the GossipSub router
+	if router == "gossipsub" {
+		options = append(options, peerscoreoptions(scores)...)
+	}
+
+	// Create a new PubSub service which uses the router
+	pubsubhandler, err := constructor(ctx, nodehost, options...)
 	// Handle any potential error
 	if err != nil {
 		logrus.WithFields(logrus.Fields{
 			"error": err.Error(),
-			"type":  "GossipSub",
+			"type":  router,
 		}).Fatalln("PubSub Handler Creation Failed!")
 	}
 
@@ -282,7 +627,7 @@ func setupPubSub(ctx context.Context, nodehost host.Host, routingdiscovery *disc
 }
 
 // A function that bootstraps a given Kademlia DHT to satisfy the IPFS router
-// interface and connects to all the bootstrap peers provided by libp2p
+// interface and connects to all the bootstrap peers of the configuration
 func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT) {
 	// Bootstrap the DHT to satisfy the IPFS Router interface
 	if err := kaddht.Bootstrap(ctx); err != nil {
@@ -300,8 +645,8 @@ func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT)
 	var connectedbootpeers int
 	var totalbootpeers int
 
-	// Iterate over the default bootstrap peers provided by libp2p
-	for _, peeraddr := range dht.DefaultBootstrapPeers {
+	// Iterate over the configured (or default) bootstrap peers
+	for _, peeraddr := range currentconfig().bootstrappeers() {
 		// Retrieve the peer address information
 		peerinfo, _ := peer.AddrInfoFromP2pAddr(peeraddr)
 
@@ -316,6 +661,7 @@ func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT)
 				// Increment the total bootstrap peer count
 				totalbootpeers++
 			} else {
+				reportstartup("bootstrap", "connected to bootstrap peer %s", peerinfo.ID.ShortString())
 				// Increment the connected bootstrap peer count
 				connectedbootpeers++
 				// Increment the total bootstrap peer count
@@ -329,21 +675,7 @@ func bootstrapDHT(ctx context.Context, nodehost host.Host, kaddht *dht.IpfsDHT)
 
 	// Log the number of bootstrap peers connected
 	logrus.Debugf("Connected to %d out of %d Bootstrap Peers.", connectedbootpeers, totalbootpeers)
-}
-
-// A function that connects the given host to all peers recieved from a
-// channel of peer address information. Meant to be started as a go routine.
-func handlePeerDiscovery(nodehost host.Host, peerchan <-chan peer.AddrInfo) {
-	// Iterate over the peer channel
-	for peer := range peerchan {
-		// Ignore if the discovered peer is the host itself
-		if peer.ID == nodehost.ID() {
-			continue
-		}
-
-		// Connect to the peer
-		nodehost.Connect(context.Background(), peer)
-	}
+	reportstartup("bootstrap", "connected to %d of %d bootstrap peers", connectedbootpeers, totalbootpeers)
 }
 
 // A function that generates a CID object for a given string and returns it.
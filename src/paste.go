@@ -0,0 +1,159 @@
+package src
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+)
+
+// Represents the time within which consecutive input lines are considered
+// a single paste. Pasted lines arrive much faster than anyone can type.
+const pastedelay = 40 * time.Millisecond
+
+// Represents the fence that marks a message as a code block
+const codefence = "```"
+
+// A method of UI that handles the done signal of the input field. Lines
+// are collected for a short while before they are submitted, so that a
+// multi-line paste can be told apart from lines that are typed.
+// Called from the tview event loop.
+func (ui *UI) inputdone(key tcell.Key) {
+	// Check if trigger was caused by a Return(Enter) press.
+	if key != tcell.KeyEnter {
+		return
+	}
+
+	// Read the input text. Empty lines are only kept within a paste.
+	line := ui.inputBox.GetText()
+	if len(line) == 0 && len(ui.pasted) == 0 {
+		return
+	}
+
+	// Reset the input field
+	ui.inputBox.SetText("")
+
+	ui.pasted = append(ui.pasted, line)
+	if ui.pastetimer != nil {
+		ui.pastetimer.Stop()
+	}
+
+	ui.pastetimer = time.AfterFunc(pastedelay, func() {
+		ui.TerminalApp.QueueUpdateDraw(ui.flushinput)
+	})
+}
+
+// A method of UI that submits the collected input lines. A single line
+// is submitted as a command or message, while multiple lines are a paste.
+// Called from the tview event loop.
+func (ui *UI) flushinput() {
+	lines := ui.pasted
+	ui.pasted = nil
+
+	// Drop the trailing empty lines of the paste
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	switch len(lines) {
+	case 0:
+	case 1:
+		ui.submitline(lines[0])
+	default:
+		ui.offerpaste(lines)
+	}
+}
+
+// A method of UI that submits a line of input as a command or a message
+func (ui *UI) submitline(line string) {
+	// Check for command inputs
+	if strings.HasPrefix(line, "/") {
+		// Split the command from its argument
+		cmdparts := strings.SplitN(line, " ", 2)
+
+		// Add a nil arg if there is no argument
+		if len(cmdparts) == 1 {
+			cmdparts = append(cmdparts, "")
+		}
+
+		// Send the command
+		ui.CmdInputs <- uicommand{cmdtype: cmdparts[0], cmdarg: strings.TrimSpace(cmdparts[1])}
+		return
+	}
+
+	// Send the message
+	ui.MsgInputs <- line
+}
+
+// A method of UI that asks how a multi-line paste is sent: as a single
+// code block message, as separate messages, or not at all.
+// Called from the tview event loop.
+func (ui *UI) offerpaste(lines []string) {
+	codeblock := "Send as a code block"
+	separate := fmt.Sprintf("Send %d messages", len(lines))
+
+	modal := tview.NewModal().
+		SetText(fmt.Sprintf("You pasted %d lines.", len(lines))).
+		AddButtons([]string{codeblock, separate, "Cancel"}).
+		SetDoneFunc(func(index int, label string) {
+			// Dismiss the modal and return to the input
+			ui.pages.RemovePage("paste")
+			ui.TerminalApp.SetFocus(ui.inputBox)
+
+			// Send the messages outside the event loop, since each
+			// displayed message queues a redraw on the event loop
+			switch label {
+			case codeblock:
+				go func() { ui.MsgInputs <- formatcodeblock(lines) }()
+			case separate:
+				go func() {
+					for _, line := range lines {
+						if strings.TrimSpace(line) != "" {
+							ui.MsgInputs <- line
+						}
+					}
+				}()
+			}
+		})
+
+	ui.pages.AddPage("paste", modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
+
+// A function that formats lines as a code block message
+func formatcodeblock(lines []string) string {
+	return codefence + "\n" + strings.Join(lines, "\n") + "\n" + codefence
+}
+
+// A function that returns the lines of a code block message,
+// or false if the message is not a code block
+func codeblocklines(message string) ([]string, bool) {
+	if len(message) < 2*len(codefence)+2 {
+		return nil, false
+	}
+
+	if !strings.HasPrefix(message, codefence+"\n") || !strings.HasSuffix(message, "\n"+codefence) {
+		return nil, false
+	}
+
+	body := message[len(codefence)+1 : len(message)-len(codefence)-1]
+	return strings.Split(body, "\n"), true
+}
+
+// A function that sanitizes the text of a message for the message box. Code
+// blocks are rendered on their own lines, set off from the other messages.
+func rendermessage(message string) string {
+	lines, ok := codeblocklines(message)
+	if !ok {
+		return sanitize(message)
+	}
+
+	var builder strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&builder, "\n[aqua]  | %s[-]", sanitize(line))
+	}
+
+	return builder.String()
+}
@@ -0,0 +1,43 @@
+package src
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// A function that returns the path of the peerchat base directory in the
+// home directory of the user (~/.peerchat) which holds the default profile
+// and the other profiles. Falls back to the working directory on failure.
+func basedir() string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return ".peerchat"
+	}
+
+	return filepath.Join(home, ".peerchat")
+}
+
+// A function that returns the path of the directory of the selected
+// profile, which holds its identity and configuration file. This is the
+// base directory for the default profile and ~/.peerchat/profiles/<name>
+// for the other profiles.
+func defaultdir() string {
+	if name := ProfileName(); name != "" {
+		return filepath.Join(basedir(), "profiles", name)
+	}
+
+	return basedir()
+}
+
+// A function that returns the path of the peerchat directory which
+// holds plugins, scripts and other local data. This is the directory
+// of the selected profile unless the configuration sets another one.
+func peerchatdir() string {
+	return currentconfig().datadir()
+}
+
+// A function that returns the path of a
+// file or directory within the peerchat directory
+func peerchatpath(elem ...string) string {
+	return filepath.Join(append([]string{peerchatdir()}, elem...)...)
+}
@@ -0,0 +1,60 @@
+package src
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the interval between the checks of the number of peers in a room
+const peeralertinterval = 2 * time.Second
+
+// A function that checks the peer count thresholds of the configuration
+func validpeeralerts(thresholds []int) error {
+	for _, threshold := range thresholds {
+		if threshold < 1 {
+			return fmt.Errorf("invalid peer alert threshold %d (must be at least 1)", threshold)
+		}
+	}
+
+	return nil
+}
+
+// A function that returns the log lines for the peer count thresholds of the
+// configuration that were crossed when the number of peers changed
+func peeralerts(thresholds []int, before, after int) []string {
+	var alerts []string
+	for _, threshold := range thresholds {
+		switch {
+		case before < threshold && after >= threshold:
+			alerts = append(alerts, fmt.Sprintf("the room has reached %d peers (%d now)", threshold, after))
+		case before >= threshold && after < threshold:
+			alerts = append(alerts, fmt.Sprintf("the room has dropped below %d peers (%d now)", threshold, after))
+		}
+	}
+
+	return alerts
+}
+
+// A method of ChatRoom that logs when the number of peers in the room crosses
+// the peer count thresholds of the configuration, until the room is exited
+func (cr *ChatRoom) watchpeercount() {
+	ticker := time.NewTicker(peeralertinterval)
+	defer ticker.Stop()
+
+	count := len(cr.PeerList())
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+
+		case <-ticker.C:
+			current := len(cr.PeerList())
+			for _, alert := range peeralerts(currentconfig().PeerAlerts, count, current) {
+				cr.log(logrus.InfoLevel, "peers", alert)
+			}
+			count = current
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package src
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// Represents the interval at which the GossipSub peer scores are inspected
+const peerscoreinterval = 10 * time.Second
+
+// A structure that represents the last known GossipSub scores of the peers
+type peerscores struct {
+	// Represents the scores indexed by peer ID
+	scores map[peer.ID]float64
+	// Represents the lock on the scores
+	mutex sync.RWMutex
+}
+
+// A constructor function that generates and returns an empty peerscores
+func newpeerscores() *peerscores {
+	return &peerscores{scores: make(map[peer.ID]float64)}
+}
+
+// A method of peerscores that replaces the scores with the
+// scores inspected from the GossipSub router
+func (ps *peerscores) inspect(scores map[peer.ID]float64) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.scores = scores
+}
+
+// A method of peerscores that returns the score of a peer (0 if unknown)
+func (ps *peerscores) score(peerid peer.ID) float64 {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	return ps.scores[peerid]
+}
+
+// A function that returns the GossipSub peer score options. The score only
+// penalizes misbehaving routers and many peers from a single IP address, so
+// regular peers stay at zero and are never graylisted by it.
+func peerscoreoptions(scores *peerscores) []pubsub.Option {
+	params := &pubsub.PeerScoreParams{
+		Topics:                      make(map[string]*pubsub.TopicScoreParams),
+		AppSpecificScore:            func(peer.ID) float64 { return 0 },
+		IPColocationFactorWeight:    -10,
+		IPColocationFactorThreshold: 5,
+		BehaviourPenaltyWeight:      -1,
+		BehaviourPenaltyDecay:       pubsub.ScoreParameterDecay(time.Hour),
+		DecayInterval:               time.Second,
+		DecayToZero:                 0.01,
+		RetainScore:                 time.Hour,
+	}
+
+	thresholds := &pubsub.PeerScoreThresholds{
+		GossipThreshold:   -100,
+		PublishThreshold:  -500,
+		GraylistThreshold: -1000,
+	}
+
+	return []pubsub.Option{
+		pubsub.WithPeerScore(params, thresholds),
+		pubsub.WithPeerScoreInspect(pubsub.PeerScoreInspectFn(scores.inspect), peerscoreinterval),
+	}
+}
+
+// A method of P2P that returns the last known GossipSub score of a
+// peer, which is negative for peers that misbehave (0 if unknown)
+func (p2p *P2P) PeerScore(peerid peer.ID) float64 {
+	return p2p.scores.score(peerid)
+}
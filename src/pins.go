@@ -0,0 +1,183 @@
+package src
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the key pinned for a user name on first contact
+type pin struct {
+	// Represents the peer ID (derived from the public key) of the user
+	PeerID string `json:"peerid"`
+	// Represents the time the key was pinned
+	Pinned time.Time `json:"pinned"`
+}
+
+// A structure that represents the keys pinned for user names, trusted on
+// first use like SSH host keys. A user name that later appears with a
+// different key raises a warning until the new key is trusted.
+type pinstore struct {
+	// Represents the pinned keys indexed by user name
+	pins map[string]pin
+	// Represents the last different key seen for each pinned user name
+	changed map[string]peer.ID
+	// Represents whether the pins have been loaded
+	loaded bool
+	// Represents the lock on the pins
+	mutex sync.Mutex
+}
+
+// Represents the pinned keys of the application
+var pins = &pinstore{}
+
+// A function that returns the path of the pinned keys
+func pinspath() string {
+	return peerchatpath("pins.json")
+}
+
+// A method of pinstore that loads the pinned keys if they have not
+// been loaded yet. Must be called with the pin store lock held.
+func (store *pinstore) load() {
+	if store.loaded {
+		return
+	}
+
+	store.loaded = true
+	store.pins = make(map[string]pin)
+	store.changed = make(map[string]peer.ID)
+
+	data, err := ioutil.ReadFile(pinspath())
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &store.pins); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  pinspath(),
+		}).Warnln("Failed to Parse the Pinned Keys!")
+	}
+}
+
+// A method of pinstore that saves the pinned keys.
+// Must be called with the pin store lock held.
+func (store *pinstore) save() {
+	data, err := json.MarshalIndent(store.pins, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(pinspath()), 0700); err == nil {
+			err = ioutil.WriteFile(pinspath(), data, 0600)
+		}
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  pinspath(),
+		}).Warnln("Failed to Save the Pinned Keys!")
+	}
+}
+
+// A method of pinstore that checks the key of a user name against its pinned
+// key, pinning it on first contact. The alias of a friend is pinned to the
+// friend. Returns the pinned key and true the first time a user name is seen
+// with a key that differs from its pinned key.
+func (store *pinstore) check(username string, peerid peer.ID) (peer.ID, bool) {
+	if username == "" {
+		return "", false
+	}
+
+	// A user name that is the alias of a friend must be the friend
+	for _, f := range friends.list() {
+		if f.Alias == username && f.PeerID != peerid.Pretty() {
+			return store.mismatch(username, f.PeerID, peerid)
+		}
+	}
+
+	store.mutex.Lock()
+	store.load()
+
+	pinned, ok := store.pins[username]
+	if !ok {
+		// Trust the key on first use
+		store.pins[username] = pin{PeerID: peerid.Pretty(), Pinned: time.Now()}
+		store.save()
+		store.mutex.Unlock()
+		return "", false
+	}
+	store.mutex.Unlock()
+
+	if pinned.PeerID == peerid.Pretty() {
+		return "", false
+	}
+
+	return store.mismatch(username, pinned.PeerID, peerid)
+}
+
+// A method of pinstore that records a key that differs from the pinned key of a
+// user name. Returns the pinned key and whether the difference is new.
+func (store *pinstore) mismatch(username string, pinned string, peerid peer.ID) (peer.ID, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	pinnedid, err := peer.Decode(pinned)
+	if err != nil {
+		return "", false
+	}
+
+	// Warn only once for each different key
+	if store.changed[username] == peerid {
+		return pinnedid, false
+	}
+	store.changed[username] = peerid
+
+	return pinnedid, true
+}
+
+// A method of pinstore that trusts the last different key seen for a user name,
+// replacing its pinned key. Returns false if no different key has been seen.
+func (store *pinstore) trust(username string) (peer.ID, bool) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	peerid, ok := store.changed[username]
+	if !ok {
+		return "", false
+	}
+
+	store.pins[username] = pin{PeerID: peerid.Pretty(), Pinned: time.Now()}
+	delete(store.changed, username)
+	store.save()
+
+	return peerid, true
+}
+
+// A method of pinstore that forgets the pinned keys of user names
+func (store *pinstore) forget(usernames ...string) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	for _, username := range usernames {
+		delete(store.pins, username)
+		delete(store.changed, username)
+	}
+	store.save()
+}
+
+// A method of pinstore that forgets every pinned key, so
+// that they are loaded again from the file when needed
+func (store *pinstore) reset() {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+
+	store.loaded = false
+}
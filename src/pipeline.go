@@ -0,0 +1,80 @@
+package src
+
+import (
+	"sync"
+)
+
+// A type that represents a transformer for outbound messages. It recieves
+// the room name and the message text and returns the transformed text.
+// The message is dropped if the transformer returns false.
+type OutboundTransformer func(room string, message string) (string, bool)
+
+// A type that represents a filter for inbound messages. It recieves the
+// room name and the chat message and returns the filtered chat message.
+// The message is dropped if the filter returns false.
+type InboundFilter func(room string, msg ChatMessage) (ChatMessage, bool)
+
+// A structure that represents the pipeline of
+// transformers and filters applied to chat messages
+type messagepipeline struct {
+	// Represents the outbound message transformers
+	outbound []OutboundTransformer
+	// Represents the inbound message filters
+	inbound []InboundFilter
+
+	// Represents the lock on the pipeline
+	mutex sync.RWMutex
+}
+
+// Represents the pipeline applied to the messages of all chat rooms
+var pipeline = &messagepipeline{}
+
+// A function that adds a transformer to the pipeline which is
+// applied to every message before it is published to a chat room
+func AddOutboundTransformer(transformer OutboundTransformer) {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+
+	pipeline.outbound = append(pipeline.outbound, transformer)
+}
+
+// A function that adds a filter to the pipeline which is applied
+// to every message recieved from a chat room before it is delivered
+func AddInboundFilter(filter InboundFilter) {
+	pipeline.mutex.Lock()
+	defer pipeline.mutex.Unlock()
+
+	pipeline.inbound = append(pipeline.inbound, filter)
+}
+
+// A method of messagepipeline that applies the outbound transformers in
+// order to a message. Returns false if any transformer drops the message.
+func (mp *messagepipeline) transform(room string, message string) (string, bool) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	for _, transformer := range mp.outbound {
+		var ok bool
+		if message, ok = transformer(room, message); !ok {
+			return "", false
+		}
+	}
+
+	return message, true
+}
+
+// A method of messagepipeline that applies the inbound filters in order
+// to a chat message. Returns false if any filter drops the message.
+func (mp *messagepipeline) filter(room string, msg ChatMessage) (ChatMessage, bool) {
+	mp.mutex.RLock()
+	defer mp.mutex.RUnlock()
+
+	for _, filter := range mp.inbound {
+		var ok bool
+		if msg, ok = filter(room, msg); !ok {
+			return ChatMessage{}, false
+		}
+	}
+
+	return msg, true
+}
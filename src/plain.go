@@ -0,0 +1,218 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// Represents the commands of the registry that the plain UI supports
+var plaincommands = []string{"/help", "/quit", "/room", "/user"}
+
+// A structure that represents the plain ChatRoom UI.
+// The plain UI bypasses tview and prints messages line-by-line
+// to stdout while reading input from stdin, so that it works
+// with screen readers and simple terminals.
+type PlainUI struct {
+	// Represents the ChatRoom (embedded)
+	*ChatRoom
+	// Represents the events the plain UI ignores (embedded)
+	NopEvents
+
+	// Represents the source of user input lines
+	input io.Reader
+	// Represents the destination of output lines
+	output io.Writer
+}
+
+// A constructor function that generates and
+// returns a new PlainUI for a given ChatRoom
+func NewPlainUI(cr *ChatRoom) *PlainUI {
+	return &PlainUI{
+		ChatRoom: cr,
+		input:    os.Stdin,
+		output:   os.Stdout,
+	}
+}
+
+// A method of PlainUI that starts the plain UI and
+// runs until the user quits or the input is closed
+func (pui *PlainUI) Run() error {
+	// Read the input lines in the background
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+
+		scanner := bufio.NewScanner(pui.input)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	defer pui.Exit()
+	fmt.Fprintf(pui.output, "Joined room %s as %s. Type /help for commands.\n", pui.RoomName, pui.UserName)
+
+	// Follow the events of the chat room, and of the rooms it is left for
+	events := pui.Events(context.Background())
+
+	for {
+		select {
+
+		case line, ok := <-lines:
+			// Exit if the input has closed
+			if !ok {
+				return nil
+			}
+
+			// Ignore empty lines
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+
+			// Check for command inputs
+			if strings.HasPrefix(line, "/") {
+				if quit := pui.handlecommand(line); quit {
+					return nil
+				}
+				continue
+			}
+
+			// Send the message to outbound queue
+			pui.Outbound <- line
+
+		case event, ok := <-events:
+			// Stop waiting for events once the chat room has closed
+			if !ok {
+				events = nil
+				continue
+			}
+
+			event(pui)
+
+		case <-pui.psctx.Done():
+			return nil
+		}
+	}
+}
+
+// A method of PlainUI that prints a message received in the chat room
+func (pui *PlainUI) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	// Show only that a whisper was sent, unless it was sent to the user
+	if msg.Whisper != nil && !msg.Whispered {
+		fmt.Fprintf(pui.output, "%s whispered to %d members\n", stripcontrols(msg.SenderName), len(msg.Whisper.Keys))
+		return
+	}
+
+	verb := "says"
+	if msg.Whispered {
+		verb = "whispers"
+	}
+
+	// Strip control characters so that peers cannot inject terminal escapes
+	fmt.Fprintf(pui.output, "%s %s: %s%s\n", stripcontrols(msg.SenderName), verb, tagprefix(msg.Tags), stripcontrols(msg.Message))
+}
+
+// A method of PlainUI that prints a log of the chat room
+func (pui *PlainUI) Error(cr *ChatRoom, err error) {
+	log := errorlog(err)
+	fmt.Fprintf(pui.output, "%s: %s\n", stripcontrols(log.logprefix), stripcontrols(log.logmsg))
+}
+
+// A method of PlainUI that handles a command line.
+// Returns true if the user has asked to quit.
+func (pui *PlainUI) handlecommand(line string) bool {
+	// Split the command from its argument
+	cmdparts := strings.SplitN(line, " ", 2)
+	if len(cmdparts) == 1 {
+		cmdparts = append(cmdparts, "")
+	}
+
+	cmd, arg := cmdparts[0], strings.TrimSpace(cmdparts[1])
+
+	// Resolve the aliases of the command
+	name := cmd
+	if command, ok := registry.lookup(cmd); ok {
+		name = command.Name
+	}
+
+	switch name {
+	case "/quit":
+		return true
+
+	case "/help":
+		pui.help(arg)
+
+	case "/room":
+		if arg == "" {
+			fmt.Fprintln(pui.output, "Missing room name for command.")
+			break
+		}
+
+		// Jump to the new chatroom
+		newchatroom, err := pui.Jump(context.Background(), arg)
+		if err != nil {
+			fmt.Fprintf(pui.output, "Could not change chat room: %s\n", err)
+			break
+		}
+
+		pui.ChatRoom = newchatroom
+		fmt.Fprintf(pui.output, "Joined room %s.\n", pui.RoomName)
+
+	case "/user":
+		if arg == "" {
+			fmt.Fprintln(pui.output, "Missing user name for command.")
+			break
+		}
+
+		// Set or clear the user name of the current room
+		if arg == "here" || strings.HasPrefix(arg, "here ") {
+			if err := pui.SetRoomNick(strings.TrimSpace(strings.TrimPrefix(arg, "here"))); err != nil {
+				fmt.Fprintf(pui.output, "Could not change user name: %s\n", err)
+				break
+			}
+
+			fmt.Fprintf(pui.output, "User name in room %s changed to %s.\n", pui.RoomName, pui.UserName)
+			break
+		}
+
+		pui.UpdateUser(arg)
+		fmt.Fprintf(pui.output, "User name changed to %s.\n", pui.UserName)
+
+	default:
+		fmt.Fprintf(pui.output, "Unsupported command %s. Type /help for commands.\n", cmd)
+	}
+
+	return false
+}
+
+// A method of PlainUI that prints the usage of the supported commands from the
+// command registry, or the usage and aliases of a single command if one is given
+func (pui *PlainUI) help(name string) {
+	for _, supported := range plaincommands {
+		command, ok := registry.lookup(supported)
+		if !ok {
+			continue
+		}
+
+		if name == "" {
+			fmt.Fprintf(pui.output, "%s - %s\n", command.usage(), command.Help)
+			continue
+		}
+
+		if resolved, ok := registry.lookup(name); ok && resolved == command {
+			fmt.Fprintf(pui.output, "%s - %s\n", command.usage(), command.Help)
+			if len(command.Aliases) > 0 {
+				fmt.Fprintf(pui.output, "Aliases: %s\n", strings.Join(command.Aliases, ", "))
+			}
+			return
+		}
+	}
+
+	if name != "" {
+		fmt.Fprintf(pui.output, "No help for unsupported command %s.\n", name)
+	}
+}
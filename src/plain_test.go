@@ -0,0 +1,54 @@
+package src
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestPlainHelp(t *testing.T) {
+	var output bytes.Buffer
+	pui := &PlainUI{output: &output}
+
+	if quit := pui.handlecommand("/help"); quit {
+		t.Fatal("/help asked to quit")
+	}
+
+	// Every supported command is listed with the usage of the registry
+	for _, name := range plaincommands {
+		command, ok := registry.lookup(name)
+		if !ok {
+			t.Fatalf("%s is not registered", name)
+		}
+		if !strings.Contains(output.String(), command.usage()+" - "+command.Help) {
+			t.Errorf("/help does not list %q, got:\n%s", command.usage(), output.String())
+		}
+	}
+
+	// The aliases of the registry resolve to the supported commands
+	output.Reset()
+	pui.handlecommand("/? nick")
+	if !strings.Contains(output.String(), "/user") {
+		t.Errorf("/? nick printed %q, want the usage of /user", output.String())
+	}
+
+	if quit := pui.handlecommand("/q"); !quit {
+		t.Error("/q did not ask to quit")
+	}
+}
+
+func TestPlainErrorStripsControls(t *testing.T) {
+	var output bytes.Buffer
+	pui := &PlainUI{output: &output}
+
+	pui.Error(nil, &ChatError{Prefix: "pub\x1b[2J", Message: "failed\x1b]0;pwned\x07\r\nfake line"})
+	pui.Error(nil, errors.New("\x1b[31mred"))
+
+	if strings.ContainsAny(output.String(), "\x1b\x07\r") {
+		t.Errorf("Error() printed control characters: %q", output.String())
+	}
+	if strings.Count(output.String(), "\n") != 2 {
+		t.Errorf("Error() printed %q, want one line per error", output.String())
+	}
+}
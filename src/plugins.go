@@ -0,0 +1,347 @@
+package src
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time to wait for a plugin to register itself
+const pluginregistertimeout = time.Second * 5
+
+// Represents the time to wait for a plugin to respond to a request
+const pluginrequesttimeout = time.Second * 2
+
+/*
+A structure that represents a message of the plugin protocol.
+
+Plugins are executables in the plugins directory (~/.peerchat/plugins)
+that are started as subprocesses and speak JSON lines over stdin/stdout.
+
+The first line written by a plugin must be a 'register' message with its
+name, its commands and whether it transforms outbound or filters inbound
+messages. The plugin then recieves 'command', 'outbound' and 'inbound'
+requests, each with an ID, and must reply with a message of the same ID.
+
+A reply to a 'command' request may list messages to 'send' to the room
+and lines to 'display' locally. A reply to an 'outbound' or 'inbound'
+request carries the (possibly rewritten) 'message' or sets 'drop'. A
+reply without a 'message' leaves the message unchanged.
+*/
+type pluginmessage struct {
+	ID   int    `json:"id,omitempty"`
+	Type string `json:"type,omitempty"`
+
+	// Fields of the register message
+	Name     string          `json:"name,omitempty"`
+	Commands []plugincommand `json:"commands,omitempty"`
+	Outbound bool            `json:"outbound,omitempty"`
+	Inbound  bool            `json:"inbound,omitempty"`
+
+	// Fields of the requests
+	Command    string  `json:"command,omitempty"`
+	Arg        string  `json:"arg,omitempty"`
+	Room       string  `json:"room,omitempty"`
+	User       string  `json:"user,omitempty"`
+	Message    *string `json:"message,omitempty"`
+	SenderID   string  `json:"senderid,omitempty"`
+	SenderName string  `json:"sendername,omitempty"`
+
+	// Fields of the replies
+	Drop    bool     `json:"drop,omitempty"`
+	Send    []string `json:"send,omitempty"`
+	Display []string `json:"display,omitempty"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// A structure that represents a command registered by a plugin
+type plugincommand struct {
+	Name    string   `json:"name"`
+	Aliases []string `json:"aliases,omitempty"`
+	Args    string   `json:"args,omitempty"`
+	Help    string   `json:"help,omitempty"`
+	Details string   `json:"details,omitempty"`
+}
+
+// A structure that represents a running plugin subprocess
+type plugin struct {
+	// Represents the registration of the plugin
+	manifest pluginmessage
+	// Represents the plugin subprocess
+	process *exec.Cmd
+	// Represents the encoder for the plugin's stdin
+	encoder *json.Encoder
+
+	// Represents the ID of the next request
+	nextid int
+	// Represents the channels awaiting replies by request ID
+	pending map[int]chan pluginmessage
+
+	// Represents the lock on the plugin state
+	mutex sync.Mutex
+}
+
+// Represents the plugins that have been loaded
+var plugins []*plugin
+
+// A function that loads all plugins in the plugins directory
+// (~/.peerchat/plugins). Each plugin's commands are added to the
+// command registry and its transformers and filters to the message
+// pipeline. Plugins that fail to start or register are skipped.
+func LoadPlugins() {
+	dir := currentconfig().pluginsdir()
+
+	// Read the plugins directory
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logrus.Debugf("No Plugins Loaded from %s.", dir)
+		return
+	}
+
+	for _, entry := range entries {
+		// Skip directories and non-executable files
+		if entry.IsDir() || (runtime.GOOS != "windows" && entry.Mode()&0111 == 0) {
+			continue
+		}
+
+		// Start the plugin
+		path := filepath.Join(dir, entry.Name())
+		p, err := startplugin(path)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"plugin": path,
+			}).Warnln("Failed to Load Plugin!")
+			continue
+		}
+
+		// Register the plugin's hooks
+		p.install()
+		plugins = append(plugins, p)
+
+		logrus.WithFields(logrus.Fields{
+			"plugin": p.manifest.Name,
+		}).Debugln("Loaded Plugin.")
+	}
+}
+
+// A function that stops all loaded plugins
+func StopPlugins() {
+	for _, p := range plugins {
+		p.process.Process.Kill()
+		p.process.Wait()
+	}
+
+	plugins = nil
+}
+
+// A function that starts a plugin subprocess and waits for its registration
+func startplugin(path string) (*plugin, error) {
+	p := &plugin{
+		process: exec.Command(path),
+		pending: make(map[int]chan pluginmessage),
+	}
+
+	// Setup the standard pipes of the subprocess
+	stdin, err := p.process.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	stdout, err := p.process.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+
+	p.process.Stderr = os.Stderr
+	p.encoder = json.NewEncoder(stdin)
+
+	// Start the subprocess
+	if err := p.process.Start(); err != nil {
+		return nil, err
+	}
+
+	// Read the registration and then the replies in the background
+	registered := make(chan pluginmessage, 1)
+	go p.readloop(stdout, registered)
+
+	select {
+	case manifest := <-registered:
+		if manifest.Name == "" {
+			manifest.Name = filepath.Base(path)
+		}
+
+		p.manifest = manifest
+		return p, nil
+
+	case <-time.After(pluginregistertimeout):
+		p.process.Process.Kill()
+		return nil, errors.New("plugin did not register in time")
+	}
+}
+
+// A method of plugin that reads the messages written by the plugin. The first
+// 'register' message is sent to the registered channel, while replies are
+// delivered to the requests awaiting them.
+func (p *plugin) readloop(stdout io.Reader, registered chan<- pluginmessage) {
+	scanner := bufio.NewScanner(stdout)
+	for scanner.Scan() {
+		var msg pluginmessage
+		if err := json.Unmarshal(scanner.Bytes(), &msg); err != nil {
+			continue
+		}
+
+		if msg.Type == "register" {
+			registered <- msg
+			continue
+		}
+
+		// Deliver the reply to the waiting request
+		p.mutex.Lock()
+		if reply, ok := p.pending[msg.ID]; ok {
+			reply <- msg
+			delete(p.pending, msg.ID)
+		}
+		p.mutex.Unlock()
+	}
+}
+
+// A method of plugin that sends a request to the plugin and waits for its reply
+func (p *plugin) request(req pluginmessage) (pluginmessage, error) {
+	reply := make(chan pluginmessage, 1)
+
+	// Assign an ID to the request and send it
+	p.mutex.Lock()
+	p.nextid++
+	req.ID = p.nextid
+	p.pending[req.ID] = reply
+	err := p.encoder.Encode(req)
+	p.mutex.Unlock()
+
+	if err != nil {
+		return pluginmessage{}, err
+	}
+
+	select {
+	case msg := <-reply:
+		if msg.Error != "" {
+			return msg, errors.New(msg.Error)
+		}
+		return msg, nil
+
+	case <-time.After(pluginrequesttimeout):
+		p.mutex.Lock()
+		delete(p.pending, req.ID)
+		p.mutex.Unlock()
+
+		return pluginmessage{}, fmt.Errorf("plugin '%s' did not reply in time", p.manifest.Name)
+	}
+}
+
+// A method of plugin that adds its commands to the command
+// registry and its transformers and filters to the pipeline
+func (p *plugin) install() {
+	for _, pc := range p.manifest.Commands {
+		name := pc.Name
+		if !strings.HasPrefix(name, "/") {
+			name = "/" + name
+		}
+
+		err := RegisterCommand(Command{
+			Name:    name,
+			Aliases: pc.Aliases,
+			Args:    pc.Args,
+			Help:    fmt.Sprintf("%s (plugin: %s)", pc.Help, p.manifest.Name),
+			Details: pc.Details,
+			Handler: p.commandhandler(name),
+		})
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"plugin": p.manifest.Name,
+			}).Warnln("Failed to Register Plugin Command!")
+		}
+	}
+
+	if p.manifest.Outbound {
+		AddOutboundTransformer(p.transform)
+	}
+
+	if p.manifest.Inbound {
+		AddInboundFilter(p.filter)
+	}
+}
+
+// A method of plugin that generates the handler for one of its commands
+func (p *plugin) commandhandler(name string) CommandHandler {
+	return func(ui *UI, arg string) {
+		reply, err := p.request(pluginmessage{Type: "command", Command: name, Arg: arg, Room: ui.RoomName, User: ui.UserName})
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "pluginerr", logmsg: err.Error()}
+			return
+		}
+
+		// Display the lines for the user
+		for _, line := range reply.Display {
+			ui.display_pluginmessage(p.manifest.Name, line)
+		}
+
+		// Send the messages to the room
+		for _, message := range reply.Send {
+			ui.sendtext(message)
+		}
+	}
+}
+
+// A method of plugin that transforms an outbound message.
+// The message is passed through unchanged if the plugin fails.
+func (p *plugin) transform(room string, message string) (string, bool) {
+	reply, err := p.request(pluginmessage{Type: "outbound", Room: room, Message: &message})
+	if err != nil {
+		return message, true
+	}
+
+	if reply.Drop {
+		return "", false
+	}
+
+	// Keep the message if the reply does not rewrite it
+	if reply.Message != nil {
+		message = *reply.Message
+	}
+
+	return message, true
+}
+
+// A method of plugin that filters an inbound message.
+// The message is passed through unchanged if the plugin fails.
+func (p *plugin) filter(room string, msg ChatMessage) (ChatMessage, bool) {
+	reply, err := p.request(pluginmessage{Type: "inbound", Room: room, Message: &msg.Message, SenderID: msg.SenderID, SenderName: msg.SenderName})
+	if err != nil {
+		return msg, true
+	}
+
+	if reply.Drop {
+		return ChatMessage{}, false
+	}
+
+	// Keep the message if the reply does not rewrite it
+	if reply.Message != nil {
+		msg.Message = *reply.Message
+	}
+
+	return msg, true
+}
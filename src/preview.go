@@ -0,0 +1,479 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+)
+
+// Represents the protocol ID for requesting the preview of a link from its sender
+const previewprotocol = protocol.ID("/peerchat/preview/1.0.0")
+
+// Represents the time allowed for fetching the preview of a link
+const previewtimeout = 10 * time.Second
+
+// Represents the maximum number of bytes of a page read for its preview
+const maxpreviewbody = 512 * 1024
+
+// Represents the maximum length of the title and description of a preview
+const maxpreviewtext = 200
+
+// Represents the time for which a node serves previews of the links it sent
+const previewrecent = 10 * time.Minute
+
+// Represents the time for which a fetched preview is kept
+const previewcachettl = time.Hour
+
+// Represents the maximum number of fetched previews kept
+const maxpreviewcache = 256
+
+// Represents the private networks that previews are never fetched from, besides
+// the loopback, link-local and unspecified addresses: RFC 1918, the shared address
+// space of carrier-grade NATs and the unique local IPv6 addresses
+var privatenetworks = func() []*net.IPNet {
+	var networks []*net.IPNet
+	for _, cidr := range []string{"10.0.0.0/8", "172.16.0.0/12", "192.168.0.0/16", "100.64.0.0/10", "fc00::/7"} {
+		_, network, _ := net.ParseCIDR(cidr)
+		networks = append(networks, network)
+	}
+
+	return networks
+}()
+
+// Represents the modes of link previews. Previews are not fetched when 'off'
+// (the default), fetched by the node itself when 'direct' (which reveals its
+// address to the linked site) and requested from the sender of the link when
+// 'sender' (who already knows the link). Nodes that fetch previews also serve
+// the previews of the links they sent recently.
+var previewmodes = []string{"off", "direct", "sender"}
+
+// Represents the pattern of links in messages
+var linkpattern = regexp.MustCompile(`https?://[^\s<>"'\x60]+`)
+
+// Represents the patterns of the title and description of a page
+var (
+	titlepattern = regexp.MustCompile(`(?is)<title[^>]*>(.*?)</title>`)
+	metapattern  = regexp.MustCompile(`(?is)<meta\s[^>]*>`)
+	attrpattern  = regexp.MustCompile(`(?is)([a-z:]+)\s*=\s*("[^"]*"|'[^']*')`)
+)
+
+// A structure that represents the preview of a link
+type LinkPreview struct {
+	// Represents the link
+	URL string `json:"url"`
+	// Represents the title of the page
+	Title string `json:"title,omitempty"`
+	// Represents the description of the page
+	Description string `json:"description,omitempty"`
+	// Represents the reason the preview could not be fetched
+	Error string `json:"error,omitempty"`
+}
+
+// A structure that represents a fetched preview and when it was fetched
+type cachedpreview struct {
+	preview *LinkPreview
+	fetched time.Time
+}
+
+// A structure that represents the link previews of a host
+type previewer struct {
+	// Represents the mode of link previews
+	mode string
+	// Represents the fetched previews indexed by link
+	cache map[string]cachedpreview
+	// Represents the links sent by the host and when they were sent
+	recent map[string]time.Time
+	// Represents the lock on the previews
+	mutex sync.Mutex
+}
+
+// A constructor function that generates and returns a previewer for a mode
+func newpreviewer(mode string) *previewer {
+	if mode == "" {
+		mode = "off"
+	}
+
+	return &previewer{
+		mode:   mode,
+		cache:  make(map[string]cachedpreview),
+		recent: make(map[string]time.Time),
+	}
+}
+
+// A function that checks a mode of link previews
+func validpreviewmode(mode string) bool {
+	if mode == "" {
+		return true
+	}
+
+	for _, valid := range previewmodes {
+		if mode == valid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A function that returns the first link in a message
+func firstlink(message string) (string, bool) {
+	link := linkpattern.FindString(message)
+	// Drop the punctuation that commonly follows a link in a sentence
+	link = strings.TrimRight(link, ".,;:!?)]}")
+
+	return link, link != ""
+}
+
+// A method of P2P that sets the mode of link previews ('off', 'direct' or 'sender')
+func (p2p *P2P) SetPreviews(mode string) error {
+	if !validpreviewmode(mode) {
+		return fmt.Errorf("invalid link preview mode '%s' (%s)", mode, strings.Join(previewmodes, ", "))
+	}
+	if mode == "" {
+		mode = "off"
+	}
+
+	p2p.previews.mutex.Lock()
+	defer p2p.previews.mutex.Unlock()
+
+	p2p.previews.mode = mode
+	return nil
+}
+
+// A method of P2P that returns the mode of link previews
+func (p2p *P2P) previewmode() string {
+	p2p.previews.mutex.Lock()
+	defer p2p.previews.mutex.Unlock()
+
+	return p2p.previews.mode
+}
+
+// A method of P2P that remembers the links of a message sent by the
+// host, so that their previews can be served to the receivers
+func (p2p *P2P) rememberlinks(message string) {
+	links := linkpattern.FindAllString(message, 8)
+	if len(links) == 0 {
+		return
+	}
+
+	p2p.previews.mutex.Lock()
+	defer p2p.previews.mutex.Unlock()
+
+	now := time.Now()
+	for link, sent := range p2p.previews.recent {
+		if now.Sub(sent) > previewrecent {
+			delete(p2p.previews.recent, link)
+		}
+	}
+
+	for _, link := range links {
+		p2p.previews.recent[strings.TrimRight(link, ".,;:!?)]}")] = now
+	}
+}
+
+// A method of P2P that returns the preview of a link sent by a peer, fetching
+// it directly or requesting it from the sender depending on the preview mode.
+// Previews are cached for a while, and nil is returned if previews are off.
+func (p2p *P2P) LinkPreview(sender peer.ID, link string) (*LinkPreview, error) {
+	p2p.previews.mutex.Lock()
+	mode := p2p.previews.mode
+	cached, ok := p2p.previews.cache[link]
+	p2p.previews.mutex.Unlock()
+
+	if ok && time.Since(cached.fetched) < previewcachettl {
+		return cached.preview, nil
+	}
+
+	ctx, cancel := context.WithTimeout(p2p.Ctx, previewtimeout)
+	defer cancel()
+
+	var preview *LinkPreview
+	var err error
+
+	switch mode {
+	case "direct":
+		preview, err = fetchpreview(ctx, link)
+	case "sender":
+		preview, err = p2p.requestpreview(ctx, sender, link)
+	default:
+		return nil, nil
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	p2p.previews.store(link, preview)
+	return preview, nil
+}
+
+// A method of previewer that caches the preview of a link. The expired
+// previews are dropped, and then the oldest one if the cache is full.
+func (previews *previewer) store(link string, preview *LinkPreview) {
+	previews.mutex.Lock()
+	defer previews.mutex.Unlock()
+
+	now := time.Now()
+	oldest := ""
+	for cachedlink, cached := range previews.cache {
+		if now.Sub(cached.fetched) >= previewcachettl {
+			delete(previews.cache, cachedlink)
+			continue
+		}
+		if oldest == "" || cached.fetched.Before(previews.cache[oldest].fetched) {
+			oldest = cachedlink
+		}
+	}
+
+	if _, ok := previews.cache[link]; !ok && len(previews.cache) >= maxpreviewcache {
+		delete(previews.cache, oldest)
+	}
+
+	previews.cache[link] = cachedpreview{preview: preview, fetched: now}
+}
+
+// A method of P2P that requests the preview of a link from its sender
+func (p2p *P2P) requestpreview(ctx context.Context, sender peer.ID, link string) (*LinkPreview, error) {
+	stream, err := p2p.Host.NewStream(ctx, sender, previewprotocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		stream.SetDeadline(deadline)
+	}
+
+	if err := json.NewEncoder(stream).Encode(LinkPreview{URL: link}); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	var preview LinkPreview
+	if err := json.NewDecoder(io.LimitReader(stream, 16*1024)).Decode(&preview); err != nil {
+		return nil, err
+	}
+
+	if preview.Error != "" {
+		return nil, errors.New(preview.Error)
+	}
+
+	preview.URL = link
+	return &preview, nil
+}
+
+// A method of P2P that answers requests for the previews of links. Only the
+// links that the host sent recently are fetched, and only if it fetches
+// previews itself, so that it cannot be used as an open proxy.
+func (p2p *P2P) handlepreview(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(previewtimeout + 5*time.Second))
+
+	var request LinkPreview
+	if err := json.NewDecoder(io.LimitReader(stream, 16*1024)).Decode(&request); err != nil {
+		stream.Reset()
+		return
+	}
+
+	p2p.previews.mutex.Lock()
+	mode := p2p.previews.mode
+	_, sent := p2p.previews.recent[request.URL]
+	p2p.previews.mutex.Unlock()
+
+	var reply *LinkPreview
+	switch {
+	case mode == "off":
+		reply = &LinkPreview{Error: "the sender does not serve link previews"}
+	case !sent:
+		reply = &LinkPreview{Error: "the link was not sent recently"}
+	default:
+		ctx, cancel := context.WithTimeout(p2p.Ctx, previewtimeout)
+		defer cancel()
+
+		preview, err := fetchpreview(ctx, request.URL)
+		if err != nil {
+			reply = &LinkPreview{Error: err.Error()}
+		} else {
+			reply = preview
+		}
+	}
+
+	json.NewEncoder(stream).Encode(reply)
+}
+
+// A function that returns an HTTP transport that dials through the proxy or
+// Tor of the configuration if one is used, with a timeout for the response headers
+func proxiedtransport(timeout time.Duration) *http.Transport {
+	transport := &http.Transport{ResponseHeaderTimeout: timeout}
+
+	cfg := currentconfig()
+	proxy := cfg.proxy()
+	if cfg.Tor.Enabled {
+		proxy = newonionservice(cfg.Tor).proxy()
+	}
+	if proxy != nil {
+		transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+			return proxy.dial(ctx, addr)
+		}
+	}
+
+	return transport
+}
+
+// A function that checks if an address is on the host or its local networks,
+// which link previews must not reach so that a link cannot probe them
+func internalip(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsUnspecified() {
+		return true
+	}
+
+	for _, network := range privatenetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A function that checks the scheme and host of the link of a preview. Hosts
+// named by an internal address or as localhost are refused, and the names of
+// other hosts are checked once resolved, when they are dialed directly.
+func checkpreviewlink(link *url.URL) error {
+	if link.Scheme != "http" && link.Scheme != "https" {
+		return fmt.Errorf("links with the scheme '%s' are not previewed", link.Scheme)
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(link.Hostname(), "."))
+	if ip := net.ParseIP(host); (ip != nil && internalip(ip)) || host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return errors.New("links to local addresses are not previewed")
+	}
+
+	return nil
+}
+
+// A function that fetches a page and returns the preview of its title and
+// description. The page is fetched through the proxy or Tor if one is used.
+// Links to the host and its local networks are refused, even after redirects.
+func fetchpreview(ctx context.Context, link string) (*LinkPreview, error) {
+	transport := proxiedtransport(previewtimeout)
+	if transport.DialContext == nil {
+		// Check the addresses that are dialed, once the names are resolved
+		dialer := &net.Dialer{
+			Timeout: previewtimeout,
+			Control: func(network, address string, conn syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if ip := net.ParseIP(host); ip == nil || internalip(ip) {
+					return errors.New("links to local addresses are not previewed")
+				}
+				return nil
+			},
+		}
+		transport.DialContext = dialer.DialContext
+	}
+
+	client := &http.Client{
+		Transport: transport,
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= 3 {
+				return errors.New("too many redirects")
+			}
+			return checkpreviewlink(req.URL)
+		},
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, link, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkpreviewlink(request.URL); err != nil {
+		return nil, err
+	}
+	request.Header.Set("User-Agent", AgentVersion())
+	request.Header.Set("Accept", "text/html")
+
+	response, err := client.Do(request)
+	if err != nil {
+		return nil, err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("page returned %s", response.Status)
+	}
+	if !strings.Contains(response.Header.Get("Content-Type"), "html") {
+		return nil, errors.New("page is not html")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(response.Body, maxpreviewbody))
+	if err != nil {
+		return nil, err
+	}
+
+	preview := parsepreview(string(body))
+	preview.URL = link
+	return preview, nil
+}
+
+// A function that parses the title and description of an html page,
+// preferring the Open Graph properties over the title and description
+func parsepreview(page string) *LinkPreview {
+	preview := &LinkPreview{}
+
+	if match := titlepattern.FindStringSubmatch(page); match != nil {
+		preview.Title = match[1]
+	}
+
+	for _, meta := range metapattern.FindAllString(page, -1) {
+		attrs := make(map[string]string)
+		for _, attr := range attrpattern.FindAllStringSubmatch(meta, -1) {
+			attrs[strings.ToLower(attr[1])] = strings.Trim(attr[2], `"'`)
+		}
+
+		name := strings.ToLower(attrs["property"] + attrs["name"])
+		switch name {
+		case "og:title":
+			preview.Title = attrs["content"]
+		case "og:description":
+			preview.Description = attrs["content"]
+		case "description":
+			if preview.Description == "" {
+				preview.Description = attrs["content"]
+			}
+		}
+	}
+
+	preview.Title = previewtext(preview.Title)
+	preview.Description = previewtext(preview.Description)
+	return preview
+}
+
+// A function that unescapes, collapses and shortens the text of a preview
+func previewtext(text string) string {
+	text = strings.Join(strings.Fields(html.UnescapeString(text)), " ")
+
+	if runes := []rune(text); len(runes) > maxpreviewtext {
+		text = string(runes[:maxpreviewtext-1]) + "…"
+	}
+
+	return text
+}
@@ -0,0 +1,97 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestInternalIP(t *testing.T) {
+	tests := []struct {
+		ip   string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"172.32.0.1", false},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"fe80::1", true},
+		{"fd00::1", true},
+		{"0.0.0.0", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1::", false},
+	}
+
+	for _, test := range tests {
+		if got := internalip(net.ParseIP(test.ip)); got != test.want {
+			t.Errorf("internalip(%s) = %v, want %v", test.ip, got, test.want)
+		}
+	}
+}
+
+func TestCheckPreviewLink(t *testing.T) {
+	tests := []struct {
+		link string
+		ok   bool
+	}{
+		{"https://example.com/page", true},
+		{"http://93.184.216.34/", true},
+		{"http://localhost:8080/", false},
+		{"http://api.localhost./", false},
+		{"http://127.0.0.1/", false},
+		{"http://[::1]:80/", false},
+		{"http://169.254.169.254/latest/meta-data", false},
+		{"file:///etc/passwd", false},
+	}
+
+	for _, test := range tests {
+		link, err := url.Parse(test.link)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := checkpreviewlink(link); (err == nil) != test.ok {
+			t.Errorf("checkpreviewlink(%s) returned %v, want success %v", test.link, err, test.ok)
+		}
+	}
+}
+
+func TestFetchPreviewRefusesLocal(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<title>secret</title>")
+	}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := fetchpreview(ctx, server.URL); err == nil {
+		t.Error("the preview of a loopback link was fetched")
+	}
+}
+
+func TestPreviewCache(t *testing.T) {
+	previews := newpreviewer("direct")
+
+	for i := 0; i < maxpreviewcache+10; i++ {
+		previews.store(fmt.Sprintf("https://example.com/%d", i), &LinkPreview{})
+	}
+	if len(previews.cache) > maxpreviewcache {
+		t.Errorf("the cache holds %d previews, want at most %d", len(previews.cache), maxpreviewcache)
+	}
+
+	// Expired previews are dropped
+	previews.cache["https://example.com/0"] = cachedpreview{preview: &LinkPreview{}, fetched: time.Now().Add(-2 * previewcachettl)}
+	previews.store("https://example.com/new", &LinkPreview{})
+	if _, ok := previews.cache["https://example.com/0"]; ok {
+		t.Error("an expired preview was kept")
+	}
+}
@@ -0,0 +1,86 @@
+package src
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sync"
+)
+
+// Represents the pattern of valid profile names
+var profilename = regexp.MustCompile(`^[A-Za-z0-9_-]{1,32}$`)
+
+// Represents the selected profile (empty for the default profile), the profile
+// requested by /profile to switch to and the lock on them
+var (
+	profile       string
+	profileswitch string
+	profilelock   sync.Mutex
+)
+
+// A function that selects the profile whose identity, configuration and local
+// data are used (~/.peerchat/profiles/<name>). Must be called before the
+// configuration is loaded. An empty name or 'default' selects the default profile (~/.peerchat).
+func SetProfile(name string) error {
+	if name != "" && !profilename.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s' (letters, digits, '-' and '_' only)", name)
+	}
+
+	if name == "default" {
+		name = ""
+	}
+
+	profilelock.Lock()
+	defer profilelock.Unlock()
+
+	profile = name
+	return nil
+}
+
+// A function that returns the name of the selected profile (empty for the default profile)
+func ProfileName() string {
+	profilelock.Lock()
+	defer profilelock.Unlock()
+
+	return profile
+}
+
+// A function that returns the profile requested by /profile, if any,
+// so that the application can restart with it after the UI exits
+func ProfileSwitch() (string, bool) {
+	profilelock.Lock()
+	defer profilelock.Unlock()
+
+	return profileswitch, profileswitch != ""
+}
+
+// A function that requests the application to restart with a profile
+func requestprofile(name string) error {
+	if !profilename.MatchString(name) {
+		return fmt.Errorf("invalid profile name '%s' (letters, digits, '-' and '_' only)", name)
+	}
+
+	profilelock.Lock()
+	defer profilelock.Unlock()
+
+	profileswitch = name
+	return nil
+}
+
+// A function that returns the names of the existing profiles
+func listprofiles() []string {
+	entries, err := ioutil.ReadDir(filepath.Join(basedir(), "profiles"))
+	if err != nil {
+		return nil
+	}
+
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && profilename.MatchString(entry.Name()) {
+			names = append(names, entry.Name())
+		}
+	}
+
+	return names
+}
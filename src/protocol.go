@@ -0,0 +1,187 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the version of the chat protocol spoken by the application.
+// Messages without a protocol version are from clients that predate it.
+const protocolversion = 2
+
+// Represents the protocol version assumed for messages without one
+const legacyprotocol = 1
+
+// Represents the protocol ID of the capability handshake
+const helloprotocol = protocol.ID("/peerchat/hello/1.0.0")
+
+// Represents the time allowed for a capability handshake
+const hellotimeout = 10 * time.Second
+
+// Represents the capabilities supported by the application,
+// which are exchanged with other peers in the capability handshake
+var capabilities = []string{"hello", "chunks", versionedtopics}
+
+// A structure that represents the capability handshake of a peer
+type hello struct {
+	// Represents the chat protocol version of the peer
+	Protocol int `json:"protocol"`
+	// Represents the agent version of the peer
+	Agent string `json:"agent"`
+	// Represents the capabilities of the peer
+	Capabilities []string `json:"capabilities"`
+}
+
+// A structure that represents the known protocol details of the peers
+type peerprotocols struct {
+	// Represents the handshakes of the peers
+	hellos map[peer.ID]*hello
+	// Represents the peers that have been warned about
+	warned map[peer.ID]bool
+	// Represents the peers that have been warned about for missing features
+	incompatible map[peer.ID]bool
+	// Represents the lock on the protocol details
+	mutex sync.Mutex
+}
+
+// A constructor function that creates an empty set of peer protocol details
+func newpeerprotocols() *peerprotocols {
+	return &peerprotocols{
+		hellos:       make(map[peer.ID]*hello),
+		warned:       make(map[peer.ID]bool),
+		incompatible: make(map[peer.ID]bool),
+	}
+}
+
+// A function that returns the capability handshake of the application
+func ownhello() hello {
+	return hello{
+		Protocol:     protocolversion,
+		Agent:        AgentVersion(),
+		Capabilities: capabilities,
+	}
+}
+
+// A method of ChatMessage that returns the protocol version of the message
+func (msg ChatMessage) protocol() int {
+	if msg.Protocol == 0 {
+		return legacyprotocol
+	}
+
+	return msg.Protocol
+}
+
+// A method of P2P that handles an incoming capability handshake
+// by reading the handshake of the peer and replying with its own
+func (p2p *P2P) handlehello(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(hellotimeout))
+
+	var remote hello
+	if err := json.NewDecoder(stream).Decode(&remote); err != nil {
+		stream.Reset()
+		return
+	}
+	p2p.sethello(stream.Conn().RemotePeer(), remote)
+
+	json.NewEncoder(stream).Encode(ownhello())
+}
+
+// A method of P2P that performs the capability handshake with a peer
+func (p2p *P2P) handshake(peerid peer.ID) {
+	ctx, cancel := context.WithTimeout(p2p.Ctx, hellotimeout)
+	defer cancel()
+
+	// Peers that predate the handshake do not support its protocol
+	stream, err := p2p.Host.NewStream(ctx, peerid, helloprotocol)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  peerid.Pretty(),
+		}).Debugln("Failed to Handshake with a Peer!")
+		return
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(hellotimeout))
+
+	var remote hello
+	err = json.NewEncoder(stream).Encode(ownhello())
+	if err == nil {
+		err = json.NewDecoder(stream).Decode(&remote)
+	}
+
+	if err != nil {
+		stream.Reset()
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  peerid.Pretty(),
+		}).Debugln("Failed to Handshake with a Peer!")
+		return
+	}
+
+	p2p.sethello(peerid, remote)
+
+	// Retrieve the profile and avatar of the peer in advance
+	if _, err := p2p.PeerAvatar(peerid); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  peerid.Pretty(),
+		}).Debugln("Failed to Retrieve the Avatar of a Peer!")
+	}
+}
+
+// A method of P2P that records the capability handshake of a peer
+func (p2p *P2P) sethello(peerid peer.ID, remote hello) {
+	p2p.protocols.mutex.Lock()
+	defer p2p.protocols.mutex.Unlock()
+
+	if remote.Protocol == 0 {
+		remote.Protocol = legacyprotocol
+	}
+
+	p2p.protocols.hellos[peerid] = &remote
+}
+
+// A method of P2P that returns the capability handshake of a peer, if known
+func (p2p *P2P) peerhello(peerid peer.ID) (hello, bool) {
+	p2p.protocols.mutex.Lock()
+	defer p2p.protocols.mutex.Unlock()
+
+	remote, ok := p2p.protocols.hellos[peerid]
+	if !ok {
+		return hello{}, false
+	}
+
+	return *remote, true
+}
+
+// A method of P2P that observes the protocol version of a message from
+// a peer, starting a capability handshake with peers that are new.
+// Returns true the first time a peer is seen speaking a newer protocol.
+func (p2p *P2P) observe(peerid peer.ID, version int) bool {
+	p2p.protocols.mutex.Lock()
+	defer p2p.protocols.mutex.Unlock()
+
+	remote, ok := p2p.protocols.hellos[peerid]
+	if !ok {
+		remote = &hello{Protocol: version}
+		p2p.protocols.hellos[peerid] = remote
+		go p2p.handshake(peerid)
+	} else if version > remote.Protocol {
+		remote.Protocol = version
+	}
+
+	if remote.Protocol > protocolversion && !p2p.protocols.warned[peerid] {
+		p2p.protocols.warned[peerid] = true
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,111 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	host "github.com/libp2p/go-libp2p-host"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the kinds of PubSub traces: JSON or protobuf trace files, or a remote tracer
+var tracekinds = []string{"json", "pb", "remote"}
+
+// An interface that represents a tracer of the PubSub events of the host
+type pubsubtracer interface {
+	pubsub.EventTracer
+	// Flushes and closes the trace
+	Close()
+}
+
+// Represents the PubSub trace of the host as <kind>:<target> (empty if not traced)
+var pubsubtrace string
+
+// A function that sets the PubSub trace of the host, which captures the publish,
+// deliver and drop events of the messages for offline analysis. The trace is
+// 'json:<file>' or 'pb:<file>' for a trace file, 'remote:<multiaddr>' for a remote
+// tracer, or empty for none. Must be done before the host is created.
+func SetPubSubTrace(trace string) error {
+	if trace != "" {
+		if _, _, err := parsetrace(trace); err != nil {
+			return err
+		}
+	}
+
+	pubsubtrace = trace
+	return nil
+}
+
+// A function that parses a PubSub trace into its kind and its target
+func parsetrace(trace string) (string, string, error) {
+	index := strings.IndexByte(trace, ':')
+	if index < 0 || index == len(trace)-1 {
+		return "", "", fmt.Errorf("invalid pubsub trace '%s' (<kind>:<target> where kind is %s)", trace, strings.Join(tracekinds, ", "))
+	}
+
+	kind, target := trace[:index], trace[index+1:]
+	switch kind {
+	case "json", "pb":
+	case "remote":
+		if _, err := tracepeer(target); err != nil {
+			return "", "", fmt.Errorf("invalid remote tracer '%s' - %s", target, err)
+		}
+	default:
+		return "", "", fmt.Errorf("invalid pubsub trace kind '%s' (%s)", kind, strings.Join(tracekinds, ", "))
+	}
+
+	return kind, target, nil
+}
+
+// A function that returns the peer of a remote tracer from its multiaddr, which must include its peer ID
+func tracepeer(addr string) (*peer.AddrInfo, error) {
+	muladdr, err := multiaddr.NewMultiaddr(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	return peer.AddrInfoFromP2pAddr(muladdr)
+}
+
+// A function that opens the PubSub trace of the host, or returns nil if the host
+// isn't traced. A trace that cannot be opened is logged and left out, since tracing
+// is only a diagnostic.
+func opentracer(ctx context.Context, nodehost host.Host) pubsubtracer {
+	if pubsubtrace == "" {
+		return nil
+	}
+
+	kind, target, _ := parsetrace(pubsubtrace)
+
+	var tracer pubsubtracer
+	var err error
+	switch kind {
+	case "json":
+		tracer, err = pubsub.NewJSONTracer(target)
+	case "pb":
+		tracer, err = pubsub.NewPBTracer(target)
+	case "remote":
+		var info *peer.AddrInfo
+		if info, err = tracepeer(target); err == nil {
+			tracer, err = pubsub.NewRemoteTracer(ctx, nodehost, *info)
+		}
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"trace": pubsubtrace,
+		}).Warnln("Failed to Open the PubSub Trace!")
+		return nil
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"trace": pubsubtrace,
+	}).Infoln("Tracing the PubSub Events.")
+
+	return tracer
+}
@@ -0,0 +1,202 @@
+package src
+
+import (
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the time the host waits after losing all its connections before it
+// reconnects, and the backoff between the attempts, which doubles up to the maximum
+const (
+	reconnectdelay      = 5 * time.Second
+	maxreconnectbackoff = 2 * time.Minute
+)
+
+// A structure that represents the watcher of the connectivity of a host, which
+// reconnects the host to the network when it loses all its connections, such
+// as when a laptop sleeps or switches between networks
+type netwatcher struct {
+	// Represents the signal that the host lost all its connections
+	lost chan struct{}
+	// Represents the discovery method the host connected to the peers with
+	discovery string
+	// Represents the channel closed when the host reconnects, replaced after each reconnect
+	reconnected chan struct{}
+	// Represents the lock on the discovery method and the reconnected channel
+	lock sync.Mutex
+}
+
+// A constructor function that returns a netwatcher
+func newnetwatcher() *netwatcher {
+	return &netwatcher{lost: make(chan struct{}, 1), reconnected: make(chan struct{})}
+}
+
+// A method of P2P that records the discovery method the host connected to the peers
+// with ('advertise', 'announce' or 'tracker'), which is repeated on a reconnect
+func (p2p *P2P) setdiscovery(method string) {
+	p2p.netwatch.lock.Lock()
+	defer p2p.netwatch.lock.Unlock()
+
+	p2p.netwatch.discovery = method
+}
+
+// A method of P2P that returns a channel that is closed the next time the
+// host reconnects to the network after losing all its connections
+func (p2p *P2P) watchreconnect() <-chan struct{} {
+	p2p.netwatch.lock.Lock()
+	defer p2p.netwatch.lock.Unlock()
+
+	return p2p.netwatch.reconnected
+}
+
+// A method of P2P that returns the notifiee which signals the
+// network watcher when the last connection of the host closes
+func (p2p *P2P) netnotifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		DisconnectedF: func(net network.Network, conn network.Conn) {
+			if len(net.Conns()) > 0 {
+				return
+			}
+
+			select {
+			case p2p.netwatch.lost <- struct{}{}:
+			default:
+			}
+		},
+	}
+}
+
+// A method of P2P that reconnects the host to the network each time it loses all
+// its connections. The host bootstraps again until it reaches a bootstrap peer,
+// then repeats its discovery and redials the known peers of its joined rooms.
+func (p2p *P2P) watchnetwork() {
+	p2p.Host.Network().Notify(p2p.netnotifiee())
+
+	for {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case <-p2p.netwatch.lost:
+		}
+
+		logrus.Warnln("Lost all the Connections, Reconnecting to the Network!")
+		if p2p.reconnect() {
+			p2p.rediscover()
+			p2p.redialrooms()
+
+			p2p.netwatch.lock.Lock()
+			close(p2p.netwatch.reconnected)
+			p2p.netwatch.reconnected = make(chan struct{})
+			p2p.netwatch.lock.Unlock()
+
+			logrus.WithFields(logrus.Fields{
+				"peers": len(p2p.Host.Network().Peers()),
+			}).Infoln("Reconnected to the Network.")
+		}
+	}
+}
+
+// A method of P2P that bootstraps the host again, with an exponential backoff, until it
+// has connections. Returns false if the host is closed before it reconnects.
+func (p2p *P2P) reconnect() bool {
+	backoff := reconnectdelay
+	for {
+		select {
+		case <-p2p.Ctx.Done():
+			return false
+		case <-time.After(backoff):
+		}
+
+		// The connections may have come back on their own, such as from peers dialing in
+		if len(p2p.Host.Network().Conns()) == 0 {
+			bootstrapDHT(p2p.Ctx, p2p.Host, p2p.KadDHT)
+		}
+		if len(p2p.Host.Network().Conns()) > 0 {
+			// Refill the routing table of the DHT, which emptied with the connections
+			p2p.KadDHT.RefreshRoutingTable()
+			return true
+		}
+
+		logrus.WithFields(logrus.Fields{
+			"retry": backoff,
+		}).Debugln("Failed to Reconnect to the Network!")
+
+		if backoff *= 2; backoff > maxreconnectbackoff {
+			backoff = maxreconnectbackoff
+		}
+	}
+}
+
+// A method of P2P that repeats the discovery the host connected to the peers with,
+// advertising the host again and connecting to the peers it finds. Unlike at the
+// startup, a failed discovery is only logged, since the host is connected again.
+func (p2p *P2P) rediscover() {
+	p2p.netwatch.lock.Lock()
+	method := p2p.netwatch.discovery
+	p2p.netwatch.lock.Unlock()
+
+	var err error
+	switch method {
+	case "advertise":
+		if _, err = p2p.Discovery.Advertise(p2p.Ctx, servicename()); err == nil {
+			if peerchan, ferr := p2p.Discovery.FindPeers(p2p.Ctx, servicename()); ferr == nil {
+				go p2p.handlediscovery(peerchan)
+			} else {
+				err = ferr
+			}
+		}
+
+	case "announce":
+		cidvalue := generateCID(servicename())
+		if err = p2p.provide(p2p.Ctx, cidvalue); err == nil {
+			go p2p.handlediscovery(p2p.KadDHT.FindProvidersAsync(p2p.Ctx, cidvalue, 0))
+		}
+
+	case "tracker":
+		// The tracker discovery renews its registration and looks for peers on its own
+		return
+
+	default:
+		return
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":     err.Error(),
+			"discovery": method,
+		}).Warnln("Failed to Rediscover the Service Peers!")
+	}
+}
+
+// A method of P2P that redials the known peers of the rooms joined on the host
+func (p2p *P2P) redialrooms() {
+	p2p.topiclock.Lock()
+	var rooms []string
+	for topic := range p2p.topics {
+		if room, ok := topicroom(topic); ok {
+			rooms = append(rooms, room)
+		}
+	}
+	p2p.topiclock.Unlock()
+
+	for _, room := range rooms {
+		p2p.redialroom(room)
+	}
+}
+
+// A method of ChatRoom that posts a log line each time the host
+// reconnects to the network, until the chat room is exited
+func (cr *ChatRoom) reconnectloop() {
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+
+		case <-cr.Host.watchreconnect():
+			cr.log(logrus.InfoLevel, "network", "reconnected to the network, redialing the peers of the room")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package src
+
+import (
+	"errors"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/control"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the interval between the checks of the memory used by the process
+const memoryinterval = 10 * time.Second
+
+// A structure that represents the limits on the resources that peers can make
+// the host use, so that a hostile peer opening thousands of connections or
+// streams cannot exhaust the memory of the client. A limit of 0 is no limit.
+type ResourceLimits struct {
+	// Represents the maximum number of open connections, beyond which inbound connections are refused
+	Connections int
+	// Represents the maximum number of open streams, beyond which inbound streams are reset
+	Streams int
+	// Represents the maximum number of open streams with a single peer
+	PeerStreams int
+	// Represents the heap size in megabytes beyond which connections are trimmed
+	Memory int
+}
+
+// Represents the default limits on the resources of the host
+var defaultlimits = ResourceLimits{Connections: 512, Streams: 4096, PeerStreams: 128, Memory: 1024}
+
+// Represents the limits on the resources of the host
+var limits = defaultlimits
+
+// A function that returns the default limits on the resources of the host
+func DefaultResourceLimits() ResourceLimits {
+	return defaultlimits
+}
+
+// A function that sets the limits on the resources of the
+// host, which must be done before the host is created
+func SetResourceLimits(resources ResourceLimits) error {
+	if resources.Connections < 0 || resources.Streams < 0 || resources.PeerStreams < 0 || resources.Memory < 0 {
+		return errors.New("the resource limits cannot be negative")
+	}
+
+	limits = resources
+	return nil
+}
+
+// A structure that represents a connection gater that refuses inbound
+// connections once the host has as many connections as the limit
+type connlimiter struct {
+	// Represents the network of the host, set once the host is created
+	network network.Network
+	// Represents the lock on the network
+	lock sync.RWMutex
+}
+
+// A method of connlimiter that counts the connections of the network of a created host
+func (limiter *connlimiter) attach(net network.Network) {
+	limiter.lock.Lock()
+	defer limiter.lock.Unlock()
+
+	limiter.network = net
+}
+
+// A method of connlimiter that allows dialing any peer
+func (limiter *connlimiter) InterceptPeerDial(peer.ID) bool { return true }
+
+// A method of connlimiter that allows dialing any address
+func (limiter *connlimiter) InterceptAddrDial(peer.ID, multiaddr.Multiaddr) bool { return true }
+
+// A method of connlimiter that refuses inbound connections beyond the connection limit
+func (limiter *connlimiter) InterceptAccept(addrs network.ConnMultiaddrs) bool {
+	limiter.lock.RLock()
+	net := limiter.network
+	limiter.lock.RUnlock()
+
+	if limits.Connections == 0 || net == nil {
+		return true
+	}
+
+	if len(net.Conns()) >= limits.Connections {
+		logrus.WithFields(logrus.Fields{
+			"address": addrs.RemoteMultiaddr().String(),
+			"limit":   limits.Connections,
+		}).Debugln("Refused a Connection over the Limit.")
+		return false
+	}
+
+	return true
+}
+
+// A method of connlimiter that allows any secured connection
+func (limiter *connlimiter) InterceptSecured(network.Direction, peer.ID, network.ConnMultiaddrs) bool {
+	return true
+}
+
+// A method of connlimiter that allows any upgraded connection
+func (limiter *connlimiter) InterceptUpgraded(network.Conn) (bool, control.DisconnectReason) {
+	return true, 0
+}
+
+// A method of P2P that returns the notifiee which resets the inbound
+// streams that go over the limits on the streams of the host
+func (p2p *P2P) streamlimiter() network.Notifiee {
+	return &network.NotifyBundle{OpenedStreamF: p2p.limitstream}
+}
+
+// A method of P2P that resets an inbound stream if the host has as many
+// streams as the limit, in total or with the peer that opened it
+func (p2p *P2P) limitstream(net network.Network, stream network.Stream) {
+	if stream.Stat().Direction != network.DirInbound || (limits.Streams == 0 && limits.PeerStreams == 0) {
+		return
+	}
+
+	remote := stream.Conn().RemotePeer()
+	total, peerstreams := 0, 0
+	for _, conn := range net.Conns() {
+		count := len(conn.GetStreams())
+		total += count
+		if conn.RemotePeer() == remote {
+			peerstreams += count
+		}
+	}
+
+	if (limits.Streams > 0 && total > limits.Streams) || (limits.PeerStreams > 0 && peerstreams > limits.PeerStreams) {
+		stream.Reset()
+		logrus.WithFields(logrus.Fields{
+			"peer":    remote.Pretty(),
+			"streams": peerstreams,
+			"total":   total,
+		}).Debugln("Reset a Stream over the Limit.")
+	}
+}
+
+// A method of P2P that checks the memory used by the process and trims the
+// connections of the host while the heap is larger than the memory limit.
+// Returns right away if there is no memory limit.
+func (p2p *P2P) watchmemory() {
+	limit := limits.Memory
+	if limit <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(memoryinterval)
+	defer ticker.Stop()
+
+	var stats runtime.MemStats
+	for {
+		select {
+		case <-p2p.Ctx.Done():
+			return
+
+		case <-ticker.C:
+			runtime.ReadMemStats(&stats)
+			if heap := stats.HeapAlloc >> 20; heap > uint64(limit) {
+				logrus.WithFields(logrus.Fields{
+					"heap":  heap,
+					"limit": limit,
+					"conns": len(p2p.Host.Network().Conns()),
+				}).Warnln("Memory over the Limit, Trimming the Connections!")
+				p2p.Host.ConnManager().TrimOpenConns(p2p.Ctx)
+			}
+		}
+	}
+}
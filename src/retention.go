@@ -0,0 +1,123 @@
+package src
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the interval between the runs of the retention janitor
+const retentioninterval = time.Hour
+
+// A structure that represents the retention policy of the history. Messages
+// older than the maximum age, and the oldest messages of a room beyond the
+// maximum number of messages, are removed from the history. Zero is no limit.
+type RetentionConfig struct {
+	// Represents the age beyond which the messages are removed (e.g. 720h)
+	MaxAge time.Duration `yaml:"maxage"`
+	// Represents the number of the most recent messages kept for each room
+	MaxMessages int `yaml:"maxmessages"`
+	// Represents whether the history of the direct messages is left out of the policy
+	ExcludeDMs bool `yaml:"excludedms"`
+}
+
+// Represents the guard that starts the retention janitor only once
+var janitoronce sync.Once
+
+// A method of RetentionConfig that checks the limits of the retention policy
+func (policy RetentionConfig) validate() error {
+	if policy.MaxAge < 0 || policy.MaxMessages < 0 {
+		return errors.New("the retention limits cannot be negative")
+	}
+
+	return nil
+}
+
+// A method of RetentionConfig that returns whether the policy has any limits
+func (policy RetentionConfig) enabled() bool {
+	return policy.MaxAge > 0 || policy.MaxMessages > 0
+}
+
+// A method of RetentionConfig that returns whether the policy applies to the history of a room
+func (policy RetentionConfig) applies(roomname string) bool {
+	return !(policy.ExcludeDMs && strings.HasPrefix(roomname, "@"))
+}
+
+// A function that returns the messages of the history of a room, oldest
+// first, that are kept by the retention policy of the configuration
+func retained(roomname string, history []GatewayMessage) []GatewayMessage {
+	policy := currentconfig().History.Retention
+	if !policy.enabled() || !policy.applies(roomname) {
+		return history
+	}
+
+	if policy.MaxAge > 0 {
+		cutoff := time.Now().Add(-policy.MaxAge)
+		index := 0
+		for index < len(history) && history[index].Timestamp.Before(cutoff) {
+			index++
+		}
+		history = history[index:]
+	}
+
+	if policy.MaxMessages > 0 && len(history) > policy.MaxMessages {
+		history = history[len(history)-policy.MaxMessages:]
+	}
+
+	return history
+}
+
+// A method of historystore that applies the retention policy of the configuration to
+// the histories of the rooms in its index, under the lock of each history. Returns the number of messages removed and
+// the number of histories they were removed from.
+func (store *historystore) purge() (int, int) {
+	store.indexlock.Lock()
+	rooms := store.readindex()
+	store.indexlock.Unlock()
+
+	removed, histories := 0, 0
+	for _, roomname := range rooms {
+		// Hold the lock from the load to the rewrite, so a message
+		// appended in between (by the gateway) is not lost
+		unlock := store.lock(roomname)
+		history, _ := store.load(roomname, math.MaxInt32)
+
+		kept := retained(roomname, history)
+		if len(kept) < len(history) {
+			store.write(roomname, kept)
+			removed += len(history) - len(kept)
+			histories++
+		}
+		unlock()
+	}
+
+	return removed, histories
+}
+
+// A function that starts the janitor that applies the retention policy of the
+// configuration to the history every hour, once for the process. The janitor
+// only runs if the policy has limits.
+func startjanitor(store *historystore) {
+	janitoronce.Do(func() {
+		if !currentconfig().History.Retention.enabled() {
+			return
+		}
+
+		go func() {
+			for {
+				if removed, histories := store.purge(); removed > 0 {
+					logrus.WithFields(logrus.Fields{
+						"messages":  removed,
+						"histories": histories,
+					}).Infoln("Removed the Messages beyond the Retention Policy.")
+				}
+
+				time.Sleep(retentioninterval)
+			}
+		}()
+	})
+}
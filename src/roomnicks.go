@@ -0,0 +1,109 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the user names that override the user name
+// of the profile in some rooms. They are kept with the profile but are not
+// published with the user profile, so the rooms cannot be linked by them.
+type roomnickstore struct {
+	// Represents the user names indexed by room name
+	nicks map[string]string
+	// Represents whether the user names have been loaded
+	loaded bool
+	// Represents the lock on the user names
+	mutex sync.Mutex
+}
+
+// Represents the per-room user names of the selected profile
+var roomnicks = &roomnickstore{}
+
+// A function that returns the path of the per-room user names of the selected profile
+func roomnickspath() string {
+	return filepath.Join(defaultdir(), "nicks.json")
+}
+
+// A method of roomnickstore that loads the per-room user names if
+// they have not been loaded yet. Must be called with the store lock held.
+func (store *roomnickstore) load() {
+	if store.loaded {
+		return
+	}
+
+	store.loaded = true
+	store.nicks = make(map[string]string)
+
+	data, err := ioutil.ReadFile(roomnickspath())
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &store.nicks); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  roomnickspath(),
+		}).Warnln("Failed to Parse the Room User Names!")
+	}
+}
+
+// A method of roomnickstore that returns the user name for a room,
+// which is the given user name unless it is overridden for the room
+func (store *roomnickstore) name(room string, username string) string {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	if nick, ok := store.nicks[room]; ok {
+		return nick
+	}
+
+	return username
+}
+
+// A method of roomnickstore that overrides the user name for a room,
+// or removes the override if the user name is empty, and saves it
+func (store *roomnickstore) set(room string, nick string) error {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	if nick == "" {
+		delete(store.nicks, room)
+	} else {
+		store.nicks[room] = nick
+	}
+
+	data, err := json.MarshalIndent(store.nicks, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(roomnickspath()), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(roomnickspath(), data, 0600)
+}
+
+// A method of ChatRoom that sets the user name of the user in this room only,
+// or goes back to the user name of the profile if the user name is empty
+func (cr *ChatRoom) SetRoomNick(nick string) error {
+	if cr.daemon != nil {
+		return errors.New("room user names are not supported when attached to a daemon")
+	}
+
+	if err := roomnicks.set(cr.RoomName, nick); err != nil {
+		return err
+	}
+
+	cr.UserName = roomnicks.name(cr.RoomName, cr.basename)
+	return nil
+}
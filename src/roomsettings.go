@@ -0,0 +1,842 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the DHT namespace of the room settings records
+const roomnamespace = "peerchat-room"
+
+// Represents the protocol ID for fetching and pushing room settings between peers
+const roomprotocol = protocol.ID("/peerchat/room/1.0.0")
+
+// Represents the time allowed to publish or retrieve room settings
+const roomtimeout = 30 * time.Second
+
+// Represents the maximum size of a room settings record
+const maxroomsettingssize = 64 * 1024
+
+// Represents the maximum number of earlier records in the chain of a settings record
+const maxsettingschain = 16
+
+// Represents the message types that room settings can allow
+var messagetypes = []string{"text", "code", "link"}
+
+// A structure that represents the settings of a room, which are signed by one
+// of its operators and replicated to every client in the room. The clients
+// enforce the settings on the messages of the room with a topic validator.
+// The sub-rooms of a room share its settings.
+type RoomSettings struct {
+	// Represents the name of the room
+	Room string `json:"room"`
+	// Represents the version of the settings (newer settings win)
+	Version int64 `json:"version"`
+	// Represents the peer IDs of the operators that can change the settings
+	Operators []string `json:"operators"`
+	// Represents the minimum number of seconds between the messages of a user (off if 0)
+	SlowMode int `json:"slowmode,omitempty"`
+	// Represents the maximum length of a message in bytes (unlimited if 0)
+	MaxLength int `json:"maxlength,omitempty"`
+	// Represents the number of seconds messages are kept in the history (forever if 0)
+	TTL int `json:"ttl,omitempty"`
+	// Represents the allowed message types (all if empty)
+	Types []string `json:"types,omitempty"`
+	// Represents whether only the operators and publishers can post in the room
+	ReadOnly bool `json:"readonly,omitempty"`
+	// Represents the peer IDs of the publishers of a read-only room
+	Publishers []string `json:"publishers,omitempty"`
+	// Represents the maximum number of members of the room (unlimited if 0)
+	MaxMembers int `json:"maxmembers,omitempty"`
+	// Represents whether joining the room requires the approval of an operator
+	Approval bool `json:"approval,omitempty"`
+	// Represents the peer IDs of the members admitted to a room that requires approval
+	Members []string `json:"members,omitempty"`
+	// Represents the names of the sub-rooms of the room, such as 'dev' for 'project/dev'
+	Channels []string `json:"channels,omitempty"`
+}
+
+// A structure that represents room settings signed by an operator of the room.
+// The chain lists the earlier records of the room that changed its operators,
+// oldest first, which shows how the signer came to be an operator. It is not
+// covered by the signature, since each of its records is signed on its own.
+type signedsettings struct {
+	Settings  []byte   `json:"settings"`
+	PublicKey []byte   `json:"publickey"`
+	Signature []byte   `json:"signature"`
+	Chain     [][]byte `json:"chain,omitempty"`
+}
+
+// A structure that represents a request of the room settings protocol,
+// which either asks for the settings of a room or pushes new settings
+type roomrequest struct {
+	Room   string `json:"room"`
+	Record []byte `json:"record,omitempty"`
+}
+
+// A structure that represents the validator of the room settings records in
+// the DHT, which accepts records signed by one of the operators they name and
+// selects the newest of those that chain from the known settings of the room
+type roomvalidator struct{}
+
+// A structure that represents the known settings of rooms. The first
+// settings seen for a room are trusted, and later settings are only
+// accepted if they are newer and chain from the known settings.
+type roomsettingsstore struct {
+	// Represents the signed settings records indexed by room name
+	records map[string][]byte
+	// Represents the time of the last message of each sender in each room
+	lastmessage map[string]map[peer.ID]time.Time
+	// Represents whether the settings have been loaded
+	loaded bool
+	// Represents the lock on the settings
+	mutex sync.Mutex
+}
+
+// Represents the known room settings of the application
+var roomsettings = &roomsettingsstore{}
+
+// A function that returns the path of the known room settings
+func roomsettingspath() string {
+	return peerchatpath("rooms.json")
+}
+
+// A function that returns the DHT key of the settings of a room. When the
+// topics are hashed, the key is named after the hashed room name as well.
+func roomkey(room string) string {
+	name := rootroom(room)
+	if hashingtopics() {
+		name = "h-" + hashroom(name)
+	}
+
+	// The settings of the rooms of named networks are kept apart
+	if network := networkname(); network != "" {
+		return fmt.Sprintf("/%s/%s/%s", roomnamespace, network, name)
+	}
+
+	return fmt.Sprintf("/%s/%s", roomnamespace, name)
+}
+
+// A method of roomsettingsstore that loads the known room settings if they
+// have not been loaded yet. Must be called with the store lock held.
+func (store *roomsettingsstore) load() {
+	if store.loaded {
+		return
+	}
+
+	store.loaded = true
+	store.records = make(map[string][]byte)
+	store.lastmessage = make(map[string]map[peer.ID]time.Time)
+
+	data, err := ioutil.ReadFile(roomsettingspath())
+	if err != nil {
+		return
+	}
+
+	if err := json.Unmarshal(data, &store.records); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  roomsettingspath(),
+		}).Warnln("Failed to Parse the Room Settings!")
+	}
+}
+
+// A method of roomsettingsstore that saves the known room
+// settings. Must be called with the store lock held.
+func (store *roomsettingsstore) save() {
+	data, err := json.MarshalIndent(store.records, "", "  ")
+	if err == nil {
+		if err = os.MkdirAll(filepath.Dir(roomsettingspath()), 0700); err == nil {
+			err = ioutil.WriteFile(roomsettingspath(), data, 0600)
+		}
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  roomsettingspath(),
+		}).Warnln("Failed to Save the Room Settings!")
+	}
+}
+
+// A method of roomsettingsstore that returns the known settings of a room (nil if none)
+func (store *roomsettingsstore) get(room string) *RoomSettings {
+	room = rootroom(room)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	record, ok := store.records[room]
+	if !ok {
+		return nil
+	}
+
+	settings, _, err := verifysettings(room, record)
+	if err != nil {
+		return nil
+	}
+
+	return settings
+}
+
+// A method of roomsettingsstore that returns the known signed settings record of a room
+func (store *roomsettingsstore) record(room string) ([]byte, bool) {
+	room = rootroom(room)
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	record, ok := store.records[room]
+	return record, ok
+}
+
+// A method of roomsettingsstore that accepts a signed settings record for a
+// room if it is the first one seen, or if it is newer than the known settings
+// and chains from them. Returns the settings and their signer
+// if they were accepted, and false if they are not newer.
+func (store *roomsettingsstore) accept(room string, record []byte) (*RoomSettings, peer.ID, bool, error) {
+	room = rootroom(room)
+
+	settings, signer, err := verifysettings(room, record)
+	if err != nil {
+		return nil, "", false, err
+	}
+
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	if known, ok := store.records[room]; ok {
+		current, _, err := verifysettings(room, known)
+		if err == nil {
+			if settings.Version <= current.Version {
+				return current, "", false, nil
+			}
+			if !chainsfrom(current, room, record) {
+				return nil, "", false, fmt.Errorf("settings are signed by %s, who is not an operator of the room", signer.Pretty())
+			}
+		}
+	}
+
+	store.records[room] = record
+	store.save()
+
+	return settings, signer, true, nil
+}
+
+// A method of roomsettingsstore that checks if a message was sent sooner
+// after the last message of its sender than the slow mode of the room
+// allows, and otherwise records it as the last message of the sender
+func (store *roomsettingsstore) tooquick(room string, sender peer.ID, slowmode int) bool {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	store.load()
+
+	senders, ok := store.lastmessage[room]
+	if !ok {
+		senders = make(map[peer.ID]time.Time)
+		store.lastmessage[room] = senders
+	}
+
+	now := time.Now()
+	if last, ok := senders[sender]; ok && now.Sub(last) < time.Duration(slowmode)*time.Second-slowmodeslack {
+		return true
+	}
+
+	senders[sender] = now
+	return false
+}
+
+// A method of RoomSettings that checks if a peer is an operator of the room
+func (settings *RoomSettings) operator(peerid peer.ID) bool {
+	for _, operator := range settings.Operators {
+		if operator == peerid.Pretty() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of RoomSettings that checks if a peer can post in the room,
+// which only the operators and publishers can do in a read-only room
+func (settings *RoomSettings) canpublish(peerid peer.ID) bool {
+	if !settings.ReadOnly || settings.operator(peerid) {
+		return true
+	}
+
+	for _, publisher := range settings.Publishers {
+		if publisher == peerid.Pretty() {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of RoomSettings that checks if a message type is allowed in the room
+func (settings *RoomSettings) allows(msgtype string) bool {
+	if len(settings.Types) == 0 {
+		return true
+	}
+
+	for _, allowed := range settings.Types {
+		if allowed == msgtype {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of RoomSettings that checks the settings
+func (settings *RoomSettings) validate() error {
+	if len(settings.Operators) == 0 {
+		return errors.New("room settings must have an operator")
+	}
+
+	for _, operator := range settings.Operators {
+		if _, err := peer.Decode(operator); err != nil {
+			return fmt.Errorf("invalid operator '%s'", operator)
+		}
+	}
+	for _, list := range [][]string{settings.Publishers, settings.Members} {
+		for _, member := range list {
+			if _, err := peer.Decode(member); err != nil {
+				return fmt.Errorf("invalid member '%s'", member)
+			}
+		}
+	}
+
+	for _, msgtype := range settings.Types {
+		valid := false
+		for _, known := range messagetypes {
+			valid = valid || msgtype == known
+		}
+
+		if !valid {
+			return fmt.Errorf("invalid message type '%s' (%s)", msgtype, strings.Join(messagetypes, ", "))
+		}
+	}
+
+	for _, channel := range settings.Channels {
+		if channel == "" || strings.Contains(channel, roomseparator) {
+			return fmt.Errorf("invalid channel '%s'", channel)
+		}
+	}
+
+	if settings.SlowMode < 0 || settings.MaxLength < 0 || settings.TTL < 0 || settings.MaxMembers < 0 {
+		return errors.New("room settings cannot be negative")
+	}
+
+	return nil
+}
+
+// A function that returns the type of a message: 'code' for code
+// blocks, 'link' for messages with links and 'text' for the others
+func messagetype(message string) string {
+	if _, ok := codeblocklines(message); ok {
+		return "code"
+	}
+	if _, ok := firstlink(message); ok {
+		return "link"
+	}
+
+	return "text"
+}
+
+// A function that verifies a signed settings record for a room and returns
+// the settings and their signer, who must be one of the operators they name
+func verifysettings(room string, value []byte) (*RoomSettings, peer.ID, error) {
+	if len(value) > maxroomsettingssize {
+		return nil, "", errors.New("room settings record is too large")
+	}
+
+	var record signedsettings
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, "", err
+	}
+
+	pubkey, err := crypto.UnmarshalPublicKey(record.PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+
+	ok, err := pubkey.Verify(record.Settings, record.Signature)
+	if err != nil {
+		return nil, "", err
+	}
+	if !ok {
+		return nil, "", errors.New("invalid room settings signature")
+	}
+
+	var settings RoomSettings
+	if err := json.Unmarshal(record.Settings, &settings); err != nil {
+		return nil, "", err
+	}
+
+	if settings.Room != room {
+		return nil, "", errors.New("room settings are for another room")
+	}
+	if err := settings.validate(); err != nil {
+		return nil, "", err
+	}
+
+	signer, err := peer.IDFromPublicKey(pubkey)
+	if err != nil {
+		return nil, "", err
+	}
+	if !settings.operator(signer) {
+		return nil, "", errors.New("room settings are not signed by an operator")
+	}
+
+	return &settings, signer, nil
+}
+
+// A function that checks if a signed settings record for a room chains from the
+// trusted settings of the room. Following the chain of the record from the trusted
+// settings, each record that changed the operators must be signed by an operator
+// of the one before it, and the record by an operator of the last of them.
+func chainsfrom(trusted *RoomSettings, room string, value []byte) bool {
+	settings, signer, err := verifysettings(room, value)
+	if err != nil {
+		return false
+	}
+
+	var record signedsettings
+	if err := json.Unmarshal(value, &record); err != nil {
+		return false
+	}
+
+	current := trusted
+	for _, link := range record.Chain {
+		linked, linksigner, err := verifysettings(room, link)
+		if err != nil {
+			return false
+		}
+
+		// Skip the records that the trusted settings already follow
+		if linked.Version <= current.Version {
+			continue
+		}
+		if linked.Version >= settings.Version || !current.operator(linksigner) {
+			return false
+		}
+
+		current = linked
+	}
+
+	return current.operator(signer)
+}
+
+// A function that returns the chain of the settings record that replaces a
+// record of a room, which adds the record to its chain if it changed the
+// operators. Only the last maxsettingschain records of the chain are kept.
+func settingschain(room string, value []byte) [][]byte {
+	settings, _, err := verifysettings(room, value)
+	if err != nil {
+		return nil
+	}
+
+	var record signedsettings
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil
+	}
+
+	chain := record.Chain
+	changed := len(chain) == 0
+	if !changed {
+		last, _, err := verifysettings(room, chain[len(chain)-1])
+		changed = err != nil || !sameoperators(last.Operators, settings.Operators)
+	}
+
+	if changed {
+		record.Chain = nil
+		if link, err := json.Marshal(record); err == nil {
+			chain = append(append([][]byte{}, chain...), link)
+		}
+	}
+
+	if len(chain) > maxsettingschain {
+		chain = chain[len(chain)-maxsettingschain:]
+	}
+
+	return chain
+}
+
+// A function that checks if two lists of operators have the same peers
+func sameoperators(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	set := make(map[string]bool, len(a))
+	for _, operator := range a {
+		set[operator] = true
+	}
+	for _, operator := range b {
+		if !set[operator] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// A function that signs room settings with an identity key into
+// a record, with the chain of the record that they replace
+func signsettings(settings RoomSettings, chain [][]byte, prvkey crypto.PrivKey) ([]byte, error) {
+	data, err := json.Marshal(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := prvkey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := crypto.MarshalPublicKey(prvkey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedsettings{Settings: data, PublicKey: pubkey, Signature: signature, Chain: chain})
+}
+
+// A function that returns the room name of a room settings DHT key for a
+// record. If the key is named after the hashed name of the room of the record
+// (in the network of the key), the room of the record is returned.
+func roomkeyname(key string, value []byte) (string, error) {
+	prefix := fmt.Sprintf("/%s/", roomnamespace)
+	if len(key) <= len(prefix) || key[:len(prefix)] != prefix {
+		return "", errors.New("invalid room settings key")
+	}
+
+	// Split the network of the key, since root room names contain no '/'
+	room, namespace := key[len(prefix):], service
+	if index := strings.LastIndex(room, "/"); index >= 0 {
+		room, namespace = room[index+1:], service+"/"+room[:index]
+	}
+
+	var record signedsettings
+	var settings RoomSettings
+	if strings.HasPrefix(room, "h-") && json.Unmarshal(value, &record) == nil && json.Unmarshal(record.Settings, &settings) == nil {
+		if room == "h-"+hashroomin(namespace, settings.Room) {
+			return settings.Room, nil
+		}
+	}
+
+	return room, nil
+}
+
+// A method of roomvalidator that validates a room settings record
+func (roomvalidator) Validate(key string, value []byte) error {
+	room, err := roomkeyname(key, value)
+	if err != nil {
+		return err
+	}
+
+	_, _, err = verifysettings(room, value)
+	return err
+}
+
+// A method of roomvalidator that selects the newest room settings record. Anyone
+// can sign settings that name themselves operator, so if the settings of the room
+// are known, only the records that chain from them are selected.
+func (roomvalidator) Select(key string, values [][]byte) (int, error) {
+	best, version := -1, int64(0)
+	for index, value := range values {
+		room, err := roomkeyname(key, value)
+		if err != nil {
+			return 0, err
+		}
+
+		settings, _, err := verifysettings(room, value)
+		if err != nil {
+			continue
+		}
+		if trusted := roomsettings.get(room); trusted != nil && !chainsfrom(trusted, room, value) {
+			continue
+		}
+
+		if best == -1 || settings.Version > version {
+			best, version = index, settings.Version
+		}
+	}
+
+	if best == -1 {
+		return 0, errors.New("no valid room settings records")
+	}
+
+	return best, nil
+}
+
+// A method of P2P that returns the known settings of a room (nil if it has none)
+func (p2p *P2P) RoomSettings(room string) *RoomSettings {
+	return roomsettings.get(room)
+}
+
+// A method of P2P that starts enforcing the settings of a room on its topic
+// and retrieves the settings of the room from the DHT and the room peers.
+// Called when a chat room is joined.
+func (p2p *P2P) watchroom(room string, topic Topic) {
+	// A validator may already be registered by another user of the topic
+	p2p.PubSub.RegisterTopicValidator(topic.String(), p2p.roommessagevalidator(room))
+
+	go p2p.syncroomsettings(room, topic)
+}
+
+// A method of P2P that returns the topic validator that enforces the
+// settings of a room on its messages, including the messages of the host
+func (p2p *P2P) roommessagevalidator(room string) pubsub.ValidatorEx {
+	return func(ctx context.Context, from peer.ID, message *pubsub.Message) pubsub.ValidationResult {
+		// Drop the messages of peers as if they were lost if the chaos options ask for it
+		if from != p2p.Host.ID() && chaosdrop() {
+			return pubsub.ValidationIgnore
+		}
+
+		settings := roomsettings.get(room)
+		if settings == nil {
+			return pubsub.ValidationAccept
+		}
+
+		var cm ChatMessage
+		if err := json.Unmarshal(message.Data, &cm); err != nil {
+			return pubsub.ValidationReject
+		}
+
+		sender, err := peer.IDFromBytes(message.GetFrom())
+		if err != nil {
+			return pubsub.ValidationReject
+		}
+
+		// Only the operators and publishers can post in a read-only room,
+		// and only admitted members in a room that requires approval
+		if !settings.canpublish(sender) || !settings.member(sender) {
+			return pubsub.ValidationReject
+		}
+
+		// The length of a chunk is checked with the chunks before it
+		length := len(cm.Message)
+		if cm.Chunk != nil {
+			length += cm.Chunk.Index * chunklength
+		}
+		if settings.MaxLength > 0 && length > settings.MaxLength {
+			return pubsub.ValidationReject
+		}
+
+		// The type and slow mode are checked on the first chunk
+		if cm.Chunk != nil && cm.Chunk.Index > 0 {
+			return pubsub.ValidationAccept
+		}
+
+		if !settings.allows(messagetype(cm.Message)) {
+			return pubsub.ValidationReject
+		}
+
+		// Messages may arrive late, so slow mode violations are ignored
+		// without a penalty. Operators are exempt from the slow mode.
+		if settings.SlowMode > 0 && !settings.operator(sender) && roomsettings.tooquick(room, sender, settings.SlowMode) {
+			return pubsub.ValidationIgnore
+		}
+
+		return pubsub.ValidationAccept
+	}
+}
+
+// A method of P2P that retrieves the settings of a room from the DHT,
+// and then from some of the peers of the room once they are connected
+func (p2p *P2P) syncroomsettings(room string, topic Topic) {
+	ctx, cancel := context.WithTimeout(p2p.Ctx, roomtimeout)
+	defer cancel()
+
+	if record, err := p2p.getvalue(ctx, roomkey(room)); err == nil {
+		p2p.acceptsettings(room, record)
+	}
+
+	// Wait for the peers of the room to be connected
+	select {
+	case <-ctx.Done():
+		return
+	case <-time.After(5 * time.Second):
+	}
+
+	peers := topic.ListPeers()
+	if len(peers) > 5 {
+		peers = peers[:5]
+	}
+
+	for _, peerid := range peers {
+		if record, err := p2p.requestsettings(ctx, peerid, room); err == nil && record != nil {
+			p2p.acceptsettings(room, record)
+		}
+	}
+}
+
+// A method of P2P that accepts a settings record for a room and logs it
+func (p2p *P2P) acceptsettings(room string, record []byte) {
+	settings, signer, accepted, err := roomsettings.accept(room, record)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  room,
+		}).Warnln("Rejected the Room Settings!")
+		return
+	}
+
+	if accepted {
+		logrus.WithFields(logrus.Fields{
+			"room":    room,
+			"version": settings.Version,
+			"signer":  signer.Pretty(),
+		}).Infoln("Accepted the Room Settings.")
+	}
+}
+
+// A method of P2P that requests the settings record of a room from a peer
+func (p2p *P2P) requestsettings(ctx context.Context, peerid peer.ID, room string) ([]byte, error) {
+	stream, err := p2p.Host.NewStream(ctx, peerid, roomprotocol)
+	if err != nil {
+		return nil, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(roomtimeout))
+
+	if err := json.NewEncoder(stream).Encode(roomrequest{Room: room}); err != nil {
+		stream.Reset()
+		return nil, err
+	}
+
+	var reply roomrequest
+	if err := json.NewDecoder(io.LimitReader(stream, 2*maxroomsettingssize)).Decode(&reply); err != nil {
+		return nil, err
+	}
+
+	return reply.Record, nil
+}
+
+// A method of P2P that handles the room settings protocol, which answers
+// with the known settings of a room or accepts settings pushed by an operator
+func (p2p *P2P) handleroomsettings(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(roomtimeout))
+
+	var request roomrequest
+	if err := json.NewDecoder(io.LimitReader(stream, 2*maxroomsettingssize)).Decode(&request); err != nil {
+		stream.Reset()
+		return
+	}
+
+	// Accept the pushed settings
+	if request.Record != nil {
+		p2p.acceptsettings(request.Room, request.Record)
+		return
+	}
+
+	record, _ := roomsettings.record(request.Room)
+	json.NewEncoder(stream).Encode(roomrequest{Room: request.Room, Record: record})
+}
+
+// A method of P2P that updates the settings of a room, which requires the host
+// to be one of its operators (or the room to have no settings yet, which makes
+// the host its first operator). The settings are signed, published to the DHT
+// and pushed to the peers of the room.
+func (p2p *P2P) UpdateRoomSettings(room string, update func(settings *RoomSettings) error) (*RoomSettings, error) {
+	self := p2p.Host.ID()
+	// Sub-rooms share the settings of their namespace
+	room = rootroom(room)
+
+	settings := roomsettings.get(room)
+	if settings == nil {
+		settings = &RoomSettings{Room: room, Operators: []string{self.Pretty()}}
+	} else if !settings.operator(self) {
+		return nil, errors.New("only the operators of the room can change its settings")
+	}
+
+	updated := *settings
+	updated.Operators = append([]string{}, settings.Operators...)
+	updated.Types = append([]string{}, settings.Types...)
+	updated.Publishers = append([]string{}, settings.Publishers...)
+	updated.Members = append([]string{}, settings.Members...)
+	updated.Channels = append([]string{}, settings.Channels...)
+	if err := update(&updated); err != nil {
+		return nil, err
+	}
+	updated.Version = time.Now().UnixNano()
+
+	if err := updated.validate(); err != nil {
+		return nil, err
+	}
+	// The signer must be an operator of the settings it signs
+	if !updated.operator(self) {
+		return nil, errors.New("operators cannot remove themselves from the room")
+	}
+
+	var chain [][]byte
+	if current, ok := roomsettings.record(room); ok {
+		chain = settingschain(room, current)
+	}
+
+	record, err := signsettings(updated, chain, p2p.Host.Peerstore().PrivKey(self))
+	if err != nil {
+		return nil, err
+	}
+
+	if _, _, _, err := roomsettings.accept(room, record); err != nil {
+		return nil, err
+	}
+
+	go p2p.publishsettings(room, record)
+	return &updated, nil
+}
+
+// A method of P2P that publishes a settings record of a room to the
+// DHT and pushes it to the connected peers of the room and its sub-rooms
+func (p2p *P2P) publishsettings(room string, record []byte) {
+	ctx, cancel := context.WithTimeout(p2p.Ctx, roomtimeout)
+	defer cancel()
+
+	if err := p2p.putvalue(ctx, roomkey(room), record); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  room,
+		}).Debugln("Failed to Publish the Room Settings!")
+	}
+
+	var wait sync.WaitGroup
+	for _, peerid := range p2p.roompeers(room) {
+		wait.Add(1)
+		go func(peerid peer.ID) {
+			defer wait.Done()
+
+			stream, err := p2p.Host.NewStream(ctx, peerid, roomprotocol)
+			if err != nil {
+				return
+			}
+			defer stream.Close()
+
+			json.NewEncoder(stream).Encode(roomrequest{Room: room, Record: record})
+		}(peerid)
+	}
+
+	wait.Wait()
+}
@@ -0,0 +1,114 @@
+package src
+
+import (
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+func TestSettingsChain(t *testing.T) {
+	keys := make(map[string]crypto.PrivKey)
+	ids := make(map[string]string)
+	for _, name := range []string{"alice", "bob", "mallory"} {
+		prvkey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		id, _ := peer.IDFromPrivateKey(prvkey)
+		keys[name], ids[name] = prvkey, id.Pretty()
+	}
+
+	sign := func(signer string, version int64, operators []string, previous []byte) []byte {
+		var chain [][]byte
+		if previous != nil {
+			chain = settingschain("lobby", previous)
+		}
+
+		record, err := signsettings(RoomSettings{Room: "lobby", Version: version, Operators: operators}, chain, keys[signer])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return record
+	}
+
+	// Alice creates the room, hands it to Bob, and Bob updates it
+	first := sign("alice", 1, []string{ids["alice"]}, nil)
+	second := sign("alice", 2, []string{ids["alice"], ids["bob"]}, first)
+	third := sign("bob", 3, []string{ids["bob"]}, second)
+	fourth := sign("bob", 4, []string{ids["bob"]}, third)
+	forged := sign("mallory", 5, []string{ids["mallory"]}, nil)
+
+	trusted, _, err := verifysettings("lobby", first)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		record []byte
+		want   bool
+	}{
+		{"same record", first, true},
+		{"signed by a trusted operator", second, true},
+		{"signed by an operator of the chain", third, true},
+		{"chain without new operators", fourth, true},
+		{"self-signed by a stranger", forged, false},
+	}
+
+	for _, test := range tests {
+		if got := chainsfrom(trusted, "lobby", test.record); got != test.want {
+			t.Errorf("%s: chainsfrom() = %v, want %v", test.name, got, test.want)
+		}
+	}
+
+	// The records that do not change the operators are left out of the chain
+	if chain := settingschain("lobby", fourth); len(chain) != 3 {
+		t.Errorf("the chain after the fourth record has %d records, want 3", len(chain))
+	}
+}
+
+func TestRoomKeyName(t *testing.T) {
+	prvkey, _, err := crypto.GenerateEd25519Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, _ := peer.IDFromPrivateKey(prvkey)
+
+	record, err := signsettings(RoomSettings{Room: "lobby", Version: 1, Operators: []string{id.Pretty()}}, nil, prvkey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"/" + roomnamespace + "/lobby", "lobby"},
+		{"/" + roomnamespace + "/work/lobby", "lobby"},
+		{"/" + roomnamespace + "/h-" + hashroomin(service, "lobby"), "lobby"},
+		{"/" + roomnamespace + "/work/h-" + hashroomin(service+"/work", "lobby"), "lobby"},
+		{"/" + roomnamespace + "/work/h-" + hashroomin(service, "lobby"), "h-" + hashroomin(service, "lobby")},
+		{"/" + roomnamespace + "/h-" + hashroomin(service, "other"), "h-" + hashroomin(service, "other")},
+	}
+
+	for _, test := range tests {
+		got, err := roomkeyname(test.key, record)
+		if err != nil || got != test.want {
+			t.Errorf("roomkeyname(%q) = %q, %v, want %q", test.key, got, err, test.want)
+		}
+	}
+
+	// The key of a room does not reveal its name when the topics are hashed
+	hashedtopics = true
+	defer func() { hashedtopics = false }()
+
+	if key := roomkey("lobby/dev"); strings.Contains(key, "lobby") {
+		t.Errorf("roomkey() = %q, want the hashed room name", key)
+	}
+	if room, _ := roomkeyname(roomkey("lobby"), record); room != "lobby" {
+		t.Errorf("the hashed key resolves to %q, want lobby", room)
+	}
+}
@@ -0,0 +1,34 @@
+package src
+
+import (
+	"strings"
+	"unicode"
+
+	"github.com/rivo/tview"
+)
+
+// A function that makes text received from peers safe to display in the
+// terminal UI. Control characters (which could move the cursor or corrupt
+// the screen) and bidirectional overrides (which could disguise the text)
+// are removed, tabs become spaces, and tview color and region tags are
+// escaped so that peers cannot restyle or hide parts of the UI.
+func sanitize(text string) string {
+	return tview.Escape(stripcontrols(text))
+}
+
+// A function that removes control characters and bidirectional
+// formatting characters from text, replacing tabs with spaces
+func stripcontrols(text string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
+		case r >= '\u202a' && r <= '\u202e', r >= '\u2066' && r <= '\u2069', r == '\u200e', r == '\u200f':
+			return -1
+		default:
+			return r
+		}
+	}, text)
+}
@@ -0,0 +1,107 @@
+package src
+
+import (
+	"regexp"
+	"testing"
+	"unicode"
+)
+
+// Represents the pattern of the color and region tags that tview acts on.
+// Escaped tags ('[red[]') and lone brackets do not match it.
+var tagpattern = regexp.MustCompile(`\[[a-zA-Z0-9_,;: \-\."#]+\]`)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name    string
+		payload string
+		want    string
+	}{
+		// tview color and region tags
+		{"color tag", "[red]alert", "[red[]alert"},
+		{"color reset", "[-]text", "[-[]text"},
+		{"hex color", "[#ff0000]text", "[#ff0000[]text"},
+		{"background and attributes", "[:blue:bl]text", "[:blue:bl[]text"},
+		{"attributes only", "[::b]bold[::-]", "[::b[]bold[::-[]"},
+		{"region tag", `["evil"]click me[""]`, `["evil"[]click me[""[]`},
+		{"hidden text", "[black:black]secret[-:-]", "[black:black[]secret[-:-[]"},
+
+		// '[' escape edge cases
+		{"already escaped", "[red[]", "[red[[]"},
+		{"nested brackets", "[[red]]", "[[red[]]"},
+		{"empty brackets", "[]", "[]"},
+		{"lone open bracket", "[", "["},
+		{"lone close bracket", "]", "]"},
+		{"unclosed tag", "[red", "[red"},
+		{"bracket in text", "a[1] = b[2]", "a[1[] = b[2[]"},
+		{"non tag characters", "[hello!]", "[hello!]"},
+
+		// ANSI escape sequences lose their ESC and cannot reach the terminal
+		{"csi color", "\x1b[31mred\x1b[0m", "[31mred[0m"},
+		{"csi clear screen", "\x1b[2J\x1b[H", "[2J[H"},
+		{"csi cursor up", "ok\x1b[1A\x1b[2Kfake", "ok[1A[2Kfake"},
+		{"osc title with bel", "\x1b]0;pwned\x07", "]0;pwned"},
+		{"osc clipboard with st", "\x1b]52;c;cm0gLXJmIH4=\x1b\\", "]52;c;cm0gLXJmIH4=\\"},
+		{"csi before tag", "\x1b[2J[red]", "[2J[red[]"},
+
+		// C0 and C1 control characters
+		{"nul", "a\x00b", "ab"},
+		{"bell and backspace", "a\x07\x08b", "ab"},
+		{"carriage return", "safe\rEVIL", "safeEVIL"},
+		{"newline", "one\ntwo", "onetwo"},
+		{"tab", "a\tb", "a b"},
+		{"delete", "a\x7fb", "ab"},
+		{"c1 csi", "a\u009b31mb", "a31mb"},
+		{"c1 osc and st", "\u009d0;title\u009c", "0;title"},
+
+		// Bidirectional formatting characters
+		{"right to left override", "invoice\u202etxt.exe", "invoicetxt.exe"},
+		{"left to right override", "a\u202db", "ab"},
+		{"embeddings and pop", "\u202aa\u202bb\u202c", "ab"},
+		{"isolates", "\u2066a\u2067b\u2068c\u2069", "abc"},
+		{"marks", "a\u200eb\u200fc", "abc"},
+
+		// Text that is safe is left alone
+		{"plain", "hello, world", "hello, world"},
+		{"unicode", "héllo 世界 🙂", "héllo 世界 🙂"},
+		{"arabic", "مرحبا", "مرحبا"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got := sanitize(test.payload)
+			if got != test.want {
+				t.Errorf("sanitize(%q) = %q, want %q", test.payload, got, test.want)
+			}
+
+			if tag := tagpattern.FindString(got); tag != "" {
+				t.Errorf("sanitize(%q) = %q, which contains the tag %q", test.payload, got, tag)
+			}
+
+			for _, r := range got {
+				if unicode.IsControl(r) || r >= '\u202a' && r <= '\u202e' || r >= '\u2066' && r <= '\u2069' || r == '\u200e' || r == '\u200f' {
+					t.Errorf("sanitize(%q) = %q, which contains %U", test.payload, got, r)
+				}
+			}
+		})
+	}
+}
+
+func TestStripControls(t *testing.T) {
+	tests := []struct {
+		payload string
+		want    string
+	}{
+		{"[red]kept", "[red]kept"},
+		{"\x1b[31mred", "[31mred"},
+		{"line\r\nQUIT :bye", "lineQUIT :bye"},
+		{"a\tb", "a b"},
+		{"\u202egnp.exe", "gnp.exe"},
+		{"\u0085next line", "next line"},
+	}
+
+	for _, test := range tests {
+		if got := stripcontrols(test.payload); got != test.want {
+			t.Errorf("stripcontrols(%q) = %q, want %q", test.payload, got, test.want)
+		}
+	}
+}
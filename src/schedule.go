@@ -0,0 +1,419 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the number of messages and reminders that can be scheduled at once
+const maxscheduled = 100
+
+// Represents how far ahead messages can be scheduled
+const maxscheduleahead = 365 * 24 * time.Hour
+
+// Represents the interval at which a gateway checks for scheduled messages that are due
+const scheduleinterval = time.Second
+
+// Represents the pattern of the days in a delay such as '1d12h'
+var daypattern = regexp.MustCompile(`(\d+)d`)
+
+// A structure that represents a message or a reminder scheduled for later
+type scheduledmessage struct {
+	// Represents the ID of the scheduled message
+	ID string `json:"id"`
+	// Represents the room the message is sent to
+	Room string `json:"room"`
+	// Represents the text of the message
+	Text string `json:"text"`
+	// Represents when the message is due
+	At time.Time `json:"at"`
+	// Represents whether the message is a reminder for the user, which is not sent
+	Reminder bool `json:"reminder,omitempty"`
+}
+
+// A method of scheduledmessage that describes the message for the list of scheduled messages
+func (msg scheduledmessage) describe() string {
+	text := strings.SplitN(msg.Text, "\n", 2)[0]
+	if len(text) > 40 {
+		text = text[:40] + "..."
+	}
+
+	kind := "to " + msg.Room
+	if msg.Reminder {
+		kind = "reminder"
+	}
+
+	// Messages of other rooms are held once they are due
+	due := "due"
+	if wait := time.Until(msg.At); wait > 0 {
+		due = "in " + wait.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%s %s (%s, %s): %s", msg.ID, msg.At.Format("Mon 02 Jan 15:04"), kind, due, text)
+}
+
+// A structure that represents the messages scheduled by a node, kept in
+// a file of the scheduled directory (~/.peerchat/scheduled) until they are due
+type schedulestore struct {
+	// Represents the path of the file of the scheduled messages
+	path string
+	// Represents the scheduled messages
+	messages []scheduledmessage
+	// Represents the lock on the scheduled messages
+	lock sync.Mutex
+}
+
+// A function that opens the scheduled messages kept under a name. The user
+// interface and the gateway keep theirs apart, since both can run at once.
+func openschedule(name string) *schedulestore {
+	store := &schedulestore{path: peerchatpath("scheduled", name+".json")}
+
+	data, err := ioutil.ReadFile(store.path)
+	if err != nil {
+		return store
+	}
+
+	if err := json.Unmarshal(data, &store.messages); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  store.path,
+		}).Warnln("Failed to Parse the Scheduled Messages!")
+	}
+
+	return store
+}
+
+// A method of schedulestore that writes the scheduled messages to their file.
+// Must be called with the lock held.
+func (store *schedulestore) save() error {
+	if err := os.MkdirAll(filepath.Dir(store.path), 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(store.messages)
+	if err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(store.path, data, 0600)
+}
+
+// A method of schedulestore that schedules a message and returns it with its ID
+func (store *schedulestore) add(msg scheduledmessage) (scheduledmessage, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	if len(store.messages) >= maxscheduled {
+		return msg, fmt.Errorf("at most %d messages can be scheduled", maxscheduled)
+	}
+
+	msg.ID = chunkid()[:6]
+	store.messages = append(store.messages, msg)
+	return msg, store.save()
+}
+
+// A method of schedulestore that returns the scheduled messages, the earliest first
+func (store *schedulestore) list() []scheduledmessage {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	messages := append([]scheduledmessage{}, store.messages...)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].At.Before(messages[j].At)
+	})
+
+	return messages
+}
+
+// A method of schedulestore that cancels the scheduled message
+// with an ID that starts with a prefix, and returns it
+func (store *schedulestore) cancel(prefix string) (scheduledmessage, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	found := -1
+	for index, msg := range store.messages {
+		if !strings.HasPrefix(msg.ID, prefix) {
+			continue
+		}
+		if found >= 0 {
+			return scheduledmessage{}, fmt.Errorf("more than one scheduled message has an ID starting with %s", prefix)
+		}
+		found = index
+	}
+
+	if found < 0 {
+		return scheduledmessage{}, fmt.Errorf("no scheduled message has the ID %s", prefix)
+	}
+
+	msg := store.messages[found]
+	store.messages = append(store.messages[:found], store.messages[found+1:]...)
+	return msg, store.save()
+}
+
+// A method of schedulestore that removes and returns the messages that are due
+// and accepted by a function, the earliest first. The messages that are not
+// accepted, such as those of rooms that cannot be sent to yet, are kept.
+func (store *schedulestore) due(now time.Time, accept func(scheduledmessage) bool) []scheduledmessage {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+
+	var due []scheduledmessage
+	kept := store.messages[:0]
+	for _, msg := range store.messages {
+		if !msg.At.After(now) && accept(msg) {
+			due = append(due, msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+
+	if len(due) == 0 {
+		return nil
+	}
+
+	store.messages = kept
+	if err := store.save(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  store.path,
+		}).Debugln("Failed to Save the Scheduled Messages!")
+	}
+
+	sort.Slice(due, func(i, j int) bool {
+		return due[i].At.Before(due[j].At)
+	})
+	return due
+}
+
+// A function that splits the argument of the schedule and remind commands into
+// the time of the message and its text. The time is quoted ("in 2h") or given
+// as 'in <delay>', 'at <time>', 'tomorrow <time>' or a single word.
+func parseschedule(arg string, now time.Time) (time.Time, string, error) {
+	arg = strings.TrimSpace(arg)
+
+	var when, text string
+	if strings.HasPrefix(arg, `"`) {
+		end := strings.Index(arg[1:], `"`)
+		if end < 0 {
+			return time.Time{}, "", errors.New("missing the closing quote of the time")
+		}
+		when, text = arg[1:end+1], arg[end+2:]
+	} else {
+		fields := strings.SplitN(arg, " ", 3)
+		count := 1
+		if len(fields) > 1 && (fields[0] == "in" || fields[0] == "at" || fields[0] == "tomorrow") {
+			count = 2
+		}
+		if len(fields) <= count {
+			return time.Time{}, "", errors.New("missing the text of the message")
+		}
+		when, text = strings.Join(fields[:count], " "), strings.Join(fields[count:], " ")
+	}
+
+	if text = strings.TrimSpace(text); text == "" {
+		return time.Time{}, "", errors.New("missing the text of the message")
+	}
+
+	at, err := parsewhen(strings.TrimSpace(when), now)
+	if err != nil {
+		return time.Time{}, "", err
+	}
+
+	switch {
+	case !at.After(now):
+		return time.Time{}, "", errors.New("the time has already passed")
+	case at.Sub(now) > maxscheduleahead:
+		return time.Time{}, "", errors.New("messages can be scheduled at most a year ahead")
+	}
+
+	return at, text, nil
+}
+
+// A function that parses the time of a scheduled message: a delay such as
+// 'in 2h', 'in 1d12h' or '45m', a time of the day such as 'at 15:30' (the
+// next day if it has passed) or 'tomorrow 9:00', or a date and a time such as
+// '2021-07-01 15:30' or an RFC 3339 timestamp
+func parsewhen(when string, now time.Time) (time.Time, error) {
+	invalid := fmt.Errorf("invalid time '%s', use a delay (in 2h) or a time (at 15:30)", when)
+
+	// Delays, with days converted into hours
+	if !strings.HasPrefix(when, "at ") && !strings.HasPrefix(when, "tomorrow ") {
+		delay := daypattern.ReplaceAllStringFunc(strings.TrimSpace(strings.TrimPrefix(when, "in ")), func(days string) string {
+			count, _ := strconv.Atoi(strings.TrimSuffix(days, "d"))
+			return strconv.Itoa(count*24) + "h"
+		})
+		if duration, err := time.ParseDuration(delay); err == nil {
+			return now.Add(duration), nil
+		}
+	}
+
+	// Times of the day, today or tomorrow
+	if strings.HasPrefix(when, "at ") || strings.HasPrefix(when, "tomorrow ") {
+		fields := strings.Fields(when)
+		clock, err := time.ParseInLocation("15:04", fields[len(fields)-1], now.Location())
+		if err != nil {
+			return time.Time{}, invalid
+		}
+
+		at := time.Date(now.Year(), now.Month(), now.Day(), clock.Hour(), clock.Minute(), 0, 0, now.Location())
+		if fields[0] == "tomorrow" || !at.After(now) {
+			at = at.AddDate(0, 0, 1)
+		}
+		return at, nil
+	}
+
+	// Dates and times
+	if at, err := time.ParseInLocation("2006-01-02 15:04", when, now.Location()); err == nil {
+		return at, nil
+	}
+	if at, err := time.Parse(time.RFC3339, when); err == nil {
+		return at, nil
+	}
+
+	return time.Time{}, invalid
+}
+
+// A method of Gateway that schedules a message for a room, which is
+// joined if needed and sent the message once it is due
+func (gw *Gateway) Schedule(roomname string, message string, at time.Time) (scheduledmessage, error) {
+	if message == "" {
+		return scheduledmessage{}, errors.New("message is empty")
+	}
+
+	return gw.schedule.add(scheduledmessage{Room: roomname, Text: message, At: at})
+}
+
+// A method of Gateway that returns the scheduled messages, the earliest first
+func (gw *Gateway) Scheduled() []scheduledmessage {
+	return gw.schedule.list()
+}
+
+// A method of Gateway that cancels the scheduled message with an ID that starts with a prefix
+func (gw *Gateway) Unschedule(prefix string) (scheduledmessage, error) {
+	return gw.schedule.cancel(prefix)
+}
+
+// A method of Gateway that sends the scheduled messages when they are due,
+// for as long as the host runs. The messages that were due while the gateway
+// was not running are sent when it starts.
+func (gw *Gateway) sendscheduled() {
+	ticker := time.NewTicker(scheduleinterval)
+	defer ticker.Stop()
+
+	for {
+		for _, msg := range gw.schedule.due(time.Now(), func(scheduledmessage) bool { return true }) {
+			err := gw.Join(msg.Room)
+			if err == nil {
+				err = gw.Send(msg.Room, msg.Text)
+			}
+
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"room":  msg.Room,
+				}).Warnln("Failed to Send a Scheduled Message!")
+			}
+		}
+
+		select {
+		case <-ticker.C:
+		case <-gw.Host.Ctx.Done():
+			return
+		}
+	}
+}
+
+// A method of UI that schedules a message for the room, or a reminder for the
+// user. Messages are held by the daemon when attached to one, so that they are
+// sent even if the interface is closed.
+func (ui *UI) schedulemessage(text string, at time.Time, reminder bool) (scheduledmessage, error) {
+	if ui.daemon != nil && !reminder {
+		return ui.daemon.Schedule(ui.RoomName, text, at)
+	}
+
+	return ui.schedule.add(scheduledmessage{Room: ui.RoomName, Text: text, At: at, Reminder: reminder})
+}
+
+// A method of UI that returns the messages scheduled by the interface
+// and by the daemon it is attached to, the earliest first
+func (ui *UI) scheduled() ([]scheduledmessage, error) {
+	messages := ui.schedule.list()
+	if ui.daemon == nil {
+		return messages, nil
+	}
+
+	held, err := ui.daemon.Scheduled()
+	if err != nil {
+		return messages, err
+	}
+
+	messages = append(messages, held...)
+	sort.Slice(messages, func(i, j int) bool {
+		return messages[i].At.Before(messages[j].At)
+	})
+	return messages, nil
+}
+
+// A method of UI that cancels a scheduled message of the interface, or
+// else of the daemon it is attached to, by a prefix of its ID
+func (ui *UI) unschedule(prefix string) (scheduledmessage, error) {
+	msg, err := ui.schedule.cancel(prefix)
+	if err == nil || ui.daemon == nil {
+		return msg, err
+	}
+
+	return ui.daemon.Unschedule(prefix)
+}
+
+// A method of UI that sends the scheduled messages that are due and shows the
+// reminders. A message is held until its room is shown, while the room is
+// read-only and, under slow mode, until a message can be sent. Called from
+// the UI event handler.
+func (ui *UI) sendscheduled() {
+	room := ui.RoomName
+	readonly := ui.readonly()
+	due := ui.schedule.due(time.Now(), func(msg scheduledmessage) bool {
+		if msg.Reminder {
+			return true
+		}
+		if msg.Room != room {
+			return false
+		}
+
+		// Hold the message while the room is read-only, without using up the slow mode
+		if readonly {
+			if !ui.scheduleheld[msg.ID] {
+				ui.scheduleheld[msg.ID] = true
+				ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "schedule", logmsg: fmt.Sprintf("the scheduled message %s is held, the room is read-only", msg.ID)})
+			}
+			return false
+		}
+
+		return ui.takeslot() <= 0
+	})
+
+	for _, msg := range due {
+		delete(ui.scheduleheld, msg.ID)
+
+		if msg.Reminder {
+			ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "reminder", logmsg: msg.Text})
+			ui.notifier.Notify(msg.Room, "Reminder", msg.Text)
+			continue
+		}
+
+		ui.sendtext(msg.Text)
+	}
+}
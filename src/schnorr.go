@@ -0,0 +1,76 @@
+package src
+
+import (
+	"crypto/rand"
+	"errors"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcec/v2/schnorr"
+)
+
+/*
+Schnorr signatures over secp256k1 as specified by BIP-340, which nostr uses
+to sign events. The signatures are made and verified by the schnorr package
+of btcec, which implements BIP-340 on the secp256k1 curve of decred.
+*/
+
+// A function that returns the private key for a 32 byte secret key
+func schnorrprivkey(secret []byte) (*btcec.PrivateKey, error) {
+	var scalar btcec.ModNScalar
+	if len(secret) != 32 || scalar.SetByteSlice(secret) || scalar.IsZero() {
+		return nil, errors.New("invalid secret key")
+	}
+
+	return btcec.PrivKeyFromScalar(&scalar), nil
+}
+
+// A function that returns the 32 byte x-only public key of a secret key
+func schnorrpubkey(secret []byte) ([]byte, error) {
+	key, err := schnorrprivkey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	return schnorr.SerializePubKey(key.PubKey()), nil
+}
+
+// A function that signs a 32 byte message with a secret key
+// and fresh auxiliary randomness, as recommended by BIP-340
+func schnorrsign(secret []byte, message []byte) ([]byte, error) {
+	var aux [32]byte
+	if _, err := rand.Read(aux[:]); err != nil {
+		return nil, err
+	}
+
+	return schnorrsignaux(secret, message, aux)
+}
+
+// A function that signs a 32 byte message with a secret key and the given auxiliary randomness
+func schnorrsignaux(secret []byte, message []byte, aux [32]byte) ([]byte, error) {
+	key, err := schnorrprivkey(secret)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := schnorr.Sign(key, message, schnorr.CustomNonce(aux))
+	if err != nil {
+		return nil, err
+	}
+
+	return signature.Serialize(), nil
+}
+
+// A function that verifies the signature of a 32 byte message for an x-only public key
+func schnorrverify(pubkey []byte, message []byte, signature []byte) bool {
+	key, err := schnorr.ParsePubKey(pubkey)
+	if err != nil {
+		return false
+	}
+
+	parsed, err := schnorr.ParseSignature(signature)
+	if err != nil {
+		return false
+	}
+
+	return parsed.Verify(message, key)
+}
@@ -0,0 +1,229 @@
+package src
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+	lua "github.com/yuin/gopher-lua"
+)
+
+/*
+A structure that represents the Lua scripting engine.
+
+Scripts are the *.lua files in the scripts directory (~/.peerchat/scripts).
+Each script runs in its own Lua state and gets a 'peerchat' global table:
+
+	peerchat.on_message(function(msg) ... end)
+		Called for every inbound message with a table of 'message', 'senderid',
+		'sendername' and 'room'. Returning a string replaces the message text,
+		returning false drops the message and returning nil keeps it.
+
+	peerchat.on_send(function(text, room) ... end)
+		Called for every outbound message. Returning a string replaces the text,
+		returning false drops the message and returning nil keeps it.
+
+	peerchat.send(text)
+		Publishes a message to the current chat room.
+
+Scripts are reloaded at runtime with the /reload command.
+*/
+type scriptengine struct {
+	// Represents the loaded scripts
+	scripts []*script
+	// Represents the function that publishes messages for scripts
+	sender func(text string)
+
+	// Represents the lock on the engine and the Lua states
+	mutex sync.Mutex
+	// Represents the one-time installation of the pipeline hooks
+	installed sync.Once
+}
+
+// A structure that represents a loaded Lua script
+type script struct {
+	// Represents the file name of the script
+	name string
+	// Represents the Lua state of the script
+	state *lua.LState
+
+	// Represents the inbound message callbacks
+	onmessage []*lua.LFunction
+	// Represents the outbound message callbacks
+	onsend []*lua.LFunction
+}
+
+// Represents the scripting engine
+var scripts = &scriptengine{}
+
+// A function that loads all scripts in the scripts directory and
+// hooks the scripting engine into the message pipeline.
+// Returns the number of scripts that were loaded.
+func LoadScripts() int {
+	scripts.installed.Do(func() {
+		AddInboundFilter(scripts.filter)
+		AddOutboundTransformer(scripts.transform)
+	})
+
+	return scripts.reload()
+}
+
+// A function that sets the function used to publish
+// the messages sent by scripts with peerchat.send
+func SetScriptSender(sender func(text string)) {
+	scripts.mutex.Lock()
+	defer scripts.mutex.Unlock()
+
+	scripts.sender = sender
+}
+
+// A method of scriptengine that closes all loaded scripts and loads the
+// scripts in the scripts directory again. Scripts that fail to load are
+// skipped. Returns the number of scripts that were loaded.
+func (se *scriptengine) reload() int {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	// Close the loaded scripts
+	for _, s := range se.scripts {
+		s.state.Close()
+	}
+	se.scripts = nil
+
+	// Read the scripts directory
+	dir := currentconfig().scriptsdir()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		logrus.Debugf("No Scripts Loaded from %s.", dir)
+		return 0
+	}
+
+	for _, entry := range entries {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".lua") {
+			continue
+		}
+
+		// Load the script
+		s := se.newscript(entry.Name())
+		if err := s.state.DoFile(filepath.Join(dir, entry.Name())); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error":  err.Error(),
+				"script": entry.Name(),
+			}).Warnln("Failed to Load Script!")
+
+			s.state.Close()
+			continue
+		}
+
+		se.scripts = append(se.scripts, s)
+	}
+
+	return len(se.scripts)
+}
+
+// A method of scriptengine that creates a script with
+// a new Lua state and the 'peerchat' API table
+func (se *scriptengine) newscript(name string) *script {
+	s := &script{name: name, state: lua.NewState()}
+
+	api := s.state.NewTable()
+	s.state.SetField(api, "on_message", s.state.NewFunction(func(L *lua.LState) int {
+		s.onmessage = append(s.onmessage, L.CheckFunction(1))
+		return 0
+	}))
+
+	s.state.SetField(api, "on_send", s.state.NewFunction(func(L *lua.LState) int {
+		s.onsend = append(s.onsend, L.CheckFunction(1))
+		return 0
+	}))
+
+	s.state.SetField(api, "send", s.state.NewFunction(func(L *lua.LState) int {
+		// Publish asynchronously since the engine is locked during callbacks
+		if text := L.CheckString(1); se.sender != nil {
+			go se.sender(text)
+		}
+		return 0
+	}))
+
+	s.state.SetGlobal("peerchat", api)
+	return s
+}
+
+// A method of scriptengine that applies the on_message callbacks of all scripts
+func (se *scriptengine) filter(room string, msg ChatMessage) (ChatMessage, bool) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	for _, s := range se.scripts {
+		for _, callback := range s.onmessage {
+			// Create the message table
+			table := s.state.NewTable()
+			table.RawSetString("message", lua.LString(msg.Message))
+			table.RawSetString("senderid", lua.LString(msg.SenderID))
+			table.RawSetString("sendername", lua.LString(msg.SenderName))
+			table.RawSetString("room", lua.LString(room))
+
+			result, ok := s.call(callback, table)
+			if !ok {
+				return ChatMessage{}, false
+			}
+
+			if result != nil {
+				msg.Message = *result
+			}
+		}
+	}
+
+	return msg, true
+}
+
+// A method of scriptengine that applies the on_send callbacks of all scripts
+func (se *scriptengine) transform(room string, message string) (string, bool) {
+	se.mutex.Lock()
+	defer se.mutex.Unlock()
+
+	for _, s := range se.scripts {
+		for _, callback := range s.onsend {
+			result, ok := s.call(callback, lua.LString(message), lua.LString(room))
+			if !ok {
+				return "", false
+			}
+
+			if result != nil {
+				message = *result
+			}
+		}
+	}
+
+	return message, true
+}
+
+// A method of script that calls a callback and interprets its result.
+// Returns false if the callback returned false, and the returned string
+// if it returned one. Errors are logged and treated as returning nil.
+func (s *script) call(callback *lua.LFunction, args ...lua.LValue) (*string, bool) {
+	err := s.state.CallByParam(lua.P{Fn: callback, NRet: 1, Protect: true}, args...)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error":  err.Error(),
+			"script": s.name,
+		}).Debugln("Script Callback Failed!")
+		return nil, true
+	}
+
+	// Retrieve the result from the stack
+	result := s.state.Get(-1)
+	s.state.Pop(1)
+
+	switch value := result.(type) {
+	case lua.LBool:
+		return nil, bool(value)
+	case lua.LString:
+		text := string(value)
+		return &text, true
+	default:
+		return nil, true
+	}
+}
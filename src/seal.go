@@ -0,0 +1,117 @@
+package src
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Represents the scrypt parameters for deriving keys from passwords
+const (
+	scryptN = 1 << 15
+	scryptR = 8
+	scryptP = 1
+)
+
+// A structure that represents data sealed with a password. The key is
+// derived from the password with scrypt and the data is encrypted and
+// authenticated with AES-256-GCM.
+type sealed struct {
+	// Represents the version of the format
+	Version int `json:"version"`
+	// Represents the key derivation function
+	KDF string `json:"kdf"`
+	// Represents the salt of the key derivation
+	Salt []byte `json:"salt"`
+	// Represents the nonce of the encryption
+	Nonce []byte `json:"nonce"`
+	// Represents the encrypted data
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// A function that derives a 32 byte key from a password and a salt with scrypt
+func passwordkey(password string, salt []byte) ([]byte, error) {
+	return scrypt.Key([]byte(password), salt, scryptN, scryptR, scryptP, 32)
+}
+
+// A function that derives an AES-256-GCM cipher from a password and a salt
+func passwordcipher(password string, salt []byte) (cipher.AEAD, error) {
+	key, err := passwordkey(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	return keycipher(key)
+}
+
+// A function that returns an AES-256-GCM cipher for a 32 byte key
+func keycipher(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// A function that encrypts data with a password
+func seal(plaintext []byte, password string) ([]byte, error) {
+	if password == "" {
+		return nil, errors.New("password is empty")
+	}
+
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, err
+	}
+
+	aead, err := passwordcipher(password, salt)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(sealed{
+		Version:    1,
+		KDF:        "scrypt",
+		Salt:       salt,
+		Nonce:      nonce,
+		Ciphertext: aead.Seal(nil, nonce, plaintext, nil),
+	})
+}
+
+// A function that decrypts data that was encrypted with a password
+func unseal(data []byte, password string) ([]byte, error) {
+	var box sealed
+	if err := json.Unmarshal(data, &box); err != nil {
+		return nil, errors.New("not an encrypted file")
+	}
+
+	if box.Version != 1 || box.KDF != "scrypt" {
+		return nil, errors.New("unsupported encrypted file format")
+	}
+
+	aead, err := passwordcipher(password, box.Salt)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(box.Nonce) != aead.NonceSize() {
+		return nil, errors.New("invalid encrypted file")
+	}
+
+	plaintext, err := aead.Open(nil, box.Nonce, box.Ciphertext, nil)
+	if err != nil {
+		return nil, errors.New("wrong password or corrupted file")
+	}
+
+	return plaintext, nil
+}
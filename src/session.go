@@ -0,0 +1,62 @@
+package src
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the state of the last session of the
+// terminal UI, which is restored when the application is started again
+type session struct {
+	// Represents the rooms listed as tabs, with the current room first
+	Rooms []string `json:"rooms"`
+}
+
+// A function that returns the path of the session file
+func sessionpath() string {
+	return peerchatpath("session.json")
+}
+
+// A function that returns the rooms of the last session of the
+// terminal UI, with the room that was open first. Returns nil
+// if there is no last session.
+func LoadSession() []string {
+	data, err := ioutil.ReadFile(sessionpath())
+	if err != nil {
+		return nil
+	}
+
+	var last session
+	if err := json.Unmarshal(data, &last); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  sessionpath(),
+		}).Debugln("Failed to Parse the Last Session!")
+		return nil
+	}
+
+	return last.Rooms
+}
+
+// A function that saves the rooms of the session of the terminal UI,
+// with the room that is open first, so that they can be restored
+func SaveSession(rooms []string) {
+	data, err := json.MarshalIndent(session{Rooms: rooms}, "", "  ")
+	if err != nil {
+		return
+	}
+
+	if err := os.MkdirAll(peerchatdir(), 0700); err == nil {
+		err = ioutil.WriteFile(sessionpath(), data, 0600)
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  sessionpath(),
+		}).Debugln("Failed to Save the Session!")
+	}
+}
@@ -0,0 +1,438 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	mocknet "github.com/libp2p/go-libp2p/p2p/net/mock"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the prefix of the messages generated by a simulation
+const simprefix = "sim"
+
+// Represents the time allowed for the simulated nodes to discover each other
+const simdiscovery = 30 * time.Second
+
+// A structure that represents the settings of a simulation
+type SimulationConfig struct {
+	// Represents the number of simulated nodes
+	Nodes int
+	// Represents the rooms that every node joins
+	Rooms []string
+	// Represents the number of nodes each node is connected to before discovery
+	Degree int
+	// Represents the latency of the links between the nodes
+	Latency time.Duration
+	// Represents the time between the messages of each node in each room
+	Interval time.Duration
+	// Represents the number of nodes that send messages (all if 0),
+	// the other nodes only read the messages of the senders
+	Senders int
+	// Represents the minimum size of the messages, which are padded up to it
+	Size int
+	// Represents whether the first room of the first node is left
+	// to an interface instead of being read by the simulation
+	Observe bool
+}
+
+// A structure that represents a simulation of many nodes in the same process,
+// which are linked by an in-memory network instead of real transports
+type Simulation struct {
+	// Represents the settings of the simulation
+	config SimulationConfig
+	// Represents the context of the simulation
+	ctx    context.Context
+	cancel context.CancelFunc
+	// Represents the in-memory network of the nodes
+	network mocknet.Mocknet
+	// Represents the simulated nodes
+	nodes []*P2P
+	// Represents the chat rooms joined by the nodes
+	chatrooms []*ChatRoom
+	// Represents the chat rooms that send messages
+	sending []*ChatRoom
+	// Represents the observed chat room (nil if not observed)
+	observer *ChatRoom
+	// Represents the time the discovery of the nodes took
+	discovery time.Duration
+	// Represents the statistics of the generated traffic
+	stats *simstats
+}
+
+// A structure that represents the statistics of the traffic of a simulation
+type simstats struct {
+	// Represents the number of sent, expected, delivered, duplicate and reordered messages
+	sent       int
+	expected   int
+	delivered  int
+	duplicates int
+	reordered  int
+	// Represents the delivery latencies of the delivered messages
+	latencies []time.Duration
+	// Represents the last sequence number seen by each reader from each sender
+	last map[string]int
+	// Represents the lock on the statistics
+	mutex sync.Mutex
+}
+
+// A constructor function that creates the nodes of a simulation on an in-memory
+// network. The nodes are linked to each other, but each node only connects to
+// a few random nodes so that the rest must be found with the discovery.
+// The context of the simulation is derived from the given context.
+func NewSimulation(ctx context.Context, config SimulationConfig) (*Simulation, error) {
+	if config.Nodes < 2 {
+		return nil, errors.New("a simulation needs at least 2 nodes")
+	}
+	if len(config.Rooms) == 0 {
+		config.Rooms = []string{defaultroom}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	sim := &Simulation{
+		config:  config,
+		ctx:     ctx,
+		cancel:  cancel,
+		network: mocknet.New(ctx),
+		stats:   &simstats{last: make(map[string]int)},
+	}
+	sim.network.SetLinkDefaults(mocknet.LinkOptions{Latency: config.Latency})
+
+	for index := 0; index < config.Nodes; index++ {
+		nodehost, err := sim.network.GenPeer()
+		if err != nil {
+			sim.Close()
+			return nil, err
+		}
+
+		node, err := NewHostP2P(ctx, nodehost)
+		if err != nil {
+			sim.Close()
+			return nil, err
+		}
+
+		sim.nodes = append(sim.nodes, node)
+	}
+
+	if err := sim.network.LinkAll(); err != nil {
+		sim.Close()
+		return nil, err
+	}
+
+	// Connect each node to a few of the nodes created before it,
+	// which keeps the network connected without being complete
+	for index := 1; index < len(sim.nodes); index++ {
+		for _, other := range rand.Perm(index)[:minint(config.Degree, index)] {
+			if _, err := sim.network.ConnectPeers(sim.nodes[index].Host.ID(), sim.nodes[other].Host.ID()); err != nil {
+				sim.Close()
+				return nil, err
+			}
+		}
+	}
+
+	return sim, nil
+}
+
+// A method of Simulation that bootstraps the DHTs of the nodes and lets every node
+// advertise the service and connect to the nodes it finds, as they do on the internet
+func (sim *Simulation) Discover() {
+	started := time.Now()
+	ctx, cancel := context.WithTimeout(sim.ctx, simdiscovery)
+	defer cancel()
+
+	var wait sync.WaitGroup
+	for _, node := range sim.nodes {
+		wait.Add(1)
+		go func(node *P2P) {
+			defer wait.Done()
+
+			if err := node.KadDHT.Bootstrap(ctx); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"peer":  node.Host.ID().Pretty(),
+				}).Debugln("Failed to Bootstrap a Simulated Node!")
+			}
+
+			if _, err := node.Discovery.Advertise(ctx, servicename()); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"peer":  node.Host.ID().Pretty(),
+				}).Debugln("Failed to Advertise a Simulated Node!")
+				return
+			}
+
+			peerchan, err := node.Discovery.FindPeers(ctx, servicename())
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"peer":  node.Host.ID().Pretty(),
+				}).Debugln("Failed to Discover Peers for a Simulated Node!")
+				return
+			}
+
+			node.handlediscovery(peerchan)
+		}(node)
+	}
+
+	wait.Wait()
+	sim.discovery = time.Since(started)
+}
+
+// A method of Simulation that joins every node to the rooms of the simulation
+// and reads their messages, except those of the observed room
+func (sim *Simulation) Join() error {
+	chatrooms := make([]*ChatRoom, len(sim.nodes)*len(sim.config.Rooms))
+	errs := make(chan error, len(chatrooms))
+
+	var wait sync.WaitGroup
+	for index, node := range sim.nodes {
+		for roomindex, room := range sim.config.Rooms {
+			wait.Add(1)
+			go func(slot int, node *P2P, username, room string) {
+				defer wait.Done()
+
+				chatroom, err := JoinChatRoom(sim.ctx, node, username, room)
+				if err != nil {
+					errs <- err
+					return
+				}
+				chatrooms[slot] = chatroom
+			}(index*len(sim.config.Rooms)+roomindex, node, fmt.Sprintf("sim-%02d", index), room)
+		}
+	}
+	wait.Wait()
+	close(errs)
+
+	for slot, chatroom := range chatrooms {
+		if chatroom == nil {
+			continue
+		}
+
+		sim.chatrooms = append(sim.chatrooms, chatroom)
+		if sim.config.Senders == 0 || slot/len(sim.config.Rooms) < sim.config.Senders {
+			sim.sending = append(sim.sending, chatroom)
+		}
+		if slot == 0 && sim.config.Observe {
+			sim.observer = chatroom
+			continue
+		}
+		go sim.read(chatroom)
+	}
+
+	return <-errs
+}
+
+// A method of Simulation that waits until every joined chat room sees the other
+// members of the room as peers, or until the timeout. Returns false on timeout.
+func (sim *Simulation) Settle(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+
+	for _, chatroom := range sim.chatrooms {
+		for len(chatroom.PeerList()) < len(sim.nodes)-1 {
+			if time.Now().After(deadline) {
+				return false
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+
+	return true
+}
+
+// A method of Simulation that returns the observed chat room (nil if not observed)
+func (sim *Simulation) Observer() *ChatRoom {
+	return sim.observer
+}
+
+// A method of Simulation that generates messages from the senders in every
+// joined room until the context is cancelled. Each message carries its sequence
+// number and send time to measure the ordering and latency of delivery.
+func (sim *Simulation) Run(ctx context.Context) {
+	// Pad the messages up to the size of the simulation
+	padding := ""
+	if sim.config.Size > 0 {
+		padding = " " + strings.Repeat("x", sim.config.Size)
+	}
+
+	var wait sync.WaitGroup
+	for _, chatroom := range sim.sending {
+		wait.Add(1)
+		go func(chatroom *ChatRoom) {
+			defer wait.Done()
+
+			// Spread the messages of the nodes over the interval
+			select {
+			case <-time.After(time.Duration(rand.Int63n(int64(sim.config.Interval) + 1))):
+			case <-ctx.Done():
+				return
+			}
+
+			ticker := time.NewTicker(sim.config.Interval)
+			defer ticker.Stop()
+
+			// Every message is expected by the other members
+			// of the room, except by the observed room
+			readers := len(sim.nodes) - 1
+			if sim.observer != nil && sim.observer != chatroom && sim.observer.RoomName == chatroom.RoomName {
+				readers--
+			}
+
+			for seq := 1; ; seq++ {
+				select {
+				case chatroom.Outbound <- fmt.Sprintf("%s %d %d%s", simprefix, seq, time.Now().UnixNano(), padding):
+					sim.stats.sending(readers)
+				case <-ctx.Done():
+					return
+				case <-sim.ctx.Done():
+					return
+				}
+
+				select {
+				case <-ticker.C:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(chatroom)
+	}
+
+	wait.Wait()
+}
+
+// A method of Simulation that reads the events of a chat room until it
+// is closed, recording the delivery of the generated messages
+func (sim *Simulation) read(chatroom *ChatRoom) {
+	chatroom.Emit(sim.ctx, simreader{stats: sim.stats})
+}
+
+// A structure that represents the consumer of the events of a simulated chat room
+type simreader struct {
+	NopEvents
+
+	stats *simstats
+}
+
+// A method of simreader that records the delivery of a message
+func (reader simreader) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	reader.stats.record(cr, msg)
+}
+
+// A method of simreader that logs a log of the chat room for debugging
+func (reader simreader) Error(cr *ChatRoom, err error) {
+	logrus.WithFields(logrus.Fields{
+		"room": cr.RoomName,
+		"user": cr.UserName,
+	}).Debugln(err.Error())
+}
+
+// A method of simstats that records a sent message that is expected by a number of readers
+func (stats *simstats) sending(readers int) {
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.sent++
+	stats.expected += readers
+}
+
+// A method of simstats that records the delivery of a generated message to a chat room
+func (stats *simstats) record(chatroom *ChatRoom, msg ChatMessage) {
+	fields := strings.Fields(msg.Message)
+	if len(fields) < 3 || fields[0] != simprefix {
+		return
+	}
+
+	seq, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return
+	}
+	sent, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil {
+		return
+	}
+	latency := time.Since(time.Unix(0, sent))
+
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	stats.delivered++
+	stats.latencies = append(stats.latencies, latency)
+
+	key := fmt.Sprintf("%s/%s/%s", chatroom.selfid, chatroom.RoomName, msg.SenderID)
+	switch last := stats.last[key]; {
+	case seq == last:
+		stats.duplicates++
+	case seq < last:
+		stats.reordered++
+	default:
+		stats.last[key] = seq
+	}
+}
+
+// A method of Simulation that returns a report of the connectivity of the
+// nodes and of the traffic delivered so far, as lines of text
+func (sim *Simulation) Report() []string {
+	connected := make([]int, len(sim.nodes))
+	for index, node := range sim.nodes {
+		connected[index] = len(node.Host.Network().Peers())
+	}
+	least, most, total := connected[0], connected[0], 0
+	for _, count := range connected {
+		least, most, total = minint(least, count), maxint(most, count), total+count
+	}
+
+	lines := []string{
+		fmt.Sprintf("nodes:      %d (discovery took %s)", len(sim.nodes), sim.discovery.Round(time.Millisecond)),
+		fmt.Sprintf("peers:      %d min, %d avg, %d max", least, total/len(connected), most),
+	}
+
+	for _, room := range sim.config.Rooms {
+		members := 0
+		for _, chatroom := range sim.chatrooms {
+			if chatroom.RoomName == room {
+				members += len(chatroom.PeerList())
+			}
+		}
+		lines = append(lines, fmt.Sprintf("room %s: %d avg mesh peers", room, members/len(sim.nodes)))
+	}
+
+	stats := sim.stats
+	stats.mutex.Lock()
+	defer stats.mutex.Unlock()
+
+	return append(lines, stats.report()...)
+}
+
+// A method of Simulation that exits the chat rooms and shuts down the nodes
+func (sim *Simulation) Close() {
+	for _, chatroom := range sim.chatrooms {
+		chatroom.Exit()
+	}
+	for _, node := range sim.nodes {
+		node.Close()
+	}
+
+	sim.cancel()
+	sim.network.Close()
+}
+
+// A function that returns the smaller of two integers
+func minint(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// A function that returns the larger of two integers
+func maxint(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
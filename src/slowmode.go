@@ -0,0 +1,114 @@
+package src
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the tolerance of the topic validator for messages that arrive
+// sooner after each other than the slow mode allows, due to network delays
+const slowmodeslack = 2 * time.Second
+
+// A method of ChatRoom that returns the time left before the host can
+// send a message under the slow mode of the room. Operators are exempt.
+func (cr *ChatRoom) slowmodewait() time.Duration {
+	// Room settings are not available when backed by a daemon
+	if cr.Host == nil {
+		return 0
+	}
+
+	settings := cr.Host.RoomSettings(cr.RoomName)
+	if settings == nil || settings.SlowMode == 0 || settings.operator(cr.selfid) {
+		return 0
+	}
+
+	cr.slowlock.Lock()
+	defer cr.slowlock.Unlock()
+
+	wait := time.Until(cr.lastsent.Add(time.Duration(settings.SlowMode) * time.Second))
+	if wait < 0 {
+		return 0
+	}
+
+	return wait
+}
+
+// A method of ChatRoom that takes the next message slot of the slow mode of
+// the room. Returns the time left if the host cannot send a message yet.
+func (cr *ChatRoom) takeslot() time.Duration {
+	if wait := cr.slowmodewait(); wait > 0 {
+		return wait
+	}
+
+	cr.slowlock.Lock()
+	cr.lastsent = time.Now()
+	cr.slowlock.Unlock()
+
+	return 0
+}
+
+// A method of UI that returns the label of the input field, which
+// counts down the time left before a message can be sent in slow mode
+// and counts the messages pending until the room has peers
+func (ui *UI) inputlabel() string {
+	if ui.readonly() {
+		return ui.UserName + " (read-only) > "
+	}
+	if wait := ui.slowmodewait(); wait > 0 {
+		return fmt.Sprintf("%s (%ds) > ", ui.UserName, int(wait.Seconds()+0.999))
+	}
+	if pending := ui.Pending(); pending > 0 {
+		return fmt.Sprintf("%s (%d pending) > ", ui.UserName, pending)
+	}
+
+	return ui.UserName + " > "
+}
+
+// A method of UI that updates the label of the input field if it has
+// changed, and disables the input of messages in read-only rooms
+func (ui *UI) synclabel() {
+	label := ui.inputlabel()
+	readonly := ui.readonly()
+
+	ui.TerminalApp.QueueUpdate(func() {
+		ui.syncreadonly(readonly)
+		if ui.inputBox.GetLabel() != label {
+			ui.inputBox.SetLabel(label)
+			go ui.TerminalApp.Draw()
+		}
+	})
+}
+
+// A method of UI that sends a message typed by the user, unless the room is
+// read-only or its slow mode is counting down, in which case the message is
+// put back into the input field. Called from the UI event handler.
+func (ui *UI) sendmessage(msg string) {
+	// Send the message to the peer of the shown direct message pane
+	if peerid := ui.shownpane(); peerid != "" {
+		go ui.senddm(peerid, msg)
+		return
+	}
+
+	if ui.readonly() {
+		ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "readonly", logmsg: "this room is read-only, only its operators and publishers can post"})
+		return
+	}
+
+	if wait := ui.takeslot(); wait > 0 {
+		ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "slowmode", logmsg: fmt.Sprintf("slow mode is on, you can send a message in %ds", int(wait.Seconds()+0.999))})
+
+		ui.TerminalApp.QueueUpdateDraw(func() {
+			if ui.inputBox.GetText() == "" {
+				ui.inputBox.SetText(msg)
+			}
+		})
+		ui.synclabel()
+		return
+	}
+
+	// Send the message and add it to the message box as a self message
+	ui.sendtext(msg)
+	ui.synclabel()
+}
@@ -0,0 +1,301 @@
+package src
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/transport"
+	tptu "github.com/libp2p/go-libp2p-transport-upgrader"
+	"github.com/libp2p/go-tcp-transport"
+	"github.com/multiformats/go-multiaddr"
+	manet "github.com/multiformats/go-multiaddr/net"
+)
+
+// Represents the time allowed to connect to a peer through the proxy
+const proxytimeout = 30 * time.Second
+
+// A structure that represents a SOCKS5 proxy that
+// outgoing connections to peers are dialed through
+type socksproxy struct {
+	// Represents the address of the proxy (host:port)
+	addr string
+	// Represents the credentials for the proxy (optional)
+	username string
+	password string
+}
+
+// A function that parses the URL of a SOCKS5 proxy (socks5://[user:pass@]host:port).
+// Returns nil if the URL is empty.
+func parseproxy(raw string) (*socksproxy, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	proxyurl, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy '%s' - %s", raw, err)
+	}
+
+	if proxyurl.Scheme != "socks5" && proxyurl.Scheme != "socks5h" {
+		return nil, fmt.Errorf("invalid proxy '%s' - only socks5:// proxies are supported", raw)
+	}
+
+	if _, _, err := net.SplitHostPort(proxyurl.Host); err != nil {
+		return nil, fmt.Errorf("invalid proxy '%s' - %s", raw, err)
+	}
+
+	proxy := &socksproxy{addr: proxyurl.Host}
+	if proxyurl.User != nil {
+		proxy.username = proxyurl.User.Username()
+		proxy.password, _ = proxyurl.User.Password()
+	}
+
+	return proxy, nil
+}
+
+// A method of socksproxy that connects to a target address (host:port)
+// through the proxy. Host names are resolved by the proxy.
+func (proxy *socksproxy) dial(ctx context.Context, target string) (net.Conn, error) {
+	host, portstr, err := net.SplitHostPort(target)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portstr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port '%s'", portstr)
+	}
+
+	// Connect to the proxy
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", proxy.addr)
+	if err != nil {
+		return nil, err
+	}
+
+	// Bound the handshake by the dial context
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(proxytimeout)
+	}
+	conn.SetDeadline(deadline)
+
+	if err := proxy.handshake(conn, host, uint16(port)); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("socks5 proxy %s - %s", proxy.addr, err)
+	}
+
+	conn.SetDeadline(time.Time{})
+	return conn, nil
+}
+
+// A method of socksproxy that authenticates with the proxy
+// and requests a connection to a host and port (RFC 1928)
+func (proxy *socksproxy) handshake(conn net.Conn, host string, port uint16) error {
+	// Offer the authentication methods
+	methods := []byte{0x00}
+	if proxy.username != "" {
+		methods = append(methods, 0x02)
+	}
+	if _, err := conn.Write(append([]byte{0x05, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return err
+	}
+	if reply[0] != 0x05 {
+		return errors.New("not a socks5 proxy")
+	}
+
+	switch reply[1] {
+	case 0x00:
+	case 0x02:
+		// Authenticate with the username and password (RFC 1929)
+		if len(proxy.username) > 255 || len(proxy.password) > 255 {
+			return errors.New("credentials are too long")
+		}
+
+		auth := []byte{0x01, byte(len(proxy.username))}
+		auth = append(auth, proxy.username...)
+		auth = append(auth, byte(len(proxy.password)))
+		auth = append(auth, proxy.password...)
+		if _, err := conn.Write(auth); err != nil {
+			return err
+		}
+
+		if _, err := io.ReadFull(conn, reply); err != nil {
+			return err
+		}
+		if reply[1] != 0x00 {
+			return errors.New("authentication failed")
+		}
+	default:
+		return errors.New("no acceptable authentication method")
+	}
+
+	// Request a connection to the target
+	request := []byte{0x05, 0x01, 0x00}
+	if ip := net.ParseIP(host); ip != nil {
+		if ip4 := ip.To4(); ip4 != nil {
+			request = append(request, 0x01)
+			request = append(request, ip4...)
+		} else {
+			request = append(request, 0x04)
+			request = append(request, ip.To16()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return errors.New("host name is too long")
+		}
+		request = append(request, 0x03, byte(len(host)))
+		request = append(request, host...)
+	}
+	request = append(request, byte(port>>8), byte(port))
+
+	if _, err := conn.Write(request); err != nil {
+		return err
+	}
+
+	// Read the reply and skip the bound address
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return err
+	}
+	if header[1] != 0x00 {
+		return fmt.Errorf("connection refused by the proxy (code %d)", header[1])
+	}
+
+	var skip int
+	switch header[3] {
+	case 0x01:
+		skip = net.IPv4len
+	case 0x04:
+		skip = net.IPv6len
+	case 0x03:
+		length := make([]byte, 1)
+		if _, err := io.ReadFull(conn, length); err != nil {
+			return err
+		}
+		skip = int(length[0])
+	default:
+		return errors.New("invalid reply from the proxy")
+	}
+
+	_, err := io.ReadFull(conn, make([]byte, skip+2))
+	return err
+}
+
+// A structure that represents a TCP transport that dials
+// peers through a SOCKS5 proxy, while listening directly
+type proxytransport struct {
+	*tcp.TcpTransport
+
+	// Represents the proxy to dial through
+	proxy *socksproxy
+	// Represents whether onion addresses can be dialed (the proxy is Tor)
+	onion bool
+}
+
+// A structure that represents a connection dialed through the
+// proxy, which reports the multiaddr of the peer as its remote address
+type proxyconn struct {
+	net.Conn
+
+	laddr multiaddr.Multiaddr
+	raddr multiaddr.Multiaddr
+}
+
+// A function that returns a constructor of a TCP transport that dials
+// through a SOCKS5 proxy, for use with the libp2p transport option.
+// Onion addresses can also be dialed if onion is set.
+func proxytcp(proxy *socksproxy, onion bool) func(upgrader *tptu.Upgrader) *proxytransport {
+	return func(upgrader *tptu.Upgrader) *proxytransport {
+		return &proxytransport{
+			TcpTransport: tcp.NewTCPTransport(upgrader),
+			proxy:        proxy,
+			onion:        onion,
+		}
+	}
+}
+
+// A method of proxytransport that returns whether it can dial an address
+func (t *proxytransport) CanDial(addr multiaddr.Multiaddr) bool {
+	if t.onion && isonion(addr) {
+		return true
+	}
+
+	return t.TcpTransport.CanDial(addr)
+}
+
+// A method of proxytransport that returns the protocols it handles
+func (t *proxytransport) Protocols() []int {
+	if t.onion {
+		return []int{multiaddr.P_TCP, multiaddr.P_ONION3}
+	}
+
+	return t.TcpTransport.Protocols()
+}
+
+// A method of proxytransport that dials a peer through the proxy
+// and upgrades the connection to a secure multiplexed connection
+func (t *proxytransport) Dial(ctx context.Context, raddr multiaddr.Multiaddr, p peer.ID) (transport.CapableConn, error) {
+	var target string
+	if t.onion && isonion(raddr) {
+		// Onion addresses are reached through Tor by their host name
+		value, _ := raddr.ValueForProtocol(multiaddr.P_ONION3)
+		parts := strings.SplitN(value, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid onion address %s", raddr)
+		}
+		target = net.JoinHostPort(parts[0]+".onion", parts[1])
+	} else {
+		_, hostport, err := manet.DialArgs(raddr)
+		if err != nil {
+			return nil, err
+		}
+		target = hostport
+	}
+
+	conn, err := t.proxy.dial(ctx, target)
+	if err != nil {
+		return nil, err
+	}
+
+	laddr, err := manet.FromNetAddr(conn.LocalAddr())
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return t.Upgrader.UpgradeOutbound(ctx, t, &proxyconn{Conn: conn, laddr: laddr, raddr: raddr}, p)
+}
+
+// A function that returns whether a multiaddr is an onion address
+func isonion(addr multiaddr.Multiaddr) bool {
+	_, err := addr.ValueForProtocol(multiaddr.P_ONION3)
+	return err == nil
+}
+
+// A method of proxyconn that returns the local multiaddr of the connection
+func (conn *proxyconn) LocalMultiaddr() multiaddr.Multiaddr {
+	return conn.laddr
+}
+
+// A method of proxyconn that returns the multiaddr of the peer
+func (conn *proxyconn) RemoteMultiaddr() multiaddr.Multiaddr {
+	return conn.raddr
+}
+
+// A method of proxytransport that describes the transport
+func (t *proxytransport) String() string {
+	return fmt.Sprintf("TCP via SOCKS5 %s", t.proxy.addr)
+}
@@ -0,0 +1,184 @@
+package src
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Represents the spam score above which the messages of a sender are hidden.
+// A sender is shown again once their score decays below half of it.
+const spamthreshold = 10.0
+
+// Represents the half-life of the spam score of a sender
+const spamhalflife = 10 * time.Second
+
+// Represents the number of recent messages of a sender checked for duplicates
+const spamrecent = 5
+
+// Represents the number of hidden messages kept for each sender
+const spamhidden = 100
+
+// A structure that represents the spam state of a sender
+type spamsender struct {
+	// Represents the decaying spam score and the time it was last updated
+	score   float64
+	updated time.Time
+	// Represents the recent messages of the sender (normalized)
+	recent []string
+	// Represents whether the messages of the sender are hidden
+	hidden bool
+	// Represents the hidden messages and the number not yet reported
+	messages   []ChatMessage
+	unreported int
+	// Represents whether the sender was allowed with /show
+	allowed bool
+}
+
+// A structure that represents the spam tracker of the UI. Every message adds to
+// the decaying spam score of its sender, more so for duplicates of their recent
+// messages and for senders with a negative GossipSub peer score. The messages
+// of a sender are hidden while their score is above the threshold.
+type spamtracker struct {
+	// Represents the spam state of the senders
+	senders map[peer.ID]*spamsender
+	// Represents the lock on the spam state
+	mutex sync.Mutex
+}
+
+// A constructor function that generates and returns an empty spamtracker
+func newspamtracker() *spamtracker {
+	return &spamtracker{senders: make(map[peer.ID]*spamsender)}
+}
+
+// A method of spamtracker that scores a message of a sender with their
+// GossipSub peer score and returns whether the message must be hidden and
+// whether it is the first hidden message of a flood. Hidden messages are
+// kept so that they can be shown with /show.
+func (st *spamtracker) check(senderid peer.ID, msg ChatMessage, peerscore float64) (bool, bool) {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sender, ok := st.senders[senderid]
+	if !ok {
+		sender = &spamsender{}
+		st.senders[senderid] = sender
+	}
+
+	if sender.allowed {
+		return false, false
+	}
+
+	// Decay the score since the last message
+	now := time.Now()
+	if !sender.updated.IsZero() {
+		sender.score *= math.Pow(0.5, now.Sub(sender.updated).Seconds()/spamhalflife.Seconds())
+	}
+	sender.updated = now
+
+	// Every message adds to the score, duplicates even more
+	sender.score++
+
+	normalized := strings.ToLower(strings.TrimSpace(msg.Message))
+	for _, recent := range sender.recent {
+		if recent == normalized {
+			sender.score += 3
+			break
+		}
+	}
+
+	sender.recent = append(sender.recent, normalized)
+	if len(sender.recent) > spamrecent {
+		sender.recent = sender.recent[1:]
+	}
+
+	// Peers penalized by the GossipSub router are more suspicious
+	if peerscore < 0 {
+		sender.score += math.Min(-peerscore/10, 5)
+	}
+
+	washidden := sender.hidden
+	switch {
+	case sender.score > spamthreshold:
+		sender.hidden = true
+	case sender.score < spamthreshold/2:
+		sender.hidden = false
+	}
+
+	if !sender.hidden {
+		return false, false
+	}
+
+	sender.messages = append(sender.messages, msg)
+	if len(sender.messages) > spamhidden {
+		sender.messages = sender.messages[1:]
+	}
+	sender.unreported++
+
+	return true, !washidden
+}
+
+// A method of spamtracker that returns the number of hidden messages of
+// each sender that have not been reported since their messages stopped
+// being hidden (or were last reported), and marks them as reported
+func (st *spamtracker) report() map[peer.ID]int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	reports := make(map[peer.ID]int)
+	now := time.Now()
+
+	for senderid, sender := range st.senders {
+		if sender.unreported == 0 {
+			continue
+		}
+
+		// Report once the score has decayed, or periodically during a flood
+		score := sender.score * math.Pow(0.5, now.Sub(sender.updated).Seconds()/spamhalflife.Seconds())
+		if score < spamthreshold/2 || sender.unreported >= 25 {
+			reports[senderid] = sender.unreported
+			sender.unreported = 0
+		}
+	}
+
+	return reports
+}
+
+// A method of spamtracker that returns and forgets the hidden messages of a
+// sender and shows their later messages for the rest of the session
+func (st *spamtracker) show(senderid peer.ID) []ChatMessage {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	sender, ok := st.senders[senderid]
+	if !ok {
+		sender = &spamsender{}
+		st.senders[senderid] = sender
+	}
+
+	messages := sender.messages
+	sender.messages = nil
+	sender.unreported = 0
+	sender.hidden = false
+	sender.allowed = true
+
+	return messages
+}
+
+// A method of spamtracker that returns the number of hidden messages of each sender
+func (st *spamtracker) hiddencounts() map[peer.ID]int {
+	st.mutex.Lock()
+	defer st.mutex.Unlock()
+
+	counts := make(map[peer.ID]int)
+	for senderid, sender := range st.senders {
+		if len(sender.messages) > 0 {
+			counts[senderid] = len(sender.messages)
+		}
+	}
+
+	return counts
+}
@@ -0,0 +1,57 @@
+package src
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// A structure that represents a step in the startup of the node
+type StartupEvent struct {
+	// Represents the stage of the startup ('identity', 'listen', 'bootstrap' or 'discovery')
+	Stage string
+	// Represents the progress made in the stage
+	Message string
+	// Represents the time of the progress
+	Time time.Time
+}
+
+// Represents the channel that the progress of the startup is reported on and the lock on it
+var (
+	startupevents chan StartupEvent
+	startuplock   sync.Mutex
+)
+
+// A function that starts reporting the progress of the startup of the node
+// and returns the channel of the progress, until StopStartup is called
+func WatchStartup() <-chan StartupEvent {
+	startuplock.Lock()
+	defer startuplock.Unlock()
+
+	startupevents = make(chan StartupEvent, 64)
+	return startupevents
+}
+
+// A function that stops reporting the progress of the startup of the node
+func StopStartup() {
+	startuplock.Lock()
+	defer startuplock.Unlock()
+
+	startupevents = nil
+}
+
+// A function that reports progress in a stage of the startup of the node, if
+// the startup is watched. The progress is dropped if the watcher falls behind.
+func reportstartup(stage string, format string, args ...interface{}) {
+	startuplock.Lock()
+	defer startuplock.Unlock()
+
+	if startupevents == nil {
+		return
+	}
+
+	select {
+	case startupevents <- StartupEvent{Stage: stage, Message: fmt.Sprintf(format, args...), Time: time.Now()}:
+	default:
+	}
+}
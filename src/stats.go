@@ -0,0 +1,173 @@
+package src
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/metrics"
+	"github.com/libp2p/go-libp2p-core/network"
+)
+
+// A structure that represents the statistics of a session of the application,
+// summarized on exit for the operators of relays and bots
+type SessionStats struct {
+	// Represents when the session started
+	Started time.Time `json:"started"`
+	// Represents how long the session lasted, in seconds
+	Duration float64 `json:"duration"`
+	// Represents the rooms joined in the session, in the order they were joined
+	Rooms []string `json:"rooms"`
+	// Represents the number of messages sent and received in the rooms
+	Sent     int `json:"sent"`
+	Received int `json:"received"`
+	// Represents the largest number of peers the host was connected to at once
+	PeakPeers int `json:"peakpeers"`
+	// Represents the number of bytes received and sent over the connections
+	BytesIn  int64 `json:"bytesin"`
+	BytesOut int64 `json:"bytesout"`
+}
+
+// A structure that represents the counters of the statistics of the session
+type sessioncounter struct {
+	// Represents when the session started
+	started time.Time
+	// Represents the rooms joined in the session
+	rooms []string
+	// Represents the number of messages sent and received in the rooms
+	sent     int
+	received int
+	// Represents the largest number of peers the host was connected to at once
+	peakpeers int
+	// Represents the counter of the bytes transferred by the hosts
+	bandwidth *metrics.BandwidthCounter
+	// Represents the lock on the counters
+	lock sync.Mutex
+}
+
+// Represents the statistics of the session and the file they are appended to on exit (empty if none)
+var (
+	stats     = &sessioncounter{started: time.Now(), bandwidth: metrics.NewBandwidthCounter()}
+	statsfile string
+)
+
+// A function that sets the file that the statistics of the session
+// are appended to on exit as a JSON line, or none if it is empty
+func SetStatsFile(path string) {
+	statsfile = path
+}
+
+// A method of sessioncounter that records a joined room
+func (counter *sessioncounter) joined(room string) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+
+	for _, joined := range counter.rooms {
+		if joined == room {
+			return
+		}
+	}
+	counter.rooms = append(counter.rooms, room)
+}
+
+// A method of sessioncounter that counts the messages sent and received in the rooms
+func (counter *sessioncounter) count(sent, received int) {
+	counter.lock.Lock()
+	defer counter.lock.Unlock()
+
+	counter.sent += sent
+	counter.received += received
+}
+
+// A method of sessioncounter that returns the notifiee which
+// records the largest number of peers the host is connected to
+func (counter *sessioncounter) notifiee() network.Notifiee {
+	return &network.NotifyBundle{
+		ConnectedF: func(net network.Network, conn network.Conn) {
+			peers := len(net.Peers())
+
+			counter.lock.Lock()
+			if peers > counter.peakpeers {
+				counter.peakpeers = peers
+			}
+			counter.lock.Unlock()
+		},
+	}
+}
+
+// A function that returns the statistics of the session so far
+func SessionSummary() SessionStats {
+	stats.lock.Lock()
+	defer stats.lock.Unlock()
+
+	bandwidth := stats.bandwidth.GetBandwidthTotals()
+	return SessionStats{
+		Started:   stats.started,
+		Duration:  time.Since(stats.started).Seconds(),
+		Rooms:     append([]string{}, stats.rooms...),
+		Sent:      stats.sent,
+		Received:  stats.received,
+		PeakPeers: stats.peakpeers,
+		BytesIn:   bandwidth.TotalIn,
+		BytesOut:  bandwidth.TotalOut,
+	}
+}
+
+// A method of SessionStats that returns the summary of the session for the terminal
+func (summary SessionStats) String() string {
+	rooms := "none"
+	if len(summary.Rooms) > 0 {
+		rooms = strings.Join(summary.Rooms, ", ")
+	}
+
+	var builder strings.Builder
+	builder.WriteString("Session summary\n")
+	fmt.Fprintf(&builder, "  duration:    %s\n", time.Duration(summary.Duration*float64(time.Second)).Round(time.Second))
+	fmt.Fprintf(&builder, "  rooms:       %s\n", rooms)
+	fmt.Fprintf(&builder, "  messages:    %d sent, %d received\n", summary.Sent, summary.Received)
+	fmt.Fprintf(&builder, "  peak peers:  %d\n", summary.PeakPeers)
+	fmt.Fprintf(&builder, "  transferred: %s in, %s out\n", humanbytes(summary.BytesIn), humanbytes(summary.BytesOut))
+
+	return builder.String()
+}
+
+// A function that returns a number of bytes in a human readable unit
+func humanbytes(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(bytes)/float64(div), "KMGTPE"[exp])
+}
+
+// A function that appends the statistics of a session to the statistics file
+// as a JSON line, if one is set, so that the sessions can be compared over time
+func SaveSessionStats(summary SessionStats) error {
+	if statsfile == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(summary)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(expandhome(statsfile), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(data, '\n'))
+	return err
+}
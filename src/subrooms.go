@@ -0,0 +1,160 @@
+package src
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+)
+
+// Represents the separator of the namespace and the sub-room in room names
+const roomseparator = "/"
+
+// A function that returns the namespace of a room, which is the room itself
+// unless it is a sub-room such as 'project/dev'. Sub-rooms have separate
+// topics but share the settings and membership of their namespace.
+func rootroom(room string) string {
+	return strings.SplitN(room, roomseparator, 2)[0]
+}
+
+// A method of P2P that checks if the host has joined a room in a namespace
+func (p2p *P2P) joinednamespace(room string) bool {
+	root := rootroom(room)
+
+	p2p.topiclock.Lock()
+	defer p2p.topiclock.Unlock()
+
+	for name := range p2p.topics {
+		if joined, ok := topicroom(name); ok && rootroom(joined) == root {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of P2P that returns the connected peers of the
+// joined rooms in the namespace of a room, without duplicates
+func (p2p *P2P) roompeers(room string) []peer.ID {
+	root := rootroom(room)
+
+	p2p.topiclock.Lock()
+	defer p2p.topiclock.Unlock()
+
+	seen := make(map[peer.ID]bool)
+	peers := []peer.ID{}
+	for name, shared := range p2p.topics {
+		if joined, ok := topicroom(name); !ok || rootroom(joined) != root {
+			continue
+		}
+
+		for _, peerid := range shared.topic.ListPeers() {
+			if !seen[peerid] {
+				seen[peerid] = true
+				peers = append(peers, peerid)
+			}
+		}
+	}
+
+	return peers
+}
+
+// A method of UI that shows the known rooms as a tree of namespaces and
+// their sub-rooms, including the channels listed in the room settings.
+// Selecting a room switches to it. Must be called from the tview event loop.
+func (ui *UI) showroomtree() {
+	ui.stateLock.Lock()
+	rooms := append([]string{}, ui.rooms...)
+	ui.stateLock.Unlock()
+
+	// Group the rooms by namespace
+	namespaces := make(map[string]map[string]bool)
+	add := func(room string) {
+		root := rootroom(room)
+		if namespaces[root] == nil {
+			namespaces[root] = make(map[string]bool)
+		}
+		if room != root {
+			namespaces[root][room] = true
+		}
+	}
+
+	for _, room := range rooms {
+		add(room)
+
+		if settings := roomsettings.get(room); settings != nil {
+			for _, channel := range settings.Channels {
+				add(rootroom(room) + roomseparator + channel)
+			}
+		}
+	}
+
+	names := make([]string, 0, len(namespaces))
+	for name := range namespaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tree := tview.NewTreeNode("rooms").SetSelectable(false)
+	var current *tview.TreeNode
+	node := func(room string, text string) *tview.TreeNode {
+		child := tview.NewTreeNode(tview.Escape(text)).SetReference(room)
+		if room == ui.RoomName {
+			child.SetColor(currentconfig().accent())
+			current = child
+		}
+		return child
+	}
+
+	for _, name := range names {
+		parent := node(name, name)
+		tree.AddChild(parent)
+
+		subrooms := make([]string, 0, len(namespaces[name]))
+		for room := range namespaces[name] {
+			subrooms = append(subrooms, room)
+		}
+		sort.Strings(subrooms)
+
+		for _, room := range subrooms {
+			parent.AddChild(node(room, strings.TrimPrefix(room, name+roomseparator)))
+		}
+	}
+
+	view := tview.NewTreeView().SetRoot(tree)
+	view.SetBorder(true).SetTitle("Rooms (Enter to switch, Esc to close)")
+	if current != nil {
+		view.SetCurrentNode(current)
+	}
+
+	close := func() {
+		ui.pages.RemovePage("rooms")
+		ui.TerminalApp.SetFocus(ui.inputBox)
+	}
+
+	view.SetSelectedFunc(func(selected *tview.TreeNode) {
+		room, ok := selected.GetReference().(string)
+		close()
+
+		if ok && room != ui.RoomName {
+			go roomcommand(ui, room)
+		}
+	})
+	view.SetDoneFunc(func(key tcell.Key) {
+		close()
+	})
+
+	// Center the tree on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, 20, 1, true).
+			AddItem(nil, 0, 1, false), 50, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("rooms", popup, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
@@ -0,0 +1,375 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the protocol ID for the terminal sessions shared between peers
+const termprotocol = protocol.ID("/peerchat/term/1.0.0")
+
+// Represents the interval at which the output of a shared terminal is read
+const termpoll = 50 * time.Millisecond
+
+// Represents the number of bytes of a shared terminal kept in a viewer pane
+const maxtermview = 256 * 1024
+
+// A structure that represents the header of a shared terminal stream, which
+// is followed by the raw output of the terminal of the sharing peer
+type termheader struct {
+	// Represents the user name of the sharing peer
+	Name string `json:"name"`
+	// Represents the shell that is shared
+	Shell string `json:"shell"`
+}
+
+// A structure that represents a terminal shared by a peer, handed to the UI
+type termshare struct {
+	// Represents the sharing peer
+	peer peer.ID
+	// Represents the header of the stream
+	header termheader
+	// Represents the stream of the output of the terminal
+	stream network.Stream
+	// Represents the reader of the output past the header
+	reader io.Reader
+}
+
+// A method of P2P that returns the channel of the terminals shared by peers.
+// Shares are reset when the channel is not read.
+func (p2p *P2P) TermShares() <-chan termshare {
+	return p2p.termshares
+}
+
+// A method of P2P that handles the terminal sessions shared by peers
+func (p2p *P2P) handleterm(stream network.Stream) {
+	// The header must fit in the buffer of the reader
+	reader := bufio.NewReader(stream)
+	line, err := reader.ReadSlice('\n')
+	if err != nil {
+		stream.Reset()
+		return
+	}
+
+	var header termheader
+	if err := json.Unmarshal(line, &header); err != nil {
+		stream.Reset()
+		return
+	}
+
+	share := termshare{peer: stream.Conn().RemotePeer(), header: header, stream: stream, reader: reader}
+	select {
+	case p2p.termshares <- share:
+	default:
+		stream.Reset()
+	}
+}
+
+// A function that returns the command that runs a shell on a new PTY with
+// script(1), writing the output of the terminal to a file as it is written
+func scriptcommand(shell string, path string) *exec.Cmd {
+	switch runtime.GOOS {
+	case "darwin", "freebsd", "openbsd", "netbsd":
+		return exec.Command("script", "-q", "-F", path, shell)
+	default:
+		return exec.Command("script", "-q", "-f", "-c", shell, path)
+	}
+}
+
+// A method of UI that shares a shell with peers, read-only. The terminal UI is
+// suspended while the shell runs on a new PTY in the terminal of the user, and
+// everything the shell writes is streamed to the peers until it exits.
+func (ui *UI) shareterm(peerids []peer.ID) error {
+	shell := os.Getenv("SHELL")
+	if shell == "" {
+		shell = "sh"
+	}
+	if _, err := exec.LookPath("script"); err != nil {
+		return errors.New("sharing a terminal needs script(1)")
+	}
+
+	// Open the streams to the peers
+	header, _ := json.Marshal(termheader{Name: ui.UserName, Shell: shell})
+	var streams []network.Stream
+	for _, peerid := range peerids {
+		ctx, cancel := context.WithTimeout(ui.Host.Ctx, dmtimeout)
+		stream, err := ui.Host.Host.NewStream(ctx, peerid, termprotocol)
+		cancel()
+
+		if err == nil {
+			_, err = stream.Write(append(header, '\n'))
+		}
+		if err != nil {
+			ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "share", logmsg: fmt.Sprintf("cannot share with %s - %s", peerid.ShortString(), err)}
+			continue
+		}
+		streams = append(streams, stream)
+	}
+	if len(streams) == 0 {
+		return errors.New("none of the peers could be reached")
+	}
+
+	// The output of the terminal is written to a file by script(1)
+	file, err := ioutil.TempFile("", "peerchat-term-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	defer file.Close()
+
+	done := make(chan struct{})
+	var wait sync.WaitGroup
+	wait.Add(1)
+	go func() {
+		defer wait.Done()
+		streamterm(file, streams, done)
+	}()
+
+	var runerr error
+	ui.TerminalApp.Suspend(func() {
+		fmt.Printf("Sharing this terminal read-only with %d peers. Exit the shell to return to PeerChat.\n", len(streams))
+
+		cmd := scriptcommand(shell, file.Name())
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+		runerr = cmd.Run()
+	})
+
+	close(done)
+	wait.Wait()
+	return runerr
+}
+
+// A function that streams what is written to the output file of a shared
+// terminal to the streams of the peers, until the share is done and the rest
+// of the file has been sent. The streams of the peers that fail are dropped.
+func streamterm(file *os.File, streams []network.Stream, done <-chan struct{}) {
+	buffer := make([]byte, 32*1024)
+	finished := false
+
+	for {
+		count, err := file.Read(buffer)
+		if count > 0 {
+			open := streams[:0]
+			for _, stream := range streams {
+				stream.SetWriteDeadline(time.Now().Add(dmtimeout))
+				if _, err := stream.Write(buffer[:count]); err != nil {
+					stream.Reset()
+					continue
+				}
+				open = append(open, stream)
+			}
+			streams = open
+		}
+
+		if err != nil && err != io.EOF {
+			break
+		}
+		if count > 0 {
+			continue
+		}
+
+		// Read the rest of the file once more after the shell has exited
+		if finished {
+			break
+		}
+		select {
+		case <-done:
+			finished = true
+		case <-time.After(termpoll):
+		}
+	}
+
+	for _, stream := range streams {
+		stream.Close()
+	}
+}
+
+// A structure that represents a writer that keeps the text and the colors of
+// the output of a terminal for a viewer pane, dropping the other control codes
+// (such as the cursor movements) which a text view cannot follow
+type termfilter struct {
+	// Represents the writer of the viewer pane
+	out io.Writer
+	// Represents the incomplete escape sequence at the end of the last write
+	pending []byte
+}
+
+// A function that returns the length of the escape sequence at the start of
+// data, or -1 if it is incomplete. Control sequences (CSI) end with a final
+// byte, operating system commands (OSC) with a BEL or ST, others after a byte.
+func escapelength(data []byte) int {
+	if len(data) < 2 {
+		return -1
+	}
+
+	switch data[1] {
+	case '[':
+		for index := 2; index < len(data); index++ {
+			if data[index] >= 0x40 && data[index] <= 0x7e {
+				return index + 1
+			}
+		}
+	case ']':
+		for index := 2; index < len(data); index++ {
+			if data[index] == 0x07 {
+				return index + 1
+			}
+			if data[index] == 0x1b && index+1 < len(data) && data[index+1] == '\\' {
+				return index + 2
+			}
+		}
+	default:
+		return 2
+	}
+
+	return -1
+}
+
+// A method of termfilter that writes the text and the color codes of terminal output
+func (filter *termfilter) Write(data []byte) (int, error) {
+	written := len(data)
+	data = append(filter.pending, data...)
+	filter.pending = nil
+
+	// The text is escaped so that it is not read as color tags
+	var clean, text []byte
+	flush := func() {
+		clean = append(clean, tview.Escape(string(text))...)
+		text = text[:0]
+	}
+
+	for index := 0; index < len(data); index++ {
+		switch char := data[index]; {
+		case char == 0x1b:
+			length := escapelength(data[index:])
+			if length < 0 {
+				// Keep the incomplete sequence for the next write, unless it never ends
+				if len(data)-index < 4096 {
+					filter.pending = append([]byte{}, data[index:]...)
+				}
+				index = len(data)
+				continue
+			}
+
+			// Keep the color codes (SGR) only
+			if sequence := data[index : index+length]; sequence[1] == '[' && sequence[length-1] == 'm' {
+				flush()
+				clean = append(clean, sequence...)
+			}
+			index += length - 1
+
+		case char == '\n' || char == '\t' || (char >= 0x20 && char != 0x7f):
+			text = append(text, char)
+		}
+	}
+	flush()
+
+	_, err := filter.out.Write(clean)
+	return written, err
+}
+
+// A structure that represents the viewer pane of a terminal shared by a peer
+type termviewer struct {
+	// Represents the user name of the sharing peer
+	name string
+	// Represents the text view with the output of the terminal
+	view *tview.TextView
+}
+
+// A method of UI that finds the peer of a shared terminal by the
+// user name of the peer or by the end of its peer ID
+func (ui *UI) findterm(query string) (peer.ID, bool) {
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	for peerid, viewer := range ui.termviews {
+		if viewer.name == query || strings.HasSuffix(peerid.Pretty(), query) {
+			return peerid, true
+		}
+	}
+
+	return "", false
+}
+
+// A method of UI that opens the viewer pane of a terminal shared by a peer and
+// writes the output of the terminal into it until the share ends. The pane is
+// shown with /view-term. Must not be called from the tview event loop.
+func (ui *UI) viewterm(share termshare) {
+	name := stripcontrols(share.header.Name)
+	if name == "" {
+		pretty := share.peer.Pretty()
+		name = pretty[len(pretty)-8:]
+	}
+
+	view := tview.NewTextView().
+		SetDynamicColors(true).
+		SetScrollable(true).
+		SetMaxLines(maxtermview / 80).
+		SetChangedFunc(func() {
+			ui.TerminalApp.Draw()
+		})
+	view.SetBorder(true).SetTitle(tview.Escape(fmt.Sprintf("Terminal of %s (read-only, Esc to close)", name)))
+
+	ui.stateLock.Lock()
+	ui.termviews[share.peer] = &termviewer{name: name, view: view}
+	ui.stateLock.Unlock()
+
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "share", logmsg: fmt.Sprintf("%s is sharing their terminal, /view-term %s to watch", name, name)}
+
+	filter := &termfilter{out: tview.ANSIWriter(view)}
+	io.Copy(filter, share.reader)
+	share.stream.Close()
+
+	fmt.Fprintf(view, "\n[gray]The terminal is no longer shared.[-]\n")
+	ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "share", logmsg: fmt.Sprintf("%s stopped sharing their terminal", name)}
+}
+
+// A method of UI that shows the viewer pane of the terminal shared by a peer.
+// Must be called from the tview event loop.
+func (ui *UI) showterm(peerid peer.ID) bool {
+	ui.stateLock.Lock()
+	viewer, ok := ui.termviews[peerid]
+	ui.stateLock.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	view := viewer.view
+	view.SetDoneFunc(func(key tcell.Key) {
+		ui.pages.RemovePage("term")
+		ui.TerminalApp.SetFocus(ui.inputBox)
+	})
+	view.ScrollToEnd()
+
+	// Center the viewer on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, 30, 1, true).
+			AddItem(nil, 0, 1, false), 100, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("term", popup, true, true)
+	ui.TerminalApp.SetFocus(view)
+	return true
+}
@@ -0,0 +1,72 @@
+package src
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+)
+
+// Represents the number of bytes of the HMAC kept in the name of a hashed topic
+const topichashlength = 16
+
+// Represents whether the topics of the rooms are derived from an HMAC of
+// their names. Like the network, it is fixed once the node has started.
+var hashedtopics bool
+
+// Represents the room names of the hashed topics that have been derived,
+// since a room name cannot be recovered from its hashed topic
+var hashedrooms sync.Map
+
+// A method of Config that sets whether the topics of the rooms are hashed,
+// overriding the configuration file. All the members of a room must agree.
+func (cfg *Config) SetHashTopics(enabled bool) {
+	configlock.Lock()
+	cfg.HashTopics = enabled
+	hashedtopics = enabled
+	configlock.Unlock()
+}
+
+// A function that returns whether the topics of the rooms are hashed
+func hashingtopics() bool {
+	configlock.RLock()
+	defer configlock.RUnlock()
+
+	return hashedtopics
+}
+
+// A function that returns the hashed topic name of a room, which is an HMAC of
+// the room name keyed with the namespace of the network. Observers of the
+// network cannot tell the names of the rooms from their topics, though they
+// can still check whether a topic belongs to a room name they guess.
+func hashtopic(roomname string) string {
+	topic := topicprefix() + "h-" + hashroom(roomname)
+	hashedrooms.Store(topic, roomname)
+
+	return topic
+}
+
+// A function that returns the hex encoded HMAC of a room name, keyed with the namespace of the network
+func hashroom(roomname string) string {
+	return hashroomin(servicename(), roomname)
+}
+
+// A function that returns the hashed name of a room in the network of a
+// namespace, for the keys of other networks that share the same DHT
+func hashroomin(namespace, roomname string) string {
+	mac := hmac.New(sha256.New, []byte(namespace))
+	mac.Write([]byte(roomname))
+
+	return hex.EncodeToString(mac.Sum(nil)[:topichashlength])
+}
+
+// A function that returns the room name of a hashed topic,
+// or false if the topic has not been derived by the host
+func hashedroom(topic string) (string, bool) {
+	roomname, ok := hashedrooms.Load(topic)
+	if !ok {
+		return "", false
+	}
+
+	return roomname.(string), true
+}
@@ -0,0 +1,273 @@
+package src
+
+import (
+	"bufio"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the default addresses of the SOCKS and control ports
+// of Tor and the default port of the onion service
+const (
+	defaulttorsocks   = "127.0.0.1:9050"
+	defaulttorcontrol = "127.0.0.1:9051"
+	defaultonionport  = 4001
+)
+
+// Represents the time allowed for the Tor control port to respond
+const tortimeout = 30 * time.Second
+
+// A structure that represents a connection to the control port of Tor
+type torcontrol struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// A structure that represents the onion service of the node, through
+// which other peers reach the node over Tor. The onion service is removed
+// by Tor when the control connection that created it is closed.
+type onionservice struct {
+	// Represents the Tor settings of the configuration
+	config TorConfig
+	// Represents the control connection that keeps the service alive
+	control *torcontrol
+	// Represents the onion multiaddr of the node (nil until published)
+	addr multiaddr.Multiaddr
+	// Represents the lock on the onion service
+	mutex sync.Mutex
+}
+
+// A function that returns the path of the onion service key of the
+// selected profile, which keeps the onion address the same across restarts
+func onionkeypath() string {
+	return filepath.Join(defaultdir(), "onion.key")
+}
+
+// A function that connects and authenticates to the control port of Tor,
+// with the password if one is given, else with cookie or no authentication
+func dialtorcontrol(addr, password string) (*torcontrol, error) {
+	conn, err := net.DialTimeout("tcp", addr, tortimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	tc := &torcontrol{conn: conn, reader: bufio.NewReader(conn)}
+	if err := tc.authenticate(password); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return tc, nil
+}
+
+// A method of torcontrol that sends a command and returns the
+// lines of a successful reply without their status codes
+func (tc *torcontrol) command(cmd string) ([]string, error) {
+	tc.conn.SetDeadline(time.Now().Add(tortimeout))
+	defer tc.conn.SetDeadline(time.Time{})
+
+	if _, err := fmt.Fprintf(tc.conn, "%s\r\n", cmd); err != nil {
+		return nil, err
+	}
+
+	var lines []string
+	for {
+		line, err := tc.reader.ReadString('\n')
+		if err != nil {
+			return nil, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if len(line) < 4 {
+			return nil, fmt.Errorf("invalid reply from tor - %s", line)
+		}
+		if line[:3] != "250" {
+			return nil, fmt.Errorf("tor - %s", line[4:])
+		}
+
+		lines = append(lines, line[4:])
+		// A space after the status code marks the last line of the reply
+		if line[3] == ' ' {
+			return lines, nil
+		}
+	}
+}
+
+// A method of torcontrol that authenticates to the control port
+func (tc *torcontrol) authenticate(password string) error {
+	if password != "" {
+		_, err := tc.command(fmt.Sprintf("AUTHENTICATE %s", strconv.Quote(password)))
+		return err
+	}
+
+	// Discover the authentication methods of the control port
+	lines, err := tc.command("PROTOCOLINFO 1")
+	if err != nil {
+		return err
+	}
+
+	var methods, cookiefile string
+	for _, line := range lines {
+		if !strings.HasPrefix(line, "AUTH ") {
+			continue
+		}
+
+		for _, field := range strings.Fields(line[5:]) {
+			switch {
+			case strings.HasPrefix(field, "METHODS="):
+				methods = strings.TrimPrefix(field, "METHODS=")
+			case strings.HasPrefix(field, "COOKIEFILE="):
+				cookiefile, _ = strconv.Unquote(strings.TrimPrefix(field, "COOKIEFILE="))
+			}
+		}
+	}
+
+	switch {
+	case strings.Contains(methods, "NULL"):
+		_, err = tc.command("AUTHENTICATE")
+
+	case strings.Contains(methods, "COOKIE") && cookiefile != "":
+		cookie, err := ioutil.ReadFile(cookiefile)
+		if err != nil {
+			return err
+		}
+		_, err = tc.command(fmt.Sprintf("AUTHENTICATE %s", hex.EncodeToString(cookie)))
+		return err
+
+	default:
+		err = fmt.Errorf("unsupported tor authentication methods '%s', set a control password", methods)
+	}
+
+	return err
+}
+
+// A method of torcontrol that closes the control connection
+func (tc *torcontrol) close() error {
+	return tc.conn.Close()
+}
+
+// A constructor function that creates the onion service of the
+// node for the Tor settings, which is published once the host listens
+func newonionservice(config TorConfig) *onionservice {
+	if config.Socks == "" {
+		config.Socks = defaulttorsocks
+	}
+	if config.Control == "" {
+		config.Control = defaulttorcontrol
+	}
+	if config.Port == 0 {
+		config.Port = defaultonionport
+	}
+
+	return &onionservice{config: config}
+}
+
+// A method of onionservice that returns the SOCKS proxy of Tor
+func (onion *onionservice) proxy() *socksproxy {
+	return &socksproxy{addr: onion.config.Socks}
+}
+
+// A method of onionservice that returns the onion multiaddr of the node
+// as the only address advertised to other peers, so that the addresses of
+// the host are never revealed. Nothing is advertised until it is published.
+func (onion *onionservice) addrs(addrs []multiaddr.Multiaddr) []multiaddr.Multiaddr {
+	onion.mutex.Lock()
+	defer onion.mutex.Unlock()
+
+	if onion.addr == nil {
+		return nil
+	}
+
+	return []multiaddr.Multiaddr{onion.addr}
+}
+
+// A method of onionservice that publishes the onion service with the key
+// of the profile (or a new key that is saved), forwarding it to a local port
+func (onion *onionservice) publish(localport int) error {
+	control, err := dialtorcontrol(onion.config.Control, onion.config.Password)
+	if err != nil {
+		return err
+	}
+
+	// Reuse the key of the profile to keep the onion address
+	key := "NEW:ED25519-V3"
+	if data, err := ioutil.ReadFile(onionkeypath()); err == nil {
+		key = strings.TrimSpace(string(data))
+	}
+
+	lines, err := control.command(fmt.Sprintf("ADD_ONION %s Port=%d,127.0.0.1:%d", key, onion.config.Port, localport))
+	if err != nil {
+		control.close()
+		return err
+	}
+
+	var serviceid, privkey string
+	for _, line := range lines {
+		switch {
+		case strings.HasPrefix(line, "ServiceID="):
+			serviceid = strings.TrimPrefix(line, "ServiceID=")
+		case strings.HasPrefix(line, "PrivateKey="):
+			privkey = strings.TrimPrefix(line, "PrivateKey=")
+		}
+	}
+
+	if serviceid == "" {
+		control.close()
+		return errors.New("tor did not return the onion service ID")
+	}
+
+	// Save a new key, readable only by the user
+	if privkey != "" {
+		err := os.MkdirAll(filepath.Dir(onionkeypath()), 0700)
+		if err == nil {
+			err = ioutil.WriteFile(onionkeypath(), []byte(privkey), 0600)
+		}
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"file":  onionkeypath(),
+			}).Warnln("Failed to Save the Onion Service Key!")
+		}
+	}
+
+	addr, err := multiaddr.NewMultiaddr(fmt.Sprintf("/onion3/%s:%d", serviceid, onion.config.Port))
+	if err != nil {
+		control.close()
+		return err
+	}
+
+	onion.mutex.Lock()
+	onion.control = control
+	onion.addr = addr
+	onion.mutex.Unlock()
+
+	return nil
+}
+
+// A method of onionservice that removes the onion service
+func (onion *onionservice) close() error {
+	onion.mutex.Lock()
+	defer onion.mutex.Unlock()
+
+	if onion.control == nil {
+		return nil
+	}
+
+	err := onion.control.close()
+	onion.control = nil
+	onion.addr = nil
+	return err
+}
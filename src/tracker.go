@@ -0,0 +1,413 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	coredisc "github.com/libp2p/go-libp2p-core/discovery"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/multiformats/go-multiaddr"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the protocol ID for registering with and discovering peers at a tracker,
+// which is a JSON protocol of peerchat and not the libp2p rendezvous protocol
+const trackerprotocol = protocol.ID("/peerchat/tracker/1.0.0")
+
+// Represents the default and the maximum time a registration is kept by a tracker
+const (
+	defaulttrackerttl = 2 * time.Hour
+	maxtrackerttl     = 72 * time.Hour
+)
+
+// Represents the limits of a tracker on namespaces, registrations and addresses
+const (
+	maxnamespacelength   = 255
+	maxregistrations     = 1000
+	maxpeerregistrations = 16
+	maxtrackeraddrs      = 16
+)
+
+// Represents the time between the discoveries of peers at the trackers
+const trackerinterval = time.Minute
+
+// A structure that represents a request to a tracker. Peers
+// 'register' their addresses under a namespace for a time, 'unregister'
+// them, or 'discover' the peers registered under a namespace.
+type trackerrequest struct {
+	// Represents the type of the request
+	Type string `json:"type"`
+	// Represents the namespace of the request
+	Namespace string `json:"namespace"`
+	// Represents the addresses of a registration
+	Addrs []string `json:"addrs,omitempty"`
+	// Represents the requested time of a registration in seconds
+	TTL int `json:"ttl,omitempty"`
+	// Represents the maximum number of peers to discover (all if zero)
+	Limit int `json:"limit,omitempty"`
+}
+
+// A structure that represents a peer registered at a tracker
+type trackerpeer struct {
+	// Represents the peer ID of the peer
+	PeerID string `json:"peerid"`
+	// Represents the addresses of the peer
+	Addrs []string `json:"addrs"`
+}
+
+// A structure that represents the reply of a tracker
+type trackerreply struct {
+	// Represents the reason the request was refused
+	Error string `json:"error,omitempty"`
+	// Represents the granted time of a registration in seconds
+	TTL int `json:"ttl,omitempty"`
+	// Represents the discovered peers
+	Peers []trackerpeer `json:"peers,omitempty"`
+}
+
+// A structure that represents a registration at a tracker
+type registration struct {
+	// Represents the addresses of the registered peer
+	addrs []string
+	// Represents when the registration expires
+	expires time.Time
+}
+
+// A structure that represents the registrations of a tracker
+type trackerserver struct {
+	// Represents the registrations indexed by namespace and peer
+	registrations map[string]map[peer.ID]registration
+	// Represents the lock on the registrations
+	mutex sync.Mutex
+}
+
+// A method of P2P that serves as a tracker, where peers register
+// their addresses under a namespace and discover the other registered peers.
+// Meant for relay nodes of small private deployments.
+func (p2p *P2P) ServeTracker() {
+	p2p.tracker = &trackerserver{registrations: make(map[string]map[peer.ID]registration)}
+	p2p.Host.SetStreamHandler(trackerprotocol, p2p.handletracker)
+}
+
+// A method of P2P that answers the requests of the peers to the tracker.
+// Peers can only register themselves, since registrations are keyed by the
+// authenticated peer of the stream, and only with the addresses they are known
+// by: the address of the connection and the addresses reported by identify.
+func (p2p *P2P) handletracker(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(dmtimeout))
+
+	var request trackerrequest
+	if err := json.NewDecoder(io.LimitReader(stream, 16*1024)).Decode(&request); err != nil {
+		stream.Reset()
+		return
+	}
+
+	remote := stream.Conn().RemotePeer()
+	known := append(p2p.Host.Peerstore().Addrs(remote), stream.Conn().RemoteMultiaddr())
+
+	reply := p2p.tracker.answer(remote, known, request)
+	json.NewEncoder(stream).Encode(reply)
+}
+
+// A method of trackerserver that answers a request of a peer with the addresses it is known by
+func (server *trackerserver) answer(peerid peer.ID, known []multiaddr.Multiaddr, request trackerrequest) trackerreply {
+	if request.Namespace == "" || len(request.Namespace) > maxnamespacelength {
+		return trackerreply{Error: "invalid namespace"}
+	}
+
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	// Forget the expired registrations of the namespace
+	registered := server.registrations[request.Namespace]
+	for id, reg := range registered {
+		if time.Now().After(reg.expires) {
+			delete(registered, id)
+		}
+	}
+	if registered != nil && len(registered) == 0 {
+		delete(server.registrations, request.Namespace)
+		registered = nil
+	}
+
+	switch request.Type {
+	case "register":
+		if len(request.Addrs) == 0 || len(request.Addrs) > maxtrackeraddrs {
+			return trackerreply{Error: "invalid addresses"}
+		}
+		addrs, err := ownaddrs(request.Addrs, known)
+		if err != nil {
+			return trackerreply{Error: err.Error()}
+		}
+
+		ttl := time.Duration(request.TTL) * time.Second
+		if ttl <= 0 {
+			ttl = defaulttrackerttl
+		}
+		if ttl > maxtrackerttl {
+			ttl = maxtrackerttl
+		}
+
+		if _, ok := registered[peerid]; !ok {
+			if len(registered) >= maxregistrations {
+				return trackerreply{Error: "too many registrations in the namespace"}
+			}
+			if server.count(peerid) >= maxpeerregistrations {
+				return trackerreply{Error: "too many registrations of the peer"}
+			}
+		}
+
+		if registered == nil {
+			registered = make(map[peer.ID]registration)
+			server.registrations[request.Namespace] = registered
+		}
+
+		registered[peerid] = registration{addrs: addrs, expires: time.Now().Add(ttl)}
+		return trackerreply{TTL: int(ttl / time.Second)}
+
+	case "unregister":
+		delete(registered, peerid)
+		return trackerreply{}
+
+	case "discover":
+		var reply trackerreply
+		for id, reg := range registered {
+			if request.Limit > 0 && len(reply.Peers) >= request.Limit {
+				break
+			}
+			reply.Peers = append(reply.Peers, trackerpeer{PeerID: id.Pretty(), Addrs: reg.addrs})
+		}
+		return reply
+
+	default:
+		return trackerreply{Error: fmt.Sprintf("unknown request '%s'", request.Type)}
+	}
+}
+
+// A method of trackerserver that counts the unexpired registrations
+// of a peer in all namespaces. Must be called with the lock held.
+func (server *trackerserver) count(peerid peer.ID) int {
+	count := 0
+	for _, registered := range server.registrations {
+		if reg, ok := registered[peerid]; ok && time.Now().Before(reg.expires) {
+			count++
+		}
+	}
+
+	return count
+}
+
+// A function that returns the addresses of a registration that the registering
+// peer is known by, so that peers cannot register the addresses of others and
+// turn the discovering peers against them. Fails if none of them are known.
+func ownaddrs(addrs []string, known []multiaddr.Multiaddr) ([]string, error) {
+	var owned []string
+	for _, addr := range addrs {
+		decoded, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid address '%s'", addr)
+		}
+
+		for _, knownaddr := range known {
+			if knownaddr != nil && decoded.Equal(knownaddr) {
+				owned = append(owned, decoded.String())
+				break
+			}
+		}
+	}
+
+	if len(owned) == 0 {
+		return nil, errors.New("none of the addresses belong to the peer")
+	}
+
+	return owned, nil
+}
+
+// A structure that represents the discovery of peers through trackers.
+// It is an alternative to the DHT for small private deployments, where the
+// DHT converges slowly. Satisfies the discovery interface of libp2p.
+type trackerdiscovery struct {
+	// Represents the P2P host
+	p2p *P2P
+	// Represents the trackers
+	points []peer.AddrInfo
+}
+
+// A method of trackerdiscovery that sends a request to a tracker
+func (td *trackerdiscovery) request(ctx context.Context, point peer.AddrInfo, request trackerrequest) (trackerreply, error) {
+	if err := td.p2p.Host.Connect(ctx, point); err != nil {
+		return trackerreply{}, err
+	}
+
+	stream, err := td.p2p.Host.NewStream(ctx, point.ID, trackerprotocol)
+	if err != nil {
+		return trackerreply{}, err
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(dmtimeout))
+
+	if err := json.NewEncoder(stream).Encode(request); err != nil {
+		stream.Reset()
+		return trackerreply{}, err
+	}
+
+	var reply trackerreply
+	if err := json.NewDecoder(io.LimitReader(stream, 1024*1024)).Decode(&reply); err != nil {
+		return trackerreply{}, err
+	}
+	if reply.Error != "" {
+		return trackerreply{}, errors.New(reply.Error)
+	}
+
+	return reply, nil
+}
+
+// A method of trackerdiscovery that registers the addresses of the host under
+// a namespace at all the trackers. Returns the shortest granted time.
+func (td *trackerdiscovery) Advertise(ctx context.Context, namespace string, options ...coredisc.Option) (time.Duration, error) {
+	var opts coredisc.Options
+	if err := opts.Apply(options...); err != nil {
+		return 0, err
+	}
+
+	request := trackerrequest{Type: "register", Namespace: namespace, TTL: int(opts.Ttl / time.Second)}
+	for _, addr := range td.p2p.Host.Addrs() {
+		if len(request.Addrs) < maxtrackeraddrs {
+			request.Addrs = append(request.Addrs, addr.String())
+		}
+	}
+
+	var granted time.Duration
+	var lasterr error
+	for _, point := range td.points {
+		reply, err := td.request(ctx, point, request)
+		if err != nil {
+			lasterr = err
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"point": point.ID.Pretty(),
+			}).Debugln("Failed to Register at a Tracker!")
+			continue
+		}
+
+		ttl := time.Duration(reply.TTL) * time.Second
+		if granted == 0 || ttl < granted {
+			granted = ttl
+		}
+	}
+
+	if granted == 0 {
+		if lasterr == nil {
+			lasterr = errors.New("no trackers are configured")
+		}
+		return 0, lasterr
+	}
+
+	return granted, nil
+}
+
+// A method of trackerdiscovery that discovers the peers registered
+// under a namespace at all the trackers
+func (td *trackerdiscovery) FindPeers(ctx context.Context, namespace string, options ...coredisc.Option) (<-chan peer.AddrInfo, error) {
+	var opts coredisc.Options
+	if err := opts.Apply(options...); err != nil {
+		return nil, err
+	}
+
+	found := make(map[peer.ID]peer.AddrInfo)
+	for _, point := range td.points {
+		reply, err := td.request(ctx, point, trackerrequest{Type: "discover", Namespace: namespace, Limit: opts.Limit})
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"point": point.ID.Pretty(),
+			}).Debugln("Failed to Discover at a Tracker!")
+			continue
+		}
+
+		for _, registered := range reply.Peers {
+			peerid, err := peer.Decode(registered.PeerID)
+			if err != nil {
+				continue
+			}
+
+			addrinfo := peer.AddrInfo{ID: peerid}
+			for _, addr := range registered.Addrs {
+				if decoded, err := multiaddr.NewMultiaddr(addr); err == nil {
+					addrinfo.Addrs = append(addrinfo.Addrs, decoded)
+				}
+			}
+			found[peerid] = addrinfo
+		}
+	}
+
+	peerchan := make(chan peer.AddrInfo, len(found))
+	for _, addrinfo := range found {
+		peerchan <- addrinfo
+	}
+	close(peerchan)
+
+	return peerchan, nil
+}
+
+// A method of P2P that connects to service peers through the trackers
+// of the configuration instead of the DHT. The host registers under the service
+// namespace, renewing the registration before it expires, and discovers and
+// connects to the registered peers periodically.
+func (p2p *P2P) TrackerConnect() {
+	p2p.setdiscovery("tracker")
+	td := &trackerdiscovery{p2p: p2p, points: currentconfig().trackers()}
+	if len(td.points) == 0 {
+		logrus.Fatalln("No Trackers are Configured!")
+	}
+
+	// Register the host under the service namespace
+	ttl, err := td.Advertise(p2p.Ctx, servicename())
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Register at the Trackers!")
+	}
+	// Debug log
+	logrus.Debugf("Registered at the Trackers for %s", ttl)
+
+	go func() {
+		renew := time.NewTimer(ttl / 2)
+		discover := time.NewTicker(trackerinterval)
+		defer renew.Stop()
+		defer discover.Stop()
+
+		for {
+			// Connect to the peers registered at the trackers
+			if peerchan, err := td.FindPeers(p2p.Ctx, servicename()); err == nil {
+				p2p.handlediscovery(peerchan)
+			}
+
+			select {
+			case <-p2p.Ctx.Done():
+				return
+
+			case <-renew.C:
+				// Renew the registration, retrying soon if it fails
+				ttl, err := td.Advertise(p2p.Ctx, servicename())
+				if err != nil {
+					ttl = 2 * trackerinterval
+				}
+				renew.Reset(ttl / 2)
+
+			case <-discover.C:
+			}
+		}
+	}()
+
+	// Debug log
+	logrus.Debugln("Started Tracker Peer Discovery.")
+}
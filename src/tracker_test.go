@@ -0,0 +1,102 @@
+package src
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/multiformats/go-multiaddr"
+)
+
+// A function that returns a new empty tracker
+func newtesttracker() *trackerserver {
+	return &trackerserver{registrations: make(map[string]map[peer.ID]registration)}
+}
+
+// A function that parses multiaddrs for a test
+func testaddrs(t *testing.T, addrs ...string) []multiaddr.Multiaddr {
+	t.Helper()
+
+	var parsed []multiaddr.Multiaddr
+	for _, addr := range addrs {
+		decoded, err := multiaddr.NewMultiaddr(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed = append(parsed, decoded)
+	}
+
+	return parsed
+}
+
+func TestTrackerOwnAddresses(t *testing.T) {
+	server := newtesttracker()
+	known := testaddrs(t, "/ip4/10.0.0.1/tcp/4001", "/ip4/203.0.113.7/tcp/4001")
+
+	// The addresses of another host are dropped
+	reply := server.answer("alice", known, trackerrequest{
+		Type:      "register",
+		Namespace: "peerchat",
+		Addrs:     []string{"/ip4/10.0.0.1/tcp/4001", "/ip4/198.51.100.1/tcp/80"},
+	})
+	if reply.Error != "" {
+		t.Fatalf("register failed: %s", reply.Error)
+	}
+
+	reply = server.answer("bob", nil, trackerrequest{Type: "discover", Namespace: "peerchat"})
+	if len(reply.Peers) != 1 || len(reply.Peers[0].Addrs) != 1 || reply.Peers[0].Addrs[0] != "/ip4/10.0.0.1/tcp/4001" {
+		t.Fatalf("discover returned %+v, want only the known address of alice", reply.Peers)
+	}
+
+	// A registration with only the addresses of another host is refused
+	reply = server.answer("mallory", known, trackerrequest{
+		Type:      "register",
+		Namespace: "peerchat",
+		Addrs:     []string{"/ip4/198.51.100.1/tcp/80"},
+	})
+	if reply.Error == "" {
+		t.Fatal("registering the addresses of another host succeeded")
+	}
+}
+
+func TestTrackerPeerLimit(t *testing.T) {
+	server := newtesttracker()
+	known := testaddrs(t, "/ip4/10.0.0.1/tcp/4001")
+
+	register := func(namespace string) trackerreply {
+		return server.answer("mallory", known, trackerrequest{Type: "register", Namespace: namespace, Addrs: []string{"/ip4/10.0.0.1/tcp/4001"}})
+	}
+
+	for index := 0; index < maxpeerregistrations; index++ {
+		if reply := register(fmt.Sprintf("namespace-%d", index)); reply.Error != "" {
+			t.Fatalf("registration %d failed: %s", index, reply.Error)
+		}
+	}
+
+	if reply := register("one-too-many"); reply.Error == "" {
+		t.Fatal("registering beyond the limit of the peer succeeded")
+	}
+	if _, ok := server.registrations["one-too-many"]; ok {
+		t.Error("a refused registration left its namespace behind")
+	}
+
+	// Renewing a registration is not limited
+	if reply := register("namespace-0"); reply.Error != "" {
+		t.Fatalf("renewing a registration failed: %s", reply.Error)
+	}
+}
+
+func TestTrackerTTL(t *testing.T) {
+	server := newtesttracker()
+	known := testaddrs(t, "/ip4/10.0.0.1/tcp/4001")
+
+	reply := server.answer("alice", known, trackerrequest{
+		Type:      "register",
+		Namespace: "peerchat",
+		Addrs:     []string{"/ip4/10.0.0.1/tcp/4001"},
+		TTL:       int(10 * maxtrackerttl.Seconds()),
+	})
+	if reply.TTL != int(maxtrackerttl.Seconds()) {
+		t.Errorf("granted a TTL of %ds, want %ds", reply.TTL, int(maxtrackerttl.Seconds()))
+	}
+}
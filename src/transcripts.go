@@ -0,0 +1,156 @@
+package src
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// A structure that represents the settings of the plain-text transcripts of the rooms
+type TranscriptConfig struct {
+	// Represents whether every message of the rooms is appended to the transcripts
+	Enabled bool `yaml:"enabled"`
+	// Represents the directory of the transcripts (<data>/transcripts if empty)
+	Dir string `yaml:"dir"`
+}
+
+// A structure that represents the open transcript file of a room
+type transcriptfile struct {
+	// Represents the file of the day
+	file *os.File
+	// Represents the day of the file (YYYY-MM-DD)
+	day string
+}
+
+// A structure that represents the plain-text transcripts of the rooms, in the
+// style of irssi logs. Each room has a directory with a file for each day, so
+// the transcripts rotate daily: <dir>/<room>/<YYYY-MM-DD>.log
+type transcriptlog struct {
+	// Represents the open transcript files by room
+	files map[string]*transcriptfile
+	// Represents the lock on the files
+	lock sync.Mutex
+}
+
+// Represents the transcripts of the rooms
+var transcripts = &transcriptlog{files: make(map[string]*transcriptfile)}
+
+// A method of Config that returns the directory of the transcripts
+func (cfg *Config) transcriptsdir() string {
+	return cfg.storagepath(cfg.Transcripts.Dir, "transcripts")
+}
+
+// A function that returns a room name that is safe to use as a file or directory
+// name. An empty name and the names '.' and '..' become underscores, so that they cannot
+// refer to the directory itself or to its parent.
+func transcriptname(roomname string) string {
+	switch roomname {
+	case "", ".", "..":
+		return strings.Repeat("_", len(roomname)+1)
+	}
+
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case '/', '\\', ':', '*', '?', '"', '<', '>', '|', 0:
+			return '_'
+		}
+		return r
+	}, roomname)
+}
+
+// A method of transcriptlog that appends a message of a room to its transcript if
+// transcripts are enabled. Each line of the message is written with the time and
+// the name of the sender, and a new file is opened when the day changes. Control
+// characters are stripped, so that the text of peers cannot forge lines or
+// send escape sequences to a terminal that shows the transcript.
+func (log *transcriptlog) write(roomname string, sender string, message string) {
+	cfg := currentconfig()
+	if !cfg.Transcripts.Enabled {
+		return
+	}
+
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	now := time.Now()
+	file, err := log.open(cfg.transcriptsdir(), roomname, now)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Debugln("Failed to Open the Transcript of the Room!")
+		return
+	}
+
+	var builder strings.Builder
+	sender = stripcontrols(sender)
+	for _, line := range strings.Split(message, "\n") {
+		fmt.Fprintf(&builder, "%s <%s> %s\n", now.Format("15:04:05"), sender, stripcontrols(line))
+	}
+
+	if _, err := file.WriteString(builder.String()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"room":  roomname,
+		}).Debugln("Failed to Write the Transcript of the Room!")
+	}
+}
+
+// A method of transcriptlog that returns the transcript file of a room for the day,
+// closing the file of the previous day. Must be called with the lock held.
+func (log *transcriptlog) open(dir string, roomname string, now time.Time) (*os.File, error) {
+	day := now.Format("2006-01-02")
+	if current, ok := log.files[roomname]; ok {
+		if current.day == day {
+			return current.file, nil
+		}
+
+		current.file.Close()
+		delete(log.files, roomname)
+	}
+
+	roomdir := filepath.Join(dir, transcriptname(roomname))
+	if err := os.MkdirAll(roomdir, 0700); err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(roomdir, day+".log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(file, "--- Log opened %s\n", now.Format("Mon Jan 02 15:04:05 2006"))
+	log.files[roomname] = &transcriptfile{file: file, day: day}
+	return file, nil
+}
+
+// A method of transcriptlog that closes the transcript file of a room, if it is open
+func (log *transcriptlog) close(roomname string) {
+	log.lock.Lock()
+	defer log.lock.Unlock()
+
+	if current, ok := log.files[roomname]; ok {
+		fmt.Fprintf(current.file, "--- Log closed %s\n", time.Now().Format("Mon Jan 02 15:04:05 2006"))
+		current.file.Close()
+		delete(log.files, roomname)
+	}
+}
+
+// A method of transcriptlog that closes the open transcript files of every room
+func (log *transcriptlog) closeall() {
+	log.lock.Lock()
+	rooms := make([]string, 0, len(log.files))
+	for roomname := range log.files {
+		rooms = append(rooms, roomname)
+	}
+	log.lock.Unlock()
+
+	for _, roomname := range rooms {
+		log.close(roomname)
+	}
+}
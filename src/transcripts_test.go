@@ -0,0 +1,33 @@
+package src
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestTranscriptName(t *testing.T) {
+	tests := []struct {
+		roomname string
+		want     string
+	}{
+		{"lobby", "lobby"},
+		{"project/dev", "project_dev"},
+		{"../../etc", ".._.._etc"},
+		{"", "_"},
+		{".", "__"},
+		{"..", "___"},
+		{"...", "..."},
+	}
+
+	for _, test := range tests {
+		got := transcriptname(test.roomname)
+		if got != test.want {
+			t.Errorf("transcriptname(%q) = %q, want %q", test.roomname, got, test.want)
+		}
+
+		// The name must stay a single entry within the directory
+		if dir := filepath.Join("transcripts", got); filepath.Dir(dir) != "transcripts" {
+			t.Errorf("transcriptname(%q) = %q, which leaves the directory", test.roomname, got)
+		}
+	}
+}
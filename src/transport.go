@@ -0,0 +1,191 @@
+package src
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	host "github.com/libp2p/go-libp2p-host"
+	pubsub "github.com/libp2p/go-libp2p-pubsub"
+)
+
+// Represents the router of the message transport that is used if the configuration doesn't set one
+const defaultrouter = "gossipsub"
+
+// Represents the estimated size of the network for the RandomSub router,
+// which forwards each message to the square root of this many peers
+const randomsubsize = 100
+
+// An interface that represents the transport of the messages of the chat rooms.
+// It is implemented by the PubSub handler of libp2p with any of its routers
+// (GossipSub, FloodSub) or with a custom transport, such as a centralized router
+// for tests, so that the transport can be substituted without changing the rooms.
+type MessageTransport interface {
+	// Joins a topic and returns its handle
+	Join(topic string, opts ...pubsub.TopicOpt) (Topic, error)
+	// Registers the validator of the messages of a topic
+	RegisterTopicValidator(topic string, val interface{}, opts ...pubsub.ValidatorOpt) error
+	// Removes the validator of the messages of a topic
+	UnregisterTopicValidator(topic string) error
+	// Returns the peers that the host is connected to in a topic
+	ListPeers(topic string) []peer.ID
+	// Returns the topics that the host has joined
+	GetTopics() []string
+}
+
+// An interface that represents the handle of a topic joined on a message transport
+type Topic interface {
+	// Returns the name of the topic
+	String() string
+	// Publishes a message to the topic
+	Publish(ctx context.Context, data []byte, opts ...pubsub.PubOpt) error
+	// Subscribes to the messages of the topic
+	Subscribe(opts ...pubsub.SubOpt) (Subscription, error)
+	// Returns a handler of the peers joining and leaving the topic
+	EventHandler(opts ...pubsub.TopicEventHandlerOpt) (TopicEvents, error)
+	// Returns the peers that the host is connected to in the topic
+	ListPeers() []peer.ID
+	// Closes the handle of the topic
+	Close() error
+}
+
+// An interface that represents a subscription to the messages of a topic
+type Subscription interface {
+	// Returns the next message of the topic, blocking until one arrives
+	Next(ctx context.Context) (*pubsub.Message, error)
+	// Cancels the subscription
+	Cancel()
+}
+
+// An interface that represents a handler of the peers joining and leaving a topic
+type TopicEvents interface {
+	// Returns the next peer event of the topic, blocking until one happens
+	NextPeerEvent(ctx context.Context) (pubsub.PeerEvent, error)
+	// Cancels the handler
+	Cancel()
+}
+
+// A structure that represents the message transport of the PubSub handler of libp2p
+type pubsubtransport struct {
+	*pubsub.PubSub
+}
+
+// A method of pubsubtransport that joins a topic and returns its handle
+func (transport pubsubtransport) Join(topic string, opts ...pubsub.TopicOpt) (Topic, error) {
+	handle, err := transport.PubSub.Join(topic, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return pubsubtopic{handle}, nil
+}
+
+// A structure that represents the handle of a topic of the PubSub handler of libp2p
+type pubsubtopic struct {
+	*pubsub.Topic
+}
+
+// A method of pubsubtopic that subscribes to the messages of the topic
+func (topic pubsubtopic) Subscribe(opts ...pubsub.SubOpt) (Subscription, error) {
+	sub, err := topic.Topic.Subscribe(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return sub, nil
+}
+
+// A method of pubsubtopic that returns a handler of the peers joining and leaving the topic
+func (topic pubsubtopic) EventHandler(opts ...pubsub.TopicEventHandlerOpt) (TopicEvents, error) {
+	events, err := topic.Topic.EventHandler(opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// A function that returns the message transport of a PubSub handler of libp2p
+func newpubsubtransport(handler *pubsub.PubSub, err error) (MessageTransport, error) {
+	if err != nil {
+		return nil, err
+	}
+
+	return pubsubtransport{handler}, nil
+}
+
+// A type that represents a constructor of a message transport. The options
+// apply to every router, such as the discovery service of the host.
+type TransportConstructor func(ctx context.Context, nodehost host.Host, options ...pubsub.Option) (MessageTransport, error)
+
+// Represents the constructors of the message transports by the name of their router and the lock on them
+var (
+	transports = map[string]TransportConstructor{
+		"gossipsub": func(ctx context.Context, nodehost host.Host, options ...pubsub.Option) (MessageTransport, error) {
+			return newpubsubtransport(pubsub.NewGossipSub(ctx, nodehost, options...))
+		},
+		"floodsub": func(ctx context.Context, nodehost host.Host, options ...pubsub.Option) (MessageTransport, error) {
+			return newpubsubtransport(pubsub.NewFloodSub(ctx, nodehost, options...))
+		},
+		"randomsub": func(ctx context.Context, nodehost host.Host, options ...pubsub.Option) (MessageTransport, error) {
+			return newpubsubtransport(pubsub.NewRandomSub(ctx, nodehost, randomsubsize, options...))
+		},
+	}
+	transportlock sync.RWMutex
+)
+
+// A function that registers the constructor of a message transport under the name of its router,
+// which can then be selected with 'router' in the configuration. Must be called before the host is
+// created, and replaces the constructor of a router registered with the same name.
+func RegisterTransport(name string, constructor TransportConstructor) {
+	transportlock.Lock()
+	defer transportlock.Unlock()
+
+	transports[name] = constructor
+}
+
+// A method of Config that sets the router of the message transport of
+// the rooms, overriding the configuration file. Must be called before
+// the host is created.
+func (cfg *Config) SetRouter(name string) error {
+	if _, ok := transportconstructor(name); !ok {
+		return fmt.Errorf("invalid router '%s' (%s)", name, strings.Join(transportnames(), ", "))
+	}
+
+	configlock.Lock()
+	cfg.Router = name
+	configlock.Unlock()
+
+	return nil
+}
+
+// A function that returns the constructor of the message
+// transport for the name of a router, or false if it is unknown
+func transportconstructor(name string) (TransportConstructor, bool) {
+	if name == "" {
+		name = defaultrouter
+	}
+
+	transportlock.RLock()
+	defer transportlock.RUnlock()
+
+	constructor, ok := transports[name]
+	return constructor, ok
+}
+
+// A function that returns the sorted names of the routers of the registered message transports
+func transportnames() []string {
+	transportlock.RLock()
+	defer transportlock.RUnlock()
+
+	names := make([]string, 0, len(transports))
+	for name := range transports {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
@@ -1,16 +1,31 @@
 package src
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"os/signal"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 
 	"github.com/gdamore/tcell/v2"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
 	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
 )
 
-// Represents the app version
-const appversion = "v1.1.0"
+// Represents the terminal dimensions below which
+// the optional panes are collapsed automatically
+const narrowwidth = 60
+const shortheight = 20
+
+// Represents the minimum length of a peer ID suffix that finds a peer
+const minpeersuffix = 6
 
 // A structure that represents the ChatRoom UI
 type UI struct {
@@ -26,10 +41,100 @@ type UI struct {
 
 	// Represents the UI element with the list of peers
 	peerBox *tview.TextView
-	// Represents the UI element with the chat messages and logs
+	// Represents the UI element with the chat messages
 	messageBox *tview.TextView
+	// Represents the UI element with the chat logs
+	logBox *tview.TextView
 	// Represents the UI element for the input field
 	inputBox *tview.InputField
+	// Represents the UI element with the title
+	titleBox *tview.TextView
+	// Represents the UI element with the usage instructions
+	usageBox *tview.TextView
+	// Represents the UI element with the room tabs
+	tabBox *tview.TextView
+	// Represents the UI element with the warning that the room has no peers
+	bannerBox *tview.TextView
+
+	// Represents the root pages that overlay modals on the layout
+	pages *tview.Pages
+	// Represents the root layout flexbox
+	rootFlex *tview.Flex
+	// Represents the layout flexbox with the messages and peers
+	chatFlex *tview.Flex
+
+	// Represents whether the peer box is enabled by the user
+	showPeers bool
+	// Represents whether the usage bar is enabled by the user
+	showUsage bool
+	// Represents whether the title is enabled by the user
+	showTitle bool
+	// Represents whether the log pane is enabled by the user
+	showLogs bool
+	// Represents whether the warning that the room has no peers
+	// is shown (only used from the tview event loop)
+	showBanner bool
+	// Represents the most verbose log level displayed in the log pane,
+	// which is a logrus.Level accessed atomically
+	logLevel uint32
+	// Represents the last known terminal dimensions
+	width, height int
+
+	// Represents the palette of colors for peer nicks
+	nickPalette []string
+	// Represents the mapping of keys to UI actions
+	keyMap map[tcell.Key]string
+	// Represents the notification dispatcher for mentions
+	notifier *notifier
+	// Represents the spam tracker that hides floods of messages
+	spam *spamtracker
+
+	// Represents the peers currently listed in the peer box
+	peers []peer.ID
+	// Represents the last known user names of peers
+	nicks map[peer.ID]string
+	// Represents the rooms visited in this session, listed as tabs
+	rooms []string
+	// Represents the latest messages received in the room, kept for /copy
+	recent []ChatMessage
+	// Represents the lock on the peers, nicks, rooms and recent messages
+	stateLock sync.Mutex
+
+	// Represents the input lines collected to detect pastes and the
+	// timer that submits them (only used from the tview event loop)
+	pasted     []string
+	pastetimer *time.Timer
+	// Represents whether the input field only accepts commands, in a
+	// read-only room (only used from the tview event loop)
+	inputdisabled bool
+	// Represents the requests to join the rooms the user is an operator of
+	joinrequests <-chan *joinrequest
+
+	// Represents the pages of the conversations, the room and the direct message panes
+	convPages *tview.Pages
+	// Represents the direct message panes indexed by peer, and the order they were opened in
+	dms     map[peer.ID]*dmpane
+	dmorder []peer.ID
+	// Represents the peer of the shown direct message pane (empty if the room is shown)
+	activedm peer.ID
+	// Represents the direct message frames received from peers
+	dmevents <-chan dmevent
+	// Represents when the peer of the shown pane was last told that the
+	// user is typing (only used from the tview event loop)
+	lasttyping time.Time
+	// Represents the history of the direct messages, if the history is kept on disk
+	dmhistory *historystore
+	// Represents the events of the voice channel
+	voiceevents <-chan string
+	// Represents the messages and reminders scheduled by the user
+	schedule *schedulestore
+	// Represents the IDs of the scheduled messages that are held because their
+	// room is read-only, and that the user was told about (only used from the event handler)
+	scheduleheld map[string]bool
+	// Represents the terminals shared by peers
+	termshares <-chan termshare
+	// Represents the viewer panes of the shared terminals indexed by peer
+	termviews map[peer.ID]*termviewer
 }
 
 // A structure that represents a UI command
@@ -50,7 +155,7 @@ func NewUI(cr *ChatRoom) *UI {
 
 	// Create a title box
 	titlebox := tview.NewTextView().
-		SetText(fmt.Sprintf("PeerChat. A P2P Chat Application. %s", appversion)).
+		SetText(fmt.Sprintf("PeerChat. A P2P Chat Application. %s", Version())).
 		SetTextColor(tcell.ColorWhite).
 		SetTextAlign(tview.AlignCenter)
 
@@ -68,14 +173,28 @@ func NewUI(cr *ChatRoom) *UI {
 	messagebox.
 		SetBorder(true).
 		SetBorderColor(tcell.ColorGreen).
-		SetTitle(fmt.Sprintf("ChatRoom-%s", cr.RoomName)).
+		SetTitle(roomtitle(cr, len(cr.PeerList()))).
+		SetTitleAlign(tview.AlignLeft).
+		SetTitleColor(tcell.ColorWhite)
+
+	// Create a log box
+	logbox := tview.NewTextView().
+		SetDynamicColors(true).
+		SetChangedFunc(func() {
+			app.Draw()
+		})
+
+	logbox.
+		SetBorder(true).
+		SetBorderColor(tcell.ColorGreen).
+		SetTitle("Logs (F2)").
 		SetTitleAlign(tview.AlignLeft).
 		SetTitleColor(tcell.ColorWhite)
 
 	// Create a usage instruction box
 	usage := tview.NewTextView().
 		SetDynamicColors(true).
-		SetText(`[red]/quit[green] - quit the chat | [red]/room <roomname>[green] - change chat room | [red]/user <username>[green] - change user name | [red]/clear[green] - clear the chat`)
+		SetText(`[red]/help[green] - list all commands | [red]/quit[green] - quit the chat | [red]/room <roomname>[green] - change chat room | [red]/user <username>[green] - change user name | [red]/clear[green] - clear the chat`)
 
 	usage.
 		SetBorder(true).
@@ -95,6 +214,18 @@ func NewUI(cr *ChatRoom) *UI {
 		SetTitleAlign(tview.AlignLeft).
 		SetTitleColor(tcell.ColorWhite)
 
+	// Create a room tab bar
+	tabbox := tview.NewTextView().
+		SetDynamicColors(true).
+		SetRegions(true).
+		SetWrap(false)
+
+	// Create a warning banner, shown while the room has no peers
+	bannerbox := tview.NewTextView().
+		SetDynamicColors(true).
+		SetTextAlign(tview.AlignCenter).
+		SetText(emptyroombanner)
+
 	// Create a text input box
 	input := tview.NewInputField().
 		SetLabel(cr.UserName + " > ").
@@ -109,79 +240,360 @@ func NewUI(cr *ChatRoom) *UI {
 		SetTitleColor(tcell.ColorWhite).
 		SetBorderPadding(0, 0, 1, 0)
 
+	// Create the pages of the room and the direct message panes
+	convpages := tview.NewPages().
+		AddPage("room", messagebox, true, true)
+
+	// Create a flexbox for the messages and peers
+	chatflex := tview.NewFlex().SetDirection(tview.FlexColumn).
+		AddItem(convpages, 0, 1, false).
+		AddItem(peerbox, 20, 1, false)
+
+	// Create a flexbox to fit all the widgets
+	flex := tview.NewFlex().SetDirection(tview.FlexRow).
+		AddItem(titlebox, 3, 1, false).
+		AddItem(tabbox, 1, 1, false).
+		AddItem(bannerbox, 0, 0, false).
+		AddItem(chatflex, 0, 8, false).
+		AddItem(logbox, 8, 1, false).
+		AddItem(input, 3, 1, true).
+		AddItem(usage, 3, 1, false)
+
+	// Create the root pages with the flex as the main page
+	pages := tview.NewPages().
+		AddPage("main", flex, true, true)
+
+	// Set the pages as the app root
+	app.SetRoot(pages, true)
+
+	// Create UI
+	ui := &UI{
+		ChatRoom:    cr,
+		TerminalApp: app,
+		peerBox:     peerbox,
+		messageBox:  messagebox,
+		logBox:      logbox,
+		inputBox:    input,
+		titleBox:    titlebox,
+		usageBox:    usage,
+		tabBox:      tabbox,
+		bannerBox:   bannerbox,
+		pages:       pages,
+		rootFlex:    flex,
+		chatFlex:    chatflex,
+		convPages:   convpages,
+		showPeers:   true,
+		showUsage:   true,
+		showTitle:   true,
+		showLogs:    true,
+		logLevel:    uint32(logrus.InfoLevel),
+		MsgInputs:   msgchan,
+		CmdInputs:   cmdchan,
+		nickPalette: defaultpalette,
+		notifier:    newNotifier("bell"),
+		spam:        newspamtracker(),
+		nicks:       make(map[peer.ID]string),
+		rooms:       []string{cr.RoomName},
+		dms:         make(map[peer.ID]*dmpane),
+		termviews:   make(map[peer.ID]*termviewer),
+		schedule:    openschedule("ui"),
+
+		scheduleheld: make(map[string]bool),
+	}
+
 	// Define functionality when the input recieves a done signal (enter/tab)
-	input.SetDoneFunc(func(key tcell.Key) {
-		// Check if trigger was caused by a Return(Enter) press.
-		if key != tcell.KeyEnter {
-			return
+	input.SetDoneFunc(ui.inputdone)
+
+	// Send typing indicators in direct message panes
+	input.SetChangedFunc(ui.inputchanged)
+
+	// Receive the join requests and direct messages of the host
+	if cr.Host != nil {
+		ui.attachhost(cr.Host)
+	}
+
+	// Apply the theme and key bindings of the configuration
+	ui.applyconfig(currentconfig())
+
+	// Publish the user profile with the user name
+	if ui.Host != nil {
+		ui.Host.PublishProfile(ui.UserName)
+	}
+
+	// Publish the messages sent by scripts to the current room
+	SetScriptSender(func(text string) {
+		ui.sendtext(text)
+	})
+
+	// Enable mouse support (click to focus and wheel scrolling)
+	app.EnableMouse(true)
+
+	// Open the whois popup for a peer when it is clicked
+	peerbox.SetMouseCapture(func(action tview.MouseAction, event *tcell.EventMouse) (tview.MouseAction, *tcell.EventMouse) {
+		if action != tview.MouseLeftClick {
+			return action, event
 		}
 
-		// Read the input text
-		line := input.GetText()
+		// Determine the line that was clicked
+		_, y := event.Position()
+		_, top, _, _ := peerbox.GetInnerRect()
+		row, _ := peerbox.GetScrollOffset()
+		index := y - top + row
+
+		// Find the peer on the line
+		ui.stateLock.Lock()
+		var clicked peer.ID
+		if index >= 0 && index < len(ui.peers) {
+			clicked = ui.peers[index]
+		}
+		ui.stateLock.Unlock()
+
+		if clicked != "" {
+			ui.showwhois(clicked)
+			return action, nil
+		}
+
+		return action, event
+	})
 
-		// Check if there is any input text. No point printing empty messages
-		if len(line) == 0 {
+	// Switch to a room when its tab is clicked
+	tabbox.SetHighlightedFunc(func(added, removed, remaining []string) {
+		if len(added) == 0 {
 			return
 		}
 
-		// Check for command inputs
-		if strings.HasPrefix(line, "/") {
-			// Split the command
-			cmdparts := strings.Split(line, " ")
+		// Show the pane of a direct message tab
+		var index int
+		if _, err := fmt.Sscanf(added[0], "dm-%d", &index); err == nil {
+			ui.stateLock.Lock()
+			var peerid peer.ID
+			if index < len(ui.dmorder) {
+				peerid = ui.dmorder[index]
+			}
+			ui.stateLock.Unlock()
 
-			// Add a nil arg if there is no argument
-			if len(cmdparts) == 1 {
-				cmdparts = append(cmdparts, "")
+			if peerid != "" {
+				ui.showpane(peerid)
 			}
+			return
+		}
 
-			// Send the command
-			cmdchan <- uicommand{cmdtype: cmdparts[0], cmdarg: cmdparts[1]}
+		// Find the room for the tab
+		fmt.Sscanf(added[0], "tab-%d", &index)
 
-		} else {
-			// Send the message
-			msgchan <- line
+		ui.stateLock.Lock()
+		var room string
+		if index < len(ui.rooms) {
+			room = ui.rooms[index]
 		}
+		ui.stateLock.Unlock()
 
-		// Reset the input field
-		input.SetText("")
+		// Show the current room again, or switch to another room
+		if room == ui.RoomName {
+			ui.showroomview()
+		} else if room != "" {
+			go roomcommand(ui, room)
+		}
 	})
 
-	// Create a flexbox to fit all the widgets
-	flex := tview.NewFlex().SetDirection(tview.FlexRow).
-		AddItem(titlebox, 3, 1, false).
-		AddItem(tview.NewFlex().SetDirection(tview.FlexColumn).
-			AddItem(messagebox, 0, 1, false).
-			AddItem(peerbox, 20, 1, false),
-			0, 8, false).
-		AddItem(input, 3, 1, true).
-		AddItem(usage, 3, 1, false)
+	// Render the room tabs
+	ui.synctabs()
 
-	// Set the flex as the app root
-	app.SetRoot(flex, true)
+	// Handle the global key bindings
+	app.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		if action, ok := ui.keyMap[event.Key()]; ok {
+			keyactions[action](ui)
+			return nil
+		}
 
-	// Create UI and return it
-	return &UI{
-		ChatRoom:    cr,
-		TerminalApp: app,
-		peerBox:     peerbox,
-		messageBox:  messagebox,
-		inputBox:    input,
-		MsgInputs:   msgchan,
-		CmdInputs:   cmdchan,
+		return event
+	})
+
+	// Adapt the layout to the terminal size before every draw
+	app.SetBeforeDrawFunc(func(screen tcell.Screen) bool {
+		ui.width, ui.height = screen.Size()
+		ui.relayout()
+		return false
+	})
+
+	// Return the UI
+	return ui
+}
+
+// Represents the UI actions that can be bound to keys.
+// Actions are called from the tview event loop.
+var keyactions = map[string]func(ui *UI){
+	"togglelogs":  func(ui *UI) { ui.showLogs = !ui.showLogs; ui.relayout() },
+	"togglepeers": func(ui *UI) { ui.showPeers = !ui.showPeers; ui.relayout() },
+	"toggleusage": func(ui *UI) { ui.showUsage = !ui.showUsage; ui.relayout() },
+	"toggletitle": func(ui *UI) { ui.showTitle = !ui.showTitle; ui.relayout() },
+	"clear":       func(ui *UI) { ui.messageBox.Clear() },
+	"quit":        func(ui *UI) { ui.confirmquit() },
+	"talk":        func(ui *UI) { go ui.talk() },
+}
+
+// A method of UI that applies the theme, key bindings and default rooms of
+// a configuration. Must be called from the tview event loop once running.
+func (ui *UI) applyconfig(cfg *Config) {
+	// Color the borders and labels with the accent color
+	accent := cfg.accent()
+	boxes := []*tview.Box{ui.titleBox.Box, ui.messageBox.Box, ui.logBox.Box, ui.usageBox.Box, ui.peerBox.Box, ui.inputBox.Box}
+	for _, box := range boxes {
+		box.SetBorderColor(accent)
+	}
+	ui.inputBox.SetLabelColor(accent)
+
+	// The key bindings have been validated when the configuration was loaded
+	ui.keyMap, _ = cfg.keymap()
+
+	// Show the key that toggles the log pane in its title
+	ui.logBox.SetTitle("Logs")
+	for key, action := range ui.keyMap {
+		if action == "togglelogs" {
+			ui.logBox.SetTitle(fmt.Sprintf("Logs (%s)", tcell.KeyNames[key]))
+		}
+	}
+
+	// List the default rooms as tabs
+	ui.AddRooms(cfg.Rooms...)
+}
+
+// A method of UI that lists chat rooms as tabs, so that they can be
+// switched to with a click. Rooms that are already listed are skipped.
+func (ui *UI) AddRooms(rooms ...string) {
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	for _, room := range rooms {
+		known := false
+		for _, tab := range ui.rooms {
+			known = known || tab == room
+		}
+		if !known && room != "" {
+			ui.rooms = append(ui.rooms, room)
+		}
+	}
+}
+
+// A method of UI that returns the rooms listed as tabs, with the current room first
+func (ui *UI) Rooms() []string {
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	rooms := []string{ui.RoomName}
+	for _, room := range ui.rooms {
+		if room != ui.RoomName {
+			rooms = append(rooms, room)
+		}
 	}
+
+	return rooms
+}
+
+// A method of UI that sets the color palette used for peer nicks.
+// Supported palettes are 'default' and 'colorblind'.
+func (ui *UI) SetPalette(name string) {
+	ui.nickPalette = nickpalette(name)
 }
 
-// A method of UI that starts the UI app
+// A method of UI that starts the UI app.
+// SIGINT and SIGTERM stop the app so that it can exit cleanly.
 func (ui *UI) Run() error {
 	go ui.starteventhandler()
 
+	// Trap the interrupt and termination signals
+	sigchan := make(chan os.Signal, 1)
+	signal.Notify(sigchan, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(sigchan)
+
+	go func() {
+		if _, ok := <-sigchan; ok {
+			ui.TerminalApp.Stop()
+		}
+	}()
+
 	defer ui.Close()
 	return ui.TerminalApp.Run()
 }
 
-// A method of UI that closes the UI app
+// A method of UI that closes the UI app, saves the rooms of the session and exits the chat room
 func (ui *UI) Close() {
-	ui.pscancel()
+	SaveSession(ui.Rooms())
+	ui.Exit()
+}
+
+// A method of UI that displays a modal asking the user to confirm
+// quitting. Quits immediately if the modal is already displayed.
+// Must be called from the tview event loop.
+func (ui *UI) confirmquit() {
+	// Quit if the user has already been asked
+	if ui.pages.HasPage("quit") {
+		ui.TerminalApp.Stop()
+		return
+	}
+
+	modal := tview.NewModal().
+		SetText("Do you want to quit PeerChat?").
+		AddButtons([]string{"Quit", "Cancel"}).
+		SetDoneFunc(func(index int, label string) {
+			if label == "Quit" {
+				ui.TerminalApp.Stop()
+				return
+			}
+
+			// Dismiss the modal and return to the input
+			ui.pages.RemovePage("quit")
+			ui.TerminalApp.SetFocus(ui.inputBox)
+		})
+
+	ui.pages.AddPage("quit", modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
+
+// A method of UI that sets the notification methods used for mentions.
+// Accepts a comma separated list of 'bell', 'term' and 'desktop'.
+func (ui *UI) SetNotifications(methods string) {
+	ui.notifier = newNotifier(methods)
+}
+
+// A method of UI that resizes the optional panes based on the
+// user's toggles and the terminal size. Panes are collapsed when
+// the terminal is too small, so the message area isn't squeezed.
+// Must be called from the tview event loop.
+func (ui *UI) relayout() {
+	// Determine the visibility of each pane
+	peers := ui.showPeers && ui.width >= narrowwidth
+	usage := ui.showUsage && ui.height >= shortheight
+	title := ui.showTitle && ui.height >= shortheight
+	logs := ui.showLogs && ui.height >= shortheight
+
+	// Resize the peer box
+	if peers {
+		ui.chatFlex.ResizeItem(ui.peerBox, 20, 1)
+	} else {
+		ui.chatFlex.ResizeItem(ui.peerBox, 0, 0)
+	}
+
+	// Resize the usage bar
+	if usage {
+		ui.rootFlex.ResizeItem(ui.usageBox, 3, 1)
+	} else {
+		ui.rootFlex.ResizeItem(ui.usageBox, 0, 0)
+	}
+
+	// Resize the log pane
+	if logs {
+		ui.rootFlex.ResizeItem(ui.logBox, 8, 1)
+	} else {
+		ui.rootFlex.ResizeItem(ui.logBox, 0, 0)
+	}
+
+	// Resize the title box
+	if title {
+		ui.rootFlex.ResizeItem(ui.titleBox, 3, 1)
+	} else {
+		ui.rootFlex.ResizeItem(ui.titleBox, 0, 0)
+	}
 }
 
 // A method of UI that handles UI events
@@ -189,30 +601,61 @@ func (ui *UI) starteventhandler() {
 	refreshticker := time.NewTicker(time.Second)
 	defer refreshticker.Stop()
 
+	// Follow the events of the chat room, and of the rooms it is left for
+	events := ui.Events(context.Background())
+
 	for {
 		select {
 
 		case msg := <-ui.MsgInputs:
-			// Send the message to outbound queue
-			ui.Outbound <- msg
-			// Add the message to the message box as a self message
-			ui.display_selfmessage(msg)
+			// Send the message, holding it back under slow mode
+			ui.sendmessage(msg)
 
 		case cmd := <-ui.CmdInputs:
 			// Handle the recieved command
 			go ui.handlecommand(cmd)
 
-		case msg := <-ui.Inbound:
-			// Print the recieved messages to the message box
-			ui.display_chatmessage(msg)
+		case event, ok := <-events:
+			// Stop waiting for events once the chat room has closed
+			if !ok {
+				events = nil
+				continue
+			}
+
+			// Apply the event of the chat room to the UI
+			event(ui)
+
+		case event := <-ui.dmevents:
+			// Show the direct message in its pane
+			ui.display_dmevent(event)
+
+		case event := <-ui.voiceevents:
+			// Report the events of the voice channel
+			ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: event}
+
+		case share := <-ui.termshares:
+			// Fill the viewer pane of a shared terminal
+			go ui.viewterm(share)
 
-		case log := <-ui.Logs:
-			// Add the log to the message box
-			ui.display_logmessage(log)
+		case request := <-ui.joinrequests:
+			// Ask the user to approve a request to join a room
+			ui.TerminalApp.QueueUpdateDraw(func() {
+				ui.showjoinrequest(request)
+			})
 
 		case <-refreshticker.C:
 			// Refresh the list of peers in the chat room periodically
 			ui.syncpeerbox()
+			// Report the messages hidden as spam
+			ui.display_spamreports()
+			// Count down the slow mode in the input label
+			ui.synclabel()
+			// Clear the expired typing indicators
+			ui.synctyping()
+			// Spin the spinners of the direct messages being sent
+			ui.syncspinners()
+			// Send the scheduled messages that are due
+			ui.sendscheduled()
 
 		case <-ui.psctx.Done():
 			// End the event loop
@@ -221,86 +664,512 @@ func (ui *UI) starteventhandler() {
 	}
 }
 
-// A method of UI that handles a UI command
-func (ui *UI) handlecommand(cmd uicommand) {
+// A method of UI that prints a message received in the chat room to the message box
+func (ui *UI) MessageReceived(cr *ChatRoom, msg ChatMessage) {
+	ui.display_chatmessage(msg)
+}
 
-	switch cmd.cmdtype {
+// A method of UI that refreshes the list of peers when a peer joins the chat room
+func (ui *UI) PeerJoined(cr *ChatRoom, peerid peer.ID) {
+	ui.syncpeerbox()
+}
 
-	// Check for the quit command
-	case "/quit":
-		// Stop the chat UI
-		ui.TerminalApp.Stop()
+// A method of UI that refreshes the list of peers when a peer leaves the chat room
+func (ui *UI) PeerLeft(cr *ChatRoom, peerid peer.ID) {
+	ui.syncpeerbox()
+}
+
+// A method of UI that is told when the chat room is left for another room.
+// The room command updates the UI for the new room itself, so this only
+// brings the UI online when the offline room is joined once the host is up.
+func (ui *UI) RoomChanged(from *ChatRoom, to *ChatRoom) {
+	if !from.offline {
 		return
+	}
 
-	// Check for the clear command
-	case "/clear":
-		// Clear the UI message box
-		ui.messageBox.Clear()
-
-	// Check for the room change command
-	case "/room":
-		if cmd.cmdarg == "" {
-			ui.Logs <- chatlog{logprefix: "badcmd", logmsg: "missing room name for command"}
-		} else {
-			ui.Logs <- chatlog{logprefix: "roomchange", logmsg: fmt.Sprintf("joining new room '%s'", cmd.cmdarg)}
-
-			// Create a reference to the current chatroom
-			oldchatroom := ui.ChatRoom
-
-			// Create a new chatroom and join it
-			newchatroom, err := JoinChatRoom(ui.Host, ui.UserName, cmd.cmdarg)
-			if err != nil {
-				ui.Logs <- chatlog{logprefix: "jumperr", logmsg: fmt.Sprintf("could not change chat room - %s", err)}
-				return
-			}
+	ui.ChatRoom = to
+	ui.attachhost(to.Host)
 
-			// Assign the new chat room to UI
-			ui.ChatRoom = newchatroom
-			// Sleep for a second to give time for the queues to adapt
-			time.Sleep(time.Second * 1)
+	ui.synclabel()
+	ui.syncpeerbox()
+}
 
-			// Exit the old chatroom and pause for two seconds
-			oldchatroom.Exit()
+// A method of UI that receives the join requests of the rooms the user is an operator
+// of and the direct messages of a host, keeping them with the history if it is kept
+func (ui *UI) attachhost(host *P2P) {
+	ui.joinrequests = host.JoinRequests()
+	ui.dmevents = host.DMEvents()
+	ui.voiceevents = host.VoiceEvents()
+	ui.termshares = host.TermShares()
 
-			// Clear the UI message box
-			ui.messageBox.Clear()
-			// Update the chat room UI element
-			ui.messageBox.SetTitle(fmt.Sprintf("ChatRoom-%s", ui.ChatRoom.RoomName))
+	if currentconfig().History.Persist {
+		if store, err := openhistory(); err == nil {
+			ui.dmhistory = store
+			startjanitor(store)
 		}
+	}
+}
 
-	// Check for the user change command
-	case "/user":
-		if cmd.cmdarg == "" {
-			ui.Logs <- chatlog{logprefix: "badcmd", logmsg: "missing user name for command"}
-		} else {
-			// Update the chat user name
-			ui.UpdateUser(cmd.cmdarg)
-			// Update the chat room UI element
-			ui.inputBox.SetLabel(ui.UserName + " > ")
+// A function that returns the title of the message box of
+// a chat room, with the live number of peers in the room
+func roomtitle(cr *ChatRoom, peers int) string {
+	switch {
+	case cr.offline:
+		return fmt.Sprintf("ChatRoom-%s [offline]", cr.RoomName)
+	case peers == 1:
+		return fmt.Sprintf("ChatRoom-%s [1 peer]", cr.RoomName)
+	default:
+		return fmt.Sprintf("ChatRoom-%s [%d peers]", cr.RoomName, peers)
+	}
+}
+
+// A method of UI that adds a log of the chat room to the message box
+func (ui *UI) Error(cr *ChatRoom, err error) {
+	ui.display_logmessage(errorlog(err))
+}
+
+// A method of UI that handles a UI command by looking it up in the
+// command registry. Suggestions are logged for unsupported commands.
+func (ui *UI) handlecommand(cmd uicommand) {
+	// Lookup the command
+	command, ok := registry.lookup(cmd.cmdtype)
+	if !ok {
+		logmsg := fmt.Sprintf("unsupported command - %s", cmd.cmdtype)
+		// Add suggestions for similar commands
+		if suggestions := registry.suggest(cmd.cmdtype); len(suggestions) > 0 {
+			logmsg = fmt.Sprintf("%s (did you mean %s?)", logmsg, strings.Join(suggestions, ", "))
 		}
 
-	// Unsupported command
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: logmsg}
+		return
+	}
+
+	// Handle the command
+	command.Handler(ui, cmd.cmdarg)
+}
+
+// A method of UI that renders the room tabs, highlighting the current room
+func (ui *UI) synctabs() {
+	ui.stateLock.Lock()
+	rooms := append([]string{}, ui.rooms...)
+	var panes []*dmpane
+	var labels []string
+	for _, peerid := range ui.dmorder {
+		pane := ui.dms[peerid]
+		label := "@" + stripcontrols(pane.name)
+		if pane.unread > 0 {
+			label += fmt.Sprintf(" (%d)", pane.unread)
+		}
+
+		panes = append(panes, pane)
+		labels = append(labels, label)
+	}
+	activedm := ui.activedm
+	ui.stateLock.Unlock()
+
+	// Render each room as a clickable region
+	var builder strings.Builder
+	var active string
+	for index, room := range rooms {
+		region := fmt.Sprintf("tab-%d", index)
+		fmt.Fprintf(&builder, `["%s"] %s [""] `, region, tview.Escape(room))
+
+		if room == ui.RoomName && activedm == "" {
+			active = region
+		}
+	}
+
+	// Render each direct message pane with its unread count
+	for index, pane := range panes {
+		region := fmt.Sprintf("dm-%d", index)
+		fmt.Fprintf(&builder, `["%s"] %s [""] `, region, tview.Escape(labels[index]))
+
+		if pane.peer == activedm {
+			active = region
+		}
+	}
+
+	ui.tabBox.SetText(builder.String())
+	if active != "" {
+		ui.tabBox.Highlight(active)
+	}
+}
+
+// A method of UI that displays a popup with the details
+// of a peer. Must be called from the tview event loop.
+func (ui *UI) showwhois(peerid peer.ID) {
+	// Retrieve the last known user name of the peer
+	ui.stateLock.Lock()
+	nick, ok := ui.nicks[peerid]
+	ui.stateLock.Unlock()
+
+	if !ok {
+		nick = "unknown"
+	}
+
+	// Collect the details of the peer, below its avatar if it is known
+	var details []string
+	if ui.Host != nil {
+		if avatar := ui.Host.cachedavatar(peerid); avatar != "" {
+			details = append(details, avatarlines(avatar)...)
+			details = append(details, "")
+		}
+	}
+
+	details = append(details,
+		fmt.Sprintf("User: %s", sanitize(nick)),
+		fmt.Sprintf("Peer ID: %s", peerid.Pretty()),
+	)
+
+	if alias, ok := friends.alias(peerid); ok {
+		details = append(details, fmt.Sprintf("Friend: %s", sanitize(alias)))
+	}
+
+	// Add the connection details if the host is local (and not a daemon)
+	if ui.Host != nil {
+		details = append(details, fmt.Sprintf("Status: %s", connectedness(ui.Host.Host.Network().Connectedness(peerid))))
+
+		for _, addr := range ui.Host.Host.Peerstore().Addrs(peerid) {
+			details = append(details, fmt.Sprintf("Address: %s", addr))
+		}
+
+		if agent, ok := ui.Host.peeragent(peerid); ok {
+			details = append(details, fmt.Sprintf("Client: %s", sanitize(agent.describe())))
+		}
+
+		if remote, ok := ui.Host.peerhello(peerid); ok {
+			details = append(details, fmt.Sprintf("Protocol: v%d", remote.Protocol))
+			if remote.Agent != "" {
+				details = append(details, fmt.Sprintf("Agent: %s", sanitize(remote.Agent)))
+			}
+		}
+
+		if supported, ok := ui.Host.peercapabilities(peerid); ok && len(supported) > 0 {
+			details = append(details, fmt.Sprintf("Capabilities: %s", sanitize(strings.Join(supported, ", "))))
+		}
+
+		if missing := ui.Host.missingfeatures(peerid); len(missing) > 0 {
+			details = append(details, fmt.Sprintf("Unsupported: %s", strings.Join(missing, ", ")))
+		}
+	}
+
+	ui.showmodal("whois", details)
+}
+
+// A method of UI that displays the user profile of a peer in a popup
+func (ui *UI) showprofile(peerid peer.ID, profile *UserProfile, avatar string) {
+	var details []string
+	if avatar != "" {
+		details = append(details, avatarlines(avatar)...)
+		details = append(details, "")
+	}
+
+	details = append(details,
+		fmt.Sprintf("Name: %s", sanitize(profile.Name)),
+		fmt.Sprintf("Peer ID: %s", peerid.Pretty()),
+	)
+
+	if profile.Bio != "" {
+		details = append(details, fmt.Sprintf("Bio: %s", sanitize(profile.Bio)))
+	}
+	for _, contact := range profile.Contacts {
+		details = append(details, fmt.Sprintf("Contact: %s", sanitize(contact)))
+	}
+	if profile.Updated > 0 {
+		details = append(details, fmt.Sprintf("Updated: %s", time.Unix(profile.Updated, 0).Format(time.RFC822)))
+	}
+
+	ui.showmodal("profile", details)
+}
+
+// A method of UI that displays lines of details in a popup with a close button
+func (ui *UI) showmodal(page string, details []string) {
+	modal := tview.NewModal().
+		SetText(strings.Join(details, "\n")).
+		AddButtons([]string{"Close"}).
+		SetDoneFunc(func(index int, label string) {
+			ui.pages.RemovePage(page)
+			ui.TerminalApp.SetFocus(ui.inputBox)
+		})
+
+	ui.pages.AddPage(page, modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
+
+// A function that returns a readable description of a connectedness state
+func connectedness(state network.Connectedness) string {
+	switch state {
+	case network.Connected:
+		return "connected"
+	case network.CanConnect:
+		return "recently connected"
+	case network.CannotConnect:
+		return "unreachable"
 	default:
-		ui.Logs <- chatlog{logprefix: "badcmd", logmsg: fmt.Sprintf("unsupported command - %s", cmd.cmdtype)}
+		return "not connected"
 	}
 }
 
+// A method of UI that finds a peer in the current room by user name or
+// by a suffix of its peer ID (as displayed in the peer box). The peer is
+// only found if the query matches exactly one peer.
+func (ui *UI) findpeer(query string) (peer.ID, bool) {
+	matches := ui.matchpeers(query)
+	if len(matches) != 1 {
+		return "", false
+	}
+
+	return matches[0], true
+}
+
+// A method of UI that returns the peers in the current room that match a query.
+// A user name or alias matches exactly and is preferred over peer ID suffixes,
+// which must be at least minpeersuffix characters long. An empty query matches nothing.
+func (ui *UI) matchpeers(query string) []peer.ID {
+	query = strings.TrimSpace(query)
+	if query == "" {
+		return nil
+	}
+
+	ui.stateLock.Lock()
+	defer ui.stateLock.Unlock()
+
+	var named, suffixed []peer.ID
+	for _, p := range ui.peers {
+		if alias, ok := friends.alias(p); ui.nicks[p] == query || ok && alias == query {
+			named = append(named, p)
+			continue
+		}
+
+		if len(query) >= minpeersuffix && strings.HasSuffix(p.Pretty(), query) {
+			suffixed = append(suffixed, p)
+		}
+	}
+
+	if len(named) > 0 {
+		return named
+	}
+
+	return suffixed
+}
+
 // A method of UI that displays a message recieved from a peer
-func (ui *UI) display_chatmessage(msg chatmessage) {
-	prompt := fmt.Sprintf("[green]<%s>:[-]", msg.SenderName)
-	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, msg.Message)
+func (ui *UI) display_chatmessage(msg ChatMessage) {
+	// Remember the user name of the sender
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		ui.stateLock.Lock()
+		ui.nicks[senderid] = msg.SenderName
+		ui.stateLock.Unlock()
+	}
+
+	// Warn prominently if the user name appears with a different key than it was pinned to
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		if pinned, changed := pins.check(msg.SenderName, senderid); changed {
+			ui.display_keywarning(msg.SenderName, pinned, senderid)
+		}
+	}
+
+	// Hide the message if its sender is flooding the room (friends are never hidden)
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		if _, friend := friends.alias(senderid); !friend {
+			var peerscore float64
+			if ui.Host != nil {
+				peerscore = ui.Host.PeerScore(senderid)
+			}
+
+			hidden, started := ui.spam.check(senderid, msg, peerscore)
+			if started {
+				fmt.Fprintf(ui.messageBox, "[gray]hiding messages from %s as spam (/show %s)[-]\n", sanitize(msg.SenderName), sanitize(msg.SenderName))
+			}
+			if hidden {
+				return
+			}
+		}
+	}
+
+	// Keep the message so that it can be copied
+	ui.recordrecent(msg)
+
+	// Show whispers distinctly, and only that they were sent to the other members
+	if msg.Whisper != nil {
+		ui.display_whisper(msg)
+		return
+	}
+
+	// Messages are sanitized so that peers cannot inject tags or control characters
+	fmt.Fprintf(ui.messageBox, "%s [gray]%s[-]%s\n", ui.chatprompt(msg), tview.Escape(tagprefix(msg.Tags)), rendermessage(msg.Message))
+
+	// Show the preview of the first link of the message
+	if link, ok := firstlink(msg.Message); ok && ui.Host != nil {
+		if senderid, err := peer.Decode(msg.SenderID); err == nil {
+			go ui.display_preview(senderid, link)
+		}
+	}
+
+	// Notify the user if they were mentioned
+	if mentions(msg.Message, ui.UserName) {
+		ui.notifier.Notify(ui.RoomName, fmt.Sprintf("%s mentioned you in %s", msg.SenderName, ui.RoomName), msg.Message)
+	}
+}
+
+// A method of UI that returns the colored prompt of a message recieved
+// from a peer, with the alias of friends instead of their user name
+func (ui *UI) chatprompt(msg ChatMessage) string {
+	name := msg.SenderName
+	if senderid, err := peer.Decode(msg.SenderID); err == nil {
+		if alias, ok := friends.alias(senderid); ok {
+			name = alias
+		}
+	}
+
+	color := nickcolor(ui.nickPalette, msg.SenderID)
+	return fmt.Sprintf("[%s]<%s>:[-]", color, sanitize(name))
+}
+
+// A method of UI that displays a placeholder for the messages that were
+// hidden as spam from each sender since the last placeholder
+func (ui *UI) display_spamreports() {
+	for senderid, count := range ui.spam.report() {
+		ui.stateLock.Lock()
+		name, ok := ui.nicks[senderid]
+		ui.stateLock.Unlock()
+
+		if !ok {
+			name = senderid.ShortString()
+		}
+
+		fmt.Fprintf(ui.messageBox, "[gray]%d messages hidden from %s (/show %s)[-]\n", count, sanitize(name), sanitize(name))
+	}
+}
+
+// A method of UI that fetches the preview of a link sent
+// by a peer and displays it if previews are enabled
+func (ui *UI) display_preview(sender peer.ID, link string) {
+	preview, err := ui.Host.LinkPreview(sender, link)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"link":  link,
+		}).Debugln("Failed to Fetch the Link Preview!")
+		return
+	}
+
+	if preview == nil || preview.Title == "" && preview.Description == "" {
+		return
+	}
+
+	text := preview.Title
+	if preview.Description != "" {
+		if text != "" {
+			text += " - "
+		}
+		text += preview.Description
+	}
+
+	fmt.Fprintf(ui.messageBox, "[gray]  > %s[-]\n", sanitize(text))
+}
+
+// A method of UI that displays a warning that a user name has appeared with a
+// different key than the key that was pinned for it on first contact
+func (ui *UI) display_keywarning(username string, pinned, current peer.ID) {
+	fmt.Fprintf(ui.messageBox, "[red::b]WARNING: '%s' is using a different key than before![-::-]\n", sanitize(username))
+	fmt.Fprintf(ui.messageBox, "[red::b]Pinned key: %s[-::-]\n", pinned.Pretty())
+	fmt.Fprintf(ui.messageBox, "[red::b]Current key: %s[-::-]\n", current.Pretty())
+	fmt.Fprintf(ui.messageBox, "[red::b]Someone may be impersonating them. Verify with them and use /trust %s to accept the new key.[-::-]\n", sanitize(username))
+
+	// Called from the event loop, so the log is displayed directly
+	ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "keychange", logmsg: fmt.Sprintf("'%s' appeared with key %s instead of the pinned key %s", username, current.Pretty(), pinned.Pretty())})
+}
+
+// A function that checks if a message mentions a given user name, ignoring case.
+// The mention must be a whole word, so '@bob' does not mention 'bo' and an
+// email address such as 'alice@bob.org' does not mention 'bob'.
+func mentions(message, username string) bool {
+	if username == "" {
+		return false
+	}
+
+	pattern := `(?i)(^|[^\pL\pN_])@` + regexp.QuoteMeta(username) + `($|[^\pL\pN_])`
+	return regexp.MustCompile(pattern).MatchString(message)
 }
 
-// A method of UI that displays a message recieved from self
+// A method of UI that applies the outbound transformers to a message, sends
+// it to the chat room and displays it, so that the user sees the message as it
+// is sent. A message dropped by a transformer is neither sent nor displayed.
+func (ui *UI) sendtext(msg string) {
+	message, ok := pipeline.transform(ui.RoomName, msg)
+	if !ok {
+		ui.display_logmessage(chatlog{loglevel: logrus.WarnLevel, logprefix: "dropped", logmsg: "the message was dropped by an outbound transformer"})
+		return
+	}
+
+	ui.transformed <- message
+	ui.display_selfmessage(message)
+}
+
+// A method of UI that displays a message recieved from self. Messages sent
+// while the room has no peers are marked as pending, since no one has them yet.
 func (ui *UI) display_selfmessage(msg string) {
-	prompt := fmt.Sprintf("[blue]<%s>:[-]", ui.UserName)
-	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, msg)
+	prompt := fmt.Sprintf("[blue]<%s>:[-]", sanitize(ui.UserName))
+	fmt.Fprintf(ui.messageBox, "%s %s%s\n", prompt, rendermessage(msg), pendingmarker(ui.ChatRoom))
 }
 
-// A method of UI that displays a log message
+// A method of UI that displays a message from a plugin
+func (ui *UI) display_pluginmessage(name string, msg string) {
+	prompt := fmt.Sprintf("[yellow]<%s>:[-]", sanitize(name))
+	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, sanitize(msg))
+}
+
+// A method of UI that displays a log message in the log pane.
+// Logs more verbose than the log pane's level are discarded.
 func (ui *UI) display_logmessage(log chatlog) {
-	prompt := fmt.Sprintf("[yellow]<%s>:[-]", log.logprefix)
-	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, log.logmsg)
+	// Check the log level against the filter
+	if log.loglevel > logrus.Level(atomic.LoadUint32(&ui.logLevel)) {
+		return
+	}
+
+	// Pick the prompt color based on the log level
+	var color string
+	switch {
+	case log.loglevel <= logrus.ErrorLevel:
+		color = "red"
+	case log.loglevel == logrus.WarnLevel:
+		color = "yellow"
+	default:
+		color = "white"
+	}
+
+	// Logs can carry user names and other text from peers
+	prompt := fmt.Sprintf("[%s]<%s>:[-]", color, log.logprefix)
+	fmt.Fprintf(ui.logBox, "%s %s\n", prompt, sanitize(log.logmsg))
+}
+
+// A method of UI that displays the usage of all commands, or
+// the detailed usage of a single command if one is given
+func (ui *UI) display_help(name string) {
+	prompt := "[yellow]<help>:[-]"
+
+	// Display the usage of all commands
+	if name == "" {
+		for _, command := range registry.list() {
+			fmt.Fprintf(ui.messageBox, "%s [red]%s[-] - %s\n", prompt, tview.Escape(command.usage()), command.Help)
+		}
+		return
+	}
+
+	// Lookup the command
+	command, ok := registry.lookup(name)
+	if !ok {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "badcmd", logmsg: fmt.Sprintf("no help for unsupported command - %s", name)}
+		return
+	}
+
+	// Display the detailed usage of the command
+	fmt.Fprintf(ui.messageBox, "%s [red]%s[-]\n", prompt, tview.Escape(command.usage()))
+	if len(command.Aliases) > 0 {
+		fmt.Fprintf(ui.messageBox, "%s aliases: %s\n", prompt, strings.Join(command.Aliases, ", "))
+	}
+	fmt.Fprintf(ui.messageBox, "%s %s\n", prompt, command.Details)
 }
 
 // A method of UI that refreshes the list of peers
@@ -308,6 +1177,11 @@ func (ui *UI) syncpeerbox() {
 	// Retrieve the list of peers from the chatroom
 	peers := ui.PeerList()
 
+	// Remember the listed peers for mouse clicks
+	ui.stateLock.Lock()
+	ui.peers = peers
+	ui.stateLock.Unlock()
+
 	// Clear() is not a threadsafe call
 	// So we acquire the thread lock on it
 	ui.peerBox.Lock()
@@ -316,12 +1190,25 @@ func (ui *UI) syncpeerbox() {
 	// Release the lock
 	ui.peerBox.Unlock()
 
+	// Warn that the messages reach no one while the room has no peers
+	ui.syncbanner(len(peers) == 0)
+	// Count the peers in the title of the message box
+	title := roomtitle(ui.ChatRoom, len(peers))
+	ui.TerminalApp.QueueUpdate(func() {
+		ui.messageBox.SetTitle(title)
+	})
+
 	// Iterate over the list of peers
 	for _, p := range peers {
 		// Generate the pretty version of the peer ID
 		peerid := p.Pretty()
 		// Shorten the peer ID
 		peerid = peerid[len(peerid)-8:]
+		// Show the alias of friends instead of the peer ID
+		if alias, ok := friends.alias(p); ok {
+			fmt.Fprintf(ui.peerBox, "[::b]%s[::-]\n", tview.Escape(alias))
+			continue
+		}
 		// Add the peer ID to the peer box
 		fmt.Fprintln(ui.peerBox, peerid)
 	}
@@ -0,0 +1,33 @@
+package src
+
+import "testing"
+
+func TestMentions(t *testing.T) {
+	tests := []struct {
+		message  string
+		username string
+		want     bool
+	}{
+		{"@bob hi", "bob", true},
+		{"hi @bob", "bob", true},
+		{"hi @Bob!", "bob", true},
+		{"(@bob)", "bob", true},
+		{"@bob, @carol", "carol", true},
+		{"hi @bobby", "bob", false},
+		{"hi @bob_", "bob", false},
+		{"mail alice@bob.org", "bob", false},
+		{"hi bob", "bob", false},
+		{"@bobé", "bob", false},
+		{"@josé!", "josé", true},
+		{"@a.b", "a.b", true},
+		{"@axb", "a.b", false},
+		{"@[red]", "[red]", true},
+		{"@ anyone", "", false},
+	}
+
+	for _, test := range tests {
+		if got := mentions(test.message, test.username); got != test.want {
+			t.Errorf("mentions(%q, %q) = %v, want %v", test.message, test.username, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package src
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the DHT namespace of the user profile records
+const profilenamespace = "peerchat-profile"
+
+// Represents the protocol ID for fetching a user profile directly from its peer
+const profileprotocol = protocol.ID("/peerchat/profile/1.0.0")
+
+// Represents the interval at which the user profile is published again,
+// which keeps the record alive on the DHT (records expire after 36 hours)
+const profilerefresh = time.Hour
+
+// Represents the time allowed to publish or retrieve a user profile
+const profiletimeout = 30 * time.Second
+
+// Represents the maximum size of a user profile record
+const maxprofilesize = 16 * 1024
+
+// A structure that represents the profile of a user, which is
+// published under the peer ID of the user in the DHT
+type UserProfile struct {
+	// Represents the display name of the user
+	Name string `json:"name"`
+	// Represents a short biography of the user
+	Bio string `json:"bio,omitempty"`
+	// Represents the hash of the avatar of the user
+	Avatar string `json:"avatar,omitempty"`
+	// Represents the public contact addresses of the user
+	Contacts []string `json:"contacts,omitempty"`
+	// Represents the time the profile was updated (newer profiles win)
+	Updated int64 `json:"updated"`
+}
+
+// A structure that represents a user profile record signed by the identity
+// key of its peer. The public key is included since RSA keys cannot be
+// extracted from peer IDs.
+type signedprofile struct {
+	Profile   []byte `json:"profile"`
+	PublicKey []byte `json:"publickey"`
+	Signature []byte `json:"signature"`
+}
+
+// A structure that represents the publisher of the user profile of the host
+type profilepublisher struct {
+	// Represents the profile of the user
+	profile UserProfile
+	// Represents whether the periodic publishing has started
+	started bool
+	// Represents the retrieved user profiles of other peers
+	known map[peer.ID]*UserProfile
+	// Represents the lock on the publisher
+	mutex sync.Mutex
+}
+
+// A structure that represents the validator of the user profile records
+// in the DHT, which accepts records signed by the peer in their key
+type profilevalidator struct{}
+
+// A function that returns the DHT key of the user profile of a peer
+func profilekey(peerid peer.ID) string {
+	return fmt.Sprintf("/%s/%s", profilenamespace, string(peerid))
+}
+
+// A function that returns the path of the user profile of the selected profile
+func userprofilepath() string {
+	return filepath.Join(defaultdir(), "userprofile.json")
+}
+
+// A function that loads the user profile of the selected
+// profile, returning an empty profile if there is none
+func loaduserprofile() UserProfile {
+	var profile UserProfile
+
+	data, err := ioutil.ReadFile(userprofilepath())
+	if err != nil {
+		return profile
+	}
+
+	if err := json.Unmarshal(data, &profile); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  userprofilepath(),
+		}).Warnln("Failed to Parse the User Profile!")
+	}
+
+	return profile
+}
+
+// A function that saves the user profile of the selected profile
+func saveuserprofile(profile UserProfile) error {
+	data, err := json.MarshalIndent(profile, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(userprofilepath()), 0700); err != nil {
+		return err
+	}
+
+	return ioutil.WriteFile(userprofilepath(), data, 0600)
+}
+
+// A function that verifies a signed user profile record for a peer and returns the profile
+func verifyprofile(peerid peer.ID, value []byte) (*UserProfile, error) {
+	if len(value) > maxprofilesize {
+		return nil, errors.New("profile record is too large")
+	}
+
+	var record signedprofile
+	if err := json.Unmarshal(value, &record); err != nil {
+		return nil, err
+	}
+
+	// The public key must belong to the peer
+	pubkey, err := crypto.UnmarshalPublicKey(record.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	if !peerid.MatchesPublicKey(pubkey) {
+		return nil, errors.New("profile record is not signed by its peer")
+	}
+
+	ok, err := pubkey.Verify(record.Profile, record.Signature)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("invalid profile signature")
+	}
+
+	var profile UserProfile
+	if err := json.Unmarshal(record.Profile, &profile); err != nil {
+		return nil, err
+	}
+
+	return &profile, nil
+}
+
+// A function that signs a user profile with an identity key into a record
+func signprofile(profile UserProfile, prvkey crypto.PrivKey) ([]byte, error) {
+	data, err := json.Marshal(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := prvkey.Sign(data)
+	if err != nil {
+		return nil, err
+	}
+
+	pubkey, err := crypto.MarshalPublicKey(prvkey.GetPublic())
+	if err != nil {
+		return nil, err
+	}
+
+	return json.Marshal(signedprofile{Profile: data, PublicKey: pubkey, Signature: signature})
+}
+
+// A function that returns the peer ID of a user profile DHT key
+func profilepeer(key string) (peer.ID, error) {
+	prefix := fmt.Sprintf("/%s/", profilenamespace)
+	if len(key) <= len(prefix) || key[:len(prefix)] != prefix {
+		return "", errors.New("invalid profile key")
+	}
+
+	return peer.IDFromBytes([]byte(key[len(prefix):]))
+}
+
+// A method of profilevalidator that validates a user profile record
+func (profilevalidator) Validate(key string, value []byte) error {
+	peerid, err := profilepeer(key)
+	if err != nil {
+		return err
+	}
+
+	_, err = verifyprofile(peerid, value)
+	return err
+}
+
+// A method of profilevalidator that selects the most recently updated user profile record
+func (profilevalidator) Select(key string, values [][]byte) (int, error) {
+	peerid, err := profilepeer(key)
+	if err != nil {
+		return 0, err
+	}
+
+	best, updated := -1, int64(0)
+	for index, value := range values {
+		profile, err := verifyprofile(peerid, value)
+		if err != nil {
+			continue
+		}
+
+		if best == -1 || profile.Updated > updated {
+			best, updated = index, profile.Updated
+		}
+	}
+
+	if best == -1 {
+		return 0, errors.New("no valid profile records")
+	}
+
+	return best, nil
+}
+
+// A method of P2P that returns the signed user profile record of the host
+func (p2p *P2P) profilerecord() ([]byte, error) {
+	p2p.profile.mutex.Lock()
+	profile := p2p.profile.profile
+	p2p.profile.mutex.Unlock()
+
+	return signprofile(profile, p2p.Host.Peerstore().PrivKey(p2p.Host.ID()))
+}
+
+// A method of P2P that publishes the user profile of the host to the DHT
+func (p2p *P2P) publishprofile() {
+	record, err := p2p.profilerecord()
+	if err == nil {
+		ctx, cancel := context.WithTimeout(p2p.Ctx, profiletimeout)
+		err = p2p.putvalue(ctx, profilekey(p2p.Host.ID()), record)
+		cancel()
+	}
+
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"peer":  p2p.Host.ID().Pretty(),
+		}).Debugln("Failed to Publish the User Profile!")
+	}
+}
+
+// A method of P2P that sets the display name of the user profile of the host
+// and publishes it. The profile is published again periodically afterwards.
+func (p2p *P2P) PublishProfile(name string) {
+	p2p.UpdateProfile(func(profile *UserProfile) { profile.Name = name })
+}
+
+// A method of P2P that updates the user profile of the host, saves it
+// (except for the display name, which is the user name) and publishes it
+func (p2p *P2P) UpdateProfile(update func(profile *UserProfile)) error {
+	p2p.profile.mutex.Lock()
+	update(&p2p.profile.profile)
+	p2p.profile.profile.Updated = time.Now().Unix()
+
+	saved := p2p.profile.profile
+	saved.Name = ""
+	start := !p2p.profile.started
+	p2p.profile.started = true
+	p2p.profile.mutex.Unlock()
+
+	err := saveuserprofile(saved)
+
+	if start {
+		go p2p.refreshprofile()
+	} else {
+		go p2p.publishprofile()
+	}
+
+	return err
+}
+
+// A method of P2P that returns the user profile of the host
+func (p2p *P2P) Profile() UserProfile {
+	p2p.profile.mutex.Lock()
+	defer p2p.profile.mutex.Unlock()
+
+	return p2p.profile.profile
+}
+
+// A method of P2P that publishes the user profile of
+// the host periodically until the host context closes
+func (p2p *P2P) refreshprofile() {
+	ticker := time.NewTicker(profilerefresh)
+	defer ticker.Stop()
+
+	for {
+		p2p.publishprofile()
+
+		select {
+		case <-p2p.Ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// A method of P2P that handles a request for the user profile
+// of the host by replying with its signed profile record
+func (p2p *P2P) handleprofile(stream network.Stream) {
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(profiletimeout))
+
+	record, err := p2p.profilerecord()
+	if err != nil {
+		stream.Reset()
+		return
+	}
+
+	stream.Write(record)
+}
+
+// A method of P2P that retrieves the user profile of a peer from the DHT,
+// falling back to requesting it from the peer directly. The profile is
+// verified against the identity key of the peer.
+func (p2p *P2P) LookupProfile(peerid peer.ID) (*UserProfile, error) {
+	ctx, cancel := context.WithTimeout(p2p.Ctx, profiletimeout)
+	defer cancel()
+
+	profile, err := p2p.lookupprofile(ctx, peerid)
+	if err != nil {
+		return nil, err
+	}
+
+	// Remember the profile for the avatars
+	p2p.profile.mutex.Lock()
+	p2p.profile.known[peerid] = profile
+	p2p.profile.mutex.Unlock()
+
+	return profile, nil
+}
+
+// A method of P2P that returns the last retrieved user profile of a
+// peer, retrieving it if it has not been retrieved before
+func (p2p *P2P) knownprofile(peerid peer.ID) (*UserProfile, error) {
+	p2p.profile.mutex.Lock()
+	profile, ok := p2p.profile.known[peerid]
+	p2p.profile.mutex.Unlock()
+
+	if ok {
+		return profile, nil
+	}
+
+	return p2p.LookupProfile(peerid)
+}
+
+// A method of P2P that retrieves the user profile of a peer from the DHT or the peer
+func (p2p *P2P) lookupprofile(ctx context.Context, peerid peer.ID) (*UserProfile, error) {
+	// The own profile is always known
+	if peerid == p2p.Host.ID() {
+		profile := p2p.Profile()
+		return &profile, nil
+	}
+
+	value, err := p2p.getvalue(ctx, profilekey(peerid))
+	if err == nil {
+		return verifyprofile(peerid, value)
+	}
+
+	// Request the profile from the peer if it is not on the DHT
+	stream, streamerr := p2p.Host.NewStream(ctx, peerid, profileprotocol)
+	if streamerr != nil {
+		return nil, fmt.Errorf("profile not found - %s", err)
+	}
+	defer stream.Close()
+	stream.SetDeadline(time.Now().Add(profiletimeout))
+
+	value, err = ioutil.ReadAll(io.LimitReader(stream, maxprofilesize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	return verifyprofile(peerid, value)
+}
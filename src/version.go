@@ -0,0 +1,63 @@
+package src
+
+import (
+	"fmt"
+	"runtime"
+	"runtime/debug"
+)
+
+// Represents the semantic version of the application and the git commit
+// and date of the build. The commit and date are set at build time with
+//
+//	go build -ldflags "-X github.com/manishmeganathan/peerchat/src.commit=<sha> -X github.com/manishmeganathan/peerchat/src.builddate=<date>"
+var (
+	appversion = "v1.1.0"
+	commit     = "unknown"
+	builddate  = "unknown"
+)
+
+// Represents the path of the libp2p module
+const libp2pmodule = "github.com/libp2p/go-libp2p"
+
+// A function that returns the version of the application
+func Version() string {
+	return appversion
+}
+
+// A function that returns the agent version of the application,
+// which is advertised to other peers with the libp2p Identify protocol
+func AgentVersion() string {
+	return fmt.Sprintf("peerchat/%s", appversion)
+}
+
+// A function that returns the version of the libp2p module the
+// application was built with, or "unknown" if it is not available
+func libp2pversion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	for _, dep := range info.Deps {
+		if dep.Path == libp2pmodule {
+			if dep.Replace != nil {
+				return dep.Replace.Version
+			}
+			return dep.Version
+		}
+	}
+
+	return "unknown"
+}
+
+// A function that returns the build information of the application as
+// lines of the version, git commit, build date, libp2p and Go versions
+func BuildInfo() []string {
+	return []string{
+		fmt.Sprintf("peerchat %s", appversion),
+		fmt.Sprintf("commit:  %s", commit),
+		fmt.Sprintf("built:   %s", builddate),
+		fmt.Sprintf("libp2p:  %s", libp2pversion()),
+		fmt.Sprintf("go:      %s %s/%s", runtime.Version(), runtime.GOOS, runtime.GOARCH),
+	}
+}
@@ -0,0 +1,428 @@
+package src
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/libp2p/go-libp2p-core/network"
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/libp2p/go-libp2p-core/protocol"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the protocol ID for the voice streams between peers
+const voiceprotocol = protocol.ID("/peerchat/voice/1.0.0")
+
+// Represents the maximum size of the audio of a voice frame
+const maxvoiceframe = 4096
+
+// Represents the tag that protects the connections with the peers
+// of the voice channel from being pruned by the connection manager
+const voiceprotecttag = "peerchat-voice"
+
+// Represents the longest time the user talks at once, after which talking stops.
+// Terminals do not report key releases, so the push-to-talk key toggles talking.
+const maxtalk = time.Minute
+
+// Represents the commands that capture the microphone into Ogg/Opus on their output
+// and play Ogg/Opus from their input, if the configuration does not set others
+const (
+	defaultcapture  = "ffmpeg -loglevel quiet -f pulse -i default -ac 1 -c:a libopus -b:a 24k -application voip -f ogg -"
+	defaultplayback = "ffplay -loglevel quiet -nodisp -autoexit -f ogg -"
+)
+
+// Represents the types of the voice frames. The audio of each time a peer talks
+// is framed by a start and an end frame, so that it is a stream of its own.
+const (
+	voicestart byte = iota + 1
+	voiceaudio
+	voiceend
+)
+
+// A structure that represents the settings of the voice channels. The commands are
+// run with 'sh -c'. The capture command writes Ogg/Opus from the microphone to its
+// output and the playback command plays the Ogg/Opus written to its input.
+type VoiceConfig struct {
+	// Represents the command that captures the microphone (ffmpeg with PulseAudio if empty)
+	Capture string `yaml:"capture"`
+	// Represents the command that plays the voice of the peers (ffplay if empty)
+	Playback string `yaml:"playback"`
+}
+
+// A structure that represents the voice stream with a peer
+type voicepeer struct {
+	// Represents the stream
+	stream network.Stream
+	// Represents the lock on writes to the stream
+	writelock sync.Mutex
+	// Represents the playback of the peer while they talk, and its input
+	player      *exec.Cmd
+	playerinput io.WriteCloser
+}
+
+// A structure that represents the voice channel of a host, which is a voice
+// stream with each of the peers in the channel. Peers are only heard once
+// the user has added them to the channel.
+type voicechannel struct {
+	// Represents the peers added to the channel and their streams (nil until opened)
+	peers map[peer.ID]*voicepeer
+	// Represents the capture of the microphone while the user talks
+	capture *exec.Cmd
+	// Represents the events of the channel, read by the UI
+	events chan string
+	// Represents the lock on the channel
+	lock sync.Mutex
+}
+
+// A constructor function that generates and returns an empty voicechannel
+func newvoicechannel() *voicechannel {
+	return &voicechannel{peers: make(map[peer.ID]*voicepeer), events: make(chan string, 16)}
+}
+
+// A method of voicechannel that reports an event of the channel to the UI,
+// dropping it if the events are not read
+func (voice *voicechannel) report(format string, args ...interface{}) {
+	select {
+	case voice.events <- fmt.Sprintf(format, args...):
+	default:
+	}
+}
+
+// A method of P2P that returns the channel of the events of the voice channel
+func (p2p *P2P) VoiceEvents() <-chan string {
+	return p2p.voice.events
+}
+
+// A function that writes a voice frame to a stream
+func writevoiceframe(writer io.Writer, kind byte, data []byte) error {
+	header := []byte{kind, 0, 0}
+	binary.BigEndian.PutUint16(header[1:], uint16(len(data)))
+
+	if _, err := writer.Write(header); err != nil {
+		return err
+	}
+	_, err := writer.Write(data)
+	return err
+}
+
+// A function that reads a voice frame from a stream
+func readvoiceframe(reader io.Reader) (byte, []byte, error) {
+	header := make([]byte, 3)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint16(header[1:])
+	if size > maxvoiceframe {
+		return 0, nil, fmt.Errorf("voice frame too large (%d bytes)", size)
+	}
+
+	data := make([]byte, size)
+	_, err := io.ReadFull(reader, data)
+	return header[0], data, err
+}
+
+// A method of P2P that adds peers to the voice channel and opens a voice
+// stream to each. The peers hear the user once they add the host as well.
+func (p2p *P2P) JoinVoice(peerids ...peer.ID) error {
+	for _, peerid := range peerids {
+		if peerid == p2p.Host.ID() {
+			return errors.New("cannot talk to yourself")
+		}
+
+		p2p.voice.lock.Lock()
+		existing, ok := p2p.voice.peers[peerid]
+		if !ok {
+			p2p.voice.peers[peerid] = nil
+		}
+		p2p.voice.lock.Unlock()
+
+		// The peer has already opened a stream
+		if existing != nil {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(p2p.Ctx, dmtimeout)
+		stream, err := p2p.Host.NewStream(ctx, peerid, voiceprotocol)
+		cancel()
+		if err != nil {
+			return fmt.Errorf("cannot reach %s - %s", peerid.ShortString(), err)
+		}
+
+		p2p.addvoicestream(peerid, stream)
+	}
+
+	return nil
+}
+
+// A method of P2P that handles the voice streams opened by peers. The streams
+// of peers that are not in the voice channel are reset, and the UI is told.
+// If both peers opened a stream at once, the stream of the lower peer ID is kept.
+func (p2p *P2P) handlevoice(stream network.Stream) {
+	peerid := stream.Conn().RemotePeer()
+
+	p2p.voice.lock.Lock()
+	existing, ok := p2p.voice.peers[peerid]
+	p2p.voice.lock.Unlock()
+
+	if !ok {
+		stream.Reset()
+		p2p.voice.report("%s wants to talk, /voice %s to join them", peerid.ShortString(), peerid.ShortString())
+		return
+	}
+	if existing != nil && p2p.Host.ID() < peerid {
+		stream.Reset()
+		return
+	}
+
+	p2p.addvoicestream(peerid, stream)
+}
+
+// A method of P2P that sets the voice stream with a peer in the channel,
+// replacing any previous stream, and starts reading its frames
+func (p2p *P2P) addvoicestream(peerid peer.ID, stream network.Stream) {
+	vp := &voicepeer{stream: stream}
+
+	p2p.voice.lock.Lock()
+	if previous := p2p.voice.peers[peerid]; previous != nil {
+		previous.stream.Close()
+	}
+	p2p.voice.peers[peerid] = vp
+	p2p.voice.lock.Unlock()
+
+	// Keep the connection with the peer while the voice channel is open
+	p2p.Host.ConnManager().Protect(peerid, voiceprotecttag)
+
+	go p2p.readvoice(peerid, vp)
+}
+
+// A method of P2P that reads the frames of the voice stream of a peer and plays
+// the audio of the peer while they talk, until the stream closes
+func (p2p *P2P) readvoice(peerid peer.ID, vp *voicepeer) {
+	defer func() {
+		vp.stopplayback()
+		vp.stream.Reset()
+
+		p2p.voice.lock.Lock()
+		if p2p.voice.peers[peerid] == vp {
+			p2p.voice.peers[peerid] = nil
+		}
+		p2p.voice.lock.Unlock()
+	}()
+
+	reader := bufio.NewReader(vp.stream)
+	for {
+		kind, data, err := readvoiceframe(reader)
+		if err != nil {
+			return
+		}
+
+		switch kind {
+		case voicestart:
+			vp.stopplayback()
+			if err := vp.startplayback(); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"peer":  peerid.Pretty(),
+				}).Warnln("Failed to Start the Voice Playback!")
+				p2p.voice.report("cannot play the voice of %s - %s", peerid.ShortString(), err)
+				continue
+			}
+			p2p.voice.report("%s is talking", peerid.ShortString())
+
+		case voiceaudio:
+			if vp.playerinput != nil {
+				vp.playerinput.Write(data)
+			}
+
+		case voiceend:
+			vp.stopplayback()
+		}
+	}
+}
+
+// A method of voicepeer that starts the playback of the voice of the peer
+func (vp *voicepeer) startplayback() error {
+	command := currentconfig().Voice.Playback
+	if command == "" {
+		command = defaultplayback
+	}
+
+	player := exec.Command("sh", "-c", command)
+	input, err := player.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := player.Start(); err != nil {
+		return err
+	}
+
+	vp.player, vp.playerinput = player, input
+	return nil
+}
+
+// A method of voicepeer that stops the playback of the voice of the peer, letting it play what it has
+func (vp *voicepeer) stopplayback() {
+	if vp.player == nil {
+		return
+	}
+
+	vp.playerinput.Close()
+	go vp.player.Wait()
+	vp.player, vp.playerinput = nil, nil
+}
+
+// A method of P2P that sends a voice frame to every peer in the voice channel
+func (p2p *P2P) broadcastvoice(kind byte, data []byte) {
+	p2p.voice.lock.Lock()
+	var peers []*voicepeer
+	for _, vp := range p2p.voice.peers {
+		if vp != nil {
+			peers = append(peers, vp)
+		}
+	}
+	p2p.voice.lock.Unlock()
+
+	for _, vp := range peers {
+		vp.writelock.Lock()
+		vp.stream.SetWriteDeadline(time.Now().Add(dmtimeout))
+		err := writevoiceframe(vp.stream, kind, data)
+		vp.writelock.Unlock()
+
+		if err != nil {
+			vp.stream.Reset()
+		}
+	}
+}
+
+// A method of P2P that starts or stops talking to the peers of the voice
+// channel, capturing the microphone while talking. Talking stops by itself
+// after maxtalk. Returns whether the user is talking now.
+func (p2p *P2P) Talk() (bool, error) {
+	p2p.voice.lock.Lock()
+	defer p2p.voice.lock.Unlock()
+
+	if p2p.voice.capture != nil {
+		p2p.voice.capture.Process.Kill()
+		p2p.voice.capture = nil
+		return false, nil
+	}
+	if len(p2p.voice.peers) == 0 {
+		return false, errors.New("nobody is in the voice channel, /voice <peer> adds a peer")
+	}
+
+	command := currentconfig().Voice.Capture
+	if command == "" {
+		command = defaultcapture
+	}
+
+	capture := exec.Command("sh", "-c", command)
+	output, err := capture.StdoutPipe()
+	if err != nil {
+		return false, err
+	}
+	if err := capture.Start(); err != nil {
+		return false, fmt.Errorf("cannot capture the microphone - %s", err)
+	}
+
+	p2p.voice.capture = capture
+	go p2p.sendvoice(capture, output)
+	return true, nil
+}
+
+// A method of P2P that sends the audio captured from the microphone to the
+// peers of the voice channel until the capture ends or talking times out
+func (p2p *P2P) sendvoice(capture *exec.Cmd, output io.Reader) {
+	timeout := time.AfterFunc(maxtalk, func() {
+		p2p.voice.lock.Lock()
+		if p2p.voice.capture == capture {
+			capture.Process.Kill()
+			p2p.voice.capture = nil
+			p2p.voice.report("stopped talking after %s", maxtalk)
+		}
+		p2p.voice.lock.Unlock()
+	})
+	defer timeout.Stop()
+
+	p2p.broadcastvoice(voicestart, nil)
+
+	buffer := make([]byte, maxvoiceframe)
+	for {
+		count, err := output.Read(buffer)
+		if count > 0 {
+			p2p.broadcastvoice(voiceaudio, buffer[:count])
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	p2p.broadcastvoice(voiceend, nil)
+	capture.Wait()
+
+	p2p.voice.lock.Lock()
+	if p2p.voice.capture == capture {
+		p2p.voice.capture = nil
+	}
+	p2p.voice.lock.Unlock()
+}
+
+// A method of P2P that returns the peers in the voice channel and whether their stream is open
+func (p2p *P2P) VoicePeers() map[peer.ID]bool {
+	p2p.voice.lock.Lock()
+	defer p2p.voice.lock.Unlock()
+
+	peers := make(map[peer.ID]bool, len(p2p.voice.peers))
+	for peerid, vp := range p2p.voice.peers {
+		peers[peerid] = vp != nil
+	}
+
+	return peers
+}
+
+// A method of P2P that leaves the voice channel, stopping talking
+// and closing the voice streams with all of its peers
+func (p2p *P2P) LeaveVoice() {
+	p2p.voice.lock.Lock()
+	if p2p.voice.capture != nil {
+		p2p.voice.capture.Process.Kill()
+		p2p.voice.capture = nil
+	}
+
+	peers := p2p.voice.peers
+	p2p.voice.peers = make(map[peer.ID]*voicepeer)
+	p2p.voice.lock.Unlock()
+
+	for peerid, vp := range peers {
+		p2p.Host.ConnManager().Unprotect(peerid, voiceprotecttag)
+		if vp != nil {
+			vp.stream.Close()
+		}
+	}
+}
+
+// A method of UI that starts or stops talking to the peers of the voice
+// channel, which the push-to-talk key and '/voice talk' toggle
+func (ui *UI) talk() {
+	if ui.Host == nil {
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "voice", logmsg: "voice is not supported when attached to a daemon"}
+		return
+	}
+
+	talking, err := ui.Host.Talk()
+	switch {
+	case err != nil:
+		ui.Logs <- chatlog{loglevel: logrus.WarnLevel, logprefix: "voice", logmsg: err.Error()}
+	case talking:
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: "talking, press the push-to-talk key again to stop"}
+	default:
+		ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "voice", logmsg: "stopped talking"}
+	}
+}
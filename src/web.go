@@ -0,0 +1,221 @@
+package src
+
+import (
+	"crypto/subtle"
+	"embed"
+	"io/fs"
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the bundled web client
+//
+//go:embed web
+var webclient embed.FS
+
+// Represents the upgrader for WebSocket connections.
+// The default origin check only allows same origin requests.
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 1024,
+}
+
+// A structure that represents a JSON frame sent by a web client.
+// Type is one of 'join', 'leave', 'send', 'history' or 'peers'.
+type webinput struct {
+	Type    string `json:"type"`
+	Room    string `json:"room"`
+	Message string `json:"message,omitempty"`
+	Limit   int    `json:"limit,omitempty"`
+}
+
+// A structure that represents a JSON frame sent to a web client.
+// Type is one of 'self', 'rooms', 'message', 'history', 'peers' or 'error'.
+type weboutput struct {
+	Type     string           `json:"type"`
+	Room     string           `json:"room,omitempty"`
+	User     string           `json:"user,omitempty"`
+	ID       string           `json:"id,omitempty"`
+	Error    string           `json:"error,omitempty"`
+	Rooms    []string         `json:"rooms,omitempty"`
+	Message  *GatewayMessage  `json:"message,omitempty"`
+	Messages []GatewayMessage `json:"messages,omitempty"`
+	Peers    []GatewayPeer    `json:"peers,omitempty"`
+}
+
+// A structure that represents a WebSocket connection of a web client
+type webconn struct {
+	// Represents the gateway of the connection
+	gw *Gateway
+	// Represents the WebSocket connection
+	conn *websocket.Conn
+	// Represents the lock on writes to the connection
+	mutex sync.Mutex
+}
+
+/*
+A function that starts the web gateway of a Gateway on the given address.
+It serves a bundled web client at '/', a WebSocket at '/ws' and the REST API,
+so that a browser on the same machine or LAN can participate in the rooms.
+Requests to the REST API must carry the token as a bearer token. The
+WebSocket may also be opened with the token as a 'token' query parameter,
+which the web client takes from its own URL (e.g. http://127.0.0.1:8789/?token=secret).
+The web client itself holds no data and is served without the token.
+The token may only be left empty if the web gateway listens on a loopback address.
+*/
+func ServeWeb(gw *Gateway, addr string, token string) error {
+	// Listen on the address
+	listener, err := listenapi(addr, token)
+	if err != nil {
+		return err
+	}
+
+	// Strip the directory of the bundled web client
+	static, err := fs.Sub(webclient, "web")
+	if err != nil {
+		return err
+	}
+
+	// Serve the REST API with the token in the Authorization header
+	api := http.NewServeMux()
+	addapiroutes(api, gw)
+
+	mux := http.NewServeMux()
+	for _, route := range []string{"/self", "/rooms", "/rooms/"} {
+		mux.Handle(route, authorize(api, token))
+	}
+
+	mux.Handle("/", http.FileServer(http.FS(static)))
+	mux.Handle("/ws", authorizeupgrade(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Upgrade the connection to a WebSocket
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+
+		wc := &webconn{gw: gw, conn: conn}
+		wc.run()
+	}), token))
+
+	// Serve the requests in the background
+	go func() {
+		if err := http.Serve(listener, guardapi(mux, token)); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Errorln("Web Gateway Failed!")
+		}
+	}()
+
+	return nil
+}
+
+// A function that wraps the handler of the WebSocket to require a token. Since
+// browsers cannot set headers on WebSocket requests, the token may also be given
+// as a 'token' query parameter, but only on the request that upgrades the connection.
+// The handler is returned as is if the token is empty.
+func authorizeupgrade(handler http.Handler, token string) http.Handler {
+	if token == "" {
+		return handler
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query().Get("token")
+		if !checkbearer(r, token) && !(websocket.IsWebSocketUpgrade(r) && subtle.ConstantTimeCompare([]byte(query), []byte(token)) == 1) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// A method of webconn that relays the gateway messages to
+// the web client and handles its frames until it disconnects
+func (wc *webconn) run() {
+	defer wc.conn.Close()
+
+	// Subscribe to the gateway messages
+	messages, cancel := wc.gw.Subscribe()
+	defer cancel()
+
+	go func() {
+		for msg := range messages {
+			msg := msg
+			if err := wc.send(weboutput{Type: "message", Room: msg.Room, Message: &msg}); err != nil {
+				return
+			}
+		}
+	}()
+
+	// Greet the client with the node and the joined rooms
+	wc.send(weboutput{Type: "self", ID: wc.gw.Host.Host.ID().Pretty(), User: wc.gw.UserName})
+	wc.send(weboutput{Type: "rooms", Rooms: wc.gw.Rooms()})
+
+	for {
+		// Read the next frame
+		var in webinput
+		if err := wc.conn.ReadJSON(&in); err != nil {
+			return
+		}
+
+		wc.handle(in)
+	}
+}
+
+// A method of webconn that handles a frame from the web client
+func (wc *webconn) handle(in webinput) {
+	switch in.Type {
+	case "join":
+		if err := wc.gw.Join(in.Room); err != nil {
+			wc.send(weboutput{Type: "error", Room: in.Room, Error: err.Error()})
+			return
+		}
+
+		wc.send(weboutput{Type: "rooms", Rooms: wc.gw.Rooms()})
+
+	case "leave":
+		if err := wc.gw.Leave(in.Room); err != nil {
+			wc.send(weboutput{Type: "error", Room: in.Room, Error: err.Error()})
+			return
+		}
+
+		wc.send(weboutput{Type: "rooms", Rooms: wc.gw.Rooms()})
+
+	case "send":
+		if err := wc.gw.Send(in.Room, in.Message); err != nil {
+			wc.send(weboutput{Type: "error", Room: in.Room, Error: err.Error()})
+		}
+
+	case "history":
+		history, err := wc.gw.History(in.Room, in.Limit)
+		if err != nil {
+			wc.send(weboutput{Type: "error", Room: in.Room, Error: err.Error()})
+			return
+		}
+
+		wc.send(weboutput{Type: "history", Room: in.Room, Messages: history})
+
+	case "peers":
+		peers, err := wc.gw.Peers(in.Room)
+		if err != nil {
+			wc.send(weboutput{Type: "error", Room: in.Room, Error: err.Error()})
+			return
+		}
+
+		wc.send(weboutput{Type: "peers", Room: in.Room, Peers: peers})
+
+	default:
+		wc.send(weboutput{Type: "error", Error: "unsupported frame type - " + in.Type})
+	}
+}
+
+// A method of webconn that writes a frame to the web client
+func (wc *webconn) send(out weboutput) error {
+	wc.mutex.Lock()
+	defer wc.mutex.Unlock()
+
+	return wc.conn.WriteJSON(out)
+}
@@ -0,0 +1,44 @@
+package src
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAuthorize(t *testing.T) {
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+	upgrade := http.Header{"Connection": {"Upgrade"}, "Upgrade": {"websocket"}}
+	bearer := http.Header{"Authorization": {"Bearer secret"}}
+
+	tests := []struct {
+		name    string
+		handler http.Handler
+		target  string
+		header  http.Header
+		want    int
+	}{
+		{"api with bearer", authorize(ok, "secret"), "/rooms", bearer, http.StatusOK},
+		{"api without token", authorize(ok, "secret"), "/rooms", nil, http.StatusUnauthorized},
+		{"api with query", authorize(ok, "secret"), "/rooms?token=secret", nil, http.StatusUnauthorized},
+		{"api with wrong bearer", authorize(ok, "secret"), "/rooms", http.Header{"Authorization": {"Bearer wrong"}}, http.StatusUnauthorized},
+		{"upgrade with query", authorizeupgrade(ok, "secret"), "/ws?token=secret", upgrade, http.StatusOK},
+		{"upgrade with bearer", authorizeupgrade(ok, "secret"), "/ws", bearer, http.StatusOK},
+		{"upgrade with wrong query", authorizeupgrade(ok, "secret"), "/ws?token=wrong", upgrade, http.StatusUnauthorized},
+		{"plain request with query", authorizeupgrade(ok, "secret"), "/ws?token=secret", nil, http.StatusUnauthorized},
+		{"no token", authorize(ok, ""), "/rooms", nil, http.StatusOK},
+	}
+
+	for _, test := range tests {
+		request := httptest.NewRequest(http.MethodGet, test.target, nil)
+		for key, values := range test.header {
+			request.Header[key] = values
+		}
+
+		recorder := httptest.NewRecorder()
+		test.handler.ServeHTTP(recorder, request)
+		if recorder.Code != test.want {
+			t.Errorf("%s: got status %d, want %d", test.name, recorder.Code, test.want)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+package src
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the timeout for delivering a webhook
+const webhooktimeout = time.Second * 10
+
+// A structure that represents an outgoing webhook. Recieved messages
+// that match the rooms and the pattern are POSTed to the URL as JSON.
+type webhook struct {
+	// Represents the URL of the HTTP endpoint
+	URL string `json:"url"`
+	// Represents the rooms whose messages are delivered (all rooms if empty)
+	Rooms []string `json:"rooms"`
+	// Represents the regular expression that messages must match (optional)
+	Match string `json:"match"`
+	// Represents the extra HTTP headers of the request (optional)
+	Headers map[string]string `json:"headers"`
+
+	// Represents the compiled pattern
+	pattern *regexp.Regexp
+}
+
+// A structure that represents the JSON payload of a webhook
+type webhookpayload struct {
+	Room       string    `json:"room"`
+	SenderID   string    `json:"senderid"`
+	SenderName string    `json:"sendername"`
+	Message    string    `json:"message"`
+	Timestamp  time.Time `json:"timestamp"`
+}
+
+// Represents the HTTP client used to deliver webhooks
+var webhookclient = &http.Client{Timeout: webhooktimeout}
+
+// A function that loads the outgoing webhooks from the webhooks file
+// (~/.peerchat/webhooks.json) and adds them to the message pipeline.
+// The file contains a JSON list of webhooks with a 'url' and optional
+// 'rooms', 'match' and 'headers'. Returns the number of webhooks loaded.
+func LoadWebhooks() int {
+	path := peerchatpath("webhooks.json")
+
+	// Read the webhooks file
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		logrus.Debugf("No Webhooks Loaded from %s.", path)
+		return 0
+	}
+
+	// Unmarshal the webhooks
+	var webhooks []*webhook
+	if err := json.Unmarshal(data, &webhooks); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"file":  path,
+		}).Warnln("Failed to Parse Webhooks!")
+		return 0
+	}
+
+	loaded := 0
+	for _, hook := range webhooks {
+		// Compile the pattern
+		if hook.Match != "" {
+			if hook.pattern, err = regexp.Compile(hook.Match); err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+					"url":   hook.URL,
+				}).Warnln("Failed to Compile Webhook Pattern!")
+				continue
+			}
+		}
+
+		AddInboundFilter(hook.observe)
+		loaded++
+	}
+
+	return loaded
+}
+
+// A method of webhook that observes inbound messages and delivers the
+// matching ones in the background. Messages are always passed through.
+func (hook *webhook) observe(room string, msg ChatMessage) (ChatMessage, bool) {
+	if hook.matches(room, msg) {
+		go hook.deliver(webhookpayload{
+			Room:       room,
+			SenderID:   msg.SenderID,
+			SenderName: msg.SenderName,
+			Message:    msg.Message,
+			Timestamp:  time.Now(),
+		})
+	}
+
+	return msg, true
+}
+
+// A method of webhook that checks if a message matches its rooms and pattern
+func (hook *webhook) matches(room string, msg ChatMessage) bool {
+	// Check the pattern
+	if hook.pattern != nil && !hook.pattern.MatchString(msg.Message) {
+		return false
+	}
+
+	// Check the rooms
+	if len(hook.Rooms) == 0 {
+		return true
+	}
+
+	for _, hookroom := range hook.Rooms {
+		if hookroom == room {
+			return true
+		}
+	}
+
+	return false
+}
+
+// A method of webhook that POSTs a payload to its URL
+func (hook *webhook) deliver(payload webhookpayload) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return
+	}
+
+	// Create the request
+	request, err := http.NewRequest(http.MethodPost, hook.URL, bytes.NewReader(body))
+	if err != nil {
+		return
+	}
+
+	request.Header.Set("Content-Type", "application/json")
+	for key, value := range hook.Headers {
+		request.Header.Set(key, value)
+	}
+
+	// Send the request
+	response, err := webhookclient.Do(request)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+			"url":   hook.URL,
+		}).Debugln("Failed to Deliver Webhook!")
+		return
+	}
+	response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		logrus.WithFields(logrus.Fields{
+			"status": response.Status,
+			"url":    hook.URL,
+		}).Debugln("Webhook Endpoint Rejected Delivery!")
+	}
+}
@@ -0,0 +1,189 @@
+package src
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/libp2p/go-libp2p-core/crypto"
+	"github.com/libp2p/go-libp2p-core/peer"
+)
+
+// Represents the placeholder text of whispers, shown by clients that
+// do not support whispers and published instead of the whispered text
+const whisperplaceholder = "(whisper)"
+
+// Represents the label of the RSA-OAEP wrapping of whisper keys
+var whisperlabel = []byte("peerchat-whisper")
+
+// A structure that represents the encrypted payload of a whisper, a message
+// published on the room topic that only some members of the room can read.
+// The text is encrypted with a random key, which is encrypted to the
+// identity key of each recipient.
+type whisper struct {
+	// Represents the encrypted key of the text for each recipient peer ID
+	Keys map[string][]byte `json:"keys"`
+	// Represents the encrypted text
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// A function that returns the RSA public key of a peer from the peerstore.
+// Whispers can only be sent to peers whose keys are known and are RSA keys.
+func (p2p *P2P) whisperkey(peerid peer.ID) (*rsa.PublicKey, error) {
+	pubkey := p2p.Host.Peerstore().PubKey(peerid)
+	if pubkey == nil {
+		return nil, fmt.Errorf("the key of %s is not known", peerid.ShortString())
+	}
+
+	stdkey, err := crypto.PubKeyToStdKey(pubkey)
+	if err != nil {
+		return nil, err
+	}
+
+	rsakey, ok := stdkey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("the key of %s does not support whispers", peerid.ShortString())
+	}
+
+	return rsakey, nil
+}
+
+// A method of P2P that encrypts a text as a whisper to a set of recipients
+func (p2p *P2P) sealwhisper(recipients []peer.ID, text string) (*whisper, error) {
+	if len(recipients) == 0 {
+		return nil, errors.New("a whisper needs recipients")
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+
+	// Encrypt the text with the random key
+	aead, err := keycipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	payload := &whisper{
+		Keys:       make(map[string][]byte, len(recipients)),
+		Ciphertext: aead.Seal(nonce, nonce, []byte(text), nil),
+	}
+
+	for _, recipient := range recipients {
+		pubkey, err := p2p.whisperkey(recipient)
+		if err != nil {
+			return nil, err
+		}
+
+		wrapped, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pubkey, key, whisperlabel)
+		if err != nil {
+			return nil, err
+		}
+
+		payload.Keys[recipient.Pretty()] = wrapped
+	}
+
+	return payload, nil
+}
+
+// A method of P2P that decrypts a whisper if the host is one of its recipients
+func (p2p *P2P) openwhisper(payload *whisper) (string, bool) {
+	self := p2p.Host.ID()
+
+	wrapped, ok := payload.Keys[self.Pretty()]
+	if !ok {
+		return "", false
+	}
+
+	stdkey, err := crypto.PrivKeyToStdKey(p2p.Host.Peerstore().PrivKey(self))
+	if err != nil {
+		return "", false
+	}
+
+	prvkey, ok := stdkey.(*rsa.PrivateKey)
+	if !ok {
+		return "", false
+	}
+
+	key, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, prvkey, wrapped, whisperlabel)
+	if err != nil {
+		return "", false
+	}
+
+	aead, err := keycipher(key)
+	if err != nil || len(payload.Ciphertext) < aead.NonceSize() {
+		return "", false
+	}
+
+	nonce, ciphertext := payload.Ciphertext[:aead.NonceSize()], payload.Ciphertext[aead.NonceSize():]
+	text, err := aead.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", false
+	}
+
+	return string(text), true
+}
+
+// A method of ChatRoom that publishes a whisper to some members of the room.
+// The whisper is published on the room topic like any other message, but
+// only its recipients can read it.
+func (cr *ChatRoom) Whisper(recipients []peer.ID, text string) error {
+	if cr.Host == nil {
+		return errors.New("whispers are not supported when attached to a daemon")
+	}
+	if len(text) > chunklength {
+		return fmt.Errorf("whispers are limited to %d bytes", chunklength)
+	}
+
+	payload, err := cr.Host.sealwhisper(recipients, text)
+	if err != nil {
+		return err
+	}
+
+	return cr.publish(ChatMessage{
+		Message:    whisperplaceholder,
+		SenderID:   cr.selfid.Pretty(),
+		SenderName: cr.UserName,
+		Whisper:    payload,
+	})
+}
+
+// A method of ChatRoom that opens a received whisper. Recipients receive its
+// text, while the other members only learn that a whisper was sent.
+func (cr *ChatRoom) openwhisper(msg *ChatMessage) {
+	if cr.Host == nil {
+		return
+	}
+
+	msg.Message = ""
+	if text, ok := cr.Host.openwhisper(msg.Whisper); ok {
+		msg.Message = text
+		msg.Whispered = true
+	}
+}
+
+// A method of UI that displays a whisper. Recipients see its text in magenta,
+// while the other members only see that a whisper was sent.
+func (ui *UI) display_whisper(msg ChatMessage) {
+	if !msg.Whispered {
+		fmt.Fprintf(ui.messageBox, "[gray]%s whispered to %d members[-]\n", sanitize(msg.SenderName), len(msg.Whisper.Keys))
+		return
+	}
+
+	fmt.Fprintf(ui.messageBox, "[magenta]<%s whispers>:[-] %s\n", sanitize(msg.SenderName), rendermessage(msg.Message))
+	ui.notifier.Notify(ui.RoomName, fmt.Sprintf("%s whispered to you in %s", msg.SenderName, ui.RoomName), msg.Message)
+}
+
+// A method of UI that displays a whisper sent by the user
+func (ui *UI) display_selfwhisper(names []string, msg string) {
+	fmt.Fprintf(ui.messageBox, "[magenta]<%s whispers to %s>:[-] %s\n", sanitize(ui.UserName), sanitize(strings.Join(names, ", ")), rendermessage(msg))
+}
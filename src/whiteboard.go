@@ -0,0 +1,427 @@
+package src
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gdamore/tcell/v2"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the size of the whiteboard of a room, in characters
+const (
+	boardwidth  = 80
+	boardheight = 24
+)
+
+// Represents the number of cells of the whiteboard sent in a single message
+const boardbatch = 200
+
+// A structure that represents a cell of the whiteboard of a room. Each cell
+// is a last-writer-wins register of a character, versioned with a Lamport
+// stamp like the lines of the notes, so merging the cells of peers in any
+// order converges. A space clears the cell.
+type boardcell struct {
+	X    int    `json:"x"`
+	Y    int    `json:"y"`
+	Char string `json:"char"`
+	// Represents the version the character was drawn at
+	Version noteid `json:"version"`
+}
+
+// A structure that represents a message on the whiteboard topic of a room, which
+// carries cells of the whiteboard, or asks the peers for all the cells they have
+type boardmessage struct {
+	Cells []boardcell `json:"cells,omitempty"`
+	Sync  bool        `json:"sync,omitempty"`
+}
+
+// A structure that represents the whiteboard of a room, a grid of characters
+// that is synchronized with the peers of the room over a dedicated topic
+type roomboard struct {
+	// Represents the whiteboard topic of the room and the subscription to it
+	topic Topic
+	sub   Subscription
+	// Represents the drawn cells by position
+	cells map[[2]int]boardcell
+	// Represents the Lamport clock of the whiteboard
+	clock uint64
+	// Represents the function called when the peers change the whiteboard (nil if not shown)
+	onchange func()
+	// Represents the lock on the cells
+	lock sync.Mutex
+	// Represents the guard that leaves the whiteboard topic once
+	once sync.Once
+}
+
+// A function that returns the PubSub topic name of the whiteboard of a chat
+// room, which sits next to the topic of the room and is hashed in the same way
+func boardtopic(roomname string) string {
+	prefix := strings.Replace(topicprefix(), "/room/", "/board/", 1)
+	if hashingtopics() {
+		return prefix + "h-" + hashroom(roomname)
+	}
+
+	return prefix + roomname
+}
+
+// A function that checks that a cell is on the whiteboard and holds a printable ASCII character
+func validcell(cell boardcell) bool {
+	if cell.X < 0 || cell.X >= boardwidth || cell.Y < 0 || cell.Y >= boardheight {
+		return false
+	}
+
+	return len(cell.Char) == 1 && cell.Char[0] >= ' ' && cell.Char[0] <= '~' && cell.Version.Peer != ""
+}
+
+// A method of roomboard that merges a cell into the whiteboard. Returns
+// whether the whiteboard changed. Must be called with the lock held.
+func (board *roomboard) merge(cell boardcell) bool {
+	if cell.Version.Clock > board.clock {
+		board.clock = cell.Version.Clock
+	}
+
+	position := [2]int{cell.X, cell.Y}
+	if current, ok := board.cells[position]; ok && !cell.Version.newer(current.Version) {
+		return false
+	}
+
+	board.cells[position] = cell
+	return true
+}
+
+// A method of roomboard that returns the character of a cell, which is a space if it was never drawn
+func (board *roomboard) char(x, y int) rune {
+	board.lock.Lock()
+	defer board.lock.Unlock()
+
+	if cell, ok := board.cells[[2]int{x, y}]; ok {
+		return rune(cell.Char[0])
+	}
+
+	return ' '
+}
+
+// A method of ChatRoom that joins the whiteboard topic of the room the first time
+// the whiteboard is opened, and asks the peers for their whiteboard. The topic is
+// only joined on demand, so rooms that nobody draws in carry no extra topic.
+func (cr *ChatRoom) joinboard() error {
+	if cr.daemon != nil || cr.offline || cr.psctx.Err() != nil {
+		return errors.New("the whiteboard is not available in this room")
+	}
+
+	var err error
+	cr.boardonce.Do(func() {
+		name := boardtopic(cr.RoomName)
+
+		var topic Topic
+		if topic, err = cr.Host.JoinTopic(name); err != nil {
+			return
+		}
+
+		var sub Subscription
+		if sub, err = topic.Subscribe(); err != nil {
+			cr.Host.LeaveTopic(name)
+			return
+		}
+
+		cr.boardlock.Lock()
+		cr.board = &roomboard{topic: topic, sub: sub, cells: make(map[[2]int]boardcell)}
+		cr.boardlock.Unlock()
+
+		go cr.boardloop()
+		go cr.syncboard()
+	})
+
+	if err != nil {
+		return fmt.Errorf("failed to join the whiteboard - %s", err)
+	}
+	if cr.whiteboard() == nil {
+		return errors.New("the whiteboard is not available in this room")
+	}
+
+	return nil
+}
+
+// A method of ChatRoom that returns the whiteboard of the room (nil if it has not been joined)
+func (cr *ChatRoom) whiteboard() *roomboard {
+	cr.boardlock.Lock()
+	defer cr.boardlock.Unlock()
+
+	return cr.board
+}
+
+// A method of ChatRoom that asks the peers of the room for their whiteboard once
+// the whiteboard topic has peers, giving up if it has none for a while
+func (cr *ChatRoom) syncboard() {
+	ticker := time.NewTicker(pendinginterval)
+	defer ticker.Stop()
+	timeout := time.After(notessynctimeout)
+
+	for {
+		select {
+		case <-cr.psctx.Done():
+			return
+		case <-timeout:
+			return
+		case <-ticker.C:
+			if len(cr.board.topic.ListPeers()) == 0 {
+				continue
+			}
+
+			cr.publishboard(boardmessage{Sync: true})
+			return
+		}
+	}
+}
+
+// A method of ChatRoom that publishes a message on the whiteboard topic of the room
+func (cr *ChatRoom) publishboard(msg boardmessage) error {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+
+	return cr.board.topic.Publish(cr.psctx, data)
+}
+
+// A method of ChatRoom that publishes all the cells of the whiteboard of the room in batches
+func (cr *ChatRoom) sendboard() {
+	cr.board.lock.Lock()
+	cells := make([]boardcell, 0, len(cr.board.cells))
+	for _, cell := range cr.board.cells {
+		cells = append(cells, cell)
+	}
+	cr.board.lock.Unlock()
+
+	for start := 0; start < len(cells); start += boardbatch {
+		end := start + boardbatch
+		if end > len(cells) {
+			end = len(cells)
+		}
+
+		if err := cr.publishboard(boardmessage{Cells: cells[start:end]}); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  cr.RoomName,
+			}).Debugln("Failed to Send the Whiteboard of the Room!")
+			return
+		}
+	}
+}
+
+// A method of ChatRoom that reads the whiteboard topic of the room until the
+// subscription or the room is closed, merging the cells of the peers into the
+// whiteboard and sending the whiteboard to the peers that ask for it
+func (cr *ChatRoom) boardloop() {
+	for {
+		message, err := cr.board.sub.Next(cr.psctx)
+		if err != nil {
+			return
+		}
+		if message.ReceivedFrom == cr.selfid {
+			continue
+		}
+
+		var msg boardmessage
+		if err := json.Unmarshal(message.Data, &msg); err != nil {
+			continue
+		}
+
+		if msg.Sync {
+			go cr.sendboard()
+		}
+
+		changed := false
+		cr.board.lock.Lock()
+		for _, cell := range msg.Cells {
+			if validcell(cell) {
+				changed = cr.board.merge(cell) || changed
+			}
+		}
+		onchange := cr.board.onchange
+		cr.board.lock.Unlock()
+
+		if changed && onchange != nil {
+			onchange()
+		}
+	}
+}
+
+// A method of ChatRoom that draws characters on the whiteboard of the room and
+// publishes them to the peers. A space clears a cell.
+func (cr *ChatRoom) Draw(cells ...boardcell) error {
+	if cr.whiteboard() == nil {
+		return errors.New("the whiteboard is not available in this room")
+	}
+
+	cr.board.lock.Lock()
+	for index := range cells {
+		cr.board.clock++
+		cells[index].Version = noteid{Clock: cr.board.clock, Peer: cr.selfid.Pretty()}
+		if !validcell(cells[index]) {
+			cr.board.lock.Unlock()
+			return fmt.Errorf("cannot draw '%s' at %d,%d", cells[index].Char, cells[index].X, cells[index].Y)
+		}
+		cr.board.merge(cells[index])
+	}
+	cr.board.lock.Unlock()
+
+	for start := 0; start < len(cells); start += boardbatch {
+		end := start + boardbatch
+		if end > len(cells) {
+			end = len(cells)
+		}
+
+		if err := cr.publishboard(boardmessage{Cells: cells[start:end]}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// A method of ChatRoom that clears the whiteboard of the room for every peer
+func (cr *ChatRoom) ClearBoard() error {
+	if err := cr.joinboard(); err != nil {
+		return err
+	}
+
+	cr.board.lock.Lock()
+	var cells []boardcell
+	for _, cell := range cr.board.cells {
+		if cell.Char != " " {
+			cells = append(cells, boardcell{X: cell.X, Y: cell.Y, Char: " "})
+		}
+	}
+	cr.board.lock.Unlock()
+
+	return cr.Draw(cells...)
+}
+
+// A method of ChatRoom that leaves the whiteboard topic of the room, if it was joined
+func (cr *ChatRoom) leaveboard() {
+	board := cr.whiteboard()
+	if board == nil {
+		return
+	}
+
+	board.once.Do(func() {
+		board.sub.Cancel()
+		cr.Host.LeaveTopic(board.topic.String())
+	})
+}
+
+// A method of UI that shows the whiteboard of a room to draw on, which must
+// have been joined. The arrow keys move the cursor, printable characters are
+// drawn at the cursor, Backspace and Delete clear a cell and Esc closes the
+// whiteboard. Must be called from the tview event loop.
+func (ui *UI) showboard(room *ChatRoom) {
+	board := room.whiteboard()
+	cursorx, cursory := 0, 0
+
+	view := tview.NewBox()
+	view.SetBorder(true)
+	title := func() {
+		view.SetTitle(fmt.Sprintf("Whiteboard of %s (%d,%d) (arrows to move, type to draw, Esc to close)", tview.Escape(room.RoomName), cursorx, cursory))
+	}
+	title()
+
+	view.SetDrawFunc(func(screen tcell.Screen, x, y, width, height int) (int, int, int, int) {
+		for row := 0; row < boardheight && row < height-2; row++ {
+			for column := 0; column < boardwidth && column < width-2; column++ {
+				style := tcell.StyleDefault
+				if column == cursorx && row == cursory {
+					style = style.Reverse(true)
+				}
+				screen.SetContent(x+1+column, y+1+row, board.char(column, row), nil, style)
+			}
+		}
+
+		return x + 1, y + 1, width - 2, height - 2
+	})
+
+	draw := func(x, y int, char string) {
+		go func() {
+			if err := room.Draw(boardcell{X: x, Y: y, Char: char}); err != nil {
+				ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "draw", logmsg: err.Error()}
+			}
+			ui.TerminalApp.QueueUpdateDraw(func() {})
+		}()
+	}
+
+	view.SetInputCapture(func(event *tcell.EventKey) *tcell.EventKey {
+		switch event.Key() {
+		case tcell.KeyEscape:
+			board.lock.Lock()
+			board.onchange = nil
+			board.lock.Unlock()
+
+			ui.pages.RemovePage("board")
+			ui.TerminalApp.SetFocus(ui.inputBox)
+			return nil
+
+		case tcell.KeyLeft:
+			if cursorx > 0 {
+				cursorx--
+			}
+		case tcell.KeyRight:
+			if cursorx < boardwidth-1 {
+				cursorx++
+			}
+		case tcell.KeyUp:
+			if cursory > 0 {
+				cursory--
+			}
+		case tcell.KeyDown:
+			if cursory < boardheight-1 {
+				cursory++
+			}
+
+		case tcell.KeyBackspace, tcell.KeyBackspace2:
+			if cursorx > 0 {
+				cursorx--
+			}
+			draw(cursorx, cursory, " ")
+		case tcell.KeyDelete:
+			draw(cursorx, cursory, " ")
+
+		case tcell.KeyRune:
+			if event.Rune() < ' ' || event.Rune() > '~' {
+				return nil
+			}
+			draw(cursorx, cursory, string(event.Rune()))
+			if cursorx < boardwidth-1 {
+				cursorx++
+			}
+
+		default:
+			return nil
+		}
+
+		title()
+		return nil
+	})
+
+	// Redraw the whiteboard when the peers draw on it
+	board.lock.Lock()
+	board.onchange = func() { ui.TerminalApp.QueueUpdateDraw(func() {}) }
+	board.lock.Unlock()
+
+	// Center the whiteboard on the screen
+	popup := tview.NewFlex().
+		AddItem(nil, 0, 1, false).
+		AddItem(tview.NewFlex().SetDirection(tview.FlexRow).
+			AddItem(nil, 0, 1, false).
+			AddItem(view, boardheight+2, 1, true).
+			AddItem(nil, 0, 1, false), boardwidth+2, 1, true).
+		AddItem(nil, 0, 1, false)
+
+	ui.pages.AddPage("board", popup, true, true)
+	ui.TerminalApp.SetFocus(view)
+}
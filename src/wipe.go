@@ -0,0 +1,246 @@
+package src
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/libp2p/go-libp2p-core/peer"
+	"github.com/rivo/tview"
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the target of the wipe command that wipes the data of every room
+const wipeall = "all"
+
+// A function that overwrites a file with random bytes and syncs it to the disk
+// before removing it, so that its contents are not simply left on the disk
+func shredfile(path string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return err
+	}
+
+	info, err := file.Stat()
+	if err == nil {
+		_, err = io.CopyN(file, rand.Reader, info.Size())
+	}
+	if err == nil {
+		err = file.Sync()
+	}
+	file.Close()
+
+	if err != nil {
+		return err
+	}
+
+	return os.Remove(path)
+}
+
+// A function that shreds a file, or every file within a directory before removing
+// it. Returns the number of files shredded. A path that does not exist is ignored.
+func shred(path string) (int, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	if !info.IsDir() {
+		return 1, shredfile(path)
+	}
+
+	count := 0
+	err = filepath.Walk(path, func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+
+		count++
+		return shredfile(file)
+	})
+	if err != nil {
+		return count, err
+	}
+
+	return count, os.RemoveAll(path)
+}
+
+// A method of P2P that forgets the retrieved user profiles of peers and shreds
+// their cached avatars, or of every peer if none are given. Returns the number
+// of files shredded.
+func (p2p *P2P) forgetprofiles(peerids ...peer.ID) (int, error) {
+	p2p.profile.mutex.Lock()
+	var forgotten []*UserProfile
+	if len(peerids) == 0 {
+		for peerid, profile := range p2p.profile.known {
+			forgotten = append(forgotten, profile)
+			delete(p2p.profile.known, peerid)
+		}
+	} else {
+		for _, peerid := range peerids {
+			if profile, ok := p2p.profile.known[peerid]; ok {
+				forgotten = append(forgotten, profile)
+				delete(p2p.profile.known, peerid)
+			}
+		}
+	}
+	p2p.profile.mutex.Unlock()
+
+	count := 0
+	for _, profile := range forgotten {
+		if profile == nil || profile.Avatar == "" {
+			continue
+		}
+
+		shredded, err := shred(avatarcachepath(profile.Avatar))
+		count += shredded
+		if err != nil {
+			return count, err
+		}
+	}
+
+	return count, nil
+}
+
+// A method of UI that wipes the local data of a room, or of every room if the
+// target is 'all', for machines that are shared or compromised. The history, the
+// transcripts and the local copy of the notes of the room are shredded, and for
+// the current room also the pinned keys and the cached profiles of its members.
+// Wiping every room shreds the whole history, the transcripts, the notes, the
+// pinned keys and the cached profiles.
+// Returns the number of files shredded. The history held in memory by a daemon
+// is not wiped, which must be restarted for that.
+func (ui *UI) wipe(target string) (int, error) {
+	if target == wipeall {
+		return ui.wipeall()
+	}
+
+	count := 0
+	transcripts.close(target)
+
+	// Remove the room from the history if there is one
+	if _, err := os.Stat(historydir()); err == nil {
+		store := ui.dmhistory
+		if store == nil {
+			if store, err = openhistory(); err != nil {
+				return count, err
+			}
+		}
+
+		shredded, err := store.forget(target)
+		count += shredded
+		if err != nil {
+			return count, err
+		}
+	}
+
+	for _, path := range []string{filepath.Join(currentconfig().transcriptsdir(), transcriptname(target)), notespath(target)} {
+		shredded, err := shred(path)
+		count += shredded
+		if err != nil {
+			return count, err
+		}
+	}
+	if target != ui.RoomName {
+		return count, nil
+	}
+
+	// Forget the pinned keys and the profiles of the members of the current room
+	peerids := ui.PeerList()
+	names := make([]string, 0, len(peerids))
+	ui.stateLock.Lock()
+	for _, peerid := range peerids {
+		if name, ok := ui.nicks[peerid]; ok {
+			names = append(names, name)
+		}
+	}
+	ui.stateLock.Unlock()
+
+	if len(names) > 0 {
+		pins.forget(names...)
+	}
+
+	if ui.Host != nil && len(peerids) > 0 {
+		shredded, err := ui.Host.forgetprofiles(peerids...)
+		count += shredded
+		return count, err
+	}
+
+	return count, nil
+}
+
+// A method of UI that wipes the local data of every room
+func (ui *UI) wipeall() (int, error) {
+	count := 0
+	transcripts.closeall()
+
+	paths := []string{historydir(), currentconfig().transcriptsdir(), pinspath(), peerchatpath("avatars"), peerchatpath("notes")}
+	for _, path := range paths {
+		shredded, err := shred(path)
+		count += shredded
+		if err != nil {
+			return count, err
+		}
+	}
+
+	pins.reset()
+
+	// The history is written with a new salt from now on
+	if ui.dmhistory != nil {
+		ui.dmhistory = nil
+		if store, err := openhistory(); err == nil {
+			ui.dmhistory = store
+		}
+	}
+
+	if ui.Host != nil {
+		shredded, err := ui.Host.forgetprofiles()
+		count += shredded
+		return count, err
+	}
+
+	return count, nil
+}
+
+// A method of UI that displays a modal asking the user to confirm wiping
+// the local data of a room or of every room, and wipes it if confirmed.
+// Must be called from the tview event loop.
+func (ui *UI) confirmwipe(target string) {
+	question := fmt.Sprintf("Do you want to wipe the history, the transcripts, the pinned keys and the cached profiles of '%s' from this machine? This cannot be undone.", target)
+	if target == wipeall {
+		question = "Do you want to wipe the history, the transcripts, the pinned keys and the cached profiles of every room from this machine? This cannot be undone."
+	}
+
+	modal := tview.NewModal().
+		SetText(question).
+		AddButtons([]string{"Wipe", "Cancel"}).
+		SetDoneFunc(func(index int, label string) {
+			ui.pages.RemovePage("wipe")
+			ui.TerminalApp.SetFocus(ui.inputBox)
+
+			if label != "Wipe" {
+				return
+			}
+
+			go func() {
+				count, err := ui.wipe(target)
+				if err != nil {
+					ui.Logs <- chatlog{loglevel: logrus.ErrorLevel, logprefix: "wipe", logmsg: fmt.Sprintf("failed to wipe '%s' after shredding %d files - %s", target, count, err)}
+					return
+				}
+
+				if target == wipeall || target == ui.RoomName {
+					ui.TerminalApp.QueueUpdateDraw(ui.messageBox.Clear)
+				}
+				ui.Logs <- chatlog{loglevel: logrus.InfoLevel, logprefix: "wipe", logmsg: fmt.Sprintf("wiped '%s', %d files were shredded", target, count)}
+			}()
+		})
+
+	ui.pages.AddPage("wipe", modal, false, true)
+	ui.TerminalApp.SetFocus(modal)
+}
@@ -0,0 +1,437 @@
+package src
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Represents the interval of the whitespace keepalives sent to an XMPP server
+const xmppkeepalive = time.Second * 60
+
+// Represents the suffix added to the names of XMPP occupants in a chat room
+const xmppnamesuffix = "@xmpp"
+
+// Represents the XML namespaces of the XMPP protocol
+const (
+	xmppnsstream = "http://etherx.jabber.org/streams"
+	xmppnstls    = "urn:ietf:params:xml:ns:xmpp-tls"
+	xmppnssasl   = "urn:ietf:params:xml:ns:xmpp-sasl"
+	xmppnsbind   = "urn:ietf:params:xml:ns:xmpp-bind"
+	xmppnsmuc    = "http://jabber.org/protocol/muc"
+)
+
+// A structure that represents a bridge that maps a chat room to an XMPP
+// multi-user chat (MUC). Room messages are relayed to the MUC as '<name> text'
+// and MUC messages are published into the room with the nickname of the
+// occupant suffixed by '@xmpp'. Joins and parts are announced on both sides.
+type xmppbridge struct {
+	// Represents the chat room that is bridged
+	Room string `json:"room"`
+	// Represents the JID of the bridge account (user@domain)
+	JID string `json:"jid"`
+	// Represents the password of the bridge account
+	Password string `json:"password"`
+	// Represents the address of the XMPP server (the domain of the JID on port 5222 if empty)
+	Server string `json:"server"`
+	// Represents whether to connect with direct TLS instead of STARTTLS
+	DirectTLS bool `json:"directtls"`
+	// Represents the JID of the MUC that is bridged (room@conference.domain)
+	MUC string `json:"muc"`
+	// Represents the nickname of the bridge in the MUC and its name in the chat room
+	Nick string `json:"nick"`
+
+	// Represents the chat room side of the bridge
+	room *bridgeroom
+	// Represents the current connection to the XMPP server
+	conn net.Conn
+	// Represents the lock on the connection
+	mutex sync.Mutex
+}
+
+// A structure that represents the stream features of an XMPP server
+type xmppfeatures struct {
+	XMLName    xml.Name  `xml:"http://etherx.jabber.org/streams features"`
+	StartTLS   *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-tls starttls"`
+	Mechanisms []string  `xml:"urn:ietf:params:xml:ns:xmpp-sasl mechanisms>mechanism"`
+	Bind       *struct{} `xml:"urn:ietf:params:xml:ns:xmpp-bind bind"`
+}
+
+// A structure that represents an XMPP message stanza
+type xmppmessage struct {
+	From  string    `xml:"from,attr"`
+	Type  string    `xml:"type,attr"`
+	Body  string    `xml:"body"`
+	Delay *struct{} `xml:"urn:xmpp:delay delay"`
+}
+
+// A structure that represents an XMPP presence stanza
+type xmpppresence struct {
+	From string `xml:"from,attr"`
+	Type string `xml:"type,attr"`
+}
+
+// A structure that represents an XMPP iq stanza
+type xmppiq struct {
+	ID   string    `xml:"id,attr"`
+	From string    `xml:"from,attr"`
+	Type string    `xml:"type,attr"`
+	Ping *struct{} `xml:"urn:xmpp:ping ping"`
+}
+
+// A method of xmppbridge that joins the chat room and starts relaying
+func (bridge *xmppbridge) start(p2phost *P2P) error {
+	if bridge.JID == "" || bridge.Password == "" || bridge.MUC == "" || bridge.Nick == "" {
+		return errors.New("xmpp bridge requires a jid, password, muc and nick")
+	}
+
+	if !strings.Contains(bridge.JID, "@") {
+		return errors.New("xmpp bridge jid must be of the form user@domain")
+	}
+
+	// Join the chat room with the nick of the bridge
+	room, err := joinbridgeroom(p2phost, bridge.Nick, bridge.Room)
+	if err != nil {
+		return err
+	}
+	bridge.room = room
+
+	// Announce the peers joining and leaving the room
+	err = room.presence(func(name string, joined bool) {
+		verb := "left"
+		if joined {
+			verb = "joined"
+		}
+
+		bridge.groupchat(fmt.Sprintf("* %s %s %s", name, verb, room.chatroom.RoomName))
+	})
+	if err != nil {
+		room.leave()
+		return err
+	}
+
+	// Relay the room messages to the MUC
+	go room.relay(func(msg ChatMessage) {
+		bridge.groupchat(fmt.Sprintf("<%s> %s", msg.SenderName, msg.Message))
+	})
+
+	go bridge.xmpploop()
+	return nil
+}
+
+// A method of xmppbridge that stops relaying, closes the XMPP stream and exits the chat room
+func (bridge *xmppbridge) stop() {
+	bridge.room.cancel()
+
+	bridge.mutex.Lock()
+	if bridge.conn != nil {
+		fmt.Fprint(bridge.conn, "</stream:stream>")
+		bridge.conn.Close()
+	}
+	bridge.mutex.Unlock()
+
+	bridge.room.leave()
+}
+
+// A method of xmppbridge that keeps a connection to the XMPP
+// server and reconnects to it until the bridge is stopped
+func (bridge *xmppbridge) xmpploop() {
+	for {
+		if err := bridge.session(); err != nil && bridge.room.ctx.Err() == nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"jid":   bridge.JID,
+			}).Warnln("XMPP Bridge Disconnected!")
+		}
+
+		select {
+		case <-bridge.room.ctx.Done():
+			return
+		case <-time.After(bridgereconnect):
+		}
+	}
+}
+
+// A method of xmppbridge that connects and authenticates to the XMPP server,
+// joins the MUC and handles its stanzas until the connection is closed
+func (bridge *xmppbridge) session() error {
+	parts := strings.SplitN(bridge.JID, "@", 2)
+	user, domain := parts[0], parts[1]
+
+	server := bridge.Server
+	if server == "" {
+		server = net.JoinHostPort(domain, "5222")
+	}
+
+	// Connect to the XMPP server
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: time.Second * 30}
+	if bridge.DirectTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", server, &tls.Config{ServerName: domain})
+	} else {
+		conn, err = dialer.Dial("tcp", server)
+	}
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// Negotiate STARTTLS unless the connection is already encrypted
+	decoder, features, err := xmppopen(conn, domain)
+	if err != nil {
+		return err
+	}
+
+	if !bridge.DirectTLS {
+		if features.StartTLS == nil {
+			return errors.New("server does not offer STARTTLS")
+		}
+
+		fmt.Fprintf(conn, "<starttls xmlns='%s'/>", xmppnstls)
+		if name, err := xmppnext(decoder); err != nil || name.Local != "proceed" {
+			return errors.New("server refused STARTTLS")
+		}
+
+		tlsconn := tls.Client(conn, &tls.Config{ServerName: domain})
+		if err := tlsconn.Handshake(); err != nil {
+			return err
+		}
+		conn = tlsconn
+		defer conn.Close()
+
+		if decoder, features, err = xmppopen(conn, domain); err != nil {
+			return err
+		}
+	}
+
+	// Authenticate with SASL PLAIN
+	plain := false
+	for _, mechanism := range features.Mechanisms {
+		plain = plain || mechanism == "PLAIN"
+	}
+	if !plain {
+		return errors.New("server does not offer SASL PLAIN")
+	}
+
+	credentials := base64.StdEncoding.EncodeToString([]byte("\x00" + user + "\x00" + bridge.Password))
+	fmt.Fprintf(conn, "<auth xmlns='%s' mechanism='PLAIN'>%s</auth>", xmppnssasl, credentials)
+	if name, err := xmppnext(decoder); err != nil || name.Local != "success" {
+		return errors.New("authentication failed")
+	}
+
+	// Restart the stream and bind a resource
+	if decoder, _, err = xmppopen(conn, domain); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(conn, "<iq type='set' id='bind'><bind xmlns='%s'><resource>peerchat</resource></bind></iq>", xmppnsbind)
+	if name, err := xmppnext(decoder); err != nil || name.Local != "iq" {
+		return errors.New("resource binding failed")
+	}
+
+	bridge.mutex.Lock()
+	bridge.conn = conn
+	bridge.mutex.Unlock()
+
+	defer func() {
+		bridge.mutex.Lock()
+		bridge.conn = nil
+		bridge.mutex.Unlock()
+	}()
+
+	// Announce availability and join the MUC without its history
+	bridge.send("<presence/>")
+	bridge.send(fmt.Sprintf("<presence to='%s'><x xmlns='%s'><history maxstanzas='0'/></x></presence>", xmlescape(bridge.MUC+"/"+bridge.Nick), xmppnsmuc))
+
+	logrus.WithFields(logrus.Fields{
+		"jid":  bridge.JID,
+		"muc":  bridge.MUC,
+		"room": bridge.room.chatroom.RoomName,
+	}).Infoln("XMPP Bridge Connected")
+
+	// Send whitespace keepalives in the background
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(xmppkeepalive)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				bridge.send(" ")
+			}
+		}
+	}()
+
+	// Track the occupants of the MUC to announce joins and parts
+	occupants := make(map[string]bool)
+	joined := false
+
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+
+		start, ok := token.(xml.StartElement)
+		if !ok {
+			continue
+		}
+
+		switch start.Name.Local {
+		case "message":
+			var message xmppmessage
+			if err := decoder.DecodeElement(&message, &start); err != nil {
+				return err
+			}
+
+			nick, ok := bridge.occupant(message.From)
+			if !ok || message.Type != "groupchat" || message.Body == "" || message.Delay != nil || nick == bridge.Nick {
+				continue
+			}
+
+			bridge.room.publish(nick+xmppnamesuffix, message.Body)
+
+		case "presence":
+			var presence xmpppresence
+			if err := decoder.DecodeElement(&presence, &start); err != nil {
+				return err
+			}
+
+			nick, ok := bridge.occupant(presence.From)
+			if !ok {
+				continue
+			}
+
+			// The presence of the bridge itself comes after the
+			// occupants present when joining, which are not announced
+			if nick == bridge.Nick {
+				joined = true
+				continue
+			}
+
+			switch {
+			case presence.Type == "unavailable" && occupants[nick]:
+				delete(occupants, nick)
+				bridge.room.publish(bridge.Nick, fmt.Sprintf("* %s left %s", nick+xmppnamesuffix, bridge.MUC))
+			case presence.Type == "" && !occupants[nick]:
+				occupants[nick] = true
+				if joined {
+					bridge.room.publish(bridge.Nick, fmt.Sprintf("* %s joined %s", nick+xmppnamesuffix, bridge.MUC))
+				}
+			}
+
+		case "iq":
+			var iq xmppiq
+			if err := decoder.DecodeElement(&iq, &start); err != nil {
+				return err
+			}
+
+			// Answer pings from the server and the MUC
+			if iq.Type == "get" && iq.Ping != nil {
+				bridge.send(fmt.Sprintf("<iq type='result' id='%s' to='%s'/>", xmlescape(iq.ID), xmlescape(iq.From)))
+			}
+
+		default:
+			if err := decoder.Skip(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// A method of xmppbridge that returns the nickname of the occupant
+// of the MUC that a JID (room@conference.domain/nick) refers to
+func (bridge *xmppbridge) occupant(jid string) (string, bool) {
+	parts := strings.SplitN(jid, "/", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], bridge.MUC) {
+		return "", false
+	}
+
+	return parts[1], true
+}
+
+// A method of xmppbridge that sends a message to the MUC
+func (bridge *xmppbridge) groupchat(text string) {
+	bridge.send(fmt.Sprintf("<message to='%s' type='groupchat'><body>%s</body></message>", xmlescape(bridge.MUC), xmlescape(text)))
+}
+
+// A method of xmppbridge that writes a raw stanza to the XMPP
+// server. The stanza is dropped if the bridge is disconnected.
+func (bridge *xmppbridge) send(stanza string) {
+	bridge.mutex.Lock()
+	defer bridge.mutex.Unlock()
+
+	if bridge.conn == nil {
+		return
+	}
+
+	io.WriteString(bridge.conn, stanza)
+}
+
+// A function that opens an XMPP stream on a connection and
+// returns the decoder of the stream and the features of the server
+func xmppopen(conn net.Conn, domain string) (*xml.Decoder, *xmppfeatures, error) {
+	fmt.Fprintf(conn, "<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' xmlns:stream='%s' version='1.0'>", xmlescape(domain), xmppnsstream)
+
+	decoder := xml.NewDecoder(conn)
+
+	// Wait for the stream header of the server
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			if start.Name.Space != xmppnsstream || start.Name.Local != "stream" {
+				return nil, nil, errors.New("unexpected stream header")
+			}
+			break
+		}
+	}
+
+	// Decode the stream features
+	features := &xmppfeatures{}
+	if err := decoder.Decode(features); err != nil {
+		return nil, nil, err
+	}
+
+	return decoder, features, nil
+}
+
+// A function that reads and skips the next element of an XMPP stream
+// and returns its name (used for the replies of the stream negotiation)
+func xmppnext(decoder *xml.Decoder) (xml.Name, error) {
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return xml.Name{}, err
+		}
+
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name, decoder.Skip()
+		}
+	}
+}
+
+// A function that escapes a text for use in XML
+func xmlescape(text string) string {
+	var buffer bytes.Buffer
+	xml.EscapeText(&buffer, []byte(text))
+	return buffer.String()
+}
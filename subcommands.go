@@ -0,0 +1,802 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/manishmeganathan/peerchat/src"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/term"
+)
+
+// A function that runs the chat subcommand, which joins
+// a chat room with the terminal, plain or headless interface
+func chatcommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("chat")
+	username := flags.String("user", "", "username to use in the chatroom.")
+	var chatrooms roomsflag
+	flags.Var(&chatrooms, "room", "chatroom to join (repeatable, the first is opened and the others listed as tabs).")
+	restore := flags.Bool("restore", true, "restore the rooms of the last session if no rooms are given.")
+	node := newnodeflags(flags)
+	palette := flags.String("palette", "", "color palette for peer nicks ('default' or 'colorblind').")
+	notify := flags.String("notify", "bell", "notification methods for mentions ('bell', 'term', 'desktop').")
+	plain := flags.Bool("plain", false, "use the plain line-by-line interface (for screen readers).")
+	headless := flags.Bool("headless", false, "run without a UI, using JSON lines on stdin/stdout (for bots).")
+	inhook := flags.String("inhook", "", "address of the local endpoint for incoming webhooks (e.g. 127.0.0.1:8787).")
+	inhooktoken := flags.String("inhooktoken", os.Getenv("PEERCHAT_INHOOK_TOKEN"), "token required by the incoming webhook endpoint.")
+	socket := flags.String("socket", "", "unix socket of the daemon control API (default ~/.peerchat/daemon.sock).")
+	attach := flags.Bool("attach", false, "run the UI as a thin client of a running daemon.")
+	previews := flags.String("previews", "", "link previews ('off', 'direct' or 'sender' to ask the sender of the link).")
+
+	config := setup(flags, common, args)
+
+	// Determine the rooms to join
+	rooms := launchrooms(chatrooms, config, *restore)
+	chatroom := ""
+	if len(rooms) > 0 {
+		chatroom = rooms[0]
+	}
+
+	// Keep stdout clean for the JSON lines of the headless interface
+	if *headless {
+		if logfile == nil {
+			logrus.SetOutput(os.Stderr)
+		}
+	} else if *plain || *attach {
+		// Display the welcome figlet (skipped for the plain interface)
+		if !*plain {
+			fmt.Println(figlet)
+		}
+		fmt.Println("The PeerChat Application is starting.")
+		fmt.Println("This may take upto 30 seconds.")
+		fmt.Println()
+	}
+
+	// Attach the interface to a running daemon if requested
+	if *attach {
+		runattached(*socket, rooms, *palette, *notify, *plain, *headless)
+		return
+	}
+
+	// Open the chat UI right away and start the node in the background.
+	// The UI shows the progress of the startup and goes online once the
+	// chat room is joined.
+	if !*headless && !*plain {
+		// Keep the logs off the terminal while the UI is drawn
+		if logfile == nil {
+			logtofile(src.DefaultLogPath(), src.DefaultLogMaxSize, src.DefaultLogBackups)
+		}
+
+		offline := src.NewOfflineChatRoom(*username, chatroom)
+		started := make(chan *src.P2P, 1)
+		go func() {
+			p2phost := startchat(config, node, *previews, *inhook, *inhooktoken)
+			started <- p2phost
+
+			chatapp, err := offline.GoOnline(context.Background(), p2phost)
+			if err != nil {
+				logrus.WithFields(logrus.Fields{
+					"error": err.Error(),
+				}).Fatalln("Failed to Join the Chat Room!")
+			}
+			logrus.Infof("Joined the '%s' chatroom as '%s'", chatapp.RoomName, chatapp.UserName)
+		}()
+
+		// Run the Chat UI
+		runui(offline, rooms, *palette, *notify)
+
+		// Shutdown the application if the node has started
+		select {
+		case p2phost := <-started:
+			shutdown(p2phost)
+		default:
+		}
+	} else {
+		p2phost := startchat(config, node, *previews, *inhook, *inhooktoken)
+
+		// Join the chat room
+		chatapp, err := src.JoinChatRoom(context.Background(), p2phost, *username, chatroom)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Join the Chat Room!")
+		}
+		logrus.Infof("Joined the '%s' chatroom as '%s'", chatapp.RoomName, chatapp.UserName)
+
+		// Wait for network setup to complete
+		time.Sleep(time.Second * 5)
+
+		if *headless {
+			// Start the headless interface
+			src.NewHeadless(chatapp).Run()
+		} else {
+			// Start the plain interface
+			src.NewPlainUI(chatapp).Run()
+		}
+
+		// Shutdown the application
+		shutdown(p2phost)
+	}
+
+	// Restart with another profile if one was requested with /profile
+	if name, ok := src.ProfileSwitch(); ok {
+		restart(name, args)
+	}
+}
+
+// A function that starts the node of the chat subcommand, sets its link
+// previews, loads the plugins, scripts, webhooks and bridges and starts
+// the incoming webhook endpoint if requested
+func startchat(config *src.Config, node *nodeflags, previews, inhook, inhooktoken string) *src.P2P {
+	p2phost := startnode(config, node, false)
+
+	// Set the mode of link previews
+	if err := p2phost.SetPreviews(previews); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Set the Link Previews!")
+	}
+
+	// Load the plugins, scripts, webhooks and bridges
+	src.LoadPlugins()
+	src.LoadScripts()
+	src.LoadWebhooks()
+	src.LoadBridges(p2phost)
+
+	// Start the incoming webhook endpoint if requested
+	startinhook(p2phost, inhook, inhooktoken)
+
+	return p2phost
+}
+
+// A function that runs the daemon subcommand, which keeps the node
+// online without a UI and serves the control API, REST API and web UI
+func daemoncommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("daemon")
+	username := flags.String("user", "", "username to use in the chatrooms.")
+	var chatrooms roomsflag
+	flags.Var(&chatrooms, "room", "chatroom to join (repeatable).")
+	node := newnodeflags(flags)
+	socket := flags.String("socket", "", "unix socket of the daemon control API (default ~/.peerchat/daemon.sock).")
+	api := flags.String("api", "", "address to serve the REST API on (e.g. 127.0.0.1:8788).")
+	web := flags.String("web", "", "address to serve the web UI on (e.g. 127.0.0.1:8789).")
+	apitoken := flags.String("apitoken", os.Getenv("PEERCHAT_API_TOKEN"), "token required by the REST API and the web UI (optional on loopback addresses).")
+	inhook := flags.String("inhook", "", "address of the local endpoint for incoming webhooks (e.g. 127.0.0.1:8787).")
+	inhooktoken := flags.String("inhooktoken", os.Getenv("PEERCHAT_INHOOK_TOKEN"), "token required by the incoming webhook endpoint.")
+
+	config := setup(flags, common, args)
+
+	p2phost := startnode(config, node, false)
+
+	// Load the plugins, scripts, webhooks and bridges
+	src.LoadPlugins()
+	src.LoadScripts()
+	src.LoadWebhooks()
+	src.LoadBridges(p2phost)
+
+	// Start the incoming webhook endpoint if requested
+	startinhook(p2phost, *inhook, *inhooktoken)
+
+	rungateway(p2phost, gatewayconfig{
+		username: *username,
+		rooms:    launchrooms(chatrooms, config, false),
+		apiaddr:  *api,
+		webaddr:  *web,
+		token:    *apitoken,
+		daemon:   true,
+		socket:   *socket,
+	})
+
+	shutdown(p2phost)
+}
+
+// A function that runs the relay subcommand, which runs a node that
+// relays connections for peers that cannot be reached directly
+func relaycommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("relay")
+	node := newnodeflags(flags)
+	tracker := flags.Bool("tracker", false, "also serve as a tracker for the tracker discovery.")
+
+	config := setup(flags, common, args)
+
+	p2phost := startnode(config, node, true)
+
+	// Let peers register and discover each other at the relay
+	if *tracker {
+		p2phost.ServeTracker()
+		logrus.Infoln("Serving as a Tracker")
+	}
+
+	// Log the addresses of the relay so that peers can be pointed to it
+	for _, addr := range p2phost.Host.Addrs() {
+		logrus.WithFields(logrus.Fields{
+			"peer":    p2phost.Host.ID().Pretty(),
+			"address": addr.String(),
+		}).Infof("Relaying on %s/p2p/%s", addr, p2phost.Host.ID().Pretty())
+	}
+
+	waitforsignal()
+	shutdown(p2phost)
+}
+
+// A function that runs the keygen subcommand, which
+// generates the identity key of the selected profile
+func keygencommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("keygen")
+	force := flags.Bool("force", false, "replace an existing identity key (changes the peer ID).")
+
+	setup(flags, common, args)
+
+	peerid, err := src.GenerateIdentity(*force)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Generate the Identity Key!")
+	}
+
+	fmt.Println(peerid)
+}
+
+// A function that runs the key subcommand, which exports the identity key of
+// a profile with its pinned keys and friends into a password-encrypted file,
+// or imports such a file, to move the identity between machines
+func keycommand(args []string) {
+	if len(args) == 0 || (args[0] != "export" && args[0] != "import") {
+		fmt.Fprintln(os.Stderr, "Usage: peerchat key <export|import> [flags]")
+		os.Exit(2)
+	}
+	action, args := args[0], args[1:]
+
+	// Define the flags of the command
+	flags, common := newflagset("key " + action)
+	file := flags.String("file", "", "encrypted backup file to write or read.")
+	force := flags.Bool("force", false, "replace a different existing identity key on import (changes the peer ID).")
+
+	setup(flags, common, args)
+
+	if *file == "" {
+		logrus.Fatalln("The Backup File is Required!")
+	}
+
+	switch action {
+	case "export":
+		password := readpassword("Password for the backup: ", true)
+
+		data, err := src.ExportIdentity(password)
+		if err == nil {
+			err = ioutil.WriteFile(*file, data, 0600)
+		}
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Export the Identity Key!")
+		}
+
+		fmt.Printf("Exported the identity key to %s\n", *file)
+
+	case "import":
+		data, err := ioutil.ReadFile(*file)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Read the Backup File!")
+		}
+
+		password := readpassword("Password of the backup: ", false)
+
+		peerid, err := src.ImportIdentity(data, password, *force)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Import the Identity Key!")
+		}
+
+		fmt.Println(peerid)
+	}
+}
+
+// A function that returns the password of a key backup, which is read from
+// the PEERCHAT_KEY_PASSWORD environment variable if it is set, else from the
+// terminal without echoing it (twice if confirm is set)
+func readpassword(prompt string, confirm bool) string {
+	if password := os.Getenv("PEERCHAT_KEY_PASSWORD"); password != "" {
+		return password
+	}
+
+	read := func(prompt string) string {
+		fmt.Fprint(os.Stderr, prompt)
+		password, err := term.ReadPassword(int(os.Stdin.Fd()))
+		fmt.Fprintln(os.Stderr)
+
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Read the Password!")
+		}
+
+		return string(password)
+	}
+
+	password := read(prompt)
+	if password == "" {
+		logrus.Fatalln("The Password is Required!")
+	}
+
+	if confirm && read("Repeat the password: ") != password {
+		logrus.Fatalln("The Passwords do not Match!")
+	}
+
+	return password
+}
+
+// A function that runs the bots subcommand, which runs the plugins,
+// scripts, webhooks and bridges of the profile in its rooms without a UI
+func botscommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("bots")
+	username := flags.String("user", "", "username of the bots in the chatrooms.")
+	var chatrooms roomsflag
+	flags.Var(&chatrooms, "room", "chatroom to join (repeatable).")
+	node := newnodeflags(flags)
+	inhook := flags.String("inhook", "", "address of the local endpoint for incoming webhooks (e.g. 127.0.0.1:8787).")
+	inhooktoken := flags.String("inhooktoken", os.Getenv("PEERCHAT_INHOOK_TOKEN"), "token required by the incoming webhook endpoint.")
+
+	config := setup(flags, common, args)
+
+	p2phost := startnode(config, node, false)
+
+	// Load the plugins, scripts, webhooks and bridges
+	src.LoadPlugins()
+	scripts := src.LoadScripts()
+	webhooks := src.LoadWebhooks()
+	bridges := src.LoadBridges(p2phost)
+	logrus.Infof("Loaded %d Scripts, %d Webhooks and %d Bridges", scripts, webhooks, bridges)
+
+	// Start the incoming webhook endpoint if requested
+	startinhook(p2phost, *inhook, *inhooktoken)
+
+	// Join the rooms so that the scripts and webhooks see their messages
+	rungateway(p2phost, gatewayconfig{
+		username: *username,
+		rooms:    launchrooms(chatrooms, config, false),
+	})
+
+	shutdown(p2phost)
+}
+
+// A function that runs the version subcommand
+func versioncommand(args []string) {
+	for _, line := range src.BuildInfo() {
+		fmt.Println(line)
+	}
+}
+
+// A function that runs the export subcommand, which writes the
+// history of a room kept by a running daemon to stdout
+func exportcommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("export")
+	chatroom := flags.String("room", "", "chatroom to export.")
+	socket := flags.String("socket", "", "unix socket of the daemon control API (default ~/.peerchat/daemon.sock).")
+	limit := flags.Int("limit", 0, "number of most recent messages to export (all if 0).")
+	format := flags.String("format", "text", "output format ('text' or 'json').")
+
+	setup(flags, common, args)
+
+	if *chatroom == "" {
+		logrus.Fatalln("The Room to Export is Required!")
+	}
+
+	// Connect to the daemon
+	client, err := src.DialDaemon(*socket)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Connect to the Daemon!")
+	}
+	defer client.Close()
+
+	// Retrieve the history of the room
+	history, err := client.History(*chatroom, *limit)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Export the Room!")
+	}
+
+	switch *format {
+	case "json":
+		encoder := json.NewEncoder(os.Stdout)
+		for _, msg := range history {
+			encoder.Encode(msg)
+		}
+
+	default:
+		for _, msg := range history {
+			fmt.Println(src.ExportLine(msg))
+		}
+	}
+}
+
+// A function that runs the import subcommand, which loads transcripts or the
+// history exported from another machine into the history of the rooms
+func importcommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("import")
+	chatroom := flags.String("room", "", "chatroom to import into (required unless the messages are JSON, which carry their room).")
+	format := flags.String("format", "auto", "format of the files ('auto', 'json', 'text' or 'transcript').")
+
+	setup(flags, common, args)
+
+	if flags.NArg() == 0 {
+		logrus.Fatalln("The Files to Import are Required!")
+	}
+	if !src.HistoryEnabled() {
+		logrus.Warnln("The History is not Persisted (history.persist), the Imported Messages are only Shown once it is.")
+	}
+
+	// Import each file or directory of files
+	for _, path := range flags.Args() {
+		result, err := src.ImportHistory(path, *chatroom, *format)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"path":  path,
+			}).Fatalln("Failed to Import the History!")
+		}
+
+		for room, count := range result.Imported {
+			fmt.Printf("Imported %d messages into '%s' from %s\n", count, room, path)
+		}
+		if result.Duplicates > 0 || result.Skipped > 0 {
+			fmt.Printf("Skipped %d messages already in the history and %d unreadable lines from %s\n", result.Duplicates, result.Skipped, path)
+		}
+	}
+}
+
+// A function that runs the simulate subcommand, which runs many nodes in the
+// process on an in-memory network, joins them to rooms and generates traffic
+// to exercise discovery, ordering and the UI without internet access
+func simulatecommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("simulate")
+	nodes := flags.Int("n", 20, "number of simulated nodes.")
+	var chatrooms roomsflag
+	flags.Var(&chatrooms, "room", "chatroom that every node joins (repeatable, default lobby).")
+	degree := flags.Int("degree", 3, "number of nodes each node is connected to before discovery.")
+	latency := flags.Duration("latency", 20*time.Millisecond, "latency of the links between the nodes.")
+	interval := flags.Duration("interval", 2*time.Second, "time between the messages of each node in each room.")
+	duration := flags.Duration("duration", 0, "time to generate traffic for (until interrupted if 0).")
+	withui := flags.Bool("ui", false, "open the first room of the first node in the chat UI.")
+
+	// Keep the data written by the simulated nodes out of the default profile
+	*common.profile = "simulate"
+	setup(flags, common, args)
+
+	sim, err := src.NewSimulation(context.Background(), src.SimulationConfig{
+		Nodes:    *nodes,
+		Rooms:    chatrooms,
+		Degree:   *degree,
+		Latency:  *latency,
+		Interval: *interval,
+		Observe:  *withui,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Create the Simulation!")
+	}
+	defer sim.Close()
+	logrus.Infof("Created %d Simulated Nodes", *nodes)
+
+	sim.Discover()
+	if err := sim.Join(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Failed to Join a Simulated Node to a Room!")
+	}
+	logrus.Infoln("Started the Simulated Traffic")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		ctx, cancel = context.WithTimeout(ctx, *duration)
+		defer cancel()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		sim.Run(ctx)
+		close(done)
+	}()
+
+	if observer := sim.Observer(); observer != nil {
+		// Generate the traffic until the UI exits
+		runui(observer, chatrooms, "", "bell")
+		cancel()
+	} else {
+		// Print a report every few seconds until the traffic stops or is interrupted
+		go func() {
+			waitforsignal()
+			cancel()
+		}()
+
+		ticker := time.NewTicker(10 * time.Second)
+		defer ticker.Stop()
+
+	reporting:
+		for {
+			select {
+			case <-ticker.C:
+				for _, line := range sim.Report() {
+					logrus.Infoln(line)
+				}
+			case <-ctx.Done():
+				break reporting
+			}
+		}
+	}
+
+	<-done
+	for _, line := range sim.Report() {
+		fmt.Println(line)
+	}
+}
+
+// A function that runs the bench subcommand, which floods a room on an
+// in-memory network from synthetic senders at a configured rate and size,
+// and reports the delivery latency percentiles and the drop rate
+func benchcommand(args []string) {
+	// Define the flags of the command
+	flags, common := newflagset("bench")
+	senders := flags.Int("senders", 5, "number of synthetic senders.")
+	readers := flags.Int("readers", 5, "number of nodes that only read the messages.")
+	rate := flags.Float64("rate", 10, "messages per second of each sender.")
+	size := flags.Int("size", 256, "size of the messages in bytes.")
+	duration := flags.Duration("duration", 30*time.Second, "time to flood the room for.")
+	latency := flags.Duration("latency", 0, "latency of the links between the nodes.")
+	chatroom := flags.String("room", "bench", "chatroom to flood.")
+	drain := flags.Duration("drain", 5*time.Second, "time to wait for the messages in flight after the flood.")
+
+	// Keep the data written by the synthetic nodes out of the default profile
+	*common.profile = "simulate"
+	setup(flags, common, args)
+
+	if *rate <= 0 || *senders < 1 {
+		logrus.Fatalln("The Rate and the Number of Senders must be Positive!")
+	}
+
+	nodes := *senders + *readers
+	sim, err := src.NewSimulation(context.Background(), src.SimulationConfig{
+		Nodes:    nodes,
+		Rooms:    []string{*chatroom},
+		Degree:   nodes - 1,
+		Latency:  *latency,
+		Interval: time.Duration(float64(time.Second) / *rate),
+		Senders:  *senders,
+		Size:     *size,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Create the Benchmark Nodes!")
+	}
+	defer sim.Close()
+
+	if err := sim.Join(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Join a Benchmark Node to the Room!")
+	}
+	if !sim.Settle(30 * time.Second) {
+		logrus.Warnln("The Room Mesh did not Settle, Results may Include Drops at Startup")
+	}
+	logrus.Infof("Flooding '%s' from %d Senders for %s", *chatroom, *senders, *duration)
+
+	ctx, cancel := context.WithTimeout(context.Background(), *duration)
+	defer cancel()
+
+	started := time.Now()
+	sim.Run(ctx)
+	elapsed := time.Since(started)
+
+	// Let the messages in flight arrive before reporting
+	time.Sleep(*drain)
+
+	for _, line := range sim.Benchmark(elapsed) {
+		fmt.Println(line)
+	}
+}
+
+// A function that restarts the chat subcommand with a profile,
+// keeping all the other arguments of the command
+func restart(profile string, args []string) {
+	executable, err := os.Executable()
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Restart with the Profile!")
+	}
+
+	// Replace the profile flag in the arguments
+	argv := []string{os.Args[0], "chat", "-profile", profile}
+	for i := 0; i < len(args); i++ {
+		name := strings.TrimLeft(args[i], "-")
+		switch {
+		case !strings.HasPrefix(args[i], "-"):
+			argv = append(argv, args[i])
+		case name == "profile":
+			i++
+		case strings.HasPrefix(name, "profile="):
+		default:
+			argv = append(argv, args[i])
+		}
+	}
+
+	fmt.Printf("Switching to the '%s' profile.\n", profile)
+	if err := syscall.Exec(executable, argv, os.Environ()); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Restart with the Profile!")
+	}
+}
+
+// A function that creates and runs the terminal UI for a chat room,
+// listing the other rooms to join at launch as tabs
+func runui(chatapp *src.ChatRoom, rooms []string, palette, notify string) {
+	// Keep the logs off the terminal while the UI is drawn,
+	// since they would otherwise be printed over the UI
+	if logfile == nil {
+		logtofile(src.DefaultLogPath(), src.DefaultLogMaxSize, src.DefaultLogBackups)
+	}
+
+	// Create the Chat UI
+	ui := src.NewUI(chatapp)
+	// Restore the terminal before exiting on a fatal error
+	logrus.RegisterExitHandler(ui.TerminalApp.Stop)
+	// List the other rooms as tabs
+	ui.AddRooms(rooms...)
+	// Set the nick color palette
+	ui.SetPalette(palette)
+	// Set the notification methods
+	ui.SetNotifications(notify)
+	// Start the UI system
+	if err := ui.Run(); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Errorln("Chat UI Failed!")
+	}
+}
+
+// A function that runs an interface as a thin client of a running daemon,
+// so that the node stays online in its rooms while the interface restarts
+func runattached(socket string, rooms []string, palette, notify string, plain, headless bool) {
+	// Connect to the daemon
+	client, err := src.DialDaemon(socket)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Attach to the Daemon!")
+	}
+	defer client.Close()
+
+	// Join the first chat room on the daemon
+	chatroom := ""
+	if len(rooms) > 0 {
+		chatroom = rooms[0]
+	}
+
+	chatapp, err := src.JoinDaemonChatRoom(context.Background(), client, chatroom)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Join the Chat Room!")
+	}
+	logrus.Infof("Attached to the '%s' chatroom as '%s'", chatapp.RoomName, chatapp.UserName)
+
+	switch {
+	case headless:
+		src.NewHeadless(chatapp).Run()
+	case plain:
+		src.NewPlainUI(chatapp).Run()
+	default:
+		runui(chatapp, rooms, palette, notify)
+	}
+}
+
+// A structure that represents the configuration of a gateway run
+type gatewayconfig struct {
+	// Represents the user name
+	username string
+	// Represents the chat rooms to join, the first of which receives
+	// the messages of scripts (the default room if empty)
+	rooms []string
+	// Represents the addresses of the REST API and the web UI (optional)
+	apiaddr, webaddr string
+	// Represents the token of the REST API and the web UI (optional on loopback addresses)
+	token string
+	// Represents whether to serve the daemon control API and on which socket
+	daemon bool
+	socket string
+}
+
+// A function that runs the daemon, REST API and web gateway with an
+// initial chat room until the process is interrupted or terminated
+func rungateway(p2phost *src.P2P, config gatewayconfig) {
+	// Create the gateway and join the initial chat room
+	gateway := src.NewGateway(p2phost, config.username)
+	defer gateway.Close()
+
+	initial := ""
+	if len(config.rooms) > 0 {
+		initial = config.rooms[0]
+	}
+
+	if err := gateway.Join(initial); err != nil {
+		logrus.WithFields(logrus.Fields{
+			"error": err.Error(),
+		}).Fatalln("Failed to Join the Chat Room!")
+	}
+
+	// Publish the messages sent by scripts to the initial chat room
+	initial = gateway.Rooms()[0]
+	src.SetScriptSender(func(text string) {
+		gateway.Send(initial, text)
+	})
+
+	// Join the other chat rooms
+	for _, room := range config.rooms {
+		if err := gateway.Join(room); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+				"room":  room,
+			}).Warnln("Failed to Join the Chat Room!")
+		}
+	}
+
+	// Serve the daemon control API
+	if config.daemon {
+		server, err := src.ServeDaemon(gateway, config.socket)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Start the Daemon Control API!")
+		}
+		defer server.Stop()
+		logrus.Infoln("Serving the Daemon Control API")
+	}
+
+	// Serve the REST API
+	if config.apiaddr != "" {
+		if err := src.ServeAPI(gateway, config.apiaddr, config.token); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Start the REST API!")
+		}
+		logrus.Infof("Serving the REST API on %s", config.apiaddr)
+	}
+
+	// Serve the web UI
+	if config.webaddr != "" {
+		if err := src.ServeWeb(gateway, config.webaddr, config.token); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err.Error(),
+			}).Fatalln("Failed to Start the Web UI!")
+		}
+		logrus.Infof("Serving the Web UI on http://%s", config.webaddr)
+	}
+
+	// Wait for an interrupt or termination signal
+	waitforsignal()
+}